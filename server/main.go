@@ -23,8 +23,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/ts2/ts2-sim-server/plugins/lines"
 	_ "github.com/ts2/ts2-sim-server/plugins/points"
@@ -40,19 +45,106 @@ var logger log.Logger
 
 func main() {
 	// Command line arguments
+	configFile := flag.String("config", os.Getenv("TS2_CONFIG"), "A TOML configuration file with the listen address, tokens, CORS policy, KPI windows, audit capacity and suggestion tuning of the server. It is read again on SIGHUP. Settings can be overridden by TS2_* environment variables, and by the flags of this command line. Defaults to the TS2_CONFIG environment variable.")
 	port := flag.String("port", server.DefaultPort, "The port on which the server will listen")
 	addr := flag.String("addr", server.DefaultAddr, "The address on which the server will listen. Set to 0.0.0.0 to listen on all addresses.")
 	logFile := flag.String("logfile", "", "The filename in which to save the logs. If not specified, the logs are sent to stderr.")
+	logRotation := server.LogRotation{}
+	logMaxSize := flag.Int("logfile-max-size", 0, "The size in megabytes above which -logfile is rotated. Set to 0 to not rotate on size.")
+	flag.DurationVar(&logRotation.Interval, "logfile-rotate", 0, "The time after which -logfile is rotated, e.g. 24h. Set to 0 to not rotate on time.")
+	flag.DurationVar(&logRotation.MaxAge, "logfile-max-age", 0, "The time after which rotated log files are deleted, e.g. 720h. Set to 0 to keep them.")
+	flag.IntVar(&logRotation.MaxBackups, "logfile-max-backups", 0, "The number of rotated log files kept. Set to 0 to keep them all.")
 	logLevel := flag.String("loglevel", "info", "The minimum level of log to be written. Possible values are 'crit', 'error', 'warn', 'info' and 'debug'.")
+	logLevels := flag.String("loglevels", "", "Comma separated minimum levels of the logs of some modules, overriding -loglevel, e.g. hub=debug,http=warn. Modules are hub, http, server, simulation and suggestions. Levels can be changed at runtime with /api/admin/log-levels.")
+	logFormat := flag.String("logformat", "", "The format of the logs: 'terminal', 'logfmt' or 'json'. Defaults to 'terminal' on stdout and 'logfmt' in -logfile.")
 	version := flag.Bool("version", false, "Display version and exit.")
+	tlsCert := flag.String("tls-cert", "", "The PEM certificate file. If set with -tls-key, the server is served over HTTPS/WSS.")
+	tlsKey := flag.String("tls-key", "", "The PEM private key file of the TLS certificate.")
+	tlsReload := flag.Duration("tls-reload", 0, "If set, check the TLS certificate and key files for changes at this interval (e.g. 1h) and reload them without restarting.")
+	coalesce := flag.Duration("coalesce", 0, "If set, merge trainChanged and trackItemChanged notifications of the same object sent within this interval (e.g. 200ms). Clients can override it with 'coalesceMs' when registering.")
+	rateLimit := flag.Float64("rate-limit", 50, "The maximum number of requests per second of each websocket client. Set to 0 to disable rate limiting.")
+	rateBurst := flag.Int("rate-burst", 100, "The number of requests a websocket client may send at once above -rate-limit.")
+	compression := flag.Int("ws-compression", 1, "The deflate level, from 1 (fastest) to 9 (smallest), of the websocket messages sent to clients that support compression. Set to 0 to disable compression.")
+	idleTimeout := flag.Duration("ws-idle-timeout", 60*time.Second, "Disconnect websocket clients that neither sent a message nor answered a ping within this time. Clients are pinged at 9/10 of this interval. Set to 0 to disable pings and idle timeouts.")
+	maxClients := flag.Int("max-clients", 0, "The maximum number of websocket clients connected at once to all the simulations. Further connections are refused with a 503 status. Set to 0 for no limit.")
+	timeFactor := flag.Int("time-factor", 0, "The time factor, from 1 to 10, with which the simulations start. If not set, the time factor of each simulation file is used.")
+	suggestionInterval := flag.Duration("suggestion-interval", 0, "The time in whole minutes (e.g. 2m) between two automatic recomputations of the suggestions of each simulation. Takes precedence over the simulation files and the configuration file.")
+	kpiInterval := flag.Duration("kpi-interval", time.Minute, "The time between two KPI snapshots of each simulation, from 10s to 10m. The last 1440 snapshots are kept for the KPI history.")
+	auditCapacity := flag.Int("audit-capacity", 1000, "The number of entries kept in the audit log of each simulation. Takes precedence over the configuration file.")
+	checkpointDir := flag.String("checkpoint-dir", "checkpoints", "The directory in which simulation checkpoints are saved, in a sub-directory per simulation.")
+	geoTransform := flag.String("geojson-transform", "", "Affine transform 'a,b,c,d,e,f' applied to layout coordinates in the GeoJSON export (x' = a*x + b*y + c, y' = d*x + e*y + f).")
+	mqttConfig := server.DefaultMQTTConfig()
+	mqttBroker := flag.String("mqtt-broker", "", "The URL of an MQTT broker (e.g. tcp://localhost:1883 or ssl://broker:8883) to which simulation events, KPIs and suggestions are published. MQTT is disabled if not set.")
+	flag.StringVar(&mqttConfig.ClientID, "mqtt-client-id", mqttConfig.ClientID, "The MQTT client ID.")
+	flag.StringVar(&mqttConfig.Username, "mqtt-username", "", "The username to connect to the MQTT broker.")
+	flag.StringVar(&mqttConfig.Password, "mqtt-password", os.Getenv("TS2_MQTT_PASSWORD"), "The password to connect to the MQTT broker. Defaults to the TS2_MQTT_PASSWORD environment variable.")
+	flag.DurationVar(&mqttConfig.KeepAlive, "mqtt-keepalive", mqttConfig.KeepAlive, "The MQTT keep alive interval.")
+	flag.StringVar(&mqttConfig.EventsTopic, "mqtt-events-topic", mqttConfig.EventsTopic, "The topic of simulation events. {simulation} is replaced by the simulation ID and {event} by the event name. Set to empty to not publish events.")
+	flag.StringVar(&mqttConfig.KPITopic, "mqtt-kpi-topic", mqttConfig.KPITopic, "The topic of the KPI snapshots. Set to empty to not publish KPIs.")
+	flag.StringVar(&mqttConfig.SuggestionsTopic, "mqtt-suggestions-topic", mqttConfig.SuggestionsTopic, "The topic of the suggestions. Set to empty to not publish suggestions.")
+	mqttEvents := flag.String("mqtt-events", "", "Comma separated names of the events published on -mqtt-events-topic (e.g. trainStoppedAtStation,signalAspectChanged). All events are published if not set.")
+	flag.BoolVar(&mqttConfig.Retain, "mqtt-retain", false, "Retain the KPI and suggestions messages on the broker, so that new subscribers get the last ones at once.")
+	kafkaConfig := server.DefaultKafkaConfig()
+	kafkaBrokers := flag.String("kafka-brokers", "", "Comma separated host:port addresses of Kafka brokers to which the event journal and the audit entries are streamed. Kafka is disabled if not set.")
+	flag.StringVar(&kafkaConfig.ClientID, "kafka-client-id", kafkaConfig.ClientID, "The Kafka client ID.")
+	flag.StringVar(&kafkaConfig.EventsTopic, "kafka-events-topic", kafkaConfig.EventsTopic, "The Kafka topic of the event journal. Set to empty to not stream events.")
+	flag.StringVar(&kafkaConfig.AuditTopic, "kafka-audit-topic", kafkaConfig.AuditTopic, "The Kafka topic of the audit entries. Set to empty to not stream audit entries.")
+	flag.StringVar(&kafkaConfig.Format, "kafka-format", kafkaConfig.Format, "The serialization of the Kafka record values, 'json' or 'msgpack'.")
+	flag.IntVar(&kafkaConfig.BufferSize, "kafka-buffer", kafkaConfig.BufferSize, "The maximum number of records waiting to be acknowledged by Kafka. Newer records are dropped when it is reached.")
+	telemetryConfig := server.DefaultTelemetryConfig()
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		telemetryConfig.ServiceName = name
+	}
+	otlpEndpoint := flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "The base URL of an OpenTelemetry OTLP/HTTP receiver (e.g. http://localhost:4318) to which traces and metrics are exported. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable. Telemetry is disabled if not set.")
+	otlpHeaders := flag.String("otlp-headers", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), "Comma separated key=value headers of the OTLP export requests. Defaults to the OTEL_EXPORTER_OTLP_HEADERS environment variable.")
+	flag.StringVar(&telemetryConfig.ServiceName, "otlp-service-name", telemetryConfig.ServiceName, "The service name of the exported telemetry. Defaults to the OTEL_SERVICE_NAME environment variable or ts2-sim-server.")
+	flag.Float64Var(&telemetryConfig.SampleRatio, "otlp-sample-ratio", telemetryConfig.SampleRatio, "The share of the traces that are exported, between 0 and 1. Metrics always include all requests.")
+	flag.DurationVar(&telemetryConfig.MetricsInterval, "otlp-metrics-interval", telemetryConfig.MetricsInterval, "The interval between two exports of the metrics.")
+	timetableFeedConfig := server.DefaultTimetableFeedConfig()
+	flag.StringVar(&timetableFeedConfig.URL, "timetable-feed-url", "", "The URL of an external timetable update feed, polled for delay predictions, cancellations and platform changes. Polling is disabled if not set.")
+	flag.DurationVar(&timetableFeedConfig.Interval, "timetable-feed-interval", timetableFeedConfig.Interval, "The interval between two polls of the timetable feed.")
+	timetableFeedHeaders := flag.String("timetable-feed-headers", os.Getenv("TS2_TIMETABLE_FEED_HEADERS"), "Comma separated key=value headers of the timetable feed requests, e.g. for authentication. Defaults to the TS2_TIMETABLE_FEED_HEADERS environment variable.")
+	remoteWriteConfig := server.DefaultRemoteWriteConfig()
+	flag.StringVar(&remoteWriteConfig.URL, "remote-write-url", "", "The Prometheus remote-write endpoint to push KPI and runtime metrics to, e.g. http://prometheus:9090/api/v1/write. Metrics are not pushed if not set.")
+	flag.DurationVar(&remoteWriteConfig.Interval, "remote-write-interval", remoteWriteConfig.Interval, "The interval between two pushes of metrics.")
+	remoteWriteHeaders := flag.String("remote-write-headers", os.Getenv("TS2_REMOTE_WRITE_HEADERS"), "Comma separated key=value headers of the remote-write requests, e.g. for authentication. Defaults to the TS2_REMOTE_WRITE_HEADERS environment variable.")
+	remoteWriteLabels := flag.String("remote-write-labels", "", "Comma separated key=value labels added to all pushed series, e.g. cluster=prod.")
+	debugToken := flag.String("debug-token", os.Getenv("TS2_DEBUG_TOKEN"), "The token that admin users must give in the X-Debug-Token header to use the pprof and runtime diagnostics endpoints. The endpoints are disabled if not set. Defaults to the TS2_DEBUG_TOKEN environment variable.")
+	backupConfig := server.DefaultBackupConfig()
+	flag.StringVar(&backupConfig.Bucket, "backup-bucket", "", "The S3 bucket to which checkpoints, KPI history and audit logs of the simulations are periodically backed up. Backups are disabled if not set.")
+	flag.StringVar(&backupConfig.Endpoint, "backup-endpoint", "https://s3.amazonaws.com", "The URL of the S3-compatible storage service (e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000).")
+	flag.StringVar(&backupConfig.Region, "backup-region", backupConfig.Region, "The region of the backup bucket.")
+	flag.StringVar(&backupConfig.AccessKey, "backup-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "The access key of the backup storage. Defaults to the AWS_ACCESS_KEY_ID environment variable.")
+	flag.StringVar(&backupConfig.SecretKey, "backup-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "The secret key of the backup storage. Defaults to the AWS_SECRET_ACCESS_KEY environment variable.")
+	flag.BoolVar(&backupConfig.PathStyle, "backup-path-style", false, "Address the bucket in the URL path instead of the host name, as most self-hosted storage services require.")
+	flag.StringVar(&backupConfig.Prefix, "backup-prefix", backupConfig.Prefix, "The prefix of the keys of the backed up objects.")
+	flag.DurationVar(&backupConfig.Interval, "backup-interval", backupConfig.Interval, "The interval between two backups.")
+	flag.DurationVar(&backupConfig.Retention, "backup-retention", backupConfig.Retention, "The age after which backed up objects are deleted. Set to 0 to keep them forever.")
+	flag.IntVar(&backupConfig.KeepLast, "backup-keep", backupConfig.KeepLast, "The number of most recent objects of each kind and simulation kept whatever the retention.")
+	scoringRules := flag.String("scoring-rules", "", "A JSON file with the points given to dispatchers during scored sessions, e.g. {\"spad\": 200, \"freeOverrides\": 0}. Rules not in the file keep their default value.")
+	stressConfig := server.DefaultStressConfig()
+	stressClients := flag.Int("stress-clients", 0, "If set, drive the server with this number of synthetic websocket clients for -stress-duration, print the report as JSON on stdout and exit. The exit status is 1 if clients failed or commands were unanswered or refused.")
+	stressURL := flag.String("stress-url", "", "The websocket URL (e.g. ws://host:22222/ws) of the server to stress with -stress-clients. If not set, the simulation files are loaded and served as usual, and the server stresses itself.")
+	flag.StringVar(&stressConfig.Token, "stress-token", os.Getenv("TS2_CLIENT_TOKEN"), "The register token of the clients of -stress-url: the token of a user with the operator role, or the client token to only observe. Defaults to the TS2_CLIENT_TOKEN environment variable.")
+	flag.DurationVar(&stressConfig.Duration, "stress-duration", stressConfig.Duration, "The time during which the synthetic clients send commands, after -stress-ramp-up.")
+	flag.DurationVar(&stressConfig.RampUp, "stress-ramp-up", stressConfig.RampUp, "The time over which the synthetic clients connect.")
+	flag.DurationVar(&stressConfig.Interval, "stress-interval", stressConfig.Interval, "The time between two commands of each synthetic client.")
+	stressScript := flag.String("stress-script", "", "A JSON file with the list of commands (e.g. [{\"object\": \"train\", \"action\": \"list\"}]) sent in turn by each synthetic client. Defaults to reading trains, routes, track items and suggestions, and recomputing suggestions.")
+	stressTrains := flag.Int("stress-trains", 0, "If set, duplicate the trains of the default simulation until it has this many, to stress the server with a large simulation.")
+	flag.BoolVar(&stressConfig.StartSimulation, "stress-start", stressConfig.StartSimulation, "Start the simulation when the stress test begins.")
+	suggestionWebhookURL := flag.String("suggestion-webhook-url", "", "The http(s) URL to which each generated, accepted, dismissed and overridden suggestion is POSTed. The suggestion webhook is disabled if not set.")
+	suggestionWebhookSecret := flag.String("suggestion-webhook-secret", os.Getenv("TS2_SUGGESTION_WEBHOOK_SECRET"), "The secret with which suggestion webhook payloads are signed. Defaults to the TS2_SUGGESTION_WEBHOOK_SECRET environment variable.")
+	suggestionWebhookDecisions := flag.String("suggestion-webhook-decisions", "", "Comma separated decisions POSTed to -suggestion-webhook-url among generated, accepted, dismissed and overridden. All decisions are POSTed if not set.")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage of ts2-sim-server:
-  ts2-sim-server [options...] file
+  ts2-sim-server [options...] file [file...]
 
 ARGUMENTS:
   file
-		The JSON simulation file to load
+		The JSON simulation file to load. If several files are given, the
+		first one is the default simulation and the others are hosted next
+		to it, with the file name without extension as simulation ID.
 
 OPTIONS:
 `)
@@ -69,14 +161,42 @@ OPTIONS:
 	// Handle ctrl+c to kill on terminal
 	killChan := make(chan os.Signal, 1)
 	signal.Notify(killChan, os.Interrupt)
+	// Reload the configuration on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 
 	// Setup logging system
 	logger = log.New()
+	var format log.Format
+	switch *logFormat {
+	case "":
+		format = log.TerminalFormat()
+		if *logFile != "" {
+			format = log.LogfmtFormat()
+		}
+	case "terminal":
+		format = log.TerminalFormat()
+	case "logfmt":
+		format = log.LogfmtFormat()
+	case "json":
+		format = log.JsonFormat()
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Unknown logformat\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
 	var outputHandler log.Handler
 	if *logFile != "" {
-		outputHandler = log.Must.FileHandler(*logFile, log.LogfmtFormat())
+		logRotation.MaxSize = int64(*logMaxSize) << 20
+		file, err := server.OpenRotatingFile(*logFile, logRotation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		outputHandler = log.StreamHandler(file, format)
 	} else {
-		outputHandler = log.StreamHandler(os.Stdout, log.TerminalFormat())
+		outputHandler = log.StreamHandler(os.Stdout, format)
 	}
 	logLvl, err_level := log.LvlFromString(*logLevel)
 	if err_level != nil {
@@ -84,13 +204,237 @@ OPTIONS:
 		flag.Usage()
 		os.Exit(1)
 	}
-	logger.SetHandler(log.LvlFilterHandler(
-		logLvl,
-		outputHandler,
-	))
+	moduleLevels, err := server.ParseLogLevels(*logLevels)
+	if err == nil {
+		err = server.SetLogLevels(logLvl, moduleLevels)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	logger.SetHandler(server.LogLevelHandler(outputHandler))
 	simulation.InitializeLogger(logger)
 	server.InitializeLogger(logger)
 
+	// The flags of the command line take precedence over the configuration
+	// file and the environment
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	loadConfig := func() (*server.ServerConfig, error) {
+		config, err := server.LoadServerConfig(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		if setFlags["addr"] {
+			config.Addr = *addr
+		}
+		if setFlags["port"] {
+			config.Port = *port
+		}
+		if setFlags["debug-token"] {
+			config.DebugToken = *debugToken
+		}
+		if setFlags["audit-capacity"] {
+			config.AuditCapacity = *auditCapacity
+		}
+		if setFlags["suggestion-interval"] {
+			if *suggestionInterval < 0 || *suggestionInterval%time.Minute != 0 {
+				return nil, fmt.Errorf("suggestion interval must be a whole number of minutes")
+			}
+			config.Suggestions["suggestionsIntervalMinutes"] = suggestionInterval.Minutes()
+		}
+		return config, nil
+	}
+	config, err := loadConfig()
+	if err == nil {
+		err = server.SetServerConfig(config)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *geoTransform != "" {
+		t, err := server.ParseAffineTransform(*geoTransform)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		server.SetLayoutTransform(t)
+	}
+
+	if err := server.SetRateLimit(*rateLimit, *rateBurst); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetCompressionLevel(*compression); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetIdleTimeout(*idleTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetCoalesceInterval(*coalesce); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetMaxClients(*maxClients); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetStartTimeFactor(*timeFactor); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetKPISnapshotInterval(*kpiInterval); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := server.SetCheckpointDir(*checkpointDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *mqttBroker != "" {
+		mqttConfig.Broker = *mqttBroker
+		if *mqttEvents != "" {
+			mqttConfig.Events = strings.Split(*mqttEvents, ",")
+		}
+		if err := server.SetMQTTConfig(mqttConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if *kafkaBrokers != "" {
+		kafkaConfig.Brokers = strings.Split(*kafkaBrokers, ",")
+		if err := server.SetKafkaConfig(kafkaConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if *otlpEndpoint != "" {
+		telemetryConfig.Endpoint = *otlpEndpoint
+		headers, err := server.ParseOTLPHeaders(*otlpHeaders)
+		if err == nil {
+			telemetryConfig.Headers = headers
+			err = server.SetTelemetryConfig(telemetryConfig)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if *suggestionWebhookURL != "" {
+		swConfig := server.SuggestionWebhookConfig{URL: *suggestionWebhookURL, Secret: *suggestionWebhookSecret}
+		if *suggestionWebhookDecisions != "" {
+			swConfig.Decisions = strings.Split(*suggestionWebhookDecisions, ",")
+		}
+		if err := server.SetSuggestionWebhookConfig(swConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if timetableFeedConfig.URL != "" {
+		headers, err := server.ParseOTLPHeaders(*timetableFeedHeaders)
+		if err == nil {
+			timetableFeedConfig.Headers = headers
+			err = server.SetTimetableFeedConfig(timetableFeedConfig)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if remoteWriteConfig.URL != "" {
+		headers, err := server.ParseOTLPHeaders(*remoteWriteHeaders)
+		if err == nil {
+			remoteWriteConfig.Headers = headers
+			remoteWriteConfig.Labels, err = server.ParseOTLPHeaders(*remoteWriteLabels)
+		}
+		if err == nil {
+			err = server.SetRemoteWriteConfig(remoteWriteConfig)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if *scoringRules != "" {
+		rules, err := server.LoadScoringRules(*scoringRules)
+		if err == nil {
+			err = server.SetScoringRules(rules)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if backupConfig.Bucket != "" {
+		if err := server.SetBackupConfig(backupConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if *tlsCert != "" || *tlsKey != "" {
+		if err := server.SetTLSConfig(*tlsCert, *tlsKey, *tlsReload); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if *stressClients > 0 {
+		stressConfig.Clients = *stressClients
+		if *stressScript != "" {
+			script, err := server.LoadStressScript(*stressScript)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+				flag.Usage()
+				os.Exit(1)
+			}
+			stressConfig.Script = script
+		}
+		if *stressURL != "" {
+			stressConfig.URL = *stressURL
+			runStress(stressConfig)
+		}
+	}
+
 	// Load the simulation
 	if len(flag.Args()) == 0 {
 		fmt.Fprintf(os.Stderr, "Error: Please specify a simulation file\n\n")
@@ -106,13 +450,20 @@ OPTIONS:
 		os.Exit(1)
 	}
 
+	if *stressTrains > 0 {
+		if data, err = server.ScaleSimulationTrains(data, *stressTrains, time.Minute); err != nil {
+			logger.Crit("Unable to scale the simulation", "file", simFile, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	var sim simulation.Simulation
 	if err = json.Unmarshal(data, &sim); err != nil {
 		logger.Error("Load Error", "file", simFile, "error", err)
 		return
 	}
 
-	go server.Run(&sim, *addr, *port)
+	go server.Run(&sim, config.Addr, config.Port)
 
 	if err = sim.Initialize(); err != nil {
 		logger.Error("Invalid simulation", "file", simFile, "error", err)
@@ -120,10 +471,93 @@ OPTIONS:
 	}
 	logger.Info("Simulation loaded", "sim", sim.Options.Title)
 
-	select {
-	case <-killChan:
-		// TODO gracefully shutdown things maybe
-		logger.Info("Server killed, exiting...")
-		os.Exit(0)
+	for _, file := range flag.Args()[1:] {
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		logger.Info("Loading simulation", "file", file, "id", id)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			logger.Crit("Unable to read file", "file", file, "error", err)
+			os.Exit(1)
+		}
+		var other simulation.Simulation
+		if err = json.Unmarshal(data, &other); err != nil {
+			logger.Error("Load Error", "file", file, "error", err)
+			return
+		}
+		if err = server.AddSimulation(id, &other); err != nil {
+			logger.Error("Unable to add simulation", "file", file, "error", err)
+			return
+		}
+	}
+
+	if *stressClients > 0 {
+		host := config.Addr
+		if host == "0.0.0.0" || host == "" {
+			host = "127.0.0.1"
+		}
+		scheme := "ws"
+		if *tlsCert != "" {
+			scheme = "wss"
+		}
+		stressConfig.URL = fmt.Sprintf("%s://%s/ws", scheme, net.JoinHostPort(host, config.Port))
+		stressConfig.Token = sim.Options.ClientToken
+		if config.ClientToken != "" {
+			stressConfig.Token = config.ClientToken
+		}
+		for _, u := range config.Users {
+			if u.Role == server.RoleOperator {
+				stressConfig.Token = u.Token
+				break
+			}
+		}
+		waitForServer(net.JoinHostPort(host, config.Port))
+		runStress(stressConfig)
+	}
+
+	for {
+		select {
+		case <-reloadChan:
+			logger.Info("Reloading configuration", "file", *configFile)
+			config, err := loadConfig()
+			if err == nil {
+				err = server.SetServerConfig(config)
+			}
+			if err != nil {
+				logger.Error("Unable to reload configuration, keeping the current one", "file", *configFile, "error", err)
+			}
+		case <-killChan:
+			// TODO gracefully shutdown things maybe
+			logger.Info("Server killed, exiting...")
+			os.Exit(0)
+		}
+	}
+}
+
+// waitForServer waits for the server to listen on address, for at most 10
+// seconds.
+func waitForServer(address string) {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// runStress runs a stress test with the given configuration, prints its
+// report and exits.
+func runStress(config server.StressConfig) {
+	logger.Info("Starting stress test", "url", config.URL, "clients", config.Clients, "duration", config.Duration)
+	report, err := server.RunStress(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(data))
+	if report.ConnectFailures > 0 || report.Disconnections > 0 || report.Errors > 0 || report.Unanswered > 0 {
+		os.Exit(1)
 	}
+	os.Exit(0)
 }
@@ -25,16 +25,18 @@ import (
 
 // StandardManager is a points manager that performs points change
 // immediately and never fails.
+//
+// Directions are kept separately for each simulation.
 type StandardManager struct {
 	sync.RWMutex
-	directions map[string]simulation.PointDirection
+	directions map[*simulation.Simulation]map[string]simulation.PointDirection
 }
 
 // Direction returns the direction of the points
 func (sm *StandardManager) Direction(p *simulation.PointsItem) simulation.PointDirection {
 	sm.RLock()
 	defer sm.RUnlock()
-	return sm.directions[p.ID()]
+	return sm.directions[p.Simulation()][p.ID()]
 }
 
 // SetDirection tries to set the given PointsItem to the given direction
@@ -47,23 +49,36 @@ func (sm *StandardManager) SetDirection(p *simulation.PointsItem, dir simulation
 	}
 	sm.Lock()
 	defer sm.Unlock()
-	sm.directions[p.ID()] = dir
+	dirs, ok := sm.directions[p.Simulation()]
+	if !ok {
+		dirs = make(map[string]simulation.PointDirection)
+		sm.directions[p.Simulation()] = dirs
+	}
+	dirs[p.ID()] = dir
 	if p.PairedItem() != nil {
-		sm.directions[p.PairedItem().ID()] = dir
+		dirs[p.PairedItem().ID()] = dir
 	}
 }
 
+// ReleaseSimulation discards the directions of all points of the given simulation.
+func (sm *StandardManager) ReleaseSimulation(sim *simulation.Simulation) {
+	sm.Lock()
+	defer sm.Unlock()
+	delete(sm.directions, sim)
+}
+
 // Name returns a description of this manager that is used for the UI.
 func (sm *StandardManager) Name() string {
 	return "Standard Manager"
 }
 
 var _ simulation.PointsItemManager = new(StandardManager)
+var _ simulation.SimulationReleaser = new(StandardManager)
 
 // newStandardManager returns a pointer to a new StandardManager.
 func newStandardManager() *StandardManager {
 	return &StandardManager{
-		directions: make(map[string]simulation.PointDirection),
+		directions: make(map[*simulation.Simulation]map[string]simulation.PointDirection),
 	}
 }
 
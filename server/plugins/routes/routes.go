@@ -33,6 +33,18 @@ type StandardManager struct{}
 // In this implementation, it checks route conflicts and returns
 // false if a conflict is found.
 func (sm StandardManager) CanActivate(r *simulation.Route) error {
+	// Only r and the routes of its conflict graph can hold its items: if none
+	// of them is set or being released, there is nothing to check.
+	busy := r.State() != simulation.Deactivated
+	for _, c := range r.Conflicts() {
+		if c.Kind != simulation.ConflictFlank && c.Route.State() != simulation.Deactivated {
+			busy = true
+			break
+		}
+	}
+	if !busy {
+		return nil
+	}
 	var flag *simulation.Route
 	for _, pos := range r.Positions {
 		if pos.TrackItem().ID() == r.BeginSignalId || pos.TrackItem().ID() == r.EndSignalId {
@@ -45,13 +45,19 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	secs := float64(timeElapsed) / float64(time.Second)
 
 	// maxDistance is the maximum distance we look ahead to find speed limits
-	maxDistance := math.Max(math.Pow(t.Speed, 2)/t.TrainType().StdBraking, defaultMaxDistance)
+	maxDistance := math.Max(math.Pow(t.Speed, 2)/t.Braking(), defaultMaxDistance)
+	safetyDistance := lineSafetyDistance
+	if margin, ok := t.MovingBlockMargin(); ok {
+		// In moving block, signals do not protect the train ahead: we
+		// supervise our braking curve down to its tail plus the margin.
+		safetyDistance = margin
+		maxDistance += margin
+	}
 
 	// Get distances to next targets
 	dtnStation, okStation := distanceToNextStop(t, maxDistance)
 	dtnSpeedLimit, speedLimit, okSpeedLimit := nextSpeedLimit(t, maxDistance, secs)
 	dtnTrain, okTrain := distanceToNextTrain(t, maxDistance)
-	safetyDistance := lineSafetyDistance
 	if t.IsShunting() {
 		safetyDistance = 0
 	}
@@ -64,8 +70,8 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	switch t.ApplicableAction().Target {
 	case simulation.ASAP:
 		// We emulate a distance to next signal to get a stdBraking
-		dtnSignal = (math.Pow(t.Speed-t.TrainType().StdBraking*secs, 2)-math.Pow(t.ApplicableAction().Speed, 2))/
-			(2*t.TrainType().StdBraking) + (t.Speed * secs / 2)
+		dtnSignal = (math.Pow(t.Speed-t.Braking()*secs, 2)-math.Pow(t.ApplicableAction().Speed, 2))/
+			(2*t.Braking()) + (t.Speed * secs / 2)
 	case simulation.BeforeNextSignal:
 		if nsp.TrackItemID == t.LastSeenSignal().ID() {
 			// The signal with the applicable action is still ahead
@@ -104,8 +110,8 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	targetSpeed := math.Min(targetSpeedForStation,
 		math.Min(targetSpeedForLimit,
 			math.Min(targetSpeedForTrain, targetSpeedForSignal)))
-	acceleration := math.Max(-t.TrainType().EmergBraking,
-		math.Min(1/secs*(targetSpeed-t.Speed), t.TrainType().StdAccel))
+	acceleration := math.Max(-t.EmergencyBraking(),
+		math.Min(1/secs*(targetSpeed-t.Speed), t.Acceleration()))
 	simulation.Logger.Debug("Set Train speed", "ID", t.ID(),
 		"dtnStation", dtnStation,
 		"dtnSpeedLimit", dtnSpeedLimit,
@@ -144,6 +150,11 @@ func distanceToNextSignal(t *simulation.Train) (float64, bool) {
 //
 // Second parameter is true if a stop has been found.
 func distanceToNextStop(t *simulation.Train, maxDistance float64) (float64, bool) {
+	if t.IsShunting() {
+		// Shunting movements do not call at places but stop at the end of
+		// their path
+		return t.DistanceToShuntEnd(maxDistance)
+	}
 	if t.Service() == nil || t.NextPlaceIndex == simulation.NoMorePlace {
 		// No service assigned or no place to call at
 		return 0, false
@@ -186,7 +197,7 @@ func nextSpeedLimit(t *simulation.Train, maxDistance, secs float64) (float64, fl
 	for pos.TrackItem().Type() != simulation.TypeEnd && distance < maxDistance {
 		pos = pos.Next(simulation.DirectionCurrent)
 		ti := pos.TrackItem()
-		if ti.MaxSpeed() < getMaxSpeed(t)-t.TrainType().StdBraking*secs {
+		if ti.MaxSpeed() < getMaxSpeed(t)-t.Braking()*secs {
 			return distance, ti.MaxSpeed(), true
 		}
 		distance += ti.RealLength()
@@ -225,7 +236,7 @@ func distanceToNextTrain(t *simulation.Train, maxDistance float64) (float64, boo
 func targetSpeed(t *simulation.Train, secs, targetDistance, targetSpeed float64) float64 {
 	// d is the maximum distance that can be travelled during the last
 	// sample. It is used to determine when to stop the train.
-	d := 0.5 * t.TrainType().StdBraking * math.Pow(secs, 2)
+	d := 0.5 * t.Braking() * math.Pow(secs, 2)
 	if targetDistance < d {
 		return targetSpeed
 	}
@@ -251,11 +262,14 @@ func targetSpeed(t *simulation.Train, secs, targetDistance, targetSpeed float64)
 func calculatedSpeed(t *simulation.Train, targetDistance, targetSpeed float64) float64 {
 	return math.Min(
 		getMaxSpeed(t),
-		math.Sqrt(math.Abs(2*targetDistance*t.TrainType().StdBraking)+math.Pow(targetSpeed, 2)))
+		math.Sqrt(math.Abs(2*targetDistance*t.Braking())+math.Pow(targetSpeed, 2)))
 }
 
 // getMaxSpeed returns the maximum speed allowed for the train in its current position
 func getMaxSpeed(t *simulation.Train) float64 {
+	if t.IsShunting() {
+		return math.Min(simulation.ShuntingSpeed, t.MaxSpeedForTrainTrackItems())
+	}
 	return math.Min(t.TrainType().MaxSpeed, t.MaxSpeedForTrainTrackItems())
 }
 
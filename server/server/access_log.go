@@ -0,0 +1,127 @@
+package server
+
+import (
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// statusRecorder is a http.ResponseWriter that remembers the status code and
+// the size of the response.
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    size   int
+}
+
+// WriteHeader records the status code before writing it
+func (sr *statusRecorder) WriteHeader(code int) {
+    if sr.status == 0 {
+        sr.status = code
+    }
+    sr.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the size of the response
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    n, err := sr.ResponseWriter.Write(b)
+    sr.size += n
+    return n, err
+}
+
+// Flush lets streaming handlers (e.g. server sent events) work through the recorder
+func (sr *statusRecorder) Flush() {
+    if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// clientAddress returns the address of the client, honouring X-Forwarded-For
+// when the server is behind a proxy.
+func clientAddress(r *http.Request) string {
+    if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+        return strings.TrimSpace(strings.Split(fwd, ",")[0])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+// clientIdentity returns the user ID and role given by the client, if any.
+func clientIdentity(r *http.Request) (string, string) {
+    return r.Header.Get("X-User-ID"), r.Header.Get("X-User-Role")
+}
+
+// isMutatingMethod returns true if requests with the given method may change
+// the state of the server.
+func isMutatingMethod(method string) bool {
+    switch method {
+    case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+        return true
+    }
+    return false
+}
+
+// accessLog logs every request served by the given handler and records the
+// mutating ones in the audit log.
+func accessLog(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        sr := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(sr, r)
+        if sr.status == 0 {
+            sr.status = http.StatusOK
+        }
+        latency := time.Since(start)
+        user, role := clientIdentity(r)
+        remote := clientAddress(r)
+        logCtx := []interface{}{
+            "submodule", "http", "method", r.Method, "path", r.URL.Path, "status", sr.status,
+            "latency", latency, "size", sr.size, "remote", remote, "user", user, "role", role,
+        }
+        switch {
+        case sr.status >= 500:
+            logger.Error("HTTP request", logCtx...)
+        case sr.status >= 400:
+            logger.Warn("HTTP request", logCtx...)
+        default:
+            logger.Info("HTTP request", logCtx...)
+        }
+        if !isMutatingMethod(r.Method) {
+            return
+        }
+        severity := "INFO"
+        if sr.status >= 400 {
+            severity = "WARNING"
+        }
+        // Commands sent to a simulation are recorded in its own audit log
+        h := hub
+        if id, _, ok := scopedSimulationPath(r.URL.Path); ok {
+            if sh, found := simulations.get(id); found {
+                h = sh
+            }
+        }
+        h.audits.append(AuditEntry{
+            Event:    "HTTP_COMMAND",
+            Category: "http",
+            Severity: severity,
+            Object:   map[string]interface{}{"id": r.URL.Path, "type": "http"},
+            Details: map[string]interface{}{
+                "method":    r.Method,
+                "path":      r.URL.Path,
+                "query":     r.URL.RawQuery,
+                "status":    sr.status,
+                "latencyMs": float64(latency) / float64(time.Millisecond),
+                "user":      user,
+                "role":      role,
+                "remote":    remote,
+            },
+        })
+    })
+}
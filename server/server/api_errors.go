@@ -0,0 +1,96 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// Error codes of the REST API
+const (
+    ErrCodeBadRequest               = "BAD_REQUEST"
+    ErrCodeInvalidParameter         = "INVALID_PARAMETER"
+    ErrCodeMethodNotAllowed         = "METHOD_NOT_ALLOWED"
+    ErrCodeUnauthorized             = "UNAUTHORIZED"
+    ErrCodeForbidden                = "FORBIDDEN"
+    ErrCodeNotFound                 = "NOT_FOUND"
+    ErrCodeTrainNotFound            = "TRAIN_NOT_FOUND"
+    ErrCodeServiceNotFound          = "SERVICE_NOT_FOUND"
+    ErrCodeSignalNotFound           = "SIGNAL_NOT_FOUND"
+    ErrCodeSectionNotFound          = "SECTION_NOT_FOUND"
+    ErrCodeScenarioNotFound         = "SCENARIO_NOT_FOUND"
+    ErrCodeTrainingNotFound         = "TRAINING_SCENARIO_NOT_FOUND"
+    ErrCodeDisruptionNotFound       = "DISRUPTION_NOT_FOUND"
+    ErrCodeSpeedRestrictionNotFound = "SPEED_RESTRICTION_NOT_FOUND"
+    ErrCodePossessionNotFound       = "POSSESSION_NOT_FOUND"
+    ErrCodePointsNotFound           = "POINTS_NOT_FOUND"
+    ErrCodeLevelCrossingNotFound    = "LEVEL_CROSSING_NOT_FOUND"
+    ErrCodeSimulationNotFound       = "SIMULATION_NOT_FOUND"
+    ErrCodeCheckpointNotFound       = "CHECKPOINT_NOT_FOUND"
+    ErrCodeBreakpointNotFound       = "BREAKPOINT_NOT_FOUND"
+    ErrCodePlaceNotFound            = "PLACE_NOT_FOUND"
+    ErrCodeDepotNotFound            = "DEPOT_NOT_FOUND"
+    ErrCodeRouteNotFound            = "ROUTE_NOT_FOUND"
+    ErrCodeControlAreaNotFound      = "CONTROL_AREA_NOT_FOUND"
+    ErrCodeConflict                 = "CONFLICT"
+    ErrCodeNotImplemented           = "NOT_IMPLEMENTED"
+    ErrCodeSimulationNotAvailable   = "SIMULATION_NOT_INITIALIZED"
+    ErrCodeInternal                 = "INTERNAL_ERROR"
+)
+
+// apiError is the body of all error responses of the REST API:
+//
+//   {"error": {"code": "TRAIN_NOT_FOUND", "message": "Train not found", "details": {"trainId": "12"}, "timestamp": "..."}}
+type apiError struct {
+    Code      string                 `json:"code"`
+    Message   string                 `json:"message"`
+    Details   map[string]interface{} `json:"details,omitempty"`
+    Timestamp string                 `json:"timestamp"`
+}
+
+// writeAPIError writes an error response with the given HTTP status and error envelope.
+func writeAPIError(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(map[string]apiError{
+        "error": {Code: code, Message: message, Details: details, Timestamp: time.Now().UTC().Format(time.RFC3339)},
+    })
+}
+
+// methodNotAllowed writes a 405 error response
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+    writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed",
+        map[string]interface{}{"method": r.Method})
+}
+
+// simulationNotInitialized writes a 503 error response
+func simulationNotInitialized(w http.ResponseWriter) {
+    writeAPIError(w, http.StatusServiceUnavailable, ErrCodeSimulationNotAvailable, "Simulation not initialized", nil)
+}
+
+// badRequest writes a 400 error response for an unparsable request body
+func badRequest(w http.ResponseWriter, err error) {
+    writeAPIError(w, http.StatusBadRequest, ErrCodeBadRequest, "Bad request",
+        map[string]interface{}{"error": err.Error()})
+}
+
+// invalidParameter writes a 400 error response for a request with invalid values
+func invalidParameter(w http.ResponseWriter, message string, details map[string]interface{}) {
+    writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidParameter, message, details)
+}
+
+// internalError writes a 500 error response
+func internalError(w http.ResponseWriter, message string, err error) {
+    var details map[string]interface{}
+    if err != nil {
+        details = map[string]interface{}{"error": err.Error()}
+    }
+    writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, message, details)
+}
+
+// serveAPINotFound answers requests to unknown API routes
+func serveAPINotFound(w http.ResponseWriter, r *http.Request) {
+    writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "Not found",
+        map[string]interface{}{"path": r.URL.Path})
+}
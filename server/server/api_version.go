@@ -0,0 +1,58 @@
+package server
+
+import (
+    "net/http"
+    "strings"
+)
+
+const (
+    // apiVersion is the current version of the REST API
+    apiVersion = "v1"
+    // apiPrefix is the path prefix of the current version of the REST API
+    apiPrefix = "/api/" + apiVersion
+)
+
+// apiSunset returns the Sunset header of the unversioned routes, or an empty
+// string if the configuration sets no sunset date.
+func apiSunset() string {
+    serverConfigMutex.RLock()
+    defer serverConfigMutex.RUnlock()
+    if serverConfig == nil || serverConfig.APISunset.IsZero() {
+        return ""
+    }
+    return serverConfig.APISunset.UTC().Format(http.TimeFormat)
+}
+
+// versionedAPI serves the requests made to apiPrefix and under it with the
+// given API handler, whose routes are registered without the version.
+func versionedAPI(api http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        r2 := new(http.Request)
+        *r2 = *r
+        u := *r.URL
+        u.Path = "/api" + strings.TrimPrefix(r.URL.Path, apiPrefix)
+        if u.Path == "/api" {
+            u.Path = "/api/"
+        }
+        u.RawPath = ""
+        r2.URL = &u
+        w.Header().Set("API-Version", apiVersion)
+        api.ServeHTTP(w, r2)
+    })
+}
+
+// deprecatedAPI serves the unversioned routes with the given API handler and
+// flags the responses as deprecated, pointing to the versioned route, and
+// until when they are served if the configuration says so.
+func deprecatedAPI(api http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        successor := apiPrefix + strings.TrimPrefix(r.URL.Path, "/api")
+        w.Header().Set("Deprecation", "true")
+        if sunset := apiSunset(); sunset != "" {
+            w.Header().Set("Sunset", sunset)
+        }
+        w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+        logger.Debug("Deprecated unversioned API route", "submodule", "http", "path", r.URL.Path, "successor", successor)
+        api.ServeHTTP(w, r)
+    })
+}
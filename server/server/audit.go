@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +15,7 @@ import (
 type AuditEntry struct {
 	ID        string                 `json:"id"`
 	Timestamp string                 `json:"timestamp"`
+	SimTime   string                 `json:"simTime,omitempty"`
 	Event     string                 `json:"event"`
 	Category  string                 `json:"category"`
 	Severity  string                 `json:"severity"`
@@ -27,15 +29,64 @@ type auditState struct {
 	capacity    int
 	nextID      int64
 	subscribers map[chan AuditEntry]bool
+	// simulationID is the ID of the simulation of this audit log
+	simulationID string
 }
 
-var audits = &auditState{}
+// defaultAuditCapacity is the default number of entries kept in the audit
+// log of each simulation
+const defaultAuditCapacity = 1000
 
-func init() {
-	// default capacity for audit ring buffer
-	audits.capacity = 1000
-	audits.entries = make([]AuditEntry, 0, audits.capacity)
-	audits.subscribers = make(map[chan AuditEntry]bool)
+var (
+	auditCapacityMutex sync.RWMutex
+	auditCapacity      = defaultAuditCapacity
+)
+
+// audits is the audit log of the default simulation
+var audits = newAuditState(DefaultSimulationID)
+
+// SetAuditCapacity sets the number of entries kept in the audit log of each
+// simulation. The oldest entries of the existing logs are dropped if they
+// are longer.
+func SetAuditCapacity(capacity int) error {
+	if capacity < 1 {
+		return fmt.Errorf("audit capacity must be at least 1")
+	}
+	auditCapacityMutex.Lock()
+	auditCapacity = capacity
+	auditCapacityMutex.Unlock()
+	for _, h := range simulations.list() {
+		h.audits.setCapacity(capacity)
+	}
+	return nil
+}
+
+// currentAuditCapacity returns the number of entries kept in audit logs
+func currentAuditCapacity() int {
+	auditCapacityMutex.RLock()
+	defer auditCapacityMutex.RUnlock()
+	return auditCapacity
+}
+
+// newAuditState returns an empty audit log for the simulation with the given ID
+func newAuditState(simID string) *auditState {
+	a := new(auditState)
+	a.simulationID = simID
+	a.capacity = currentAuditCapacity()
+	a.entries = make([]AuditEntry, 0, a.capacity)
+	a.subscribers = make(map[chan AuditEntry]bool)
+	return a
+}
+
+// setCapacity changes the number of entries kept in this log, dropping the
+// oldest ones if there are more.
+func (a *auditState) setCapacity(capacity int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.capacity = capacity
+	if len(a.entries) > capacity {
+		a.entries = append(make([]AuditEntry, 0, capacity), a.entries[len(a.entries)-capacity:]...)
+	}
 }
 
 func (a *auditState) append(entry AuditEntry) {
@@ -54,6 +105,7 @@ func (a *auditState) append(entry AuditEntry) {
 	} else {
 		a.entries = append(a.entries, entry)
 	}
+	streamKafkaAudit(a.simulationID, entry)
 	// broadcast non-blocking to subscribers
 	for ch := range a.subscribers {
 		select {
@@ -96,12 +148,31 @@ func (a *auditState) getSince(sinceID int64, limit int) []AuditEntry {
 	return out
 }
 
-// recordAuditFromEvent converts a simulation event to an AuditEntry and appends it
-func recordAuditFromEvent(e *simulation.Event) {
+// recordAuditFromEvent appends the audit entry of the given event, if it is
+// audited.
+func (h *Hub) recordAuditFromEvent(e *simulation.Event) {
 	if e == nil {
 		return
 	}
+	if entry := h.eventSnapshot(e).audit; entry != nil {
+		h.audits.append(*entry)
+	}
+}
+
+// auditEntryFromEvent converts the given event of sim sent at the given
+// simulation time to an AuditEntry. It returns false if the event is not
+// audited.
+//
+// It reads the object of the event, so that it is called when the event is
+// sent, on the goroutine of the simulation.
+func auditEntryFromEvent(sim *simulation.Simulation, e *simulation.Event, now time.Time) (AuditEntry, bool) {
+	switch e.Name {
+	case simulation.TrackItemChangedEvent, simulation.TrainChangedEvent, simulation.ClockEvent:
+		// ignore very chatty events by default
+		return AuditEntry{}, false
+	}
 	entry := AuditEntry{
+		SimTime:  sim.FormatTime(now),
 		Severity: "INFO",
 		Object:   map[string]interface{}{},
 		Details:  map[string]interface{}{},
@@ -151,8 +222,8 @@ func recordAuditFromEvent(e *simulation.Event) {
 				sl := line.Lines[t.NextPlaceIndex]
 				if !sl.ScheduledArrivalTime.IsZero() {
 					entry.Details["scheduledArrival"] = sl.ScheduledArrivalTime.Format(time.RFC3339)
-					entry.Details["actualTime"] = sim.Options.CurrentTime.Format(time.RFC3339)
-					d := sim.Options.CurrentTime.Sub(sl.ScheduledArrivalTime)
+					entry.Details["actualTime"] = now.Format(time.RFC3339)
+					d := now.Sub(sl.ScheduledArrivalTime.Time)
 					entry.Details["delayMinutes"] = int(d / time.Minute)
 				}
 			}
@@ -175,27 +246,159 @@ func recordAuditFromEvent(e *simulation.Event) {
 				sl := line.Lines[idx]
 				if !sl.ScheduledDepartureTime.IsZero() {
 					entry.Details["scheduledDeparture"] = sl.ScheduledDepartureTime.Format(time.RFC3339)
-					entry.Details["actualTime"] = sim.Options.CurrentTime.Format(time.RFC3339)
-					d := sim.Options.CurrentTime.Sub(sl.ScheduledDepartureTime)
+					entry.Details["actualTime"] = now.Format(time.RFC3339)
+					d := now.Sub(sl.ScheduledDepartureTime.Time)
 					entry.Details["delayMinutes"] = int(d / time.Minute)
 				}
 			}
 		}
+	case simulation.TrainAddedEvent:
+		entry.Event = "TRAIN_ADDED"
+		entry.Category = "train"
+		if t, ok := e.Object.(*simulation.Train); ok {
+			entry.Object["id"] = t.ID()
+			entry.Object["serviceCode"] = t.ServiceCode
+			entry.Details["trainTypeCode"] = t.TrainTypeCode
+			entry.Details["trackItemId"] = t.TrainHead.TrackItemID
+			entry.Details["appearTime"] = t.AppearTime.Format(time.RFC3339)
+		}
+	case simulation.TrainCancelledEvent:
+		entry.Event = "TRAIN_CANCELLED"
+		entry.Category = "train"
+		entry.Severity = "WARNING"
+		if t, ok := e.Object.(*simulation.Train); ok {
+			entry.Object["id"] = t.ID()
+			entry.Object["serviceCode"] = t.ServiceCode
+		}
+	case simulation.TrainSplitEvent, simulation.TrainJoinedEvent:
+		entry.Event = "TRAIN_SPLIT"
+		if e.Name == simulation.TrainJoinedEvent {
+			entry.Event = "TRAIN_JOINED"
+		}
+		entry.Category = "train"
+		if tc, ok := e.Object.(*simulation.TrainCoupling); ok {
+			entry.Object["id"] = tc.TrainID
+			entry.Details["otherTrainId"] = tc.OtherTrainID
+			entry.Details["trackItemId"] = tc.TrackItemID
+			entry.Details["placeCode"] = tc.PlaceCode
+		}
+	case simulation.SignalFailedEvent, simulation.SignalRepairedEvent:
+		entry.Event = "SIGNAL_REPAIRED"
+		if e.Name == simulation.SignalFailedEvent {
+			entry.Event = "SIGNAL_FAILED"
+			entry.Severity = "WARNING"
+		}
+		entry.Category = "signal"
+		if s, ok := e.Object.(*simulation.SignalItem); ok {
+			entry.Object["id"] = s.ID()
+			entry.Object["type"] = s.SignalTypeCode
+			entry.Details["failureMode"] = string(s.FailureMode())
+			entry.Details["cause"] = s.FailureCause()
+			if !s.RepairTime().IsZero() {
+				entry.Details["repairTime"] = s.RepairTime().Format(time.RFC3339)
+			}
+		}
+	case simulation.PointsFailedEvent, simulation.PointsRepairedEvent:
+		entry.Event = "POINTS_REPAIRED"
+		if e.Name == simulation.PointsFailedEvent {
+			entry.Event = "POINTS_FAILED"
+			entry.Severity = "WARNING"
+		}
+		entry.Category = "points"
+		if p, ok := e.Object.(*simulation.PointsItem); ok {
+			entry.Object["id"] = p.ID()
+			entry.Details["failureMode"] = string(p.FailureMode())
+			entry.Details["cause"] = p.FailureCause()
+			entry.Details["reversed"] = p.Reversed()
+			if !p.RepairTime().IsZero() {
+				entry.Details["repairTime"] = p.RepairTime().Format(time.RFC3339)
+			}
+		}
+	case simulation.LevelCrossingFailedEvent, simulation.LevelCrossingRepairedEvent:
+		entry.Event = "LEVEL_CROSSING_REPAIRED"
+		if e.Name == simulation.LevelCrossingFailedEvent {
+			entry.Event = "LEVEL_CROSSING_FAILED"
+			entry.Severity = "WARNING"
+		}
+		entry.Category = "levelCrossing"
+		if lc, ok := e.Object.(*simulation.LevelCrossingItem); ok {
+			entry.Object["id"] = lc.ID()
+			entry.Details["failureMode"] = string(lc.FailureMode())
+			entry.Details["cause"] = lc.FailureCause()
+			entry.Details["state"] = string(lc.State())
+			if !lc.RepairTime().IsZero() {
+				entry.Details["repairTime"] = lc.RepairTime().Format(time.RFC3339)
+			}
+		}
+	case simulation.PerturbationEvent:
+		entry.Event = "PERTURBATION_INJECTED"
+		entry.Category = "train"
+		if p, ok := e.Object.(*simulation.Perturbation); ok {
+			entry.Object["id"] = p.TrainID
+			entry.Object["serviceCode"] = p.ServiceCode
+			entry.Details["kind"] = string(p.Kind)
+			entry.Details["delaySeconds"] = p.DelaySeconds
+		}
+	case simulation.OverspeedEvent, simulation.SignalPassedAtDangerEvent:
+		entry.Event = "TRAIN_OVERSPEED"
+		if e.Name == simulation.SignalPassedAtDangerEvent {
+			entry.Event = "SIGNAL_PASSED_AT_DANGER"
+		}
+		entry.Category = "safety"
+		entry.Severity = "CRITICAL"
+		if sv, ok := e.Object.(*simulation.SafetyViolation); ok {
+			entry.Object["id"] = sv.TrainID
+			entry.Object["serviceCode"] = sv.ServiceCode
+			entry.Details["trackItemId"] = sv.TrackItemID
+			entry.Details["speed"] = sv.Speed
+			if sv.Kind == simulation.SafetyOverspeed {
+				entry.Details["permittedSpeed"] = sv.PermittedSpeed
+			}
+		}
+	case simulation.ServiceChangedEvent:
+		entry.Event = "TIMETABLE_CHANGED"
+		entry.Category = "service"
+		if s, ok := e.Object.(*simulation.Service); ok {
+			entry.Object["id"] = s.ID()
+			entry.Details["lines"] = len(s.Lines)
+		}
+	case simulation.BreakpointHitEvent:
+		entry.Event = "BREAKPOINT_HIT"
+		entry.Category = "system"
+		if bh, ok := e.Object.(*simulation.BreakpointHit); ok {
+			entry.Object["id"] = bh.ID()
+			entry.Details["type"] = string(bh.Breakpoint.Type)
+			entry.Details["reason"] = bh.Reason
+		}
 	case simulation.MessageReceivedEvent:
 		entry.Event = "MESSAGE_RECEIVED"
 		entry.Category = "system"
 		// The message logger uses string messages; attempt to marshal object to JSON if possible
-		b, _ := json.Marshal(e.Object)
+		b := []byte(e.Data)
+		if b == nil {
+			b, _ = json.Marshal(e.Object)
+		}
 		entry.Details["message"] = strings.TrimSpace(string(b))
-	default:
-		// ignore very chatty events like TrackItemChanged/TrainChanged by default
-		if e.Name == simulation.TrackItemChangedEvent || e.Name == simulation.TrainChangedEvent || e.Name == simulation.ClockEvent {
-			return
+	case ChatMessageEvent:
+		entry.Event = "CHAT_MESSAGE"
+		entry.Category = "chat"
+		if m, ok := e.Object.(*ChatMessage); ok {
+			entry.Object["id"] = m.ID()
+			entry.Object["type"] = "chatMessage"
+			entry.Details["from"] = m.From
+			entry.Details["role"] = string(m.Role)
+			entry.Details["to"] = m.To
+			entry.Details["text"] = m.Text
+			if m.Object != nil {
+				entry.Details["objectType"] = m.Object.Type
+				entry.Details["objectId"] = m.Object.ID
+			}
 		}
+	default:
 		entry.Event = strings.ToUpper(string(e.Name))
 		entry.Category = "system"
 	}
-	audits.append(entry)
+	return entry, true
 }
 
 
@@ -0,0 +1,587 @@
+package server
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Kinds of backed up objects. Each kind has its own prefix in the bucket
+// and its own retention.
+const (
+    backupCheckpoints = "checkpoints"
+    backupKPI         = "kpi"
+    backupAudit       = "audit"
+)
+
+// backupTimeFormat is the format of the timestamps in object keys
+const backupTimeFormat = "20060102T150405Z"
+
+// BackupConfig is the configuration of the backup of the simulations to
+// S3-compatible object storage.
+type BackupConfig struct {
+    // Endpoint is the base URL of the storage service, e.g.
+    // https://s3.eu-west-1.amazonaws.com or http://minio:9000
+    Endpoint string
+    // Bucket is the name of the bucket in which objects are written
+    Bucket string
+    // Region is the region used to sign requests
+    Region string
+    // AccessKey and SecretKey are the credentials used to sign requests
+    AccessKey string
+    SecretKey string
+    // PathStyle addresses the bucket in the path (endpoint/bucket/key)
+    // instead of the host name (bucket.endpoint/key), as required by most
+    // self-hosted services.
+    PathStyle bool
+    // Prefix is prepended to all object keys
+    Prefix string
+    // Interval is the time between two backups
+    Interval time.Duration
+    // Retention is the age after which objects are deleted. Objects are
+    // never deleted if it is 0.
+    Retention time.Duration
+    // KeepLast is the number of most recent objects of each kind and
+    // simulation that are kept whatever their age.
+    KeepLast int
+}
+
+// DefaultBackupConfig returns the default backup configuration, without
+// storage service.
+func DefaultBackupConfig() BackupConfig {
+    return BackupConfig{
+        Region:    "us-east-1",
+        Prefix:    "ts2/",
+        Interval:  15 * time.Minute,
+        Retention: 7 * 24 * time.Hour,
+        KeepLast:  3,
+    }
+}
+
+// validate checks the configuration
+func (bc BackupConfig) validate() error {
+    u, err := url.Parse(bc.Endpoint)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("invalid backup endpoint: %s", bc.Endpoint)
+    }
+    if bc.Bucket == "" || strings.Contains(bc.Bucket, "/") {
+        return fmt.Errorf("invalid backup bucket: %q", bc.Bucket)
+    }
+    if bc.Region == "" {
+        return fmt.Errorf("backup region cannot be empty")
+    }
+    if bc.AccessKey == "" || bc.SecretKey == "" {
+        return fmt.Errorf("backup access key and secret key are required")
+    }
+    if bc.Interval < time.Minute {
+        return fmt.Errorf("backup interval must be at least 1m")
+    }
+    if bc.Retention < 0 {
+        return fmt.Errorf("backup retention cannot be negative")
+    }
+    if bc.KeepLast < 1 {
+        return fmt.Errorf("at least one backup of each kind must be kept")
+    }
+    return nil
+}
+
+// An s3Client makes the requests of the S3 API used for backups, signed with
+// AWS Signature Version 4.
+type s3Client struct {
+    config BackupConfig
+    client *http.Client
+}
+
+// objectURL returns the URL of the given object key, or of the bucket if
+// key is empty.
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+    u, _ := url.Parse(c.config.Endpoint)
+    base := strings.TrimSuffix(u.Path, "/")
+    if c.config.PathStyle {
+        u.Path = base + "/" + c.config.Bucket + "/" + key
+    } else {
+        u.Host = c.config.Bucket + "." + u.Host
+        u.Path = base + "/" + key
+    }
+    u.RawQuery = s3CanonicalQuery(query)
+    return u
+}
+
+// do signs and sends a request and returns the response body. Non 2xx
+// responses are returned as errors.
+func (c *s3Client) do(method string, u *url.URL, body []byte, contentType string) ([]byte, error) {
+    req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    sum := sha256.Sum256(body)
+    c.sign(req, hex.EncodeToString(sum[:]), time.Now())
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        var s3Err struct {
+            Code    string `xml:"Code"`
+            Message string `xml:"Message"`
+        }
+        if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+            return nil, fmt.Errorf("%s %s: %s (%s)", method, u.Path, s3Err.Code, s3Err.Message)
+        }
+        return nil, fmt.Errorf("%s %s: unexpected status %d", method, u.Path, resp.StatusCode)
+    }
+    return data, nil
+}
+
+// sign adds the AWS Signature Version 4 authorization of the request. All the
+// headers of the request are signed.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+    amzDate := now.UTC().Format(backupTimeFormat)
+    date := amzDate[:8]
+    req.Header.Set("X-Amz-Date", amzDate)
+    req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+    headers := map[string]string{"host": req.URL.Host}
+    for k, v := range req.Header {
+        headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+    }
+    names := make([]string, 0, len(headers))
+    for k := range headers {
+        names = append(names, k)
+    }
+    sort.Strings(names)
+    var canonicalHeaders strings.Builder
+    for _, k := range names {
+        canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+    }
+    signedHeaders := strings.Join(names, ";")
+    canonicalRequest := strings.Join([]string{
+        req.Method,
+        s3Escape(req.URL.Path, false),
+        s3CanonicalQuery(req.URL.Query()),
+        canonicalHeaders.String(),
+        signedHeaders,
+        payloadHash,
+    }, "\n")
+
+    scope := date + "/" + c.config.Region + "/s3/aws4_request"
+    hash := sha256.Sum256([]byte(canonicalRequest))
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+    key := []byte("AWS4" + c.config.SecretKey)
+    for _, part := range []string{date, c.config.Region, "s3", "aws4_request"} {
+        key = hmacSHA256(key, part)
+    }
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        c.config.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+// s3Escape URI-encodes s as required by Signature Version 4. Slashes are
+// kept unless encodeSlash is true.
+func s3Escape(s string, encodeSlash bool) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        ch := s[i]
+        switch {
+        case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+            ch == '-', ch == '_', ch == '.', ch == '~':
+            b.WriteByte(ch)
+        case ch == '/' && !encodeSlash:
+            b.WriteByte(ch)
+        default:
+            fmt.Fprintf(&b, "%%%02X", ch)
+        }
+    }
+    return b.String()
+}
+
+// s3CanonicalQuery returns the query sorted by key and encoded as required
+// by Signature Version 4.
+func s3CanonicalQuery(query url.Values) string {
+    keys := make([]string, 0, len(query))
+    for k := range query {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var parts []string
+    for _, k := range keys {
+        values := append([]string{}, query[k]...)
+        sort.Strings(values)
+        for _, v := range values {
+            parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+        }
+    }
+    return strings.Join(parts, "&")
+}
+
+// put writes an object
+func (c *s3Client) put(key string, data []byte, contentType string) error {
+    _, err := c.do(http.MethodPut, c.objectURL(key, nil), data, contentType)
+    return err
+}
+
+// delete removes an object
+func (c *s3Client) delete(key string) error {
+    _, err := c.do(http.MethodDelete, c.objectURL(key, nil), nil, "")
+    return err
+}
+
+// An s3Object is an object returned by list
+type s3Object struct {
+    Key          string    `xml:"Key"`
+    LastModified time.Time `xml:"LastModified"`
+    Size         int64     `xml:"Size"`
+}
+
+// list returns all the objects whose key starts with prefix
+func (c *s3Client) list(prefix string) ([]s3Object, error) {
+    var (
+        res   []s3Object
+        token string
+    )
+    for {
+        query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+        if token != "" {
+            query.Set("continuation-token", token)
+        }
+        data, err := c.do(http.MethodGet, c.objectURL("", query), nil, "")
+        if err != nil {
+            return nil, err
+        }
+        var page struct {
+            Contents              []s3Object `xml:"Contents"`
+            IsTruncated           bool       `xml:"IsTruncated"`
+            NextContinuationToken string     `xml:"NextContinuationToken"`
+        }
+        if err := xml.Unmarshal(data, &page); err != nil {
+            return nil, fmt.Errorf("unable to parse object list: %s", err)
+        }
+        res = append(res, page.Contents...)
+        if !page.IsTruncated || page.NextContinuationToken == "" {
+            return res, nil
+        }
+        token = page.NextContinuationToken
+    }
+}
+
+// A backupUploader periodically writes the checkpoints, the KPI history and
+// the audit log of each simulation to object storage, and deletes the
+// objects older than the retention.
+//
+// KPI snapshots and audit entries are uploaded incrementally: each object
+// holds those recorded since the previous successful upload. Audit entries
+// dropped from the audit log in between are lost.
+type backupUploader struct {
+    config  BackupConfig
+    s3      *s3Client
+    trigger chan struct{}
+
+    // runMutex prevents concurrent backups
+    runMutex sync.Mutex
+
+    mutex        sync.Mutex
+    lastAuditIDs map[string]int64
+    lastKPIs     map[string]time.Time
+    backups      int
+    uploads      int
+    bytes        int64
+    deleted      int
+    errors       int
+    lastError    string
+    lastBackupAt time.Time
+    running      bool
+}
+
+var (
+    backups      *backupUploader
+    backupsMutex sync.RWMutex
+)
+
+// SetBackupConfig configures the backup to object storage. Backups start
+// when the server runs.
+func SetBackupConfig(bc BackupConfig) error {
+    if err := bc.validate(); err != nil {
+        return err
+    }
+    if bc.Prefix != "" && !strings.HasSuffix(bc.Prefix, "/") {
+        bc.Prefix += "/"
+    }
+    backupsMutex.Lock()
+    defer backupsMutex.Unlock()
+    backups = &backupUploader{
+        config:       bc,
+        s3:           &s3Client{config: bc, client: &http.Client{Timeout: 5 * time.Minute}},
+        trigger:      make(chan struct{}, 1),
+        lastAuditIDs: make(map[string]int64),
+        lastKPIs:     make(map[string]time.Time),
+    }
+    return nil
+}
+
+// currentBackupUploader returns the backup uploader or nil if backups are
+// not configured
+func currentBackupUploader() *backupUploader {
+    backupsMutex.RLock()
+    defer backupsMutex.RUnlock()
+    return backups
+}
+
+// startBackupUploader starts the periodic backups if they are configured
+func startBackupUploader() {
+    if bu := currentBackupUploader(); bu != nil {
+        go bu.run()
+    }
+}
+
+// run backs up the simulations every interval and when triggered
+func (bu *backupUploader) run() {
+    ticker := time.NewTicker(bu.config.Interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+        case <-bu.trigger:
+        }
+        bu.backup()
+    }
+}
+
+// requestBackup starts a backup as soon as possible. It returns false if a
+// backup is already requested.
+func (bu *backupUploader) requestBackup() bool {
+    select {
+    case bu.trigger <- struct{}{}:
+        return true
+    default:
+        return false
+    }
+}
+
+// backup uploads the data of all simulations, then applies the retention
+func (bu *backupUploader) backup() {
+    bu.runMutex.Lock()
+    defer bu.runMutex.Unlock()
+    bu.mutex.Lock()
+    bu.running = true
+    bu.mutex.Unlock()
+    now := time.Now().UTC()
+    var errs []string
+    for _, h := range simulations.list() {
+        if err := bu.backupSimulation(h, now); err != nil {
+            errs = append(errs, err.Error())
+        }
+    }
+    if bu.config.Retention > 0 {
+        for _, h := range simulations.list() {
+            if err := bu.applyRetention(h.id, now); err != nil {
+                errs = append(errs, err.Error())
+            }
+        }
+    }
+    bu.mutex.Lock()
+    bu.running = false
+    bu.backups++
+    bu.lastBackupAt = now
+    if len(errs) > 0 {
+        bu.errors += len(errs)
+        bu.lastError = strings.Join(errs, "; ")
+    } else {
+        bu.lastError = ""
+    }
+    bu.mutex.Unlock()
+    if len(errs) > 0 {
+        logger.Warn("Backup failed", "submodule", "backup", "errors", errs)
+        return
+    }
+    logger.Info("Backup done", "submodule", "backup")
+}
+
+// objectKey returns the key of the object of the given kind of simulation simID
+func (bu *backupUploader) objectKey(simID, kind string, t time.Time, ext string) string {
+    return fmt.Sprintf("%s%s/%s/%s.%s", bu.config.Prefix, simID, kind, t.Format(backupTimeFormat), ext)
+}
+
+// upload writes an object and updates the statistics
+func (bu *backupUploader) upload(key string, data []byte, contentType string) error {
+    if err := bu.s3.put(key, data, contentType); err != nil {
+        return err
+    }
+    bu.mutex.Lock()
+    bu.uploads++
+    bu.bytes += int64(len(data))
+    bu.mutex.Unlock()
+    return nil
+}
+
+// backupSimulation uploads the checkpoint of the simulation of h and its KPI
+// snapshots and audit entries recorded since the last backup.
+func (bu *backupUploader) backupSimulation(h *Hub, now time.Time) error {
+    data, _, err := h.checkpoint()
+    if err != nil {
+        return fmt.Errorf("%s: unable to take checkpoint: %s", h.id, err)
+    }
+    if err := bu.upload(bu.objectKey(h.id, backupCheckpoints, now, "json"), data, "application/json"); err != nil {
+        return fmt.Errorf("%s: %s", h.id, err)
+    }
+
+    bu.mutex.Lock()
+    lastKPI := bu.lastKPIs[h.id]
+    lastAuditID := bu.lastAuditIDs[h.id]
+    bu.mutex.Unlock()
+
+    h.metrics.mu.RLock()
+    var snapshots []map[string]interface{}
+    for _, s := range h.metrics.snapshots {
+        if !s.ts.After(lastKPI) {
+            continue
+        }
+        snapshots = append(snapshots, map[string]interface{}{
+            "timestamp":      s.ts.Format(time.RFC3339),
+            "simTime":        s.simTime,
+            "kpis":           s.kpis(),
+            "weather":        s.weather,
+            "signallingMode": s.signallingMode,
+        })
+        lastKPI = s.ts
+    }
+    h.metrics.mu.RUnlock()
+    if len(snapshots) > 0 {
+        data, _ := json.Marshal(map[string]interface{}{"simulation": h.id, "snapshots": snapshots})
+        if err := bu.upload(bu.objectKey(h.id, backupKPI, now, "json"), data, "application/json"); err != nil {
+            return fmt.Errorf("%s: %s", h.id, err)
+        }
+        bu.mutex.Lock()
+        bu.lastKPIs[h.id] = lastKPI
+        bu.mutex.Unlock()
+    }
+
+    entries := h.audits.getSince(lastAuditID, h.audits.capacity)
+    if len(entries) > 0 {
+        var buf bytes.Buffer
+        enc := json.NewEncoder(&buf)
+        for _, e := range entries {
+            _ = enc.Encode(e)
+        }
+        if err := bu.upload(bu.objectKey(h.id, backupAudit, now, "jsonl"), buf.Bytes(), "application/x-ndjson"); err != nil {
+            return fmt.Errorf("%s: %s", h.id, err)
+        }
+        id, _ := strconv.ParseInt(entries[len(entries)-1].ID, 10, 64)
+        bu.mutex.Lock()
+        bu.lastAuditIDs[h.id] = id
+        bu.mutex.Unlock()
+    }
+    return nil
+}
+
+// applyRetention deletes the objects of simulation simID older than the
+// retention, keeping the KeepLast most recent objects of each kind.
+func (bu *backupUploader) applyRetention(simID string, now time.Time) error {
+    limit := now.Add(-bu.config.Retention)
+    for _, kind := range []string{backupCheckpoints, backupKPI, backupAudit} {
+        objects, err := bu.s3.list(bu.config.Prefix + simID + "/" + kind + "/")
+        if err != nil {
+            return fmt.Errorf("%s: %s", simID, err)
+        }
+        sort.Slice(objects, func(i, j int) bool {
+            return objects[i].LastModified.After(objects[j].LastModified)
+        })
+        for i, o := range objects {
+            if i < bu.config.KeepLast || o.LastModified.After(limit) {
+                continue
+            }
+            if err := bu.s3.delete(o.Key); err != nil {
+                return fmt.Errorf("%s: %s", simID, err)
+            }
+            bu.mutex.Lock()
+            bu.deleted++
+            bu.mutex.Unlock()
+        }
+    }
+    return nil
+}
+
+// view returns the status of the backups for the API
+func (bu *backupUploader) view() map[string]interface{} {
+    bu.mutex.Lock()
+    defer bu.mutex.Unlock()
+    res := map[string]interface{}{
+        "enabled":          true,
+        "endpoint":         bu.config.Endpoint,
+        "bucket":           bu.config.Bucket,
+        "region":           bu.config.Region,
+        "prefix":           bu.config.Prefix,
+        "pathStyle":        bu.config.PathStyle,
+        "intervalSeconds":  bu.config.Interval.Seconds(),
+        "retentionSeconds": bu.config.Retention.Seconds(),
+        "keepLast":         bu.config.KeepLast,
+        "running":          bu.running,
+        "stats": map[string]interface{}{
+            "backups":   bu.backups,
+            "uploads":   bu.uploads,
+            "bytes":     bu.bytes,
+            "deleted":   bu.deleted,
+            "errors":    bu.errors,
+            "lastError": bu.lastError,
+        },
+    }
+    if !bu.lastBackupAt.IsZero() {
+        res["lastBackupAt"] = bu.lastBackupAt.Format(time.RFC3339)
+    }
+    return res
+}
+
+// GET /api/backups
+// POST /api/backups
+//
+// GET returns the configuration and statistics of the backups. POST starts
+// a backup at once.
+func serveBackups(w http.ResponseWriter, r *http.Request) {
+    bu := currentBackupUploader()
+    switch r.Method {
+    case http.MethodGet:
+        res := map[string]interface{}{"enabled": false}
+        if bu != nil {
+            res = bu.view()
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(res)
+    case http.MethodPost:
+        if bu == nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, "Backups are not configured", nil)
+            return
+        }
+        bu.requestBackup()
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.WriteHeader(http.StatusAccepted)
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// breakpointRequest is the body of a breakpoint creation request, from HTTP
+// or from the hub.
+type breakpointRequest struct {
+    Type      string `json:"type"`
+    Time      string `json:"time"`
+    TrainID   string `json:"trainId"`
+    PlaceCode string `json:"placeCode"`
+}
+
+// addBreakpoint creates the breakpoint described by br and adds it to the
+// simulation s. The time of TIME breakpoints is a date time of the simulation
+// or the next occurrence of a time of day.
+func addBreakpoint(s *simulation.Simulation, br breakpointRequest) (*simulation.Breakpoint, error) {
+    b := &simulation.Breakpoint{
+        Type:      simulation.BreakpointType(strings.ToUpper(br.Type)),
+        TrainID:   br.TrainID,
+        PlaceCode: br.PlaceCode,
+    }
+    if br.Time != "" {
+        t, ok := s.ParseDateTime(br.Time)
+        if !ok {
+            var err error
+            t, err = nextTimeOfDay(s.Options.CurrentTime.Time, br.Time)
+            if err != nil {
+                return nil, err
+            }
+        }
+        b.Time.Time = t
+    }
+    if err := s.AddBreakpoint(b); err != nil {
+        return nil, err
+    }
+    return b, nil
+}
+
+// GET /api/simulation/breakpoints
+// POST /api/simulation/breakpoints
+func serveBreakpoints(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.Breakpoints()})
+    case http.MethodPost:
+        var body breakpointRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        b, err := addBreakpoint(h.sim, body)
+        if err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", resourceLocation(r, "/api/simulation/breakpoints/"+b.ID()))
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(b)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// DELETE /api/simulation/breakpoints/{id}
+func serveBreakpoint(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodDelete {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/simulation/breakpoints/")
+    if err := h.sim.RemoveBreakpoint(id); err != nil {
+        writeAPIError(w, http.StatusNotFound, ErrCodeBreakpointNotFound, "Breakpoint not found", map[string]interface{}{"breakpointId": id})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+}
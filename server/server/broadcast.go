@@ -0,0 +1,118 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// encodeBuffers holds the buffers in which the messages written to the
+// websocket connections are encoded.
+var encodeBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getEncodeBuffer returns an empty buffer from the pool. It must be given
+// back with putEncodeBuffer once its content has been written.
+func getEncodeBuffer() *bytes.Buffer {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putEncodeBuffer gives back buf to the pool. Buffers grown by very large
+// messages, such as dumps, are dropped to keep the pool small.
+func putEncodeBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > 64*1024 {
+		return
+	}
+	encodeBuffers.Put(buf)
+}
+
+// encodedNotification holds the encodings of a broadcast notification.
+//
+// A broadcast notification is sent to all the connections listening to its
+// event, so it is encoded once for all of them instead of once per
+// connection. It must not be modified after it is created.
+type encodedNotification struct {
+	json []byte
+
+	msgpackOnce sync.Once
+	msgpack     []byte
+	msgpackErr  error
+}
+
+// msgpackData returns the MessagePack encoding of the notification,
+// computing it the first time it is needed.
+func (en *encodedNotification) msgpackData() ([]byte, error) {
+	en.msgpackOnce.Do(func() {
+		en.msgpack, en.msgpackErr = marshalMsgpack(RawJSON(en.json))
+	})
+	return en.msgpack, en.msgpackErr
+}
+
+// newBroadcastNotification returns the notification of the given recorded
+// event, to be sent to all its listeners.
+//
+// Its JSON encoding is built from the object encoded when the event was sent,
+// so that the object is marshalled only once per event, and clients get the
+// state of the object at the time of the event, as when they resume.
+func newBroadcastNotification(se *sequencedEvent) *ResponseNotification {
+	n := se.notification()
+	n.objectID = se.objectID
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(n); err != nil {
+		logger.Error("Unable to encode notification", "submodule", "hub", "event", se.name, "error", err)
+		return n
+	}
+	// Drop the newline added by the encoder
+	data := make([]byte, buf.Len()-1)
+	copy(data, buf.Bytes())
+	n.encoded = &encodedNotification{json: data}
+	return n
+}
+
+// encodeMessage encodes msg for the websocket protocol of a connection, in
+// MessagePack if useMsgpack is true and in JSON otherwise. The encoding may
+// be written in buf, and is only valid until buf is reused.
+func encodeMessage(msg interface{}, useMsgpack bool, buf *bytes.Buffer) (data []byte, messageType int, err error) {
+	if useMsgpack {
+		if n, ok := msg.(*ResponseNotification); ok && n.encoded != nil {
+			data, err = n.encoded.msgpackData()
+			return data, websocket.BinaryMessage, err
+		}
+		data, err = marshalMsgpack(msg)
+		return data, websocket.BinaryMessage, err
+	}
+	if n, ok := msg.(*ResponseNotification); ok && n.encoded != nil {
+		return n.encoded.json, websocket.TextMessage, nil
+	}
+	if err := json.NewEncoder(buf).Encode(msg); err != nil {
+		return nil, websocket.TextMessage, err
+	}
+	return buf.Bytes()[:buf.Len()-1], websocket.TextMessage, nil
+}
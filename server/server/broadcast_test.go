@@ -0,0 +1,169 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// loadBenchmarkSimulation returns the demo simulation with its trains
+// duplicated until it has the given number of trains.
+func loadBenchmarkSimulation(tb testing.TB, trains int) *simulation.Simulation {
+	data, err := ioutil.ReadFile("../simulation/testdata/demo.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		tb.Fatal(err)
+	}
+	demoTrains := raw["trains"].([]interface{})
+	for i := len(demoTrains); i < trains; i++ {
+		tr := make(map[string]interface{})
+		for k, v := range demoTrains[i%len(demoTrains)].(map[string]interface{}) {
+			tr[k] = v
+		}
+		tr["trainId"] = fmt.Sprint(i)
+		demoTrains = append(demoTrains, tr)
+	}
+	raw["trains"] = demoTrains
+	data, _ = json.Marshal(raw)
+	s := new(simulation.Simulation)
+	if err := json.Unmarshal(data, s); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// newBenchmarkHub returns a hub of s with the given number of connections
+// listening to the changes of all trains.
+func newBenchmarkHub(s *simulation.Simulation, clients int) (*Hub, []*connection) {
+	h := newHub("benchmark")
+	h.sim = s
+	conns := make([]*connection, clients)
+	listeners := make(map[*connection]*ListenerFilter)
+	for i := range conns {
+		conns[i] = &connection{pushChan: make(chan interface{}, 256)}
+		listeners[conns[i]] = &ListenerFilter{}
+	}
+	h.registry[registryEntry{eventName: simulation.TrainChangedEvent}] = listeners
+	return h, conns
+}
+
+// broadcastTick notifies the changes of all the trains of h and encodes the
+// notifications received by each connection, as a simulation step does.
+func broadcastTick(tb testing.TB, h *Hub, conns []*connection, useMsgpack bool) {
+	for _, tr := range h.sim.Trains {
+		h.notifyClients(&simulation.Event{Name: simulation.TrainChangedEvent, Object: tr})
+	}
+	buf := new(bytes.Buffer)
+	for _, c := range conns {
+		for len(c.pushChan) > 0 {
+			buf.Reset()
+			if _, _, err := encodeMessage(<-c.pushChan, useMsgpack, buf); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestBroadcastNotifications(t *testing.T) {
+	Convey("Testing broadcast notifications", t, func() {
+		s := loadBenchmarkSimulation(t, 10)
+		So(s.Trains, ShouldHaveLength, 10)
+		h, conns := newBenchmarkHub(s, 3)
+		h.notifyClients(&simulation.Event{Name: simulation.TrainChangedEvent, Object: s.Trains[3]})
+		Convey("All listeners should get the same pre-encoded notification", func() {
+			var msgs []interface{}
+			for _, c := range conns {
+				So(c.pushChan, ShouldHaveLength, 1)
+				msgs = append(msgs, <-c.pushChan)
+			}
+			So(msgs[1], ShouldEqual, msgs[0])
+			So(msgs[2], ShouldEqual, msgs[0])
+			n := msgs[0].(*ResponseNotification)
+			So(n.encoded, ShouldNotBeNil)
+			// The notification holds the encoded train, not the train itself
+			object, err := json.Marshal(s.Trains[3])
+			So(err, ShouldBeNil)
+			So(n.Data.Object, ShouldResemble, RawJSON(object))
+
+			expected, err := json.Marshal(&ResponseNotification{
+				MsgType: TypeNotification,
+				Seq:     n.Seq,
+				Data:    DataEvent{Name: simulation.TrainChangedEvent, Object: s.Trains[3]},
+			})
+			So(err, ShouldBeNil)
+			data, messageType, err := encodeMessage(n, false, new(bytes.Buffer))
+			So(err, ShouldBeNil)
+			So(messageType, ShouldEqual, websocket.TextMessage)
+			So(string(data), ShouldEqual, string(expected))
+
+			expectedMsgpack, err := marshalMsgpack(json.RawMessage(expected))
+			So(err, ShouldBeNil)
+			data, messageType, err = encodeMessage(n, true, new(bytes.Buffer))
+			So(err, ShouldBeNil)
+			So(messageType, ShouldEqual, websocket.BinaryMessage)
+			So(data, ShouldResemble, expectedMsgpack)
+		})
+		Convey("Other messages should be encoded as before", func() {
+			msg := NewOkResponse(12, "OK")
+			expected, _ := json.Marshal(msg)
+			data, _, err := encodeMessage(msg, false, new(bytes.Buffer))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, string(expected))
+		})
+		Convey("The replay buffer should keep the events in order once full", func() {
+			for i := 0; i < replayBufferSize+3; i++ {
+				h.notifyClients(&simulation.Event{Name: simulation.TrainChangedEvent, Object: s.Trains[i%10]})
+				for _, c := range conns {
+					<-c.pushChan
+				}
+			}
+			last := h.replay.lastSeq
+			events, err := h.replay.since(last - 5)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 5)
+			for i, se := range events {
+				So(se.seq, ShouldEqual, last-4+uint64(i))
+			}
+			events, err = h.replay.since(last - replayBufferSize)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, replayBufferSize)
+			So(events[0].seq, ShouldEqual, last-replayBufferSize+1)
+			_, err = h.replay.since(last - replayBufferSize - 1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// BenchmarkBroadcastTick measures the broadcast of the changes of the trains
+// of a 100-train simulation to 10 JSON clients.
+func BenchmarkBroadcastTick(b *testing.B) {
+	h, conns := newBenchmarkHub(loadBenchmarkSimulation(b, 100), 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		broadcastTick(b, h, conns, false)
+	}
+}
+
+// BenchmarkBroadcastTickMsgpack measures the broadcast of the changes of the
+// trains of a 100-train simulation to 10 MessagePack clients.
+func BenchmarkBroadcastTickMsgpack(b *testing.B) {
+	h, conns := newBenchmarkHub(loadBenchmarkSimulation(b, 100), 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		broadcastTick(b, h, conns, true)
+	}
+}
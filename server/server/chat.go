@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// ChatMessageEvent is sent to its listeners when a client sends a message
+const ChatMessageEvent simulation.EventName = "chatMessage"
+
+const (
+	// maxChatMessages is the number of messages kept for each simulation
+	maxChatMessages = 200
+	// maxChatLength is the maximum length of a message in characters
+	maxChatLength = 1000
+)
+
+// errChatNoUser is returned when a client without user sends a message
+var errChatNoUser = errors.New("register with a user to send messages")
+
+// A ChatAttachment is the object of the simulation a message is about
+type ChatAttachment struct {
+	// Type is train, route, trackItem or service
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// object returns the object of simulation s this attachment refers to
+func (a *ChatAttachment) object(s *simulation.Simulation) (simulation.SimObject, error) {
+	switch a.Type {
+	case "train":
+		idx, err := strconv.Atoi(a.ID)
+		if err != nil || idx < 0 || idx >= len(s.Trains) {
+			return nil, fmt.Errorf("unknown train: %s", a.ID)
+		}
+		return s.Trains[idx], nil
+	case "route":
+		if rte, ok := s.Routes[a.ID]; ok {
+			return rte, nil
+		}
+		return nil, fmt.Errorf("unknown route: %s", a.ID)
+	case "trackItem":
+		if ti, ok := s.TrackItems[a.ID]; ok {
+			return ti, nil
+		}
+		return nil, fmt.Errorf("unknown track item: %s", a.ID)
+	case "service":
+		if svc, ok := s.Services[a.ID]; ok {
+			return svc, nil
+		}
+		return nil, fmt.Errorf("unknown service: %s", a.ID)
+	}
+	return nil, fmt.Errorf("unknown object type: %s", a.Type)
+}
+
+// A ChatMessage is a text message sent by a client to the other clients of
+// a simulation, or to a single user.
+type ChatMessage struct {
+	MsgID string     `json:"id"`
+	From  string     `json:"from"`
+	Role  ClientRole `json:"role"`
+	// To is the user the message is sent to, or empty if it is sent to all
+	// the clients.
+	To      string          `json:"to,omitempty"`
+	Text    string          `json:"text"`
+	Object  *ChatAttachment `json:"object,omitempty"`
+	SimTime string          `json:"simTime"`
+	SentAt  string          `json:"sentAt"`
+}
+
+// ID returns the ID of the message
+func (m *ChatMessage) ID() string {
+	return m.MsgID
+}
+
+// visibleTo returns true if the given user may read this message. A nil
+// message is visible to all.
+func (m *ChatMessage) visibleTo(user string) bool {
+	if m == nil || m.To == "" {
+		return true
+	}
+	return user != "" && (m.To == user || m.From == user)
+}
+
+// privateChatMessage returns the object of e if it is a message sent to a
+// single user, and nil otherwise.
+func privateChatMessage(e *simulation.Event) *ChatMessage {
+	if m, ok := e.Object.(*ChatMessage); ok && m.To != "" {
+		return m
+	}
+	return nil
+}
+
+// A chatRequest is the content of a message to send
+type chatRequest struct {
+	Text   string          `json:"text"`
+	To     string          `json:"to"`
+	Object *ChatAttachment `json:"object"`
+}
+
+// chatState holds the last messages of a simulation
+type chatState struct {
+	mu       sync.RWMutex
+	nextID   int
+	messages []*ChatMessage
+}
+
+// chats holds the messages of the default simulation
+var chats = newChatState()
+
+// newChatState returns a chatState without messages
+func newChatState() *chatState {
+	return &chatState{nextID: 1}
+}
+
+// sendChatMessage sends the message of cr from the given user to the clients
+// of the simulation of h.
+//
+// sendChatMessage must not be called from the hub loop.
+func (h *Hub) sendChatMessage(from string, role ClientRole, cr chatRequest) (*ChatMessage, error) {
+	if from == "" {
+		return nil, errChatNoUser
+	}
+	text := strings.TrimSpace(cr.Text)
+	if text == "" {
+		return nil, errors.New("empty message")
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return nil, fmt.Errorf("message longer than %d characters", maxChatLength)
+	}
+	if cr.Object != nil {
+		if _, err := cr.Object.object(h.sim); err != nil {
+			return nil, err
+		}
+	}
+	msg := &ChatMessage{
+		From:    from,
+		Role:    role,
+		To:      strings.TrimSpace(cr.To),
+		Text:    text,
+		Object:  cr.Object,
+		SimTime: h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
+		SentAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	cs := h.chats
+	cs.mu.Lock()
+	msg.MsgID = strconv.Itoa(cs.nextID)
+	cs.nextID++
+	cs.messages = append(cs.messages, msg)
+	if len(cs.messages) > maxChatMessages {
+		cs.messages = cs.messages[len(cs.messages)-maxChatMessages:]
+	}
+	cs.mu.Unlock()
+	select {
+	case h.events <- &simulation.Event{Name: ChatMessageEvent, Object: msg}:
+	case <-h.done:
+	}
+	return msg, nil
+}
+
+// chatHistory returns the last limit messages that user may read, oldest
+// first. All the messages are returned if limit is 0.
+func (h *Hub) chatHistory(user string, limit int) []*ChatMessage {
+	cs := h.chats
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	res := []*ChatMessage{}
+	for _, m := range cs.messages {
+		if m.visibleTo(user) {
+			res = append(res, m)
+		}
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res
+}
+
+// GET /api/chat?limit=N
+// POST /api/chat
+//
+// GET returns the last messages of the simulation that the user of
+// the X-User-ID header may read. POST sends the message of the body from
+// this user.
+func serveChat(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	user, role := clientIdentity(r)
+	switch r.Method {
+	case http.MethodGet:
+		limit := 0
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit < 0 {
+				invalidParameter(w, "limit must be a positive integer", map[string]interface{}{"limit": l})
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.chatHistory(user, limit)})
+	case http.MethodPost:
+		var cr chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+			badRequest(w, err)
+			return
+		}
+		msg, err := h.sendChatMessage(user, ClientRole(role), cr)
+		if err != nil {
+			invalidParameter(w, err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(msg)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestChat(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing messages between clients", t, func() {
+		So(RoleObserver.allows("chat", "history"), ShouldBeTrue)
+		So(RoleObserver.allows("chat", "send"), ShouldBeFalse)
+		So(RoleOperator.allows("chat", "send"), ShouldBeTrue)
+
+		So(AddSimulation("chat", loadDemoWith(nil)), ShouldBeNil)
+		h, _ := simulations.get("chat")
+		defer func() { So(simulations.remove("chat"), ShouldBeNil) }()
+
+		Convey("Messages should be checked and kept in the history", func() {
+			_, err := h.sendChatMessage("", RoleOperator, chatRequest{Text: "Hello"})
+			So(err, ShouldEqual, errChatNoUser)
+			_, err = h.sendChatMessage("alice", RoleOperator, chatRequest{Text: "  "})
+			So(err, ShouldNotBeNil)
+			_, err = h.sendChatMessage("alice", RoleOperator, chatRequest{Text: strings.Repeat("a", maxChatLength+1)})
+			So(err, ShouldNotBeNil)
+			_, err = h.sendChatMessage("alice", RoleOperator, chatRequest{Text: "Hello", Object: &ChatAttachment{Type: "train", ID: "99"}})
+			So(err, ShouldNotBeNil)
+			_, err = h.sendChatMessage("alice", RoleOperator, chatRequest{Text: "Hello", Object: &ChatAttachment{Type: "depot", ID: "1"}})
+			So(err, ShouldNotBeNil)
+
+			msg, err := h.sendChatMessage("alice", RoleOperator, chatRequest{Text: " Signal 5 is failed ", Object: &ChatAttachment{Type: "trackItem", ID: "5"}})
+			So(err, ShouldBeNil)
+			So(msg.ID(), ShouldEqual, "1")
+			So(msg.Text, ShouldEqual, "Signal 5 is failed")
+			So(msg.SimTime, ShouldEqual, "06:00:00")
+			private, err := h.sendChatMessage("carol", RoleAdmin, chatRequest{Text: "Hold train 0", To: "bob", Object: &ChatAttachment{Type: "train", ID: "0"}})
+			So(err, ShouldBeNil)
+			So(h.chatHistory("bob", 0), ShouldHaveLength, 2)
+			So(h.chatHistory("carol", 0), ShouldHaveLength, 2)
+			So(h.chatHistory("alice", 0), ShouldHaveLength, 1)
+			So(h.chatHistory("", 0), ShouldHaveLength, 1)
+			So(h.chatHistory("bob", 1)[0], ShouldEqual, private)
+
+			e := &simulation.Event{Name: ChatMessageEvent, Object: private}
+			So(privateChatMessage(e), ShouldEqual, private)
+			So((&ListenerFilter{TrainIDs: []string{"0"}}).matches(h.sim, h.eventSubject(e)), ShouldBeTrue)
+			So((&ListenerFilter{TrainIDs: []string{"1"}}).matches(h.sim, h.eventSubject(e)), ShouldBeFalse)
+			So(privateChatMessage(&simulation.Event{Name: ChatMessageEvent, Object: msg}), ShouldBeNil)
+
+			var found bool
+			for i := 0; i < 20 && !found; i++ {
+				for _, entry := range h.audits.getSince(0, 1000) {
+					if entry.Event == "CHAT_MESSAGE" && entry.Object["id"] == private.ID() {
+						found = true
+						So(entry.Details["to"], ShouldEqual, "bob")
+						So(entry.Details["objectType"], ShouldEqual, "train")
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			So(found, ShouldBeTrue)
+		})
+		Convey("Messages should be sent to their recipients", func() {
+			// dial registers a client of the chat simulation listening to messages
+			dial := func(user string) *websocket.Conn {
+				c := clientDial(t)
+				So(c.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: "client-secret", Role: "operator", User: user, SimID: "chat"}}), ShouldBeNil)
+				var resp ResponseStatus
+				So(c.ReadJSON(&resp), ShouldBeNil)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				resp = sendRequestStatus(c, "server", "addListener", `{"event": "chatMessage"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				return c
+			}
+			// next returns the text of the next message received by c
+			next := func(c *websocket.Conn) string {
+				var n struct {
+					Data struct {
+						Name   simulation.EventName `json:"name"`
+						Object ChatMessage          `json:"object"`
+					} `json:"data"`
+				}
+				So(c.ReadJSON(&n), ShouldBeNil)
+				So(n.Data.Name, ShouldEqual, ChatMessageEvent)
+				return n.Data.Object.Text
+			}
+			alice := clientDial(t)
+			defer alice.Close()
+			So(alice.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: "client-secret", Role: "operator", User: "alice", SimID: "chat"}}), ShouldBeNil)
+			var resp ResponseStatus
+			So(alice.ReadJSON(&resp), ShouldBeNil)
+			bob := dial("bob")
+			defer bob.Close()
+			carol := dial("carol")
+			defer carol.Close()
+
+			resp = sendRequestStatus(alice, "chat", "send", `{"text": "Only for bob", "to": "bob"}`)
+			So(resp.MsgType, ShouldEqual, TypeResponse)
+			resp = sendRequestStatus(alice, "chat", "send", `{"text": "For everyone"}`)
+			So(resp.MsgType, ShouldEqual, TypeResponse)
+			So(next(bob), ShouldEqual, "Only for bob")
+			So(next(bob), ShouldEqual, "For everyone")
+			So(next(carol), ShouldEqual, "For everyone")
+
+			So(alice.WriteJSON(Request{ID: 5, Object: "chat", Action: "history", Params: RawJSON(`{"limit": 10}`)}), ShouldBeNil)
+			var history struct {
+				Data []ChatMessage `json:"data"`
+			}
+			So(alice.ReadJSON(&history), ShouldBeNil)
+			So(history.Data, ShouldHaveLength, 2)
+			So(history.Data[0].To, ShouldEqual, "bob")
+		})
+		Convey("Messages should be sent and read over HTTP", func() {
+			post := func(user, body string) *http.Response {
+				req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:22222/api/chat", strings.NewReader(body))
+				if user != "" {
+					req.Header.Set("X-User-ID", user)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				return resp
+			}
+			So(post("", `{"text": "Hello"}`).StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(post("instructor", `{"text": "Hello", "object": {"type": "route", "id": "XX"}}`).StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(post("instructor", `{"text": "Secret", "to": "trainee"}`).StatusCode, ShouldEqual, http.StatusCreated)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:22222/api/chat?limit=1", nil)
+			req.Header.Set("X-User-ID", "trainee")
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []ChatMessage `json:"items"`
+			}
+			So(json.NewDecoder(resp.Body).Decode(&list), ShouldBeNil)
+			resp.Body.Close()
+			So(list.Items, ShouldHaveLength, 1)
+			So(list.Items[0].Text, ShouldEqual, "Secret")
+			So(list.Items[0].From, ShouldEqual, "instructor")
+			for _, m := range hub.chatHistory("", 0) {
+				So(m.Text, ShouldNotEqual, "Secret")
+			}
+		})
+	})
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// checkpointDir is the directory in which checkpoints are saved, with one
+// sub-directory per simulation.
+var (
+    checkpointDir      = "checkpoints"
+    checkpointDirMutex sync.RWMutex
+)
+
+// SetCheckpointDir sets the directory in which the checkpoints of the
+// simulations are saved. It is created when the first checkpoint is saved.
+func SetCheckpointDir(dir string) error {
+    if dir == "" {
+        return fmt.Errorf("checkpoint directory cannot be empty")
+    }
+    checkpointDirMutex.Lock()
+    defer checkpointDirMutex.Unlock()
+    checkpointDir = dir
+    return nil
+}
+
+// checkpointsDir returns the directory holding the checkpoints of the given simulation
+func checkpointsDir(simID string) string {
+    checkpointDirMutex.RLock()
+    defer checkpointDirMutex.RUnlock()
+    return filepath.Join(checkpointDir, simID)
+}
+
+// checkpointPath returns the path of the checkpoint with the given name.
+// Checkpoint names follow the same rules as simulation IDs.
+func checkpointPath(simID, name string) (string, error) {
+    if !simulationIDPattern.MatchString(name) {
+        return "", fmt.Errorf("invalid checkpoint name %q", name)
+    }
+    return filepath.Join(checkpointsDir(simID), name+".json"), nil
+}
+
+// A checkpointInfo describes a checkpoint file
+type checkpointInfo struct {
+    Name           string    `json:"name"`
+    SavedAt        time.Time `json:"savedAt"`
+    Size           int64     `json:"size"`
+    SimulationTime string    `json:"simulationTime,omitempty"`
+}
+
+// saveCheckpoint saves the complete state of the simulation of h under the
+// given name, replacing any checkpoint with the same name. A name is
+// generated from the current date if name is empty. The simulation is paused
+// while its state is saved.
+func (h *Hub) saveCheckpoint(name string) (checkpointInfo, error) {
+    if name == "" {
+        name = "ckpt_" + time.Now().UTC().Format("20060102T150405")
+    }
+    path, err := checkpointPath(h.id, name)
+    if err != nil {
+        return checkpointInfo{}, err
+    }
+    data, simTime, err := h.checkpoint()
+    if err != nil {
+        return checkpointInfo{}, err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return checkpointInfo{}, fmt.Errorf("unable to create checkpoint directory: %s", err)
+    }
+    // Write to a temporary file first so that a failed save does not
+    // corrupt an existing checkpoint.
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return checkpointInfo{}, fmt.Errorf("unable to write checkpoint: %s", err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        return checkpointInfo{}, fmt.Errorf("unable to write checkpoint: %s", err)
+    }
+    logger.Info("Checkpoint saved", "simulation", h.id, "checkpoint", name, "file", path)
+    return checkpointInfo{
+        Name:           name,
+        SavedAt:        time.Now().UTC(),
+        Size:           int64(len(data)),
+        SimulationTime: simTime,
+    }, nil
+}
+
+// checkpoint returns the complete state of the simulation of h and its
+// formatted simulation time. The simulation is paused while its state is
+// taken.
+func (h *Hub) checkpoint() ([]byte, string, error) {
+    h.restartMutex.Lock()
+    defer h.restartMutex.Unlock()
+    started := h.sim.IsStarted()
+    if started {
+        h.sim.Pause()
+    }
+    data, err := h.sim.Checkpoint()
+    simTime := h.sim.FormatTime(h.sim.Options.CurrentTime.Time)
+    if started {
+        h.sim.Start()
+    }
+    return data, simTime, err
+}
+
+// readCheckpoint returns the content of the given checkpoint of the simulation simID
+func readCheckpoint(simID, name string) ([]byte, error) {
+    path, err := checkpointPath(simID, name)
+    if err != nil {
+        return nil, err
+    }
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("unknown checkpoint %s", name)
+    }
+    return data, err
+}
+
+// checkpointExists returns true if the given checkpoint of the simulation simID exists
+func checkpointExists(simID, name string) bool {
+    path, err := checkpointPath(simID, name)
+    if err != nil {
+        return false
+    }
+    _, err = os.Stat(path)
+    return err == nil
+}
+
+// listCheckpoints returns the checkpoints of the simulation simID, most recent first
+func listCheckpoints(simID string) ([]checkpointInfo, error) {
+    files, err := ioutil.ReadDir(checkpointsDir(simID))
+    if os.IsNotExist(err) {
+        return []checkpointInfo{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    res := []checkpointInfo{}
+    for _, f := range files {
+        name := strings.TrimSuffix(f.Name(), ".json")
+        if f.IsDir() || name == f.Name() || !simulationIDPattern.MatchString(name) {
+            continue
+        }
+        res = append(res, checkpointInfo{Name: name, SavedAt: f.ModTime().UTC(), Size: f.Size()})
+    }
+    sort.Slice(res, func(i, j int) bool {
+        if !res[i].SavedAt.Equal(res[j].SavedAt) {
+            return res[i].SavedAt.After(res[j].SavedAt)
+        }
+        return res[i].Name < res[j].Name
+    })
+    return res, nil
+}
+
+// deleteCheckpoint removes the given checkpoint of the simulation simID
+func deleteCheckpoint(simID, name string) error {
+    path, err := checkpointPath(simID, name)
+    if err != nil {
+        return err
+    }
+    return os.Remove(path)
+}
+
+// GET /api/simulation/checkpoints
+// POST /api/simulation/checkpoints
+//
+// POST takes an optional body {"name": "before_peak"} and saves the current
+// state of the simulation under this name.
+func serveCheckpoints(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        items, err := listCheckpoints(h.id)
+        if err != nil {
+            internalError(w, "Failed to list checkpoints", err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    case http.MethodPost:
+        var body struct {
+            Name string `json:"name"`
+        }
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+                badRequest(w, err)
+                return
+            }
+        }
+        if body.Name != "" && !simulationIDPattern.MatchString(body.Name) {
+            invalidParameter(w, "Invalid checkpoint name", map[string]interface{}{"name": body.Name})
+            return
+        }
+        info, err := h.saveCheckpoint(body.Name)
+        if err != nil {
+            internalError(w, "Failed to save checkpoint", err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", resourceLocation(r, "/api/simulation/checkpoints/"+info.Name))
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(info)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/simulation/checkpoints/{name}
+// DELETE /api/simulation/checkpoints/{name}
+// POST /api/simulation/checkpoints/{name}/restore?autoStart=1
+//
+// GET downloads the checkpoint file.
+func serveCheckpoint(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    name := strings.TrimPrefix(r.URL.Path, "/api/simulation/checkpoints/")
+    restore := strings.HasSuffix(name, "/restore")
+    name = strings.TrimSuffix(name, "/restore")
+    if !checkpointExists(h.id, name) {
+        writeAPIError(w, http.StatusNotFound, ErrCodeCheckpointNotFound, "Checkpoint not found", map[string]interface{}{"name": name})
+        return
+    }
+    switch {
+    case restore && r.Method == http.MethodPost:
+        if err := h.restoreCheckpoint(name); err != nil {
+            invalidParameter(w, err.Error(), map[string]interface{}{"name": name})
+            return
+        }
+        if r.URL.Query().Get("autoStart") == "1" {
+            h.sim.Start()
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    case restore:
+        methodNotAllowed(w, r)
+    case r.Method == http.MethodGet:
+        data, err := readCheckpoint(h.id, name)
+        if err != nil {
+            internalError(w, "Failed to read checkpoint", err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+        _, _ = w.Write(data)
+    case r.Method == http.MethodDelete:
+        if err := deleteCheckpoint(h.id, name); err != nil {
+            internalError(w, "Failed to delete checkpoint", err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// maxCoalesceInterval is the longest coalescing interval a client may ask for.
+const maxCoalesceInterval = 10 * time.Second
+
+var (
+	// coalesceInterval is the default coalescing interval of client connections.
+	// Zero means that notifications are sent as soon as they are fired.
+	coalesceInterval      time.Duration
+	coalesceIntervalMutex sync.RWMutex
+
+	// coalescedEvents are the events which may be merged by the coalescer.
+	coalescedEvents = map[simulation.EventName]bool{
+		simulation.TrainChangedEvent:     true,
+		simulation.TrackItemChangedEvent: true,
+	}
+)
+
+// SetCoalesceInterval sets the default interval during which trainChanged and
+// trackItemChanged notifications of the same object are merged into a single
+// message. Set to zero to disable coalescing.
+func SetCoalesceInterval(d time.Duration) error {
+	if d < 0 || d > maxCoalesceInterval {
+		return fmt.Errorf("coalesce interval must be between 0 and %s", maxCoalesceInterval)
+	}
+	coalesceIntervalMutex.Lock()
+	defer coalesceIntervalMutex.Unlock()
+	coalesceInterval = d
+	return nil
+}
+
+func defaultCoalesceInterval() time.Duration {
+	coalesceIntervalMutex.RLock()
+	defer coalesceIntervalMutex.RUnlock()
+	return coalesceInterval
+}
+
+// coalesceIntervalFromMs returns the coalescing interval asked by a client at
+// registration. Zero keeps the server default and a negative value disables
+// coalescing for this connection.
+func coalesceIntervalFromMs(ms int) (time.Duration, error) {
+	switch {
+	case ms == 0:
+		return defaultCoalesceInterval(), nil
+	case ms < 0:
+		return 0, nil
+	}
+	d := time.Duration(ms) * time.Millisecond
+	if d > maxCoalesceInterval {
+		return 0, fmt.Errorf("coalesceMs must not exceed %d", maxCoalesceInterval/time.Millisecond)
+	}
+	return d, nil
+}
+
+// A coalescer buffers the notifications of a connection and keeps only the
+// latest notification of each object until it is flushed.
+//
+// It is not safe for concurrent use and is meant to be used only from the
+// writing loop of its connection.
+type coalescer struct {
+	interval time.Duration
+	pending  map[registryEntry]*ResponseNotification
+	order    []registryEntry
+}
+
+// newCoalescer returns a coalescer flushing at the given interval, or nil if
+// interval is zero.
+func newCoalescer(interval time.Duration) *coalescer {
+	if interval <= 0 {
+		return nil
+	}
+	return &coalescer{
+		interval: interval,
+		pending:  make(map[registryEntry]*ResponseNotification),
+	}
+}
+
+// add buffers the given message if it can be coalesced, replacing any pending
+// notification of the same event and object. It returns false if the message
+// must be sent right away.
+func (c *coalescer) add(msg interface{}) bool {
+	if c == nil {
+		return false
+	}
+	n, ok := msg.(*ResponseNotification)
+	if !ok {
+		return false
+	}
+	if n.Data.Name == SimulationRestartedEvent {
+		// Pending notifications are about the objects of the previous simulation
+		c.pending = make(map[registryEntry]*ResponseNotification)
+		c.order = c.order[:0]
+		return false
+	}
+	if !coalescedEvents[n.Data.Name] {
+		return false
+	}
+	if n.objectID == "" {
+		return false
+	}
+	re := registryEntry{eventName: n.Data.Name, id: n.objectID}
+	if _, exists := c.pending[re]; !exists {
+		c.order = append(c.order, re)
+	}
+	c.pending[re] = n
+	return true
+}
+
+// flush returns the pending notifications in the order their objects were
+// first changed and empties the buffer.
+func (c *coalescer) flush() []*ResponseNotification {
+	if c == nil || len(c.order) == 0 {
+		return nil
+	}
+	res := make([]*ResponseNotification, len(c.order))
+	for i, re := range c.order {
+		res[i] = c.pending[re]
+		delete(c.pending, re)
+	}
+	c.order = c.order[:0]
+	return res
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestCoalescer(t *testing.T) {
+	Convey("Testing notification coalescing", t, func() {
+		Convey("A zero interval should disable coalescing", func() {
+			c := newCoalescer(0)
+			So(c, ShouldBeNil)
+			So(c.add(NewNotificationResponse(&simulation.Event{Name: simulation.TrainChangedEvent, Object: sim.Trains[0]})), ShouldBeFalse)
+			So(c.flush(), ShouldBeEmpty)
+		})
+		Convey("Notifications of the same object should be merged", func() {
+			c := newCoalescer(100 * time.Millisecond)
+			t0 := NewNotificationResponse(&simulation.Event{Name: simulation.TrainChangedEvent, Object: sim.Trains[0]})
+			ti := NewNotificationResponse(&simulation.Event{Name: simulation.TrackItemChangedEvent, Object: sim.TrackItems["2"]})
+			t0bis := NewNotificationResponse(&simulation.Event{Name: simulation.TrainChangedEvent, Object: sim.Trains[0]})
+			So(c.add(t0), ShouldBeTrue)
+			So(c.add(ti), ShouldBeTrue)
+			So(c.add(t0bis), ShouldBeTrue)
+			res := c.flush()
+			So(res, ShouldHaveLength, 2)
+			So(res[0], ShouldEqual, t0bis)
+			So(res[1], ShouldEqual, ti)
+			So(c.flush(), ShouldBeEmpty)
+		})
+		Convey("Other messages should not be coalesced", func() {
+			c := newCoalescer(100 * time.Millisecond)
+			So(c.add(NewNotificationResponse(&simulation.Event{Name: simulation.TrainStoppedAtStationEvent, Object: sim.Trains[0]})), ShouldBeFalse)
+			So(c.add(NewOkResponse(1, "OK")), ShouldBeFalse)
+			So(c.flush(), ShouldBeEmpty)
+		})
+		Convey("Client intervals should be checked", func() {
+			So(SetCoalesceInterval(200*time.Millisecond), ShouldBeNil)
+			d, err := coalesceIntervalFromMs(0)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 200*time.Millisecond)
+			d, err = coalesceIntervalFromMs(-1)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 0)
+			d, err = coalesceIntervalFromMs(50)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 50*time.Millisecond)
+			_, err = coalesceIntervalFromMs(20000)
+			So(err, ShouldNotBeNil)
+			So(SetCoalesceInterval(-time.Second), ShouldNotBeNil)
+			So(SetCoalesceInterval(0), ShouldBeNil)
+		})
+	})
+}
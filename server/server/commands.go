@@ -0,0 +1,140 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+const maxBatchCommands = 100
+
+// A commandResult is the outcome of a single command of a batch
+type commandResult struct {
+    Index   int             `json:"index"`
+    Object  string          `json:"object"`
+    Action  string          `json:"action"`
+    Status  string          `json:"status"`
+    Message string          `json:"message,omitempty"`
+    Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// executeCommand runs the given command through the object of h it targets,
+// exactly as if it had been sent by the websocket client conn from the given
+// remote address, and returns its result. The role and the control areas of
+// the client are checked as for websocket requests.
+//
+// The server object is not reachable this way since its actions (login,
+// listeners) only make sense on a websocket connection.
+func executeCommand(h *Hub, conn *connection, remote string, index int, cmd simulation.SuggestionAction) commandResult {
+    res := commandResult{Index: index, Object: cmd.Object, Action: cmd.Action, Status: string(Fail)}
+    obj, ok := h.objects[cmd.Object]
+    if !ok || cmd.Object == "server" {
+        res.Message = fmt.Sprintf("Error: unknown object %s", cmd.Object)
+        return res
+    }
+    params, err := json.Marshal(cmd.Params)
+    if err != nil {
+        res.Message = fmt.Sprintf("Error: unparsable params: %s", err)
+        return res
+    }
+    req := Request{ID: index, Object: cmd.Object, Action: cmd.Action, Params: RawJSON(params)}
+    ch := make(chan interface{}, 1)
+    if denied := h.authorizeRequest(conn, req, remote); denied != nil {
+        ch <- denied
+    } else {
+        obj.dispatch(h, req, conn, ch)
+    }
+    select {
+    case resp := <-ch:
+        switch r := resp.(type) {
+        case *ResponseStatus:
+            res.Status = string(r.Data.Status)
+            res.Message = r.Data.Message
+        case *Response:
+            res.Status = string(Ok)
+            res.Data = json.RawMessage(r.Data)
+        default:
+            res.Message = "Error: unexpected response"
+        }
+    default:
+        res.Message = "Error: no response"
+    }
+    return res
+}
+
+// POST /api/commands
+//
+// Body: {"commands": [{"object": "route", "action": "activate", "params": {"id": "1"}}, ...], "continueOnError": false}
+//
+// Commands are executed sequentially, in order, with the role and in the
+// control areas of the user of the bearer token. Unless continueOnError is
+// set, the first failing command stops the batch and the remaining ones are
+// reported as SKIPPED.
+func serveCommands(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    credential, ok := requireUser(w, r, h.sim, RoleObserver)
+    if !ok {
+        return
+    }
+    conn := &connection{
+        pushChan:   make(chan interface{}, 4),
+        clientType: Client,
+        role:       credential.Role,
+        user:       credential.User,
+    }
+    var body struct {
+        Commands        []simulation.SuggestionAction `json:"commands"`
+        ContinueOnError bool                          `json:"continueOnError"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        badRequest(w, err)
+        return
+    }
+    if len(body.Commands) == 0 {
+        invalidParameter(w, "No commands", nil)
+        return
+    }
+    if len(body.Commands) > maxBatchCommands {
+        invalidParameter(w, "Too many commands", map[string]interface{}{"max": maxBatchCommands, "count": len(body.Commands)})
+        return
+    }
+    results := make([]commandResult, 0, len(body.Commands))
+    failed, skipped := 0, 0
+    for i, cmd := range body.Commands {
+        if failed > 0 && !body.ContinueOnError {
+            results = append(results, commandResult{Index: i, Object: cmd.Object, Action: cmd.Action, Status: "SKIPPED"})
+            skipped++
+            continue
+        }
+        res := executeCommand(h, conn, r.RemoteAddr, i, cmd)
+        if res.Status != string(Ok) {
+            failed++
+        }
+        results = append(results, res)
+    }
+    status := "OK"
+    switch {
+    case failed == len(body.Commands):
+        status = "FAILED"
+    case failed > 0:
+        status = "PARTIAL"
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{
+        "status":   status,
+        "executed": len(body.Commands) - skipped,
+        "failed":   failed,
+        "skipped":  skipped,
+        "results":  results,
+    })
+}
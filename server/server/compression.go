@@ -0,0 +1,62 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"compress/flate"
+	"fmt"
+	"sync"
+)
+
+const (
+	// defaultCompressionLevel is the deflate level of websocket messages. It
+	// favours speed since most messages are small notifications.
+	defaultCompressionLevel = flate.BestSpeed
+	// compressionThreshold is the size in bytes under which messages are sent
+	// uncompressed, since deflate does not pay off on them.
+	compressionThreshold = 256
+)
+
+var (
+	// compressionLevel is the deflate level of the messages sent to clients
+	// that negotiated permessage-deflate. Zero disables compression.
+	compressionLevel = defaultCompressionLevel
+	compressionMutex sync.RWMutex
+)
+
+// SetCompressionLevel sets the deflate level, from 1 (best speed) to 9 (best
+// compression), of the messages sent to websocket clients that support the
+// permessage-deflate extension. Zero disables compression.
+func SetCompressionLevel(level int) error {
+	if level < 0 || level > flate.BestCompression {
+		return fmt.Errorf("compression level must be between 0 and %d", flate.BestCompression)
+	}
+	compressionMutex.Lock()
+	defer compressionMutex.Unlock()
+	compressionLevel = level
+	return nil
+}
+
+// currentCompressionLevel returns the deflate level of websocket messages,
+// or zero if compression is disabled.
+func currentCompressionLevel() int {
+	compressionMutex.RLock()
+	defer compressionMutex.RUnlock()
+	return compressionLevel
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompression(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing websocket compression", t, func() {
+		dialer := websocket.Dialer{EnableCompression: true}
+		u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws"}
+		Convey("Invalid levels should be refused", func() {
+			So(SetCompressionLevel(-1), ShouldNotBeNil)
+			So(SetCompressionLevel(10), ShouldNotBeNil)
+			So(currentCompressionLevel(), ShouldEqual, defaultCompressionLevel)
+		})
+		Convey("Clients supporting compression should get compressed messages", func() {
+			c, res, err := dialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(strings.Contains(res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"), ShouldBeTrue)
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			So(c.WriteJSON(Request{ID: 2, Object: "trackItem", Action: "list"}), ShouldBeNil)
+			var resp Response
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.ID, ShouldEqual, 2)
+			So(len(resp.Data), ShouldBeGreaterThan, compressionThreshold)
+		})
+		Convey("Compression should not be negotiated when disabled", func() {
+			So(SetCompressionLevel(0), ShouldBeNil)
+			defer SetCompressionLevel(defaultCompressionLevel)
+			c, res, err := dialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(res.Header.Get("Sec-Websocket-Extensions"), ShouldBeEmpty)
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,693 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A ServerConfig holds the settings of the server that are read from the
+// configuration file and from the environment.
+//
+// The configuration file is written in a subset of TOML: [tables] holding
+// key = value lines, where values are strings, numbers, booleans or arrays of
+// strings. Durations are strings such as "90s" or "5m".
+type ServerConfig struct {
+	// Addr and Port are the address and port on which the server listens.
+	// Changing them needs a restart.
+	Addr string
+	Port string
+	// APISunset is the date after which the unversioned routes of the HTTP
+	// API may be removed, sent in their Sunset header. Zero omits it.
+	APISunset time.Time
+	// ClientToken, if set, is the token that clients must give to register
+	// to any simulation, instead of the client token of the simulation.
+	ClientToken string
+	// Users are the credentials of the clients bound to a user and a role.
+	Users []UserCredential
+	// ObserverClientToken restricts the clients registering with the client
+	// token to RoleObserver, so that only Users may act on the simulations.
+	// Otherwise they may have any role, as with older servers.
+	ObserverClientToken bool
+	// DebugToken enables the debug endpoints if set, see SetDebugToken.
+	DebugToken string
+	// CORS is the cross-origin policy of the HTTP API and websocket
+	CORS CORSConfig
+	// KPI holds the windows and alert thresholds of the realtime KPIs
+	KPI KPITuning
+	// AuditCapacity is the number of entries kept in each audit log
+	AuditCapacity int
+	// Suggestions are the values of suggestion options, by option name
+	// (e.g. suggestMaxItems), set on all the hosted simulations. Values are
+	// booleans or float64 numbers, as in the JSON of the options API.
+	Suggestions map[string]interface{}
+}
+
+// DefaultServerConfig returns the configuration of a server without
+// configuration file.
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Addr:          DefaultAddr,
+		Port:          DefaultPort,
+		KPI:           DefaultKPITuning(),
+		AuditCapacity: defaultAuditCapacity,
+		Suggestions:   make(map[string]interface{}),
+	}
+}
+
+// A configKey is a setting of the configuration file, in the given table,
+// that can be overridden by the env environment variable.
+type configKey struct {
+	table string
+	name  string
+	env   string
+	set   func(c *ServerConfig, v interface{}) error
+}
+
+// configKeys are the settings of the configuration file
+var configKeys = []configKey{
+	{"server", "addr", "TS2_ADDR", func(c *ServerConfig, v interface{}) (err error) {
+		c.Addr, err = configString(v)
+		return
+	}},
+	{"server", "port", "TS2_PORT", func(c *ServerConfig, v interface{}) error {
+		if p, ok := v.(int64); ok {
+			v = strconv.FormatInt(p, 10)
+		}
+		p, err := configString(v)
+		if err != nil {
+			return err
+		}
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", p)
+		}
+		c.Port = p
+		return nil
+	}},
+	{"server", "apiSunset", "TS2_API_SUNSET", func(c *ServerConfig, v interface{}) (err error) {
+		c.APISunset, err = configTime(v)
+		return
+	}},
+	{"auth", "clientToken", "TS2_CLIENT_TOKEN", func(c *ServerConfig, v interface{}) (err error) {
+		c.ClientToken, err = configString(v)
+		return
+	}},
+	{"auth", "users", "TS2_USERS", func(c *ServerConfig, v interface{}) error {
+		users, err := configStrings(v)
+		if err != nil {
+			return err
+		}
+		c.Users = make([]UserCredential, len(users))
+		for i, u := range users {
+			if c.Users[i], err = parseUserCredential(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"auth", "observerClientToken", "TS2_OBSERVER_CLIENT_TOKEN", func(c *ServerConfig, v interface{}) (err error) {
+		c.ObserverClientToken, err = configBool(v)
+		return
+	}},
+	{"auth", "debugToken", "TS2_DEBUG_TOKEN", func(c *ServerConfig, v interface{}) (err error) {
+		c.DebugToken, err = configString(v)
+		return
+	}},
+	{"cors", "allowedOrigins", "TS2_CORS_ORIGINS", func(c *ServerConfig, v interface{}) (err error) {
+		c.CORS.AllowedOrigins, err = configStrings(v)
+		return
+	}},
+	{"cors", "allowedHeaders", "TS2_CORS_HEADERS", func(c *ServerConfig, v interface{}) (err error) {
+		c.CORS.AllowedHeaders, err = configStrings(v)
+		return
+	}},
+	{"cors", "maxAge", "TS2_CORS_MAX_AGE", func(c *ServerConfig, v interface{}) (err error) {
+		c.CORS.MaxAge, err = configDuration(v)
+		return
+	}},
+	{"kpi", "onTimeWindow", "TS2_KPI_ON_TIME_WINDOW", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.OnTimeWindow, err = configDuration(v)
+		return
+	}},
+	{"kpi", "delayWindow", "TS2_KPI_DELAY_WINDOW", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.DelayWindow, err = configDuration(v)
+		return
+	}},
+	{"kpi", "throughputWindow", "TS2_KPI_THROUGHPUT_WINDOW", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.ThroughputWindow, err = configDuration(v)
+		return
+	}},
+	{"kpi", "mttrWindow", "TS2_KPI_MTTR_WINDOW", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.MTTRWindow, err = configDuration(v)
+		return
+	}},
+	{"kpi", "acceptanceWindow", "TS2_KPI_ACCEPTANCE_WINDOW", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.AcceptanceWindow, err = configDuration(v)
+		return
+	}},
+	{"kpi", "minHeadway", "TS2_KPI_MIN_HEADWAY", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.MinHeadway, err = configDuration(v)
+		return
+	}},
+	{"kpi", "punctualityAlertBelow", "TS2_KPI_PUNCTUALITY_ALERT_BELOW", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.PunctualityBelow, err = configFloat(v)
+		return
+	}},
+	{"kpi", "averageDelayAlertAbove", "TS2_KPI_AVERAGE_DELAY_ALERT_ABOVE", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.AverageDelayAbove, err = configFloat(v)
+		return
+	}},
+	{"kpi", "openConflictsAlertAbove", "TS2_KPI_OPEN_CONFLICTS_ALERT_ABOVE", func(c *ServerConfig, v interface{}) (err error) {
+		c.KPI.OpenConflictsAbove, err = configInt(v)
+		return
+	}},
+	{"audit", "capacity", "TS2_AUDIT_CAPACITY", func(c *ServerConfig, v interface{}) (err error) {
+		c.AuditCapacity, err = configInt(v)
+		return
+	}},
+	suggestionConfigKey("enabled", "TS2_SUGGESTIONS_ENABLED", "suggestionsEnabled"),
+	suggestionConfigKey("intervalMinutes", "TS2_SUGGESTIONS_INTERVAL_MINUTES", "suggestionsIntervalMinutes"),
+	suggestionConfigKey("predictiveMaxDistanceM", "TS2_SUGGESTIONS_PREDICTIVE_MAX_DISTANCE_M", "suggestPredictiveMaxDistanceM"),
+	suggestionConfigKey("predictiveMaxETASeconds", "TS2_SUGGESTIONS_PREDICTIVE_MAX_ETA_SECONDS", "suggestPredictiveMaxETASeconds"),
+	suggestionConfigKey("safetyBufferSeconds", "TS2_SUGGESTIONS_SAFETY_BUFFER_SECONDS", "suggestSafetyBufferSeconds"),
+	suggestionConfigKey("maxItems", "TS2_SUGGESTIONS_MAX_ITEMS", "suggestMaxItems"),
+}
+
+// suggestionConfigKey returns the setting of the suggestions table that sets
+// the given simulation option.
+func suggestionConfigKey(name, env, option string) configKey {
+	return configKey{"suggestions", name, env, func(c *ServerConfig, v interface{}) error {
+		var err error
+		if tunableOptions[option].Kind == "bool" {
+			v, err = configBool(v)
+		} else {
+			v, err = configFloat(v)
+		}
+		if err != nil {
+			return err
+		}
+		c.Suggestions[option] = v
+		return nil
+	}}
+}
+
+// LoadServerConfig returns the default configuration updated with the
+// settings of the given configuration file, if path is not empty, and with
+// the environment variables.
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	c := DefaultServerConfig()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values, err := parseConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if err := c.decode(values); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	for _, k := range configKeys {
+		if v := os.Getenv(k.env); v != "" {
+			if err := k.set(c, v); err != nil {
+				return nil, fmt.Errorf("%s: %s", k.env, err)
+			}
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// decode sets the settings of c from the values of a configuration file. It
+// fails on unknown tables and keys, so that typos do not go unnoticed.
+func (c *ServerConfig) decode(values map[string]map[string]interface{}) error {
+	known := make(map[string]bool)
+	for _, k := range configKeys {
+		known[k.table+"."+k.name] = true
+	}
+	var unknown []string
+	for table, keys := range values {
+		for name := range keys {
+			if !known[table+"."+name] {
+				unknown = append(unknown, fmt.Sprintf("[%s] %s", table, name))
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+	}
+	for _, k := range configKeys {
+		v, ok := values[k.table][k.name]
+		if !ok {
+			continue
+		}
+		if err := k.set(c, v); err != nil {
+			return fmt.Errorf("[%s] %s: %s", k.table, k.name, err)
+		}
+	}
+	return nil
+}
+
+// validate returns an error if a setting of c is out of range
+func (c *ServerConfig) validate() error {
+	if c.DebugToken != "" && len(c.DebugToken) < minDebugTokenLength {
+		return fmt.Errorf("debug token must be at least %d characters long", minDebugTokenLength)
+	}
+	tokens := make(map[string]bool, len(c.Users))
+	for _, u := range c.Users {
+		if tokens[u.Token] {
+			return fmt.Errorf("user %s: token already given to another user", u.User)
+		}
+		tokens[u.Token] = true
+	}
+	if c.ObserverClientToken && len(c.Users) == 0 {
+		return fmt.Errorf("observer client token needs users")
+	}
+	if c.AuditCapacity < 1 {
+		return fmt.Errorf("audit capacity must be at least 1")
+	}
+	if err := c.CORS.validate(); err != nil {
+		return err
+	}
+	if err := c.KPI.validate(); err != nil {
+		return err
+	}
+	_, err := c.suggestionOptions()
+	return err
+}
+
+// suggestionOptions returns the checked values of the suggestion options of
+// c, as set by the options API.
+func (c *ServerConfig) suggestionOptions() (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(c.Suggestions))
+	for name, value := range c.Suggestions {
+		to, ok := tunableOptions[name]
+		if !ok || !strings.HasPrefix(name, "suggest") {
+			return nil, fmt.Errorf("unknown suggestion option: %s", name)
+		}
+		v, err := to.check(name, value)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = v
+	}
+	return res, nil
+}
+
+var (
+	serverConfigMutex sync.RWMutex
+	// serverConfig is the applied configuration, or nil if SetServerConfig
+	// has not been called
+	serverConfig *ServerConfig
+	// configOptions are the checked suggestion options of serverConfig
+	configOptions map[string]interface{}
+	// startTimeFactor is the time factor set on the simulations when they
+	// are loaded, or 0 to keep the one of their file
+	startTimeFactor int
+)
+
+// SetStartTimeFactor sets the time factor of the simulations loaded
+// afterwards. Zero keeps the time factor of each simulation file.
+func SetStartTimeFactor(factor int) error {
+	if factor != 0 {
+		if _, err := tunableOptions["timeFactor"].check("timeFactor", float64(factor)); err != nil {
+			return err
+		}
+	}
+	serverConfigMutex.Lock()
+	defer serverConfigMutex.Unlock()
+	startTimeFactor = factor
+	return nil
+}
+
+// SetServerConfig applies the given configuration to the server. It is called
+// at startup and each time the configuration is reloaded.
+//
+// All the settings are applied at once, except the listen address, whose
+// change is only logged.
+func SetServerConfig(c *ServerConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	options, _ := c.suggestionOptions()
+	serverConfigMutex.Lock()
+	prev := serverConfig
+	serverConfig = c
+	configOptions = options
+	serverConfigMutex.Unlock()
+	if prev != nil && (prev.Addr != c.Addr || prev.Port != c.Port) {
+		logger.Warn("Restart the server to listen on the new address", "submodule", "config", "address", c.Addr+":"+c.Port)
+	}
+	_ = SetCORSConfig(c.CORS)
+	_ = SetKPITuning(c.KPI)
+	_ = SetAuditCapacity(c.AuditCapacity)
+	debugTokenMutex.Lock()
+	debugToken = c.DebugToken
+	debugTokenMutex.Unlock()
+	for _, h := range simulations.list() {
+		if h.sim == nil || len(options) == 0 {
+			continue
+		}
+		applyConfigOptions(h.sim)
+		// Options are encoded here since the simulation keeps changing them
+		data, err := json.Marshal(&h.sim.Options)
+		if err != nil {
+			logger.Error("Unable to encode simulation options", "submodule", "config", "simulation", h.id, "error", err)
+		}
+		select {
+		case h.events <- &simulation.Event{Name: simulation.OptionsChangedEvent, Object: &h.sim.Options, Data: data}:
+		case <-h.done:
+		}
+		if h.sim.Options.SuggestionsEnabled {
+			h.sim.RecomputeSuggestions()
+		}
+	}
+	logger.Info("Configuration applied", "submodule", "config", "cors", len(c.CORS.AllowedOrigins), "auditCapacity", c.AuditCapacity, "suggestionOptions", len(options))
+	return nil
+}
+
+// applyConfigOptions sets the time factor and the suggestion options of
+// the configuration on s
+func applyConfigOptions(s *simulation.Simulation) {
+	serverConfigMutex.RLock()
+	defer serverConfigMutex.RUnlock()
+	if startTimeFactor != 0 {
+		s.Options.TimeFactor = startTimeFactor
+	}
+	for name, v := range configOptions {
+		o := &s.Options
+		switch name {
+		case "suggestionsEnabled":
+			o.SuggestionsEnabled = v.(bool)
+		case "suggestionsIntervalMinutes":
+			o.SuggestionsIntervalMinutes = v.(int)
+		case "suggestPredictiveMaxDistanceM":
+			o.SuggestPredictiveMaxDistanceM = v.(float64)
+		case "suggestPredictiveMaxETASeconds":
+			o.SuggestPredictiveMaxETASeconds = v.(int)
+		case "suggestSafetyBufferSeconds":
+			o.SuggestSafetyBufferSeconds = v.(int)
+		case "suggestMaxItems":
+			o.SuggestMaxItems = v.(int)
+		}
+	}
+}
+
+// clientToken returns the token that clients must give to register to s
+func clientToken(s *simulation.Simulation) string {
+	serverConfigMutex.RLock()
+	defer serverConfigMutex.RUnlock()
+	if serverConfig != nil && serverConfig.ClientToken != "" {
+		return serverConfig.ClientToken
+	}
+	return s.Options.ClientToken
+}
+
+// clientCredential returns the credential of the clients registering to s
+// with the given token, and false if the token is invalid. The client token
+// is the credential of anonymous clients, which are observers if the
+// configuration says so, and may have any role otherwise.
+func clientCredential(s *simulation.Simulation, token string) (UserCredential, bool) {
+	serverConfigMutex.RLock()
+	var users []UserCredential
+	anonymous := RoleAdmin
+	if serverConfig != nil {
+		users = serverConfig.Users
+		if serverConfig.ObserverClientToken {
+			anonymous = RoleObserver
+		}
+	}
+	serverConfigMutex.RUnlock()
+	for _, u := range users {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(u.Token)) == 1 {
+			return u, true
+		}
+	}
+	if token != clientToken(s) {
+		return UserCredential{}, false
+	}
+	return UserCredential{Role: anonymous}, true
+}
+
+// configString returns v if it is a string
+func configString(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expecting a string, got %v", v)
+	}
+	return s, nil
+}
+
+// configInt returns the integer v, or v parsed as an integer if it is a
+// string.
+func configInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("expecting an integer, got %q", n)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("expecting an integer, got %v", v)
+}
+
+// configFloat returns the number v, or v parsed as a number if it is a
+// string.
+func configFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expecting a number, got %q", n)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("expecting a number, got %v", v)
+}
+
+// configBool returns the boolean v, or v parsed as a boolean if it is a
+// string.
+func configBool(v interface{}) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		res, err := strconv.ParseBool(b)
+		if err != nil {
+			return false, fmt.Errorf("expecting a boolean, got %q", b)
+		}
+		return res, nil
+	}
+	return false, fmt.Errorf("expecting a boolean, got %v", v)
+}
+
+// configDuration returns the duration of the string v, e.g. "90s"
+func configDuration(v interface{}) (time.Duration, error) {
+	s, err := configString(v)
+	if err != nil {
+		return 0, fmt.Errorf("expecting a duration such as \"5m\", got %v", v)
+	}
+	return time.ParseDuration(s)
+}
+
+// configTime returns the RFC 3339 date v
+func configTime(v interface{}) (time.Time, error) {
+	s, err := configString(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expecting a date such as \"2026-12-31T23:59:59Z\", got %v", v)
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// configStrings returns the array of strings v, or the comma separated
+// values of v if it is a string.
+func configStrings(v interface{}) ([]string, error) {
+	switch l := v.(type) {
+	case string:
+		var res []string
+		for _, s := range strings.Split(l, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]string, len(l))
+		for i, e := range l {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("expecting an array of strings, got %v", v)
+			}
+			res[i] = s
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("expecting an array of strings, got %v", v)
+}
+
+// configKeyPattern matches table names and bare keys
+var configKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseConfig parses a configuration file and returns its values by table
+// and key. Keys before the first table are in the "" table.
+func parseConfig(data []byte) (map[string]map[string]interface{}, error) {
+	res := map[string]map[string]interface{}{"": {}}
+	table := ""
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimSpace(stripConfigComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid table header %s", lineNum, line)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if !configKeyPattern.MatchString(table) {
+				return nil, fmt.Errorf("line %d: invalid table name %q", lineNum, table)
+			}
+			if _, ok := res[table]; ok {
+				return nil, fmt.Errorf("line %d: table %s defined twice", lineNum, table)
+			}
+			res[table] = make(map[string]interface{})
+			continue
+		}
+		eq := unquotedIndex(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expecting key = value", lineNum)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if strings.HasPrefix(key, "\"") {
+			var err error
+			if key, err = strconv.Unquote(key); err != nil {
+				return nil, fmt.Errorf("line %d: invalid key %s", lineNum, line[:eq])
+			}
+		} else if !configKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNum, key)
+		}
+		value := strings.TrimSpace(line[eq+1:])
+		// Arrays may span several lines
+		for strings.HasPrefix(value, "[") && unquotedIndex(value, ']') < 0 && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripConfigComment(lines[i]))
+		}
+		v, err := parseConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		if _, ok := res[table][key]; ok {
+			return nil, fmt.Errorf("line %d: key %s defined twice", lineNum, key)
+		}
+		res[table][key] = v
+	}
+	return res, nil
+}
+
+// parseConfigValue returns the string, int64, float64, bool or array of
+// such values written in s.
+func parseConfigValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s == "true", s == "false":
+		return s == "true", nil
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.Contains(s[1:len(s)-1], "'") {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '[':
+		if unquotedIndex(s, ']') != len(s)-1 {
+			return nil, fmt.Errorf("invalid array %s", s)
+		}
+		res := []interface{}{}
+		rest := strings.TrimSpace(s[1 : len(s)-1])
+		for rest != "" {
+			end := unquotedIndex(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			elem := strings.TrimSpace(rest[:end])
+			if strings.HasPrefix(elem, "[") {
+				return nil, fmt.Errorf("nested arrays are not supported")
+			}
+			v, err := parseConfigValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+			if end == len(rest) {
+				break
+			}
+			rest = strings.TrimSpace(rest[end+1:])
+		}
+		return res, nil
+	}
+	num := strings.Replace(s, "_", "", -1)
+	if i, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+// stripConfigComment returns line without its comment, if any
+func stripConfigComment(line string) string {
+	if i := unquotedIndex(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// unquotedIndex returns the index of the first c of s that is not in a
+// quoted string, or -1 if there is none.
+func unquotedIndex(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
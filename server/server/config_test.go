@@ -0,0 +1,215 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testConfig = `
+# Server configuration
+[server]
+addr = "127.0.0.1"
+port = 8080
+apiSunset = "2027-06-30T00:00:00+02:00"
+
+[auth]
+clientToken = "config#secret" # not a comment inside the string
+users = ["alice:operator:alice:token"]
+observerClientToken = true
+
+[cors]
+allowedOrigins = [
+    "https://dashboard.example.com", # main dashboard
+    'http://localhost:3000',
+]
+maxAge = "10m"
+
+[kpi]
+onTimeWindow = "3m"
+punctualityAlertBelow = 90
+averageDelayAlertAbove = 2.5
+
+[audit]
+capacity = 1_500
+
+[suggestions]
+enabled = false
+maxItems = 20
+`
+
+func TestServerConfig(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the server configuration", t, func() {
+		dir, err := ioutil.TempDir("", "ts2-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		write := func(content string) string {
+			path := filepath.Join(dir, "ts2.toml")
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			return path
+		}
+		Convey("The configuration file should be parsed", func() {
+			values, err := parseConfig([]byte(testConfig))
+			So(err, ShouldBeNil)
+			So(values["server"]["port"], ShouldEqual, int64(8080))
+			So(values["auth"]["clientToken"], ShouldEqual, "config#secret")
+			So(values["cors"]["allowedOrigins"], ShouldResemble, []interface{}{"https://dashboard.example.com", "http://localhost:3000"})
+			So(values["kpi"]["averageDelayAlertAbove"], ShouldEqual, 2.5)
+			So(values["audit"]["capacity"], ShouldEqual, int64(1500))
+			So(values["suggestions"]["enabled"], ShouldEqual, false)
+
+			for _, bad := range []string{"[kpi\n", "[a]\n[a]\n", "[a]\nb = 1\nb = 2\n", "[a]\nb\n", "[a]\nb = 'c\n", "[a]\nb = [[1]]\n", "[a]\nb = x\n"} {
+				_, err := parseConfig([]byte(bad))
+				So(err, ShouldNotBeNil)
+			}
+			_, err = parseConfig([]byte("[a]\n\nb = x\n"))
+			So(err.Error(), ShouldStartWith, "line 3:")
+		})
+		Convey("The configuration should be loaded from the file and the environment", func() {
+			config, err := LoadServerConfig(write(testConfig))
+			So(err, ShouldBeNil)
+			So(config.Addr, ShouldEqual, "127.0.0.1")
+			So(config.Port, ShouldEqual, "8080")
+			So(config.APISunset.UTC(), ShouldEqual, time.Date(2027, 6, 29, 22, 0, 0, 0, time.UTC))
+			So(config.ClientToken, ShouldEqual, "config#secret")
+			So(config.Users, ShouldResemble, []UserCredential{{User: "alice", Role: RoleOperator, Token: "alice:token"}})
+			So(config.ObserverClientToken, ShouldBeTrue)
+			So(config.CORS.MaxAge, ShouldEqual, 10*time.Minute)
+			So(config.KPI.OnTimeWindow, ShouldEqual, 3*time.Minute)
+			So(config.KPI.DelayWindow, ShouldEqual, defaultDelayWindow)
+			So(config.KPI.PunctualityBelow, ShouldEqual, 90)
+			So(config.AuditCapacity, ShouldEqual, 1500)
+			So(config.Suggestions, ShouldResemble, map[string]interface{}{"suggestionsEnabled": false, "suggestMaxItems": 20.0})
+
+			os.Setenv("TS2_AUDIT_CAPACITY", "50")
+			os.Setenv("TS2_CORS_ORIGINS", "https://a.example.com, https://b.example.com")
+			defer os.Unsetenv("TS2_AUDIT_CAPACITY")
+			defer os.Unsetenv("TS2_CORS_ORIGINS")
+			config, err = LoadServerConfig(write(testConfig))
+			So(err, ShouldBeNil)
+			So(config.AuditCapacity, ShouldEqual, 50)
+			So(config.CORS.AllowedOrigins, ShouldResemble, []string{"https://a.example.com", "https://b.example.com"})
+
+			os.Setenv("TS2_AUDIT_CAPACITY", "many")
+			_, err = LoadServerConfig("")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Invalid settings should be rejected", func() {
+			for _, bad := range []string{
+				"[server]\nhost = \"a\"\n",
+				"[server]\nport = 70000\n",
+				"[server]\napiSunset = \"next year\"\n",
+				"[kpi]\nonTimeWindow = 5\n",
+				"[kpi]\ndelayWindow = \"-1m\"\n",
+				"[audit]\ncapacity = 0\n",
+				"[cors]\nallowedOrigins = [\"example.com\"]\n",
+				"[suggestions]\nmaxItems = 1000\n",
+				"[suggestions]\nenabled = 1\n",
+				"[auth]\nusers = [\"alice:pilot:token\"]\n",
+				"[auth]\nusers = [\"alice:operator\"]\n",
+				"[auth]\nusers = [\"alice:operator:token\", \"bob:admin:token\"]\n",
+				"[auth]\nobserverClientToken = true\n",
+			} {
+				_, err := LoadServerConfig(write(bad))
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("The configuration should be applied to the server", func() {
+			enabled, maxItems := sim.Options.SuggestionsEnabled, sim.Options.SuggestMaxItems
+			defer func() {
+				So(SetServerConfig(testServerConfig()), ShouldBeNil)
+				sim.Options.SuggestionsEnabled, sim.Options.SuggestMaxItems = enabled, maxItems
+			}()
+			So(AddSimulation("configured", loadDemoWith(nil)), ShouldBeNil)
+			h, _ := simulations.get("configured")
+			defer simulations.remove("configured")
+			for i := 0; i < 30; i++ {
+				h.audits.append(AuditEntry{Event: "TEST"})
+			}
+
+			config, err := LoadServerConfig(write(testConfig))
+			So(err, ShouldBeNil)
+			config.AuditCapacity = 10
+			So(SetServerConfig(config), ShouldBeNil)
+			So(currentKPITuning().OnTimeWindow, ShouldEqual, 3*time.Minute)
+			So(h.audits.getSince(0, 1000), ShouldHaveLength, 10)
+			So(h.sim.Options.SuggestMaxItems, ShouldEqual, 20)
+			So(h.sim.Options.SuggestionsEnabled, ShouldBeFalse)
+
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldNotBeNil)
+			c2 := clientDial(t)
+			defer c2.Close()
+			So(register(t, c2, Client, "", "config#secret"), ShouldBeNil)
+
+			So(SetServerConfig(testServerConfig()), ShouldBeNil)
+			So(AddSimulation("unconfigured", loadDemoWith(nil)), ShouldBeNil)
+			defer simulations.remove("unconfigured")
+			h2, _ := simulations.get("unconfigured")
+			So(h2.sim.Options.SuggestMaxItems, ShouldEqual, 0)
+			So(h2.audits.capacity, ShouldEqual, defaultAuditCapacity)
+			So(currentKPITuning(), ShouldResemble, DefaultKPITuning())
+		})
+		Convey("Simulations should start with the given time factor", func() {
+			So(SetStartTimeFactor(11), ShouldNotBeNil)
+			So(SetStartTimeFactor(3), ShouldBeNil)
+			defer SetStartTimeFactor(0)
+			So(AddSimulation("accelerated", loadDemoWith(nil)), ShouldBeNil)
+			defer simulations.remove("accelerated")
+			h, _ := simulations.get("accelerated")
+			So(h.sim.Options.TimeFactor, ShouldEqual, 3)
+
+			So(SetKPISnapshotInterval(time.Second), ShouldNotBeNil)
+			So(SetKPISnapshotInterval(time.Hour), ShouldNotBeNil)
+			So(SetKPISnapshotInterval(defaultKPISnapshotInterval), ShouldBeNil)
+		})
+		Convey("Allowed origins should get CORS headers", func() {
+			So(SetCORSConfig(CORSConfig{AllowedOrigins: []string{"https://dashboard.example.com"}, MaxAge: time.Minute}), ShouldBeNil)
+			defer SetCORSConfig(CORSConfig{})
+			handler := allowCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			request := func(method, origin string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(method, "/api/trains", nil)
+				r.Header.Set("Origin", origin)
+				if method == http.MethodOptions {
+					r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				return w
+			}
+			w := request(http.MethodOptions, "https://dashboard.example.com")
+			So(w.Code, ShouldEqual, http.StatusNoContent)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://dashboard.example.com")
+			So(w.Header().Get("Access-Control-Allow-Headers"), ShouldContainSubstring, "X-User-ID")
+			So(w.Header().Get("Access-Control-Max-Age"), ShouldEqual, "60")
+
+			w = request(http.MethodGet, "https://dashboard.example.com")
+			So(w.Code, ShouldEqual, http.StatusTeapot)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://dashboard.example.com")
+
+			w = request(http.MethodGet, "https://evil.example.com")
+			So(w.Code, ShouldEqual, http.StatusTeapot)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.Header.Set("Origin", "https://evil.example.com")
+			So(checkWebsocketOrigin(r), ShouldBeFalse)
+			r.Header.Set("Origin", "https://dashboard.example.com")
+			So(checkWebsocketOrigin(r), ShouldBeTrue)
+		})
+	})
+}
@@ -23,6 +23,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -30,7 +32,12 @@ import (
 type ClientType string
 
 const (
+	// Client is the type of standard clients, whose role is chosen at register.
 	Client ClientType = "client"
+	// Observer is the type of read-only clients, such as wallboard displays or
+	// spectators. They always have RoleObserver: they receive events and may
+	// only call read actions.
+	Observer ClientType = "observer"
 )
 
 type ManagerType string
@@ -38,24 +45,83 @@ type ManagerType string
 // connection is a wrapper around the websocket.Conn
 type connection struct {
 	websocket.Conn
+	// hub is the hub of the simulation this connection is attached to
+	hub *Hub
 	// pushChan is the channel on which pushed messaged are sent
-	pushChan    chan interface{}
-	clientType  ClientType
+	pushChan   chan interface{}
+	clientType ClientType
+	role       ClientRole
+	// user is the dispatcher using this client
+	user        string
 	ManagerType ManagerType
 	Requests    []Request
+	// coalescer merges frequent notifications of the same object, if enabled
+	coalescer *coalescer
+	// limiter limits the requests rate of the client, if enabled
+	limiter *rateLimiter
+	// idleTimeout is the time after which the client is disconnected if it
+	// neither sent a message nor answered a ping. Zero disables it.
+	idleTimeout time.Duration
+	// ctx is cancelled when the connection is closed
+	ctx context.Context
+}
+
+// context returns the context of this connection, which is cancelled when
+// the connection is closed.
+func (conn *connection) context() context.Context {
+	if conn.ctx == nil {
+		return context.Background()
+	}
+	return conn.ctx
+}
+
+// readRequest reads the next message of the connection into v, decoding it
+// with the subprotocol negotiated at handshake.
+//
+// Each message read gives the client another idle timeout.
+func (conn *connection) readRequest(v interface{}) error {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	conn.extendReadDeadline()
+	if conn.Subprotocol() != msgpackSubprotocol {
+		return json.Unmarshal(data, v)
+	}
+	return unmarshalMsgpack(data, v)
+}
+
+// writeResponse sends v to the client, encoded with the subprotocol
+// negotiated at handshake.
+//
+// If the client negotiated compression, only messages of at least
+// compressionThreshold bytes are compressed.
+func (conn *connection) writeResponse(v interface{}) error {
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+	data, messageType, err := encodeMessage(v, conn.Subprotocol() == msgpackSubprotocol, buf)
+	if err != nil {
+		return err
+	}
+	conn.EnableWriteCompression(len(data) >= compressionThreshold)
+	return conn.WriteMessage(messageType, data)
 }
 
 // loop starts the reading and writing loops of the connection.
 func (conn *connection) loop(ctx context.Context) {
 	logger.Debug("New connection", "remote", conn.RemoteAddr())
 	if err, req := conn.registerClient(); err != nil {
+		if isTimeout(err) {
+			atomic.AddInt64(&connStats.idleTimeouts, 1)
+		}
 		// Try to notify client
-		_ = conn.WriteJSON(NewErrorResponse(req.ID, err))
+		_ = conn.writeResponse(NewErrorResponse(req.ID, err))
 		logger.Error("Error while login", "connection", conn.RemoteAddr(), "error", err)
 		return
 	}
 	loopCtx, childCancel := context.WithCancel(ctx)
 	defer childCancel()
+	conn.ctx = loopCtx
 	go conn.processWrite(loopCtx)
 	conn.processRead(loopCtx)
 }
@@ -69,8 +135,13 @@ func (conn *connection) processRead(ctx context.Context) {
 		default:
 		}
 		var req Request
-		err := conn.ReadJSON(&req)
+		err := conn.readRequest(&req)
 		if err != nil {
+			if isTimeout(err) {
+				logger.Info("Disconnecting idle client", "connection", conn.RemoteAddr(), "timeout", conn.idleTimeout)
+				atomic.AddInt64(&connStats.idleTimeouts, 1)
+				return
+			}
 			switch err.(type) {
 			case *websocket.CloseError, net.Error:
 				logger.Debug("Connection closed by peer", "connection", conn.RemoteAddr())
@@ -82,18 +153,72 @@ func (conn *connection) processRead(ctx context.Context) {
 				continue
 			}
 		}
+		if !conn.limiter.allow(time.Now()) {
+			if conn.limiter.exceeded() {
+				conn.disconnectRateLimited()
+				return
+			}
+			conn.pushChan <- NewRateLimitedResponse(req.ID)
+			continue
+		}
 		conn.Requests = append(conn.Requests, req)
-		hub.readChan <- conn
+		select {
+		case conn.hub.readChan <- conn:
+		case <-conn.hub.done:
+			return
+		}
 	}
 }
 
+// disconnectRateLimited closes the connection of a client that persistently
+// exceeds its rate limit.
+func (conn *connection) disconnectRateLimited() {
+	logger.Warn("Disconnecting client exceeding its rate limit", "connection", conn.RemoteAddr())
+	conn.hub.audits.append(AuditEntry{
+		Event:    "RATE_LIMIT_DISCONNECT",
+		Category: "security",
+		Severity: "WARNING",
+		Object:   map[string]interface{}{"id": conn.RemoteAddr().String(), "type": "connection"},
+		Details: map[string]interface{}{
+			"role":       string(conn.role),
+			"rejections": conn.limiter.rejections,
+		},
+	})
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
 // processWrite performs all the write operations to the connection sent by the hub
 func (conn *connection) processWrite(ctx context.Context) {
+	var flushChan <-chan time.Time
+	if conn.coalescer != nil {
+		ticker := time.NewTicker(conn.coalescer.interval)
+		defer ticker.Stop()
+		flushChan = ticker.C
+	}
+	var pingChan <-chan time.Time
+	if period := conn.pingPeriod(); period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		pingChan = ticker.C
+	}
 	for {
 		select {
 		case req := <-conn.pushChan:
-			if err := conn.WriteJSON(req); err != nil {
-				logger.Info("Error while writing", "connection", conn.RemoteAddr(), "request", req, "error", err)
+			if conn.coalescer.add(req) {
+				continue
+			}
+			conn.write(req)
+		case <-flushChan:
+			for _, n := range conn.coalescer.flush() {
+				conn.write(n)
+			}
+		case <-pingChan:
+			if err := conn.ping(); err != nil {
+				logger.Info("Error while pinging", "connection", conn.RemoteAddr(), "error", err)
+				// Unblock processRead so that the connection is unregistered
+				_ = conn.Conn.Close()
+				return
 			}
 		case <-ctx.Done():
 			return
@@ -101,12 +226,21 @@ func (conn *connection) processWrite(ctx context.Context) {
 	}
 }
 
+// write sends the given message to the client
+func (conn *connection) write(msg interface{}) {
+	_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.writeResponse(msg); err != nil {
+		atomic.AddInt64(&connStats.writeErrors, 1)
+		logger.Info("Error while writing", "connection", conn.RemoteAddr(), "request", msg, "error", err)
+	}
+}
+
 // registerClient() waits for a register request from the client, checks it and registers the connection
 // on the hub if it is valid. Otherwise it returns an error.
 func (conn *connection) registerClient() (error, *Request) {
 	// Parse request
 	req := new(Request)
-	if err := conn.ReadJSON(req); err != nil {
+	if err := conn.readRequest(req); err != nil {
 		return err, req
 	}
 	if req.Object != "server" || req.Action != "register" {
@@ -116,27 +250,68 @@ func (conn *connection) registerClient() (error, *Request) {
 	if err := json.Unmarshal(req.Params, &registerParams); err != nil {
 		return fmt.Errorf("unable to parse register params: %s", err), req
 	}
+	if registerParams.SimID != "" {
+		h, ok := simulations.get(registerParams.SimID)
+		if !ok {
+			return fmt.Errorf("unknown simulation %s", registerParams.SimID), req
+		}
+		conn.hub = h
+	}
 
 	// Authenticate client and type
-	if registerParams.ClientType == Client &&
-		registerParams.Token == sim.Options.ClientToken {
-		conn.clientType = Client
-	} else {
+	credential, ok := clientCredential(conn.hub.sim, registerParams.Token)
+	if !ok {
 		return fmt.Errorf("invalid register parameters"), req
 	}
+	switch registerParams.ClientType {
+	case Client:
+	case Observer:
+		if registerParams.Role != "" && ClientRole(registerParams.Role) != RoleObserver {
+			return fmt.Errorf("observer clients cannot have role %s", registerParams.Role), req
+		}
+		registerParams.Role = string(RoleObserver)
+	default:
+		return fmt.Errorf("invalid register parameters"), req
+	}
+	conn.clientType = registerParams.ClientType
+	role, err := credential.grantedRole(registerParams.Role)
+	if err != nil {
+		return err, req
+	}
+	conn.role = role
+	conn.user = registerParams.User
+	if credential.User != "" {
+		if registerParams.User != "" && registerParams.User != credential.User {
+			return fmt.Errorf("token of user %s cannot register as %s", credential.User, registerParams.User), req
+		}
+		conn.user = credential.User
+	}
+	interval, err := coalesceIntervalFromMs(registerParams.CoalesceMs)
+	if err != nil {
+		return err, req
+	}
+	conn.coalescer = newCoalescer(interval)
+	conn.limiter = newRateLimiter()
 
 	// authenticated, so setup
-	if err := conn.WriteJSON(NewOkResponse(req.ID, "Successfully registered")); err != nil {
+	if err := conn.writeResponse(NewOkResponse(req.ID, "Successfully registered")); err != nil {
 		logger.Info("Error while writing", "connection", conn.RemoteAddr(), "request", "NewOkResponse", "error", err)
 	}
-	hub.registerChan <- conn
-	logger.Info("Registered client", "connection", conn.RemoteAddr(), "clientType", conn.clientType, "managerType", conn.ManagerType)
+	select {
+	case conn.hub.registerChan <- conn:
+	case <-conn.hub.done:
+		return fmt.Errorf("simulation %s has been removed", conn.hub.id), req
+	}
+	logger.Info("Registered client", "connection", conn.RemoteAddr(), "simulation", conn.hub.id, "clientType", conn.clientType, "managerType", conn.ManagerType, "role", conn.role, "user", conn.user, "coalesce", interval)
 	return nil, req
 }
 
 // Close terminates the websocket connection and closes associated resources
 func (conn *connection) Close() error {
 	_ = conn.Conn.Close()
-	hub.unregisterChan <- conn
+	select {
+	case conn.hub.unregisterChan <- conn:
+	case <-conn.hub.done:
+	}
 	return nil
 }
@@ -33,7 +33,7 @@ func TestConnection(t *testing.T) {
 		c := clientDial(t)
 		Convey("Login test", func() {
 			Convey("First request that is not a register request should fail", func() {
-				badRequest := Request{1234, "Dummy", "dummy", nil}
+				badRequest := Request{ID: 1234, Object: "Dummy", Action: "dummy"}
 				err := c.WriteJSON(badRequest)
 				So(err, ShouldBeNil)
 				var resp ResponseStatus
@@ -52,6 +52,32 @@ func TestConnection(t *testing.T) {
 				So(err, ShouldNotBeNil)
 				So(err, ShouldHaveSameTypeAs, new(websocket.CloseError))
 			})
+			Convey("Login with a too long coalescing interval should fail", func() {
+				err := c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{ClientType: Client, Token: "client-secret", CoalesceMs: 60000}})
+				So(err, ShouldBeNil)
+				var resp ResponseStatus
+				err = c.ReadJSON(&resp)
+				So(err, ShouldBeNil)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: coalesceMs must not exceed 10000")
+			})
+			Convey("Login with an unknown role should fail", func() {
+				err := c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{ClientType: Client, Token: "client-secret", Role: "superuser"}})
+				So(err, ShouldBeNil)
+				var resp ResponseStatus
+				err = c.ReadJSON(&resp)
+				So(err, ShouldBeNil)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown role superuser")
+			})
+			Convey("Login with coalescing should be allowed", func() {
+				err := c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{ClientType: Client, Token: "client-secret", CoalesceMs: 100}})
+				So(err, ShouldBeNil)
+				var resp ResponseStatus
+				err = c.ReadJSON(&resp)
+				So(err, ShouldBeNil)
+				So(resp.Data.Status, ShouldEqual, Ok)
+			})
 			Convey("Correct login should be allowed", func() {
 				err := register(t, c, Client, "", "client-secret")
 				So(err, ShouldBeNil)
@@ -60,7 +86,7 @@ func TestConnection(t *testing.T) {
 		Convey("Login double test", func() {
 			err := register(t, c, Client, "", "client-secret")
 			So(err, ShouldBeNil)
-			err = c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{Client, "", "client-secret"}})
+			err = c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{ClientType: Client, Token: "client-secret"}})
 			So(err, ShouldBeNil)
 			var resp ResponseStatus
 			err = c.ReadJSON(&resp)
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// ControlAreaChangedEvent is sent to its listeners when a control area is
+// assigned to another dispatcher or released.
+const ControlAreaChangedEvent simulation.EventName = "controlAreaChanged"
+
+// errControlAreaNotFound is returned when assigning an unknown control area
+var errControlAreaNotFound = errors.New("unknown control area")
+
+// A ControlAreaView is the state of a control area of a simulation with the
+// dispatcher currently controlling it.
+type ControlAreaView struct {
+	AreaID     string   `json:"id"`
+	Name       string   `json:"name"`
+	TrackItems []string `json:"trackItems"`
+	// Dispatcher is the user controlling the area. The area is controlled by
+	// supervisors only if it is empty.
+	Dispatcher string `json:"dispatcher"`
+	// AssignedBy is the user who assigned the area to its dispatcher. It is
+	// empty if the area has the dispatcher of the simulation file.
+	AssignedBy string `json:"assignedBy,omitempty"`
+}
+
+// ID returns the ID of the control area
+func (v ControlAreaView) ID() string {
+	return v.AreaID
+}
+
+// An areaAssignment is the dispatcher given to a control area while the
+// simulation runs.
+type areaAssignment struct {
+	dispatcher string
+	by         string
+}
+
+// areaState holds the dispatchers assigned to the control areas of a
+// simulation, which override those of the simulation file.
+type areaState struct {
+	mu          sync.RWMutex
+	assignments map[string]areaAssignment
+}
+
+// areas holds the control area assignments of the default simulation
+var areas = newAreaState()
+
+// newAreaState returns an areaState without assignments
+func newAreaState() *areaState {
+	return &areaState{assignments: make(map[string]areaAssignment)}
+}
+
+// controlAreaView returns the current state of the control area ca
+func (h *Hub) controlAreaView(ca *simulation.ControlArea) ControlAreaView {
+	view := ControlAreaView{
+		AreaID:     ca.ID(),
+		Name:       ca.Name,
+		TrackItems: ca.TrackItems,
+		Dispatcher: ca.Dispatcher,
+	}
+	h.areas.mu.RLock()
+	defer h.areas.mu.RUnlock()
+	if a, ok := h.areas.assignments[ca.ID()]; ok {
+		view.Dispatcher = a.dispatcher
+		view.AssignedBy = a.by
+	}
+	return view
+}
+
+// controlAreaViews returns the current state of all the control areas of the
+// simulation of h, sorted by ID.
+func (h *Hub) controlAreaViews() []ControlAreaView {
+	res := []ControlAreaView{}
+	for _, ca := range h.sim.ControlAreas() {
+		res = append(res, h.controlAreaView(ca))
+	}
+	return res
+}
+
+// areaDispatcher returns the user controlling the control area with the
+// given ID.
+func (h *Hub) areaDispatcher(areaID string) string {
+	h.areas.mu.RLock()
+	a, ok := h.areas.assignments[areaID]
+	h.areas.mu.RUnlock()
+	if ok {
+		return a.dispatcher
+	}
+	if ca := h.sim.ControlArea(areaID); ca != nil {
+		return ca.Dispatcher
+	}
+	return ""
+}
+
+// assignControlArea gives the control area with the given ID to the given
+// dispatcher, or releases it if dispatcher is empty, and notifies the
+// change. by is the user who made the change.
+//
+// assignControlArea must not be called from the hub loop.
+func (h *Hub) assignControlArea(areaID, dispatcher, by string) (ControlAreaView, error) {
+	ca := h.sim.ControlArea(areaID)
+	if ca == nil {
+		return ControlAreaView{}, errControlAreaNotFound
+	}
+	previous := h.areaDispatcher(areaID)
+	h.areas.mu.Lock()
+	h.areas.assignments[areaID] = areaAssignment{dispatcher: dispatcher, by: by}
+	h.areas.mu.Unlock()
+	view := h.controlAreaView(ca)
+	event := "CONTROL_AREA_ASSIGNED"
+	if dispatcher == "" {
+		event = "CONTROL_AREA_RELEASED"
+	}
+	h.audits.append(AuditEntry{
+		SimTime:  h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
+		Event:    event,
+		Category: "security",
+		Severity: "INFO",
+		Object:   map[string]interface{}{"id": areaID, "type": "controlArea"},
+		Details: map[string]interface{}{
+			"dispatcher": dispatcher,
+			"previous":   previous,
+			"by":         by,
+		},
+	})
+	select {
+	case h.events <- &simulation.Event{Name: ControlAreaChangedEvent, Object: view}:
+	case <-h.done:
+	}
+	return view, nil
+}
+
+// uncontrolledArea returns the ID of the first control area of the given
+// track items that user does not control, or an empty string if user may
+// act on all of them. Track items outside all areas can be controlled by
+// everyone.
+func (h *Hub) uncontrolledArea(user string, items []string) string {
+	for _, tiID := range items {
+		areaID := h.sim.ControlAreaOf(tiID)
+		if areaID == "" {
+			continue
+		}
+		if dispatcher := h.areaDispatcher(areaID); user == "" || dispatcher != user {
+			return areaID
+		}
+	}
+	return ""
+}
+
+// areaRestricted returns true if this connection may only act in the
+// control areas assigned to its user. Supervisors and admins act everywhere
+// and observers cannot act at all.
+func (conn *connection) areaRestricted() bool {
+	return conn.role == RoleOperator
+}
+
+// deniedControlArea returns the ID of the control area that conn does not
+// control and that req acts on, or an empty string if conn may make req.
+func (h *Hub) deniedControlArea(conn *connection, req Request) string {
+	if !conn.areaRestricted() || requiredRole(req.Object, req.Action) == RoleObserver {
+		return ""
+	}
+	return h.uncontrolledArea(conn.user, requestItems(h.sim, req.Object, json.RawMessage(req.Params)))
+}
+
+// requestItems returns the IDs of the track items acted on by a request on
+// the given object with the given params. Routes are controlled from their entry
+// signal and trains from the track item of their head.
+func requestItems(s *simulation.Simulation, object string, params json.RawMessage) []string {
+	var p struct {
+		ID              json.RawMessage `json:"id"`
+		Begin           string          `json:"begin"`
+		Via             []string        `json:"via"`
+		SignalID        string          `json:"signalId"`
+		PointsID        string          `json:"pointsId"`
+		LevelCrossingID string          `json:"levelCrossingId"`
+		TrackItemID     string          `json:"trackItemId"`
+		ToTrackItemID   string          `json:"toTrackItemId"`
+	}
+	if len(params) == 0 || json.Unmarshal(params, &p) != nil {
+		return nil
+	}
+	id := rawObjectID(p.ID)
+	var items []string
+	switch object {
+	case "route":
+		if rte, ok := s.Routes[id]; ok {
+			items = append(items, rte.BeginSignalId)
+		}
+		items = append(items, p.Begin)
+		items = append(items, p.Via...)
+	case "train":
+		if idx, err := strconv.Atoi(id); err == nil && idx >= 0 && idx < len(s.Trains) {
+			items = append(items, s.Trains[idx].TrainHead.TrackItemID)
+		}
+	case "trackItem":
+		items = append(items, id, p.SignalID, p.PointsID, p.LevelCrossingID, p.TrackItemID, p.ToTrackItemID)
+	case "possession":
+		items = append(items, p.TrackItemID, p.ToTrackItemID)
+		for _, ps := range s.Possessions() {
+			if ps.ID() == id {
+				items = append(items, ps.TrackItemID, ps.ToTrackItemID)
+			}
+		}
+	case "speedRestriction":
+		items = append(items, p.TrackItemID, p.ToTrackItemID)
+		for _, sr := range s.SpeedRestrictions() {
+			if sr.ID() == id {
+				items = append(items, sr.TrackItemID, sr.ToTrackItemID)
+			}
+		}
+	case "suggestions":
+		if sg, ok := findSuggestion(s, id); ok {
+			items = append(items, suggestionItems(s, sg)...)
+		}
+	}
+	return items
+}
+
+// rawObjectID returns the object ID given as a JSON string or number
+func rawObjectID(raw json.RawMessage) string {
+	var id string
+	if json.Unmarshal(raw, &id) == nil {
+		return id
+	}
+	var num json.Number
+	if json.Unmarshal(raw, &num) == nil {
+		return num.String()
+	}
+	return ""
+}
+
+// suggestionItems returns the IDs of the track items acted on by the
+// actions of the suggestion sg.
+func suggestionItems(s *simulation.Simulation, sg simulation.Suggestion) []string {
+	var items []string
+	for _, a := range sg.Actions {
+		params, err := json.Marshal(a.Params)
+		if err != nil {
+			continue
+		}
+		items = append(items, requestItems(s, a.Object, params)...)
+	}
+	return items
+}
+
+// suggestionsFor returns the suggestions of sgs that the given user may
+// accept, that is those acting only in the control areas of the user or
+// outside all areas.
+func (h *Hub) suggestionsFor(sgs []simulation.Suggestion, user string) []simulation.Suggestion {
+	res := []simulation.Suggestion{}
+	for _, sg := range sgs {
+		if h.uncontrolledArea(user, suggestionItems(h.sim, sg)) == "" {
+			res = append(res, sg)
+		}
+	}
+	return res
+}
+
+// suggestionRouting builds the suggestionsUpdated notifications of the
+// connections restricted to their control areas, once for each user.
+type suggestionRouting struct {
+	h           *Hub
+	se          *sequencedEvent
+	items       []simulation.Suggestion
+	generatedAt json.RawMessage
+	byUser      map[string]*ResponseNotification
+}
+
+// newSuggestionRouting returns the suggestionRouting of the event se, or
+// nil if it is not a suggestionsUpdated event or if the simulation has no
+// control areas.
+//
+// Suggestions are decoded from the encoded object of the event, which is not
+// changed by the simulation.
+func (h *Hub) newSuggestionRouting(se *sequencedEvent) *suggestionRouting {
+	if se.name != simulation.SuggestionsUpdatedEvent || len(h.sim.ControlAreas()) == 0 {
+		return nil
+	}
+	var sgs struct {
+		Items       []simulation.Suggestion `json:"items"`
+		GeneratedAt json.RawMessage         `json:"generatedAt"`
+	}
+	if err := json.Unmarshal(se.object, &sgs); err != nil {
+		logger.Error("Unable to decode suggestions", "submodule", "hub", "error", err)
+		return nil
+	}
+	return &suggestionRouting{h: h, se: se, items: sgs.Items, generatedAt: sgs.GeneratedAt, byUser: make(map[string]*ResponseNotification)}
+}
+
+// notification returns the notification to send to conn instead of the
+// broadcast notification n.
+func (sr *suggestionRouting) notification(conn *connection, n *ResponseNotification) *ResponseNotification {
+	if sr == nil || !conn.areaRestricted() {
+		return n
+	}
+	if rn, ok := sr.byUser[conn.user]; ok {
+		return rn
+	}
+	object, err := json.Marshal(struct {
+		Items       []simulation.Suggestion `json:"items"`
+		GeneratedAt json.RawMessage         `json:"generatedAt"`
+	}{
+		Items:       sr.h.suggestionsFor(sr.items, conn.user),
+		GeneratedAt: sr.generatedAt,
+	})
+	if err != nil {
+		logger.Error("Unable to marshal routed suggestions", "submodule", "hub", "user", conn.user, "error", err)
+	}
+	rn := newBroadcastNotification(&sequencedEvent{
+		seq:    sr.se.seq,
+		name:   sr.se.name,
+		object: object,
+	})
+	sr.byUser[conn.user] = rn
+	return rn
+}
+
+// GET /api/control-areas
+//
+// Returns the control areas of the simulation with their dispatchers.
+func serveControlAreas(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.controlAreaViews()})
+}
+
+// GET /api/control-areas/{id}
+// PUT /api/control-areas/{id}
+//
+// PUT assigns the control area to the dispatcher of the body, or releases it
+// if the dispatcher is empty. It needs the token of a supervisor or an admin.
+func serveControlArea(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	var user UserCredential
+	if r.Method == http.MethodPut {
+		var ok bool
+		if user, ok = requireUser(w, r, h.sim, RoleSupervisor); !ok {
+			return
+		}
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/control-areas/")
+	ca := h.sim.ControlArea(id)
+	if ca == nil {
+		writeAPIError(w, http.StatusNotFound, ErrCodeControlAreaNotFound, "Control area not found", map[string]interface{}{"areaId": id})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(h.controlAreaView(ca))
+	case http.MethodPut:
+		var body struct {
+			Dispatcher *string `json:"dispatcher"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			badRequest(w, err)
+			return
+		}
+		if body.Dispatcher == nil {
+			invalidParameter(w, "dispatcher is required", nil)
+			return
+		}
+		view, err := h.assignControlArea(id, *body.Dispatcher, user.User)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "Unable to assign control area",
+				map[string]interface{}{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(view)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
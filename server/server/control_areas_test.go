@@ -0,0 +1,170 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestControlAreas(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing control areas", t, func() {
+		So(RoleOperator.allows("controlArea", "assign"), ShouldBeFalse)
+		So(RoleSupervisor.allows("controlArea", "assign"), ShouldBeTrue)
+		So(RoleSupervisor.allows("simulation", "restart"), ShouldBeFalse)
+		So(RoleObserver.allows("controlArea", "list"), ShouldBeTrue)
+
+		// The demo simulation with a West area assigned to alice and an
+		// unassigned East area
+		areas := loadDemoWith(func(raw map[string]interface{}) {
+			raw["controlAreas"] = map[string]interface{}{
+				"WEST": map[string]interface{}{"name": "West", "trackItems": []string{"1", "2", "4", "5", "6", "7"}, "dispatcher": "alice"},
+				"EAST": map[string]interface{}{"name": "East", "trackItems": []string{"9", "15", "3", "17"}},
+			}
+		})
+		So(AddSimulation("areas", areas), ShouldBeNil)
+		h, _ := simulations.get("areas")
+		defer func() { So(simulations.remove("areas"), ShouldBeNil) }()
+		request := func(object, params string) Request {
+			return Request{Object: object, Action: "activate", Params: RawJSON(params)}
+		}
+		// auditEvents returns the audit entries of the given event
+		auditEvents := func(event string) []AuditEntry {
+			var res []AuditEntry
+			for _, entry := range h.audits.getSince(0, 1000) {
+				if entry.Event == event {
+					res = append(res, entry)
+				}
+			}
+			return res
+		}
+		alice := &connection{role: RoleOperator, user: "alice"}
+		bob := &connection{role: RoleOperator, user: "bob"}
+
+		Convey("Operators should only act in their control areas", func() {
+			So(h.deniedControlArea(alice, request("route", `{"id": "1"}`)), ShouldBeEmpty)
+			So(h.deniedControlArea(bob, request("route", `{"id": "1"}`)), ShouldEqual, "WEST")
+			So(h.deniedControlArea(alice, request("route", `{"id": "3"}`)), ShouldEqual, "EAST")
+			So(h.deniedControlArea(bob, request("route", `{"id": "11"}`)), ShouldBeEmpty)
+			So(h.deniedControlArea(bob, Request{Object: "route", Action: "setThrough", Params: RawJSON(`{"begin": "5", "end": "3"}`)}), ShouldEqual, "WEST")
+			So(h.deniedControlArea(bob, Request{Object: "train", Action: "proceed", Params: RawJSON(`{"id": 0}`)}), ShouldEqual, "WEST")
+			So(h.deniedControlArea(bob, Request{Object: "trackItem", Action: "operateLevelCrossing", Params: RawJSON(`{"id": "7"}`)}), ShouldEqual, "WEST")
+			So(h.deniedControlArea(bob, Request{Object: "route", Action: "list"}), ShouldBeEmpty)
+			So(h.deniedControlArea(&connection{role: RoleOperator}, request("route", `{"id": "3"}`)), ShouldEqual, "EAST")
+			So(h.deniedControlArea(&connection{role: RoleSupervisor, user: "carol"}, request("route", `{"id": "3"}`)), ShouldBeEmpty)
+		})
+		Convey("Supervisors should reassign control areas live", func() {
+			view, err := h.assignControlArea("EAST", "bob", "carol")
+			So(err, ShouldBeNil)
+			So(view.Dispatcher, ShouldEqual, "bob")
+			So(view.AssignedBy, ShouldEqual, "carol")
+			So(h.deniedControlArea(bob, request("route", `{"id": "3"}`)), ShouldBeEmpty)
+			_, err = h.assignControlArea("WEST", "", "carol")
+			So(err, ShouldBeNil)
+			So(h.deniedControlArea(alice, request("route", `{"id": "1"}`)), ShouldEqual, "WEST")
+			_, err = h.assignControlArea("XX", "bob", "carol")
+			So(err, ShouldEqual, errControlAreaNotFound)
+			views := h.controlAreaViews()
+			So(views, ShouldHaveLength, 2)
+			So(views[0].Dispatcher, ShouldEqual, "bob")
+			So(views[1].Dispatcher, ShouldBeEmpty)
+			So(auditEvents("CONTROL_AREA_RELEASED"), ShouldHaveLength, 1)
+		})
+		Convey("Suggestions should be routed to the responsible dispatcher", func() {
+			suggestion := func(id, route string) simulation.Suggestion {
+				return simulation.Suggestion{ID: id, Actions: []simulation.SuggestionAction{
+					{Object: "route", Action: "activate", Params: map[string]interface{}{"id": route}},
+				}}
+			}
+			items := []simulation.Suggestion{suggestion("W", "1"), suggestion("E", "3"), suggestion("N", "11")}
+			routed := h.suggestionsFor(items, "alice")
+			So(routed, ShouldHaveLength, 2)
+			So(routed[0].ID, ShouldEqual, "W")
+			So(routed[1].ID, ShouldEqual, "N")
+
+			se := h.replay.record(h.newSequencedEvent(&simulation.Event{Name: simulation.SuggestionsUpdatedEvent, Object: simulation.Suggestions{Items: items}}))
+			n := newBroadcastNotification(se)
+			routing := h.newSuggestionRouting(se)
+			So(routing, ShouldNotBeNil)
+			So(routing.notification(&connection{role: RoleAdmin}, n), ShouldEqual, n)
+			an := routing.notification(alice, n)
+			So(an, ShouldNotEqual, n)
+			So(an.Seq, ShouldEqual, n.Seq)
+			So(routing.notification(&connection{role: RoleOperator, user: "alice"}, n), ShouldEqual, an)
+			So(string(an.encoded.json), ShouldContainSubstring, `"id":"W"`)
+			So(string(an.encoded.json), ShouldNotContainSubstring, `"id":"E"`)
+			So(hub.newSuggestionRouting(se), ShouldBeNil)
+		})
+		Convey("Operators should be denied actions outside their areas over websocket", func() {
+			c := clientDial(t)
+			defer c.Close()
+			So(c.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: "client-secret", Role: "operator", User: "bob", SimID: "areas"}}), ShouldBeNil)
+			var resp ResponseStatus
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "route", "activate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, PermissionDenied)
+			So(resp.Data.Message, ShouldContainSubstring, "control area WEST")
+			resp = sendRequestStatus(c, "controlArea", "assign", `{"id": "WEST", "dispatcher": "bob"}`)
+			So(resp.Data.Status, ShouldEqual, PermissionDenied)
+			entries := auditEvents("CONTROL_AREA_DENIED")
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Details["user"], ShouldEqual, "bob")
+		})
+		Convey("Control areas should be served over HTTP", func() {
+			resp, err := http.Get("http://127.0.0.1:22222/api/control-areas")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []interface{} `json:"items"`
+			}
+			So(json.NewDecoder(resp.Body).Decode(&list), ShouldBeNil)
+			resp.Body.Close()
+			So(list.Items, ShouldBeEmpty)
+			// put assigns WEST to bob with the given token and returns the status
+			put := func(token string) int {
+				req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/control-areas/WEST", strings.NewReader(`{"dispatcher": "bob"}`))
+				req.Header.Set("X-User-ID", "sam")
+				req.Header.Set("X-User-Role", "supervisor")
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			So(put(""), ShouldEqual, http.StatusUnauthorized)
+			So(put("client-secret"), ShouldEqual, http.StatusUnauthorized)
+			So(put("alice-secret"), ShouldEqual, http.StatusForbidden)
+			So(put("sam-secret"), ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Operators should be denied batch commands outside their areas", func() {
+			req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:22222/api/v1/simulations/areas/commands",
+				strings.NewReader(`{"commands": [{"object": "route", "action": "activate", "params": {"id": "1"}}]}`))
+			req.Header.Set("Authorization", "Bearer bob-secret")
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			var batch struct {
+				Results []commandResult `json:"results"`
+			}
+			So(json.NewDecoder(resp.Body).Decode(&batch), ShouldBeNil)
+			So(batch.Results, ShouldHaveLength, 1)
+			So(batch.Results[0].Status, ShouldEqual, string(PermissionDenied))
+			So(batch.Results[0].Message, ShouldContainSubstring, "control area WEST")
+			entries := auditEvents("CONTROL_AREA_DENIED")
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Details["user"], ShouldEqual, "bob")
+		})
+	})
+}
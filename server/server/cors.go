@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCORSHeaders are the request headers that browsers may send with
+// cross-origin requests if no headers are configured.
+var defaultCORSHeaders = []string{"Content-Type", "Authorization", "X-User-ID", "X-User-Role"}
+
+// corsExposedHeaders are the response headers that cross-origin scripts may
+// read.
+var corsExposedHeaders = []string{"API-Version", "Deprecation", "Sunset", "Link"}
+
+// CORSConfig is the cross-origin resource sharing policy of the HTTP API and
+// of the websocket endpoint.
+type CORSConfig struct {
+	// AllowedOrigins are the origins (e.g. https://dashboard.example.com)
+	// allowed to call the server from a browser, or "*" for all origins.
+	// Only same-origin requests are allowed if empty.
+	AllowedOrigins []string
+	// AllowedHeaders are the request headers that browsers may send.
+	// Defaults to defaultCORSHeaders.
+	AllowedHeaders []string
+	// MaxAge is the time during which browsers may cache a preflight
+	// response. Browsers use their own default if 0.
+	MaxAge time.Duration
+}
+
+// validate returns an error if c is not a valid CORS policy
+func (c CORSConfig) validate() error {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return fmt.Errorf("invalid CORS origin %q: expecting scheme://host[:port] or *", o)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("CORS max age cannot be negative")
+	}
+	return nil
+}
+
+// allows returns the value of the Access-Control-Allow-Origin header for the
+// given origin, or an empty string if it is not allowed.
+func (c CORSConfig) allows(origin string) string {
+	for _, o := range c.AllowedOrigins {
+		switch {
+		case o == "*":
+			return "*"
+		case strings.EqualFold(strings.TrimSuffix(o, "/"), origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+var (
+	corsMutex  sync.RWMutex
+	corsConfig CORSConfig
+)
+
+// SetCORSConfig sets the cross-origin resource sharing policy of the server
+func SetCORSConfig(c CORSConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = defaultCORSHeaders
+	}
+	corsMutex.Lock()
+	defer corsMutex.Unlock()
+	corsConfig = c
+	return nil
+}
+
+// currentCORSConfig returns the cross-origin resource sharing policy
+func currentCORSConfig() CORSConfig {
+	corsMutex.RLock()
+	defer corsMutex.RUnlock()
+	return corsConfig
+}
+
+// allowCORS adds the CORS headers to the responses of the given handler for
+// the requests of allowed origins, and answers their preflight requests.
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c := currentCORSConfig()
+		w.Header().Add("Vary", "Origin")
+		allowed := c.allows(origin)
+		if allowed == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// checkWebsocketOrigin returns true if a websocket connection may be opened
+// with the request r, which is the case for non browser clients, for pages
+// served by this server and for the allowed CORS origins.
+func checkWebsocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return currentCORSConfig().allows(origin) != ""
+}
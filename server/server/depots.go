@@ -0,0 +1,116 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// trainStablingRequest sends a train to a depot or calls it back from there
+type trainStablingRequest struct {
+    Depot       string `json:"depot"`
+    ServiceCode string `json:"serviceCode"`
+}
+
+// GET /api/depots
+//
+// Returns the depots of the simulation with the trains stabled in them or
+// on their way to them.
+func serveDepots(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.Depots()})
+}
+
+// GET /api/depots/{id}
+func serveDepot(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/depots/")
+    d := h.sim.Depot(id)
+    if d == nil {
+        writeAPIError(w, http.StatusNotFound, ErrCodeDepotNotFound, "Depot not found", map[string]interface{}{"depotId": id})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(d)
+}
+
+// POST, DELETE /api/trains/{trainId}/stabling
+//
+// POST sends the train to the depot given in the body, where it is stabled
+// after a shunting movement. DELETE calls the stabled train back into
+// traffic, with the service given in the body if any.
+func serveTrainStabling(w http.ResponseWriter, r *http.Request, trainID string) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+        methodNotAllowed(w, r)
+        return
+    }
+    tid, err := strconv.Atoi(trainID)
+    if err != nil || tid < 0 || tid >= len(h.sim.Trains) {
+        writeAPIError(w, http.StatusNotFound, ErrCodeTrainNotFound, "Train not found", map[string]interface{}{"trainId": trainID})
+        return
+    }
+    var body trainStablingRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+    }
+    t := h.sim.Trains[tid]
+    res := map[string]interface{}{"status": "OK", "trainId": trainID}
+    switch r.Method {
+    case http.MethodPost:
+        if h.sim.Depot(body.Depot) == nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodeDepotNotFound, "Depot not found", map[string]interface{}{"depotId": body.Depot})
+            return
+        }
+        path, err := t.SendToDepot(body.Depot)
+        if err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"trainId": trainID, "depot": body.Depot})
+            return
+        }
+        res["depot"] = body.Depot
+        res["routes"] = []string{}
+        if path != nil {
+            res["routes"] = path.RouteIDs()
+        }
+    case http.MethodDelete:
+        if body.ServiceCode != "" {
+            if _, ok := h.sim.Services[body.ServiceCode]; !ok {
+                writeAPIError(w, http.StatusNotFound, ErrCodeServiceNotFound, "Service not found", map[string]interface{}{"serviceId": body.ServiceCode})
+                return
+            }
+        }
+        if err := t.CallBack(body.ServiceCode); err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"trainId": trainID})
+            return
+        }
+        res["serviceCode"] = t.ServiceCode
+    }
+    res["trainStatus"] = trainStatusToString(t.Status)
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
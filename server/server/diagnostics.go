@@ -0,0 +1,351 @@
+package server
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "runtime"
+    "runtime/pprof"
+    "runtime/trace"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+    // minDebugTokenLength is the minimum length of the debug token
+    minDebugTokenLength = 16
+    // maxProfileSeconds is the longest CPU profile or trace that can be taken
+    maxProfileSeconds = 120
+)
+
+// Names of the operations of the server measured in the runtime diagnostics
+const (
+    operationHubEvent   = "hub.event"
+    operationHubRequest = "hub.request"
+    operationHTTP       = "http.request"
+    operationMetrics    = "metrics.snapshot"
+)
+
+var (
+    debugToken      string
+    debugTokenMutex sync.RWMutex
+)
+
+// debugTokenHeader is the header of the HTTP requests that holds the debug
+// token
+const debugTokenHeader = "X-Debug-Token"
+
+// SetDebugToken enables the debug endpoints of the API for the admin clients
+// that give token as a bearer token.
+func SetDebugToken(token string) error {
+    if len(token) < minDebugTokenLength {
+        return fmt.Errorf("debug token must be at least %d characters long", minDebugTokenLength)
+    }
+    debugTokenMutex.Lock()
+    defer debugTokenMutex.Unlock()
+    debugToken = token
+    return nil
+}
+
+// currentDebugToken returns the debug token, or an empty string if the debug
+// endpoints are disabled
+func currentDebugToken() string {
+    debugTokenMutex.RLock()
+    defer debugTokenMutex.RUnlock()
+    return debugToken
+}
+
+// requireDebugAccess checks that r may use the debug endpoints and writes an
+// error response if not. The debug endpoints are not found unless a debug
+// token is set, and need the token in the X-Debug-Token header and the
+// bearer token of an admin user.
+func requireDebugAccess(w http.ResponseWriter, r *http.Request) bool {
+    token := currentDebugToken()
+    if token == "" {
+        serveAPINotFound(w, r)
+        return false
+    }
+    given := r.Header.Get(debugTokenHeader)
+    if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+        writeAPIError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid debug token", nil)
+        return false
+    }
+    _, ok := requireUser(w, r, requestHub(r).sim, RoleAdmin)
+    return ok
+}
+
+// An operationTiming holds the durations of an operation
+type operationTiming struct {
+    count int64
+    total time.Duration
+    max   time.Duration
+    last  time.Duration
+}
+
+// operationTimings records the durations of the operations of the server
+// and of the simulations, by operation name.
+type operationTimings struct {
+    mutex      sync.Mutex
+    operations map[string]*operationTiming
+}
+
+// timings holds the durations of the operations since the server started
+var timings = &operationTimings{operations: make(map[string]*operationTiming)}
+
+// record adds a run of the given operation that lasted d
+func (ot *operationTimings) record(name string, d time.Duration) {
+    ot.mutex.Lock()
+    defer ot.mutex.Unlock()
+    op, ok := ot.operations[name]
+    if !ok {
+        op = new(operationTiming)
+        ot.operations[name] = op
+    }
+    op.count++
+    op.total += d
+    op.last = d
+    if d > op.max {
+        op.max = d
+    }
+}
+
+// since records a run of the given operation started at start
+func (ot *operationTimings) since(name string, start time.Time) {
+    ot.record(name, time.Since(start))
+}
+
+// report returns the timings of each operation in milliseconds
+func (ot *operationTimings) report() map[string]interface{} {
+    ot.mutex.Lock()
+    defer ot.mutex.Unlock()
+    ms := func(d time.Duration) float64 {
+        return float64(d) / float64(time.Millisecond)
+    }
+    res := make(map[string]interface{}, len(ot.operations))
+    for name, op := range ot.operations {
+        res[name] = map[string]interface{}{
+            "count":   op.count,
+            "totalMs": ms(op.total),
+            "avgMs":   ms(op.total / time.Duration(op.count)),
+            "maxMs":   ms(op.max),
+            "lastMs":  ms(op.last),
+        }
+    }
+    return res
+}
+
+// diagnosticsTracer measures the steps and the suggestion computations of the
+// simulations for the runtime diagnostics, and reports them to telemetry when
+// it is configured.
+type diagnosticsTracer struct{}
+
+// StartOperation starts measuring the given operation
+func (diagnosticsTracer) StartOperation(sim *simulation.Simulation, name string) func() {
+    start := time.Now()
+    end := func() {}
+    if currentTelemetry() != nil {
+        end = simulationTracer{}.StartOperation(sim, name)
+    }
+    return func() {
+        end()
+        timings.since(name, start)
+    }
+}
+
+// queueDepth returns the depth of a queue for the runtime diagnostics
+func queueDepth(length, capacity int) map[string]int {
+    return map[string]int{"length": length, "capacity": capacity}
+}
+
+// pushQueues returns the number of connections of h with listeners, and the
+// total and largest number of messages waiting to be written to them.
+func (h *Hub) pushQueues() map[string]int {
+    h.registryMutex.RLock()
+    defer h.registryMutex.RUnlock()
+    conns := make(map[*connection]bool)
+    for _, rv := range h.registry {
+        for c := range rv {
+            conns[c] = true
+        }
+    }
+    var pending, largest int
+    for c := range conns {
+        n := len(c.pushChan)
+        pending += n
+        if n > largest {
+            largest = n
+        }
+    }
+    return map[string]int{"connections": len(conns), "pending": pending, "largest": largest}
+}
+
+// runtimeDiagnostics returns the runtime statistics of the server
+func runtimeDiagnostics() map[string]interface{} {
+    var ms runtime.MemStats
+    runtime.ReadMemStats(&ms)
+    var lastGC string
+    if ms.LastGC > 0 {
+        lastGC = time.Unix(0, int64(ms.LastGC)).UTC().Format(time.RFC3339)
+    }
+    hubs := simulations.list()
+    sort.Slice(hubs, func(i, j int) bool { return hubs[i].id < hubs[j].id })
+    sims := make([]map[string]interface{}, 0, len(hubs))
+    for _, h := range hubs {
+        h.audits.mu.RLock()
+        auditSubscribers := len(h.audits.subscribers)
+        h.audits.mu.RUnlock()
+        sims = append(sims, map[string]interface{}{
+            "simulationId":     h.id,
+            "started":          h.sim.IsStarted(),
+            "trains":           len(h.sim.Trains),
+            "trackItems":       len(h.sim.TrackItems),
+            "clients":          atomic.LoadInt32(&h.clientCount),
+            "pushQueues":       h.pushQueues(),
+            "auditSubscribers": auditSubscribers,
+        })
+    }
+    queues := make(map[string]interface{})
+    if p := currentMQTTPublisher(); p != nil {
+        queues["mqtt"] = queueDepth(len(p.queue), cap(p.queue))
+    }
+    if p := currentKafkaProducer(); p != nil {
+        queues["kafka"] = queueDepth(p.pendingCount(), p.config.BufferSize)
+    }
+    if sw := currentSuggestionWebhook(); sw != nil {
+        queues["suggestionWebhook"] = queueDepth(len(sw.queue), cap(sw.queue))
+    }
+    return map[string]interface{}{
+        "goVersion":     runtime.Version(),
+        "numCPU":        runtime.NumCPU(),
+        "gomaxprocs":    runtime.GOMAXPROCS(0),
+        "uptimeSeconds": time.Since(processStart).Seconds(),
+        "goroutines":    runtime.NumGoroutine(),
+        "memory": map[string]interface{}{
+            "heapAllocBytes":   ms.HeapAlloc,
+            "heapInuseBytes":   ms.HeapInuse,
+            "heapObjects":      ms.HeapObjects,
+            "sysBytes":         ms.Sys,
+            "totalAllocBytes":  ms.TotalAlloc,
+            "gcCycles":         ms.NumGC,
+            "gcPauseTotalMs":   float64(ms.PauseTotalNs) / float64(time.Millisecond),
+            "lastGcPauseMs":    float64(ms.PauseNs[(ms.NumGC+255)%256]) / float64(time.Millisecond),
+            "lastGc":           lastGC,
+            "nextGcBytes":      ms.NextGC,
+        },
+        "connections": connStats.report(),
+        "simulations": sims,
+        "queues":      queues,
+        "timings":     timings.report(),
+    }
+}
+
+// GET /api/debug/runtime
+//
+// Returns the runtime statistics of the server: goroutines, memory, queue
+// depths and timings of the operations of each subsystem.
+func serveDebugRuntime(w http.ResponseWriter, r *http.Request) {
+    if !requireDebugAccess(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(runtimeDiagnostics())
+}
+
+// GET /api/debug/pprof/
+// GET /api/debug/pprof/{profile}?debug=N&gc=1
+// GET /api/debug/pprof/profile?seconds=N
+// GET /api/debug/pprof/trace?seconds=N
+//
+// Serves the profiles of the server in the format of net/http/pprof, for use
+// with go tool pprof.
+func serveDebugPprof(w http.ResponseWriter, r *http.Request) {
+    if !requireDebugAccess(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    name := strings.TrimPrefix(r.URL.Path, "/api/debug/pprof/")
+    switch name {
+    case "":
+        var items []map[string]interface{}
+        for _, p := range pprof.Profiles() {
+            items = append(items, map[string]interface{}{"name": p.Name(), "count": p.Count()})
+        }
+        items = append(items,
+            map[string]interface{}{"name": "profile"},
+            map[string]interface{}{"name": "trace"},
+        )
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    case "profile", "trace":
+        seconds := 30
+        if s := r.URL.Query().Get("seconds"); s != "" {
+            var err error
+            seconds, err = strconv.Atoi(s)
+            if err != nil || seconds <= 0 || seconds > maxProfileSeconds {
+                invalidParameter(w, fmt.Sprintf("seconds must be between 1 and %d", maxProfileSeconds),
+                    map[string]interface{}{"seconds": s})
+                return
+            }
+        }
+        serveTimedProfile(w, r, name, time.Duration(seconds)*time.Second)
+    default:
+        p := pprof.Lookup(name)
+        if p == nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "Unknown profile", map[string]interface{}{"profile": name})
+            return
+        }
+        if name == "heap" && r.URL.Query().Get("gc") != "" {
+            runtime.GC()
+        }
+        debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+        if debug > 0 {
+            w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        } else {
+            w.Header().Set("Content-Type", "application/octet-stream")
+            w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+        }
+        _ = p.WriteTo(w, debug)
+    }
+}
+
+// serveTimedProfile writes a CPU profile or an execution trace of the given
+// duration. Only one of them can run at a time.
+func serveTimedProfile(w http.ResponseWriter, r *http.Request, name string, d time.Duration) {
+    w.Header().Set("Content-Type", "application/octet-stream")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+    var err error
+    if name == "trace" {
+        err = trace.Start(w)
+    } else {
+        err = pprof.StartCPUProfile(w)
+    }
+    if err != nil {
+        w.Header().Del("Content-Disposition")
+        writeAPIError(w, http.StatusConflict, ErrCodeConflict, "A profile is already running",
+            map[string]interface{}{"error": err.Error()})
+        return
+    }
+    select {
+    case <-time.After(d):
+    case <-r.Context().Done():
+    }
+    if name == "trace" {
+        trace.Stop()
+        return
+    }
+    pprof.StopCPUProfile()
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// debugRequest sends a GET request to the given debug endpoint with the
+// given debug token and user token
+func debugRequest(path, token, user string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:22222"+path, nil)
+	So(err, ShouldBeNil)
+	if token != "" {
+		req.Header.Set("X-Debug-Token", token)
+	}
+	if user != "" {
+		req.Header.Set("Authorization", "Bearer "+user)
+	}
+	// The role header of the client is not trusted
+	req.Header.Set("X-User-Role", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	So(err, ShouldBeNil)
+	return resp
+}
+
+func TestDiagnostics(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the diagnostics endpoints", t, func() {
+		token := "0123456789abcdef-debug"
+		Convey("Debug endpoints should be disabled without a token", func() {
+			So(SetDebugToken("short"), ShouldNotBeNil)
+			resp := debugRequest("/api/debug/runtime", token, "admin-secret")
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Debug endpoints should need the token and the admin role", func() {
+			So(SetDebugToken(token), ShouldBeNil)
+			defer func() {
+				debugTokenMutex.Lock()
+				debugToken = ""
+				debugTokenMutex.Unlock()
+			}()
+			for _, c := range []struct {
+				token, user string
+				status      int
+				code        string
+			}{
+				{"", "admin-secret", http.StatusUnauthorized, ErrCodeUnauthorized},
+				{"wrong-token-0123456789", "admin-secret", http.StatusUnauthorized, ErrCodeUnauthorized},
+				{token, "alice-secret", http.StatusForbidden, ErrCodeForbidden},
+				{token, "client-secret", http.StatusUnauthorized, ErrCodeUnauthorized},
+				{token, "", http.StatusUnauthorized, ErrCodeUnauthorized},
+			} {
+				resp := debugRequest("/api/debug/pprof/heap", c.token, c.user)
+				var body map[string]map[string]interface{}
+				So(json.NewDecoder(resp.Body).Decode(&body), ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, c.status)
+				So(body["error"]["code"], ShouldEqual, c.code)
+			}
+
+			Convey("The runtime endpoint should report goroutines, memory, queues and timings", func() {
+				diagnosticsTracer{}.StartOperation(hub.sim, simulation.OperationStep)()
+				hub.takeSnapshot()
+				resp := debugRequest("/api/v1/debug/runtime", token, "admin-secret")
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				var rt map[string]interface{}
+				So(json.NewDecoder(resp.Body).Decode(&rt), ShouldBeNil)
+				So(rt["goroutines"], ShouldBeGreaterThan, 0)
+				So(rt["memory"].(map[string]interface{})["heapAllocBytes"], ShouldBeGreaterThan, 0)
+				So(rt["queues"], ShouldNotBeNil)
+				sims := rt["simulations"].([]interface{})
+				So(sims, ShouldNotBeEmpty)
+				So(sims[0].(map[string]interface{}), ShouldContainKey, "pushQueues")
+				tm := rt["timings"].(map[string]interface{})
+				So(tm, ShouldContainKey, simulation.OperationStep)
+				So(tm, ShouldContainKey, operationMetrics)
+				So(tm, ShouldContainKey, operationHTTP)
+				So(tm[operationMetrics].(map[string]interface{})["count"], ShouldBeGreaterThan, 0)
+			})
+			Convey("Profiles should be served", func() {
+				resp := debugRequest("/api/debug/pprof/", token, "admin-secret")
+				var index map[string][]map[string]interface{}
+				So(json.NewDecoder(resp.Body).Decode(&index), ShouldBeNil)
+				resp.Body.Close()
+				var names []interface{}
+				for _, p := range index["items"] {
+					names = append(names, p["name"])
+				}
+				So(names, ShouldContain, "heap")
+				So(names, ShouldContain, "goroutine")
+				So(names, ShouldContain, "profile")
+
+				resp = debugRequest("/api/debug/pprof/goroutine?debug=1", token, "admin-secret")
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldContainSubstring, "goroutine profile:")
+
+				resp = debugRequest("/api/debug/pprof/heap", token, "admin-secret")
+				body, _ = ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldEqual, "application/octet-stream")
+				So(body, ShouldNotBeEmpty)
+
+				resp = debugRequest("/api/debug/pprof/profile?seconds=1", token, "admin-secret")
+				body, _ = ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(body, ShouldNotBeEmpty)
+
+				resp = debugRequest("/api/debug/pprof/profile?seconds=1000", token, "admin-secret")
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+				resp = debugRequest("/api/debug/pprof/unknown", token, "admin-secret")
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
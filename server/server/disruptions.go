@@ -0,0 +1,139 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// disruptionRequest is the body of a disruption creation request, from HTTP
+// or from the hub.
+type disruptionRequest struct {
+    Type            string  `json:"type"`
+    TrackItemID     string  `json:"trackItemId"`
+    ToTrackItemID   string  `json:"toTrackItemId"`
+    SpeedLimit      float64 `json:"speedLimit"`
+    StartTime       string  `json:"startTime"`
+    EndTime         string  `json:"endTime"`
+    DurationMinutes int     `json:"durationMinutes"`
+    Reason          string  `json:"reason"`
+    FailureMode     string  `json:"failureMode"`
+}
+
+// parseSimTime parses a HH:MM:SS time, where hours of 24 and more are times
+// of the following days, or a RFC3339 date time if s has a start date. An
+// empty string gives a zero time.
+func parseSimTime(s *simulation.Simulation, value string) (simulation.Time, error) {
+    if value == "" {
+        return simulation.Time{}, nil
+    }
+    if t, ok := s.ParseDateTime(value); ok {
+        return simulation.Time{Time: t}, nil
+    }
+    if simulation.ParseTime(value).IsZero() {
+        return simulation.Time{}, fmt.Errorf("invalid time %q, expected HH:MM:SS", value)
+    }
+    return simulation.ParseTime(value), nil
+}
+
+// injectDisruption creates the disruption described by dr and adds it to the simulation s
+func injectDisruption(s *simulation.Simulation, dr disruptionRequest) (*simulation.Disruption, error) {
+    d := &simulation.Disruption{
+        Type:          simulation.DisruptionType(strings.ToUpper(dr.Type)),
+        TrackItemID:   dr.TrackItemID,
+        ToTrackItemID: dr.ToTrackItemID,
+        SpeedLimit:    dr.SpeedLimit,
+        Reason:        dr.Reason,
+        FailureMode:   simulation.TrackCircuitFailureMode(strings.ToUpper(dr.FailureMode)),
+    }
+    start, err := parseSimTime(s, dr.StartTime)
+    if err != nil {
+        return nil, err
+    }
+    end, err := parseSimTime(s, dr.EndTime)
+    if err != nil {
+        return nil, err
+    }
+    if dr.DurationMinutes < 0 {
+        return nil, fmt.Errorf("durationMinutes must be positive")
+    }
+    if end.IsZero() && dr.DurationMinutes > 0 {
+        from := start.Time
+        if from.IsZero() {
+            from = s.Options.CurrentTime.Time
+        }
+        end.Time = from.Add(time.Duration(dr.DurationMinutes) * time.Minute)
+    }
+    d.StartTime.Time = start.Time
+    d.EndTime.Time = end.Time
+    if err := s.AddDisruption(d); err != nil {
+        return nil, err
+    }
+    return d, nil
+}
+
+// GET /api/disruptions
+// POST /api/disruptions
+func serveDisruptions(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.Disruptions()})
+    case http.MethodPost:
+        var body disruptionRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        d, err := injectDisruption(h.sim, body)
+        if err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", resourceLocation(r, "/api/disruptions/"+d.ID()))
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(d)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/disruptions/{id}
+// DELETE /api/disruptions/{id}
+func serveDisruption(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/disruptions/")
+    switch r.Method {
+    case http.MethodGet:
+        d, ok := h.sim.GetDisruption(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeDisruptionNotFound, "Disruption not found", map[string]interface{}{"disruptionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(d)
+    case http.MethodDelete:
+        if err := h.sim.RemoveDisruption(id); err != nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodeDisruptionNotFound, "Disruption not found", map[string]interface{}{"disruptionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
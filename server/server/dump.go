@@ -0,0 +1,233 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+	// defaultDumpChunkSize is the size of the chunks of a chunked dump if
+	// the client does not give one.
+	defaultDumpChunkSize = 64 * 1024
+	minDumpChunkSize     = 1024
+	maxDumpChunkSize     = 1024 * 1024
+)
+
+// errConnectionClosed is returned when a chunk cannot be sent because the
+// connection of the client is closed
+var errConnectionClosed = errors.New("connection closed")
+
+// A dumpRequest holds the params of the simulation dump action.
+//
+// With Chunked, the dump is sent in chunks of ChunkSize bytes as ResponseChunk
+// messages followed by a response, instead of a single response.
+type dumpRequest struct {
+	Chunked   bool `json:"chunked"`
+	ChunkSize int  `json:"chunkSize"`
+}
+
+// DataChunk is the Data part of a ResponseChunk message. The Data of all the
+// chunks of a response, in Index order, make up the response.
+type DataChunk struct {
+	Index int    `json:"index"`
+	Last  bool   `json:"last"`
+	Data  string `json:"data"`
+}
+
+// ResponseChunk is a part of a response too large to be sent in a single
+// message.
+type ResponseChunk struct {
+	ID      int         `json:"id"`
+	MsgType MessageType `json:"msgType"`
+	Data    DataChunk   `json:"data"`
+}
+
+// A chunkWriter sends the data written to it to a websocket client as
+// ResponseChunk messages of at most size bytes. Chunks are cut on UTF-8
+// character boundaries.
+type chunkWriter struct {
+	conn  *connection
+	id    int
+	size  int
+	buf   []byte
+	index int
+	total int
+}
+
+// Write sends the complete chunks of p, and keeps the rest for the next
+// call.
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	for len(cw.buf) > cw.size {
+		n := cw.size
+		for n > 0 && !utf8.RuneStart(cw.buf[n]) {
+			n--
+		}
+		if err := cw.send(cw.buf[:n], false); err != nil {
+			return 0, err
+		}
+		cw.buf = append(cw.buf[:0], cw.buf[n:]...)
+	}
+	return len(p), nil
+}
+
+// Close sends the remaining data as the last chunk
+func (cw *chunkWriter) Close() error {
+	return cw.send(cw.buf, true)
+}
+
+// send pushes a chunk to the client
+func (cw *chunkWriter) send(data []byte, last bool) error {
+	msg := &ResponseChunk{
+		ID:      cw.id,
+		MsgType: TypeChunk,
+		Data:    DataChunk{Index: cw.index, Last: last, Data: string(data)},
+	}
+	select {
+	case cw.conn.pushChan <- msg:
+	case <-cw.conn.context().Done():
+		return errConnectionClosed
+	}
+	cw.index++
+	cw.total += len(data)
+	return nil
+}
+
+// dumpSimulation answers a simulation dump request of conn.
+//
+// The dump is encoded while it is written, so that chunked dumps of large
+// simulations are sent without holding the whole document in memory.
+func (h *Hub) dumpSimulation(req Request, conn *connection, ch chan<- interface{}) {
+	var params dumpRequest
+	if req.Params != nil {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+	}
+	if !params.Chunked {
+		var buf bytes.Buffer
+		if err := h.sim.WriteJSON(&buf); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, buf.Bytes())
+		return
+	}
+	if params.ChunkSize == 0 {
+		params.ChunkSize = defaultDumpChunkSize
+	}
+	if params.ChunkSize < minDumpChunkSize || params.ChunkSize > maxDumpChunkSize {
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("chunkSize must be between %d and %d", minDumpChunkSize, maxDumpChunkSize))
+		return
+	}
+	cw := &chunkWriter{conn: conn, id: req.ID, size: params.ChunkSize}
+	err := h.sim.WriteJSON(cw)
+	if err == nil {
+		err = cw.Close()
+	}
+	if err != nil {
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("error while dumping simulation: %s", err))
+		return
+	}
+	j, _ := json.Marshal(map[string]int{"chunks": cw.index, "size": cw.total})
+	ch <- NewResponse(req.ID, j)
+}
+
+// simulationSnapshot is the gzip compressed JSON encoding of a simulation,
+// from which the simulation can be rebuilt.
+type simulationSnapshot []byte
+
+// takeSimulationSnapshot returns a snapshot of s. The simulation is encoded
+// through the compressor, so that its uncompressed encoding is never held in
+// memory.
+func takeSimulationSnapshot(s *simulation.Simulation) (simulationSnapshot, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := s.WriteJSON(zw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restore rebuilds the simulation of this snapshot in s
+func (ss simulationSnapshot) restore(s *simulation.Simulation) error {
+	zr, err := gzip.NewReader(bytes.NewReader(ss))
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(zr).Decode(s)
+}
+
+// GET /api/simulation/dump
+//
+// Streams the complete dump of the simulation, as the simulation dump action
+// of the websocket API. The response is sent with chunked transfer encoding,
+// gzip compressed if the client accepts it.
+func serveSimulationDump(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !acceptsGzip(r) {
+		if err := h.sim.WriteJSON(w); err != nil {
+			logger.Warn("Unable to stream simulation dump", "submodule", "http", "error", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	zw := gzip.NewWriter(w)
+	err := h.sim.WriteJSON(zw)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		logger.Warn("Unable to stream simulation dump", "submodule", "http", "error", err)
+	}
+}
+
+// acceptsGzip returns true if the client of r accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// replayBufferSize is the number of broadcast events kept for resuming clients.
+const replayBufferSize = 4096
+
+// A sequencedEvent is an event broadcast by the hub with its sequence number
+// and the state of its object at that time.
+//
+// It holds no pointer to the objects of the simulation, which keeps changing
+// them: object is the encoding of the object when the event was sent and
+// subject is what it related to at that time, for listener filters.
+type sequencedEvent struct {
+	seq      uint64
+	name     simulation.EventName
+	objectID string
+	object   RawJSON
+	subject  eventSubject
+	// private is the message of the event if it is sent to a single user
+	private *ChatMessage
+}
+
+// newSequencedEvent returns the sequencedEvent of e, which is not numbered
+// until it is recorded in the replay buffer.
+//
+// The objects of simulation events are encoded by the simulation when they
+// are sent. Only the events of the server are encoded here, their objects
+// being built for the event and never changed afterwards.
+func (h *Hub) newSequencedEvent(e *simulation.Event) *sequencedEvent {
+	object := RawJSON(e.Data)
+	if object == nil {
+		var err error
+		if object, err = json.Marshal(e.Object); err != nil {
+			logger.Error("Unable to marshal event object", "submodule", "hub", "event", e.Name, "error", err)
+		}
+	}
+	return &sequencedEvent{
+		name:     e.Name,
+		objectID: e.Object.ID(),
+		object:   object,
+		subject:  h.eventSubject(e),
+		private:  privateChatMessage(e),
+	}
+}
+
+// notification returns the ResponseNotification to replay this event.
+func (se *sequencedEvent) notification() *ResponseNotification {
+	return &ResponseNotification{
+		MsgType: TypeNotification,
+		Seq:     se.seq,
+		Data: DataEvent{
+			Name:   se.name,
+			Object: se.object,
+		},
+	}
+}
+
+// replayBuffer is a bounded buffer of the last broadcast events.
+//
+// Once full, events is used as a ring in which first is the index of the
+// oldest event.
+type replayBuffer struct {
+	sync.RWMutex
+	lastSeq uint64
+	events  []*sequencedEvent
+	first   int
+}
+
+// record assigns the next sequence number to se and keeps it in the buffer,
+// dropping the oldest event if the buffer is full.
+func (rb *replayBuffer) record(se *sequencedEvent) *sequencedEvent {
+	rb.Lock()
+	defer rb.Unlock()
+	rb.lastSeq++
+	se.seq = rb.lastSeq
+	if len(rb.events) < replayBufferSize {
+		rb.events = append(rb.events, se)
+		return se
+	}
+	rb.events[rb.first] = se
+	rb.first = (rb.first + 1) % len(rb.events)
+	return se
+}
+
+// since returns the events recorded after the event with the given sequence
+// number. It returns an error if some of these events are no longer in the
+// buffer or if lastSeq has never been sent.
+func (rb *replayBuffer) since(lastSeq uint64) ([]*sequencedEvent, error) {
+	rb.RLock()
+	defer rb.RUnlock()
+	if lastSeq > rb.lastSeq {
+		return nil, fmt.Errorf("unknown event id %d (last is %d)", lastSeq, rb.lastSeq)
+	}
+	if lastSeq == rb.lastSeq {
+		return nil, nil
+	}
+	if len(rb.events) == 0 || rb.events[rb.first].seq > lastSeq+1 {
+		return nil, fmt.Errorf("events after %d are no longer available, full resync required", lastSeq)
+	}
+	start := int(lastSeq + 1 - rb.events[rb.first].seq)
+	res := make([]*sequencedEvent, len(rb.events)-start)
+	for i := range res {
+		res[i] = rb.events[(rb.first+start+i)%len(rb.events)]
+	}
+	return res, nil
+}
+
+// resumeClient sends to conn the events it missed since lastSeq, for the
+// listeners it has currently registered.
+func (h *Hub) resumeClient(req Request, conn *connection) (int, error) {
+	var params struct {
+		LastEventID *uint64 `json:"lastEventId"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return 0, fmt.Errorf("unparsable request: %s (%s)", err, req.Params)
+	}
+	if params.LastEventID == nil {
+		return 0, fmt.Errorf("missing lastEventId")
+	}
+	events, err := h.replay.since(*params.LastEventID)
+	if err != nil {
+		return 0, err
+	}
+	h.registryMutex.RLock()
+	defer h.registryMutex.RUnlock()
+	var count int
+	for _, se := range events {
+		filter, ok := h.registry[registryEntry{eventName: se.name, id: ""}][conn]
+		if !ok && se.objectID != "" {
+			filter, ok = h.registry[registryEntry{eventName: se.name, id: se.objectID}][conn]
+		}
+		if !ok || !filter.matches(h.sim, se.subject) || !se.private.visibleTo(conn.user) {
+			continue
+		}
+		conn.pushChan <- se.notification()
+		count++
+	}
+	return count, nil
+}
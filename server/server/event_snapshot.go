@@ -0,0 +1,137 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// eventSnapshot is the state of a simulation that the hub needs to handle an
+// event besides its encoded object, for the audit log, the metrics, the
+// scores and the MQTT messages.
+type eventSnapshot struct {
+	// simTime is the simulation time when the event was sent
+	simTime time.Time
+	// audit is the audit entry of the event, nil if it is not audited
+	audit *AuditEntry
+	// train is the state of the train of a station or cancellation event
+	train *trainSnapshot
+	// transferMissed is true for the events of missed transfers
+	transferMissed bool
+	// suggestions are the items of a suggestionsUpdated event
+	suggestions []simulation.Suggestion
+}
+
+// trainSnapshot is the state of a train at a station or cancellation event
+type trainSnapshot struct {
+	serviceCode string
+	hasService  bool
+	weight      float64
+	// stop is the line of the service of the train at which it arrived or
+	// from which it departed, nil if there is none.
+	stop *stopSnapshot
+}
+
+// stopSnapshot is a line of the service of a train at a station event
+type stopSnapshot struct {
+	placeCode string
+	// scheduled is the scheduled arrival or departure time, zero if the line
+	// has none.
+	scheduled time.Time
+	// passengers is the number of passengers who alighted at an arrival or
+	// boarded at a departure.
+	passengers float64
+}
+
+func init() {
+	simulation.RegisterEventSnapshotter(takeEventSnapshot)
+}
+
+// takeEventSnapshot is the simulation.EventSnapshotter of the server.
+func takeEventSnapshot(sim *simulation.Simulation, e *simulation.Event) interface{} {
+	return newEventSnapshot(sim, e)
+}
+
+// newEventSnapshot returns the snapshot of the given event of sim.
+func newEventSnapshot(sim *simulation.Simulation, e *simulation.Event) *eventSnapshot {
+	now := sim.CurrentTime()
+	s := &eventSnapshot{simTime: now}
+	if entry, ok := auditEntryFromEvent(sim, e, now); ok {
+		s.audit = &entry
+	}
+	switch e.Name {
+	case simulation.TrainStoppedAtStationEvent, simulation.TrainDepartedFromStationEvent, simulation.TrainCancelledEvent:
+		if t, ok := e.Object.(*simulation.Train); ok {
+			s.train = newTrainSnapshot(t, e.Name)
+		}
+	case simulation.TransferChangedEvent:
+		if tr, ok := e.Object.(*simulation.Transfer); ok {
+			s.transferMissed = tr.Status() == simulation.TransferMissed
+		}
+	case simulation.SuggestionsUpdatedEvent:
+		// Suggestions object is sent by value, but not its items
+		if sug, ok := e.Object.(simulation.Suggestions); ok {
+			s.suggestions = append([]simulation.Suggestion{}, sug.Items...)
+		}
+	}
+	return s
+}
+
+// newTrainSnapshot returns the snapshot of train t at the event with the
+// given name.
+func newTrainSnapshot(t *simulation.Train, name simulation.EventName) *trainSnapshot {
+	ts := &trainSnapshot{serviceCode: t.ServiceCode, weight: t.Priority().Weight()}
+	line := t.Service()
+	if line == nil {
+		return ts
+	}
+	ts.hasService = true
+	boarded, alighted := t.LastPassengerExchange()
+	switch name {
+	case simulation.TrainStoppedAtStationEvent:
+		if t.NextPlaceIndex < len(line.Lines) {
+			sl := line.Lines[t.NextPlaceIndex]
+			ts.stop = &stopSnapshot{placeCode: sl.PlaceCode, scheduled: sl.ScheduledArrivalTime.Time, passengers: alighted}
+		}
+	case simulation.TrainDepartedFromStationEvent:
+		prevIdx := t.NextPlaceIndex - 1
+		if prevIdx >= 0 && prevIdx < len(line.Lines) {
+			sl := line.Lines[prevIdx]
+			ts.stop = &stopSnapshot{placeCode: sl.PlaceCode, scheduled: sl.ScheduledDepartureTime.Time, passengers: boarded}
+		}
+	}
+	return ts
+}
+
+// eventSnapshot returns the snapshot of the given event taken by the
+// simulation when it was sent.
+//
+// The events built by the server have no snapshot. Theirs is taken on the
+// first call, from the hub goroutine, their objects not being changed by
+// the simulation.
+func (h *Hub) eventSnapshot(e *simulation.Event) *eventSnapshot {
+	if s, ok := e.Snapshot.(*eventSnapshot); ok {
+		return s
+	}
+	s := newEventSnapshot(h.sim, e)
+	e.Snapshot = s
+	return s
+}
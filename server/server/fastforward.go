@@ -0,0 +1,142 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// A fastForwardRequest asks to run the simulation headlessly until the given
+// HH:MM:SS simulation time, or RFC3339 date time if the simulation has a
+// start date.
+type fastForwardRequest struct {
+    Until string `json:"until"`
+}
+
+// A stepRequest asks to advance a paused simulation by the given number of
+// simulation seconds, or by a single tick if Seconds is zero.
+type stepRequest struct {
+    Seconds int `json:"seconds"`
+}
+
+// nextTimeOfDay returns the next occurrence of the HH:MM:SS time of day
+// s after now, so that simulation times may be given past midnight.
+func nextTimeOfDay(now time.Time, s string) (time.Time, error) {
+    t, err := time.Parse("15:04:05", s)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM:SS", s)
+    }
+    target := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+    if !target.After(now) {
+        target = target.AddDate(0, 0, 1)
+    }
+    return target, nil
+}
+
+// fastForward runs the simulation of h as fast as possible until the time
+// asked by req and returns the simulation time reached. A running simulation
+// is paused during the run and started again afterwards, unless a breakpoint
+// has been hit.
+func (h *Hub) fastForward(req fastForwardRequest) (string, error) {
+    h.restartMutex.Lock()
+    defer h.restartMutex.Unlock()
+    var err error
+    target, ok := h.sim.ParseDateTime(req.Until)
+    if !ok {
+        target, err = nextTimeOfDay(h.sim.Options.CurrentTime.Time, req.Until)
+        if err != nil {
+            return "", err
+        }
+    }
+    started := h.sim.IsStarted()
+    if started {
+        h.sim.Pause()
+    }
+    err = h.sim.FastForward(target)
+    // A simulation stopped by a breakpoint before the target stays paused
+    if started && !h.sim.Options.CurrentTime.Time.Before(target) {
+        h.sim.Start()
+    }
+    if err != nil {
+        return "", err
+    }
+    return h.sim.FormatTime(h.sim.Options.CurrentTime.Time), nil
+}
+
+// step advances the paused simulation of h as asked by req and returns the
+// simulation time reached.
+func (h *Hub) step(req stepRequest) (string, error) {
+    h.restartMutex.Lock()
+    defer h.restartMutex.Unlock()
+    if h.sim.IsStarted() {
+        return "", fmt.Errorf("simulation must be paused to step")
+    }
+    if req.Seconds < 0 {
+        return "", fmt.Errorf("seconds must not be negative")
+    }
+    d := h.sim.Tick()
+    if req.Seconds > 0 {
+        d = time.Duration(req.Seconds) * time.Second
+    }
+    if err := h.sim.FastForward(h.sim.Options.CurrentTime.Time.Add(d)); err != nil {
+        return "", err
+    }
+    return h.sim.FormatTime(h.sim.Options.CurrentTime.Time), nil
+}
+
+// POST /api/simulation/fastforward
+// Takes {"until": "08:30:00"} and runs the simulation without waiting for the
+// clock until this time, then resumes at normal speed if it was running.
+func serveFastForward(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    var req fastForwardRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        badRequest(w, err)
+        return
+    }
+    t, err := h.fastForward(req)
+    if err != nil {
+        invalidParameter(w, err.Error(), map[string]interface{}{"until": req.Until})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "currentTime": t})
+}
+
+// POST /api/simulation/step
+// Takes {"seconds": 30} and advances the paused simulation by this simulation
+// time, or by a single tick without body or with 0 seconds.
+func serveStep(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    var req stepRequest
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            badRequest(w, err)
+            return
+        }
+    }
+    t, err := h.step(req)
+    if err != nil {
+        invalidParameter(w, err.Error(), map[string]interface{}{"seconds": req.Seconds})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "currentTime": t})
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// An AffineTransform maps layout coordinates (x, y) to output coordinates:
+//
+//    x' = A*x + B*y + C
+//    y' = D*x + E*y + F
+type AffineTransform struct {
+    A, B, C, D, E, F float64
+}
+
+// IdentityTransform leaves layout coordinates unchanged
+var IdentityTransform = AffineTransform{A: 1, E: 1}
+
+// Apply returns the transformed coordinates of the given point
+func (t AffineTransform) Apply(x, y float64) (float64, float64) {
+    return t.A*x + t.B*y + t.C, t.D*x + t.E*y + t.F
+}
+
+// ParseAffineTransform parses a transform given as "a,b,c,d,e,f"
+func ParseAffineTransform(s string) (AffineTransform, error) {
+    parts := strings.Split(s, ",")
+    if len(parts) != 6 {
+        return AffineTransform{}, fmt.Errorf("affine transform must have 6 comma separated values, got %d", len(parts))
+    }
+    var vals [6]float64
+    for i, p := range parts {
+        v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+        if err != nil {
+            return AffineTransform{}, fmt.Errorf("invalid affine transform value %q: %s", p, err)
+        }
+        vals[i] = v
+    }
+    return AffineTransform{vals[0], vals[1], vals[2], vals[3], vals[4], vals[5]}, nil
+}
+
+var (
+    layoutTransform      = IdentityTransform
+    layoutTransformMutex sync.RWMutex
+)
+
+// SetLayoutTransform sets the affine transform applied to layout coordinates in GeoJSON exports.
+func SetLayoutTransform(t AffineTransform) {
+    layoutTransformMutex.Lock()
+    defer layoutTransformMutex.Unlock()
+    layoutTransform = t
+}
+
+func currentLayoutTransform() AffineTransform {
+    layoutTransformMutex.RLock()
+    defer layoutTransformMutex.RUnlock()
+    return layoutTransform
+}
+
+type geoJSONGeometry struct {
+    Type        string      `json:"type"`
+    Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+    Type       string                 `json:"type"`
+    ID         string                 `json:"id"`
+    Geometry   geoJSONGeometry        `json:"geometry"`
+    Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+    Type     string           `json:"type"`
+    Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONProjection gives the GeoJSON coordinates of the layout of a
+// simulation. Track items and positions that cannot be placed are left out
+// of the export, as well as those outside area if it is set.
+type geoJSONProjection struct {
+    sim       *simulation.Simulation
+    transform AffineTransform
+    geo       bool
+    area      *layoutArea
+}
+
+// point returns the coordinates of the layout point p
+func (gp geoJSONProjection) point(p simulation.Point) ([]float64, bool) {
+    if gp.geo {
+        ll, ok := gp.sim.GeoPoint(p)
+        return []float64{ll.Lon, ll.Lat}, ok
+    }
+    x, y := gp.transform.Apply(p.X, p.Y)
+    return []float64{x, y}, true
+}
+
+// line returns the coordinates of the track item ti drawn through the given
+// layout points.
+func (gp geoJSONProjection) line(ti simulation.TrackItem, points ...simulation.Point) ([][]float64, bool) {
+    if gp.geo {
+        if path, ok := gp.sim.GeoPath(ti); ok {
+            res := make([][]float64, len(path))
+            for i, ll := range path {
+                res[i] = []float64{ll.Lon, ll.Lat}
+            }
+            return res, true
+        }
+    }
+    res := make([][]float64, len(points))
+    for i, p := range points {
+        c, ok := gp.point(p)
+        if !ok {
+            return nil, false
+        }
+        res[i] = c
+    }
+    return res, true
+}
+
+// position returns the coordinates of the position pos
+func (gp geoJSONProjection) position(pos simulation.Position) ([]float64, bool) {
+    if gp.geo {
+        ll, ok := gp.sim.GeoPosition(pos)
+        return []float64{ll.Lon, ll.Lat}, ok
+    }
+    return gp.point(pos.LayoutPoint())
+}
+
+// layoutGeoJSON converts the layout of the given simulation and its active
+// trains into a GeoJSON feature collection.
+func layoutGeoJSON(s *simulation.Simulation, gp geoJSONProjection) geoJSONFeatureCollection {
+    fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+    items := s.TrackItems
+    if gp.area != nil {
+        items = make(map[string]simulation.TrackItem)
+        for _, ti := range s.ItemsInRect(gp.area.min, gp.area.max) {
+            items[ti.ID()] = ti
+        }
+    }
+    for id, ti := range items {
+        props := map[string]interface{}{
+            "itemType": string(ti.Type()),
+            "name": ti.Name(),
+        }
+        var (
+            geom geoJSONGeometry
+            ok bool
+        )
+        switch v := ti.(type) {
+        case *simulation.LineItem, *simulation.InvisibleLinkItem, *simulation.LevelCrossingItem:
+            props["kind"] = "track"
+            props["trackCode"] = ti.TrackCode()
+            props["maxSpeed"] = ti.MaxSpeed()
+            props["realLength"] = ti.RealLength()
+            props["occupied"] = ti.ShowsOccupied()
+            var coords [][]float64
+            coords, ok = gp.line(ti, ti.Origin(), ti.End())
+            geom = geoJSONGeometry{"LineString", coords}
+        case *simulation.PointsItem:
+            props["kind"] = "points"
+            props["reversed"] = v.Reversed()
+            props["pairedTiId"] = v.PairedTiId
+            props["occupied"] = ti.ShowsOccupied()
+            if gp.geo {
+                if _, listed := gp.sim.Options.GeoReference.Items[id]; listed {
+                    // Only the given path of the points is known
+                    var coords [][]float64
+                    coords, ok = gp.line(ti)
+                    geom = geoJSONGeometry{"LineString", coords}
+                    break
+                }
+            }
+            var branches [4][]float64
+            ok = true
+            for i, p := range []simulation.Point{v.Origin(), v.Center(), v.End(), v.Reverse()} {
+                var placed bool
+                branches[i], placed = gp.point(p)
+                ok = ok && placed
+            }
+            geom = geoJSONGeometry{"MultiLineString", [][][]float64{
+                {branches[0], branches[1]},
+                {branches[1], branches[2]},
+                {branches[1], branches[3]},
+            }}
+        case *simulation.SignalItem:
+            props["kind"] = "signal"
+            props["signalType"] = v.SignalType().Name
+            props["activeAspect"] = v.ActiveAspect().Name
+            props["meansProceed"] = v.ActiveAspect().MeansProceed()
+            props["reversed"] = v.Reversed()
+            var coords [][]float64
+            coords, ok = gp.line(ti, v.Origin())
+            if ok {
+                geom = geoJSONGeometry{"Point", coords[0]}
+            }
+        default:
+            continue
+        }
+        if !ok {
+            continue
+        }
+        if ti.Place() != nil {
+            props["place"] = ti.Place().PlaceCode
+        }
+        if ti.ActiveRoute() != nil {
+            props["activeRoute"] = ti.ActiveRoute().ID()
+        }
+        fc.Features = append(fc.Features, geoJSONFeature{Type: "Feature", ID: id, Geometry: geom, Properties: props})
+    }
+    for code, pl := range s.Places {
+        if gp.area != nil && !gp.area.contains(pl.Origin()) {
+            continue
+        }
+        coords, ok := gp.line(pl, pl.Origin())
+        if !ok {
+            continue
+        }
+        fc.Features = append(fc.Features, geoJSONFeature{
+            Type: "Feature",
+            ID: pl.ID(),
+            Geometry: geoJSONGeometry{"Point", coords[0]},
+            Properties: map[string]interface{}{
+                "kind": "place",
+                "itemType": string(simulation.TypePlace),
+                "name": pl.Name(),
+                "placeCode": code,
+            },
+        })
+    }
+    for _, t := range s.Trains {
+        if !t.IsActive() || gp.area != nil && !gp.area.contains(t.TrainHead.LayoutPoint()) {
+            continue
+        }
+        coords, ok := gp.position(t.TrainHead)
+        if !ok {
+            continue
+        }
+        fc.Features = append(fc.Features, geoJSONFeature{
+            Type: "Feature",
+            ID: "train-" + t.ID(),
+            Geometry: geoJSONGeometry{"Point", coords},
+            Properties: map[string]interface{}{
+                "kind": "train",
+                "trainId": t.ID(),
+                "serviceCode": t.ServiceCode,
+                "status": trainStatusToString(t.Status),
+                "speedKmh": t.Speed * 3.6,
+            },
+        })
+    }
+    return fc
+}
+
+// GET /api/systems/layout.geojson?transform=a,b,c,d,e,f&bbox=minX,minY,maxX,maxY
+//
+// Coordinates are the longitude and latitude of the layout when the
+// simulation has a geoReference and no transform is given. bbox limits the
+// export to the area of the layout coordinates, whatever the output
+// coordinates.
+func serveLayoutGeoJSON(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    gp := geoJSONProjection{sim: h.sim, transform: currentLayoutTransform(), geo: h.sim.IsGeoReferenced()}
+    if tp := r.URL.Query().Get("transform"); tp != "" {
+        var err error
+        if gp.transform, err = ParseAffineTransform(tp); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        gp.geo = false
+    }
+    if bp := r.URL.Query().Get("bbox"); bp != "" {
+        var err error
+        if gp.area, err = parseLayoutArea(bp); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+    }
+    w.Header().Set("Content-Type", "application/geo+json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(layoutGeoJSON(h.sim, gp))
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strings"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// maxGTFSFeedSize is the maximum size in bytes of an uploaded GTFS feed
+const maxGTFSFeedSize = 64 << 20
+
+// gtfsImportOptions returns the import options given in the query of a GTFS
+// import request: plannedTrainType, routeIds as a comma separated list and
+// stops as a comma separated list of stopId:placeCode pairs.
+func gtfsImportOptions(r *http.Request) (simulation.GTFSImportOptions, error) {
+    q := r.URL.Query()
+    opts := simulation.GTFSImportOptions{
+        PlannedTrainType: q.Get("plannedTrainType"),
+        StopPlaces:       make(map[string]string),
+    }
+    if ids := q.Get("routeIds"); ids != "" {
+        opts.RouteIDs = strings.Split(ids, ",")
+    }
+    if stops := q.Get("stops"); stops != "" {
+        for _, pair := range strings.Split(stops, ",") {
+            parts := strings.SplitN(pair, ":", 2)
+            if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+                return opts, fmt.Errorf("invalid stop mapping %q, expected stopId:placeCode", pair)
+            }
+            opts.StopPlaces[parts[0]] = parts[1]
+        }
+    }
+    return opts, nil
+}
+
+// POST /api/services/import/gtfs
+//
+// Takes a GTFS feed as a zip archive in the body and adds the services of its
+// trips to the simulation.
+func serveGTFSImport(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    opts, err := gtfsImportOptions(r)
+    if err != nil {
+        invalidParameter(w, err.Error(), map[string]interface{}{"stops": r.URL.Query().Get("stops")})
+        return
+    }
+    data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGTFSFeedSize))
+    if err != nil {
+        badRequest(w, err)
+        return
+    }
+    feed, err := simulation.ReadGTFS(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        invalidParameter(w, err.Error(), nil)
+        return
+    }
+    res, err := h.sim.ImportGTFS(feed, opts)
+    if err != nil {
+        invalidParameter(w, err.Error(), nil)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(http.StatusCreated)
+    _ = json.NewEncoder(w).Encode(res)
+}
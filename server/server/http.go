@@ -42,12 +42,10 @@ const (
 )
 
 var (
+	// sim and hub are the default simulation and its hub
 	sim    *simulation.Simulation
 	hub    *Hub
 	logger log.Logger
-	// initialSimSnapshot holds the initial JSON snapshot of the simulation
-	// so we can restart from the original state via HTTP API.
-	initialSimSnapshot []byte
 )
 
 // InitializeLogger creates the logger for the server module
@@ -56,17 +54,30 @@ func InitializeLogger(parentLogger log.Logger) {
 }
 
 // Run starts a http web server and websocket hub for the given simulation, on the given address and port.
+//
+// The given simulation is the default simulation of the server. Other
+// simulations can be hosted with AddSimulation.
 func Run(s *simulation.Simulation, addr, port string) {
 	logger.Info("Starting server")
-	sim = s
+	hub.setSimulation(s)
+	applyConfigOptions(s)
 	// Capture initial snapshot before any initialization/mutations
 	// so we can restore the simulation to its initial state later.
-	if b, err := json.Marshal(sim); err == nil {
-		initialSimSnapshot = b
+	if b, err := takeSimulationSnapshot(s); err == nil {
+		hub.initialSnapshot = b
 	} else {
 		logger.Error("Unable to marshal initial simulation snapshot", "error", err)
 	}
+	simulation.RegisterTracer(diagnosticsTracer{})
 	startMetricsTicker()
+	startWebhookDispatcher()
+	startMQTTPublisher()
+	startKafkaProducer()
+	startTelemetry()
+	startSuggestionWebhook()
+	startBackupUploader()
+	startTimetableFeedPoller()
+	startRemoteWriter()
 	hubUp := make(chan bool)
 	timer := time.After(MaxHubStartupTime)
 	go hub.run(hubUp)
@@ -108,10 +119,16 @@ func HttpdStart(addr, port string) {
 
 	http.HandleFunc("/", serveHome)
 	http.HandleFunc("/ws", serveWs)
-	http.HandleFunc("/api/suggestions", serveSuggestions)
 	installHTTPAPI()
 
 	serverAddress := fmt.Sprintf("%s:%s", addr, port)
+	if tc := tlsConfig(); tc != nil {
+		logger.Info("Starting HTTPS", "submodule", "http", "address", serverAddress)
+		srv := &http.Server{Addr: serverAddress, TLSConfig: tc}
+		err = srv.ListenAndServeTLS("", "")
+		logger.Crit("HTTPS crashed", "submodule", "http", "error", err)
+		return
+	}
 	logger.Info("Starting HTTP", "submodule", "http", "address", serverAddress)
 	err = http.ListenAndServe(serverAddress, nil)
 	logger.Crit("HTTP crashed", "submodule", "http", "error", err)
@@ -129,6 +146,10 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wsScheme := "ws"
+	if r.TLS != nil {
+		wsScheme = "wss"
+	}
 	data := struct {
 		Title       string
 		Description string
@@ -136,7 +157,7 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	}{
 		sim.Options.Title,
 		sim.Options.Description,
-		"ws://" + r.Host + "/ws",
+		wsScheme + "://" + r.Host + "/ws",
 	}
 	homeTempl.Execute(w, data)
 }
@@ -145,27 +166,28 @@ var homeTempl *template.Template
 
 // serveSuggestions returns the current suggestions as JSON
 func serveSuggestions(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
     logger.Debug("New HTTP suggestions request", "submodule", "http", "remote", r.RemoteAddr)
     if r.Method != "GET" {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        methodNotAllowed(w, r)
         return
     }
-    if sim == nil {
-        http.Error(w, "Simulation not initialized", http.StatusServiceUnavailable)
+    if h.sim == nil {
+        simulationNotInitialized(w)
         return
     }
     // force recompute if requested
     if r.URL.Query().Get("recompute") == "1" {
-        simulation.RecomputeSuggestions()
+        h.sim.RecomputeSuggestions()
     }
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
-    if sim.Suggestions == nil {
+    if h.sim.Suggestions == nil {
         _, _ = w.Write([]byte("{\"items\":[],\"generatedAt\":\"00:00:00\"}"))
         return
     }
-    data, err := json.Marshal(sim.Suggestions)
+    data, err := json.Marshal(h.sim.Suggestions)
     if err != nil {
-        http.Error(w, "Internal error", http.StatusInternalServerError)
+        internalError(w, "Unable to encode suggestions", err)
         return
     }
     _, _ = w.Write(data)
@@ -22,6 +22,14 @@ func trainStatusToString(s simulation.TrainStatus) string {
         return "OUT"
     case simulation.EndOfService:
         return "END_OF_SERVICE"
+    case simulation.Joined:
+        return "JOINED"
+    case simulation.Cancelled:
+        return "CANCELLED"
+    case simulation.Stabled:
+        return "STABLED"
+    case simulation.NotRunning:
+        return "NOT_RUNNING"
     case simulation.Inactive:
         fallthrough
     default:
@@ -29,120 +37,62 @@ func trainStatusToString(s simulation.TrainStatus) string {
     }
 }
 
-func positionXY(p simulation.Position) (float64, float64) {
-    ti := p.TrackItem()
-    switch v := ti.(type) {
-    case *simulation.LineItem:
-        // Interpolate between origin and end according to PositionOnTI
-        start := v.Origin()
-        end := v.End()
-        // PositionOnTI is measured from previous item towards the other end
-        // If coming from previous ID equals line.PreviousTiID, we use origin->end; otherwise end->origin
-        t := p.PositionOnTI / v.RealLength()
-        if p.PreviousItemID != v.PreviousTiID {
-            // reverse direction
-            start, end = end, start
-        }
-        x := start.X + (end.X-start.X)*t
-        y := start.Y + (end.Y-start.Y)*t
-        return x, y
-    default:
-        o := ti.Origin()
-        return o.X, o.Y
+// currentDate returns the calendar date of the current time of sim as
+// YYYY-MM-DD, or an empty string if sim has no start date.
+func currentDate(sim *simulation.Simulation) string {
+    d := sim.DateOf(sim.Options.CurrentTime.Time)
+    if d.IsZero() {
+        return ""
     }
+    return d.Format("2006-01-02")
 }
 
-// GET /api/trains/section/{sectionId}
-func serveTrainsBySection(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// positionXY returns the layout coordinates of p
+func positionXY(p simulation.Position) (float64, float64) {
+    pt := p.LayoutPoint()
+    return pt.X, pt.Y
+}
+
+// POST /api/trains/{trainId}/route
+// POST, DELETE /api/trains/{trainId}/delay
+// POST /api/trains/{trainId}/cancel
+// GET, POST /api/trains/{trainId}/eco
+// POST, DELETE /api/trains/{trainId}/stabling
+// POST /api/trains/{trainId}/withdraw
+func serveTrainRouteCommand(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/trains/"), "/")
+    if len(parts) == 2 && parts[1] == "delay" {
+        serveTrainDelay(w, r, parts[0])
         return
     }
-    sectionID := strings.TrimPrefix(r.URL.Path, "/api/trains/section/")
-    // Section is represented by Place or TrackItem grouping. We'll match by PlaceCode or TrackItem name prefix.
-    type trainOut struct {
-        ID          string                 `json:"id"`
-        ServiceCode string                 `json:"serviceCode"`
-        Status      string                 `json:"status"`
-        Speed       float64                `json:"speed"`
-        MaxSpeed    float64                `json:"maxSpeed"`
-        Position    map[string]float64     `json:"position"`
-        Route       []string               `json:"route"`
-        Delay       int                    `json:"delay"`
-        Specs       map[string]interface{} `json:"specs"`
+    if len(parts) == 2 && parts[1] == "cancel" {
+        serveTrainCancel(w, r, parts[0])
+        return
     }
-    resp := map[string]interface{}{
-        "sectionId": sectionID,
-        "currentTrains": []trainOut{},
-        "incomingTrains": []trainOut{},
+    if len(parts) == 2 && parts[1] == "eco" {
+        serveTrainEco(w, r, parts[0])
+        return
     }
-    // Simplified: consider trains whose head TrackItem belongs to the Place or TrackItem name contains sectionId
-    for _, t := range sim.Trains {
-        if !t.IsActive() {
-            continue
-        }
-        ti := t.TrainHead.TrackItem()
-        inSection := false
-        if ti.Place() != nil && (ti.Place().PlaceCode == sectionID || ti.Place().Name() == sectionID) {
-            inSection = true
-        }
-        if !inSection && (strings.Contains(ti.Name(), sectionID) || ti.ID() == sectionID) {
-            inSection = true
-        }
-        if !inSection {
-            continue
-        }
-        line := t.Service()
-        delayMin := 0
-        if line != nil && t.NextPlaceIndex != simulation.NoMorePlace {
-            sl := line.Lines[t.NextPlaceIndex]
-            if !sl.ScheduledDepartureTime.IsZero() {
-                d := sim.Options.CurrentTime.Sub(sl.ScheduledDepartureTime)
-                if d > 0 { delayMin = int(d / (60 * 1000000000)) }
-            }
-        }
-        routeNames := []string{}
-        if line != nil {
-            for _, sl := range line.Lines {
-                if sl.Place() != nil {
-                    routeNames = append(routeNames, sl.Place().Name())
-                } else {
-                    routeNames = append(routeNames, sl.PlaceCode)
-                }
-            }
-        }
-        x, y := positionXY(t.TrainHead)
-        out := trainOut{
-            ID:          t.ID(),
-            ServiceCode: t.ServiceCode,
-            Status:      trainStatusToString(t.Status),
-            Speed:       t.Speed * 3.6, // km/h for FE
-            MaxSpeed:    t.MaxSpeedForTrainTrackItems(),
-            Position:    map[string]float64{"x": x, "y": y},
-            Route:       routeNames,
-            Delay:       delayMin,
-            Specs:       map[string]interface{}{"type": t.TrainType().Description, "length": t.TrainType().Length},
-        }
-        resp["currentTrains"] = append(resp["currentTrains"].([]trainOut), out)
+    if len(parts) == 2 && parts[1] == "stabling" {
+        serveTrainStabling(w, r, parts[0])
+        return
+    }
+    if len(parts) == 2 && parts[1] == "withdraw" {
+        serveTrainWithdraw(w, r, parts[0])
+        return
     }
-    w.Header().Set("Content-Type", "application/json; charset=utf-8")
-    _ = json.NewEncoder(w).Encode(resp)
-}
-
-// POST /api/trains/{trainId}/route
-func serveTrainRouteCommand(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        methodNotAllowed(w, r)
         return
     }
-    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/trains/"), "/")
     if len(parts) < 2 || parts[1] != "route" {
-        http.NotFound(w, r)
+        serveAPINotFound(w, r)
         return
     }
     tid, _ := strconv.Atoi(parts[0])
-    if tid < 0 || tid >= len(sim.Trains) {
-        http.Error(w, "TRAIN_NOT_FOUND", http.StatusNotFound)
+    if tid < 0 || tid >= len(h.sim.Trains) {
+        writeAPIError(w, http.StatusNotFound, ErrCodeTrainNotFound, "Train not found", map[string]interface{}{"trainId": parts[0]})
         return
     }
     var body struct {
@@ -151,21 +101,45 @@ func serveTrainRouteCommand(w http.ResponseWriter, r *http.Request) {
         Reason   string   `json:"reason"`
     }
     if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-        http.Error(w, "Bad request", http.StatusBadRequest)
+        badRequest(w, err)
         return
     }
-    t := sim.Trains[tid]
+    t := h.sim.Trains[tid]
     switch strings.ToUpper(body.Action) {
     case "ACCEPT":
         // no-op here; client should use WS to activate a specific route. Return OK.
     case "REROUTE":
-        // Not supported in core model (no free pathfinding). Return 501.
-        http.Error(w, "Not Implemented", http.StatusNotImplemented)
+        // newRoute lists the signals or places (PLACE or PLACE/TRACK) to go through
+        if len(body.NewRoute) == 0 {
+            invalidParameter(w, "Missing newRoute", map[string]interface{}{"action": body.Action})
+            return
+        }
+        path, err := t.Reroute(body.NewRoute...)
+        if err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"trainId": parts[0], "newRoute": body.NewRoute})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "routes": path.RouteIDs(), "lengthM": path.Length})
+        return
+    case "SHUNT":
+        // newRoute lists the signals or places (PLACE or PLACE/TRACK) to shunt through
+        if len(body.NewRoute) == 0 {
+            invalidParameter(w, "Missing newRoute", map[string]interface{}{"action": body.Action})
+            return
+        }
+        path, err := t.Shunt(body.NewRoute...)
+        if err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"trainId": parts[0], "newRoute": body.NewRoute})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "routes": path.RouteIDs(), "lengthM": path.Length})
         return
     case "HALT":
         _ = t.ProceedWithCaution() // best-effort to limit to warning speed
     default:
-        http.Error(w, "Unknown action", http.StatusBadRequest)
+        invalidParameter(w, "Unknown action", map[string]interface{}{"action": body.Action})
         return
     }
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -174,15 +148,16 @@ func serveTrainRouteCommand(w http.ResponseWriter, r *http.Request) {
 
 // GET /api/systems/signals
 func serveSignals(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
     if r.Method != http.MethodGet {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        methodNotAllowed(w, r)
         return
     }
     type out struct {
         Signals []map[string]interface{} `json:"signals"`
     }
     resp := out{Signals: []map[string]interface{}{}}
-    for id, ti := range sim.TrackItems {
+    for id, ti := range h.sim.TrackItems {
         s, ok := ti.(*simulation.SignalItem)
         if !ok {
             continue
@@ -201,7 +176,8 @@ func serveSignals(w http.ResponseWriter, r *http.Request) {
             "type": s.SignalType().Name,
             "section": s.PlaceCode,
             "lastChanged": s.LastChangedRFC3339(),
-            "malfunctionStatus": "OPERATIONAL",
+            "malfunctionStatus": func() string { if s.Failed() { return "FAILED" }; return "OPERATIONAL" }(),
+            "failureMode": string(s.FailureMode()),
         })
     }
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -210,25 +186,31 @@ func serveSignals(w http.ResponseWriter, r *http.Request) {
 
 // PUT /api/systems/signals/{signalId}/status
 func serveSignalOverride(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if strings.HasSuffix(r.URL.Path, "/failure") {
+        sid := strings.TrimPrefix(r.URL.Path, "/api/systems/signals/")
+        serveSignalFailure(w, r, strings.TrimSuffix(sid, "/failure"))
+        return
+    }
     if r.Method != http.MethodPut {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        methodNotAllowed(w, r)
         return
     }
     sid := strings.TrimPrefix(r.URL.Path, "/api/systems/signals/")
     sid = strings.TrimSuffix(sid, "/status")
-    sraw, ok := sim.TrackItems[sid]
+    sraw, ok := h.sim.TrackItems[sid]
     if !ok {
-        http.Error(w, "SIGNAL_NOT_FOUND", http.StatusNotFound)
+        writeAPIError(w, http.StatusNotFound, ErrCodeSignalNotFound, "Signal not found", map[string]interface{}{"signalId": sid})
         return
     }
     s, ok := sraw.(*simulation.SignalItem)
     if !ok {
-        http.Error(w, "SIGNAL_NOT_FOUND", http.StatusNotFound)
+        writeAPIError(w, http.StatusNotFound, ErrCodeSignalNotFound, "Signal not found", map[string]interface{}{"signalId": sid})
         return
     }
     var body struct{ NewStatus string `json:"newStatus"`; Reason string `json:"reason"`; UserID string `json:"userId"` }
     if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-        http.Error(w, "Bad request", http.StatusBadRequest)
+        badRequest(w, err)
         return
     }
     // Map to an aspect name in library by color. Fallback to default.
@@ -236,11 +218,11 @@ func serveSignalOverride(w http.ResponseWriter, r *http.Request) {
     var asp *simulation.SignalAspect
     switch target {
     case "GREEN":
-        asp = sim.SignalLib.Aspects["GREEN"]
+        asp = h.sim.SignalLib.Aspects["GREEN"]
     case "YELLOW":
-        asp = sim.SignalLib.Aspects["YELLOW"]
+        asp = h.sim.SignalLib.Aspects["YELLOW"]
     case "RED":
-        asp = sim.SignalLib.Aspects["RED"]
+        asp = h.sim.SignalLib.Aspects["RED"]
     default:
         asp = s.SignalType().GetAspect(s)
     }
@@ -250,126 +232,37 @@ func serveSignalOverride(w http.ResponseWriter, r *http.Request) {
 }
 
 // GET /api/systems/overview
+//
+// The signals, tracks, routes and trains are served from overviewSnapshots,
+// in which only the objects that changed since the previous request are
+// rebuilt.
 func serveSystemOverview(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
     if r.Method != http.MethodGet {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        methodNotAllowed(w, r)
         return
     }
-    if sim == nil {
-        http.Error(w, "Simulation not initialized", http.StatusServiceUnavailable)
+    if h.sim == nil {
+        simulationNotInitialized(w)
         return
     }
 
-    totalsByType := map[string]int{}
-    segmentsTotal := 0
-    segmentsOccupied := 0
-
-    signals := []map[string]interface{}{}
-    tracks := []map[string]interface{}{}
-
-    for id, ti := range sim.TrackItems {
-        ttype := string(ti.Type())
-        totalsByType[ttype]++
-
-        switch ti.Type() {
-        case simulation.TypeLine, simulation.TypeInvisibleLink, simulation.TypeSignal, simulation.TypePoints:
-            segmentsTotal++
-            if ti.TrainPresent() { segmentsOccupied++ }
-        }
-
-        base := map[string]interface{}{
-            "id": id,
-            "type": ttype,
-            "name": ti.Name(),
-            "place": func() string { if ti.Place() != nil { return ti.Place().PlaceCode }; return "" }(),
-            "trackCode": ti.TrackCode(),
-            "origin": map[string]float64{"x": ti.Origin().X, "y": ti.Origin().Y},
-            "end": map[string]float64{"x": ti.End().X, "y": ti.End().Y},
-            "previous": func() string { if ti.PreviousItem() != nil { return ti.PreviousItem().ID() }; return "" }(),
-            "next": func() string { if ti.NextItem() != nil { return ti.NextItem().ID() }; return "" }(),
-            "conflictWith": func() string { if ti.ConflictItem() != nil { return ti.ConflictItem().ID() }; return "" }(),
-            "occupied": ti.TrainPresent(),
-            "activeRoute": func() string { if ti.ActiveRoute() != nil { return ti.ActiveRoute().ID() }; return "" }(),
-        }
-
-        switch v := ti.(type) {
-        case *simulation.SignalItem:
-            status := "RED"
-            if v.ActiveAspect().MeansProceed() { status = "GREEN" }
-            var parID, narID string
-            if v.PreviousItem() != nil && v.PreviousItem().ActiveRoute() != nil {
-                parID = v.PreviousItem().ActiveRoute().ID()
-            }
-            if v.NextItem() != nil && v.NextItem().ActiveRoute() != nil {
-                narID = v.NextItem().ActiveRoute().ID()
-            }
-            signals = append(signals, map[string]interface{}{
-                "id": id,
-                "name": v.Name(),
-                "position": map[string]float64{"x": v.Origin().X, "y": v.Origin().Y},
-                "status": status,
-                "activeAspect": v.ActiveAspect().Name,
-                "type": v.SignalType().Name,
-                "section": v.PlaceCode,
-                "lastChanged": v.LastChangedRFC3339(),
-                "activeRoute": base["activeRoute"],
-                "previousActiveRoute": parID,
-                "nextActiveRoute": narID,
-            })
-        case *simulation.PointsItem:
-            pm := map[string]interface{}{}
-            for k, val := range base { pm[k] = val }
-            pm["reversed"] = v.Reversed()
-            pm["reverseTiId"] = v.ReverseTiId
-            pm["pairedTiId"] = v.PairedTiId
-            pm["center"] = map[string]float64{"x": v.Center().X, "y": v.Center().Y}
-            pm["reverse"] = map[string]float64{"x": v.Reverse().X, "y": v.Reverse().Y}
-            tracks = append(tracks, pm)
-        case *simulation.LineItem, *simulation.InvisibleLinkItem:
-            tracks = append(tracks, base)
-        default:
-            // skip others from tracks list
-        }
-    }
-
-    routes := []map[string]interface{}{}
-    for id, r := range sim.Routes {
-        state := r.State()
-        stateStr := "DEACTIVATED"
-        switch state {
-        case simulation.Activated:
-            stateStr = "ACTIVATED"
-        case simulation.Persistent:
-            stateStr = "PERSISTENT"
-        case simulation.Destroying:
-            stateStr = "DESTROYING"
-        }
-        routes = append(routes, map[string]interface{}{
-            "id": id,
-            "beginSignal": r.BeginSignalId,
-            "endSignal": r.EndSignalId,
-            "state": stateStr,
-            "isActive": r.IsActive(),
-        })
-    }
+    oc := h.snapshots
+    oc.mu.Lock()
+    oc.refresh(h.sim)
+    signals := appendEntries([]json.RawMessage{}, oc.signalIDs, oc.trackItem)
+    tracks := appendEntries([]json.RawMessage{}, oc.trackIDs, oc.trackItem)
+    routes := appendEntries([]json.RawMessage{}, oc.routeIDs, oc.route)
+    trains := oc.allTrainEntries()
+    totalsByType := oc.totalsByType
+    segmentsTotal := oc.segmentsTotal
+    oc.mu.Unlock()
 
-    trains := []map[string]interface{}{}
     activeCount := 0
-    for _, t := range sim.Trains {
-        x, y := positionXY(t.TrainHead)
-        isActive := t.IsActive()
-        if isActive { activeCount++ }
-        trains = append(trains, map[string]interface{}{
-            "id": t.ID(),
-            "serviceCode": t.ServiceCode,
-            "status": trainStatusToString(t.Status),
-            "active": isActive,
-            "speedKmh": t.Speed * 3.6,
-            "maxSpeed": t.MaxSpeedForTrainTrackItems(),
-            "position": map[string]float64{"x": x, "y": y},
-        })
+    for _, t := range h.sim.Trains {
+        if t.IsActive() { activeCount++ }
     }
-
+    segmentsOccupied, _ := h.sim.OccupiedSegments()
     util := 0.0
     if segmentsTotal > 0 {
         util = float64(segmentsOccupied) * 100.0 / float64(segmentsTotal)
@@ -378,19 +271,21 @@ func serveSystemOverview(w http.ResponseWriter, r *http.Request) {
     resp := map[string]interface{}{
         "timestamp": time.Now().UTC().Format(time.RFC3339),
         "system": map[string]interface{}{
-            "title": sim.Options.Title,
-            "description": sim.Options.Description,
-            "version": sim.Options.Version,
-            "currentTime": sim.Options.CurrentTime.Time.Format("15:04:05"),
-            "timeFactor": sim.Options.TimeFactor,
-            "running": sim.IsStarted(),
+            "title": h.sim.Options.Title,
+            "description": h.sim.Options.Description,
+            "version": h.sim.Options.Version,
+            "currentTime": h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
+            "currentDate": currentDate(h.sim),
+            "timeFactor": h.sim.Options.TimeFactor,
+            "running": h.sim.IsStarted(),
         },
         "totals": map[string]interface{}{
             "trackItems": totalsByType,
-            "routes": len(sim.Routes),
+            "routes": len(h.sim.Routes),
             "signals": len(signals),
             "points": totalsByType[string(simulation.TypePoints)],
-            "trains": map[string]int{"total": len(sim.Trains), "active": activeCount},
+            "levelCrossings": totalsByType[string(simulation.TypeLevelCrossing)],
+            "trains": map[string]int{"total": len(h.sim.Trains), "active": activeCount},
         },
         "occupancy": map[string]interface{}{
             "segmentsTotal": segmentsTotal,
@@ -401,26 +296,239 @@ func serveSystemOverview(w http.ResponseWriter, r *http.Request) {
         "tracks": tracks,
         "routes": routes,
         "trains": trains,
+        "speedRestrictions": h.sim.SpeedRestrictions(),
+        "possessions": h.sim.Possessions(),
     }
 
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
     _ = json.NewEncoder(w).Encode(resp)
 }
 
+// overviewTrackBase returns the fields shared by all track items of s in the
+// overview
+func overviewTrackBase(s *simulation.Simulation, id string, ti simulation.TrackItem) map[string]interface{} {
+    path, _ := s.GeoPath(ti)
+    return map[string]interface{}{
+        "id": id,
+        "type": string(ti.Type()),
+        "name": ti.Name(),
+        "place": func() string { if ti.Place() != nil { return ti.Place().PlaceCode }; return "" }(),
+        "trackCode": ti.TrackCode(),
+        "origin": overviewPoint(ti.Origin(), path, 0),
+        "end": overviewPoint(ti.End(), path, len(path)-1),
+        "previous": func() string { if ti.PreviousItem() != nil { return ti.PreviousItem().ID() }; return "" }(),
+        "next": func() string { if ti.NextItem() != nil { return ti.NextItem().ID() }; return "" }(),
+        "conflictWith": func() string { if ti.ConflictItem() != nil { return ti.ConflictItem().ID() }; return "" }(),
+        "occupied": ti.ShowsOccupied(),
+        "trackCircuitFailure": string(ti.DetectionFailure()),
+        "activeRoute": func() string { if ti.ActiveRoute() != nil { return ti.ActiveRoute().ID() }; return "" }(),
+        "blocked": ti.Blocked(),
+        "speedRestriction": ti.SpeedRestriction(),
+    }
+}
+
+// overviewTrack returns the overview representation of a line, invisible link,
+// level crossing or points item of s
+func overviewTrack(s *simulation.Simulation, id string, ti simulation.TrackItem) map[string]interface{} {
+    base := overviewTrackBase(s, id, ti)
+    if v, ok := ti.(*simulation.PointsItem); ok {
+        base["reversed"] = v.Reversed()
+        base["reverseTiId"] = v.ReverseTiId
+        base["pairedTiId"] = v.PairedTiId
+        base["center"] = map[string]float64{"x": v.Center().X, "y": v.Center().Y}
+        base["reverse"] = map[string]float64{"x": v.Reverse().X, "y": v.Reverse().Y}
+        base["locked"] = v.Locked()
+        base["failureMode"] = string(v.FailureMode())
+    }
+    if v, ok := ti.(*simulation.LevelCrossingItem); ok {
+        base["levelCrossingState"] = string(v.State())
+        base["manual"] = v.IsManual()
+        base["failureMode"] = string(v.FailureMode())
+        base["levelCrossingMetrics"] = v.Metrics()
+    }
+    return base
+}
+
+// overviewSignal returns the overview representation of a signal of s
+func overviewSignal(s *simulation.Simulation, id string, v *simulation.SignalItem) map[string]interface{} {
+    status := "RED"
+    if v.ActiveAspect().MeansProceed() { status = "GREEN" }
+    var arID, parID, narID string
+    if v.ActiveRoute() != nil {
+        arID = v.ActiveRoute().ID()
+    }
+    if v.PreviousItem() != nil && v.PreviousItem().ActiveRoute() != nil {
+        parID = v.PreviousItem().ActiveRoute().ID()
+    }
+    if v.NextItem() != nil && v.NextItem().ActiveRoute() != nil {
+        narID = v.NextItem().ActiveRoute().ID()
+    }
+    path, _ := s.GeoPath(v)
+    return map[string]interface{}{
+        "id": id,
+        "name": v.Name(),
+        "position": overviewPoint(v.Origin(), path, 0),
+        "status": status,
+        "activeAspect": v.ActiveAspect().Name,
+        "type": v.SignalType().Name,
+        "section": v.PlaceCode,
+        "lastChanged": v.LastChangedRFC3339(),
+        "activeRoute": arID,
+        "previousActiveRoute": parID,
+        "nextActiveRoute": narID,
+        "failed": v.Failed(),
+        "failureMode": string(v.FailureMode()),
+    }
+}
+
+// overviewRoute returns the overview representation of a route
+func overviewRoute(id string, r *simulation.Route) map[string]interface{} {
+    stateStr := "DEACTIVATED"
+    switch r.State() {
+    case simulation.Activated:
+        stateStr = "ACTIVATED"
+    case simulation.Persistent:
+        stateStr = "PERSISTENT"
+    case simulation.Destroying:
+        stateStr = "DESTROYING"
+    }
+    return map[string]interface{}{
+        "id": id,
+        "beginSignal": r.BeginSignalId,
+        "endSignal": r.EndSignalId,
+        "state": stateStr,
+        "isActive": r.IsActive(),
+    }
+}
+
+// overviewTrain returns the overview representation of a train of s
+func overviewTrain(s *simulation.Simulation, t *simulation.Train) map[string]interface{} {
+    position := map[string]float64{}
+    position["x"], position["y"] = positionXY(t.TrainHead)
+    if ll, ok := s.GeoPosition(t.TrainHead); ok {
+        position["lat"], position["lon"] = ll.Lat, ll.Lon
+    }
+    return map[string]interface{}{
+        "id": t.ID(),
+        "serviceCode": t.ServiceCode,
+        "status": trainStatusToString(t.Status),
+        "priority": string(t.Priority()),
+        "active": t.IsActive(),
+        "speedKmh": t.Speed * 3.6,
+        "maxSpeed": t.MaxSpeedForTrainTrackItems(),
+        "position": position,
+    }
+}
+
+// overviewPoint returns the overview representation of the layout point p.
+// The coordinates of path at index i are added as its latitude and longitude
+// if the track item has a geographic path.
+func overviewPoint(p simulation.Point, path []simulation.LatLon, i int) map[string]float64 {
+    res := map[string]float64{"x": p.X, "y": p.Y}
+    if i >= 0 && i < len(path) {
+        res["lat"], res["lon"] = path[i].Lat, path[i].Lon
+    }
+    return res
+}
+
+// installHTTPAPI registers the REST API handlers.
+//
+// The API is served under /api/v1. The unversioned /api routes are still
+// served for existing clients but are deprecated.
 func installHTTPAPI() {
-    http.HandleFunc("/api/trains/section/", serveTrainsBySection)
-    http.HandleFunc("/api/trains/", serveTrainRouteCommand)
-    http.HandleFunc("/api/systems/signals", serveSignals)
-    http.HandleFunc("/api/systems/signals/", serveSignalOverride)
-    http.HandleFunc("/api/systems/overview", serveSystemOverview)
-    http.HandleFunc("/api/analytics/kpis", serveKPI)
-    http.HandleFunc("/api/analytics/historical", serveKPIHistorical)
-    http.HandleFunc("/api/simulation/whatif", serveWhatIf)
-    http.HandleFunc("/api/simulation/restart", serveSimulationRestart)
-    http.HandleFunc("/api/ai/hints", serveAIHints)
-    http.HandleFunc("/api/ai/hints/", serveAIHintRespond)
-    http.HandleFunc("/api/audit/logs", serveAuditLogs)
-    http.HandleFunc("/api/audit/stream", serveAuditStream)
+    apiMux := http.NewServeMux()
+    apiMux.HandleFunc("/api/", serveAPINotFound)
+    apiMux.HandleFunc("/api/suggestions", serveSuggestions)
+    apiMux.HandleFunc("/api/trains", serveTrains)
+    apiMux.HandleFunc("/api/trains/section/", serveTrainsBySection)
+    apiMux.HandleFunc("/api/trains/", serveTrainRouteCommand)
+    apiMux.HandleFunc("/api/services", serveServices)
+    apiMux.HandleFunc("/api/services/", serveService)
+    apiMux.HandleFunc("/api/services/import/gtfs", serveGTFSImport)
+    apiMux.HandleFunc("/api/timetable/export", serveTimetableExport)
+    apiMux.HandleFunc("/api/timetable/updates", serveTimetableUpdates)
+    apiMux.HandleFunc("/api/transfers", serveTransfers)
+    apiMux.HandleFunc("/api/depots", serveDepots)
+    apiMux.HandleFunc("/api/depots/", serveDepot)
+    apiMux.HandleFunc("/api/routes/", serveRouteConflicts)
+    apiMux.HandleFunc("/api/places/", servePlacePlatforms)
+    apiMux.HandleFunc("/api/platforms/conflicts", servePlatformConflicts)
+    apiMux.HandleFunc("/api/sections", serveSections)
+    apiMux.HandleFunc("/api/sections/", serveSection)
+    apiMux.HandleFunc("/api/systems/signals", serveSignals)
+    apiMux.HandleFunc("/api/systems/signals/", serveSignalOverride)
+    apiMux.HandleFunc("/api/systems/points", servePoints)
+    apiMux.HandleFunc("/api/systems/points/", servePointsFailure)
+    apiMux.HandleFunc("/api/systems/level-crossings", serveLevelCrossings)
+    apiMux.HandleFunc("/api/systems/level-crossings/", serveLevelCrossing)
+    apiMux.HandleFunc("/api/systems/overview", serveSystemOverview)
+    apiMux.HandleFunc("/api/systems/overview/delta", serveSystemOverviewDelta)
+    apiMux.HandleFunc("/api/systems/layout.geojson", serveLayoutGeoJSON)
+    apiMux.HandleFunc("/api/systems/layout/items", serveLayoutItems)
+    apiMux.HandleFunc("/api/systems/layout/nearest", serveLayoutNearest)
+    apiMux.HandleFunc("/api/systems/railml", serveRailML)
+    apiMux.HandleFunc("/api/systems/layout.svg", serveLayoutRender)
+    apiMux.HandleFunc("/api/systems/layout.png", serveLayoutRender)
+    apiMux.HandleFunc("/api/analytics/kpis", serveKPI)
+    apiMux.HandleFunc("/api/analytics/historical", serveKPIHistorical)
+    apiMux.HandleFunc("/api/simulation/whatif", serveWhatIf)
+    apiMux.HandleFunc("/api/simulation/restart", serveSimulationRestart)
+    apiMux.HandleFunc("/api/simulation/dump", serveSimulationDump)
+    apiMux.HandleFunc("/api/simulation/checkpoints", serveCheckpoints)
+    apiMux.HandleFunc("/api/simulation/checkpoints/", serveCheckpoint)
+    apiMux.HandleFunc("/api/simulation/rewind", serveRewind)
+    apiMux.HandleFunc("/api/simulation/fastforward", serveFastForward)
+    apiMux.HandleFunc("/api/simulation/step", serveStep)
+    apiMux.HandleFunc("/api/simulation/breakpoints", serveBreakpoints)
+    apiMux.HandleFunc("/api/simulation/breakpoints/", serveBreakpoint)
+    apiMux.HandleFunc("/api/simulation/options", serveSimulationOptions)
+    apiMux.HandleFunc("/api/simulation/schema", serveSimulationSchema)
+    apiMux.HandleFunc("/api/simulation/validate", serveSimulationValidate)
+    apiMux.HandleFunc("/api/simulation/perturbations", servePerturbations)
+    apiMux.HandleFunc("/api/simulations", serveSimulations)
+    apiMux.HandleFunc("/api/simulations/", serveSimulation)
+    apiMux.HandleFunc("/api/scenarios", serveScenarios)
+    apiMux.HandleFunc("/api/scenarios/", serveScenario)
+    apiMux.HandleFunc("/api/disruptions", serveDisruptions)
+    apiMux.HandleFunc("/api/disruptions/", serveDisruption)
+    apiMux.HandleFunc("/api/speed-restrictions", serveSpeedRestrictions)
+    apiMux.HandleFunc("/api/speed-restrictions/", serveSpeedRestriction)
+    apiMux.HandleFunc("/api/possessions", servePossessions)
+    apiMux.HandleFunc("/api/possessions/", servePossession)
+    apiMux.HandleFunc("/api/commands", serveCommands)
+    apiMux.HandleFunc("/api/webhooks", serveWebhooks)
+    apiMux.HandleFunc("/api/webhooks/", serveWebhook)
+    apiMux.HandleFunc("/api/mqtt", serveMQTT)
+    apiMux.HandleFunc("/api/kafka", serveKafka)
+    apiMux.HandleFunc("/api/telemetry", serveTelemetry)
+    apiMux.HandleFunc("/api/suggestions/webhook", serveSuggestionWebhook)
+    apiMux.HandleFunc("/api/backups", serveBackups)
+    apiMux.HandleFunc("/api/remote-write", serveRemoteWrite)
+    apiMux.HandleFunc("/api/debug/runtime", serveDebugRuntime)
+    apiMux.HandleFunc("/api/debug/pprof/", serveDebugPprof)
+    apiMux.HandleFunc("/api/ai/hints", serveAIHints)
+    apiMux.HandleFunc("/api/ai/hints/", serveAIHintRespond)
+    apiMux.HandleFunc("/api/connections", serveConnections)
+    apiMux.HandleFunc("/api/score", serveScore)
+    apiMux.HandleFunc("/api/score/sessions", serveScoreSessions)
+    apiMux.HandleFunc("/api/score/end", serveScoreEnd)
+    apiMux.HandleFunc("/api/training/scenarios", serveTrainingScenarios)
+    apiMux.HandleFunc("/api/training/scenarios/", serveTrainingScenario)
+    apiMux.HandleFunc("/api/training/run", serveTrainingRun)
+    apiMux.HandleFunc("/api/training/run/stop", serveTrainingStop)
+    apiMux.HandleFunc("/api/training/results", serveTrainingResults)
+    apiMux.HandleFunc("/api/control-areas", serveControlAreas)
+    apiMux.HandleFunc("/api/control-areas/", serveControlArea)
+    apiMux.HandleFunc("/api/chat", serveChat)
+    apiMux.HandleFunc("/api/messages", serveMessages)
+    apiMux.HandleFunc("/api/admin/log-levels", serveLogLevels)
+    apiMux.HandleFunc("/api/audit/logs", serveAuditLogs)
+    apiMux.HandleFunc("/api/audit/stream", serveAuditStream)
+    api := simulationScoped(traceHTTP(apiMux))
+    versioned := allowCORS(accessLog(versionedAPI(api)))
+    http.Handle(apiPrefix, versioned)
+    http.Handle(apiPrefix+"/", versioned)
+    http.Handle("/api/", allowCORS(accessLog(deprecatedAPI(api))))
 }
 
 
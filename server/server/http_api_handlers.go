@@ -5,14 +5,21 @@ import (
     "net/http"
     "strconv"
     "strings"
+    "sync/atomic"
     "time"
-    "github.com/ts2/ts2-sim-server/simulation"
 )
 
 // GET /api/analytics/kpis
 func serveKPI(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
-    rangeParam := r.URL.Query().Get("timeRange")
+    h := requestHub(r)
+    if r.Method != http.MethodGet { methodNotAllowed(w, r); return }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(h.metrics.kpiReport(r.URL.Query().Get("timeRange")))
+}
+
+// kpiReport returns the KPIs aggregated over the given time range (1h, 6h,
+// 1d, 1w or 1m) and their trends.
+func (m *metricsState) kpiReport(rangeParam string) map[string]interface{} {
     var dur time.Duration
     switch rangeParam {
     case "1h": dur = time.Hour
@@ -22,29 +29,20 @@ func serveKPI(w http.ResponseWriter, r *http.Request) {
     case "1m": dur = 30 * 24 * time.Hour
     default: dur = 24 * time.Hour
     }
-    agg, trend := aggregateKPIs(dur)
+    agg, trend := m.aggregateKPIs(dur)
     resp := map[string]interface{}{
         "timeRange": rangeParam,
         "timestamp": time.Now().UTC().Format(time.RFC3339),
-        "kpis": map[string]interface{}{
-            "rtp": agg.punctuality,
-            "punctuality": agg.punctuality,
-            "averageDelay": agg.averageDelay,
-            "p90Delay": agg.p90Delay,
-            "throughput": agg.throughput,
-            "utilization": agg.utilization,
-            "acceptanceRate": agg.acceptanceRate,
-            "openConflicts": agg.openConflicts,
-            "mttrConflict": agg.mttrConflict,
-            "headwayAdherence": agg.headwayAdherence,
-            "headwayBreaches": agg.headwayBreaches,
-            "efficiency": agg.efficiency,
-            "performance": agg.performance,
-        },
+        "simTime": agg.simTime,
+        "kpis": agg.kpis(),
+        "weather": agg.weather,
+        "signallingMode": agg.signallingMode,
         "trends": map[string]interface{}{
             "rtp": map[string]interface{}{"change": trend.punctuality, "direction": trendDirection(trend.punctuality)},
+            "weightedPunctuality": map[string]interface{}{"change": trend.weightedPunctuality, "direction": trendDirection(trend.weightedPunctuality)},
             "averageDelay": map[string]interface{}{"change": trend.averageDelay, "direction": trendDirection(-trend.averageDelay)},
             "p90Delay": map[string]interface{}{"change": trend.p90Delay, "direction": trendDirection(-trend.p90Delay)},
+            "passengerWeightedDelay": map[string]interface{}{"change": trend.passengerDelay, "direction": trendDirection(-trend.passengerDelay)},
             "throughput": map[string]interface{}{"change": trend.throughput, "direction": trendDirectionFloat(float64(trend.throughput))},
             "utilization": map[string]interface{}{"change": trend.utilization, "direction": trendDirection(trend.utilization)},
             "acceptanceRate": map[string]interface{}{"change": trend.acceptanceRate, "direction": trendDirection(trend.acceptanceRate)},
@@ -52,8 +50,34 @@ func serveKPI(w http.ResponseWriter, r *http.Request) {
             "headwayAdherence": map[string]interface{}{"change": trend.headwayAdherence, "direction": trendDirection(trend.headwayAdherence)},
         },
     }
-    w.Header().Set("Content-Type", "application/json; charset=utf-8")
-    _ = json.NewEncoder(w).Encode(resp)
+    return resp
+}
+
+// kpis returns the KPIs of the snapshot by name, as given to clients
+func (s kpiSnapshot) kpis() map[string]interface{} {
+    return map[string]interface{}{
+        "rtp": s.punctuality,
+        "punctuality": s.punctuality,
+        "weightedPunctuality": s.weightedPunctuality,
+        "averageDelay": s.averageDelay,
+        "p90Delay": s.p90Delay,
+        "passengerWeightedDelay": s.passengerDelay,
+        "throughput": s.throughput,
+        "utilization": s.utilization,
+        "acceptanceRate": s.acceptanceRate,
+        "openConflicts": s.openConflicts,
+        "mttrConflict": s.mttrConflict,
+        "headwayAdherence": s.headwayAdherence,
+        "headwayBreaches": s.headwayBreaches,
+        "cancellations": s.cancellations,
+        "missedConnections": s.missedConnections,
+        "overspeeds": s.overspeeds,
+        "spads": s.spads,
+        "efficiency": s.efficiency,
+        "performance": s.performance,
+        "degradedWeatherShare": s.degradedWeather,
+        "movingBlockShare": s.movingBlock,
+    }
 }
 
 func trendDirection(v float64) string { if v >= 0 { return "UP" }; return "DOWN" }
@@ -61,68 +85,57 @@ func trendDirectionFloat(v float64) string { if v >= 0 { return "UP" }; return "
 
 // GET /api/analytics/historical
 func serveKPIHistorical(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
-    metric := r.URL.Query().Get("metric")
-    period := r.URL.Query().Get("period")
+    h := requestHub(r)
+    if r.Method != http.MethodGet { methodNotAllowed(w, r); return }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(h.metrics.kpiHistory(r.URL.Query().Get("metric"), r.URL.Query().Get("period")))
+}
+
+// kpiHistory returns the series of the recorded snapshots of the given metric.
+func (m *metricsState) kpiHistory(metric, period string) map[string]interface{} {
     if period == "" { period = "hourly" }
     // naive: return last snapshots as series
-    metrics.mu.RLock()
-    snaps := append([]kpiSnapshot{}, metrics.snapshots...)
-    metrics.mu.RUnlock()
+    m.mu.RLock()
+    snaps := append([]kpiSnapshot{}, m.snapshots...)
+    m.mu.RUnlock()
     series := []map[string]interface{}{}
     for _, s := range snaps {
         v := 0.0
         switch metric {
         case "punctuality", "rtp": v = s.punctuality
+        case "weightedPunctuality": v = s.weightedPunctuality
         case "delay", "averageDelay": v = s.averageDelay
         case "p90", "p90Delay": v = s.p90Delay
+        case "passengerWeightedDelay": v = s.passengerDelay
         case "throughput": v = float64(s.throughput)
         case "utilization": v = s.utilization
         case "acceptanceRate": v = s.acceptanceRate
         case "openConflicts": v = float64(s.openConflicts)
         case "headwayAdherence": v = s.headwayAdherence
         case "headwayBreaches": v = float64(s.headwayBreaches)
+        case "cancellations": v = float64(s.cancellations)
+        case "missedConnections": v = float64(s.missedConnections)
+        case "overspeeds": v = float64(s.overspeeds)
+        case "spads": v = float64(s.spads)
+        case "degradedWeatherShare": v = s.degradedWeather
+        case "movingBlockShare": v = s.movingBlock
         default: v = s.performance
         }
-        series = append(series, map[string]interface{}{"t": s.ts.Format(time.RFC3339), "v": v})
-    }
-    resp := map[string]interface{}{"metric": metric, "period": period, "series": series}
-    w.Header().Set("Content-Type", "application/json; charset=utf-8")
-    _ = json.NewEncoder(w).Encode(resp)
-}
-
-// POST /api/simulation/whatif
-func serveWhatIf(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
-    var body map[string]interface{}
-    if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "Bad request", http.StatusBadRequest); return }
-    // Stub predictive model: use current metrics to produce adjusted values
-    agg, _ := aggregateKPIs(24 * time.Hour)
-    predictions := map[string]interface{}{
-        "throughput": float64(agg.throughput) * 1.05,
-        "averageDelay": agg.averageDelay * 1.1,
-        "utilization": agg.utilization * 1.02,
-        "bottlenecks": []string{"Junction_B"},
-        "recommendations": []string{"Consider staggering train arrivals", "Monitor signal SIG_B1 timing"},
-    }
-    resp := map[string]interface{}{
-        "scenarioId": "scenario_" + time.Now().UTC().Format("20060102150405"),
-        "predictions": predictions,
-        "confidence": 0.75,
+        series = append(series, map[string]interface{}{"t": s.ts.Format(time.RFC3339), "simTime": s.simTime, "v": v, "weather": s.weather, "signallingMode": s.signallingMode})
     }
-    w.Header().Set("Content-Type", "application/json; charset=utf-8")
-    _ = json.NewEncoder(w).Encode(resp)
+    return map[string]interface{}{"metric": metric, "period": period, "series": series}
 }
 
 // GET /api/ai/hints
 func serveAIHints(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+    h := requestHub(r)
+    if r.Method != http.MethodGet { methodNotAllowed(w, r); return }
     // Ensure simulation is ready
-    if sim == nil { http.Error(w, "Simulation not initialized", http.StatusServiceUnavailable); return }
+    if h.sim == nil { simulationNotInitialized(w); return }
     // Optional: force recompute
-    if r.URL.Query().Get("recompute") == "1" { simulation.RecomputeSuggestions() }
+    if r.URL.Query().Get("recompute") == "1" { h.sim.RecomputeSuggestions() }
     // If no snapshot yet, compute once
-    if sim.Suggestions == nil { simulation.RecomputeSuggestions() }
+    if h.sim.Suggestions == nil { h.sim.RecomputeSuggestions() }
     // Map suggestions snapshot to hints format
     type hint struct {
         ID        string                 `json:"id"`
@@ -134,8 +147,8 @@ func serveAIHints(w http.ResponseWriter, r *http.Request) {
         SuggestedAction map[string]interface{} `json:"suggestedAction"`
     }
     hints := []hint{}
-    if sim.Suggestions != nil {
-        for _, s := range sim.Suggestions.Items {
+    if h.sim.Suggestions != nil {
+        for _, s := range h.sim.Suggestions.Items {
             prio := "MEDIUM"
             if s.Score >= 15 { prio = "HIGH" } else if s.Score < 5 { prio = "LOW" }
             msg := s.Title
@@ -153,7 +166,8 @@ func serveAIHints(w http.ResponseWriter, r *http.Request) {
 
 // POST /api/ai/hints/{hintId}/respond
 func serveAIHintRespond(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+    h := requestHub(r)
+    if r.Method != http.MethodPost { methodNotAllowed(w, r); return }
     hid := strings.TrimPrefix(r.URL.Path, "/api/ai/hints/")
     var body struct{
         Response string `json:"response"`
@@ -161,21 +175,31 @@ func serveAIHintRespond(w http.ResponseWriter, r *http.Request) {
         UserID string `json:"userId"`
         DismissMinutes int `json:"dismissMinutes"`
     }
-    if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "Bad request", http.StatusBadRequest); return }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil { badRequest(w, err); return }
+    sg, found := findSuggestion(h.sim, hid)
+    userID, role := clientIdentity(r)
+    if body.UserID != "" { userID = body.UserID }
+    decision := suggestionDecision{Source: "http", Role: ClientRole(role), UserID: userID}
     switch strings.ToUpper(body.Response) {
     case "ACCEPT":
-        _ = simulation.AcceptSuggestion(hid)
-        simulation.RecomputeSuggestions()
-        metrics.mu.Lock(); metrics.accepted = append(metrics.accepted, time.Now().UTC()); metrics.mu.Unlock()
+        decision.Decision = decisionAccepted
+        if err := h.sim.AcceptSuggestion(hid); err != nil { decision.Error = err.Error() }
+        h.sim.RecomputeSuggestions()
+        h.metrics.mu.Lock(); h.metrics.accepted = append(h.metrics.accepted, time.Now().UTC()); h.metrics.mu.Unlock()
     case "DISMISS":
         if body.DismissMinutes <= 0 { body.DismissMinutes = 10 }
-        _ = simulation.RejectSuggestion(hid, body.DismissMinutes)
-        simulation.RecomputeSuggestions()
-        metrics.mu.Lock(); metrics.ignored = append(metrics.ignored, time.Now().UTC()); metrics.mu.Unlock()
+        decision.Decision = decisionDismissed
+        decision.DismissMinutes = body.DismissMinutes
+        _ = h.sim.RejectSuggestion(hid, body.DismissMinutes)
+        h.sim.RecomputeSuggestions()
+        h.metrics.mu.Lock(); h.metrics.ignored = append(h.metrics.ignored, time.Now().UTC()); h.metrics.mu.Unlock()
     case "OVERRIDE":
-        metrics.mu.Lock(); metrics.overrides = append(metrics.overrides, time.Now().UTC()); metrics.mu.Unlock()
+        decision.Decision = decisionOverridden
+        decision.OverrideAction = body.OverrideAction
+        h.metrics.mu.Lock(); h.metrics.overrides = append(h.metrics.overrides, time.Now().UTC()); h.metrics.mu.Unlock()
         // no-op for action by default
     }
+    if found && decision.Decision != "" { h.sendSuggestionDecision(sg, decision) }
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
     _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
 }
@@ -185,35 +209,17 @@ func serveAIHintRespond(w http.ResponseWriter, r *http.Request) {
 // Restarts the simulation back to its initial state loaded at process start.
 // This reinitializes all data and time to the original snapshot.
 func serveSimulationRestart(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
-    if sim == nil { http.Error(w, "Simulation not initialized", http.StatusServiceUnavailable); return }
-    if initialSimSnapshot == nil { http.Error(w, "Initial snapshot unavailable", http.StatusInternalServerError); return }
-
-    // Pause current loop if running
-    if sim.IsStarted() { sim.Pause() }
-
-    // Rebuild a fresh Simulation from the initial snapshot
-    var fresh simulation.Simulation
-    if err := json.Unmarshal(initialSimSnapshot, &fresh); err != nil {
-        http.Error(w, "Failed to rebuild simulation", http.StatusInternalServerError)
-        return
-    }
-    // Initialize and swap
-    if err := fresh.Initialize(); err != nil {
-        http.Error(w, "Failed to initialize simulation", http.StatusInternalServerError)
+    h := requestHub(r)
+    if r.Method != http.MethodPost { methodNotAllowed(w, r); return }
+    if h.sim == nil { simulationNotInitialized(w); return }
+    if err := h.restartSimulation(); err != nil {
+        internalError(w, "Failed to restart simulation", err)
         return
     }
 
-    // Swap global pointer
-    sim = &fresh
-
-    // Rebind suggestion engine
-    simulation.ResetSuggestionEngine(sim)
-    if sim.Options.SuggestionsEnabled { simulation.RecomputeSuggestions() }
-
     // Optionally restart clock if client requests autoStart=1
     if r.URL.Query().Get("autoStart") == "1" {
-        sim.Start()
+        h.sim.Start()
     }
 
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -221,32 +227,54 @@ func serveSimulationRestart(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// GET /api/connections
+// Returns health metrics of the websocket connections, globally and per simulation.
+func serveConnections(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { methodNotAllowed(w, r); return }
+    items := []map[string]interface{}{}
+    for _, h := range simulations.list() {
+        items = append(items, map[string]interface{}{
+            "simulationId": h.id,
+            "clients": atomic.LoadInt32(&h.clientCount),
+            "listeners": h.listenerCount(),
+        })
+    }
+    res := connStats.report()
+    res["idleTimeoutSeconds"] = currentIdleTimeout().Seconds()
+    res["simulations"] = items
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
+
+
 // GET /api/audit/logs?sinceId=123&limit=200
 func serveAuditLogs(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+    h := requestHub(r)
+    if r.Method != http.MethodGet { methodNotAllowed(w, r); return }
     q := r.URL.Query()
     sinceParam := q.Get("sinceId")
     limitParam := q.Get("limit")
     var sinceID int64
     var err error
-    if sinceParam != "" { sinceID, err = strconv.ParseInt(sinceParam, 10, 64); if err != nil { http.Error(w, "Bad sinceId", http.StatusBadRequest); return } }
+    if sinceParam != "" { sinceID, err = strconv.ParseInt(sinceParam, 10, 64); if err != nil { invalidParameter(w, "Bad sinceId", map[string]interface{}{"sinceId": sinceParam}); return } }
     limit := 200
     if limitParam != "" { if l, err2 := strconv.Atoi(limitParam); err2 == nil && l > 0 && l <= 1000 { limit = l } }
-    logs := audits.getSince(sinceID, limit)
+    logs := h.audits.getSince(sinceID, limit)
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
     _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": logs})
 }
 
 // GET /api/audit/stream (Server-Sent Events)
 func serveAuditStream(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+    h := requestHub(r)
+    if r.Method != http.MethodGet { methodNotAllowed(w, r); return }
     w.Header().Set("Content-Type", "text/event-stream")
     w.Header().Set("Cache-Control", "no-cache")
     w.Header().Set("Connection", "keep-alive")
     flusher, ok := w.(http.Flusher)
-    if !ok { http.Error(w, "Streaming unsupported", http.StatusInternalServerError); return }
-    ch := audits.subscribe()
-    defer audits.unsubscribe(ch)
+    if !ok { internalError(w, "Streaming unsupported", nil); return }
+    ch := h.audits.subscribe()
+    defer h.audits.unsubscribe(ch)
     // Send a comment to establish stream
     _, _ = w.Write([]byte(":ok\n\n"))
     flusher.Flush()
@@ -4,11 +4,23 @@
 package server
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
 )
 
 func TestHTTP(t *testing.T) {
@@ -31,5 +43,1502 @@ func TestHTTP(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
 		})
+		Convey("Overview delta", func() {
+			var full struct {
+				Version int64                    `json:"version"`
+				Full    bool                     `json:"full"`
+				Routes  []map[string]interface{} `json:"routes"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/overview/delta")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&full), ShouldBeNil)
+			So(full.Full, ShouldBeTrue)
+			So(full.Routes, ShouldHaveLength, 5)
+			var delta struct {
+				Full   bool                     `json:"full"`
+				Routes []map[string]interface{} `json:"routes"`
+			}
+			res, err = http.Get(fmt.Sprintf("http://127.0.0.1:22222/api/systems/overview/delta?since=%d", full.Version))
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&delta), ShouldBeNil)
+			So(delta.Full, ShouldEqual, full.Version == 0)
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/overview/delta?since=abc")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Layout GeoJSON export", func() {
+			var fc struct {
+				Type     string `json:"type"`
+				Features []struct {
+					ID       string `json:"id"`
+					Geometry struct {
+						Type        string      `json:"type"`
+						Coordinates interface{} `json:"coordinates"`
+					} `json:"geometry"`
+				} `json:"features"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/layout.geojson?transform=2,0,10,0,-1,0")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&fc), ShouldBeNil)
+			So(fc.Type, ShouldEqual, "FeatureCollection")
+			So(len(fc.Features), ShouldBeGreaterThan, 0)
+			for _, f := range fc.Features {
+				if f.ID == "11" {
+					So(f.Geometry.Type, ShouldEqual, "Point")
+					So(f.Geometry.Coordinates, ShouldResemble, []interface{}{1090.0, 0.0})
+				}
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout.geojson?transform=1,2,3")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Geographic coordinates", func() {
+			hub.sim.Options.GeoReference = &simulation.GeoReference{Affine: []float64{0.0009765625, 0, 2, 0, -0.0009765625, 48}}
+			defer func() { hub.sim.Options.GeoReference = nil }()
+			var fc struct {
+				Features []struct {
+					ID       string `json:"id"`
+					Geometry struct {
+						Coordinates interface{} `json:"coordinates"`
+					} `json:"geometry"`
+				} `json:"features"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/layout.geojson")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&fc), ShouldBeNil)
+			found := false
+			for _, f := range fc.Features {
+				if f.ID == "11" {
+					found = true
+					So(f.Geometry.Coordinates, ShouldResemble, []interface{}{2.52734375, 48.0})
+				}
+			}
+			So(found, ShouldBeTrue)
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout.geojson?transform=2,0,10,0,-1,0")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&fc), ShouldBeNil)
+			for _, f := range fc.Features {
+				if f.ID == "11" {
+					So(f.Geometry.Coordinates, ShouldResemble, []interface{}{1090.0, 0.0})
+				}
+			}
+			var overview struct {
+				Signals []struct {
+					ID       string             `json:"id"`
+					Position map[string]float64 `json:"position"`
+				} `json:"signals"`
+				Trains []struct {
+					Position map[string]float64 `json:"position"`
+				} `json:"trains"`
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/overview")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&overview), ShouldBeNil)
+			for _, s := range overview.Signals {
+				if s.ID == "11" {
+					So(s.Position, ShouldResemble, map[string]float64{"x": 540, "y": 0, "lat": 48, "lon": 2.52734375})
+				}
+			}
+			So(overview.Trains, ShouldNotBeEmpty)
+			So(overview.Trains[0].Position, ShouldContainKey, "lat")
+			So(overview.Trains[0].Position, ShouldContainKey, "lon")
+		})
+		Convey("Layout position queries", func() {
+			var items struct {
+				Items []struct {
+					ID       string  `json:"id"`
+					Type     string  `json:"type"`
+					Distance float64 `json:"distance"`
+				} `json:"items"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/layout/items?x=540&y=0&radius=20&type=SignalItem")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&items), ShouldBeNil)
+			So(items.Items, ShouldNotBeEmpty)
+			So(items.Items[0].ID, ShouldEqual, "11")
+			So(items.Items[0].Distance, ShouldEqual, 0)
+			for _, it := range items.Items {
+				So(it.Type, ShouldEqual, "SignalItem")
+				So(it.Distance, ShouldBeLessThanOrEqualTo, 20)
+			}
+			var nearest struct {
+				Item struct {
+					ID       string  `json:"id"`
+					Distance float64 `json:"distance"`
+				} `json:"item"`
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout/nearest?x=541&y=3&type=SignalItem")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&nearest), ShouldBeNil)
+			So(nearest.Item.ID, ShouldEqual, "11")
+			So(nearest.Item.Distance, ShouldAlmostEqual, math.Hypot(1, 3))
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout/nearest?x=541&y=3&type=Unknown")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			for _, q := range []string{"items?x=1&radius=5", "items?x=1&y=1", "items?x=1&y=1&radius=-1", "nearest?lat=48&lon=2"} {
+				res, err = http.Get("http://127.0.0.1:22222/api/systems/layout/" + q)
+				So(err, ShouldBeNil)
+				So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			}
+
+			hub.sim.Options.GeoReference = &simulation.GeoReference{Affine: []float64{0.0009765625, 0, 2, 0, -0.0009765625, 48}}
+			defer func() { hub.sim.Options.GeoReference = nil }()
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout/nearest?lat=48&lon=2.52734375&type=SignalItem")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&nearest), ShouldBeNil)
+			So(nearest.Item.ID, ShouldEqual, "11")
+
+			var fc struct {
+				Features []struct {
+					ID string `json:"id"`
+				} `json:"features"`
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout.geojson?bbox=530,-5,550,5")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&fc), ShouldBeNil)
+			ids := make(map[string]bool)
+			for _, f := range fc.Features {
+				ids[f.ID] = true
+			}
+			So(ids, ShouldContainKey, "11")
+			So(len(fc.Features), ShouldBeLessThan, len(hub.sim.TrackItems))
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout.geojson?bbox=10,0,0,10")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Layout rendering", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/layout.svg")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldStartWith, "image/svg+xml")
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout.png?width=400")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			img, err := png.Decode(res.Body)
+			So(err, ShouldBeNil)
+			So(img.Bounds().Dx(), ShouldEqual, 400)
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/layout.png?width=1")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("What-if simulation", func() {
+			body := `{"durationMinutes": 10, "changes": [{"type": "HOLD_TRAIN", "trainId": "0", "minutes": 5}, {"type": "BLOCK_TRACK", "trackItemId": "4"}]}`
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/whatif", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var resp struct {
+				HorizonMinutes int                    `json:"horizonMinutes"`
+				Baseline       map[string]interface{} `json:"baseline"`
+				Predictions    map[string]interface{} `json:"predictions"`
+				Conflicts      []interface{}          `json:"conflicts"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&resp), ShouldBeNil)
+			So(resp.HorizonMinutes, ShouldEqual, 10)
+			So(resp.Baseline, ShouldContainKey, "throughput")
+			So(resp.Predictions, ShouldContainKey, "averageDelay")
+			So(resp.Conflicts, ShouldNotBeNil)
+			So(sim.TrackItems["4"].Blocked(), ShouldBeFalse)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/whatif", "application/json",
+				strings.NewReader(`{"changes": [{"type": "HOLD_TRAIN", "trainId": "99", "minutes": 5}]}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Scenario management", func() {
+			body := `{"name": "Hold 0", "durationMinutes": 5, "changes": [{"type": "HOLD_TRAIN", "trainId": "0", "minutes": 2}]}`
+			res, err := http.Post("http://127.0.0.1:22222/api/scenarios", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var created scenario
+			So(json.NewDecoder(res.Body).Decode(&created), ShouldBeNil)
+			So(created.ID, ShouldNotBeEmpty)
+			So(created.Name, ShouldEqual, "Hold 0")
+			So(created.Input.Changes, ShouldHaveLength, 1)
+			So(created.Result, ShouldContainKey, "predictions")
+			So(created.Snapshot, ShouldBeEmpty)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/scenarios/" + created.ID + "?snapshot=1")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var detailed scenario
+			So(json.NewDecoder(res.Body).Decode(&detailed), ShouldBeNil)
+			So(detailed.Snapshot, ShouldNotBeEmpty)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/scenarios")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(len(list.Items), ShouldBeGreaterThanOrEqualTo, 1)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/scenarios/compare?ids=" + created.ID)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/scenarios/"+created.ID, nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.Get("http://127.0.0.1:22222/api/scenarios/" + created.ID)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Disruption injection", func() {
+			body := `{"type": "speed_restriction", "trackItemId": "102", "toTrackItemId": "104", "speedLimit": 4, "durationMinutes": 10}`
+			res, err := http.Post("http://127.0.0.1:22222/api/disruptions", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var d struct {
+				ID      string   `json:"id"`
+				Items   []string `json:"items"`
+				Status  string   `json:"status"`
+				EndTime string   `json:"endTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&d), ShouldBeNil)
+			So(d.Items, ShouldResemble, []string{"102", "103", "104"})
+			So(d.Status, ShouldEqual, "ACTIVE")
+			So(d.EndTime, ShouldNotBeEmpty)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/disruptions/" + d.ID)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/disruptions/"+d.ID, nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.TrackItems["103"].SpeedRestriction(), ShouldEqual, 0)
+
+			res, err = http.Post("http://127.0.0.1:22222/api/disruptions", "application/json",
+				strings.NewReader(`{"type": "POINTS_LOCKED", "trackItemId": "5"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Speed restrictions", func() {
+			body := `{"trackItemId": "102", "toTrackItemId": "104", "speedLimit": 4, "durationMinutes": 10}`
+			res, err := http.Post("http://127.0.0.1:22222/api/speed-restrictions", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var sr struct {
+				ID     string   `json:"id"`
+				Items  []string `json:"items"`
+				Status string   `json:"status"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&sr), ShouldBeNil)
+			So(sr.Items, ShouldResemble, []string{"102", "103", "104"})
+			So(sr.Status, ShouldEqual, "ACTIVE")
+			So(sim.TrackItems["103"].SpeedRestriction(), ShouldEqual, 4)
+
+			var overview struct {
+				SpeedRestrictions []map[string]interface{} `json:"speedRestrictions"`
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/overview")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&overview), ShouldBeNil)
+			So(overview.SpeedRestrictions, ShouldHaveLength, 1)
+
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/speed-restrictions/"+sr.ID, nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.TrackItems["103"].SpeedRestriction(), ShouldEqual, 0)
+			res, err = http.Get("http://127.0.0.1:22222/api/speed-restrictions/" + sr.ID)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			res, err = http.Post("http://127.0.0.1:22222/api/speed-restrictions", "application/json",
+				strings.NewReader(`{"trackItemId": "102"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Track possessions", func() {
+			body := `{"trackItemId": "102", "toTrackItemId": "104", "startTime": "23:00:00", "durationMinutes": 30, "reason": "Engineering works"}`
+			res, err := http.Post("http://127.0.0.1:22222/api/possessions", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var p struct {
+				ID      string   `json:"id"`
+				Items   []string `json:"items"`
+				Status  string   `json:"status"`
+				EndTime string   `json:"endTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&p), ShouldBeNil)
+			So(p.Items, ShouldResemble, []string{"102", "103", "104"})
+			So(p.Status, ShouldEqual, "PLANNED")
+			So(p.EndTime, ShouldEqual, "23:30:00")
+			So(sim.TrackItems["103"].Blocked(), ShouldBeFalse)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/possessions")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Items, ShouldHaveLength, 1)
+
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/possessions/"+p.ID, nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.Get("http://127.0.0.1:22222/api/possessions/" + p.ID)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Signal malfunctions", func() {
+			body := `{"mode": "DARK", "reason": "Lamp failure", "repairMinutes": 20}`
+			req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/systems/signals/11/failure", strings.NewReader(body))
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var f struct {
+				MalfunctionStatus string `json:"malfunctionStatus"`
+				FailureMode       string `json:"failureMode"`
+				Cause             string `json:"cause"`
+				RepairTime        string `json:"repairTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&f), ShouldBeNil)
+			So(f.MalfunctionStatus, ShouldEqual, "FAILED")
+			So(f.FailureMode, ShouldEqual, "DARK")
+			So(f.Cause, ShouldEqual, "Lamp failure")
+			So(f.RepairTime, ShouldNotBeEmpty)
+
+			req, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/systems/signals/11/failure", strings.NewReader(`{"mode": "FLASHING"}`))
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			req, _ = http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/systems/signals/11/failure", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&f), ShouldBeNil)
+			So(f.MalfunctionStatus, ShouldEqual, "OPERATIONAL")
+		})
+		Convey("Points malfunctions", func() {
+			body := `{"mode": "OUT_OF_CORRESPONDENCE", "reason": "Detection lost", "repairMinutes": 0}`
+			req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/systems/points/7/failure", strings.NewReader(body))
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var f struct {
+				MalfunctionStatus string `json:"malfunctionStatus"`
+				FailureMode       string `json:"failureMode"`
+				RepairTime        string `json:"repairTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&f), ShouldBeNil)
+			So(f.MalfunctionStatus, ShouldEqual, "FAILED")
+			So(f.FailureMode, ShouldEqual, "OUT_OF_CORRESPONDENCE")
+			So(f.RepairTime, ShouldBeEmpty)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/points")
+			So(err, ShouldBeNil)
+			var list struct {
+				Points []map[string]interface{} `json:"points"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Points, ShouldHaveLength, 1)
+			So(list.Points[0]["failureMode"], ShouldEqual, "OUT_OF_CORRESPONDENCE")
+
+			req, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/systems/points/5/failure", strings.NewReader(body))
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			req, _ = http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/systems/points/7/failure", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&f), ShouldBeNil)
+			So(f.MalfunctionStatus, ShouldEqual, "OPERATIONAL")
+		})
+		Convey("Level crossings", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/level-crossings")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var list struct {
+				LevelCrossings []map[string]interface{} `json:"levelCrossings"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.LevelCrossings, ShouldBeEmpty)
+
+			res, err = http.Post("http://127.0.0.1:22222/api/systems/level-crossings/6/close", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Train delay injection", func() {
+			body := `{"holdMinutes": 3, "performanceFactor": 0.5, "durationMinutes": 10, "reason": "test"}`
+			res, err := http.Post("http://127.0.0.1:22222/api/trains/1/delay", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var d struct {
+				Held          bool    `json:"held"`
+				HeldUntil     string  `json:"heldUntil"`
+				Performance   float64 `json:"performance"`
+				DegradedUntil string  `json:"degradedUntil"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&d), ShouldBeNil)
+			So(d.Held, ShouldBeTrue)
+			So(d.HeldUntil, ShouldNotBeEmpty)
+			So(d.Performance, ShouldEqual, 0.5)
+			So(d.DegradedUntil, ShouldNotBeEmpty)
+
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/trains/1/delay", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.Trains[1].IsHeld(), ShouldBeFalse)
+			So(sim.Trains[1].Performance(), ShouldEqual, 1)
+
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/1/delay", "application/json",
+				strings.NewReader(`{"performanceFactor": 0.5}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/99/delay", "application/json",
+				strings.NewReader(`{"holdMinutes": 1}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Batch commands", func() {
+			// post runs the given commands with the given token
+			post := func(token, body string) *http.Response {
+				req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:22222/api/commands", strings.NewReader(body))
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				return res
+			}
+			body := `{"commands": [
+				{"object": "route", "action": "show", "params": {"ids": ["1"]}},
+				{"object": "route", "action": "activate", "params": {"id": "99"}},
+				{"object": "route", "action": "list"}
+			]}`
+			So(post("", body).StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(post("client-secret", body).StatusCode, ShouldEqual, http.StatusUnauthorized)
+			res := post("alice-secret", body)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var b struct {
+				Status  string `json:"status"`
+				Failed  int    `json:"failed"`
+				Skipped int    `json:"skipped"`
+				Results []struct {
+					Status  string          `json:"status"`
+					Message string          `json:"message"`
+					Data    json.RawMessage `json:"data"`
+				} `json:"results"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&b), ShouldBeNil)
+			So(b.Status, ShouldEqual, "PARTIAL")
+			So(b.Failed, ShouldEqual, 1)
+			So(b.Skipped, ShouldEqual, 1)
+			So(b.Results, ShouldHaveLength, 3)
+			So(b.Results[0].Status, ShouldEqual, "OK")
+			So(string(b.Results[0].Data), ShouldContainSubstring, `"1"`)
+			So(b.Results[1].Status, ShouldEqual, "FAIL")
+			So(b.Results[2].Status, ShouldEqual, "SKIPPED")
+
+			res = post("alice-secret", `{"commands": [{"object": "server", "action": "register"}]}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&b), ShouldBeNil)
+			So(b.Status, ShouldEqual, "FAILED")
+
+			// Commands are run with the role of the user of the token
+			res = post("alice-secret", `{"commands": [{"object": "simulation", "action": "restart"}]}`)
+			So(json.NewDecoder(res.Body).Decode(&b), ShouldBeNil)
+			So(b.Status, ShouldEqual, "FAILED")
+			So(b.Results[0].Status, ShouldEqual, string(PermissionDenied))
+			So(b.Results[0].Message, ShouldEqual, "Error: role operator is not allowed to call simulation/restart")
+		})
+		Convey("API versioning", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/v1/systems/signals")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("API-Version"), ShouldEqual, "v1")
+			So(res.Header.Get("Deprecation"), ShouldBeEmpty)
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/signals")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Deprecation"), ShouldEqual, "true")
+			So(res.Header.Get("Link"), ShouldContainSubstring, "</api/v1/systems/signals>")
+			So(res.Header.Get("Sunset"), ShouldBeEmpty)
+			// The sunset date is only sent if it is configured
+			sunset := testServerConfig()
+			sunset.APISunset = time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)
+			So(SetServerConfig(sunset), ShouldBeNil)
+			defer func() { So(SetServerConfig(testServerConfig()), ShouldBeNil) }()
+			res, err = http.Get("http://127.0.0.1:22222/api/systems/signals")
+			So(err, ShouldBeNil)
+			So(res.Header.Get("Sunset"), ShouldEqual, "Wed, 30 Jun 2027 00:00:00 GMT")
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/scenarios/unknown")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			// The versioned root is not taken for an unversioned route
+			res, err = http.Get("http://127.0.0.1:22222/api/v1")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(res.Header.Get("API-Version"), ShouldEqual, "v1")
+			So(res.Header.Get("Deprecation"), ShouldBeEmpty)
+		})
+		Convey("API errors should use the JSON envelope", func() {
+			var e struct {
+				Error struct {
+					Code    string                 `json:"code"`
+					Message string                 `json:"message"`
+					Details map[string]interface{} `json:"details"`
+				} `json:"error"`
+			}
+			res, err := http.Post("http://127.0.0.1:22222/api/v1/trains/99/route", "application/json",
+				strings.NewReader(`{"action": "HALT"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(res.Header.Get("Content-Type"), ShouldStartWith, "application/json")
+			So(json.NewDecoder(res.Body).Decode(&e), ShouldBeNil)
+			So(e.Error.Code, ShouldEqual, "TRAIN_NOT_FOUND")
+			So(e.Error.Details["trainId"], ShouldEqual, "99")
+			res, err = http.Post("http://127.0.0.1:22222/api/v1/simulation/whatif", "application/json", strings.NewReader(`{`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(json.NewDecoder(res.Body).Decode(&e), ShouldBeNil)
+			So(e.Error.Code, ShouldEqual, "BAD_REQUEST")
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/simulation/whatif")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+			So(json.NewDecoder(res.Body).Decode(&e), ShouldBeNil)
+			So(e.Error.Code, ShouldEqual, "METHOD_NOT_ALLOWED")
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/undefined")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(json.NewDecoder(res.Body).Decode(&e), ShouldBeNil)
+			So(e.Error.Code, ShouldEqual, "NOT_FOUND")
+		})
+		Convey("Mutating requests should be audited", func() {
+			req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:22222/api/v1/commands",
+				strings.NewReader(`{"commands": [{"object": "route", "action": "list"}]}`))
+			req.Header.Set("Authorization", "Bearer alice-secret")
+			req.Header.Set("X-User-ID", "trainer-1")
+			req.Header.Set("X-User-Role", "trainer")
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/audit/logs?limit=1000")
+			So(err, ShouldBeNil)
+			var logs struct {
+				Items []AuditEntry `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&logs), ShouldBeNil)
+			var found *AuditEntry
+			for i, e := range logs.Items {
+				if e.Event == "HTTP_COMMAND" && e.Details["user"] == "trainer-1" {
+					found = &logs.Items[i]
+				}
+			}
+			So(found, ShouldNotBeNil)
+			So(found.Details["path"], ShouldEqual, "/api/v1/commands")
+			So(found.Details["method"], ShouldEqual, "POST")
+			So(found.Details["status"], ShouldEqual, 200)
+			So(found.Details["role"], ShouldEqual, "trainer")
+		})
+		Convey("Webhooks", func() {
+			webhookRetryDelay = 10 * time.Millisecond
+			received := make(chan *http.Request, 10)
+			bodies := make(chan []byte, 10)
+			var calls int32
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				received <- r
+				bodies <- b
+			}))
+			defer target.Close()
+			body := fmt.Sprintf(`{"url": "%s", "events": ["train_departed_from_station"], "secret": "s3cret"}`, target.URL)
+			res, err := http.Post("http://127.0.0.1:22222/api/v1/webhooks", "application/json", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var wh struct {
+				ID     string `json:"id"`
+				Signed bool   `json:"signed"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&wh), ShouldBeNil)
+			So(wh.Signed, ShouldBeTrue)
+
+			audits.append(AuditEntry{Event: "ROUTE_ACTIVATED", Category: "route", Severity: "INFO"})
+			audits.append(AuditEntry{Event: "TRAIN_DEPARTED_FROM_STATION", Category: "train", Severity: "INFO",
+				Object: map[string]interface{}{"id": "0"}})
+			var req *http.Request
+			var payload []byte
+			select {
+			case req = <-received:
+				payload = <-bodies
+			case <-time.After(2 * time.Second):
+			}
+			So(req, ShouldNotBeNil)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+			So(req.Header.Get("X-TS2-Event"), ShouldEqual, "TRAIN_DEPARTED_FROM_STATION")
+			So(req.Header.Get("X-TS2-Attempt"), ShouldEqual, "2")
+			So(req.Header.Get("X-TS2-Signature"), ShouldEqual, signWebhookPayload("s3cret", payload))
+			var p webhookPayload
+			So(json.Unmarshal(payload, &p), ShouldBeNil)
+			So(p.WebhookID, ShouldEqual, wh.ID)
+			So(p.Entry.Object["id"], ShouldEqual, "0")
+
+			req2, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/v1/webhooks/"+wh.ID, nil)
+			res, err = http.DefaultClient.Do(req2)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.Post("http://127.0.0.1:22222/api/v1/webhooks", "application/json",
+				strings.NewReader(`{"url": "ftp://example.com"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Suggestion decision webhook", func() {
+			webhookRetryDelay = 10 * time.Millisecond
+			var status struct {
+				Enabled bool `json:"enabled"`
+				Stats   struct {
+					Delivered int `json:"delivered"`
+					Retries   int `json:"retries"`
+				} `json:"stats"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/v1/suggestions/webhook")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&status), ShouldBeNil)
+			So(status.Enabled, ShouldBeFalse)
+			So(SetSuggestionWebhookConfig(SuggestionWebhookConfig{URL: "ftp://example.com"}), ShouldNotBeNil)
+			So(SetSuggestionWebhookConfig(SuggestionWebhookConfig{URL: "http://example.com", Decisions: []string{"ignored"}}), ShouldNotBeNil)
+
+			received := make(chan suggestionDecision, 10)
+			var calls int32
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				var d suggestionDecision
+				if r.Header.Get("X-TS2-Signature") != signWebhookPayload("s3cret", b) || json.Unmarshal(b, &d) != nil ||
+					r.Header.Get("X-TS2-Event") != "suggestion."+d.Decision {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if strings.HasPrefix(d.Suggestion.ID, "SWTEST:") {
+					received <- d
+				}
+			}))
+			defer target.Close()
+			So(SetSuggestionWebhookConfig(SuggestionWebhookConfig{URL: target.URL, Secret: "s3cret",
+				Decisions: []string{"generated", "Accepted"}}), ShouldBeNil)
+			defer func() {
+				suggestionHookMutex.Lock()
+				close(suggestionHook.queue)
+				suggestionHook = nil
+				suggestionHookMutex.Unlock()
+			}()
+			startSuggestionWebhook()
+
+			hub.sendGeneratedSuggestions(&simulation.Event{Name: simulation.SuggestionsUpdatedEvent,
+				Object: simulation.Suggestions{Items: []simulation.Suggestion{{ID: "SWTEST:1"}, {ID: "SWTEST:2"}}}})
+			hub.sendGeneratedSuggestions(&simulation.Event{Name: simulation.SuggestionsUpdatedEvent,
+				Object: simulation.Suggestions{Items: []simulation.Suggestion{{ID: "SWTEST:2"}, {ID: "SWTEST:3"}}}})
+			hub.sendSuggestionDecision(simulation.Suggestion{ID: "SWTEST:2"}, suggestionDecision{Decision: decisionDismissed, DismissMinutes: 5})
+			hub.sendSuggestionDecision(simulation.Suggestion{ID: "SWTEST:3", Title: "Hold train"},
+				suggestionDecision{Decision: decisionAccepted, Source: "websocket", Role: RoleOperator})
+
+			var decisions []suggestionDecision
+			timeout := time.After(2 * time.Second)
+		loop:
+			for len(decisions) < 4 {
+				select {
+				case d := <-received:
+					decisions = append(decisions, d)
+				case <-timeout:
+					break loop
+				}
+			}
+			So(decisions, ShouldHaveLength, 4)
+			for i, id := range []string{"SWTEST:1", "SWTEST:2", "SWTEST:3"} {
+				So(decisions[i].Decision, ShouldEqual, decisionGenerated)
+				So(decisions[i].Suggestion.ID, ShouldEqual, id)
+			}
+			So(decisions[3].Decision, ShouldEqual, decisionAccepted)
+			So(decisions[3].Suggestion.Title, ShouldEqual, "Hold train")
+			So(decisions[3].Role, ShouldEqual, RoleOperator)
+			So(decisions[3].Simulation, ShouldEqual, DefaultSimulationID)
+			So(decisions[3].DeliveryID, ShouldNotBeEmpty)
+
+			for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && status.Stats.Delivered < 4; {
+				res, err = http.Get("http://127.0.0.1:22222/api/v1/suggestions/webhook")
+				So(err, ShouldBeNil)
+				So(json.NewDecoder(res.Body).Decode(&status), ShouldBeNil)
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(status.Enabled, ShouldBeTrue)
+			So(status.Stats.Delivered, ShouldBeGreaterThanOrEqualTo, 4)
+			So(status.Stats.Retries, ShouldEqual, 1)
+		})
+		Convey("Simulation dump", func() {
+			resp, err := http.Get("http://127.0.0.1:22222/api/simulation/dump")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			var simu simulation.Simulation
+			So(json.NewDecoder(resp.Body).Decode(&simu), ShouldBeNil)
+			_ = resp.Body.Close()
+			So(simu.TrackItems, ShouldHaveLength, 29)
+			So(simu.Places, ShouldContainKey, "STN")
+
+			req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:22222/api/v1/simulation/dump", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+			zr, err := gzip.NewReader(resp.Body)
+			So(err, ShouldBeNil)
+			var gzSimu simulation.Simulation
+			So(json.NewDecoder(zr).Decode(&gzSimu), ShouldBeNil)
+			So(gzSimu.Places, ShouldHaveLength, 3)
+		})
+		Convey("Timetable updates", func() {
+			post := func(body string) []timetableUpdateResult {
+				res, err := http.Post("http://127.0.0.1:22222/api/v1/timetable/updates", "application/json", strings.NewReader(body))
+				So(err, ShouldBeNil)
+				So(res.StatusCode, ShouldEqual, http.StatusOK)
+				var out struct {
+					Items []timetableUpdateResult `json:"items"`
+				}
+				So(json.NewDecoder(res.Body).Decode(&out), ShouldBeNil)
+				return out.Items
+			}
+			items := post(`{"source": "tms", "updates": [
+				{"id": "u1", "type": "delay", "serviceCode": "S002", "placeCode": "STN", "delayMinutes": 3, "reason": "Late inbound"},
+				{"id": "u2", "type": "CANCELLATION", "serviceCode": "XXX"},
+				{"id": "u3", "type": "PLATFORM", "serviceCode": "S002", "placeCode": "STN"}]}`)
+			So(items, ShouldHaveLength, 3)
+			So(items[0].Status, ShouldEqual, timetableUpdateApplied)
+			So(*items[0].AppliedLineIndex, ShouldEqual, 0)
+			So(simulation.FormatTime(sim.Services["S002"].Lines[0].ExpectedDepartureTime.Time), ShouldEqual, "06:10:00")
+			So(items[1].Status, ShouldEqual, timetableUpdateRejected)
+			So(items[1].Error, ShouldContainSubstring, "unknown service")
+			So(items[2].Status, ShouldEqual, timetableUpdateRejected)
+
+			items = post(`{"source": "tms", "updates": [
+				{"id": "u1", "type": "DELAY", "serviceCode": "S002", "placeCode": "STN", "delayMinutes": 5},
+				{"id": "u4", "type": "DELAY", "serviceCode": "S002", "lineIndex": 0, "delayMinutes": 0}]}`)
+			So(items[0].Status, ShouldEqual, timetableUpdateDuplicate)
+			So(items[1].Status, ShouldEqual, timetableUpdateApplied)
+			So(sim.Services["S002"].Lines[0].ExpectedDepartureTime, ShouldBeNil)
+
+			res, err := http.Get("http://127.0.0.1:22222/api/v1/timetable/updates?limit=2")
+			So(err, ShouldBeNil)
+			var status struct {
+				Items []timetableUpdateResult `json:"items"`
+				Stats struct {
+					Applied    int `json:"applied"`
+					Duplicates int `json:"duplicates"`
+				} `json:"stats"`
+				Feed struct {
+					Enabled bool `json:"enabled"`
+				} `json:"feed"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&status), ShouldBeNil)
+			So(status.Items, ShouldHaveLength, 2)
+			So(status.Items[1].UpdateID, ShouldEqual, "u4")
+			So(status.Stats.Applied, ShouldBeGreaterThanOrEqualTo, 2)
+			So(status.Stats.Duplicates, ShouldBeGreaterThanOrEqualTo, 1)
+			So(status.Feed.Enabled, ShouldBeFalse)
+
+			var rejected *AuditEntry
+			logs := audits.getSince(0, 1000)
+			for i, e := range logs {
+				if e.Event == "TIMETABLE_UPDATE_REJECTED" && e.Details["source"] == "tms" {
+					rejected = &logs[i]
+				}
+			}
+			So(rejected, ShouldNotBeNil)
+			So(rejected.Object["serviceCode"], ShouldEqual, "S002")
+			So(rejected.Details["error"], ShouldEqual, "trackCode is required")
+		})
+		Convey("Sections", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/v1/sections", "application/json",
+				strings.NewReader(`{"id": "APP", "name": "Station approach", "trackItems": ["8", "9", "10"]}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var sec struct {
+				Current  []map[string]interface{} `json:"currentTrains"`
+				Incoming []map[string]interface{} `json:"incomingTrains"`
+				Section  struct {
+					Name string `json:"name"`
+				} `json:"section"`
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/trains/section/APP")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&sec), ShouldBeNil)
+			So(sec.Section.Name, ShouldEqual, "Station approach")
+			So(sec.Current, ShouldNotBeNil)
+			So(sec.Incoming, ShouldNotBeNil)
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/trains/section/STN")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/trains/section/NOWHERE")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			res, err = http.Post("http://127.0.0.1:22222/api/v1/sections", "application/json",
+				strings.NewReader(`{"id": "BAD", "trackItems": ["999"]}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			// putDirection changes the direction of the section at the given
+			// path with the token of the given user and returns the status
+			putDirection := func(path, user, body string) int {
+				req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/v1/"+path+"/direction", strings.NewReader(body))
+				if user != "" {
+					req.Header.Set("Authorization", "Bearer "+user+"-secret")
+				}
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				res.Body.Close()
+				return res.StatusCode
+			}
+			So(putDirection("sections/APP", "", `{"direction": "UP"}`), ShouldEqual, http.StatusUnauthorized)
+			So(putDirection("sections/APP", "client", `{"direction": "UP"}`), ShouldEqual, http.StatusUnauthorized)
+			So(putDirection("sections/APP", "alice", `{"direction": "UP"}`), ShouldEqual, http.StatusForbidden)
+			So(putDirection("sections/APP", "sam", `{"direction": "UP"}`), ShouldEqual, http.StatusBadRequest)
+			So(putDirection("sections/NOWHERE", "sam", `{"direction": "UP"}`), ShouldEqual, http.StatusNotFound)
+			// A single line over the line of RGT, in a simulation of its own
+			So(AddSimulation("single", loadDemoWith(func(raw map[string]interface{}) {
+				raw["sections"] = map[string]interface{}{
+					"SL": map[string]interface{}{"name": "Branch", "trackItems": []string{"12"}, "singleLine": true, "initialDirection": "UP"},
+				}
+			})), ShouldBeNil)
+			defer func() { So(simulations.remove("single"), ShouldBeNil) }()
+			So(putDirection("simulations/single/sections/SL", "sam", `{"direction": "SIDEWAYS"}`), ShouldEqual, http.StatusBadRequest)
+			req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/v1/simulations/single/sections/SL/direction", strings.NewReader(`{"direction": "DOWN"}`))
+			req.Header.Set("Authorization", "Bearer sam-secret")
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var view map[string]interface{}
+			So(json.NewDecoder(res.Body).Decode(&view), ShouldBeNil)
+			res.Body.Close()
+			So(view["direction"], ShouldEqual, "DOWN")
+			So(view["acceptingEnd"], ShouldEqual, "12")
+			req, _ = http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/v1/sections/APP", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Perturbation generator", func() {
+			req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/simulation/perturbations", strings.NewReader(`{"dwellProbability": 2}`))
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			body := `{"enabled": true, "dwellProbability": 0.2, "dwellExtension": [[30, 120, 100]], "trainFaultRate": 0.5}`
+			req, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/simulation/perturbations", strings.NewReader(body))
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var rep struct {
+				Perturbations struct {
+					Enabled        bool     `json:"enabled"`
+					DwellExtension [][3]int `json:"dwellExtension"`
+				} `json:"perturbations"`
+				Stats map[string]interface{} `json:"stats"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&rep), ShouldBeNil)
+			So(rep.Perturbations.Enabled, ShouldBeTrue)
+			So(rep.Perturbations.DwellExtension, ShouldResemble, [][3]int{{30, 120, 100}})
+			So(sim.Options.Perturbations.TrainFaultRate, ShouldEqual, 0.5)
+			req, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1:22222/api/simulation/perturbations", strings.NewReader(`{}`))
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.Options.Perturbations.Enabled, ShouldBeFalse)
+		})
+		Convey("Runtime options", func() {
+			var opts struct {
+				Options map[string]interface{} `json:"options"`
+				Limits  map[string]interface{} `json:"limits"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/v1/simulation/options")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&opts), ShouldBeNil)
+			So(opts.Options["timeFactor"], ShouldEqual, 5)
+			So(opts.Limits, ShouldContainKey, "suggestSafetyBufferSeconds")
+			patch := func(body string) *http.Response {
+				req, _ := http.NewRequest(http.MethodPatch, "http://127.0.0.1:22222/api/v1/simulation/options", strings.NewReader(body))
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				return res
+			}
+			res = patch(`{"suggestSafetyBufferSeconds": 12, "suggestMaxItems": 20}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.Options.SuggestSafetyBufferSeconds, ShouldEqual, 12)
+			So(sim.Options.SuggestMaxItems, ShouldEqual, 20)
+			res = patch(`{"suggestMaxItems": 30, "timeFactor": 50}`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(sim.Options.SuggestMaxItems, ShouldEqual, 20)
+			So(sim.Options.TimeFactor, ShouldEqual, 5)
+			res = patch(`{"title": "Hacked"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res = patch(`{"suggestSafetyBufferSeconds": 0, "suggestMaxItems": 0}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(opts.Options["weather"], ShouldEqual, "CLEAR")
+			res = patch(`{"weather": "HAIL"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res = patch(`{"weather": "LEAF_FALL"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(sim.Options.Weather), ShouldEqual, "LEAF_FALL")
+			res = patch(`{"weather": "CLEAR"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(opts.Options["signallingMode"], ShouldEqual, "FIXED_BLOCK")
+			res = patch(`{"signallingMode": "MOVING_BLOCK", "movingBlockMargin": 30}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(sim.Options.SignallingMode), ShouldEqual, "MOVING_BLOCK")
+			So(sim.Options.MovingBlockMargin, ShouldEqual, 30)
+			res = patch(`{"signallingMode": "FIXED_BLOCK", "movingBlockMargin": 0}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(opts.Options["seed"], ShouldBeGreaterThan, 0)
+			res = patch(`{"seed": -1}`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res = patch(`{"seed": 1234}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.Options.Seed, ShouldEqual, 1234)
+		})
+		Convey("GTFS import", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/services/import/gtfs?stops=A", "application/zip", strings.NewReader(""))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/services/import/gtfs", "application/zip", strings.NewReader("not a zip"))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			for name, content := range map[string]string{
+				"stops.txt":      "stop_id,stop_name\nA,Left\nB,Station\n",
+				"routes.txt":     "route_id,route_short_name\nR1,G\n",
+				"trips.txt":      "route_id,service_id,trip_id,trip_short_name\nR1,WK,T1,HTTP100\n",
+				"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,06:30:00,06:30:00,A,1\nT1,06:32:00,06:33:00,B,2\n",
+			} {
+				f, _ := zw.Create(name)
+				_, _ = f.Write([]byte(content))
+			}
+			So(zw.Close(), ShouldBeNil)
+			res, err = http.Post("http://127.0.0.1:22222/api/services/import/gtfs?plannedTrainType=UT", "application/zip", &buf)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var imp struct {
+				Services []string `json:"services"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&imp), ShouldBeNil)
+			So(imp.Services, ShouldResemble, []string{"HTTP100"})
+			So(sim.Services["HTTP100"].Lines, ShouldHaveLength, 2)
+			delete(sim.Services, "HTTP100")
+		})
+		Convey("Timetable export", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/timetable/export?service=S001")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldStartWith, "text/csv")
+			data, _ := ioutil.ReadAll(res.Body)
+			So(strings.Split(strings.TrimSpace(string(data)), "\n"), ShouldResemble, []string{
+				"service,description,sequence,placeCode,placeName,trackCode,mustStop,arrival,departure,plannedTrainType",
+				"S001,LEFT->STATION,1,LFT,LEFT,,false,,06:00:30,UT",
+				"S001,LEFT->STATION,2,STN,STATION,2,true,06:01:30,06:02:00,UT",
+			})
+			res, err = http.Get("http://127.0.0.1:22222/api/timetable/export?format=ics&place=STN")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldStartWith, "text/calendar")
+			data, _ = ioutil.ReadAll(res.Body)
+			ics := string(data)
+			So(ics, ShouldStartWith, "BEGIN:VCALENDAR\r\n")
+			So(ics, ShouldEndWith, "END:VCALENDAR\r\n")
+			So(ics, ShouldContainSubstring, "UID:S001-2@ts2-sim-server\r\n")
+			So(ics, ShouldContainSubstring, "SUMMARY:S001 at STATION\r\n")
+			So(ics, ShouldContainSubstring, "LOCATION:STATION track 2\r\n")
+			So(ics, ShouldContainSubstring, "T060130\r\nDTEND:")
+			So(ics, ShouldContainSubstring, "RRULE:FREQ=DAILY\r\n")
+			So(ics, ShouldNotContainSubstring, "S001-1@")
+			res, err = http.Get("http://127.0.0.1:22222/api/timetable/export?format=pdf")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Get("http://127.0.0.1:22222/api/timetable/export?place=XXX")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Simulation file schema and validation", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/simulation/schema")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldStartWith, "application/schema+json")
+			var schema map[string]interface{}
+			data, _ := ioutil.ReadAll(res.Body)
+			So(json.Unmarshal(data, &schema), ShouldBeNil)
+			So(schema["$schema"], ShouldEqual, "http://json-schema.org/draft-07/schema#")
+			data, _ = ioutil.ReadFile("../simulation/testdata/demo.json")
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/validate", "application/json", bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var report simulation.ValidationReport
+			So(json.NewDecoder(res.Body).Decode(&report), ShouldBeNil)
+			So(report.Valid, ShouldBeTrue)
+			So(report.Problems, ShouldBeEmpty)
+			data, _ = ioutil.ReadFile("../simulation/testdata/badroutes.json")
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/validate", "application/json", bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&report), ShouldBeNil)
+			So(report.Valid, ShouldBeFalse)
+			So(report.Problems, ShouldHaveLength, 1)
+			So(report.Problems[0].Code, ShouldEqual, "invalid_route")
+			So(report.Problems[0].Path, ShouldEqual, "/routes/1")
+			res, err = http.Get("http://127.0.0.1:22222/api/simulation/validate")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+		Convey("railML export and import", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/systems/railml")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldStartWith, "application/xml")
+			data, _ := ioutil.ReadAll(res.Body)
+			So(string(data), ShouldContainSubstring, `<railml xmlns="https://www.railml.org/schemas/2018" version="2.4">`)
+			res, err = http.Post("http://127.0.0.1:22222/api/systems/railml", "application/xml", bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var imp struct {
+				Services []string `json:"services"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&imp), ShouldBeNil)
+			So(imp.Services, ShouldBeEmpty)
+			res, err = http.Post("http://127.0.0.1:22222/api/systems/railml", "application/xml", strings.NewReader("<railml"))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Checkpoints", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "a/b"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "http_test"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			So(res.Header.Get("Location"), ShouldEqual, "/api/simulation/checkpoints/http_test")
+			title := sim.Options.Title
+			res, err = http.Get("http://127.0.0.1:22222/api/simulation/checkpoints")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Items, ShouldHaveLength, 1)
+			So(list.Items[0]["name"], ShouldEqual, "http_test")
+			res, err = http.Get("http://127.0.0.1:22222/api/simulation/checkpoints/http_test")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var cp map[string]interface{}
+			So(json.NewDecoder(res.Body).Decode(&cp), ShouldBeNil)
+			So(cp, ShouldContainKey, "state")
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/unknown/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			old := sim
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/http_test/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim, ShouldNotEqual, old)
+			So(sim.Options.Title, ShouldEqual, title)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulation/checkpoints/http_test", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Rewind", func() {
+			// Keep the initial state for the following tests
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "rewind_test"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			// Two minutes of simulation
+			for i := 0; i < 48; i++ {
+				sim.Step()
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/simulation/rewind")
+			So(err, ShouldBeNil)
+			var timeline struct {
+				HistoryMinutes int `json:"historyMinutes"`
+				Points         []struct {
+					Time string `json:"time"`
+				} `json:"points"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&timeline), ShouldBeNil)
+			So(timeline.HistoryMinutes, ShouldEqual, 60)
+			So(len(timeline.Points), ShouldBeGreaterThanOrEqualTo, 2)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/rewind", "application/json", strings.NewReader(`{"minutes": 0}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/rewind", "application/json", strings.NewReader(`{"minutes": 600}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			old := sim
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/rewind", "application/json", strings.NewReader(`{"minutes": 1}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var rewound struct {
+				RewoundTo string `json:"rewoundTo"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&rewound), ShouldBeNil)
+			So(rewound.RewoundTo, ShouldEqual, sim.Options.CurrentTime.Time.Format("15:04:05"))
+			So(sim, ShouldNotEqual, old)
+			So(sim.Options.CurrentTime.Time.Before(old.Options.CurrentTime.Time), ShouldBeTrue)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/rewind_test/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulation/checkpoints/rewind_test", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Fast-forward", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "fastforward_test"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/fastforward", "application/json", strings.NewReader(`{"until": "8h"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			until := sim.Options.CurrentTime.Time.Add(5 * time.Minute).Format("15:04:05")
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/fastforward", "application/json", strings.NewReader(fmt.Sprintf(`{"until": "%s"}`, until)))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var ff struct {
+				CurrentTime string `json:"currentTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&ff), ShouldBeNil)
+			So(ff.CurrentTime, ShouldEqual, until)
+			So(sim.Options.CurrentTime.Time.Format("15:04:05"), ShouldEqual, until)
+			So(sim.IsStarted(), ShouldBeFalse)
+			// In real date mode, times are RFC3339 date times
+			sim.Options.StartDate = "2026-10-17"
+			sim.Options.RealDateTime = true
+			untilDate := sim.DateTimeOf(sim.Options.CurrentTime.Time.Add(time.Minute)).Format(time.RFC3339)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/fastforward", "application/json", strings.NewReader(fmt.Sprintf(`{"until": "%s"}`, untilDate)))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&ff), ShouldBeNil)
+			So(ff.CurrentTime, ShouldEqual, untilDate)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/fastforward_test/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulation/checkpoints/fastforward_test", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Step", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "step_test"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			start := sim.Options.CurrentTime.Time
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/step", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.Options.CurrentTime.Time, ShouldResemble, start.Add(sim.Tick()))
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/step", "application/json", strings.NewReader(`{"seconds": 30}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var st struct {
+				CurrentTime string `json:"currentTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&st), ShouldBeNil)
+			So(st.CurrentTime, ShouldEqual, start.Add(sim.Tick()+30*time.Second).Format("15:04:05"))
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/step", "application/json", strings.NewReader(`{"seconds": -1}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/step_test/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulation/checkpoints/step_test", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Breakpoints", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/breakpoints", "application/json", strings.NewReader(`{"type": "TRAIN_AT_PLACE", "trainId": "0", "placeCode": "XXX"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/breakpoints", "application/json", strings.NewReader(`{"type": "time", "time": "23:00:00"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var bp map[string]interface{}
+			So(json.NewDecoder(res.Body).Decode(&bp), ShouldBeNil)
+			So(bp["type"], ShouldEqual, "TIME")
+			So(bp["time"], ShouldEqual, "23:00:00")
+			So(res.Header.Get("Location"), ShouldEqual, fmt.Sprintf("/api/simulation/breakpoints/%s", bp["id"]))
+			res, err = http.Get("http://127.0.0.1:22222/api/simulation/breakpoints")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Items, ShouldHaveLength, 1)
+			req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://127.0.0.1:22222/api/simulation/breakpoints/%s", bp["id"]), nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Timetable editing", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/services")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Items, ShouldHaveLength, len(sim.Services))
+			res, err = http.Get("http://127.0.0.1:22222/api/services/XXX")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			srv := sim.Services["S003"]
+			arrival := srv.Lines[1].ScheduledArrivalTime.Time.Format("15:04:05")
+			track := srv.Lines[1].TrackCode
+			patch := func(body string) *http.Response {
+				req, _ := http.NewRequest(http.MethodPatch, "http://127.0.0.1:22222/api/services/S003/lines/1", strings.NewReader(body))
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				return res
+			}
+			res = patch(`{"scheduledArrivalTime": "06:05:00", "trackCode": "2"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(srv.Lines[1].ScheduledArrivalTime.Time.Format("15:04:05"), ShouldEqual, "06:05:00")
+			So(srv.Lines[1].TrackCode, ShouldEqual, "2")
+			res = patch(`{"scheduledDepartureTime": "06:00:00"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res = patch(fmt.Sprintf(`{"scheduledArrivalTime": "%s", "trackCode": "%s"}`, arrival, track))
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(srv.Lines[1].TrackCode, ShouldEqual, track)
+			res, err = http.Post("http://127.0.0.1:22222/api/services/S003/lines", "application/json", strings.NewReader(`{"placeCode": "LFT"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(srv.Lines, ShouldHaveLength, 4)
+			So(srv.Lines[3].PlaceCode, ShouldEqual, "LFT")
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/services/S003/lines/3", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(srv.Lines, ShouldHaveLength, 3)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Spawning trains", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "spawn_test"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			count := len(sim.Trains)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains", "application/json", strings.NewReader(`{"trainTypeCode": "UT", "serviceCode": "XXX", "entryPoint": "1"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			appear := sim.Options.CurrentTime.Time.Add(10 * time.Minute).Format("15:04:05")
+			res, err = http.Post("http://127.0.0.1:22222/api/trains", "application/json", strings.NewReader(fmt.Sprintf(`{"trainTypeCode": "UT", "serviceCode": "S001", "entryPoint": "1", "appearTime": "%s"}`, appear)))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			var train struct {
+				ID         string `json:"id"`
+				AppearTime string `json:"appearTime"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&train), ShouldBeNil)
+			So(train.ID, ShouldEqual, fmt.Sprintf("%d", count))
+			So(train.AppearTime, ShouldEqual, appear)
+			res, err = http.Get("http://127.0.0.1:22222/api/trains")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Items, ShouldHaveLength, count+1)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/spawn_test/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(sim.Trains, ShouldHaveLength, count)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulation/checkpoints/spawn_test", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Cancelling trains", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/simulation/checkpoints", "application/json", strings.NewReader(`{"name": "cancel_test"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/1/cancel", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(trainStatusToString(sim.Trains[1].Status), ShouldEqual, "CANCELLED")
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/1/cancel", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusConflict)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/99/cancel", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			res, err = http.Post("http://127.0.0.1:22222/api/services/S001/cancel", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var cancelled struct {
+				CancelledTrains []string `json:"cancelledTrains"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&cancelled), ShouldBeNil)
+			So(cancelled.CancelledTrains, ShouldResemble, []string{"0"})
+			res, err = http.Post("http://127.0.0.1:22222/api/simulation/checkpoints/cancel_test/restore", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(trainStatusToString(sim.Trains[1].Status), ShouldNotEqual, "CANCELLED")
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulation/checkpoints/cancel_test", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Getting eco-driving advisories", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/trains/1/eco")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var eco struct {
+				TrainID     string                 `json:"trainId"`
+				ServiceCode string                 `json:"serviceCode"`
+				Coasting    bool                   `json:"coasting"`
+				Advisory    map[string]interface{} `json:"advisory"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&eco), ShouldBeNil)
+			So(eco.TrainID, ShouldEqual, "1")
+			So(eco.ServiceCode, ShouldEqual, sim.Trains[1].ServiceCode)
+			if _, ok := sim.Trains[1].EcoAdvisory(); !ok {
+				So(eco.Advisory, ShouldBeNil)
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/trains/99/eco")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/trains/1/eco", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+		Convey("Listing connections between services", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/transfers?status=MISSED")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var transfers struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&transfers), ShouldBeNil)
+			// The demo simulation defines no transfers
+			So(transfers.Items, ShouldNotBeNil)
+			So(transfers.Items, ShouldBeEmpty)
+			res, err = http.Post("http://127.0.0.1:22222/api/transfers", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+		Convey("Getting the platform plan of a place", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/places/STN/platforms")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var plan struct {
+				PlaceCode   string                   `json:"placeCode"`
+				Tracks      []map[string]interface{} `json:"tracks"`
+				Allocations []map[string]interface{} `json:"allocations"`
+				Conflicts   []map[string]interface{} `json:"conflicts"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&plan), ShouldBeNil)
+			So(plan.PlaceCode, ShouldEqual, "STN")
+			So(plan.Tracks, ShouldHaveLength, 2)
+			So(plan.Conflicts, ShouldNotBeNil)
+			allocs, _ := sim.PlatformPlan("STN")
+			So(plan.Allocations, ShouldHaveLength, len(allocs))
+			res, err = http.Get("http://127.0.0.1:22222/api/places/XXX/platforms")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			res, err = http.Post("http://127.0.0.1:22222/api/places/STN/platforms", "application/json",
+				strings.NewReader(`{"serviceCode": "S003", "lineIndex": 1, "trackCode": "9"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/places/STN/platforms", "application/json",
+				strings.NewReader(`{"serviceCode": "S003", "lineIndex": 0, "trackCode": "1"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Get("http://127.0.0.1:22222/api/platforms/conflicts")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Trains can be commanded to shunt", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/trains/0/route", "application/json",
+				strings.NewReader(`{"action": "SHUNT"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/0/route", "application/json",
+				strings.NewReader(`{"action": "SHUNT", "newRoute": ["XXX"]}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusConflict)
+			So(sim.Trains[0].IsShunting(), ShouldBeFalse)
+		})
+		Convey("Route conflicts can be listed", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/routes/2/conflicts")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var conflicts struct {
+				RouteID string                   `json:"routeId"`
+				Items   []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&conflicts), ShouldBeNil)
+			So(conflicts.RouteID, ShouldEqual, "2")
+			So(conflicts.Items, ShouldNotBeEmpty)
+			So(conflicts.Items[0]["routeId"], ShouldEqual, "1")
+			So(conflicts.Items[0]["kind"], ShouldEqual, "POINTS")
+			res, err = http.Get("http://127.0.0.1:22222/api/routes/XXX/conflicts")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			res, err = http.Get("http://127.0.0.1:22222/api/routes/2")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Depots can be listed and trains stabled", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/depots")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var depots struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&depots), ShouldBeNil)
+			So(depots.Items, ShouldHaveLength, len(sim.Depots()))
+			res, err = http.Get("http://127.0.0.1:22222/api/depots/XXX")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/0/stabling", "application/json",
+				strings.NewReader(`{"depot": "XXX"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			req, _ := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/trains/0/stabling", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusConflict)
+			res, err = http.Get("http://127.0.0.1:22222/api/trains/0/stabling")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+		Convey("Only trains of circular services can be withdrawn", func() {
+			res, err := http.Post("http://127.0.0.1:22222/api/trains/0/withdraw", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusConflict)
+			res, err = http.Post("http://127.0.0.1:22222/api/trains/99/withdraw", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
 	})
 }
@@ -21,27 +21,64 @@ package server
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ts2/ts2-sim-server/simulation"
 )
 
 // The Hub makes the interface between the Simulation and the websocket clients
+//
+// Each simulation hosted by the server has its own Hub.
 type Hub struct {
+	// id is the ID of the simulation of this hub
+	id string
+
+	// sim is the simulation of this hub
+	sim *simulation.Simulation
+
+	// initialSnapshot holds the snapshot of the simulation taken before its
+	// initialization, so that it can be restarted from its original state.
+	initialSnapshot simulationSnapshot
+
+	// metrics, audits and overview hold the KPIs, the audit log and the
+	// overview changes of the simulation, snapshots its overview cache,
+	// scores its scoring sessions and trainings its training runs, areas the
+	// dispatchers of its control areas and chats the messages of its clients.
+	metrics   *metricsState
+	audits    *auditState
+	overview  *overviewChangeLog
+	snapshots *overviewCache
+	scores    *scoreState
+	trainings *trainingState
+	areas     *areaState
+	chats     *chatState
+
+	// knownSuggestions holds the IDs of the suggestions of the last
+	// suggestionsUpdated event, to detect newly generated suggestions.
+	knownSuggestions map[string]bool
+
 	// Registered client connections
 	clientConnections map[*connection]bool
+	// clientCount is the number of clientConnections, for use outside the
+	// hub loop. It must be accessed atomically.
+	clientCount int32
 
 	// Registry of client listeners
-	registry map[registryEntry]map[*connection]bool
+	registry map[registryEntry]map[*connection]*ListenerFilter
 
 	// registryMutex protects the registry
 	registryMutex sync.RWMutex
 
 	// lastEvents holds the last event sent for each registryEntry
-	lastEvents map[registryEntry]*simulation.Event
+	lastEvents map[registryEntry]*sequencedEvent
 
 	// lastEventsMutex protects the lastEvents map
 	lastEventsMutex sync.RWMutex
 
+	// replay keeps the last broadcast events for resuming clients
+	replay replayBuffer
+
 	// Register requests from the connection
 	registerChan chan *connection
 
@@ -51,11 +88,24 @@ type Hub struct {
 	// Received requests channel
 	readChan chan *connection
 
+	// events receives the events of the simulation, see forward
+	events chan *simulation.Event
+
+	// stopForwarding is closed to stop forwarding the events of the current
+	// simulation to the events channel
+	stopForwarding chan struct{}
+
+	// restartMutex serializes simulation restarts
+	restartMutex sync.Mutex
+
+	// done is closed when the simulation is removed from the server
+	done chan struct{}
+
 	objects map[string]hubObject
 }
 
 type hubObject interface {
-	dispatch(h *Hub, req Request, c *connection)
+	dispatch(h *Hub, req Request, c *connection, ch chan<- interface{})
 }
 
 // run is the loop for handling dispatching requests and responses
@@ -69,13 +119,28 @@ func (h *Hub) run(hubUp chan bool) {
 	)
 	for {
 		select {
-		case e = <-sim.EventChan:
-			logger.Debug("Received event from simulation", "submodule", "hub", "event", e.Name, "object", e.Object)
+		case e = <-h.events:
+			start := time.Now()
+			logger.Debug("Received event from simulation", "submodule", "hub", "simulation", h.id, "event", e.Name, "object", e.Object)
 			// Update KPI metrics from events
-			updateMetrics(e)
+			h.updateMetrics(e)
+			h.updateScore(e)
+			if te := h.updateTraining(e); te != nil {
+				h.notifyClients(te)
+			}
 			// Record audit entry for FE consumers
-			recordAuditFromEvent(e)
+			h.recordAuditFromEvent(e)
+			h.publishMQTT(e)
+			h.sendGeneratedSuggestions(e)
+			if e.Name == SimulationRestartedEvent {
+				h.notifyRestart(e)
+				timings.since(operationHubEvent, start)
+				continue
+			}
+			// Keep track of changed objects for overview deltas
+			h.overview.record(e)
 			h.notifyClients(e)
+			timings.since(operationHubEvent, start)
 		case c = <-h.readChan:
 			logger.Debug("Reading request from client", "submodule", "hub", "data", c.Requests[0])
 			go h.dispatchObject(c)
@@ -85,6 +150,12 @@ func (h *Hub) run(hubUp chan bool) {
 		case c = <-h.unregisterChan:
 			logger.Info("Unregistering connection", "submodule", "hub", "connection", c.RemoteAddr())
 			h.unregister(c)
+		case <-h.done:
+			logger.Info("Hub stopping...", "submodule", "hub", "simulation", h.id)
+			for c := range h.clientConnections {
+				_ = c.Conn.Close()
+			}
+			return
 		}
 	}
 }
@@ -92,20 +163,27 @@ func (h *Hub) run(hubUp chan bool) {
 // register registers the given connection to this hub
 func (h *Hub) register(c *connection) {
 	switch c.clientType {
-	case Client:
+	case Client, Observer:
 		h.clientConnections[c] = true
+		atomic.StoreInt32(&h.clientCount, int32(len(h.clientConnections)))
 	}
 }
 
 // addConnectionToRegistry adds this connection to the registry for eventName and id.
-func (h *Hub) addConnectionToRegistry(conn *connection, eventName simulation.EventName, id string) {
+//
+// If filter is not nil, only events matching the filter will be sent to this
+// connection. Adding the same entry again replaces the previous filter.
+func (h *Hub) addConnectionToRegistry(conn *connection, eventName simulation.EventName, id string, filter *ListenerFilter) {
 	h.registryMutex.Lock()
 	defer h.registryMutex.Unlock()
 	re := registryEntry{eventName: eventName, id: id}
 	if _, ok := h.registry[re]; !ok {
-		h.registry[re] = make(map[*connection]bool)
+		h.registry[re] = make(map[*connection]*ListenerFilter)
 	}
-	h.registry[re][conn] = true
+	if filter.isEmpty() {
+		filter = nil
+	}
+	h.registry[re][conn] = filter
 }
 
 // removeEntryFromRegistry removes this connection from the registry for eventName and id.
@@ -114,6 +192,9 @@ func (h *Hub) removeEntryFromRegistry(conn *connection, eventName simulation.Eve
 	defer h.registryMutex.Unlock()
 	re := registryEntry{eventName: eventName, id: id}
 	delete(h.registry[re], conn)
+	if len(h.registry[re]) == 0 {
+		delete(h.registry, re)
+	}
 }
 
 // removeConnectionFromRegistry removes all entries of this connection in the registry.
@@ -121,19 +202,33 @@ func (h *Hub) removeConnectionFromRegistry(conn *connection) {
 	h.registryMutex.Lock()
 	defer h.registryMutex.Unlock()
 	for re, rv := range h.registry {
-		if _, ok := rv[conn]; ok {
+		delete(rv, conn)
+		if len(rv) == 0 {
 			delete(h.registry, re)
 		}
 	}
 }
 
+// listenerCount returns the number of listeners registered on this hub, all
+// connections included.
+func (h *Hub) listenerCount() int {
+	h.registryMutex.RLock()
+	defer h.registryMutex.RUnlock()
+	var count int
+	for _, rv := range h.registry {
+		count += len(rv)
+	}
+	return count
+}
+
 // unregister unregisters the connection to this hub
 func (h *Hub) unregister(c *connection) {
 	switch c.clientType {
-	case Client:
+	case Client, Observer:
 		if _, ok := h.clientConnections[c]; ok {
 			delete(h.clientConnections, c)
 		}
+		atomic.StoreInt32(&h.clientCount, int32(len(h.clientConnections)))
 		h.removeConnectionFromRegistry(c)
 	}
 }
@@ -141,34 +236,64 @@ func (h *Hub) unregister(c *connection) {
 // notifyClients sends the given event to all registered clients.
 func (h *Hub) notifyClients(e *simulation.Event) {
 	logger.Debug("Notifying clients", "submodule", "hub", "event", e)
-	h.updateLastEvents(e)
+	se := h.replay.record(h.newSequencedEvent(e))
+	h.updateLastEvents(se)
+	h.streamKafkaEvent(se)
+	// The same notification is sent to all listeners, so that it is encoded
+	// only once.
+	n := newBroadcastNotification(se)
+	// Suggestions are only sent to the dispatchers that can accept them, and
+	// private messages to their sender and recipient.
+	routing := h.newSuggestionRouting(se)
 	h.registryMutex.RLock()
 	defer h.registryMutex.RUnlock()
 	// Notify clients that subscribed to all objects
-	for conn := range h.registry[registryEntry{eventName: e.Name, id: ""}] {
-		conn.pushChan <- NewNotificationResponse(e)
+	for conn, filter := range h.registry[registryEntry{eventName: se.name, id: ""}] {
+		if filter.matches(h.sim, se.subject) && se.private.visibleTo(conn.user) {
+			conn.pushChan <- routing.notification(conn, n)
+		}
 	}
-	if e.Object.ID() == "" {
+	if se.objectID == "" {
 		// Object has no ID. Don't send twice
 		return
 	}
 	// Notify clients that subscribed to specific object IDs
-	for conn := range h.registry[registryEntry{eventName: e.Name, id: e.Object.ID()}] {
-		conn.pushChan <- NewNotificationResponse(e)
+	for conn, filter := range h.registry[registryEntry{eventName: se.name, id: se.objectID}] {
+		if filter.matches(h.sim, se.subject) && se.private.visibleTo(conn.user) {
+			conn.pushChan <- n
+		}
+	}
+}
+
+// notifyRestart sends the given SimulationRestartedEvent to all clients,
+// whatever their listeners. The last events, which are about the objects of
+// the previous simulation, are discarded so that they are not renotified.
+//
+// notifyRestart must be called from the hub loop.
+func (h *Hub) notifyRestart(e *simulation.Event) {
+	h.lastEventsMutex.Lock()
+	h.lastEvents = make(map[registryEntry]*sequencedEvent)
+	h.lastEventsMutex.Unlock()
+	se := h.replay.record(h.newSequencedEvent(e))
+	h.streamKafkaEvent(se)
+	n := newBroadcastNotification(se)
+	for conn := range h.clientConnections {
+		conn.pushChan <- n
 	}
 }
 
 // updateLastEvents updates the lastEvents map in a concurrently safe way
-func (h *Hub) updateLastEvents(e *simulation.Event) {
+func (h *Hub) updateLastEvents(se *sequencedEvent) {
+	if se.name == ChatMessageEvent {
+		// Messages are not renotified, clients get them with chat/history
+		return
+	}
 	h.lastEventsMutex.Lock()
 	defer h.lastEventsMutex.Unlock()
-	h.lastEvents[registryEntry{eventName: e.Name, id: e.Object.ID()}] = e
+	h.lastEvents[registryEntry{eventName: se.name, id: se.objectID}] = se
 }
 
-// dispatchObject process a request.
-//
-// - req is the request to process
-// - ch is the channel on which to send the response
+// dispatchObject processes the first pending request of conn.
 func (h *Hub) dispatchObject(conn *connection) {
 	req := conn.Requests[0]
 	conn.Requests = conn.Requests[1:]
@@ -178,25 +303,224 @@ func (h *Hub) dispatchObject(conn *connection) {
 		logger.Debug("Request for unknown object received", "submodule", "hub", "object", req.Object)
 		return
 	}
-	obj.dispatch(h, req, conn)
+	if denied := h.authorizeRequest(conn, req, conn.RemoteAddr().String()); denied != nil {
+		conn.pushChan <- denied
+		return
+	}
+	h.runRequest(conn, obj, req)
+}
+
+// authorizeRequest checks that the client of conn, at the given remote
+// address, may send req: its role must allow the action, and operators may
+// only act in their control areas. It records the denial and returns the
+// response to send if not, and nil otherwise.
+func (h *Hub) authorizeRequest(conn *connection, req Request, remote string) *ResponseStatus {
+	// Observer connections always have RoleObserver, so that this check
+	// also keeps them read-only.
+	if !conn.role.allows(req.Object, req.Action) {
+		logger.Info("Permission denied", "submodule", "hub", "connection", remote, "role", conn.role, "object", req.Object, "action", req.Action)
+		h.audits.append(AuditEntry{
+			Event:    "PERMISSION_DENIED",
+			Category: "security",
+			Severity: "WARNING",
+			Object:   map[string]interface{}{"id": req.Object, "type": "hub"},
+			Details: map[string]interface{}{
+				"action":     req.Action,
+				"role":       string(conn.role),
+				"clientType": string(conn.clientType),
+				"remote":     remote,
+			},
+		})
+		return NewPermissionDeniedResponse(req.ID, conn.role, req)
+	}
+	if areaID := h.deniedControlArea(conn, req); areaID != "" {
+		dispatcher := h.areaDispatcher(areaID)
+		logger.Info("Control area denied", "submodule", "hub", "connection", remote, "user", conn.user, "area", areaID, "object", req.Object, "action", req.Action)
+		h.audits.append(AuditEntry{
+			Event:    "CONTROL_AREA_DENIED",
+			Category: "security",
+			Severity: "WARNING",
+			Object:   map[string]interface{}{"id": areaID, "type": "controlArea"},
+			Details: map[string]interface{}{
+				"object":     req.Object,
+				"action":     req.Action,
+				"user":       conn.user,
+				"dispatcher": dispatcher,
+				"remote":     remote,
+			},
+		})
+		return NewControlAreaDeniedResponse(req.ID, areaID, dispatcher, req)
+	}
+	return nil
+}
+
+// restartSimulation replaces the simulation of this hub by a fresh one built
+// from its initial snapshot. The new simulation is not started.
+//
+// Once the new simulation is in place, a SimulationRestartedEvent is sent to
+// all clients so that they reload their state.
+func (h *Hub) restartSimulation() error {
+	h.restartMutex.Lock()
+	defer h.restartMutex.Unlock()
+	if h.initialSnapshot == nil {
+		return fmt.Errorf("initial snapshot unavailable")
+	}
+	// Pause current loop if running
+	if h.sim.IsStarted() {
+		h.sim.Pause()
+	}
+	// Rebuild a fresh Simulation from the initial snapshot
+	var fresh simulation.Simulation
+	if err := h.initialSnapshot.restore(&fresh); err != nil {
+		return fmt.Errorf("failed to rebuild simulation: %s", err)
+	}
+	// The events sent during initialization are dropped since clients reload
+	// everything after the restart.
+	initialized := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-fresh.EventChan:
+			case <-initialized:
+				return
+			}
+		}
+	}()
+	err := fresh.Initialize()
+	close(initialized)
+	if err != nil {
+		return fmt.Errorf("failed to initialize simulation: %s", err)
+	}
+	h.replaceSimulation(&fresh, simulationRestarted{})
+	return nil
+}
+
+// restoreCheckpoint replaces the simulation of this hub by the one saved in
+// the given checkpoint. The simulation is paused and clients are notified as
+// for a restart.
+func (h *Hub) restoreCheckpoint(name string) error {
+	h.restartMutex.Lock()
+	defer h.restartMutex.Unlock()
+	data, err := readCheckpoint(h.id, name)
+	if err != nil {
+		return err
+	}
+	if h.sim.IsStarted() {
+		h.sim.Pause()
+	}
+	fresh, err := simulation.RestoreCheckpoint(data)
+	if err != nil {
+		return fmt.Errorf("failed to restore checkpoint %s: %s", name, err)
+	}
+	h.replaceSimulation(fresh, simulationRestarted{Checkpoint: name})
+	return nil
+}
+
+// rewindSimulation replaces the simulation of this hub by its state d ago,
+// rounded down to the previous rewind point, and returns the simulation time
+// it was rewound to. The simulation is paused and clients are notified as for
+// a restart.
+func (h *Hub) rewindSimulation(d time.Duration) (time.Time, error) {
+	h.restartMutex.Lock()
+	defer h.restartMutex.Unlock()
+	if h.sim.IsStarted() {
+		h.sim.Pause()
+	}
+	fresh, err := h.sim.Rewind(d)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rewoundTo := fresh.Options.CurrentTime.Time
+	h.replaceSimulation(fresh, simulationRestarted{RewoundTo: fresh.FormatTime(rewoundTo)})
+	return rewoundTo, nil
+}
+
+// replaceSimulation swaps the simulation of this hub with s, releases the
+// state held for the old one and tells clients to reload everything with a
+// SimulationRestartedEvent with the given object.
+func (h *Hub) replaceSimulation(s *simulation.Simulation, sr simulationRestarted) {
+	reason := sessionEndRestarted
+	switch {
+	case sr.Checkpoint != "":
+		reason = sessionEndRestored
+	case sr.RewoundTo != "":
+		reason = sessionEndRewound
+	}
+	h.stopTraining(trainingEndAborted)
+	h.endScoringSession(reason)
+	old := h.sim
+	h.setSimulation(s)
+	old.Close()
+	// Clients polling overview deltas must reload everything
+	h.overview.reset()
+	sr.SimulationID = h.id
+	h.events <- &simulation.Event{Name: SimulationRestartedEvent, Object: sr}
+}
+
+// setSimulation sets s as the simulation of this hub and forwards its events
+// to the hub instead of those of the previous simulation.
+func (h *Hub) setSimulation(s *simulation.Simulation) {
+	h.sim = s
+	if h == hub {
+		sim = s
+	}
+	h.forward(s)
+}
+
+// forward starts forwarding the events of s to the events channel of the
+// hub, and stops forwarding the events of the previous simulation.
+func (h *Hub) forward(s *simulation.Simulation) {
+	if h.stopForwarding != nil {
+		close(h.stopForwarding)
+	}
+	stop := make(chan struct{})
+	h.stopForwarding = stop
+	go func() {
+		for {
+			select {
+			case e := <-s.EventChan:
+				select {
+				case h.events <- e:
+				case <-h.done:
+					return
+				}
+			case <-stop:
+				return
+			case <-h.done:
+				return
+			}
+		}
+	}()
 }
 
-// newHub returns a pointer to a new Hub instance
-func newHub() *Hub {
+// newHub returns a pointer to a new Hub instance for the simulation with the given ID
+func newHub(id string) *Hub {
 	h := new(Hub)
+	h.id = id
 	// make connection maps
 	h.clientConnections = make(map[*connection]bool)
 	// make registry map
-	h.registry = make(map[registryEntry]map[*connection]bool)
-	h.lastEvents = make(map[registryEntry]*simulation.Event)
+	h.registry = make(map[registryEntry]map[*connection]*ListenerFilter)
+	h.lastEvents = make(map[registryEntry]*sequencedEvent)
 	// make channels
 	h.registerChan = make(chan *connection)
 	h.unregisterChan = make(chan *connection)
 	h.readChan = make(chan *connection)
+	h.events = make(chan *simulation.Event)
+	h.done = make(chan struct{})
 	h.objects = make(map[string]hubObject)
 	return h
 }
 
 func init() {
-	hub = newHub()
+	hub = newHub(DefaultSimulationID)
+	hub.metrics = metrics
+	hub.audits = audits
+	hub.overview = overviewChanges
+	hub.snapshots = overviewSnapshots
+	hub.scores = scores
+	hub.trainings = trainings
+	hub.areas = areas
+	hub.chats = chats
+	simulations.hubs[DefaultSimulationID] = hub
 }
@@ -0,0 +1,83 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type breakpointObject struct{}
+
+// dispatch processes requests made on the Breakpoint object
+func (b *breakpointObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for breakpoint list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		bl, err := json.Marshal(h.sim.Breakpoints())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, bl)
+	case "create":
+		var br breakpointRequest
+		err := json.Unmarshal(req.Params, &br)
+		logger.Debug("Request for breakpoint create received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", br)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		bp, err := addBreakpoint(h.sim, br)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while creating breakpoint: %s", err))
+			return
+		}
+		bd, err := json.Marshal(bp)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, bd)
+	case "clear":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for breakpoint clear received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.RemoveBreakpoint(idParams.ID); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Breakpoint %s cleared successfully", idParams.ID))
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+var _ hubObject = new(breakpointObject)
+
+func init() {
+	hub.objects["breakpoint"] = new(breakpointObject)
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type controlAreaObject struct{}
+
+// dispatch processes requests made on the controlArea object
+func (c *controlAreaObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	logger.Debug("Request for controlArea received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	var params = struct {
+		ID         string `json:"id"`
+		Dispatcher string `json:"dispatcher"`
+	}{}
+	var res interface{}
+	switch req.Action {
+	case "list":
+		res = h.controlAreaViews()
+	case "show", "assign", "release":
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ca := h.sim.ControlArea(params.ID)
+		if ca == nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown control area: %s", params.ID))
+			return
+		}
+		res = h.controlAreaView(ca)
+		if req.Action == "show" {
+			break
+		}
+		if req.Action == "release" {
+			params.Dispatcher = ""
+		}
+		view, err := h.assignControlArea(params.ID, params.Dispatcher, conn.user)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		res = view
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		return
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+		return
+	}
+	ch <- NewResponse(req.ID, data)
+}
+
+var _ hubObject = new(controlAreaObject)
+
+func init() {
+	hub.objects["controlArea"] = new(controlAreaObject)
+}
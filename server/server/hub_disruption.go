@@ -0,0 +1,110 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+type disruptionObject struct{}
+
+// dispatch processes requests made on the Disruption object
+func (s *disruptionObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for disruption list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		dl, err := json.Marshal(h.sim.Disruptions())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dl)
+	case "show":
+		var idsParams = struct {
+			IDs []string `json:"ids"`
+		}{}
+		err := json.Unmarshal(req.Params, &idsParams)
+		logger.Debug("Request for disruption show received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idsParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		dis := make(map[string]*simulation.Disruption)
+		for _, id := range idsParams.IDs {
+			d, ok := h.sim.GetDisruption(id)
+			if !ok {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown disruption: %s", id))
+				return
+			}
+			dis[id] = d
+		}
+		dd, err := json.Marshal(dis)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dd)
+	case "create":
+		var dr disruptionRequest
+		err := json.Unmarshal(req.Params, &dr)
+		logger.Debug("Request for disruption create received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", dr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		d, err := injectDisruption(h.sim, dr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while injecting disruption: %s", err))
+			return
+		}
+		dd, err := json.Marshal(d)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dd)
+	case "clear":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for disruption clear received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.RemoveDisruption(idParams.ID); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Disruption %s cleared successfully", idParams.ID))
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+var _ hubObject = new(disruptionObject)
+
+func init() {
+	hub.objects["disruption"] = new(disruptionObject)
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type messageObject struct{}
+
+// dispatch processes requests made on the message object
+func (m *messageObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for message list received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", req.Params)
+		var mq messagesQuery
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &mq); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("unparsable request: %s (%s)", err, req.Params))
+				return
+			}
+		}
+		page, err := mq.messages(h.sim)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		data, err := json.Marshal(page)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, data)
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+var _ hubObject = new(messageObject)
+
+func init() {
+	hub.objects["message"] = new(messageObject)
+}
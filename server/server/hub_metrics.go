@@ -0,0 +1,95 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// MetricsUpdatedEvent is sent to the clients that subscribed to the metrics
+// object each time a new KPI snapshot is taken.
+const MetricsUpdatedEvent simulation.EventName = "metricsUpdated"
+
+// metricsReport is a KPI report sent as the object of a MetricsUpdatedEvent.
+type metricsReport map[string]interface{}
+
+// ID returns an empty string since there is only one report
+func (mr metricsReport) ID() string {
+	return ""
+}
+
+type metricsObject struct{}
+
+// dispatch processes requests made on the metrics object
+func (m *metricsObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	logger.Debug("Request for metrics received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	var params struct {
+		TimeRange string `json:"timeRange"`
+		Metric    string `json:"metric"`
+		Period    string `json:"period"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unparsable request: %s (%s)", err, req.Params))
+			return
+		}
+	}
+	switch req.Action {
+	case "current":
+		m.respond(req, ch, h.metrics.kpiReport(params.TimeRange))
+	case "historical":
+		m.respond(req, ch, h.metrics.kpiHistory(params.Metric, params.Period))
+	case "subscribe":
+		h.addConnectionToRegistry(conn, MetricsUpdatedEvent, "", nil)
+		ch <- NewOkResponse(req.ID, "Subscribed to metrics updates")
+	case "unsubscribe":
+		h.removeEntryFromRegistry(conn, MetricsUpdatedEvent, "")
+		ch <- NewOkResponse(req.ID, "Unsubscribed from metrics updates")
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+// respond sends the given report to the client
+func (m *metricsObject) respond(req Request, ch chan<- interface{}, report map[string]interface{}) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+		return
+	}
+	ch <- NewResponse(req.ID, data)
+}
+
+// notifyMetricsSubscribers sends the current KPIs to the clients that
+// subscribed to the metrics object.
+func (h *Hub) notifyMetricsSubscribers() {
+	e := &simulation.Event{Name: MetricsUpdatedEvent, Object: metricsReport(h.metrics.kpiReport(""))}
+	h.notifyClients(e)
+	h.publishMQTT(e)
+}
+
+var _ hubObject = new(metricsObject)
+
+func init() {
+	hub.objects["metrics"] = new(metricsObject)
+}
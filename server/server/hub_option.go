@@ -26,12 +26,11 @@ import (
 type optionObject struct{}
 
 // dispatch processes requests made on the Option object
-func (s *optionObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *optionObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for option list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		opts, err := json.Marshal(sim.Options)
+		opts, err := json.Marshal(h.sim.Options)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -48,7 +47,7 @@ func (s *optionObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("error on parameters: %s", err))
 			return
 		}
-		err = sim.Options.Set(setParams.Name, setParams.Value)
+		err = h.sim.Options.Set(setParams.Name, setParams.Value)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while setting option: %s", err))
 			return
@@ -0,0 +1,64 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+type perturbationObject struct{}
+
+// dispatch processes requests made on the Perturbation object
+func (s *perturbationObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	switch req.Action {
+	case "show":
+		logger.Debug("Request for perturbation show received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		pd, err := json.Marshal(perturbationsReport(h.sim))
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, pd)
+	case "set":
+		var p simulation.Perturbations
+		err := json.Unmarshal(req.Params, &p)
+		logger.Debug("Request for perturbation set received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", req.Params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.SetPerturbations(p); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while setting perturbations: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "Perturbations set successfully")
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+var _ hubObject = new(perturbationObject)
+
+func init() {
+	hub.objects["perturbation"] = new(perturbationObject)
+}
@@ -28,12 +28,11 @@ import (
 type placeObject struct{}
 
 // dispatch processes requests made on the Place object
-func (s *placeObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *placeObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for place list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		til, err := json.Marshal(sim.Places)
+		til, err := json.Marshal(h.sim.Places)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +50,7 @@ func (s *placeObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		tkis := make(map[string]*simulation.Place)
 		for _, id := range idsParams.IDs {
-			tsID, ok := sim.Places[id]
+			tsID, ok := h.sim.Places[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown place: %s", id))
 				return
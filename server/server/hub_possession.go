@@ -0,0 +1,110 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+type possessionObject struct{}
+
+// dispatch processes requests made on the Possession object
+func (s *possessionObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for possession list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		dl, err := json.Marshal(h.sim.Possessions())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dl)
+	case "show":
+		var idsParams = struct {
+			IDs []string `json:"ids"`
+		}{}
+		err := json.Unmarshal(req.Params, &idsParams)
+		logger.Debug("Request for possession show received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idsParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ps := make(map[string]*simulation.Possession)
+		for _, id := range idsParams.IDs {
+			p, ok := h.sim.GetPossession(id)
+			if !ok {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown possession: %s", id))
+				return
+			}
+			ps[id] = p
+		}
+		dd, err := json.Marshal(ps)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dd)
+	case "create":
+		var pr possessionRequest
+		err := json.Unmarshal(req.Params, &pr)
+		logger.Debug("Request for possession create received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", pr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		p, err := planPossession(h.sim, pr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while planning possession: %s", err))
+			return
+		}
+		dd, err := json.Marshal(p)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dd)
+	case "clear":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for possession clear received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.RemovePossession(idParams.ID); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Possession %s given back successfully", idParams.ID))
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+var _ hubObject = new(possessionObject)
+
+func init() {
+	hub.objects["possession"] = new(possessionObject)
+}
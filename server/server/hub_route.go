@@ -21,6 +21,7 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/ts2/ts2-sim-server/simulation"
 )
@@ -28,12 +29,11 @@ import (
 type routeObject struct{}
 
 // dispatch processes requests made on the route object
-func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (r *routeObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for route list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		rtes, err := json.Marshal(sim.Routes)
+		rtes, err := json.Marshal(h.sim.Routes)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +51,7 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		rtes := make(map[string]*simulation.Route)
 		for _, id := range idsParams.IDs {
-			rte, ok := sim.Routes[id]
+			rte, ok := h.sim.Routes[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown route: %s", id))
 				return
@@ -75,7 +75,7 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		rte, ok := sim.Routes[actParams.ID]
+		rte, ok := h.sim.Routes[actParams.ID]
 		if !ok {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown route: %s", actParams.ID))
 			return
@@ -86,6 +86,25 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 			return
 		}
 		ch <- NewOkResponse(req.ID, fmt.Sprintf("Route %s activated successfully", actParams.ID))
+	case "setThrough":
+		var thParams = struct {
+			Begin      string   `json:"begin"`
+			End        string   `json:"end"`
+			Via        []string `json:"via"`
+			Persistent bool     `json:"persistent"`
+		}{}
+		err := json.Unmarshal(req.Params, &thParams)
+		logger.Debug("Request for route setThrough received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", thParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		path, err := h.sim.SetThroughRoute(thParams.Begin, thParams.End, thParams.Persistent, thParams.Via...)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("cannot set through route from %s to %s: %s", thParams.Begin, thParams.End, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Routes %s set from signal %s to signal %s", strings.Join(path.RouteIDs(), ", "), thParams.Begin, thParams.End))
 	case "deactivate":
 		var idParams = struct {
 			ID string `json:"id"`
@@ -96,7 +115,7 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		rte, ok := sim.Routes[idParams.ID]
+		rte, ok := h.sim.Routes[idParams.ID]
 		if !ok {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown route: %s", idParams.ID))
 			return
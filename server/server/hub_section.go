@@ -0,0 +1,81 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+type sectionObject struct{}
+
+// dispatch processes requests made on the Section object
+func (s *sectionObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for section list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		var views []map[string]interface{}
+		for _, sec := range h.sim.Sections() {
+			views = append(views, sectionView(sec))
+		}
+		sl, err := json.Marshal(views)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, sl)
+	case "setDirection":
+		var dirParams = struct {
+			ID        string                         `json:"id"`
+			Direction simulation.SingleLineDirection `json:"direction"`
+		}{}
+		err := json.Unmarshal(req.Params, &dirParams)
+		logger.Debug("Request for section setDirection received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", dirParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		sec, ok := h.sim.Section(dirParams.ID)
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown section: %s", dirParams.ID))
+			return
+		}
+		if err = sec.SetDirection(dirParams.Direction); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while changing the direction of section %s: %s", dirParams.ID, err))
+			return
+		}
+		sd, err := json.Marshal(sectionView(sec))
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, sd)
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+var _ hubObject = new(sectionObject)
+
+func init() {
+	hub.objects["section"] = new(sectionObject)
+}
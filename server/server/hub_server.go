@@ -26,8 +26,7 @@ import (
 type serverObject struct{}
 
 // dispatch processes requests made on the Server object
-func (s *serverObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *serverObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "register":
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("can't call register when already registered"))
@@ -53,6 +52,14 @@ func (s *serverObject) dispatch(h *Hub, req Request, conn *connection) {
 			return
 		}
 		ch <- NewOkResponse(req.ID, "Renotify request taken into account")
+	case "resume":
+		logger.Debug("Request for resume received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", req.Params)
+		count, err := h.resumeClient(req, conn)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("%d missed notifications sent", count))
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", req.Params)
@@ -66,15 +73,18 @@ func (h *Hub) addRegistryEntry(req Request, conn *connection) error {
 		logger.Error("Unparsable request (addRegistryEntry)", "submodule", "hub", "error", err, "request", req)
 		return fmt.Errorf("unparsable request: %s (%s)", err, req.Params)
 	}
+	if err := pl.Filter.validate(h.sim); err != nil {
+		return err
+	}
 	if len(pl.IDs) == 0 {
-		h.addConnectionToRegistry(conn, pl.Event, "")
-		logger.Debug("Registry entry added", "submodule", "hub", "eventName", pl.Event)
+		h.addConnectionToRegistry(conn, pl.Event, "", pl.Filter)
+		logger.Debug("Registry entry added", "submodule", "hub", "eventName", pl.Event, "filter", pl.Filter)
 		return nil
 	}
 	for _, id := range pl.IDs {
-		h.addConnectionToRegistry(conn, pl.Event, id)
+		h.addConnectionToRegistry(conn, pl.Event, id, pl.Filter)
 	}
-	logger.Debug("Registry entries added", "submodule", "hub", "eventName", pl.Event, "ids", pl.IDs)
+	logger.Debug("Registry entries added", "submodule", "hub", "eventName", pl.Event, "ids", pl.IDs, "filter", pl.Filter)
 	return nil
 }
 
@@ -103,20 +113,18 @@ func (h *Hub) renotifyClient(req Request, conn *connection) error {
 	defer h.lastEventsMutex.RUnlock()
 	h.registryMutex.RLock()
 	defer h.registryMutex.RUnlock()
-	for re, event := range h.lastEvents {
-		if _, ok := h.registry[registryEntry{eventName: re.eventName, id: ""}]; ok {
-			if h.registry[registryEntry{eventName: event.Name, id: ""}][conn] {
-				conn.pushChan <- NewNotificationResponse(event)
-			}
+	for re, se := range h.lastEvents {
+		// Renotified events have no sequence number since they are not new
+		n := &ResponseNotification{MsgType: TypeNotification, Data: DataEvent{Name: se.name, Object: se.object}}
+		if filter, ok := h.registry[registryEntry{eventName: se.name, id: ""}][conn]; ok && filter.matches(h.sim, se.subject) {
+			conn.pushChan <- n
 		}
-		if event.Object.ID() == "" {
+		if se.objectID == "" {
 			// Object has no ID. Don't send twice
 			continue
 		}
-		if _, ok := h.registry[re]; ok {
-			if h.registry[registryEntry{eventName: event.Name, id: event.Object.ID()}][conn] {
-				conn.pushChan <- NewNotificationResponse(event)
-			}
+		if filter, ok := h.registry[re][conn]; ok && filter.matches(h.sim, se.subject) {
+			conn.pushChan <- n
 		}
 	}
 	return nil
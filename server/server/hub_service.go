@@ -28,12 +28,11 @@ import (
 type serviceObject struct{}
 
 // dispatch processes requests made on the Service object
-func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for service list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		sl, err := json.Marshal(sim.Services)
+		sl, err := json.Marshal(h.sim.Services)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +50,7 @@ func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		sl := make(map[string]*simulation.Service)
 		for _, id := range idsParams.IDs {
-			sld, ok := sim.Services[id]
+			sld, ok := h.sim.Services[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown service: %s", id))
 				return
@@ -64,6 +63,88 @@ func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection) {
 			return
 		}
 		ch <- NewResponse(req.ID, tid)
+	case "addLine":
+		var params struct {
+			ID string `json:"id"`
+			serviceLineRequest
+		}
+		err := json.Unmarshal(req.Params, &params)
+		logger.Debug("Request for service addLine received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", &params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = insertServiceLine(h.sim, params.ID, &params.serviceLineRequest); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while adding service line: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Line added to service %s successfully", params.ID))
+	case "updateLine":
+		var params struct {
+			ID    string `json:"id"`
+			Index int    `json:"index"`
+			simulation.ServiceLineChange
+		}
+		err := json.Unmarshal(req.Params, &params)
+		logger.Debug("Request for service updateLine received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.UpdateServiceLine(params.ID, params.Index, params.ServiceLineChange); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while updating service line: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Line %d of service %s updated successfully", params.Index, params.ID))
+	case "removeLine":
+		var params struct {
+			ID    string `json:"id"`
+			Index int    `json:"index"`
+		}
+		err := json.Unmarshal(req.Params, &params)
+		logger.Debug("Request for service removeLine received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.RemoveServiceLine(params.ID, params.Index); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while removing service line: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Line %d of service %s removed successfully", params.Index, params.ID))
+	case "assignPlatform":
+		var params struct {
+			ID        string `json:"id"`
+			Index     int    `json:"index"`
+			TrackCode string `json:"trackCode"`
+		}
+		err := json.Unmarshal(req.Params, &params)
+		logger.Debug("Request for service assignPlatform received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.AssignPlatform(params.ID, params.Index, params.TrackCode); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while assigning platform: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Service %s assigned to platform %s successfully", params.ID, params.TrackCode))
+	case "cancel":
+		var params struct {
+			ID string `json:"id"`
+		}
+		err := json.Unmarshal(req.Params, &params)
+		logger.Debug("Request for service cancel received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		trains, err := h.sim.CancelService(params.ID)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while cancelling service: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Service %s cancelled, %d train(s) removed", params.ID, len(trains)))
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
@@ -21,61 +21,46 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	
+
 	"github.com/ts2/ts2-sim-server/simulation"
 )
 
+// SimulationRestartedEvent is sent to all clients when the simulation is
+// restarted, restored from a checkpoint or rewound. Clients must then reload the state
+// of the objects they display, since all of them have been reset.
+const SimulationRestartedEvent simulation.EventName = "simulationRestarted"
+
+// simulationRestarted is the object of a SimulationRestartedEvent
+type simulationRestarted struct {
+	SimulationID string `json:"simulationId"`
+	Checkpoint   string `json:"checkpoint,omitempty"`
+	RewoundTo    string `json:"rewoundTo,omitempty"`
+}
+
+// ID returns an empty string since the event is about the whole simulation
+func (sr simulationRestarted) ID() string {
+	return ""
+}
+
 type simulationObject struct{}
 
 // dispatch processes requests made on the Simulation object
-func (s *simulationObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *simulationObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	logger.Debug("Request for simulation received", "submodule", "hub", "object", req.Object, "action", req.Action)
 	switch req.Action {
 	case "start":
-		sim.Start()
+		h.sim.Start()
 		ch <- NewOkResponse(req.ID, "Simulation started successfully")
 	case "pause":
-		sim.Pause()
+		h.sim.Pause()
 		ch <- NewOkResponse(req.ID, "Simulation paused successfully")
 	case "restart":
 		// Restart simulation to initial state (similar to HTTP API restart)
-		if sim == nil {
-			ch <- NewErrorResponse(req.ID, fmt.Errorf("simulation not initialized"))
-			return
-		}
-		if initialSimSnapshot == nil {
-			ch <- NewErrorResponse(req.ID, fmt.Errorf("initial snapshot unavailable"))
-			return
-		}
-		
-		// Pause current loop if running
-		if sim.IsStarted() {
-			sim.Pause()
-		}
-		
-		// Rebuild a fresh Simulation from the initial snapshot
-		var fresh simulation.Simulation
-		if err := json.Unmarshal(initialSimSnapshot, &fresh); err != nil {
-			ch <- NewErrorResponse(req.ID, fmt.Errorf("failed to rebuild simulation: %s", err))
+		if err := h.restartSimulation(); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
 			return
 		}
-		
-		// Initialize and swap
-		if err := fresh.Initialize(); err != nil {
-			ch <- NewErrorResponse(req.ID, fmt.Errorf("failed to initialize simulation: %s", err))
-			return
-		}
-		
-		// Swap global pointer
-		sim = &fresh
-		
-		// Rebind suggestion engine
-		simulation.ResetSuggestionEngine(sim)
-		if sim.Options.SuggestionsEnabled {
-			simulation.RecomputeSuggestions()
-		}
-		
+
 		// Check if auto-start is requested in params
 		autoStart := false
 		if req.Params != nil {
@@ -93,25 +78,115 @@ func (s *simulationObject) dispatch(h *Hub, req Request, conn *connection) {
 		
 		// Optionally auto-start if requested
 		if autoStart {
-			sim.Start()
+			h.sim.Start()
 			ch <- NewOkResponse(req.ID, "Simulation restarted and started successfully")
 		} else {
 			ch <- NewOkResponse(req.ID, "Simulation restarted successfully")
 		}
-	case "isStarted":
-		j, err := json.Marshal(sim.IsStarted())
+	case "checkpoint":
+		var params struct {
+			Name string `json:"name"`
+		}
+		if req.Params != nil {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+				return
+			}
+		}
+		info, err := h.saveCheckpoint(params.Name)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while saving checkpoint: %s", err))
+			return
+		}
+		j, err := json.Marshal(info)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
 		ch <- NewResponse(req.ID, RawJSON(j))
-	case "dump":
-		data, err := json.Marshal(sim)
+	case "checkpoints":
+		items, err := listCheckpoints(h.id)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		j, err := json.Marshal(items)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, RawJSON(j))
+	case "restore":
+		var params struct {
+			Name      string `json:"name"`
+			AutoStart bool   `json:"autoStart"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err := h.restoreCheckpoint(params.Name); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		if params.AutoStart {
+			h.sim.Start()
+		}
+		ch <- NewOkResponse(req.ID, "Checkpoint restored successfully")
+	case "rewind":
+		var params rewindRequest
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		t, err := h.rewind(params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while rewinding simulation: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Simulation rewound to %s", t))
+	case "fastForward":
+		var params fastForwardRequest
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		t, err := h.fastForward(params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while fast-forwarding simulation: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Simulation fast-forwarded to %s", t))
+	case "step":
+		var params stepRequest
+		if req.Params != nil {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+				return
+			}
+		}
+		t, err := h.step(params)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while stepping simulation: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Simulation stepped to %s", t))
+	case "timeline":
+		j, err := json.Marshal(timelineReport(h.sim))
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		ch <- NewResponse(req.ID, data)
+		ch <- NewResponse(req.ID, RawJSON(j))
+	case "isStarted":
+		j, err := json.Marshal(h.sim.IsStarted())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, RawJSON(j))
+	case "dump":
+		h.dumpSimulation(req, conn, ch)
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
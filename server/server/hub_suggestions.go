@@ -28,16 +28,21 @@ import (
 type suggestionsObject struct{}
 
 // dispatch processes requests on the suggestions object
-func (s *suggestionsObject) dispatch(h *Hub, req Request, conn *connection) {
-    ch := conn.pushChan
+func (s *suggestionsObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
     switch req.Action {
     case "list":
         // Return current suggestions snapshot
-        if sim.Suggestions == nil {
+        if h.sim.Suggestions == nil {
             // Force recompute if enabled
-            simulation.RecomputeSuggestions()
+            h.sim.RecomputeSuggestions()
         }
-        data, err := json.Marshal(sim.Suggestions)
+        sgs := h.sim.Suggestions
+        if sgs != nil && conn.areaRestricted() && len(h.sim.ControlAreas()) > 0 {
+            // Dispatchers only get the suggestions of their control areas
+            sgs = &simulation.Suggestions{Items: h.suggestionsFor(sgs.Items, conn.user)}
+            sgs.GeneratedAt.Time = h.sim.Suggestions.GeneratedAt.Time
+        }
+        data, err := json.Marshal(sgs)
         if err != nil {
             ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
             return
@@ -49,12 +54,19 @@ func (s *suggestionsObject) dispatch(h *Hub, req Request, conn *connection) {
             ch <- NewErrorResponse(req.ID, fmt.Errorf("unparsable request: %s (%s)", err, req.Params))
             return
         }
-        if err := simulation.AcceptSuggestion(p.ID); err != nil {
+        sg, found := findSuggestion(h.sim, p.ID)
+        if err := h.sim.AcceptSuggestion(p.ID); err != nil {
+            if found {
+                h.sendSuggestionDecision(sg, suggestionDecision{Decision: decisionAccepted, Source: "websocket", Role: conn.role, Error: err.Error()})
+            }
             ch <- NewErrorResponse(req.ID, err)
             return
         }
+        if found {
+            h.sendSuggestionDecision(sg, suggestionDecision{Decision: decisionAccepted, Source: "websocket", Role: conn.role})
+        }
         // Recompute after applying
-        simulation.RecomputeSuggestions()
+        h.sim.RecomputeSuggestions()
         ch <- NewOkResponse(req.ID, "Suggestion accepted")
     case "reject":
         var p struct{
@@ -65,13 +77,17 @@ func (s *suggestionsObject) dispatch(h *Hub, req Request, conn *connection) {
             ch <- NewErrorResponse(req.ID, fmt.Errorf("unparsable request: %s (%s)", err, req.Params))
             return
         }
-        if err := simulation.RejectSuggestion(p.ID, p.Minutes); err != nil {
+        sg, found := findSuggestion(h.sim, p.ID)
+        if err := h.sim.RejectSuggestion(p.ID, p.Minutes); err != nil {
             ch <- NewErrorResponse(req.ID, err)
             return
         }
+        if found {
+            h.sendSuggestionDecision(sg, suggestionDecision{Decision: decisionDismissed, Source: "websocket", Role: conn.role, DismissMinutes: p.Minutes})
+        }
         ch <- NewOkResponse(req.ID, "Suggestion rejected")
     case "recompute":
-        simulation.RecomputeSuggestions()
+        h.sim.RecomputeSuggestions()
         ch <- NewOkResponse(req.ID, "Recomputed")
     default:
         ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
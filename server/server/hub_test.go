@@ -20,6 +20,8 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -67,6 +69,221 @@ func TestHub(t *testing.T) {
 		c := clientDial(t)
 		err := register(t, c, Client, "", "client-secret")
 		So(err, ShouldBeNil)
+		Convey("Role permissions should be enforced", func() {
+			obs := clientDial(t)
+			defer obs.Close()
+			err := obs.WriteJSON(RequestRegister{1, "server", "register", ParamsRegister{ClientType: Client, Token: "client-secret", Role: "observer"}})
+			So(err, ShouldBeNil)
+			var resp ResponseStatus
+			So(obs.ReadJSON(&resp), ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			err = obs.WriteJSON(Request{ID: 2, Object: "route", Action: "list"})
+			So(err, ShouldBeNil)
+			var r Response
+			So(obs.ReadJSON(&r), ShouldBeNil)
+			So(r.ID, ShouldEqual, 2)
+			So(string(r.Data), ShouldNotContainSubstring, "PERMISSION_DENIED")
+
+			resp = sendRequestStatus(obs, "route", "activate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, PermissionDenied)
+			So(resp.Data.Message, ShouldEqual, "Error: role observer is not allowed to call route/activate")
+			resp = sendRequestStatus(obs, "simulation", "restart", "")
+			So(resp.Data.Status, ShouldEqual, PermissionDenied)
+			resp = sendRequestStatus(obs, "server", "renotify", "")
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			So(RoleOperator.allows("route", "activate"), ShouldBeTrue)
+			So(RoleOperator.allows("simulation", "restart"), ShouldBeFalse)
+			So(RoleOperator.allows("option", "set"), ShouldBeFalse)
+			So(RoleAdmin.allows("simulation", "restart"), ShouldBeTrue)
+			So(ClientRole("unknown").allows("route", "list"), ShouldBeFalse)
+		})
+		Convey("Roles should be bound to the register token", func() {
+			restricted := testServerConfig()
+			restricted.ObserverClientToken = true
+			So(SetServerConfig(restricted), ShouldBeNil)
+			defer func() { So(SetServerConfig(testServerConfig()), ShouldBeNil) }()
+			// registerAs registers a new client with the given params and
+			// returns the status of the register response and the one of an
+			// option/set request, which tells the role of the client.
+			registerAs := func(params ParamsRegister) (ResponseStatus, ResponseStatus) {
+				cl := clientDial(t)
+				defer cl.Close()
+				params.ClientType = Client
+				So(cl.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: params}), ShouldBeNil)
+				var resp ResponseStatus
+				So(cl.ReadJSON(&resp), ShouldBeNil)
+				if resp.Data.Status != Ok {
+					return resp, ResponseStatus{}
+				}
+				return resp, sendRequestStatus(cl, "option", "set", `{"name": "unknown", "value": 1}`)
+			}
+			resp, set := registerAs(ParamsRegister{Token: "client-secret", User: "mallory"})
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(set.Data.Message, ShouldEqual, "Error: role observer is not allowed to call option/set")
+			resp, _ = registerAs(ParamsRegister{Token: "client-secret", Role: "admin"})
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: role admin is not granted to this token")
+
+			_, set = registerAs(ParamsRegister{Token: "alice-secret"})
+			So(set.Data.Message, ShouldEqual, "Error: role operator is not allowed to call option/set")
+			_, set = registerAs(ParamsRegister{Token: "alice-secret", Role: "observer"})
+			So(set.Data.Message, ShouldEqual, "Error: role observer is not allowed to call option/set")
+			resp, _ = registerAs(ParamsRegister{Token: "alice-secret", Role: "supervisor"})
+			So(resp.Data.Status, ShouldEqual, Fail)
+			resp, _ = registerAs(ParamsRegister{Token: "alice-secret", User: "bob"})
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: token of user alice cannot register as bob")
+			_, set = registerAs(ParamsRegister{Token: "admin-secret"})
+			So(set.Data.Status, ShouldNotEqual, PermissionDenied)
+
+			// Without observerClientToken, anonymous clients keep any role
+			So(SetServerConfig(testServerConfig()), ShouldBeNil)
+			_, set = registerAs(ParamsRegister{Token: "client-secret", User: "mallory"})
+			So(set.Data.Status, ShouldNotEqual, PermissionDenied)
+			_, set = registerAs(ParamsRegister{Token: "client-secret", Role: "operator"})
+			So(set.Data.Message, ShouldEqual, "Error: role operator is not allowed to call option/set")
+		})
+		Convey("Only supervisors should change the direction of single lines", func() {
+			for token, expected := range map[string]string{
+				"alice-secret": "Error: role operator is not allowed to call section/setDirection",
+				"sam-secret":   "Error: unknown section: NOWHERE",
+			} {
+				cl := clientDial(t)
+				So(register(t, cl, Client, "", token), ShouldBeNil)
+				resp := sendRequestStatus(cl, "section", "setDirection", `{"id": "NOWHERE", "direction": "DOWN"}`)
+				So(resp.Data.Message, ShouldEqual, expected)
+				cl.Close()
+			}
+		})
+		Convey("Observer clients should be read-only", func() {
+			obs := clientDial(t)
+			defer obs.Close()
+			err := obs.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Observer, Token: "client-secret"}})
+			So(err, ShouldBeNil)
+			var resp ResponseStatus
+			So(obs.ReadJSON(&resp), ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			resp = sendRequestStatus(obs, "server", "addListener", `{"event": "optionsChanged"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(obs, "simulation", "start", "")
+			So(resp.Data.Status, ShouldEqual, PermissionDenied)
+			resp = sendRequestStatus(obs, "option", "set", `{"name": "timeFactor", "value": 2}`)
+			So(resp.Data.Status, ShouldEqual, PermissionDenied)
+
+			// Observers still receive events
+			resp = sendRequestStatus(c, "option", "set", `{"name": "timeFactor", "value": 5}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			var n ResponseNotification
+			So(obs.ReadJSON(&n), ShouldBeNil)
+			So(n.Data.Name, ShouldEqual, simulation.OptionsChangedEvent)
+
+			other := clientDial(t)
+			defer other.Close()
+			err = other.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Observer, Token: "client-secret", Role: "admin"}})
+			So(err, ShouldBeNil)
+			So(other.ReadJSON(&resp), ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: observer clients cannot have role admin")
+		})
+		Convey("Metrics should be available through the hub", func() {
+			err := c.WriteJSON(Request{ID: 3, Object: "metrics", Action: "current", Params: RawJSON(`{"timeRange": "1h"}`)})
+			So(err, ShouldBeNil)
+			var resp Response
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.ID, ShouldEqual, 3)
+			var report map[string]interface{}
+			So(json.Unmarshal(resp.Data, &report), ShouldBeNil)
+			So(report["timeRange"], ShouldEqual, "1h")
+			So(report["kpis"], ShouldContainKey, "punctuality")
+			So(report["kpis"], ShouldContainKey, "passengerWeightedDelay")
+
+			err = c.WriteJSON(Request{ID: 4, Object: "metrics", Action: "historical", Params: RawJSON(`{"metric": "throughput"}`)})
+			So(err, ShouldBeNil)
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(json.Unmarshal(resp.Data, &report), ShouldBeNil)
+			So(report["metric"], ShouldEqual, "throughput")
+			So(report["period"], ShouldEqual, "hourly")
+
+			st := sendRequestStatus(c, "metrics", "subscribe", "")
+			So(st.Data.Status, ShouldEqual, Ok)
+			hub.notifyMetricsSubscribers()
+			var event ResponseNotification
+			So(c.ReadJSON(&event), ShouldBeNil)
+			So(event.MsgType, ShouldEqual, TypeNotification)
+			So(event.Data.Name, ShouldEqual, MetricsUpdatedEvent)
+			So(event.Data.Object, ShouldContainKey, "kpis")
+			st = sendRequestStatus(c, "metrics", "unsubscribe", "")
+			So(st.Data.Status, ShouldEqual, Ok)
+
+			st = sendRequestStatus(c, "metrics", "unknown", "")
+			So(st.Data.Status, ShouldEqual, Fail)
+		})
+		Convey("Resuming should send the missed notifications", func() {
+			st := sendRequestStatus(c, "metrics", "subscribe", "")
+			So(st.Data.Status, ShouldEqual, Ok)
+			hub.notifyClients(&simulation.Event{Name: MetricsUpdatedEvent, Object: metricsReport{"n": 1}})
+			var event ResponseNotification
+			So(c.ReadJSON(&event), ShouldBeNil)
+			So(event.Seq, ShouldBeGreaterThan, 0)
+			lastSeq := event.Seq
+			So(c.Close(), ShouldBeNil)
+
+			hub.notifyClients(&simulation.Event{Name: MetricsUpdatedEvent, Object: metricsReport{"n": 2}})
+			hub.notifyClients(&simulation.Event{Name: MetricsUpdatedEvent, Object: metricsReport{"n": 3}})
+
+			c = clientDial(t)
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			st = sendRequestStatus(c, "metrics", "subscribe", "")
+			So(st.Data.Status, ShouldEqual, Ok)
+			err := c.WriteJSON(Request{ID: 8, Object: "server", Action: "resume", Params: RawJSON(fmt.Sprintf(`{"lastEventId": %d}`, lastSeq))})
+			So(err, ShouldBeNil)
+			for _, n := range []float64{2, 3} {
+				var missed struct {
+					MsgType MessageType `json:"msgType"`
+					Seq     uint64      `json:"seq"`
+					Data    struct {
+						Name   simulation.EventName   `json:"name"`
+						Object map[string]interface{} `json:"object"`
+					} `json:"data"`
+				}
+				So(c.ReadJSON(&missed), ShouldBeNil)
+				So(missed.MsgType, ShouldEqual, TypeNotification)
+				So(missed.Seq, ShouldBeGreaterThan, lastSeq)
+				So(missed.Data.Name, ShouldEqual, MetricsUpdatedEvent)
+				So(missed.Data.Object["n"], ShouldEqual, n)
+				lastSeq = missed.Seq
+			}
+			var resp ResponseStatus
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.ID, ShouldEqual, 8)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(resp.Data.Message, ShouldEqual, "2 missed notifications sent")
+
+			resp = sendRequestStatus(c, "server", "resume", fmt.Sprintf(`{"lastEventId": %d}`, lastSeq+1000000))
+			So(resp.Data.Status, ShouldEqual, Fail)
+			resp = sendRequestStatus(c, "server", "resume", `{}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: missing lastEventId")
+		})
+		Convey("Replay buffer should be bounded", func() {
+			var rb replayBuffer
+			for i := 0; i < replayBufferSize+10; i++ {
+				rb.record(&sequencedEvent{name: MetricsUpdatedEvent, object: RawJSON("{}")})
+			}
+			So(rb.events, ShouldHaveLength, replayBufferSize)
+			_, err := rb.since(5)
+			So(err, ShouldNotBeNil)
+			events, err := rb.since(replayBufferSize + 5)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 5)
+			So(events[0].seq, ShouldEqual, replayBufferSize+6)
+			events, err = rb.since(replayBufferSize + 10)
+			So(err, ShouldBeNil)
+			So(events, ShouldBeEmpty)
+		})
 		Convey("Calling unknown object should fail", func() {
 			err = c.WriteJSON(Request{Object: "undefined", Action: "undefined"})
 			So(err, ShouldBeNil)
@@ -212,6 +429,15 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Status, ShouldEqual, Fail)
 				So(resp.Data.Message, ShouldEqual, "Error: cannot activate route 2: Standard Manager vetoed route activation: conflicting route 1 is active")
 			})
+			Convey("Setting through routes", func() {
+				resp := sendRequestStatus(c, "route", "setThrough", `{"begin": "5", "end": "11"}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "Routes 1, 11 set from signal 5 to signal 11")
+				resp = sendRequestStatus(c, "route", "setThrough", `{"begin": "5", "end": "17"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: cannot set through route from 5 to 17: route 1 is already set from signal 5")
+			})
 		})
 		Convey("Trains functions", func() {
 			Convey("Calling unknown action should fail", func() {
@@ -389,6 +615,75 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Status, ShouldEqual, Fail)
 				So(resp.Data.Message, ShouldEqual, "Error: unknown trackItem: 999")
 			})
+			Convey("Injecting and clearing a disruption", func() {
+				err = c.WriteJSON(Request{Object: "trackItem", Action: "disrupt", Params: RawJSON(`{"type": "SPEED_RESTRICTION", "trackItemId": "12", "speedLimit": 5}`)})
+				So(err, ShouldBeNil)
+				var resp Response
+				err = c.ReadJSON(&resp)
+				So(err, ShouldBeNil)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				var d struct {
+					ID     string `json:"id"`
+					Status string `json:"status"`
+				}
+				err = json.Unmarshal(resp.Data, &d)
+				So(err, ShouldBeNil)
+				So(d.Status, ShouldEqual, "ACTIVE")
+				So(sim.TrackItems["12"].SpeedRestriction(), ShouldEqual, 5)
+				respStatus := sendRequestStatus(c, "trackItem", "clearDisruption", fmt.Sprintf(`{"id": "%s"}`, d.ID))
+				So(respStatus.Data.Status, ShouldEqual, Ok)
+				So(sim.TrackItems["12"].SpeedRestriction(), ShouldEqual, 0)
+			})
+			Convey("Injecting a disruption on a wrong trackItem should fail", func() {
+				resp := sendRequestStatus(c, "trackItem", "disrupt", `{"type": "SIGNAL_FAILED", "trackItemId": "12"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: error while injecting disruption: track item 12 is not a signal")
+			})
+		})
+		Convey("Disruptions functions", func() {
+			Convey("Creating, showing, listing and clearing a disruption", func() {
+				err = c.WriteJSON(Request{Object: "disruption", Action: "create", Params: RawJSON(`{"type": "TRACK_BLOCKED", "trackItemId": "14", "reason": "Fallen tree"}`)})
+				So(err, ShouldBeNil)
+				var resp Response
+				So(c.ReadJSON(&resp), ShouldBeNil)
+				var d struct {
+					ID     string `json:"id"`
+					Status string `json:"status"`
+				}
+				So(json.Unmarshal(resp.Data, &d), ShouldBeNil)
+				So(d.Status, ShouldEqual, "ACTIVE")
+				So(sim.TrackItems["14"].Blocked(), ShouldBeTrue)
+
+				err = c.WriteJSON(Request{Object: "disruption", Action: "show", Params: RawJSON(fmt.Sprintf(`{"ids": ["%s"]}`, d.ID))})
+				So(err, ShouldBeNil)
+				So(c.ReadJSON(&resp), ShouldBeNil)
+				var shown map[string]map[string]interface{}
+				So(json.Unmarshal(resp.Data, &shown), ShouldBeNil)
+				So(shown, ShouldContainKey, d.ID)
+				So(shown[d.ID]["reason"], ShouldEqual, "Fallen tree")
+
+				err = c.WriteJSON(Request{Object: "disruption", Action: "list"})
+				So(err, ShouldBeNil)
+				So(c.ReadJSON(&resp), ShouldBeNil)
+				var list []map[string]interface{}
+				So(json.Unmarshal(resp.Data, &list), ShouldBeNil)
+				So(list, ShouldHaveLength, 1)
+
+				respStatus := sendRequestStatus(c, "disruption", "clear", fmt.Sprintf(`{"id": "%s"}`, d.ID))
+				So(respStatus.Data.Status, ShouldEqual, Ok)
+				So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+			})
+			Convey("Wrong disruption requests should fail", func() {
+				resp := sendRequestStatus(c, "disruption", "show", `{"ids": ["999"]}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown disruption: 999")
+				resp = sendRequestStatus(c, "disruption", "clear", `{"id": "999"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				resp = sendRequestStatus(c, "disruption", "create", `{"type": "UNKNOWN", "trackItemId": "14"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(RoleOperator.allows("disruption", "create"), ShouldBeFalse)
+				So(RoleOperator.allows("disruption", "list"), ShouldBeTrue)
+			})
 		})
 		Convey("Places functions", func() {
 			Convey("Calling unknown action should fail", func() {
@@ -553,6 +848,52 @@ func TestHub(t *testing.T) {
 				So(simu.Places, ShouldHaveLength, 3)
 				So(simu.Places, ShouldContainKey, "STN")
 			})
+			Convey("Dumping simulation in chunks", func() {
+				err = c.WriteJSON(Request{ID: 12, Object: "simulation", Action: "dump", Params: RawJSON(`{"chunked": true, "chunkSize": 1024}`)})
+				So(err, ShouldBeNil)
+				var (
+					dump   strings.Builder
+					chunks int
+					last   bool
+					resp   Response
+				)
+				for {
+					var msg struct {
+						ID      int             `json:"id"`
+						MsgType MessageType     `json:"msgType"`
+						Data    json.RawMessage `json:"data"`
+					}
+					So(c.ReadJSON(&msg), ShouldBeNil)
+					if msg.MsgType == TypeChunk {
+						var chunk DataChunk
+						So(json.Unmarshal(msg.Data, &chunk), ShouldBeNil)
+						So(msg.ID, ShouldEqual, 12)
+						So(chunk.Index, ShouldEqual, chunks)
+						So(len(chunk.Data), ShouldBeLessThanOrEqualTo, 1024)
+						dump.WriteString(chunk.Data)
+						chunks++
+						last = chunk.Last
+						continue
+					}
+					if msg.MsgType == TypeResponse {
+						resp = Response{ID: msg.ID, MsgType: msg.MsgType, Data: RawJSON(msg.Data)}
+						break
+					}
+				}
+				So(last, ShouldBeTrue)
+				So(chunks, ShouldBeGreaterThan, 1)
+				var summary map[string]int
+				So(json.Unmarshal(resp.Data, &summary), ShouldBeNil)
+				So(summary["chunks"], ShouldEqual, chunks)
+				So(summary["size"], ShouldEqual, dump.Len())
+				var simu simulation.Simulation
+				So(json.Unmarshal([]byte(dump.String()), &simu), ShouldBeNil)
+				So(simu.TrackItems, ShouldHaveLength, 29)
+				So(simu.Places, ShouldContainKey, "STN")
+
+				resp2 := sendRequestStatus(c, "simulation", "dump", `{"chunked": true, "chunkSize": 10}`)
+				So(resp2.Data.Status, ShouldEqual, Fail)
+			})
 			Convey("Starting simulation", func() {
 				resp := sendRequestStatus(c, "simulation", "start", "")
 				So(resp.MsgType, ShouldEqual, TypeResponse)
@@ -699,6 +1040,50 @@ func TestHub(t *testing.T) {
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Fail)
 			})
+			Convey("Filtered listeners should not receive events outside their filter", func() {
+				resp := sendRequestStatus(c, "server", "addListener", `{"event": "clock", "filter": {"trainIds": ["0"]}}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				// Clock events do not relate to any train, so renotify should only send the response
+				resp = sendRequestStatus(c, "server", "renotify", "")
+				So(resp.Data.Status, ShouldEqual, Ok)
+				resp = sendRequestStatus(c, "server", "removeListener", `{"event": "clock"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+
+				resp = sendRequestStatus(c, "server", "addListener", `{"event": "clock", "filter": {"sectionIds": ["UNKNOWN"]}}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+			})
+			Convey("Listener filters should match events by train, place and section", func() {
+				train := sim.Trains[0]
+				trainEvent := &simulation.Event{Name: simulation.TrainChangedEvent, Object: train}
+				stnEvent := &simulation.Event{Name: simulation.TrackItemChangedEvent, Object: sim.TrackItems["10"]}
+				lftEvent := &simulation.Event{Name: simulation.TrackItemChangedEvent, Object: sim.TrackItems["2"]}
+				var nilFilter *ListenerFilter
+				So(nilFilter.matches(sim, hub.eventSubject(stnEvent)), ShouldBeTrue)
+				So((&ListenerFilter{}).matches(sim, hub.eventSubject(stnEvent)), ShouldBeTrue)
+
+				byTrain := &ListenerFilter{TrainIDs: []string{train.ID()}}
+				So(byTrain.matches(sim, hub.eventSubject(trainEvent)), ShouldBeTrue)
+				So(byTrain.matches(sim, hub.eventSubject(stnEvent)), ShouldBeFalse)
+				So((&ListenerFilter{TrainIDs: []string{"999"}}).matches(sim, hub.eventSubject(trainEvent)), ShouldBeFalse)
+				// Events sent by the simulation are filtered on the encoded train
+				data, err := json.Marshal(train)
+				So(err, ShouldBeNil)
+				sentEvent := &simulation.Event{Name: simulation.TrainChangedEvent, Object: train, Data: data}
+				So(hub.eventSubject(sentEvent), ShouldResemble, hub.eventSubject(trainEvent))
+
+				byPlace := &ListenerFilter{PlaceCodes: []string{"STN"}}
+				So(byPlace.matches(sim, hub.eventSubject(stnEvent)), ShouldBeTrue)
+				So(byPlace.matches(sim, hub.eventSubject(lftEvent)), ShouldBeFalse)
+				So((&ListenerFilter{PlaceCodes: []string{"STN"}, TrainIDs: []string{train.ID()}}).matches(sim, hub.eventSubject(stnEvent)), ShouldBeFalse)
+
+				So(sim.AddSection("FLT", &simulation.Section{TrackItemIDs: []string{"2", "3"}}), ShouldBeNil)
+				bySection := &ListenerFilter{SectionIDs: []string{"FLT"}}
+				So(bySection.validate(sim), ShouldBeNil)
+				So(bySection.matches(sim, hub.eventSubject(lftEvent)), ShouldBeTrue)
+				So(bySection.matches(sim, hub.eventSubject(stnEvent)), ShouldBeFalse)
+				So(sim.RemoveSection("FLT"), ShouldBeNil)
+				So(bySection.validate(sim), ShouldNotBeNil)
+			})
 			Convey("Renotify should send back the last notifications", func() {
 				err = c.WriteJSON(RequestListener{
 					Object: "server",
@@ -28,12 +28,11 @@ import (
 type trackItemObject struct{}
 
 // dispatch processes requests made on the TrackItem object
-func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for trackitem list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		til, err := json.Marshal(sim.TrackItems)
+		til, err := json.Marshal(h.sim.TrackItems)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +50,7 @@ func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		tkis := make(map[string]simulation.TrackItem)
 		for _, id := range idsParams.IDs {
-			tsID, ok := sim.TrackItems[id]
+			tsID, ok := h.sim.TrackItems[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown trackItem: %s", id))
 				return
@@ -64,6 +63,159 @@ func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection) {
 			return
 		}
 		ch <- NewResponse(req.ID, tid)
+	case "disruptions":
+		logger.Debug("Request for disruptions list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		dl, err := json.Marshal(h.sim.Disruptions())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dl)
+	case "disrupt":
+		var dr disruptionRequest
+		err := json.Unmarshal(req.Params, &dr)
+		logger.Debug("Request for trackItem disrupt received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", dr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		d, err := injectDisruption(h.sim, dr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while injecting disruption: %s", err))
+			return
+		}
+		dd, err := json.Marshal(d)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, dd)
+	case "clearDisruption":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for trackItem clearDisruption received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err = h.sim.RemoveDisruption(idParams.ID); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Disruption %s cleared successfully", idParams.ID))
+	case "failSignal":
+		var fr signalFailureRequest
+		err := json.Unmarshal(req.Params, &fr)
+		logger.Debug("Request for trackItem failSignal received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", fr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if _, err = failSignal(h.sim, fr); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while failing signal: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Signal %s failed successfully", fr.SignalID))
+	case "repairSignal":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for trackItem repairSignal received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		si, ok := h.sim.TrackItems[idParams.ID].(*simulation.SignalItem)
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown signal: %s", idParams.ID))
+			return
+		}
+		si.Repair()
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Signal %s repaired successfully", idParams.ID))
+	case "failPoints":
+		var fr pointsFailureRequest
+		err := json.Unmarshal(req.Params, &fr)
+		logger.Debug("Request for trackItem failPoints received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", fr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if _, err = failPoints(h.sim, fr); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while failing points: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Points %s failed successfully", fr.PointsID))
+	case "repairPoints":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for trackItem repairPoints received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		pi, ok := h.sim.TrackItems[idParams.ID].(*simulation.PointsItem)
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown points: %s", idParams.ID))
+			return
+		}
+		pi.Repair()
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Points %s repaired successfully", idParams.ID))
+	case "operateLevelCrossing":
+		var opParams = struct {
+			ID     string `json:"id"`
+			Action string `json:"action"`
+		}{}
+		err := json.Unmarshal(req.Params, &opParams)
+		logger.Debug("Request for trackItem operateLevelCrossing received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", opParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		lc, ok := h.sim.TrackItems[opParams.ID].(*simulation.LevelCrossingItem)
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown level crossing: %s", opParams.ID))
+			return
+		}
+		if err = operateLevelCrossing(lc, opParams.Action); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while operating level crossing: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Level crossing %s operated successfully", opParams.ID))
+	case "failLevelCrossing":
+		var fr levelCrossingFailureRequest
+		err := json.Unmarshal(req.Params, &fr)
+		logger.Debug("Request for trackItem failLevelCrossing received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", fr)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if _, err = failLevelCrossing(h.sim, fr); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while failing level crossing: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Level crossing %s failed successfully", fr.LevelCrossingID))
+	case "repairLevelCrossing":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for trackItem repairLevelCrossing received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		lc, ok := h.sim.TrackItems[idParams.ID].(*simulation.LevelCrossingItem)
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown level crossing: %s", idParams.ID))
+			return
+		}
+		lc.Repair()
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Level crossing %s repaired successfully", idParams.ID))
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
@@ -21,6 +21,7 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/ts2/ts2-sim-server/simulation"
 )
@@ -28,12 +29,11 @@ import (
 type trainObject struct{}
 
 // dispatch processes requests made on the Service object
-func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
+func (t *trainObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	logger.Debug("Request for train received", "submodule", "hub", "object", req.Object, "action", req.Action)
-	ch := conn.pushChan
 	switch req.Action {
 	case "list":
-		sl, err := json.Marshal(sim.Trains)
+		sl, err := json.Marshal(h.sim.Trains)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -50,11 +50,11 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		ts := make([]*simulation.Train, len(idsParams.IDs))
 		for i, id := range idsParams.IDs {
-			if id < 0 || id >= len(sim.Trains) {
+			if id < 0 || id >= len(h.sim.Trains) {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", id))
 				return
 			}
-			ts[i] = sim.Trains[id]
+			ts[i] = h.sim.Trains[id]
 		}
 		tid, err := json.Marshal(ts)
 		if err != nil {
@@ -71,11 +71,11 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if idParams.ID < 0 || idParams.ID >= len(sim.Trains) {
+		if idParams.ID < 0 || idParams.ID >= len(h.sim.Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
 			return
 		}
-		train := sim.Trains[idParams.ID]
+		train := h.sim.Trains[idParams.ID]
 		if err = train.Reverse(); err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to reverse train %d: %s", idParams.ID, err))
 			return
@@ -91,11 +91,11 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if smParams.ID < 0 || smParams.ID >= len(sim.Trains) {
+		if smParams.ID < 0 || smParams.ID >= len(h.sim.Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", smParams.ID))
 			return
 		}
-		if err = sim.Trains[smParams.ID].AssignService(smParams.Service); err != nil {
+		if err = h.sim.Trains[smParams.ID].AssignService(smParams.Service); err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to assign service %s to train %d: %s", smParams.Service, smParams.ID, err))
 			return
 		}
@@ -109,11 +109,11 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if idParams.ID < 0 || idParams.ID >= len(sim.Trains) {
+		if idParams.ID < 0 || idParams.ID >= len(h.sim.Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
 			return
 		}
-		train := sim.Trains[idParams.ID]
+		train := h.sim.Trains[idParams.ID]
 		_ = train.ResetService()
 		ch <- NewOkResponse(req.ID, "service reset successfully")
 	case "proceed":
@@ -125,16 +125,205 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if idParams.ID < 0 || idParams.ID >= len(sim.Trains) {
+		if idParams.ID < 0 || idParams.ID >= len(h.sim.Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
 			return
 		}
-		train := sim.Trains[idParams.ID]
+		train := h.sim.Trains[idParams.ID]
 		if err = train.ProceedWithCaution(); err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to proceed for train %d: %s", idParams.ID, err))
 			return
 		}
 		ch <- NewOkResponse(req.ID, "proceed order passed successfully")
+	case "reroute":
+		var rrParams = struct {
+			ID        int      `json:"id"`
+			Waypoints []string `json:"waypoints"`
+		}{}
+		err := json.Unmarshal(req.Params, &rrParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if rrParams.ID < 0 || rrParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", rrParams.ID))
+			return
+		}
+		path, err := h.sim.Trains[rrParams.ID].Reroute(rrParams.Waypoints...)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to reroute train %d: %s", rrParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train rerouted through routes %s", strings.Join(path.RouteIDs(), ", ")))
+	case "shunt":
+		var shParams = struct {
+			ID        int      `json:"id"`
+			Waypoints []string `json:"waypoints"`
+		}{}
+		err := json.Unmarshal(req.Params, &shParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if shParams.ID < 0 || shParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", shParams.ID))
+			return
+		}
+		path, err := h.sim.Trains[shParams.ID].Shunt(shParams.Waypoints...)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to shunt train %d: %s", shParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train shunting through routes %s", strings.Join(path.RouteIDs(), ", ")))
+	case "stable":
+		var stParams = struct {
+			ID    int    `json:"id"`
+			Depot string `json:"depot"`
+		}{}
+		err := json.Unmarshal(req.Params, &stParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if stParams.ID < 0 || stParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", stParams.ID))
+			return
+		}
+		if _, err = h.sim.Trains[stParams.ID].SendToDepot(stParams.Depot); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to stable train %d: %s", stParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train sent to depot %s", stParams.Depot))
+	case "callBack":
+		var cbParams = struct {
+			ID      int    `json:"id"`
+			Service string `json:"service"`
+		}{}
+		err := json.Unmarshal(req.Params, &cbParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if cbParams.ID < 0 || cbParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", cbParams.ID))
+			return
+		}
+		if err = h.sim.Trains[cbParams.ID].CallBack(cbParams.Service); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to call back train %d: %s", cbParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "train called back into service")
+	case "withdraw":
+		var idParams = struct {
+			ID int `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if idParams.ID < 0 || idParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
+			return
+		}
+		if err = h.sim.Trains[idParams.ID].Withdraw(); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to withdraw train %d: %s", idParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "train will be withdrawn at the end of its cycle")
+	case "split":
+		var spParams = struct {
+			ID      int    `json:"id"`
+			After   int    `json:"after"`
+			Service string `json:"service"`
+		}{}
+		err := json.Unmarshal(req.Params, &spParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if spParams.ID < 0 || spParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", spParams.ID))
+			return
+		}
+		rear, err := h.sim.Trains[spParams.ID].Split(spParams.After, spParams.Service)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to split train %d: %s", spParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train split, rear portion is train %s", rear.ID()))
+	case "join":
+		var jnParams = struct {
+			ID        int    `json:"id"`
+			Direction string `json:"direction"`
+		}{}
+		err := json.Unmarshal(req.Params, &jnParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if jnParams.ID < 0 || jnParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", jnParams.ID))
+			return
+		}
+		if jnParams.Direction != "ahead" && jnParams.Direction != "behind" {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("invalid direction %q, expected ahead or behind", jnParams.Direction))
+			return
+		}
+		other, err := h.sim.Trains[jnParams.ID].Join(jnParams.Direction == "ahead")
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to join train %d: %s", jnParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train joined with train %s", other.ID()))
+	case "cancel":
+		var idParams = struct {
+			ID int `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if idParams.ID < 0 || idParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
+			return
+		}
+		if err = h.sim.Trains[idParams.ID].Cancel(); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to cancel train %d: %s", idParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "train cancelled successfully")
+	case "coast":
+		var idParams = struct {
+			ID int `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if idParams.ID < 0 || idParams.ID >= len(h.sim.Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
+			return
+		}
+		if err = h.sim.Trains[idParams.ID].Coast(); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to make train %d coast: %s", idParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "train coasting")
+	case "spawn":
+		var spParams trainSpawnRequest
+		if err := json.Unmarshal(req.Params, &spParams); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		t, err := spawnTrain(h.sim, &spParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to add train: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train %s added", t.ID()))
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
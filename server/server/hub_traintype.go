@@ -28,12 +28,11 @@ import (
 type trainTypeObject struct{}
 
 // dispatch processes requests made on the TrainType object
-func (s *trainTypeObject) dispatch(h *Hub, req Request, conn *connection) {
-	ch := conn.pushChan
+func (s *trainTypeObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for trainType list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		tts, err := json.Marshal(sim.TrainTypes)
+		tts, err := json.Marshal(h.sim.TrainTypes)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +50,7 @@ func (s *trainTypeObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		tts := make(map[string]*simulation.TrainType)
 		for _, id := range idsParams.IDs {
-			ttID, ok := sim.TrainTypes[id]
+			ttID, ok := h.sim.TrainTypes[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown trainType: %s", id))
 				return
@@ -0,0 +1,119 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultRequestTimeout is the time after which a request without
+	// timeoutMs is answered with a TIMEOUT status.
+	defaultRequestTimeout = 30 * time.Second
+	// slowRequestTimeout is the default timeout of slowActions.
+	slowRequestTimeout = 5 * time.Minute
+	// maxRequestTimeout is the longest timeout a client may ask for.
+	maxRequestTimeout = 10 * time.Minute
+)
+
+// slowActions are the actions that get slowRequestTimeout by default.
+var slowActions = map[string]map[string]bool{
+	"simulation": {
+		"dump":    true,
+		"restart": true,
+	},
+}
+
+// A JobState is the state of an asynchronous request.
+type JobState string
+
+const (
+	JobStarted  JobState = "started"
+	JobFinished JobState = "finished"
+	JobTimeout  JobState = "timeout"
+)
+
+// jobCounter is used to generate job IDs
+var jobCounter int64
+
+// requestTimeout returns the timeout of the given request.
+func requestTimeout(req Request) (time.Duration, error) {
+	switch {
+	case req.TimeoutMs < 0:
+		return 0, fmt.Errorf("timeoutMs must be positive")
+	case req.TimeoutMs > 0:
+		d := time.Duration(req.TimeoutMs) * time.Millisecond
+		if d > maxRequestTimeout {
+			return 0, fmt.Errorf("timeoutMs must not exceed %d", maxRequestTimeout/time.Millisecond)
+		}
+		return d, nil
+	case slowActions[req.Object][req.Action]:
+		return slowRequestTimeout, nil
+	}
+	return defaultRequestTimeout, nil
+}
+
+// runRequest dispatches req to obj and pushes the response to the client.
+//
+// If the request does not complete before its deadline, the client gets a
+// TIMEOUT status (or a timeout job message for asynchronous requests). If the
+// connection is closed in the meantime, nothing is sent. In both cases the
+// action itself is not interrupted and its result is dropped.
+func (h *Hub) runRequest(conn *connection, obj hubObject, req Request) {
+	timeout, err := requestTimeout(req)
+	if err != nil {
+		conn.pushChan <- NewErrorResponse(req.ID, err)
+		return
+	}
+	trace := h.traceHubRequest(req)
+	ctx, cancel := context.WithTimeout(conn.context(), timeout)
+	defer cancel()
+	ch := make(chan interface{}, 1)
+	go obj.dispatch(h, req, conn, ch)
+
+	var jobID string
+	if req.Async {
+		jobID = fmt.Sprintf("job_%d", atomic.AddInt64(&jobCounter, 1))
+		conn.pushChan <- NewJobResponse(req.ID, jobID, JobStarted, nil)
+	}
+	select {
+	case resp := <-ch:
+		trace(resp)
+		if req.Async {
+			resp = NewJobResponse(req.ID, jobID, JobFinished, resp)
+		}
+		conn.pushChan <- resp
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			trace(nil)
+			logger.Info("Request cancelled, connection closed", "submodule", "hub", "object", req.Object, "action", req.Action)
+			return
+		}
+		logger.Warn("Request timed out", "submodule", "hub", "object", req.Object, "action", req.Action, "timeout", timeout)
+		trace(NewTimeoutResponse(req.ID, timeout))
+		if req.Async {
+			conn.pushChan <- NewJobResponse(req.ID, jobID, JobTimeout, nil)
+			return
+		}
+		conn.pushChan <- NewTimeoutResponse(req.ID, timeout)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sleepObject is a hub object whose "sleep" action takes the number of
+// milliseconds given as params.
+type sleepObject struct{}
+
+func (s *sleepObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	var ms int
+	_ = json.Unmarshal(req.Params, &ms)
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	ch <- NewOkResponse(req.ID, "Done sleeping")
+}
+
+func init() {
+	hub.objects["testSleep"] = new(sleepObject)
+}
+
+func TestJobs(t *testing.T) {
+	Convey("Testing request timeouts and jobs", t, func() {
+		c := clientDial(t)
+		So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+		Convey("Requests should get their default timeout", func() {
+			d, err := requestTimeout(Request{Object: "route", Action: "list"})
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, defaultRequestTimeout)
+			d, err = requestTimeout(Request{Object: "simulation", Action: "dump"})
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, slowRequestTimeout)
+			d, err = requestTimeout(Request{Object: "route", Action: "list", TimeoutMs: 10})
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 10*time.Millisecond)
+			_, err = requestTimeout(Request{TimeoutMs: -1})
+			So(err, ShouldNotBeNil)
+			_, err = requestTimeout(Request{TimeoutMs: 3600000})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Slow requests should time out", func() {
+			err := c.WriteJSON(Request{ID: 5, Object: "testSleep", Action: "sleep", Params: RawJSON("200"), TimeoutMs: 20})
+			So(err, ShouldBeNil)
+			var resp ResponseStatus
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.ID, ShouldEqual, 5)
+			So(resp.Data.Status, ShouldEqual, Timeout)
+			// The late result must not be sent
+			resp = sendRequestStatus(c, "testSleep", "sleep", "250")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(resp.Data.Message, ShouldEqual, "Done sleeping")
+		})
+		Convey("Asynchronous requests should send job messages", func() {
+			err := c.WriteJSON(Request{ID: 6, Object: "simulation", Action: "dump", Async: true})
+			So(err, ShouldBeNil)
+			var started ResponseJob
+			So(c.ReadJSON(&started), ShouldBeNil)
+			So(started.ID, ShouldEqual, 6)
+			So(started.MsgType, ShouldEqual, TypeJob)
+			So(started.Data.State, ShouldEqual, JobStarted)
+			So(started.Data.JobID, ShouldNotBeEmpty)
+			var finished struct {
+				ID      int         `json:"id"`
+				MsgType MessageType `json:"msgType"`
+				Data    struct {
+					JobID  string   `json:"jobId"`
+					State  JobState `json:"state"`
+					Result Response `json:"result"`
+				} `json:"data"`
+			}
+			So(c.ReadJSON(&finished), ShouldBeNil)
+			So(finished.Data.JobID, ShouldEqual, started.Data.JobID)
+			So(finished.Data.State, ShouldEqual, JobFinished)
+			So(finished.Data.Result.ID, ShouldEqual, 6)
+			So(string(finished.Data.Result.Data), ShouldContainSubstring, "trackItems")
+		})
+		Convey("Asynchronous requests should report timeouts", func() {
+			err := c.WriteJSON(Request{ID: 7, Object: "testSleep", Action: "sleep", Params: RawJSON("200"), Async: true, TimeoutMs: 20})
+			So(err, ShouldBeNil)
+			var job ResponseJob
+			So(c.ReadJSON(&job), ShouldBeNil)
+			So(job.Data.State, ShouldEqual, JobStarted)
+			So(c.ReadJSON(&job), ShouldBeNil)
+			So(job.ID, ShouldEqual, 7)
+			So(job.Data.State, ShouldEqual, JobTimeout)
+		})
+		Reset(func() {
+			So(c.Close(), ShouldBeNil)
+		})
+	})
+}
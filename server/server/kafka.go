@@ -0,0 +1,941 @@
+package server
+
+import (
+    "bufio"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    kafkaMaxBatchRecords  = 500
+    kafkaLinger           = 100 * time.Millisecond
+    kafkaDialTimeout      = 10 * time.Second
+    kafkaRequestTimeout   = 30 * time.Second
+    kafkaMaxRetryWait     = 30 * time.Second
+    defaultKafkaBuffer    = 100000
+    kafkaMaxResponseBytes = 64 << 20
+)
+
+// Kafka API keys and versions used by the producer. Produce v3 is the first
+// version with v2 record batches and Metadata v4 the first one that lets the
+// client ask for topic auto-creation. Both are supported from Kafka 1.0.
+const (
+    kafkaAPIProduce         int16 = 0
+    kafkaAPIMetadata        int16 = 3
+    kafkaProduceVersion     int16 = 3
+    kafkaMetadataVersion    int16 = 4
+    kafkaAcksAll            int16 = -1
+    kafkaNoPartitionLeader  int32 = -1
+    kafkaRecordBatchVersion int8  = 2
+)
+
+// Kafka error codes that cannot be fixed by retrying
+var kafkaFatalErrors = map[int16]string{
+    10: "MESSAGE_TOO_LARGE",
+    17: "INVALID_TOPIC_EXCEPTION",
+    18: "RECORD_LIST_TOO_LARGE",
+    29: "TOPIC_AUTHORIZATION_FAILED",
+    87: "INVALID_RECORD",
+}
+
+// kafkaRetryDelay is the delay before retrying records that were not
+// acknowledged. It doubles at each failed attempt up to kafkaMaxRetryWait.
+var kafkaRetryDelay = 500 * time.Millisecond
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaConfig is the configuration of the Kafka producer
+type KafkaConfig struct {
+    // Brokers are the host:port addresses used to discover the cluster
+    Brokers  []string
+    ClientID string
+    // EventsTopic receives the event journal and AuditTopic the audit
+    // entries. An empty topic disables its records.
+    EventsTopic string
+    AuditTopic  string
+    // Format is the serialization of the record values, json or msgpack
+    Format string
+    // BufferSize is the maximum number of records waiting to be acknowledged
+    BufferSize int
+}
+
+// DefaultKafkaConfig returns a KafkaConfig with the default topics and no brokers
+func DefaultKafkaConfig() KafkaConfig {
+    return KafkaConfig{
+        ClientID:    "ts2-sim-server",
+        EventsTopic: "ts2.events",
+        AuditTopic:  "ts2.audit",
+        Format:      "json",
+        BufferSize:  defaultKafkaBuffer,
+    }
+}
+
+// validate checks the configuration
+func (kc KafkaConfig) validate() error {
+    if len(kc.Brokers) == 0 {
+        return fmt.Errorf("at least one Kafka broker is required")
+    }
+    for _, b := range kc.Brokers {
+        if _, _, err := net.SplitHostPort(b); err != nil {
+            return fmt.Errorf("invalid Kafka broker address %s: %s", b, err)
+        }
+    }
+    for _, t := range []string{kc.EventsTopic, kc.AuditTopic} {
+        if len(t) > 249 || strings.IndexFunc(t, func(r rune) bool {
+            return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-')
+        }) >= 0 {
+            return fmt.Errorf("invalid Kafka topic name: %s", t)
+        }
+    }
+    if kc.Format != "json" && kc.Format != "msgpack" {
+        return fmt.Errorf("Kafka format must be json or msgpack")
+    }
+    if kc.BufferSize <= 0 {
+        return fmt.Errorf("Kafka buffer size must be positive")
+    }
+    return nil
+}
+
+// marshal serializes v in the format of the configuration
+func (kc KafkaConfig) marshal(v interface{}) ([]byte, error) {
+    if kc.Format == "msgpack" {
+        return marshalMsgpack(v)
+    }
+    return json.Marshal(v)
+}
+
+// contentType returns the MIME type of the record values
+func (kc KafkaConfig) contentType() string {
+    if kc.Format == "msgpack" {
+        return "application/msgpack"
+    }
+    return "application/json"
+}
+
+// A kafkaHeader is a header of a Kafka record
+type kafkaHeader struct {
+    key   string
+    value []byte
+}
+
+// A kafkaRecord is a record waiting to be acknowledged by the cluster
+type kafkaRecord struct {
+    topic     string
+    key       []byte
+    value     []byte
+    headers   []kafkaHeader
+    timestamp time.Time
+}
+
+// kafkaEventRecord is the value of the records of the event journal
+type kafkaEventRecord struct {
+    Simulation string  `json:"simulation"`
+    Seq        uint64  `json:"seq"`
+    Event      string  `json:"event"`
+    SimTime    string  `json:"simTime"`
+    Timestamp  string  `json:"timestamp"`
+    Object     RawJSON `json:"object"`
+}
+
+// kafkaAuditRecord is the value of the records of the audit entries
+type kafkaAuditRecord struct {
+    Simulation string     `json:"simulation"`
+    Entry      AuditEntry `json:"entry"`
+}
+
+// A kafkaPartition is the leader of a partition of a topic
+type kafkaPartition struct {
+    topic     string
+    partition int32
+    leader    int32
+}
+
+// A kafkaProducer streams the event journal and the audit entries of all
+// simulations to Kafka with at-least-once delivery.
+//
+// Records are buffered and sent by a single goroutine with acks=all. They
+// are removed from the buffer only when the cluster acknowledged them and
+// are retried otherwise, so that they may be delivered more than once. Their
+// key is the simulation ID, so that the records of a simulation are kept in
+// order in one partition. Records are only dropped when the buffer is full
+// or when the cluster rejects them for good.
+type kafkaProducer struct {
+    config KafkaConfig
+    wake   chan struct{}
+
+    mutex          sync.Mutex
+    pending        []*kafkaRecord
+    produced       int
+    failed         int
+    dropped        int
+    retries        int
+    lastError      string
+    lastProducedAt time.Time
+
+    // The following fields are only used by the producer goroutine
+    brokers    map[int32]string
+    partitions map[string][]kafkaPartition
+    conns      map[string]*kafkaConn
+}
+
+var (
+    kafka      *kafkaProducer
+    kafkaMutex sync.RWMutex
+)
+
+// SetKafkaConfig configures the Kafka producer. The producer connects to the
+// cluster when the server runs.
+func SetKafkaConfig(kc KafkaConfig) error {
+    if kc.BufferSize == 0 {
+        kc.BufferSize = defaultKafkaBuffer
+    }
+    if err := kc.validate(); err != nil {
+        return err
+    }
+    kafkaMutex.Lock()
+    defer kafkaMutex.Unlock()
+    kafka = &kafkaProducer{
+        config: kc,
+        wake:   make(chan struct{}, 1),
+        conns:  make(map[string]*kafkaConn),
+    }
+    return nil
+}
+
+// currentKafkaProducer returns the Kafka producer or nil if Kafka is not configured
+func currentKafkaProducer() *kafkaProducer {
+    kafkaMutex.RLock()
+    defer kafkaMutex.RUnlock()
+    return kafka
+}
+
+// startKafkaProducer starts streaming to Kafka if it is configured
+func startKafkaProducer() {
+    if p := currentKafkaProducer(); p != nil {
+        go p.run()
+    }
+}
+
+// enqueue buffers the given record, or drops it if the buffer is full
+func (p *kafkaProducer) enqueue(rec *kafkaRecord) {
+    p.mutex.Lock()
+    if len(p.pending) >= p.config.BufferSize {
+        p.dropped++
+        p.mutex.Unlock()
+        return
+    }
+    p.pending = append(p.pending, rec)
+    full := len(p.pending) >= kafkaMaxBatchRecords
+    p.mutex.Unlock()
+    if full {
+        select {
+        case p.wake <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// streamKafkaEvent sends the given journal event to Kafka
+func (h *Hub) streamKafkaEvent(se *sequencedEvent) {
+    p := currentKafkaProducer()
+    if p == nil || p.config.EventsTopic == "" {
+        return
+    }
+    now := time.Now().UTC()
+    value, err := p.config.marshal(kafkaEventRecord{
+        Simulation: h.id,
+        Seq:        se.seq,
+        Event:      string(se.name),
+        SimTime:    h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
+        Timestamp:  now.Format(time.RFC3339Nano),
+        Object:     se.object,
+    })
+    if err != nil {
+        logger.Error("Unable to serialize Kafka event record", "submodule", "kafka", "event", se.name, "error", err)
+        return
+    }
+    p.enqueue(&kafkaRecord{
+        topic: p.config.EventsTopic,
+        key:   []byte(h.id),
+        value: value,
+        headers: []kafkaHeader{
+            {key: "content-type", value: []byte(p.config.contentType())},
+            {key: "ts2-event", value: []byte(se.name)},
+            {key: "ts2-seq", value: []byte(strconv.FormatUint(se.seq, 10))},
+        },
+        timestamp: now,
+    })
+}
+
+// streamKafkaAudit sends the given audit entry of the given simulation to Kafka
+func streamKafkaAudit(simID string, entry AuditEntry) {
+    p := currentKafkaProducer()
+    if p == nil || p.config.AuditTopic == "" {
+        return
+    }
+    value, err := p.config.marshal(kafkaAuditRecord{Simulation: simID, Entry: entry})
+    if err != nil {
+        logger.Error("Unable to serialize Kafka audit record", "submodule", "kafka", "event", entry.Event, "error", err)
+        return
+    }
+    p.enqueue(&kafkaRecord{
+        topic: p.config.AuditTopic,
+        key:   []byte(simID),
+        value: value,
+        headers: []kafkaHeader{
+            {key: "content-type", value: []byte(p.config.contentType())},
+            {key: "ts2-event", value: []byte(entry.Event)},
+        },
+        timestamp: time.Now().UTC(),
+    })
+}
+
+// run sends the buffered records until they are acknowledged
+func (p *kafkaProducer) run() {
+    ticker := time.NewTicker(kafkaLinger)
+    defer ticker.Stop()
+    delay := kafkaRetryDelay
+    for {
+        select {
+        case <-ticker.C:
+        case <-p.wake:
+        }
+        for p.pendingCount() > 0 {
+            if err := p.flush(); err != nil {
+                p.mutex.Lock()
+                p.retries++
+                p.lastError = err.Error()
+                p.mutex.Unlock()
+                logger.Warn("Kafka produce failed", "submodule", "kafka", "error", err)
+                // Force a metadata refresh as leaders may have moved
+                p.partitions = nil
+                time.Sleep(delay)
+                delay *= 2
+                if delay > kafkaMaxRetryWait {
+                    delay = kafkaMaxRetryWait
+                }
+                break
+            }
+            delay = kafkaRetryDelay
+        }
+    }
+}
+
+// pendingCount returns the number of records waiting to be acknowledged
+func (p *kafkaProducer) pendingCount() int {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    return len(p.pending)
+}
+
+// flush sends the oldest buffered records and removes the acknowledged or
+// rejected ones from the buffer. It returns an error if some records must be
+// retried.
+func (p *kafkaProducer) flush() error {
+    p.mutex.Lock()
+    n := len(p.pending)
+    if n > kafkaMaxBatchRecords {
+        n = kafkaMaxBatchRecords
+    }
+    batch := make([]*kafkaRecord, n)
+    copy(batch, p.pending)
+    p.mutex.Unlock()
+
+    if p.partitions == nil {
+        if err := p.refreshMetadata(); err != nil {
+            return err
+        }
+    }
+    // Group the records by partition, keeping their order
+    type partitionRecords struct {
+        kafkaPartition
+        records []*kafkaRecord
+    }
+    var groups []*partitionRecords
+    index := make(map[kafkaPartition]*partitionRecords)
+    var retry []*kafkaRecord
+    var firstErr error
+    for _, rec := range batch {
+        parts := p.partitions[rec.topic]
+        if len(parts) == 0 {
+            retry = append(retry, rec)
+            if firstErr == nil {
+                firstErr = fmt.Errorf("no partition available for topic %s", rec.topic)
+            }
+            continue
+        }
+        kp := parts[kafkaPartitionFor(rec.key, len(parts))]
+        g, ok := index[kp]
+        if !ok {
+            g = &partitionRecords{kafkaPartition: kp}
+            index[kp] = g
+            groups = append(groups, g)
+        }
+        g.records = append(g.records, rec)
+    }
+    // Send one request per leader
+    byLeader := make(map[int32][]*partitionRecords)
+    var leaders []int32
+    for _, g := range groups {
+        if _, ok := byLeader[g.leader]; !ok {
+            leaders = append(leaders, g.leader)
+        }
+        byLeader[g.leader] = append(byLeader[g.leader], g)
+    }
+    var produced, failed int
+    for _, leader := range leaders {
+        gs := byLeader[leader]
+        var parts []kafkaPartition
+        var records [][]*kafkaRecord
+        for _, g := range gs {
+            parts = append(parts, g.kafkaPartition)
+            records = append(records, g.records)
+        }
+        codes, err := p.produce(leader, parts, records)
+        for i, g := range gs {
+            var code int16
+            switch {
+            case err != nil:
+                code = -1
+            default:
+                code = codes[i]
+            }
+            switch {
+            case code == 0:
+                produced += len(g.records)
+            case kafkaFatalErrors[code] != "":
+                failed += len(g.records)
+                logger.Error("Kafka rejected records", "submodule", "kafka", "topic", g.topic, "partition", g.partition,
+                    "error", kafkaFatalErrors[code], "records", len(g.records))
+            default:
+                retry = append(retry, g.records...)
+                if firstErr == nil {
+                    firstErr = err
+                    if err == nil {
+                        firstErr = fmt.Errorf("error code %d on %s/%d", code, g.topic, g.partition)
+                    }
+                }
+            }
+        }
+    }
+    // Retried records are put back in front of the buffer, in their
+    // original order, so that the order of each partition is kept.
+    retried := make(map[*kafkaRecord]bool, len(retry))
+    for _, rec := range retry {
+        retried[rec] = true
+    }
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    rest := p.pending[n:]
+    p.pending = make([]*kafkaRecord, 0, len(retry)+len(rest))
+    for _, rec := range batch {
+        if retried[rec] {
+            p.pending = append(p.pending, rec)
+        }
+    }
+    p.pending = append(p.pending, rest...)
+    p.produced += produced
+    p.failed += failed
+    if produced > 0 {
+        p.lastProducedAt = time.Now().UTC()
+    }
+    if firstErr == nil {
+        p.lastError = ""
+    }
+    return firstErr
+}
+
+// produce sends the given records to the given leader and returns the error
+// code of each partition.
+func (p *kafkaProducer) produce(leader int32, parts []kafkaPartition, records [][]*kafkaRecord) ([]int16, error) {
+    addr, ok := p.brokers[leader]
+    if !ok {
+        return nil, fmt.Errorf("unknown Kafka broker %d", leader)
+    }
+    // Partitions are grouped by topic in the request
+    var topics []string
+    byTopic := make(map[string][]int)
+    for i, kp := range parts {
+        if _, ok := byTopic[kp.topic]; !ok {
+            topics = append(topics, kp.topic)
+        }
+        byTopic[kp.topic] = append(byTopic[kp.topic], i)
+    }
+    var req kafkaEncoder
+    req.nullableString(nil)
+    req.int16(kafkaAcksAll)
+    req.int32(int32(kafkaRequestTimeout / time.Millisecond))
+    req.int32(int32(len(topics)))
+    for _, t := range topics {
+        req.string(t)
+        req.int32(int32(len(byTopic[t])))
+        for _, i := range byTopic[t] {
+            req.int32(parts[i].partition)
+            req.bytes(kafkaRecordBatch(records[i]))
+        }
+    }
+    resp, err := p.roundTrip(addr, kafkaAPIProduce, kafkaProduceVersion, req.buf)
+    if err != nil {
+        return nil, err
+    }
+    codes := make([]int16, len(parts))
+    for i := range codes {
+        codes[i] = -1
+    }
+    d := kafkaDecoder{data: resp}
+    for nt := d.int32(); nt > 0 && d.err == nil; nt-- {
+        topic := d.string()
+        for np := d.int32(); np > 0 && d.err == nil; np-- {
+            partition := d.int32()
+            code := d.int16()
+            d.int64() // base offset
+            d.int64() // log append time
+            for _, i := range byTopic[topic] {
+                if parts[i].partition == partition {
+                    codes[i] = code
+                }
+            }
+        }
+    }
+    if d.err != nil {
+        p.closeConn(addr)
+        return nil, fmt.Errorf("unable to read Kafka produce response: %s", d.err)
+    }
+    return codes, nil
+}
+
+// refreshMetadata gets the brokers and the partition leaders of the topics
+// from the first configured or known broker that answers.
+func (p *kafkaProducer) refreshMetadata() error {
+    var topics []string
+    for _, t := range []string{p.config.EventsTopic, p.config.AuditTopic} {
+        if t != "" {
+            topics = append(topics, t)
+        }
+    }
+    var req kafkaEncoder
+    req.int32(int32(len(topics)))
+    for _, t := range topics {
+        req.string(t)
+    }
+    req.bool(true)
+    addrs := append([]string{}, p.config.Brokers...)
+    for _, a := range p.brokers {
+        addrs = append(addrs, a)
+    }
+    var lastErr error
+    for _, addr := range addrs {
+        resp, err := p.roundTrip(addr, kafkaAPIMetadata, kafkaMetadataVersion, req.buf)
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        brokers := make(map[int32]string)
+        partitions := make(map[string][]kafkaPartition)
+        d := kafkaDecoder{data: resp}
+        d.int32() // throttle time
+        for nb := d.int32(); nb > 0 && d.err == nil; nb-- {
+            id := d.int32()
+            host := d.string()
+            port := d.int32()
+            d.nullableString() // rack
+            brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+        }
+        d.nullableString() // cluster ID
+        d.int32()          // controller ID
+        for nt := d.int32(); nt > 0 && d.err == nil; nt-- {
+            code := d.int16()
+            name := d.string()
+            d.bool() // internal
+            var parts []kafkaPartition
+            for np := d.int32(); np > 0 && d.err == nil; np-- {
+                d.int16() // partition error code
+                kp := kafkaPartition{topic: name, partition: d.int32(), leader: d.int32()}
+                d.int32Array() // replicas
+                d.int32Array() // ISR
+                parts = append(parts, kp)
+            }
+            if code != 0 {
+                lastErr = fmt.Errorf("metadata error code %d for topic %s", code, name)
+                continue
+            }
+            // Partitions must be indexed by their number for partitioning
+            sorted := make([]kafkaPartition, len(parts))
+            complete := true
+            for _, kp := range parts {
+                if kp.partition < 0 || int(kp.partition) >= len(parts) || kp.leader == kafkaNoPartitionLeader {
+                    complete = false
+                    break
+                }
+                sorted[kp.partition] = kp
+            }
+            if !complete {
+                lastErr = fmt.Errorf("some partitions of topic %s have no leader", name)
+                continue
+            }
+            partitions[name] = sorted
+        }
+        if d.err != nil {
+            p.closeConn(addr)
+            lastErr = fmt.Errorf("unable to read Kafka metadata response: %s", d.err)
+            continue
+        }
+        p.brokers = brokers
+        p.partitions = partitions
+        return nil
+    }
+    if lastErr == nil {
+        lastErr = errors.New("no Kafka broker available")
+    }
+    return lastErr
+}
+
+// roundTrip sends a request to the broker at addr and returns the response body
+func (p *kafkaProducer) roundTrip(addr string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+    kc, ok := p.conns[addr]
+    if !ok {
+        conn, err := net.DialTimeout("tcp", addr, kafkaDialTimeout)
+        if err != nil {
+            return nil, err
+        }
+        kc = &kafkaConn{conn: conn, reader: bufio.NewReader(conn)}
+        p.conns[addr] = kc
+    }
+    resp, err := kc.roundTrip(p.config.ClientID, apiKey, apiVersion, body)
+    if err != nil {
+        p.closeConn(addr)
+        return nil, err
+    }
+    return resp, nil
+}
+
+// closeConn closes the connection to the broker at addr
+func (p *kafkaProducer) closeConn(addr string) {
+    if kc, ok := p.conns[addr]; ok {
+        _ = kc.conn.Close()
+        delete(p.conns, addr)
+    }
+}
+
+// view returns the JSON representation of this producer
+func (p *kafkaProducer) view() map[string]interface{} {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    lastProduced := ""
+    if !p.lastProducedAt.IsZero() {
+        lastProduced = p.lastProducedAt.Format(time.RFC3339)
+    }
+    return map[string]interface{}{
+        "enabled":  true,
+        "brokers":  p.config.Brokers,
+        "clientId": p.config.ClientID,
+        "topics": map[string]interface{}{
+            "events": p.config.EventsTopic,
+            "audit":  p.config.AuditTopic,
+        },
+        "format":     p.config.Format,
+        "bufferSize": p.config.BufferSize,
+        "stats": map[string]interface{}{
+            "produced":       p.produced,
+            "pending":        len(p.pending),
+            "failed":         p.failed,
+            "dropped":        p.dropped,
+            "retries":        p.retries,
+            "lastError":      p.lastError,
+            "lastProducedAt": lastProduced,
+        },
+    }
+}
+
+// A kafkaConn is a connection to a Kafka broker
+type kafkaConn struct {
+    conn          net.Conn
+    reader        *bufio.Reader
+    correlationID int32
+}
+
+// roundTrip sends a request and waits for its response
+func (kc *kafkaConn) roundTrip(clientID string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+    kc.correlationID++
+    var req kafkaEncoder
+    req.int32(0) // size, set below
+    req.int16(apiKey)
+    req.int16(apiVersion)
+    req.int32(kc.correlationID)
+    req.string(clientID)
+    req.buf = append(req.buf, body...)
+    binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+    _ = kc.conn.SetDeadline(time.Now().Add(kafkaRequestTimeout + kafkaDialTimeout))
+    if _, err := kc.conn.Write(req.buf); err != nil {
+        return nil, err
+    }
+    var header [8]byte
+    if _, err := io.ReadFull(kc.reader, header[:]); err != nil {
+        return nil, err
+    }
+    size := int32(binary.BigEndian.Uint32(header[:4]))
+    if size < 4 || size > kafkaMaxResponseBytes {
+        return nil, fmt.Errorf("invalid Kafka response size %d", size)
+    }
+    if id := int32(binary.BigEndian.Uint32(header[4:])); id != kc.correlationID {
+        return nil, fmt.Errorf("unexpected Kafka correlation ID %d instead of %d", id, kc.correlationID)
+    }
+    resp := make([]byte, size-4)
+    if _, err := io.ReadFull(kc.reader, resp); err != nil {
+        return nil, err
+    }
+    return resp, nil
+}
+
+// kafkaPartitionFor returns the partition of the given key among n, with the
+// murmur2 hash of the Java client, so that other producers of the same
+// simulation records use the same partitions.
+func kafkaPartitionFor(key []byte, n int) int {
+    return int(uint32(kafkaMurmur2(key))&0x7fffffff) % n
+}
+
+// kafkaMurmur2 is the murmur2 hash of the Kafka Java client
+func kafkaMurmur2(data []byte) int32 {
+    const (
+        seed uint32 = 0x9747b28c
+        m    uint32 = 0x5bd1e995
+        r           = 24
+    )
+    length := len(data)
+    h := seed ^ uint32(length)
+    for i := 0; i+4 <= length; i += 4 {
+        k := binary.LittleEndian.Uint32(data[i:])
+        k *= m
+        k ^= k >> r
+        k *= m
+        h *= m
+        h ^= k
+    }
+    tail := length &^ 3
+    switch length % 4 {
+    case 3:
+        h ^= uint32(data[tail+2]) << 16
+        fallthrough
+    case 2:
+        h ^= uint32(data[tail+1]) << 8
+        fallthrough
+    case 1:
+        h ^= uint32(data[tail])
+        h *= m
+    }
+    h ^= h >> 13
+    h *= m
+    h ^= h >> 15
+    return int32(h)
+}
+
+// kafkaRecordBatch returns the uncompressed v2 record batch of the given records
+func kafkaRecordBatch(records []*kafkaRecord) []byte {
+    first := records[0].timestamp.UnixNano() / int64(time.Millisecond)
+    maxTimestamp := first
+    var recs kafkaEncoder
+    for i, rec := range records {
+        ts := rec.timestamp.UnixNano() / int64(time.Millisecond)
+        if ts > maxTimestamp {
+            maxTimestamp = ts
+        }
+        var r kafkaEncoder
+        r.int8(0) // attributes
+        r.varint(ts - first)
+        r.varint(int64(i))
+        r.varintBytes(rec.key)
+        r.varintBytes(rec.value)
+        r.varint(int64(len(rec.headers)))
+        for _, h := range rec.headers {
+            r.varintBytes([]byte(h.key))
+            r.varintBytes(h.value)
+        }
+        recs.varintBytes(r.buf)
+    }
+    var body kafkaEncoder
+    body.int16(0) // attributes: no compression, create time
+    body.int32(int32(len(records) - 1))
+    body.int64(first)
+    body.int64(maxTimestamp)
+    body.int64(-1) // producer ID
+    body.int16(-1) // producer epoch
+    body.int32(-1) // base sequence
+    body.int32(int32(len(records)))
+    body.buf = append(body.buf, recs.buf...)
+
+    var batch kafkaEncoder
+    batch.int64(0) // base offset
+    batch.int32(int32(4 + 1 + 4 + len(body.buf)))
+    batch.int32(-1) // partition leader epoch
+    batch.int8(kafkaRecordBatchVersion)
+    batch.int32(int32(crc32.Checksum(body.buf, crc32c)))
+    batch.buf = append(batch.buf, body.buf...)
+    return batch.buf
+}
+
+// kafkaEncoder writes the primitive types of the Kafka protocol
+type kafkaEncoder struct {
+    buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) { e.buf = append(e.buf, byte(v)) }
+
+func (e *kafkaEncoder) int16(v int16) {
+    e.buf = append(e.buf, byte(uint16(v)>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+    var b [4]byte
+    binary.BigEndian.PutUint32(b[:], uint32(v))
+    e.buf = append(e.buf, b[:]...)
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+    var b [8]byte
+    binary.BigEndian.PutUint64(b[:], uint64(v))
+    e.buf = append(e.buf, b[:]...)
+}
+
+func (e *kafkaEncoder) bool(v bool) {
+    if v {
+        e.int8(1)
+        return
+    }
+    e.int8(0)
+}
+
+func (e *kafkaEncoder) string(s string) {
+    e.int16(int16(len(s)))
+    e.buf = append(e.buf, s...)
+}
+
+// nullableString writes s, or a null string if s is nil
+func (e *kafkaEncoder) nullableString(s *string) {
+    if s == nil {
+        e.int16(-1)
+        return
+    }
+    e.string(*s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+    e.int32(int32(len(b)))
+    e.buf = append(e.buf, b...)
+}
+
+// varint writes v as a zig-zag variable length integer
+func (e *kafkaEncoder) varint(v int64) {
+    var b [binary.MaxVarintLen64]byte
+    e.buf = append(e.buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+// varintBytes writes b prefixed by its length as a varint, or a null value if b is nil
+func (e *kafkaEncoder) varintBytes(b []byte) {
+    if b == nil {
+        e.varint(-1)
+        return
+    }
+    e.varint(int64(len(b)))
+    e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder reads the primitive types of the Kafka protocol. After the
+// first error, all reads return zero values and err is set.
+type kafkaDecoder struct {
+    data []byte
+    off  int
+    err  error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+    if d.err != nil {
+        return nil
+    }
+    if n < 0 || d.off+n > len(d.data) {
+        d.err = io.ErrUnexpectedEOF
+        return nil
+    }
+    b := d.data[d.off : d.off+n]
+    d.off += n
+    return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+    if b := d.next(1); b != nil {
+        return int8(b[0])
+    }
+    return 0
+}
+
+func (d *kafkaDecoder) bool() bool { return d.int8() != 0 }
+
+func (d *kafkaDecoder) int16() int16 {
+    if b := d.next(2); b != nil {
+        return int16(binary.BigEndian.Uint16(b))
+    }
+    return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+    if b := d.next(4); b != nil {
+        return int32(binary.BigEndian.Uint32(b))
+    }
+    return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+    if b := d.next(8); b != nil {
+        return int64(binary.BigEndian.Uint64(b))
+    }
+    return 0
+}
+
+func (d *kafkaDecoder) string() string {
+    return string(d.next(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() *string {
+    n := d.int16()
+    if n < 0 {
+        return nil
+    }
+    s := string(d.next(int(n)))
+    return &s
+}
+
+func (d *kafkaDecoder) int32Array() []int32 {
+    n := d.int32()
+    if n < 0 || int(n) > len(d.data) {
+        n = 0
+    }
+    res := make([]int32, 0, n)
+    for i := int32(0); i < n && d.err == nil; i++ {
+        res = append(res, d.int32())
+    }
+    return res
+}
+
+// GET /api/kafka
+// Returns the configuration and the statistics of the Kafka producer.
+func serveKafka(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    res := map[string]interface{}{"enabled": false}
+    if p := currentKafkaProducer(); p != nil {
+        res = p.view()
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,312 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// kafkaTestRecord is a record received by the test broker
+type kafkaTestRecord struct {
+	topic     string
+	partition int32
+	key       string
+	value     []byte
+	headers   map[string]string
+}
+
+// decodeTestRecordBatch decodes a v2 record batch and checks its CRC
+func decodeTestRecordBatch(b []byte) ([]kafkaTestRecord, bool) {
+	d := kafkaDecoder{data: b}
+	d.int64()
+	length := d.int32()
+	d.int32()
+	magic := d.int8()
+	crc := uint32(d.int32())
+	valid := magic == 2 && int(length) == len(b)-12 && crc32.Checksum(b[d.off:], crc32c) == crc
+	d.int16()
+	d.int32()
+	d.int64()
+	d.int64()
+	d.int64()
+	d.int16()
+	d.int32()
+	n := d.int32()
+	rest := b[d.off:]
+	varint := func() int64 {
+		v, k := binary.Varint(rest)
+		rest = rest[k:]
+		return v
+	}
+	varBytes := func() []byte {
+		l := varint()
+		if l < 0 {
+			return nil
+		}
+		v := rest[:l]
+		rest = rest[l:]
+		return v
+	}
+	var res []kafkaTestRecord
+	for i := int32(0); i < n; i++ {
+		varint()
+		rest = rest[1:]
+		varint()
+		valid = valid && varint() == int64(i)
+		rec := kafkaTestRecord{key: string(varBytes()), value: varBytes(), headers: make(map[string]string)}
+		for nh := varint(); nh > 0; nh-- {
+			k := string(varBytes())
+			rec.headers[k] = string(varBytes())
+		}
+		res = append(res, rec)
+	}
+	return res, valid && len(rest) == 0
+}
+
+// kafkaTestBroker is a single node Kafka cluster answering Metadata and
+// Produce requests. The first produce request fails with
+// NOT_LEADER_FOR_PARTITION.
+type kafkaTestBroker struct {
+	listener   net.Listener
+	partitions map[string]int32
+
+	mutex    sync.Mutex
+	produces int
+	invalid  int
+	records  []kafkaTestRecord
+}
+
+func (kb *kafkaTestBroker) serve() {
+	for {
+		conn, err := kb.listener.Accept()
+		if err != nil {
+			return
+		}
+		go kb.handle(conn)
+	}
+}
+
+func (kb *kafkaTestBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		d := kafkaDecoder{data: req}
+		apiKey := d.int16()
+		d.int16()
+		correlationID := d.int32()
+		d.nullableString()
+		var resp kafkaEncoder
+		resp.int32(0)
+		resp.int32(correlationID)
+		switch apiKey {
+		case kafkaAPIMetadata:
+			host, port, _ := net.SplitHostPort(kb.listener.Addr().String())
+			p, _ := strconv.Atoi(port)
+			resp.int32(0)
+			resp.int32(1)
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(int32(p))
+			resp.nullableString(nil)
+			resp.nullableString(nil)
+			resp.int32(1)
+			nt := d.int32()
+			resp.int32(nt)
+			for ; nt > 0; nt-- {
+				topic := d.string()
+				resp.int16(0)
+				resp.string(topic)
+				resp.bool(false)
+				resp.int32(kb.partitions[topic])
+				for i := int32(0); i < kb.partitions[topic]; i++ {
+					resp.int16(0)
+					resp.int32(i)
+					resp.int32(1)
+					resp.int32(1)
+					resp.int32(1)
+					resp.int32(1)
+					resp.int32(1)
+				}
+			}
+		case kafkaAPIProduce:
+			kb.mutex.Lock()
+			kb.produces++
+			code := int16(0)
+			if kb.produces == 1 {
+				code = 6
+			}
+			d.nullableString()
+			d.int16()
+			d.int32()
+			nt := d.int32()
+			resp.int32(nt)
+			for ; nt > 0; nt-- {
+				topic := d.string()
+				resp.string(topic)
+				np := d.int32()
+				resp.int32(np)
+				for ; np > 0; np-- {
+					partition := d.int32()
+					records, valid := decodeTestRecordBatch(d.next(int(d.int32())))
+					if !valid {
+						kb.invalid++
+					}
+					if code == 0 {
+						for _, rec := range records {
+							rec.topic, rec.partition = topic, partition
+							kb.records = append(kb.records, rec)
+						}
+					}
+					resp.int32(partition)
+					resp.int16(code)
+					resp.int64(0)
+					resp.int64(-1)
+				}
+			}
+			resp.int32(0)
+			kb.mutex.Unlock()
+		}
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+	}
+}
+
+// received returns the records received on the given topic for the given event
+func (kb *kafkaTestBroker) received(topic, event string) []kafkaTestRecord {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+	var res []kafkaTestRecord
+	for _, rec := range kb.records {
+		if rec.topic == topic && rec.headers["ts2-event"] == event {
+			res = append(res, rec)
+		}
+	}
+	return res
+}
+
+func TestKafka(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the Kafka producer", t, func() {
+		Convey("Keys should be partitioned as by the Java client", func() {
+			So(kafkaMurmur2([]byte("21")), ShouldEqual, -973932308)
+			So(kafkaMurmur2([]byte("foobar")), ShouldEqual, -790332482)
+			So(kafkaMurmur2([]byte("a-little-bit-long-string")), ShouldEqual, -985981536)
+			So(kafkaMurmur2([]byte("a-little-bit-longer-string")), ShouldEqual, -1486304829)
+			So(kafkaMurmur2([]byte("lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8")), ShouldEqual, -58897971)
+			So(kafkaMurmur2([]byte("abc")), ShouldEqual, 479470107)
+			for _, key := range []string{"default", "exercise1", ""} {
+				p := kafkaPartitionFor([]byte(key), 3)
+				So(p, ShouldBeBetweenOrEqual, 0, 2)
+			}
+		})
+		Convey("Record batches should be valid", func() {
+			now := time.Now()
+			batch := kafkaRecordBatch([]*kafkaRecord{
+				{key: []byte("default"), value: []byte("{}"), timestamp: now},
+				{key: []byte("default"), value: []byte("[]"), headers: []kafkaHeader{{key: "h", value: []byte("v")}}, timestamp: now.Add(time.Second)},
+			})
+			records, valid := decodeTestRecordBatch(batch)
+			So(valid, ShouldBeTrue)
+			So(records, ShouldHaveLength, 2)
+			So(records[1].key, ShouldEqual, "default")
+			So(string(records[1].value), ShouldEqual, "[]")
+			So(records[1].headers, ShouldResemble, map[string]string{"h": "v"})
+		})
+		Convey("Configuration should be validated", func() {
+			kc := DefaultKafkaConfig()
+			So(SetKafkaConfig(kc), ShouldNotBeNil)
+			kc.Brokers = []string{"localhost"}
+			So(SetKafkaConfig(kc), ShouldNotBeNil)
+			kc.Brokers = []string{"localhost:9092"}
+			kc.Format = "xml"
+			So(SetKafkaConfig(kc), ShouldNotBeNil)
+			kc.Format = "json"
+			kc.EventsTopic = "ts2 events"
+			So(SetKafkaConfig(kc), ShouldNotBeNil)
+			So(currentKafkaProducer(), ShouldBeNil)
+		})
+		Convey("Events and audit entries should be delivered at least once", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			kb := &kafkaTestBroker{listener: l, partitions: map[string]int32{"ts2.events": 3, "ts2.audit": 1}}
+			go kb.serve()
+			retryDelay := kafkaRetryDelay
+			kafkaRetryDelay = 10 * time.Millisecond
+			kc := DefaultKafkaConfig()
+			kc.Brokers = []string{l.Addr().String()}
+			So(SetKafkaConfig(kc), ShouldBeNil)
+			defer func() {
+				kafkaMutex.Lock()
+				kafka = nil
+				kafkaMutex.Unlock()
+				kafkaRetryDelay = retryDelay
+			}()
+			startKafkaProducer()
+
+			for i := uint64(1); i <= 3; i++ {
+				hub.streamKafkaEvent(&sequencedEvent{
+					seq:    i,
+					name:   "kafkaTest",
+					object: RawJSON(`{"id":"` + strconv.FormatUint(i, 10) + `"}`),
+				})
+			}
+			audits.append(AuditEntry{Event: "KAFKA_TEST", Category: "system", Severity: "INFO"})
+			timeout := time.Now().Add(5 * time.Second)
+			for time.Now().Before(timeout) && (len(kb.received("ts2.events", "kafkaTest")) < 3 || len(kb.received("ts2.audit", "KAFKA_TEST")) < 1) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			events := kb.received("ts2.events", "kafkaTest")
+			So(events, ShouldHaveLength, 3)
+			for i, rec := range events {
+				So(rec.key, ShouldEqual, "default")
+				So(rec.partition, ShouldEqual, kafkaPartitionFor([]byte("default"), 3))
+				So(rec.headers["ts2-seq"], ShouldEqual, strconv.Itoa(i+1))
+				So(rec.headers["content-type"], ShouldEqual, "application/json")
+				var value map[string]interface{}
+				So(json.Unmarshal(rec.value, &value), ShouldBeNil)
+				So(value["simulation"], ShouldEqual, "default")
+				So(value["seq"], ShouldEqual, i+1)
+				So(value["object"], ShouldResemble, map[string]interface{}{"id": strconv.Itoa(i + 1)})
+			}
+			auditRecords := kb.received("ts2.audit", "KAFKA_TEST")
+			So(auditRecords, ShouldHaveLength, 1)
+			var value struct {
+				Simulation string     `json:"simulation"`
+				Entry      AuditEntry `json:"entry"`
+			}
+			So(json.Unmarshal(auditRecords[0].value, &value), ShouldBeNil)
+			So(value.Simulation, ShouldEqual, "default")
+			So(value.Entry.Category, ShouldEqual, "system")
+			kb.mutex.Lock()
+			So(kb.produces, ShouldBeGreaterThan, 1)
+			So(kb.invalid, ShouldEqual, 0)
+			kb.mutex.Unlock()
+			view := currentKafkaProducer().view()
+			So(view["stats"].(map[string]interface{})["retries"], ShouldBeGreaterThanOrEqualTo, 1)
+		})
+	})
+}
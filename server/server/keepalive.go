@@ -0,0 +1,143 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultIdleTimeout is the time after which a silent websocket client is
+	// disconnected.
+	defaultIdleTimeout = 60 * time.Second
+	// writeWait is the time allowed to write a message to a client.
+	writeWait = 10 * time.Second
+)
+
+var (
+	// idleTimeout is the time after which a websocket client that neither
+	// sent a message nor answered a ping is disconnected. Zero disables
+	// pings and idle timeouts.
+	idleTimeout    = defaultIdleTimeout
+	keepaliveMutex sync.RWMutex
+)
+
+// SetIdleTimeout sets the time after which a websocket client that neither
+// sent a message nor answered a ping is disconnected. Clients are pinged at
+// 9/10 of this interval. Zero disables pings and idle timeouts.
+func SetIdleTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("idle timeout must be positive")
+	}
+	if timeout > 0 && timeout < time.Second {
+		return fmt.Errorf("idle timeout must be at least 1s")
+	}
+	keepaliveMutex.Lock()
+	defer keepaliveMutex.Unlock()
+	idleTimeout = timeout
+	return nil
+}
+
+// currentIdleTimeout returns the idle timeout of websocket clients, or zero
+// if idle timeouts are disabled.
+func currentIdleTimeout() time.Duration {
+	keepaliveMutex.RLock()
+	defer keepaliveMutex.RUnlock()
+	return idleTimeout
+}
+
+// connectionStats counts the websocket connections of the server since it
+// started. All fields must be accessed atomically.
+type connectionStats struct {
+	opened        int64
+	closed        int64
+	idleTimeouts  int64
+	pingsSent     int64
+	pongsReceived int64
+	writeErrors   int64
+	// rejected counts the connections refused because the server had
+	// reached its maximum number of clients
+	rejected int64
+}
+
+var connStats connectionStats
+
+// report returns the health metrics of websocket connections
+func (cs *connectionStats) report() map[string]interface{} {
+	opened := atomic.LoadInt64(&cs.opened)
+	closed := atomic.LoadInt64(&cs.closed)
+	return map[string]interface{}{
+		"active":        opened - closed,
+		"opened":        opened,
+		"closed":        closed,
+		"idleTimeouts":  atomic.LoadInt64(&cs.idleTimeouts),
+		"pingsSent":     atomic.LoadInt64(&cs.pingsSent),
+		"pongsReceived": atomic.LoadInt64(&cs.pongsReceived),
+		"writeErrors":   atomic.LoadInt64(&cs.writeErrors),
+		"rejected":      atomic.LoadInt64(&cs.rejected),
+	}
+}
+
+// startKeepalive sets the read deadline of the connection and extends it
+// each time the client answers a ping. Reads fail once the client has been
+// silent for the idle timeout, which closes the connection.
+func (conn *connection) startKeepalive() {
+	if conn.idleTimeout == 0 {
+		return
+	}
+	conn.extendReadDeadline()
+	conn.SetPongHandler(func(string) error {
+		atomic.AddInt64(&connStats.pongsReceived, 1)
+		conn.extendReadDeadline()
+		return nil
+	})
+}
+
+// extendReadDeadline gives the client another idle timeout to send a message
+// or answer a ping.
+func (conn *connection) extendReadDeadline() {
+	if conn.idleTimeout == 0 {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(conn.idleTimeout))
+}
+
+// pingPeriod returns the interval at which the client is pinged, or zero if
+// idle timeouts are disabled.
+func (conn *connection) pingPeriod() time.Duration {
+	return conn.idleTimeout * 9 / 10
+}
+
+// ping sends a ping to the client.
+func (conn *connection) ping() error {
+	atomic.AddInt64(&connStats.pingsSent, 1)
+	return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+// isTimeout returns true if err is the result of the read deadline expiring
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeepalive(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing websocket keepalive", t, func() {
+		Convey("Idle timeout should be validated", func() {
+			So(SetIdleTimeout(-time.Second), ShouldNotBeNil)
+			So(SetIdleTimeout(100*time.Millisecond), ShouldNotBeNil)
+			So(SetIdleTimeout(0), ShouldBeNil)
+			So(currentIdleTimeout(), ShouldEqual, 0)
+			So(SetIdleTimeout(defaultIdleTimeout), ShouldBeNil)
+		})
+		Convey("Clients answering pings should be kept", func() {
+			So(SetIdleTimeout(time.Second), ShouldBeNil)
+			defer SetIdleTimeout(defaultIdleTimeout)
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			pongs := atomic.LoadInt64(&connStats.pongsReceived)
+			// Reading answers pings, but no message is expected
+			_ = c.SetReadDeadline(time.Now().Add(2500 * time.Millisecond))
+			_, _, err := c.ReadMessage()
+			So(err, ShouldNotBeNil)
+			So(err.(net.Error).Timeout(), ShouldBeTrue)
+			So(atomic.LoadInt64(&connStats.pongsReceived), ShouldBeGreaterThan, pongs)
+		})
+		Convey("Silent clients should be disconnected", func() {
+			So(SetIdleTimeout(time.Second), ShouldBeNil)
+			defer SetIdleTimeout(defaultIdleTimeout)
+			timeouts := atomic.LoadInt64(&connStats.idleTimeouts)
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "server", "addListener", `{"event": "clock"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			listeners := hub.listenerCount()
+			// Do not answer pings
+			c.SetPingHandler(func(string) error { return nil })
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var err error
+			for err == nil {
+				_, _, err = c.ReadMessage()
+			}
+			So(isTimeout(err), ShouldBeFalse)
+			So(atomic.LoadInt64(&connStats.idleTimeouts), ShouldEqual, timeouts+1)
+			time.Sleep(100 * time.Millisecond)
+			So(hub.listenerCount(), ShouldEqual, listeners-1)
+		})
+		Convey("Clients above the maximum should be refused", func() {
+			So(SetMaxClients(-1), ShouldNotBeNil)
+			c := clientDial(t)
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+			So(SetMaxClients(int(atomic.LoadInt64(&activeClients))), ShouldBeNil)
+			defer SetMaxClients(0)
+			rejected := atomic.LoadInt64(&connStats.rejected)
+			_, resp, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:22222/ws", nil)
+			So(err, ShouldNotBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(atomic.LoadInt64(&connStats.rejected), ShouldEqual, rejected+1)
+
+			So(SetMaxClients(0), ShouldBeNil)
+			c2 := clientDial(t)
+			defer c2.Close()
+			So(register(t, c2, Client, "", "client-secret"), ShouldBeNil)
+		})
+		Convey("Connection metrics should be available through the HTTP API", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/connections")
+			So(err, ShouldBeNil)
+			var report map[string]interface{}
+			So(json.NewDecoder(res.Body).Decode(&report), ShouldBeNil)
+			So(report["opened"], ShouldBeGreaterThan, 0)
+			So(report["idleTimeouts"], ShouldBeGreaterThan, 0)
+			So(report["idleTimeoutSeconds"], ShouldEqual, 60)
+			So(report["simulations"], ShouldNotBeEmpty)
+		})
+	})
+}
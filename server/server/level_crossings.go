@@ -0,0 +1,166 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// levelCrossingFailureRequest is the body of a level crossing failure request,
+// from HTTP or from the hub. The crossing is only repaired on request if
+// RepairMinutes is not given.
+type levelCrossingFailureRequest struct {
+    LevelCrossingID string `json:"levelCrossingId"`
+    Mode            string `json:"mode"`
+    Reason          string `json:"reason"`
+    RepairMinutes   *int   `json:"repairMinutes"`
+}
+
+// failLevelCrossing makes the barriers of the level crossing of fr malfunction
+// in the simulation s
+func failLevelCrossing(s *simulation.Simulation, fr levelCrossingFailureRequest) (*simulation.LevelCrossingItem, error) {
+    lc, ok := s.TrackItems[fr.LevelCrossingID].(*simulation.LevelCrossingItem)
+    if !ok {
+        return nil, fmt.Errorf("unknown level crossing: %s", fr.LevelCrossingID)
+    }
+    mode, err := simulation.ParseLevelCrossingFailureMode(strings.ToUpper(fr.Mode))
+    if err != nil {
+        return nil, err
+    }
+    var repairIn time.Duration
+    if fr.RepairMinutes != nil {
+        if *fr.RepairMinutes < 0 {
+            return nil, fmt.Errorf("repairMinutes must be positive")
+        }
+        repairIn = time.Duration(*fr.RepairMinutes) * time.Minute
+    }
+    cause := fr.Reason
+    if cause == "" {
+        cause = "Manual failure"
+    }
+    lc.Fail(mode, cause, repairIn)
+    return lc, nil
+}
+
+// operateLevelCrossing applies the given manual operation ("close", "open" or
+// "auto") to the level crossing lc.
+func operateLevelCrossing(lc *simulation.LevelCrossingItem, action string) error {
+    switch action {
+    case "close":
+        return lc.Close()
+    case "open":
+        return lc.Open()
+    case "auto":
+        lc.SetManual(false)
+        return nil
+    }
+    return fmt.Errorf("unknown level crossing action: %s", action)
+}
+
+// levelCrossingStatus returns the barriers state, malfunction state and
+// metrics of the given level crossing
+func levelCrossingStatus(id string, lc *simulation.LevelCrossingItem) map[string]interface{} {
+    status := "OPERATIONAL"
+    if lc.Failed() {
+        status = "FAILED"
+    }
+    var repairTime string
+    if !lc.RepairTime().IsZero() {
+        repairTime = lc.RepairTime().Format(time.RFC3339)
+    }
+    return map[string]interface{}{
+        "levelCrossingId":   id,
+        "name":              lc.Name(),
+        "state":             string(lc.State()),
+        "manual":            lc.IsManual(),
+        "trainApproaching":  lc.TrainApproaching(),
+        "malfunctionStatus": status,
+        "failureMode":       string(lc.FailureMode()),
+        "cause":             lc.FailureCause(),
+        "repairTime":        repairTime,
+        "metrics":           lc.Metrics(),
+    }
+}
+
+// GET /api/systems/level-crossings
+func serveLevelCrossings(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    crossings := []map[string]interface{}{}
+    for _, lc := range h.sim.LevelCrossings() {
+        crossings = append(crossings, levelCrossingStatus(lc.ID(), lc))
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"levelCrossings": crossings})
+}
+
+// GET /api/systems/level-crossings/{levelCrossingId}
+// POST /api/systems/level-crossings/{levelCrossingId}/{close|open|auto}
+// PUT /api/systems/level-crossings/{levelCrossingId}/failure
+// DELETE /api/systems/level-crossings/{levelCrossingId}/failure
+func serveLevelCrossing(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/systems/level-crossings/"), "/")
+    if len(parts) > 2 {
+        serveAPINotFound(w, r)
+        return
+    }
+    lcid := parts[0]
+    lc, ok := h.sim.TrackItems[lcid].(*simulation.LevelCrossingItem)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeLevelCrossingNotFound, "Level crossing not found", map[string]interface{}{"levelCrossingId": lcid})
+        return
+    }
+    var action string
+    if len(parts) == 2 {
+        action = parts[1]
+    }
+    switch {
+    case action == "" && r.Method == http.MethodGet:
+    case action == "failure" && r.Method == http.MethodPut:
+        var body levelCrossingFailureRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        body.LevelCrossingID = lcid
+        if _, err := failLevelCrossing(h.sim, body); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+    case action == "failure" && r.Method == http.MethodDelete:
+        lc.Repair()
+    case action == "close" || action == "open" || action == "auto":
+        if r.Method != http.MethodPost {
+            methodNotAllowed(w, r)
+            return
+        }
+        if err := operateLevelCrossing(lc, action); err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"levelCrossingId": lcid})
+            return
+        }
+    case action == "" || action == "failure":
+        methodNotAllowed(w, r)
+        return
+    default:
+        serveAPINotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(levelCrossingStatus(lcid, lc))
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// isEmpty returns true if this filter does not restrict anything.
+func (lf *ListenerFilter) isEmpty() bool {
+	return lf == nil || (len(lf.TrainIDs) == 0 && len(lf.PlaceCodes) == 0 && len(lf.SectionIDs) == 0)
+}
+
+// validate checks that the sections referenced by this filter exist in s.
+func (lf *ListenerFilter) validate(s *simulation.Simulation) error {
+	if lf == nil {
+		return nil
+	}
+	for _, sID := range lf.SectionIDs {
+		if _, ok := s.Section(sID); !ok {
+			return fmt.Errorf("unknown section %s in filter", sID)
+		}
+	}
+	return nil
+}
+
+// matches returns true if an event of simulation s relating to the given
+// subject passes this filter. A nil filter matches all events.
+func (lf *ListenerFilter) matches(s *simulation.Simulation, subject eventSubject) bool {
+	if lf.isEmpty() {
+		return true
+	}
+	if len(lf.TrainIDs) > 0 && !containsString(lf.TrainIDs, subject.trainID) {
+		return false
+	}
+	items := make([]simulation.TrackItem, 0, len(subject.itemIDs))
+	for _, id := range subject.itemIDs {
+		if ti, ok := s.TrackItems[id]; ok {
+			items = append(items, ti)
+		}
+	}
+	if len(lf.PlaceCodes) > 0 && !lf.matchesPlace(items) {
+		return false
+	}
+	if len(lf.SectionIDs) > 0 && !lf.matchesSection(s, items) {
+		return false
+	}
+	return true
+}
+
+// matchesPlace returns true if one of the given items belongs to one of the
+// places of this filter.
+func (lf *ListenerFilter) matchesPlace(items []simulation.TrackItem) bool {
+	for _, ti := range items {
+		if containsString(lf.PlaceCodes, placeCodeOf(ti)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSection returns true if one of the given items belongs to one of
+// the sections of this filter in simulation s.
+func (lf *ListenerFilter) matchesSection(s *simulation.Simulation, items []simulation.TrackItem) bool {
+	for _, sID := range lf.SectionIDs {
+		section, ok := s.Section(sID)
+		if !ok {
+			continue
+		}
+		for _, ti := range items {
+			if section.Contains(ti) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// An eventSubject is what the object of an event relates to, for filtering
+// purposes: a train and track items given by their IDs.
+type eventSubject struct {
+	trainID string
+	itemIDs []string
+}
+
+// eventSubject returns the subject of the given event of the simulation of h.
+//
+// Trains keep moving while the hub handles their events, so that their
+// position is read from their encoding: that of the event for the events of
+// trains, and that of their last trainChanged event for the events relating
+// to a train.
+func (h *Hub) eventSubject(e *simulation.Event) eventSubject {
+	switch o := e.Object.(type) {
+	case *simulation.Train:
+		if e.Data == nil {
+			// Event built outside the simulation
+			if ti := o.TrainHead.TrackItem(); ti != nil {
+				return eventSubject{o.ID(), []string{ti.ID()}}
+			}
+			return eventSubject{trainID: o.ID()}
+		}
+		var train struct {
+			TrainHead struct {
+				TrackItem string `json:"trackItem"`
+			} `json:"trainHead"`
+		}
+		if err := json.Unmarshal(e.Data, &train); err != nil || train.TrainHead.TrackItem == "" {
+			return eventSubject{trainID: o.ID()}
+		}
+		return eventSubject{o.ID(), []string{train.TrainHead.TrackItem}}
+	case *simulation.TrainCoupling:
+		return eventSubject{o.ID(), []string{o.TrackItemID}}
+	case *simulation.Route:
+		items := make([]string, 0, len(o.Positions))
+		for _, pos := range o.Positions {
+			if ti := pos.TrackItem(); ti != nil {
+				items = append(items, ti.ID())
+			}
+		}
+		return eventSubject{itemIDs: items}
+	case *simulation.Disruption:
+		return eventSubject{itemIDs: o.Items()}
+	case *simulation.SpeedRestriction:
+		return eventSubject{itemIDs: o.Items()}
+	case *simulation.Perturbation:
+		return h.trainSubject(o.TrainID)
+	case *simulation.Possession:
+		return eventSubject{itemIDs: o.Items()}
+	case simulation.TrackItem:
+		return eventSubject{itemIDs: []string{o.ID()}}
+	case *ChatMessage:
+		if o.Object == nil {
+			return eventSubject{}
+		}
+		attached, err := o.Object.object(h.sim)
+		if err != nil {
+			return eventSubject{}
+		}
+		if t, ok := attached.(*simulation.Train); ok {
+			return h.trainSubject(t.ID())
+		}
+		return h.eventSubject(&simulation.Event{Name: e.Name, Object: attached})
+	}
+	return eventSubject{}
+}
+
+// trainSubject returns the subject of the last trainChanged event of the
+// train with the given ID.
+func (h *Hub) trainSubject(trainID string) eventSubject {
+	h.lastEventsMutex.RLock()
+	defer h.lastEventsMutex.RUnlock()
+	if se, ok := h.lastEvents[registryEntry{eventName: simulation.TrainChangedEvent, id: trainID}]; ok {
+		return se.subject
+	}
+	return eventSubject{trainID: trainID}
+}
+
+// placeCodeOf returns the code of the place the given item belongs to, or
+// the code of the item itself if it is a place.
+func placeCodeOf(ti simulation.TrackItem) string {
+	if pl, ok := ti.(*simulation.Place); ok {
+		return pl.PlaceCode
+	}
+	if ti.Place() == nil {
+		return ""
+	}
+	return ti.Place().PlaceCode
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// logModules are the modules whose log level can be set separately. Records
+// of other modules are filtered with the default level.
+var logModules = []string{"hub", "http", "server", "simulation", "suggestions"}
+
+// logLevelNames are the names of the log levels, as given in the options
+// and the API
+var logLevelNames = map[log.Lvl]string{
+	log.LvlCrit:  "crit",
+	log.LvlError: "error",
+	log.LvlWarn:  "warn",
+	log.LvlInfo:  "info",
+	log.LvlDebug: "debug",
+}
+
+// logLevelState holds the minimum level of the records written for each
+// module
+type logLevelState struct {
+	mu       sync.RWMutex
+	defLevel log.Lvl
+	modules  map[string]log.Lvl
+}
+
+// logLevels are the log levels of the server
+var logLevels = &logLevelState{defLevel: log.LvlInfo, modules: make(map[string]log.Lvl)}
+
+// level returns the minimum level of the records of the given module
+func (ls *logLevelState) level(module string) log.Lvl {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if l, ok := ls.modules[module]; ok {
+		return l
+	}
+	return ls.defLevel
+}
+
+// LogLevels is the description of the log levels of the server
+type LogLevels struct {
+	Default string            `json:"default"`
+	Modules map[string]string `json:"modules"`
+}
+
+// current returns the levels of all the modules
+func (ls *logLevelState) current() LogLevels {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	res := LogLevels{Default: logLevelNames[ls.defLevel], Modules: make(map[string]string)}
+	for _, m := range logModules {
+		l, ok := ls.modules[m]
+		if !ok {
+			l = ls.defLevel
+		}
+		res.Modules[m] = logLevelNames[l]
+	}
+	return res
+}
+
+// set changes the default level if it is not empty and the levels of the
+// given modules. Nothing is changed if a module or a level is unknown.
+func (ls *logLevelState) set(levels LogLevels) error {
+	var defLevel log.Lvl
+	if levels.Default != "" {
+		var err error
+		if defLevel, err = log.LvlFromString(levels.Default); err != nil {
+			return fmt.Errorf("unknown log level: %s", levels.Default)
+		}
+	}
+	modules := make(map[string]log.Lvl, len(levels.Modules))
+	for m, name := range levels.Modules {
+		if !containsString(logModules, m) {
+			return fmt.Errorf("unknown log module: %s, expecting one of %s", m, strings.Join(logModules, ", "))
+		}
+		l, err := log.LvlFromString(name)
+		if err != nil {
+			return fmt.Errorf("unknown log level: %s", name)
+		}
+		modules[m] = l
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if levels.Default != "" {
+		ls.defLevel = defLevel
+	}
+	for m, l := range modules {
+		ls.modules[m] = l
+	}
+	return nil
+}
+
+// ParseLogLevels returns the module levels of a comma separated list of
+// module=level pairs, e.g. "hub=debug,http=warn".
+func ParseLogLevels(s string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid module level %q, expecting module=level", pair)
+		}
+		res[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return res, nil
+}
+
+// SetLogLevels sets the default log level and the levels of the given
+// modules. The levels of the other modules are unchanged.
+func SetLogLevels(defLevel log.Lvl, modules map[string]string) error {
+	return logLevels.set(LogLevels{Default: logLevelNames[defLevel], Modules: modules})
+}
+
+// LogLevelHandler returns a handler that writes to h the records whose level
+// is at least the level of their module.
+func LogLevelHandler(h log.Handler) log.Handler {
+	return log.FilterHandler(func(r *log.Record) bool {
+		return r.Lvl <= logLevels.level(logModule(r.Ctx))
+	}, h)
+}
+
+// logModule returns the module of a record with the given context, or an
+// empty string if it has none.
+func logModule(ctx []interface{}) string {
+	var module, submodule string
+	for i := 0; i+1 < len(ctx); i += 2 {
+		switch ctx[i] {
+		case "module":
+			module, _ = ctx[i+1].(string)
+		case "submodule":
+			submodule, _ = ctx[i+1].(string)
+		}
+	}
+	switch {
+	case submodule == "suggestions", submodule == "hub", submodule == "http":
+		return submodule
+	}
+	return module
+}
+
+// GET /api/admin/log-levels
+// PUT /api/admin/log-levels
+//
+// Returns or changes the log levels of the server. Both need the debug token,
+// see requireDebugAccess. PUT takes the same object as returned by GET, with
+// only the levels to change.
+func serveLogLevels(w http.ResponseWriter, r *http.Request) {
+	if !requireDebugAccess(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var levels LogLevels
+		if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+			badRequest(w, err)
+			return
+		}
+		if err := logLevels.set(levels); err != nil {
+			invalidParameter(w, err.Error(), nil)
+			return
+		}
+		changed := make([]string, 0, len(levels.Modules))
+		for m, l := range levels.Modules {
+			changed = append(changed, m+"="+l)
+		}
+		sort.Strings(changed)
+		logger.Info("Log levels changed", "submodule", "http", "remote", r.RemoteAddr, "default", levels.Default, "modules", strings.Join(changed, ","))
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(logLevels.current())
+}
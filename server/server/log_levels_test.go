@@ -0,0 +1,93 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestLogLevels(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing per-module log levels", t, func() {
+		defer func() {
+			logLevels.mu.Lock()
+			logLevels.defLevel = log.LvlInfo
+			logLevels.modules = make(map[string]log.Lvl)
+			logLevels.mu.Unlock()
+		}()
+		Convey("Records should be filtered with the level of their module", func() {
+			So(logModule([]interface{}{"module", "server", "submodule", "hub"}), ShouldEqual, "hub")
+			So(logModule([]interface{}{"module", "simulation", "submodule", "suggestions"}), ShouldEqual, "suggestions")
+			So(logModule([]interface{}{"module", "simulation"}), ShouldEqual, "simulation")
+			So(logModule([]interface{}{"module", "server", "submodule", "mqtt"}), ShouldEqual, "server")
+
+			levels, err := ParseLogLevels("hub=debug, http=error")
+			So(err, ShouldBeNil)
+			So(SetLogLevels(log.LvlWarn, levels), ShouldBeNil)
+			So(SetLogLevels(log.LvlWarn, map[string]string{"trains": "debug"}), ShouldNotBeNil)
+			So(SetLogLevels(log.LvlWarn, map[string]string{"hub": "verbose"}), ShouldNotBeNil)
+			_, err = ParseLogLevels("hub")
+			So(err, ShouldNotBeNil)
+
+			var written []string
+			l := log.New("module", "server")
+			l.SetHandler(LogLevelHandler(log.FuncHandler(func(r *log.Record) error {
+				written = append(written, r.Msg)
+				return nil
+			})))
+			l.Debug("hub debug", "submodule", "hub")
+			l.Warn("http warning", "submodule", "http")
+			l.Error("http error", "submodule", "http")
+			l.Info("server info")
+			l.Warn("server warning")
+			So(written, ShouldResemble, []string{"hub debug", "http error", "server warning"})
+		})
+		Convey("Log levels should be changed by admins through the HTTP API", func() {
+			token := "0123456789abcdef-debug"
+			So(SetDebugToken(token), ShouldBeNil)
+			request := func(method, user, body string) (*http.Response, LogLevels) {
+				req, err := http.NewRequest(method, "http://127.0.0.1:22222/api/v1/admin/log-levels", bytes.NewBufferString(body))
+				So(err, ShouldBeNil)
+				req.Header.Set("X-Debug-Token", token)
+				req.Header.Set("Authorization", "Bearer "+user+"-secret")
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				var levels LogLevels
+				if resp.StatusCode == http.StatusOK {
+					So(json.NewDecoder(resp.Body).Decode(&levels), ShouldBeNil)
+				}
+				return resp, levels
+			}
+			resp, levels := request(http.MethodGet, "admin", "")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(levels.Default, ShouldEqual, "info")
+			So(levels.Modules["suggestions"], ShouldEqual, "info")
+
+			resp, _ = request(http.MethodPut, "sam", `{"modules": {"hub": "debug"}}`)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			token = "wrong-token-0123456789"
+			resp, _ = request(http.MethodPut, "admin", `{"modules": {"hub": "debug"}}`)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			token = "0123456789abcdef-debug"
+			resp, _ = request(http.MethodPut, "admin", `{"modules": {"trains": "debug"}}`)
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			resp, levels = request(http.MethodPut, "admin", `{"default": "warn", "modules": {"suggestions": "debug"}}`)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(levels.Default, ShouldEqual, "warn")
+			So(levels.Modules["suggestions"], ShouldEqual, "debug")
+			So(levels.Modules["hub"], ShouldEqual, "warn")
+			So(logLevels.level("suggestions"), ShouldEqual, log.LvlDebug)
+		})
+	})
+}
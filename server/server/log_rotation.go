@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logBackupTimeFormat is the format of the time added to the name of the
+// rotated log files
+const logBackupTimeFormat = "20060102-150405.000"
+
+// LogRotation holds when log files are rotated and how long they are kept
+type LogRotation struct {
+	// MaxSize is the size in bytes above which the log file is rotated. Zero
+	// disables size based rotation.
+	MaxSize int64
+	// Interval is the time after which the log file is rotated. Zero
+	// disables time based rotation.
+	Interval time.Duration
+	// MaxAge is the time after which rotated files are deleted. Zero keeps
+	// them forever.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept. Zero keeps them all.
+	MaxBackups int
+}
+
+// A RotatingFile is a log file that is renamed with the current time and
+// replaced by a new file when it gets too large or too old.
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	rotation LogRotation
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// now returns the current time, for tests
+	now func() time.Time
+}
+
+// OpenRotatingFile opens or creates the log file at path, which is rotated
+// as given.
+func OpenRotatingFile(path string, rotation LogRotation) (*RotatingFile, error) {
+	if rotation.MaxSize < 0 || rotation.Interval < 0 || rotation.MaxAge < 0 || rotation.MaxBackups < 0 {
+		return nil, fmt.Errorf("log rotation settings cannot be negative")
+	}
+	rf := &RotatingFile{path: path, rotation: rotation, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open opens the log file for appending
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.openedAt = rf.now()
+	return nil
+}
+
+// Write writes p to the log file, after rotating it if needed
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	r := rf.rotation
+	if rf.size > 0 && ((r.MaxSize > 0 && rf.size+int64(len(p)) > r.MaxSize) || (r.Interval > 0 && rf.now().Sub(rf.openedAt) >= r.Interval)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// rotate renames the log file with the current time, opens a new one and
+// deletes the rotated files that are too old or too many.
+//
+// rf.mu must be held by the caller.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	backup := rf.backupName(rf.now())
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.removeOldBackups()
+	return nil
+}
+
+// backupName returns the name of the log file rotated at t, e.g.
+// ts2-20261016-150405.000.log for ts2.log.
+func (rf *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(rf.path)
+	return strings.TrimSuffix(rf.path, ext) + "-" + t.UTC().Format(logBackupTimeFormat) + ext
+}
+
+// backups returns the rotated files of the log file, most recent first, with
+// their rotation time.
+func (rf *RotatingFile) backups() ([]string, []time.Time, error) {
+	dir := filepath.Dir(rf.path)
+	ext := filepath.Ext(rf.path)
+	prefix := strings.TrimSuffix(filepath.Base(rf.path), ext) + "-"
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	type backup struct {
+		name string
+		t    time.Time
+	}
+	var list []backup
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(logBackupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		list = append(list, backup{filepath.Join(dir, name), t})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].t.After(list[j].t) })
+	names := make([]string, len(list))
+	times := make([]time.Time, len(list))
+	for i, b := range list {
+		names[i], times[i] = b.name, b.t
+	}
+	return names, times, nil
+}
+
+// removeOldBackups deletes the rotated files older than MaxAge and those
+// above MaxBackups. Errors are written to stderr since the log is being
+// rotated.
+func (rf *RotatingFile) removeOldBackups() {
+	r := rf.rotation
+	if r.MaxAge == 0 && r.MaxBackups == 0 {
+		return
+	}
+	names, times, err := rf.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to list rotated log files: %s\n", err)
+		return
+	}
+	for i, name := range names {
+		if (r.MaxBackups > 0 && i >= r.MaxBackups) || (r.MaxAge > 0 && rf.now().Sub(times[i]) > r.MaxAge) {
+			if err := os.Remove(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to remove rotated log file: %s\n", err)
+			}
+		}
+	}
+}
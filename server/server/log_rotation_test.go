@@ -0,0 +1,89 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogRotation(t *testing.T) {
+	Convey("Testing log file rotation", t, func() {
+		dir, err := ioutil.TempDir("", "ts2-logs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "ts2.log")
+		now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+		open := func(rotation LogRotation) *RotatingFile {
+			rf, err := OpenRotatingFile(path, rotation)
+			So(err, ShouldBeNil)
+			rf.now = func() time.Time { return now }
+			rf.openedAt = now
+			return rf
+		}
+		files := func() []string {
+			infos, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			var res []string
+			for _, info := range infos {
+				res = append(res, info.Name())
+			}
+			return res
+		}
+		line := []byte(strings.Repeat("x", 9) + "\n")
+
+		Convey("Rotation settings should be validated", func() {
+			_, err := OpenRotatingFile(path, LogRotation{MaxSize: -1})
+			So(err, ShouldNotBeNil)
+			_, err = OpenRotatingFile(filepath.Join(dir, "missing", "ts2.log"), LogRotation{})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Files should be rotated above their maximum size", func() {
+			rf := open(LogRotation{MaxSize: 25})
+			defer rf.Close()
+			for i := 0; i < 3; i++ {
+				_, err := rf.Write(line)
+				So(err, ShouldBeNil)
+			}
+			So(files(), ShouldResemble, []string{"ts2-20261016-120000.000.log", "ts2.log"})
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, string(line))
+			data, err = ioutil.ReadFile(filepath.Join(dir, "ts2-20261016-120000.000.log"))
+			So(err, ShouldBeNil)
+			So(data, ShouldHaveLength, 20)
+		})
+		Convey("Files should be rotated after the interval", func() {
+			rf := open(LogRotation{Interval: time.Hour})
+			defer rf.Close()
+			_, _ = rf.Write(line)
+			now = now.Add(30 * time.Minute)
+			_, _ = rf.Write(line)
+			So(files(), ShouldResemble, []string{"ts2.log"})
+			now = now.Add(30 * time.Minute)
+			_, _ = rf.Write(line)
+			So(files(), ShouldResemble, []string{"ts2-20261016-130000.000.log", "ts2.log"})
+		})
+		Convey("Old and extra rotated files should be deleted", func() {
+			for _, name := range []string{"ts2-20261001-120000.000.log", "ts2-20261015-120000.000.log", "ts2-20261016-110000.000.log", "other.log"} {
+				So(ioutil.WriteFile(filepath.Join(dir, name), line, 0644), ShouldBeNil)
+			}
+			rf := open(LogRotation{Interval: time.Hour, MaxAge: 7 * 24 * time.Hour, MaxBackups: 2})
+			defer rf.Close()
+			_, _ = rf.Write(line)
+			now = now.Add(time.Hour)
+			_, _ = rf.Write(line)
+			So(files(), ShouldResemble, []string{"other.log", "ts2-20261016-110000.000.log", "ts2-20261016-130000.000.log", "ts2.log"})
+			So(rf.Close(), ShouldBeNil)
+			_, err := rf.Write(line)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
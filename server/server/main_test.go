@@ -27,6 +27,7 @@ import (
 	"testing"
 
 	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
 	"github.com/ts2/ts2-sim-server/simulation"
 	log "gopkg.in/inconshreveable/log15.v2"
 )
@@ -44,9 +45,52 @@ func TestMain(m *testing.M) {
 		fmt.Println("Unable to load demo.json:", err)
 		os.Exit(1)
 	}
+	checkpoints, err := ioutil.TempDir("", "ts2-checkpoints")
+	if err != nil {
+		fmt.Println("Unable to create checkpoint directory:", err)
+		os.Exit(1)
+	}
+	SetCheckpointDir(checkpoints)
+	if err := SetServerConfig(testServerConfig()); err != nil {
+		fmt.Println("Unable to set the server configuration:", err)
+		os.Exit(1)
+	}
 	go Run(&s, "0.0.0.0", "22222")
 	s.Initialize()
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(checkpoints)
+	os.Exit(code)
+}
+
+// testServerConfig returns the configuration of the test server, in which
+// each test user has the token "<user>-secret".
+func testServerConfig() *ServerConfig {
+	c := DefaultServerConfig()
+	c.Users = []UserCredential{
+		{User: "admin", Role: RoleAdmin, Token: "admin-secret"},
+		{User: "alice", Role: RoleOperator, Token: "alice-secret"},
+		{User: "bob", Role: RoleOperator, Token: "bob-secret"},
+		{User: "carol", Role: RoleOperator, Token: "carol-secret"},
+		{User: "sam", Role: RoleSupervisor, Token: "sam-secret"},
+	}
+	return c
+}
+
+// loadDemoWith returns the demo simulation after patch, if not nil, has
+// changed its JSON document.
+func loadDemoWith(patch func(raw map[string]interface{})) *simulation.Simulation {
+	data, err := ioutil.ReadFile("../simulation/testdata/demo.json")
+	So(err, ShouldBeNil)
+	if patch != nil {
+		var raw map[string]interface{}
+		So(json.Unmarshal(data, &raw), ShouldBeNil)
+		patch(raw)
+		data, err = json.Marshal(raw)
+		So(err, ShouldBeNil)
+	}
+	var s simulation.Simulation
+	So(json.Unmarshal(data, &s), ShouldBeNil)
+	return &s
 }
 
 func clientDial(t *testing.T) *websocket.Conn {
@@ -60,7 +104,7 @@ func clientDial(t *testing.T) *websocket.Conn {
 
 // register dials to the server and logs the client in
 func register(t *testing.T, c *websocket.Conn, ct ClientType, mt ManagerType, token string) error {
-	loginRequest := RequestRegister{1234, "server", "register", ParamsRegister{ct, mt, token}}
+	loginRequest := RequestRegister{1234, "server", "register", ParamsRegister{ClientType: ct, ClientSubType: mt, Token: token}}
 	if err := c.WriteJSON(loginRequest); err != nil {
 		return err
 	}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+	// defaultMessagesLimit is the number of messages of the message log
+	// returned when no limit is given
+	defaultMessagesLimit = 100
+	// maxMessagesLimit is the largest number of messages of the message log
+	// returned at once
+	maxMessagesLimit = 1000
+)
+
+// A messagesQuery selects a page of the message log of a simulation
+type messagesQuery struct {
+	Levels []string `json:"levels"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+}
+
+// A messagesPage is a page of the message log of a simulation
+type messagesPage struct {
+	Items  []simulation.LoggedMessage `json:"items"`
+	Total  int                        `json:"total"`
+	Offset int                        `json:"offset"`
+	Limit  int                        `json:"limit"`
+}
+
+// messages returns the page of the message log of s selected by mq
+func (mq messagesQuery) messages(s *simulation.Simulation) (messagesPage, error) {
+	var types []simulation.MessageType
+	for _, level := range mq.Levels {
+		mt, err := simulation.ParseMessageLevel(level)
+		if err != nil {
+			return messagesPage{}, err
+		}
+		types = append(types, mt)
+	}
+	if mq.Offset < 0 {
+		return messagesPage{}, fmt.Errorf("offset must be positive")
+	}
+	if mq.Limit < 0 || mq.Limit > maxMessagesLimit {
+		return messagesPage{}, fmt.Errorf("limit must be between 1 and %d", maxMessagesLimit)
+	}
+	if mq.Limit == 0 {
+		mq.Limit = defaultMessagesLimit
+	}
+	items, total := s.MessageLogger.Query(types, mq.Offset, mq.Limit)
+	return messagesPage{Items: items, Total: total, Offset: mq.Offset, Limit: mq.Limit}, nil
+}
+
+// GET /api/messages?level=playerWarning,simulation&offset=0&limit=100
+//
+// Returns the messages of the message log of the simulation, oldest first,
+// with the number of messages of the given levels.
+func serveMessages(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	var mq messagesQuery
+	for _, l := range r.URL.Query()["level"] {
+		for _, level := range strings.Split(l, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				mq.Levels = append(mq.Levels, level)
+			}
+		}
+	}
+	for name, dest := range map[string]*int{"offset": &mq.Offset, "limit": &mq.Limit} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			invalidParameter(w, fmt.Sprintf("%s must be an integer", name), map[string]interface{}{name: v})
+			return
+		}
+		*dest = n
+	}
+	page, err := mq.messages(h.sim)
+	if err != nil {
+		invalidParameter(w, err.Error(), map[string]interface{}{"level": mq.Levels, "offset": mq.Offset, "limit": mq.Limit})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(page)
+}
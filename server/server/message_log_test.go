@@ -0,0 +1,73 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMessageLog(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the message log", t, func() {
+		So(RoleObserver.allows("message", "list"), ShouldBeTrue)
+		get := func(query string) (*http.Response, messagesPage) {
+			resp, err := http.Get("http://127.0.0.1:22222/api/messages" + query)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var page messagesPage
+			if resp.StatusCode == http.StatusOK {
+				So(json.NewDecoder(resp.Body).Decode(&page), ShouldBeNil)
+			}
+			return resp, page
+		}
+		Convey("Messages should be served over HTTP", func() {
+			resp, page := get("")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(page.Total, ShouldBeGreaterThanOrEqualTo, 2)
+			So(page.Limit, ShouldEqual, defaultMessagesLimit)
+			So(page.Items[0].MsgText, ShouldEqual, "Test message")
+
+			resp, page = get("?level=software&limit=1")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(page.Items, ShouldHaveLength, 1)
+			So(page.Items[0].Level, ShouldEqual, "software")
+			So(page.Items[0].MsgText, ShouldEqual, "Simulation initializing")
+
+			resp, page = get("?level=playerWarning,software&offset=1&limit=1")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(page.Offset, ShouldEqual, 1)
+			So(page.Items, ShouldHaveLength, 1)
+			So(page.Items[0].Index, ShouldEqual, 1)
+
+			for _, query := range []string{"?level=debug", "?offset=-1", "?limit=5000", "?limit=x"} {
+				resp, _ = get(query)
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+		Convey("Messages should be listed through the hub", func() {
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			So(c.WriteJSON(Request{ID: 7, Object: "message", Action: "list", Params: RawJSON(`{"levels": ["playerWarning"], "limit": 5}`)}), ShouldBeNil)
+			var resp struct {
+				ID   int          `json:"id"`
+				Data messagesPage `json:"data"`
+			}
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.ID, ShouldEqual, 7)
+			So(resp.Data.Limit, ShouldEqual, 5)
+			So(resp.Data.Items, ShouldNotBeEmpty)
+			So(resp.Data.Items[0].MsgText, ShouldEqual, "Test message")
+
+			status := sendRequestStatus(c, "message", "list", `{"levels": ["debug"]}`)
+			So(status.Data.Status, ShouldEqual, Fail)
+		})
+	})
+}
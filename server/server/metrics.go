@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
@@ -19,11 +20,115 @@ const (
 	defaultMinHeadway      = 120 * time.Second
 )
 
+// KPI alert thresholds. A KPI_ALERT audit entry is raised when a KPI crosses
+// its threshold and another one when it comes back.
+const (
+	alertPunctualityBelow = 80.0
+	alertAverageDelayAbove = 5.0
+	alertOpenConflictsAbove = 2
+)
+
+// KPITuning holds the windows over which the realtime KPIs are computed and
+// the thresholds of the KPI alerts.
+type KPITuning struct {
+	// OnTimeWindow is the delay within which a train is on time
+	OnTimeWindow time.Duration
+	// DelayWindow is the window of the average and P90 delays
+	DelayWindow time.Duration
+	// ThroughputWindow is the window of the throughput, the headway
+	// adherence and the detected conflicts
+	ThroughputWindow time.Duration
+	// MTTRWindow is the window of the resolved conflicts
+	MTTRWindow time.Duration
+	// AcceptanceWindow is the window of the suggestions acceptance rate
+	AcceptanceWindow time.Duration
+	// MinHeadway is the minimum time between two departures from the same
+	// place
+	MinHeadway time.Duration
+
+	PunctualityBelow   float64
+	AverageDelayAbove  float64
+	OpenConflictsAbove int
+}
+
+// DefaultKPITuning returns the default KPI windows and alert thresholds
+func DefaultKPITuning() KPITuning {
+	return KPITuning{
+		OnTimeWindow:       defaultOnTimeWindow,
+		DelayWindow:        defaultDelayWindow,
+		ThroughputWindow:   defaultThroughputWindow,
+		MTTRWindow:         defaultMTTRWindow,
+		AcceptanceWindow:   defaultAcceptanceWindow,
+		MinHeadway:         defaultMinHeadway,
+		PunctualityBelow:   alertPunctualityBelow,
+		AverageDelayAbove:  alertAverageDelayAbove,
+		OpenConflictsAbove: alertOpenConflictsAbove,
+	}
+}
+
+var (
+	kpiTuningMutex sync.RWMutex
+	kpiTuning      = DefaultKPITuning()
+)
+
+// validate returns an error if the windows of t are not positive or its
+// thresholds are out of range.
+func (t KPITuning) validate() error {
+	windows := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"onTimeWindow", t.OnTimeWindow},
+		{"delayWindow", t.DelayWindow},
+		{"throughputWindow", t.ThroughputWindow},
+		{"mttrWindow", t.MTTRWindow},
+		{"acceptanceWindow", t.AcceptanceWindow},
+		{"minHeadway", t.MinHeadway},
+	}
+	for _, w := range windows {
+		if w.d <= 0 {
+			return fmt.Errorf("%s must be positive", w.name)
+		}
+	}
+	if t.PunctualityBelow < 0 || t.PunctualityBelow > 100 {
+		return fmt.Errorf("punctualityBelow must be between 0 and 100")
+	}
+	if t.AverageDelayAbove < 0 || t.OpenConflictsAbove < 0 {
+		return fmt.Errorf("KPI alert thresholds cannot be negative")
+	}
+	return nil
+}
+
+// SetKPITuning sets the windows and alert thresholds of the realtime KPIs of
+// all the simulations.
+func SetKPITuning(t KPITuning) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	kpiTuningMutex.Lock()
+	defer kpiTuningMutex.Unlock()
+	kpiTuning = t
+	return nil
+}
+
+// currentKPITuning returns the current KPI windows and alert thresholds
+func currentKPITuning() KPITuning {
+	kpiTuningMutex.RLock()
+	defer kpiTuningMutex.RUnlock()
+	return kpiTuning
+}
+
 type kpiSnapshot struct {
 	ts                time.Time
+	// simTime is the simulation time of the snapshot, as given to clients
+	simTime          string
 	punctuality      float64
+	weightedPunctuality float64
 	averageDelay     float64
 	p90Delay         float64
+	// passengerDelay is the average delay in minutes of the passengers who
+	// boarded or alighted trains in the session
+	passengerDelay   float64
 	throughput       int
 	utilization      float64
 	acceptanceRate   float64
@@ -31,8 +136,24 @@ type kpiSnapshot struct {
 	mttrConflict     float64
 	headwayAdherence float64
 	headwayBreaches  int
+	cancellations    int
+	missedConnections int
+	// overspeeds and spads are the safety violations of trains in the
+	// session so far
+	overspeeds       int
+	spads            int
 	efficiency       float64
 	performance      float64
+	// weather is the weather condition when the snapshot was taken, and
+	// degradedWeather 100 if it degraded train performance, 0 otherwise, so
+	// that its average is the share of time spent in degraded conditions.
+	weather          simulation.Weather
+	degradedWeather  float64
+	// signallingMode is the signalling mode when the snapshot was taken, and
+	// movingBlock 100 if it was moving block, 0 otherwise, so that capacity
+	// KPIs can be compared between modes.
+	signallingMode   simulation.SignallingMode
+	movingBlock      float64
 }
 
 type departureEvent struct{ ts time.Time; place string }
@@ -44,10 +165,26 @@ type metricsState struct {
 	// RTP counts across arrivals + departures (today/session so far)
 	rtpOnTime int
 	rtpTotal  int
+	// RTP counts weighted by the priority class of the trains
+	rtpWeightedOnTime float64
+	rtpWeightedTotal  float64
+	// cancelled trains (today/session so far)
+	cancellations int
+	// missed connections between services (today/session so far)
+	missedConnections int
+	// safety violations: trains overspeeding and signals passed at danger
+	// (today/session so far)
+	overspeeds int
+	spads      int
 
 	// Average delay (rolling), P90 window
 	delays []delayPoint
 
+	// passenger delays (today/session so far): delay minutes weighted by the
+	// passengers who boarded or alighted, and number of these passengers
+	passengerDelayMinutes float64
+	passengers            float64
+
 	// throughput (rolling window of departures)
 	departures []departureEvent
 
@@ -69,236 +206,348 @@ type metricsState struct {
 
 	// historical snapshots
 	snapshots []kpiSnapshot
+
+	// KPIs currently in alert
+	activeAlerts map[string]bool
+}
+
+// metrics holds the KPIs of the default simulation
+var metrics = newMetricsState()
+
+// newMetricsState returns an empty KPI state
+func newMetricsState() *metricsState {
+	return &metricsState{ lastDepartureByPlace: make(map[string]time.Time), conflictFirstSeen: make(map[string]time.Time), activeAlerts: make(map[string]bool) }
+}
+
+// recordPunctualityLocked counts an arrival or departure with the given delay
+// in the RTP of the session, weighting it by weight for the weighted RTP.
+func (m *metricsState) recordPunctualityLocked(delay time.Duration, weight float64) {
+	// RTP within ±5 min
+	if delay < 0 {
+		delay = -delay
+	}
+	if delay <= currentKPITuning().OnTimeWindow {
+		m.rtpOnTime++
+		m.rtpWeightedOnTime += weight
+	}
+	m.rtpTotal++
+	m.rtpWeightedTotal += weight
 }
 
-var metrics = &metricsState{ lastDepartureByPlace: make(map[string]time.Time), conflictFirstSeen: make(map[string]time.Time) }
+// recordPassengerDelayLocked counts passengers boarding or alighting a train
+// with the given delay in the passenger-weighted delay of the session.
+func (m *metricsState) recordPassengerDelayLocked(delay time.Duration, passengers float64) {
+	if passengers <= 0 {
+		return
+	}
+	if delay > 0 {
+		m.passengerDelayMinutes += delay.Minutes() * passengers
+	}
+	m.passengers += passengers
+}
 
-func updateMetrics(e *simulation.Event) {
-	metrics.mu.Lock()
-	defer metrics.mu.Unlock()
+func (h *Hub) updateMetrics(e *simulation.Event) {
+	snap := h.eventSnapshot(e)
+	m := h.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	switch e.Name {
 	case simulation.TrainStoppedAtStationEvent:
 		// Arrival event, compute delay versus scheduled arrival
-		t := e.Object.(*simulation.Train)
-		line := t.Service()
-		if line != nil && t.NextPlaceIndex < len(line.Lines) {
-			sl := line.Lines[t.NextPlaceIndex]
-			if !sl.ScheduledArrivalTime.IsZero() {
-				delay := sim.Options.CurrentTime.Sub(sl.ScheduledArrivalTime)
-				// RTP within ±5 min
-				if delay < 0 {
-					if -delay <= defaultOnTimeWindow { metrics.rtpOnTime++ }
-				} else {
-					if delay <= defaultOnTimeWindow { metrics.rtpOnTime++ }
-				}
-				metrics.rtpTotal++
-				// Positive delay minutes only for Avg delay KPI
-				if delay > 0 { metrics.delays = append(metrics.delays, delayPoint{ts: time.Now().UTC(), minutes: delay.Minutes()}) }
-				trimDelaysLocked()
-			}
+		if t := snap.train; t != nil && t.stop != nil && !t.stop.scheduled.IsZero() {
+			delay := snap.simTime.Sub(t.stop.scheduled)
+			// RTP within ±5 min
+			m.recordPunctualityLocked(delay, t.weight)
+			// Passengers alighting arrive late at their destination
+			m.recordPassengerDelayLocked(delay, t.stop.passengers)
+			// Positive delay minutes only for Avg delay KPI
+			if delay > 0 { m.delays = append(m.delays, delayPoint{ts: time.Now().UTC(), minutes: delay.Minutes()}) }
+			m.trimDelaysLocked()
 		}
 	case simulation.TrainDepartedFromStationEvent:
 		// Departure event, compute delay versus scheduled departure at previous index
-		t := e.Object.(*simulation.Train)
-		line := t.Service()
-		if line != nil {
-			prevIdx := t.NextPlaceIndex - 1
-			if prevIdx >= 0 && prevIdx < len(line.Lines) {
-				sl := line.Lines[prevIdx]
-				if !sl.ScheduledDepartureTime.IsZero() {
-					delay := sim.Options.CurrentTime.Sub(sl.ScheduledDepartureTime)
-					if delay < 0 {
-						if -delay <= defaultOnTimeWindow { metrics.rtpOnTime++ }
-					} else {
-						if delay <= defaultOnTimeWindow { metrics.rtpOnTime++ }
-					}
-					metrics.rtpTotal++
-					if delay > 0 { metrics.delays = append(metrics.delays, delayPoint{ts: time.Now().UTC(), minutes: delay.Minutes()}) }
-					trimDelaysLocked()
-				}
-				// Throughput + headway by place
-				place := sl.PlaceCode
-				metrics.departures = append(metrics.departures, departureEvent{ts: time.Now().UTC(), place: place})
-				trimDeparturesLocked()
-				if last, ok := metrics.lastDepartureByPlace[place]; ok {
-					gap := time.Since(last)
-					if gap < defaultMinHeadway {
-						metrics.headwayBreaches = append(metrics.headwayBreaches, time.Now().UTC())
-						trimHeadwayBreachesLocked()
-					}
+		if t := snap.train; t != nil && t.stop != nil {
+			if !t.stop.scheduled.IsZero() {
+				delay := snap.simTime.Sub(t.stop.scheduled)
+				m.recordPunctualityLocked(delay, t.weight)
+				// Passengers boarding leave late
+				m.recordPassengerDelayLocked(delay, t.stop.passengers)
+				if delay > 0 { m.delays = append(m.delays, delayPoint{ts: time.Now().UTC(), minutes: delay.Minutes()}) }
+				m.trimDelaysLocked()
+			}
+			// Throughput + headway by place
+			place := t.stop.placeCode
+			m.departures = append(m.departures, departureEvent{ts: time.Now().UTC(), place: place})
+			m.trimDeparturesLocked()
+			if last, ok := m.lastDepartureByPlace[place]; ok {
+				gap := time.Since(last)
+				if gap < currentKPITuning().MinHeadway {
+					m.headwayBreaches = append(m.headwayBreaches, time.Now().UTC())
+					m.trimHeadwayBreachesLocked()
 				}
-				metrics.lastDepartureByPlace[place] = time.Now().UTC()
 			}
+			m.lastDepartureByPlace[place] = time.Now().UTC()
+		}
+	case simulation.TrainCancelledEvent:
+		// A cancelled train will never be on time
+		m.cancellations++
+		if t := snap.train; t != nil && t.hasService {
+			m.rtpTotal++
+			m.rtpWeightedTotal += t.weight
+		}
+	case simulation.OverspeedEvent:
+		m.overspeeds++
+	case simulation.SignalPassedAtDangerEvent:
+		m.spads++
+	case simulation.TransferChangedEvent:
+		if snap.transferMissed {
+			m.missedConnections++
 		}
 	case simulation.SuggestionsUpdatedEvent:
 		// Track open conflicts via route-deactivate suggestions and compute resolved/MTTR
 		now := time.Now().UTC()
-		// Suggestions object is sent by value
-		sug := e.Object.(simulation.Suggestions)
 		newSet := make(map[string]bool)
-		for _, it := range sug.Items {
+		for _, it := range snap.suggestions {
 			if strings.HasPrefix(string(it.Kind), "ROUTE_DEACTIVATE") || strings.HasPrefix(it.ID, "ROUTE_DEACTIVATE:") {
 				// Extract route id part if possible (format: ROUTE_DEACTIVATE:<routeId>)
 				routeID := it.ID
 				parts := strings.Split(it.ID, ":")
 				if len(parts) >= 2 { routeID = parts[1] }
 				newSet[routeID] = true
-				if _, ok := metrics.conflictFirstSeen[routeID]; !ok {
-					metrics.conflictFirstSeen[routeID] = now
-					metrics.conflictsDetected = append(metrics.conflictsDetected, now)
+				if _, ok := m.conflictFirstSeen[routeID]; !ok {
+					m.conflictFirstSeen[routeID] = now
+					m.conflictsDetected = append(m.conflictsDetected, now)
+					h.recordConflictScore(routeID)
+					h.audits.append(AuditEntry{
+						Event:    "CONFLICT_DETECTED",
+						Category: "route",
+						Severity: "WARNING",
+						Object:   map[string]interface{}{"id": routeID},
+						Details:  map[string]interface{}{"suggestionId": it.ID, "title": it.Title, "reason": it.Reason},
+					})
 				}
 			}
 		}
 		// Detect cleared conflicts: present before, absent now
-		for id, first := range metrics.conflictFirstSeen {
+		for id, first := range m.conflictFirstSeen {
 			if !newSet[id] {
-				metrics.conflictsResolved = append(metrics.conflictsResolved, now)
-				metrics.resolutionDurations = append(metrics.resolutionDurations, now.Sub(first))
-				delete(metrics.conflictFirstSeen, id)
+				m.conflictsResolved = append(m.conflictsResolved, now)
+				m.resolutionDurations = append(m.resolutionDurations, now.Sub(first))
+				delete(m.conflictFirstSeen, id)
+				h.audits.append(AuditEntry{
+					Event:    "CONFLICT_RESOLVED",
+					Category: "route",
+					Severity: "INFO",
+					Object:   map[string]interface{}{"id": id},
+					Details:  map[string]interface{}{"durationSeconds": now.Sub(first).Seconds()},
+				})
 			}
 		}
-		metrics.openConflicts = len(newSet)
-		trimConflictsLocked()
+		m.openConflicts = len(newSet)
+		m.trimConflictsLocked()
 	}
 }
 
-func trimDeparturesLocked() {
-	cutoff := time.Now().UTC().Add(-defaultThroughputWindow)
+func (m *metricsState) trimDeparturesLocked() {
+	cutoff := time.Now().UTC().Add(-currentKPITuning().ThroughputWindow)
 	i := 0
-	for ; i < len(metrics.departures); i++ {
-		if metrics.departures[i].ts.After(cutoff) { break }
+	for ; i < len(m.departures); i++ {
+		if m.departures[i].ts.After(cutoff) { break }
 	}
-	if i > 0 && i < len(metrics.departures) {
-		metrics.departures = append([]departureEvent{}, metrics.departures[i:]...)
-	} else if i >= len(metrics.departures) {
-		metrics.departures = nil
+	if i > 0 && i < len(m.departures) {
+		m.departures = append([]departureEvent{}, m.departures[i:]...)
+	} else if i >= len(m.departures) {
+		m.departures = nil
 	}
 }
 
-func trimDelaysLocked() {
-	cutoff := time.Now().UTC().Add(-defaultDelayWindow)
+func (m *metricsState) trimDelaysLocked() {
+	cutoff := time.Now().UTC().Add(-currentKPITuning().DelayWindow)
 	i := 0
-	for ; i < len(metrics.delays); i++ {
-		if metrics.delays[i].ts.After(cutoff) { break }
+	for ; i < len(m.delays); i++ {
+		if m.delays[i].ts.After(cutoff) { break }
 	}
-	if i > 0 && i < len(metrics.delays) {
-		metrics.delays = append([]delayPoint{}, metrics.delays[i:]...)
-	} else if i >= len(metrics.delays) {
-		metrics.delays = nil
+	if i > 0 && i < len(m.delays) {
+		m.delays = append([]delayPoint{}, m.delays[i:]...)
+	} else if i >= len(m.delays) {
+		m.delays = nil
 	}
 }
 
-func trimHeadwayBreachesLocked() {
-	cutoff := time.Now().UTC().Add(-defaultThroughputWindow)
+func (m *metricsState) trimHeadwayBreachesLocked() {
+	cutoff := time.Now().UTC().Add(-currentKPITuning().ThroughputWindow)
 	i := 0
-	for ; i < len(metrics.headwayBreaches); i++ {
-		if metrics.headwayBreaches[i].After(cutoff) { break }
+	for ; i < len(m.headwayBreaches); i++ {
+		if m.headwayBreaches[i].After(cutoff) { break }
 	}
-	if i > 0 && i < len(metrics.headwayBreaches) {
-		metrics.headwayBreaches = append([]time.Time{}, metrics.headwayBreaches[i:]...)
-	} else if i >= len(metrics.headwayBreaches) {
-		metrics.headwayBreaches = nil
+	if i > 0 && i < len(m.headwayBreaches) {
+		m.headwayBreaches = append([]time.Time{}, m.headwayBreaches[i:]...)
+	} else if i >= len(m.headwayBreaches) {
+		m.headwayBreaches = nil
 	}
 }
 
-func trimConflictsLocked() {
-	cutoffDet := time.Now().UTC().Add(-defaultThroughputWindow)
-	cutoffRes := time.Now().UTC().Add(-defaultMTTRWindow)
+func (m *metricsState) trimConflictsLocked() {
+	tuning := currentKPITuning()
+	cutoffDet := time.Now().UTC().Add(-tuning.ThroughputWindow)
+	cutoffRes := time.Now().UTC().Add(-tuning.MTTRWindow)
 	// detected
 	i := 0
-	for ; i < len(metrics.conflictsDetected); i++ { if metrics.conflictsDetected[i].After(cutoffDet) { break } }
-	if i > 0 && i < len(metrics.conflictsDetected) { metrics.conflictsDetected = append([]time.Time{}, metrics.conflictsDetected[i:]...) } else if i >= len(metrics.conflictsDetected) { metrics.conflictsDetected = nil }
+	for ; i < len(m.conflictsDetected); i++ { if m.conflictsDetected[i].After(cutoffDet) { break } }
+	if i > 0 && i < len(m.conflictsDetected) { m.conflictsDetected = append([]time.Time{}, m.conflictsDetected[i:]...) } else if i >= len(m.conflictsDetected) { m.conflictsDetected = nil }
 	// resolved
 	j := 0
-	for ; j < len(metrics.conflictsResolved); j++ { if metrics.conflictsResolved[j].After(cutoffRes) { break } }
-	if j > 0 && j < len(metrics.conflictsResolved) { metrics.conflictsResolved = append([]time.Time{}, metrics.conflictsResolved[j:]...) } else if j >= len(metrics.conflictsResolved) { metrics.conflictsResolved = nil }
+	for ; j < len(m.conflictsResolved); j++ { if m.conflictsResolved[j].After(cutoffRes) { break } }
+	if j > 0 && j < len(m.conflictsResolved) { m.conflictsResolved = append([]time.Time{}, m.conflictsResolved[j:]...) } else if j >= len(m.conflictsResolved) { m.conflictsResolved = nil }
 	// resolution durations: keep last N corresponding to window
 	maxKeep := 500
-	if len(metrics.resolutionDurations) > maxKeep { metrics.resolutionDurations = metrics.resolutionDurations[len(metrics.resolutionDurations)-maxKeep:] }
+	if len(m.resolutionDurations) > maxKeep { m.resolutionDurations = m.resolutionDurations[len(m.resolutionDurations)-maxKeep:] }
 }
 
-func takeSnapshot() {
-	metrics.mu.Lock()
-	defer metrics.mu.Unlock()
+func (h *Hub) takeSnapshot() {
+	defer timings.since(operationMetrics, time.Now())
+	m := h.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	// compute utilization instantaneously
-	occupied := 0
-	total := 0
-	for _, ti := range sim.TrackItems {
-		switch ti.Type() {
-		case simulation.TypeLine, simulation.TypeInvisibleLink, simulation.TypeSignal, simulation.TypePoints:
-			total++
-			if ti.TrainPresent() { occupied++ }
-		}
-	}
-	util := 0.0
-	if total > 0 {
-		util = float64(occupied) * 100.0 / float64(total)
-	}
+	util := h.sim.Utilization()
+	tuning := currentKPITuning()
 	// compute throughput in last hour
-	cutoff := time.Now().UTC().Add(-defaultThroughputWindow)
+	cutoff := time.Now().UTC().Add(-tuning.ThroughputWindow)
 	tp := 0
-	for _, d := range metrics.departures {
+	for _, d := range m.departures {
 		if d.ts.After(cutoff) { tp++ }
 	}
 	// RTP (session so far)
 	punctuality := 0.0
-	if metrics.rtpTotal > 0 {
-		punctuality = float64(metrics.rtpOnTime) * 100.0 / float64(metrics.rtpTotal)
+	if m.rtpTotal > 0 {
+		punctuality = float64(m.rtpOnTime) * 100.0 / float64(m.rtpTotal)
+	}
+	weightedPunctuality := 0.0
+	if m.rtpWeightedTotal > 0 {
+		weightedPunctuality = m.rtpWeightedOnTime * 100.0 / m.rtpWeightedTotal
 	}
 	// Avg delay and P90 over last 60 minutes
 	avgDelay := 0.0
 	p90 := 0.0
-	if len(metrics.delays) > 0 {
+	if len(m.delays) > 0 {
 		sum := 0.0
-		vals := make([]float64, 0, len(metrics.delays))
-		for _, d := range metrics.delays { sum += d.minutes; vals = append(vals, d.minutes) }
-		avgDelay = sum / float64(len(metrics.delays))
+		vals := make([]float64, 0, len(m.delays))
+		for _, d := range m.delays { sum += d.minutes; vals = append(vals, d.minutes) }
+		avgDelay = sum / float64(len(m.delays))
 		sort.Float64s(vals)
 		idx := int(0.9*float64(len(vals)-1) + 0.5)
 		if idx < 0 { idx = 0 }
 		if idx >= len(vals) { idx = len(vals)-1 }
 		p90 = vals[idx]
 	}
+	// Passenger-weighted delay (session so far)
+	passengerDelay := 0.0
+	if m.passengers > 0 {
+		passengerDelay = m.passengerDelayMinutes / m.passengers
+	}
 	// Acceptance rate (last 2 hours)
-	acc, tot := countInWindow(metrics.accepted, defaultAcceptanceWindow), countInWindow(append(append([]time.Time{}, metrics.accepted...), append(append([]time.Time{}, metrics.overrides...), metrics.ignored...)...), defaultAcceptanceWindow)
+	acc, tot := countInWindow(m.accepted, tuning.AcceptanceWindow), countInWindow(append(append([]time.Time{}, m.accepted...), append(append([]time.Time{}, m.overrides...), m.ignored...)...), tuning.AcceptanceWindow)
 	accRate := 0.0
 	if tot > 0 { accRate = float64(acc) * 100.0 / float64(tot) }
 	// Open conflicts and MTTR (avg of durations recorded in window)
 	mttr := 0.0
-	if len(metrics.resolutionDurations) > 0 {
+	if len(m.resolutionDurations) > 0 {
 		sum := 0.0
 		cnt := 0
-		for _, d := range metrics.resolutionDurations { sum += d.Minutes(); cnt++ }
+		for _, d := range m.resolutionDurations { sum += d.Minutes(); cnt++ }
 		if cnt > 0 { mttr = sum / float64(cnt) }
 	}
 	// Headway adherence (no breaches)
-	hwBreachesCount := countTimeInWindow(metrics.headwayBreaches, defaultThroughputWindow)
+	hwBreachesCount := countTimeInWindow(m.headwayBreaches, tuning.ThroughputWindow)
 	depCount := 0
-	for _, d := range metrics.departures { if d.ts.After(cutoff) { depCount++ } }
+	for _, d := range m.departures { if d.ts.After(cutoff) { depCount++ } }
 	headwayAdherence := 100.0
 	if depCount > 0 { headwayAdherence = 100.0 * float64(depCount-hwBreachesCount) / float64(depCount) }
 	// simple derived metrics
 	efficiency := 100.0 - avgDelay
 	if efficiency < 0 { efficiency = 0 }
 	performance := (0.5*punctuality + 0.3*float64(tp) + 0.2*util) / 2.0
+	weather := h.sim.Options.Weather
+	if weather == "" {
+		weather = simulation.WeatherClear
+	}
+	degradedWeather := 0.0
+	if weather.IsDegraded() {
+		degradedWeather = 100
+	}
+	signallingMode := h.sim.Options.SignallingMode
+	if signallingMode == "" {
+		signallingMode = simulation.SignallingFixedBlock
+	}
+	movingBlock := 0.0
+	if signallingMode == simulation.SignallingMovingBlock {
+		movingBlock = 100
+	}
 	snap := kpiSnapshot{
 		ts:               time.Now().UTC(),
+		simTime:          h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
 		punctuality:     punctuality,
+		weightedPunctuality: weightedPunctuality,
 		averageDelay:    avgDelay,
 		p90Delay:        p90,
+		passengerDelay:  passengerDelay,
 		throughput:      tp,
 		utilization:     util,
 		acceptanceRate:  accRate,
-		openConflicts:   metrics.openConflicts,
+		openConflicts:   m.openConflicts,
 		mttrConflict:    mttr,
 		headwayAdherence: headwayAdherence,
 		headwayBreaches: hwBreachesCount,
+		cancellations:   m.cancellations,
+		missedConnections: m.missedConnections,
+		overspeeds:      m.overspeeds,
+		spads:           m.spads,
 		efficiency:      efficiency,
 		performance:     performance,
+		weather:         weather,
+		degradedWeather: degradedWeather,
+		signallingMode:  signallingMode,
+		movingBlock:     movingBlock,
+	}
+	m.snapshots = append(m.snapshots, snap)
+	if len(m.snapshots) > 1440 {
+		m.snapshots = m.snapshots[len(m.snapshots)-1440:]
+	}
+	h.checkKPIAlertsLocked(snap.punctuality, snap.averageDelay, snap.openConflicts, m.rtpTotal > 0)
+}
+
+// checkKPIAlertsLocked records KPI_ALERT audit entries for the KPIs that
+// crossed their threshold since the last snapshot. Must be called with the
+// metrics lock held.
+func (h *Hub) checkKPIAlertsLocked(punctuality, averageDelay float64, openConflicts int, hasMovements bool) {
+	tuning := currentKPITuning()
+	checks := []struct {
+		kpi       string
+		value     float64
+		threshold float64
+		breached  bool
+	}{
+		{"punctuality", punctuality, tuning.PunctualityBelow, hasMovements && punctuality < tuning.PunctualityBelow},
+		{"averageDelay", averageDelay, tuning.AverageDelayAbove, averageDelay > tuning.AverageDelayAbove},
+		{"openConflicts", float64(openConflicts), float64(tuning.OpenConflictsAbove), openConflicts > tuning.OpenConflictsAbove},
 	}
-	metrics.snapshots = append(metrics.snapshots, snap)
-	if len(metrics.snapshots) > 1440 {
-		metrics.snapshots = metrics.snapshots[len(metrics.snapshots)-1440:]
+	for _, c := range checks {
+		if c.breached == h.metrics.activeAlerts[c.kpi] {
+			continue
+		}
+		h.metrics.activeAlerts[c.kpi] = c.breached
+		state, severity := "RAISED", "WARNING"
+		if !c.breached {
+			state, severity = "CLEARED", "INFO"
+		}
+		h.audits.append(AuditEntry{
+			Event:    "KPI_ALERT",
+			Category: "kpi",
+			Severity: severity,
+			Object:   map[string]interface{}{"id": c.kpi},
+			Details:  map[string]interface{}{"kpi": c.kpi, "value": c.value, "threshold": c.threshold, "state": state},
+		})
 	}
 }
 
@@ -313,29 +562,62 @@ func countInWindow(ts []time.Time, window time.Duration) int {
 
 func countTimeInWindow(ts []time.Time, window time.Duration) int { return countInWindow(ts, window) }
 
+const (
+	// defaultKPISnapshotInterval is the default time between two KPI
+	// snapshots
+	defaultKPISnapshotInterval = time.Minute
+	// minKPISnapshotInterval and maxKPISnapshotInterval bound the time
+	// between two KPI snapshots
+	minKPISnapshotInterval = 10 * time.Second
+	maxKPISnapshotInterval = 10 * time.Minute
+)
+
+var kpiSnapshotInterval = defaultKPISnapshotInterval
+
+// SetKPISnapshotInterval sets the time between two KPI snapshots of each
+// simulation. It must be called before Run. As the last 1440 snapshots are
+// kept, a shorter interval also shortens the KPI history.
+func SetKPISnapshotInterval(interval time.Duration) error {
+	if interval < minKPISnapshotInterval || interval > maxKPISnapshotInterval {
+		return fmt.Errorf("KPI snapshot interval must be between %s and %s", minKPISnapshotInterval, maxKPISnapshotInterval)
+	}
+	kpiTuningMutex.Lock()
+	defer kpiTuningMutex.Unlock()
+	kpiSnapshotInterval = interval
+	return nil
+}
+
 func startMetricsTicker() {
+	kpiTuningMutex.RLock()
+	interval := kpiSnapshotInterval
+	kpiTuningMutex.RUnlock()
 	go func() {
-		ticker := time.NewTicker(60 * time.Second)
+		ticker := time.NewTicker(interval)
 		for range ticker.C {
-			takeSnapshot()
+			for _, h := range simulations.list() {
+				h.takeSnapshot()
+				h.notifyMetricsSubscribers()
+			}
 		}
 	}()
 }
 
-func aggregateKPIs(rangeDur time.Duration) (kpiSnapshot, kpiSnapshot) {
-	metrics.mu.RLock()
-	defer metrics.mu.RUnlock()
-	if len(metrics.snapshots) == 0 {
+func (m *metricsState) aggregateKPIs(rangeDur time.Duration) (kpiSnapshot, kpiSnapshot) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.snapshots) == 0 {
 		return kpiSnapshot{ts: time.Now().UTC()}, kpiSnapshot{}
 	}
 	cutoff := time.Now().UTC().Add(-rangeDur)
 	aggCount := 0
 	var agg kpiSnapshot
-	for _, s := range metrics.snapshots {
+	for _, s := range m.snapshots {
 		if s.ts.Before(cutoff) { continue }
 		agg.punctuality += s.punctuality
+		agg.weightedPunctuality += s.weightedPunctuality
 		agg.averageDelay += s.averageDelay
 		agg.p90Delay += s.p90Delay
+		agg.passengerDelay += s.passengerDelay
 		agg.throughput += s.throughput
 		agg.utilization += s.utilization
 		agg.acceptanceRate += s.acceptanceRate
@@ -343,34 +625,49 @@ func aggregateKPIs(rangeDur time.Duration) (kpiSnapshot, kpiSnapshot) {
 		agg.mttrConflict += s.mttrConflict
 		agg.headwayAdherence += s.headwayAdherence
 		agg.headwayBreaches += s.headwayBreaches
+		agg.cancellations = s.cancellations
+		agg.missedConnections = s.missedConnections
+		agg.overspeeds = s.overspeeds
+		agg.spads = s.spads
 		agg.efficiency += s.efficiency
 		agg.performance += s.performance
+		agg.degradedWeather += s.degradedWeather
+		agg.weather = s.weather
+		agg.movingBlock += s.movingBlock
+		agg.signallingMode = s.signallingMode
+		agg.simTime = s.simTime
 		aggCount++
 	}
 	if aggCount > 0 {
 		agg.punctuality /= float64(aggCount)
+		agg.weightedPunctuality /= float64(aggCount)
 		agg.averageDelay /= float64(aggCount)
 		agg.p90Delay /= float64(aggCount)
+		agg.passengerDelay /= float64(aggCount)
 		agg.utilization /= float64(aggCount)
 		agg.acceptanceRate /= float64(aggCount)
 		agg.mttrConflict /= float64(aggCount)
 		agg.headwayAdherence /= float64(aggCount)
 		agg.efficiency /= float64(aggCount)
 		agg.performance /= float64(aggCount)
+		agg.degradedWeather /= float64(aggCount)
+		agg.movingBlock /= float64(aggCount)
 	}
 	// trends: compare average of last 10% window vs previous 10%
-	if len(metrics.snapshots) < 10 {
+	if len(m.snapshots) < 10 {
 		return agg, kpiSnapshot{}
 	}
-	n := len(metrics.snapshots)
+	n := len(m.snapshots)
 	w := n/10
 	if w < 1 { w = 1 }
-	cur := averageSlice(metrics.snapshots[n-w:])
-	prev := averageSlice(metrics.snapshots[max(0,n-2*w):n-w])
+	cur := averageSlice(m.snapshots[n-w:])
+	prev := averageSlice(m.snapshots[max(0,n-2*w):n-w])
 	trend := kpiSnapshot{
 		punctuality:  cur.punctuality - prev.punctuality,
+		weightedPunctuality: cur.weightedPunctuality - prev.weightedPunctuality,
 		averageDelay: cur.averageDelay - prev.averageDelay,
 		p90Delay:     cur.p90Delay - prev.p90Delay,
+		passengerDelay: cur.passengerDelay - prev.passengerDelay,
 		throughput:   cur.throughput - prev.throughput,
 		utilization:  cur.utilization - prev.utilization,
 		acceptanceRate: cur.acceptanceRate - prev.acceptanceRate,
@@ -389,8 +686,10 @@ func averageSlice(ss []kpiSnapshot) kpiSnapshot {
 	if len(ss) == 0 { return a }
 	for _, s := range ss {
 		a.punctuality += s.punctuality
+		a.weightedPunctuality += s.weightedPunctuality
 		a.averageDelay += s.averageDelay
 		a.p90Delay += s.p90Delay
+		a.passengerDelay += s.passengerDelay
 		a.throughput += s.throughput
 		a.utilization += s.utilization
 		a.acceptanceRate += s.acceptanceRate
@@ -402,8 +701,10 @@ func averageSlice(ss []kpiSnapshot) kpiSnapshot {
 		a.performance += s.performance
 	}
 	a.punctuality /= float64(len(ss))
+	a.weightedPunctuality /= float64(len(ss))
 	a.averageDelay /= float64(len(ss))
 	a.p90Delay /= float64(len(ss))
+	a.passengerDelay /= float64(len(ss))
 	a.utilization /= float64(len(ss))
 	a.acceptanceRate /= float64(len(ss))
 	a.mttrConflict /= float64(len(ss))
@@ -0,0 +1,531 @@
+package server
+
+import (
+    "bufio"
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+    mqttQueueSize        = 1024
+    mqttDialTimeout      = 10 * time.Second
+    mqttWriteTimeout     = 10 * time.Second
+    mqttMaxReconnectWait = time.Minute
+    defaultMQTTKeepAlive = 30 * time.Second
+)
+
+// MQTT control packet types used by the publisher
+const (
+    mqttConnect byte = 0x10
+    mqttConnAck byte = 0x20
+    mqttPublish byte = 0x30
+    mqttPingReq byte = 0xC0
+)
+
+// mqttReconnectDelay is the delay before the first reconnection attempt to
+// the broker. It doubles at each failed attempt up to mqttMaxReconnectWait.
+var mqttReconnectDelay = time.Second
+
+// MQTTConfig is the configuration of the MQTT publisher.
+//
+// Topics may contain the {simulation} placeholder, replaced by the ID of the
+// simulation, and EventsTopic the {event} placeholder, replaced by the name
+// of the event. An empty topic disables the publication of its messages.
+type MQTTConfig struct {
+    // Broker is the URL of the broker, e.g. tcp://localhost:1883 or
+    // ssl://broker:8883. The scheme defaults to tcp.
+    Broker   string
+    ClientID string
+    Username string
+    Password string
+    // KeepAlive is the MQTT keep alive interval. Defaults to 30s.
+    KeepAlive        time.Duration
+    EventsTopic      string
+    KPITopic         string
+    SuggestionsTopic string
+    // Events are the names of the events published on EventsTopic. All
+    // events are published if it is empty.
+    Events []string
+    // Retain sets the retain flag of the KPI and suggestions messages, so
+    // that new subscribers get the last ones at once.
+    Retain bool
+}
+
+// DefaultMQTTConfig returns an MQTTConfig with the default topics and no broker
+func DefaultMQTTConfig() MQTTConfig {
+    return MQTTConfig{
+        ClientID:         "ts2-sim-server",
+        KeepAlive:        defaultMQTTKeepAlive,
+        EventsTopic:      "ts2/{simulation}/events/{event}",
+        KPITopic:         "ts2/{simulation}/kpi",
+        SuggestionsTopic: "ts2/{simulation}/suggestions",
+    }
+}
+
+// address returns the host:port to dial and whether TLS is used
+func (mc MQTTConfig) address() (string, bool, error) {
+    broker := mc.Broker
+    if !strings.Contains(broker, "://") {
+        broker = "tcp://" + broker
+    }
+    u, err := url.Parse(broker)
+    if err != nil || u.Host == "" {
+        return "", false, fmt.Errorf("invalid MQTT broker URL: %s", mc.Broker)
+    }
+    var useTLS bool
+    port := "1883"
+    switch u.Scheme {
+    case "tcp", "mqtt":
+    case "ssl", "tls", "mqtts":
+        useTLS = true
+        port = "8883"
+    default:
+        return "", false, fmt.Errorf("unsupported MQTT broker scheme: %s", u.Scheme)
+    }
+    if u.Port() != "" {
+        port = u.Port()
+    }
+    return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// validate checks the configuration
+func (mc MQTTConfig) validate() error {
+    if _, _, err := mc.address(); err != nil {
+        return err
+    }
+    if mc.ClientID == "" || len(mc.ClientID) > 65535 {
+        return fmt.Errorf("MQTT client ID must be between 1 and 65535 bytes")
+    }
+    if mc.Password != "" && mc.Username == "" {
+        return fmt.Errorf("MQTT password requires a username")
+    }
+    if mc.KeepAlive < time.Second || mc.KeepAlive > 65535*time.Second {
+        return fmt.Errorf("MQTT keep alive must be between 1s and 65535s")
+    }
+    for _, t := range []string{mc.EventsTopic, mc.KPITopic, mc.SuggestionsTopic} {
+        if strings.ContainsAny(t, "+#") {
+            return fmt.Errorf("MQTT topic %s must not contain wildcards", t)
+        }
+    }
+    return nil
+}
+
+// A mqttMessage is a message waiting to be published
+type mqttMessage struct {
+    topic   string
+    payload []byte
+    retain  bool
+}
+
+// An mqttPublisher publishes simulation events, KPI snapshots and
+// suggestions to an MQTT broker with QoS 0.
+//
+// Messages are queued and sent by a single goroutine which reconnects to the
+// broker when the connection is lost. Messages are dropped when the queue is
+// full or when the broker is unreachable, so that a slow or absent broker
+// never delays the simulation.
+type mqttPublisher struct {
+    config MQTTConfig
+    queue  chan mqttMessage
+
+    mutex       sync.Mutex
+    connected   bool
+    connections int
+    published   int
+    dropped     int
+    lastError   string
+    lastSentAt  time.Time
+}
+
+var (
+    mqtt      *mqttPublisher
+    mqttMutex sync.RWMutex
+)
+
+// SetMQTTConfig configures the MQTT publisher. The publisher connects to the
+// broker when the server runs.
+func SetMQTTConfig(mc MQTTConfig) error {
+    if mc.KeepAlive == 0 {
+        mc.KeepAlive = defaultMQTTKeepAlive
+    }
+    if err := mc.validate(); err != nil {
+        return err
+    }
+    mqttMutex.Lock()
+    defer mqttMutex.Unlock()
+    mqtt = &mqttPublisher{
+        config: mc,
+        queue:  make(chan mqttMessage, mqttQueueSize),
+    }
+    return nil
+}
+
+// currentMQTTPublisher returns the MQTT publisher or nil if MQTT is not configured
+func currentMQTTPublisher() *mqttPublisher {
+    mqttMutex.RLock()
+    defer mqttMutex.RUnlock()
+    return mqtt
+}
+
+// startMQTTPublisher connects to the MQTT broker if it is configured
+func startMQTTPublisher() {
+    if p := currentMQTTPublisher(); p != nil {
+        go p.run()
+    }
+}
+
+// enqueue queues the given message, or drops it if the queue is full
+func (p *mqttPublisher) enqueue(msg mqttMessage) {
+    select {
+    case p.queue <- msg:
+    default:
+        p.mutex.Lock()
+        p.dropped++
+        p.mutex.Unlock()
+    }
+}
+
+// publishesEvent returns true if events with the given name are published on the events topic
+func (p *mqttPublisher) publishesEvent(name simulation.EventName) bool {
+    return p.config.EventsTopic != "" && (len(p.config.Events) == 0 || containsFold(p.config.Events, string(name)))
+}
+
+// mqttPayload is the JSON body of the published messages
+type mqttPayload struct {
+    Simulation string               `json:"simulation"`
+    Event      simulation.EventName `json:"event"`
+    SimTime    string               `json:"simTime"`
+    SentAt     string               `json:"sentAt"`
+    Object     interface{}          `json:"object"`
+}
+
+// publishMQTT publishes the given event on the MQTT topic it belongs to, if
+// any. Suggestions and metrics updates are published on their own topics,
+// all other events on the events topic.
+func (h *Hub) publishMQTT(e *simulation.Event) {
+    p := currentMQTTPublisher()
+    if p == nil {
+        return
+    }
+    var topic string
+    var retain bool
+    switch e.Name {
+    case MetricsUpdatedEvent:
+        topic, retain = p.config.KPITopic, p.config.Retain
+    case simulation.SuggestionsUpdatedEvent:
+        topic, retain = p.config.SuggestionsTopic, p.config.Retain
+    default:
+        if !p.publishesEvent(e.Name) {
+            return
+        }
+        topic = p.config.EventsTopic
+    }
+    if topic == "" {
+        return
+    }
+    // The objects of the simulation are published as encoded when sent
+    var object interface{} = e.Object
+    if e.Data != nil {
+        object = RawJSON(e.Data)
+    }
+    payload, err := json.Marshal(mqttPayload{
+        Simulation: h.id,
+        Event:      e.Name,
+        SimTime:    h.sim.FormatTime(h.eventSnapshot(e).simTime),
+        SentAt:     time.Now().UTC().Format(time.RFC3339),
+        Object:     object,
+    })
+    if err != nil {
+        logger.Error("Unable to marshal MQTT payload", "submodule", "mqtt", "event", e.Name, "error", err)
+        return
+    }
+    topic = strings.NewReplacer("{simulation}", h.id, "{event}", string(e.Name)).Replace(topic)
+    p.enqueue(mqttMessage{topic: topic, payload: payload, retain: retain})
+}
+
+// run connects to the broker and publishes the queued messages, reconnecting
+// when the connection is lost.
+func (p *mqttPublisher) run() {
+    delay := mqttReconnectDelay
+    for {
+        conn, err := p.connect()
+        if err != nil {
+            p.setDisconnected(err)
+            logger.Warn("Unable to connect to MQTT broker", "submodule", "mqtt", "broker", p.config.Broker, "error", err)
+            p.drain()
+            time.Sleep(delay)
+            delay *= 2
+            if delay > mqttMaxReconnectWait {
+                delay = mqttMaxReconnectWait
+            }
+            continue
+        }
+        delay = mqttReconnectDelay
+        logger.Info("Connected to MQTT broker", "submodule", "mqtt", "broker", p.config.Broker)
+        err = p.serve(conn)
+        _ = conn.Close()
+        p.setDisconnected(err)
+        logger.Warn("MQTT connection lost", "submodule", "mqtt", "broker", p.config.Broker, "error", err)
+    }
+}
+
+// drain drops the queued messages while the broker is unreachable
+func (p *mqttPublisher) drain() {
+    for {
+        select {
+        case <-p.queue:
+            p.mutex.Lock()
+            p.dropped++
+            p.mutex.Unlock()
+        default:
+            return
+        }
+    }
+}
+
+// connect dials the broker and sends the CONNECT packet
+func (p *mqttPublisher) connect() (net.Conn, error) {
+    addr, useTLS, err := p.config.address()
+    if err != nil {
+        return nil, err
+    }
+    dialer := &net.Dialer{Timeout: mqttDialTimeout}
+    var conn net.Conn
+    if useTLS {
+        host, _, _ := net.SplitHostPort(addr)
+        conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+    } else {
+        conn, err = dialer.Dial("tcp", addr)
+    }
+    if err != nil {
+        return nil, err
+    }
+    _ = conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+    if _, err = conn.Write(mqttConnectPacket(p.config)); err != nil {
+        _ = conn.Close()
+        return nil, err
+    }
+    typ, body, err := readMQTTPacket(bufio.NewReader(conn))
+    if err != nil {
+        _ = conn.Close()
+        return nil, err
+    }
+    if typ != mqttConnAck || len(body) != 2 {
+        _ = conn.Close()
+        return nil, fmt.Errorf("unexpected MQTT packet 0x%02x instead of CONNACK", typ)
+    }
+    if body[1] != 0 {
+        _ = conn.Close()
+        return nil, fmt.Errorf("connection refused by MQTT broker (return code %d)", body[1])
+    }
+    _ = conn.SetDeadline(time.Time{})
+    p.mutex.Lock()
+    p.connected = true
+    p.connections++
+    p.lastError = ""
+    p.mutex.Unlock()
+    return conn, nil
+}
+
+// serve publishes the queued messages on conn and keeps the connection
+// alive until it fails.
+func (p *mqttPublisher) serve(conn net.Conn) error {
+    closed := make(chan error, 1)
+    go func() {
+        // The broker only sends PINGRESP packets to a QoS 0 publisher. The
+        // connection is considered lost if none came after one and a half
+        // keep alive interval.
+        r := bufio.NewReader(conn)
+        for {
+            _ = conn.SetReadDeadline(time.Now().Add(p.config.KeepAlive * 3 / 2))
+            if _, _, err := readMQTTPacket(r); err != nil {
+                closed <- err
+                return
+            }
+        }
+    }()
+    ticker := time.NewTicker(p.config.KeepAlive / 2)
+    defer ticker.Stop()
+    write := func(packet []byte) error {
+        _ = conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout))
+        _, err := conn.Write(packet)
+        return err
+    }
+    for {
+        select {
+        case msg := <-p.queue:
+            if err := write(mqttPublishPacket(msg)); err != nil {
+                p.mutex.Lock()
+                p.dropped++
+                p.mutex.Unlock()
+                return err
+            }
+            p.mutex.Lock()
+            p.published++
+            p.lastSentAt = time.Now().UTC()
+            p.mutex.Unlock()
+        case <-ticker.C:
+            if err := write([]byte{mqttPingReq, 0}); err != nil {
+                return err
+            }
+        case err := <-closed:
+            return err
+        }
+    }
+}
+
+// setDisconnected records that the publisher is not connected because of err
+func (p *mqttPublisher) setDisconnected(err error) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    p.connected = false
+    if err != nil {
+        p.lastError = err.Error()
+    }
+}
+
+// view returns the JSON representation of this publisher, without credentials
+func (p *mqttPublisher) view() map[string]interface{} {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    lastSent := ""
+    if !p.lastSentAt.IsZero() {
+        lastSent = p.lastSentAt.Format(time.RFC3339)
+    }
+    events := p.config.Events
+    if events == nil {
+        events = []string{}
+    }
+    return map[string]interface{}{
+        "enabled":   true,
+        "broker":    p.config.Broker,
+        "clientId":  p.config.ClientID,
+        "connected": p.connected,
+        "topics": map[string]interface{}{
+            "events":      p.config.EventsTopic,
+            "kpi":         p.config.KPITopic,
+            "suggestions": p.config.SuggestionsTopic,
+        },
+        "events": events,
+        "retain": p.config.Retain,
+        "stats": map[string]interface{}{
+            "connections": p.connections,
+            "published":   p.published,
+            "dropped":     p.dropped,
+            "queued":      len(p.queue),
+            "lastError":   p.lastError,
+            "lastSentAt":  lastSent,
+        },
+    }
+}
+
+// appendMQTTLength appends the MQTT variable length encoding of n to b
+func appendMQTTLength(b []byte, n int) []byte {
+    for {
+        digit := byte(n % 128)
+        n /= 128
+        if n > 0 {
+            digit |= 0x80
+        }
+        b = append(b, digit)
+        if n == 0 {
+            return b
+        }
+    }
+}
+
+// appendMQTTString appends s prefixed by its 16 bits length to b
+func appendMQTTString(b []byte, s string) []byte {
+    return append(append(b, byte(len(s)>>8), byte(len(s))), s...)
+}
+
+// mqttPacket returns the packet of the given type and flags with the given body
+func mqttPacket(header byte, body []byte) []byte {
+    return append(appendMQTTLength([]byte{header}, len(body)), body...)
+}
+
+// mqttConnectPacket returns the MQTT 3.1.1 CONNECT packet of the given
+// configuration, with a clean session.
+func mqttConnectPacket(mc MQTTConfig) []byte {
+    flags := byte(0x02)
+    if mc.Username != "" {
+        flags |= 0x80
+    }
+    if mc.Password != "" {
+        flags |= 0x40
+    }
+    keepAlive := int(mc.KeepAlive / time.Second)
+    body := appendMQTTString(nil, "MQTT")
+    body = append(body, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+    body = appendMQTTString(body, mc.ClientID)
+    if mc.Username != "" {
+        body = appendMQTTString(body, mc.Username)
+    }
+    if mc.Password != "" {
+        body = appendMQTTString(body, mc.Password)
+    }
+    return mqttPacket(mqttConnect, body)
+}
+
+// mqttPublishPacket returns the QoS 0 PUBLISH packet of msg
+func mqttPublishPacket(msg mqttMessage) []byte {
+    header := mqttPublish
+    if msg.retain {
+        header |= 0x01
+    }
+    return mqttPacket(header, append(appendMQTTString(nil, msg.topic), msg.payload...))
+}
+
+// readMQTTPacket reads one packet from r and returns its type and body
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+    header, err := r.ReadByte()
+    if err != nil {
+        return 0, nil, err
+    }
+    length, multiplier := 0, 1
+    for i := 0; ; i++ {
+        if i == 4 {
+            return 0, nil, errors.New("malformed MQTT packet length")
+        }
+        digit, err := r.ReadByte()
+        if err != nil {
+            return 0, nil, err
+        }
+        length += int(digit&0x7F) * multiplier
+        multiplier *= 128
+        if digit&0x80 == 0 {
+            break
+        }
+    }
+    body := make([]byte, length)
+    if _, err := io.ReadFull(r, body); err != nil {
+        return 0, nil, err
+    }
+    return header & 0xF0, body, nil
+}
+
+// GET /api/mqtt
+// Returns the configuration and the statistics of the MQTT publisher.
+func serveMQTT(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    res := map[string]interface{}{"enabled": false}
+    if p := currentMQTTPublisher(); p != nil {
+        res = p.view()
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// mqttTestPacket is a packet received by the test broker
+type mqttTestPacket struct {
+	header byte
+	body   []byte
+}
+
+// runTestBroker accepts one MQTT connection on l, acknowledges its CONNECT
+// packet and sends all the packets it receives to the returned channel.
+func runTestBroker(l net.Listener) <-chan mqttTestPacket {
+	packets := make(chan mqttTestPacket, 100)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			header, err := r.Peek(1)
+			if err != nil {
+				return
+			}
+			h := header[0]
+			typ, body, err := readMQTTPacket(r)
+			if err != nil {
+				return
+			}
+			if typ == mqttConnect {
+				_, _ = conn.Write([]byte{mqttConnAck, 2, 0, 0})
+			}
+			packets <- mqttTestPacket{header: h, body: body}
+		}
+	}()
+	return packets
+}
+
+// nextMQTTPublish returns the topic and payload of the next PUBLISH packet
+func nextMQTTPublish(packets <-chan mqttTestPacket) (mqttTestPacket, string, map[string]interface{}) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-packets:
+			if p.header&0xF0 != mqttPublish {
+				continue
+			}
+			topicLen := int(p.body[0])<<8 | int(p.body[1])
+			var payload map[string]interface{}
+			_ = json.Unmarshal(p.body[2+topicLen:], &payload)
+			return p, string(p.body[2 : 2+topicLen]), payload
+		case <-timeout:
+			return mqttTestPacket{}, "", nil
+		}
+	}
+}
+
+func TestMQTT(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the MQTT publisher", t, func() {
+		Convey("Packets should be encoded as in MQTT 3.1.1", func() {
+			So(appendMQTTLength(nil, 0), ShouldResemble, []byte{0})
+			So(appendMQTTLength(nil, 127), ShouldResemble, []byte{0x7F})
+			So(appendMQTTLength(nil, 321), ShouldResemble, []byte{0xC1, 0x02})
+			So(appendMQTTLength(nil, 16384), ShouldResemble, []byte{0x80, 0x80, 0x01})
+			So(mqttPublishPacket(mqttMessage{topic: "a/b", payload: []byte("hi"), retain: true}), ShouldResemble,
+				[]byte{0x31, 7, 0, 3, 'a', '/', 'b', 'h', 'i'})
+			connect := mqttConnectPacket(MQTTConfig{ClientID: "ts2", Username: "u", Password: "p", KeepAlive: 30 * time.Second})
+			So(connect, ShouldResemble, []byte{0x10, 21, 0, 4, 'M', 'Q', 'T', 'T', 4, 0xC2, 0, 30, 0, 3, 't', 's', '2', 0, 1, 'u', 0, 1, 'p'})
+		})
+		Convey("Configuration should be validated", func() {
+			mc := DefaultMQTTConfig()
+			mc.Broker = "http://localhost"
+			So(SetMQTTConfig(mc), ShouldNotBeNil)
+			mc.Broker = "localhost"
+			mc.EventsTopic = "ts2/#"
+			So(SetMQTTConfig(mc), ShouldNotBeNil)
+			mc.EventsTopic = "ts2/events"
+			mc.Password = "secret"
+			So(SetMQTTConfig(mc), ShouldNotBeNil)
+			addr, useTLS, err := MQTTConfig{Broker: "ssl://broker"}.address()
+			So(err, ShouldBeNil)
+			So(addr, ShouldEqual, "broker:8883")
+			So(useTLS, ShouldBeTrue)
+			So(currentMQTTPublisher(), ShouldBeNil)
+		})
+		Convey("Events, KPIs and suggestions should be published", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			packets := runTestBroker(l)
+			mc := DefaultMQTTConfig()
+			mc.Broker = "tcp://" + l.Addr().String()
+			mc.Events = []string{"trainChanged"}
+			mc.Retain = true
+			So(SetMQTTConfig(mc), ShouldBeNil)
+			defer func() {
+				mqttMutex.Lock()
+				mqtt = nil
+				mqttMutex.Unlock()
+			}()
+			startMQTTPublisher()
+			p := <-packets
+			So(p.header, ShouldEqual, mqttConnect)
+
+			hub.publishMQTT(&simulation.Event{Name: simulation.ClockEvent, Object: hub.sim.Trains[1]})
+			hub.publishMQTT(&simulation.Event{Name: simulation.TrainChangedEvent, Object: hub.sim.Trains[0]})
+			p, topic, payload := nextMQTTPublish(packets)
+			So(topic, ShouldEqual, "ts2/default/events/trainChanged")
+			So(p.header&0x01, ShouldEqual, 0)
+			So(payload["simulation"], ShouldEqual, "default")
+			So(payload["event"], ShouldEqual, "trainChanged")
+			So(payload["object"].(map[string]interface{})["id"], ShouldEqual, "0")
+
+			hub.notifyMetricsSubscribers()
+			p, topic, payload = nextMQTTPublish(packets)
+			So(topic, ShouldEqual, "ts2/default/kpi")
+			So(p.header&0x01, ShouldEqual, 1)
+			So(payload["object"].(map[string]interface{})["kpis"], ShouldContainKey, "punctuality")
+
+			hub.publishMQTT(&simulation.Event{Name: simulation.SuggestionsUpdatedEvent, Object: simulation.Suggestions{}})
+			_, topic, _ = nextMQTTPublish(packets)
+			So(topic, ShouldEqual, "ts2/default/suggestions")
+
+			res, err := http.Get("http://127.0.0.1:22222/api/mqtt")
+			So(err, ShouldBeNil)
+			var status map[string]interface{}
+			data, _ := ioutil.ReadAll(res.Body)
+			So(json.Unmarshal(data, &status), ShouldBeNil)
+			So(status["enabled"], ShouldBeTrue)
+			So(status["connected"], ShouldBeTrue)
+			So(status["stats"].(map[string]interface{})["published"], ShouldEqual, 3)
+		})
+		Convey("Status should tell when MQTT is disabled", func() {
+			res, err := http.Get("http://127.0.0.1:22222/api/mqtt")
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(res.Body)
+			So(string(data), ShouldEqual, "{\"enabled\":false}\n")
+		})
+	})
+}
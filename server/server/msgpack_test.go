@@ -0,0 +1,85 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMsgpack(t *testing.T) {
+	Convey("Testing MessagePack encoding", t, func() {
+		Convey("Values should survive a round trip", func() {
+			in := map[string]interface{}{
+				"small":    7,
+				"negative": -5,
+				"int16":    -300,
+				"big":      1 << 40,
+				"float":    1.5,
+				"bool":     true,
+				"null":     nil,
+				"string":   "Hello",
+				"long":     string(make([]byte, 300)),
+				"array":    []interface{}{1, "two", false},
+				"object":   map[string]interface{}{"a": 1},
+			}
+			data, err := marshalMsgpack(in)
+			So(err, ShouldBeNil)
+			var out map[string]interface{}
+			So(unmarshalMsgpack(data, &out), ShouldBeNil)
+			So(out["small"], ShouldEqual, 7)
+			So(out["negative"], ShouldEqual, -5)
+			So(out["int16"], ShouldEqual, -300)
+			So(out["big"], ShouldEqual, 1<<40)
+			So(out["float"], ShouldEqual, 1.5)
+			So(out["bool"], ShouldBeTrue)
+			So(out["null"], ShouldBeNil)
+			So(out["string"], ShouldEqual, "Hello")
+			So(out["long"], ShouldHaveLength, 300)
+			So(out["array"], ShouldResemble, []interface{}{float64(1), "two", false})
+			So(out["object"], ShouldResemble, map[string]interface{}{"a": float64(1)})
+		})
+		Convey("Known encodings should be produced", func() {
+			data, err := marshalMsgpack(map[string]interface{}{"a": 1})
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, []byte{0x81, 0xa1, 'a', 0x01})
+		})
+		Convey("Invalid data should fail", func() {
+			var out interface{}
+			So(unmarshalMsgpack([]byte{0x92, 0x01}, &out), ShouldNotBeNil)
+			So(unmarshalMsgpack([]byte{0xc1}, &out), ShouldNotBeNil)
+			So(unmarshalMsgpack([]byte{0x01, 0x02}, &out), ShouldNotBeNil)
+			So(unmarshalMsgpack([]byte{0xdb, 0xff, 0xff, 0xff, 0xff}, &out), ShouldNotBeNil)
+		})
+		Convey("Clients should be able to use the msgpack subprotocol", func() {
+			dialer := websocket.Dialer{Subprotocols: []string{msgpackSubprotocol}}
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws"}
+			c, _, err := dialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(c.Subprotocol(), ShouldEqual, msgpackSubprotocol)
+
+			send := func(req interface{}) ResponseStatus {
+				data, err := marshalMsgpack(req)
+				So(err, ShouldBeNil)
+				So(c.WriteMessage(websocket.BinaryMessage, data), ShouldBeNil)
+				mt, data, err := c.ReadMessage()
+				So(err, ShouldBeNil)
+				So(mt, ShouldEqual, websocket.BinaryMessage)
+				var resp ResponseStatus
+				So(unmarshalMsgpack(data, &resp), ShouldBeNil)
+				return resp
+			}
+			resp := send(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: "client-secret"}})
+			So(resp.ID, ShouldEqual, 1)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = send(Request{ID: 2, Object: "server", Action: "renotify"})
+			So(resp.ID, ShouldEqual, 2)
+			So(resp.Data.Status, ShouldEqual, Ok)
+		})
+	})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A tunableOption is a simulation option that can be changed at runtime
+// through the HTTP API, with its accepted range. For numeric options, 0 means
+// the engine default unless min is above 0. Enum options take one of Values.
+type tunableOption struct {
+    Kind   string   `json:"type"`
+    Min    float64  `json:"min,omitempty"`
+    Max    float64  `json:"max,omitempty"`
+    Values []string `json:"values,omitempty"`
+    get    func(o *simulation.Options) interface{}
+}
+
+var tunableOptions = map[string]tunableOption{
+    "timeFactor": {Kind: "int", Min: 1, Max: 10,
+        get: func(o *simulation.Options) interface{} { return o.TimeFactor }},
+    "suggestionsEnabled": {Kind: "bool",
+        get: func(o *simulation.Options) interface{} { return o.SuggestionsEnabled }},
+    "suggestionsIntervalMinutes": {Kind: "int", Min: 0, Max: 60,
+        get: func(o *simulation.Options) interface{} { return o.SuggestionsIntervalMinutes }},
+    "suggestPredictiveMaxDistanceM": {Kind: "float", Min: 0, Max: 20000,
+        get: func(o *simulation.Options) interface{} { return o.SuggestPredictiveMaxDistanceM }},
+    "suggestPredictiveMaxETASeconds": {Kind: "int", Min: 0, Max: 3600,
+        get: func(o *simulation.Options) interface{} { return o.SuggestPredictiveMaxETASeconds }},
+    "suggestSafetyBufferSeconds": {Kind: "int", Min: 0, Max: 600,
+        get: func(o *simulation.Options) interface{} { return o.SuggestSafetyBufferSeconds }},
+    "suggestMaxItems": {Kind: "int", Min: 0, Max: 500,
+        get: func(o *simulation.Options) interface{} { return o.SuggestMaxItems }},
+    "signalFailureRate": {Kind: "float", Min: 0, Max: 10,
+        get: func(o *simulation.Options) interface{} { return o.SignalFailureRate }},
+    "signalMTTRMinutes": {Kind: "int", Min: 0, Max: 1440,
+        get: func(o *simulation.Options) interface{} { return o.SignalMTTRMinutes }},
+    "pointsFailureRate": {Kind: "float", Min: 0, Max: 10,
+        get: func(o *simulation.Options) interface{} { return o.PointsFailureRate }},
+    "pointsMTTRMinutes": {Kind: "int", Min: 0, Max: 1440,
+        get: func(o *simulation.Options) interface{} { return o.PointsMTTRMinutes }},
+    "weather": {Kind: "enum", Values: weatherNames(),
+        get: func(o *simulation.Options) interface{} {
+            if o.Weather == "" {
+                return string(simulation.WeatherClear)
+            }
+            return string(o.Weather)
+        }},
+    "signallingMode": {Kind: "enum", Values: signallingModeNames(),
+        get: func(o *simulation.Options) interface{} {
+            if o.SignallingMode == "" {
+                return string(simulation.SignallingFixedBlock)
+            }
+            return string(o.SignallingMode)
+        }},
+    "movingBlockMargin": {Kind: "float", Min: 0, Max: 1000,
+        get: func(o *simulation.Options) interface{} { return o.MovingBlockMargin }},
+    "realDateTime": {Kind: "bool",
+        get: func(o *simulation.Options) interface{} { return o.RealDateTime }},
+    "seed": {Kind: "int", Min: 0, Max: 1<<53 - 1,
+        get: func(o *simulation.Options) interface{} { return o.Seed }},
+    "rewindHistoryMinutes": {Kind: "int", Min: 0, Max: 720,
+        get: func(o *simulation.Options) interface{} { return o.RewindHistoryMinutes }},
+    "autoLinkServices": {Kind: "bool",
+        get: func(o *simulation.Options) interface{} { return o.AutoLinkServices }},
+    "minTurnaroundSeconds": {Kind: "int", Min: 0, Max: 7200,
+        get: func(o *simulation.Options) interface{} { return o.MinTurnaroundSeconds }},
+    "autoReverseAtTerminus": {Kind: "bool",
+        get: func(o *simulation.Options) interface{} { return o.AutoReverseAtTerminus }},
+    "driverChangeSeconds": {Kind: "int", Min: 0, Max: 3600,
+        get: func(o *simulation.Options) interface{} { return o.DriverChangeSeconds }},
+    "overlapLength": {Kind: "float", Min: 0, Max: 1000,
+        get: func(o *simulation.Options) interface{} { return o.OverlapLength }},
+    "overlapReleaseSeconds": {Kind: "int", Min: 0, Max: 600,
+        get: func(o *simulation.Options) interface{} { return o.OverlapReleaseSeconds }},
+}
+
+// weatherNames returns the names of the weather conditions of the simulation
+func weatherNames() []string {
+    var res []string
+    for _, w := range simulation.Weathers() {
+        res = append(res, string(w))
+    }
+    return res
+}
+
+// signallingModeNames returns the names of the signalling modes of the simulation
+func signallingModeNames() []string {
+    var res []string
+    for _, m := range simulation.SignallingModes() {
+        res = append(res, string(m))
+    }
+    return res
+}
+
+// check returns the value to set for this option, or an error if value is not acceptable.
+func (to tunableOption) check(name string, value interface{}) (interface{}, error) {
+    switch to.Kind {
+    case "bool":
+        b, ok := value.(bool)
+        if !ok {
+            return nil, fmt.Errorf("%s must be a boolean", name)
+        }
+        return b, nil
+    case "enum":
+        s, ok := value.(string)
+        if !ok || !containsString(to.Values, s) {
+            return nil, fmt.Errorf("%s must be one of %s", name, strings.Join(to.Values, ", "))
+        }
+        return s, nil
+    default:
+        f, ok := value.(float64)
+        if !ok {
+            return nil, fmt.Errorf("%s must be a number", name)
+        }
+        if f < to.Min || f > to.Max {
+            return nil, fmt.Errorf("%s must be between %v and %v", name, to.Min, to.Max)
+        }
+        if to.Kind == "int" {
+            if f != float64(int(f)) {
+                return nil, fmt.Errorf("%s must be an integer", name)
+            }
+            return int(f), nil
+        }
+        return f, nil
+    }
+}
+
+// currentOptions returns the current values of the tunable options of s
+func currentOptions(s *simulation.Simulation) map[string]interface{} {
+    res := make(map[string]interface{})
+    for name, to := range tunableOptions {
+        res[name] = to.get(&s.Options)
+    }
+    return res
+}
+
+func writeOptions(w http.ResponseWriter, s *simulation.Simulation) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{
+        "options": currentOptions(s),
+        "limits":  tunableOptions,
+    })
+}
+
+// GET /api/simulation/options
+// PATCH /api/simulation/options
+//
+// PATCH takes an object of option names to values. All values are checked
+// before any is applied, so that either all or none are changed.
+func serveSimulationOptions(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        writeOptions(w, h.sim)
+    case http.MethodPatch:
+        var body map[string]interface{}
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        if len(body) == 0 {
+            invalidParameter(w, "No options given", nil)
+            return
+        }
+        names := make([]string, 0, len(body))
+        for name := range body {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        values := make(map[string]interface{})
+        for _, name := range names {
+            to, ok := tunableOptions[name]
+            if !ok {
+                invalidParameter(w, fmt.Sprintf("Unknown or read-only option: %s", name), map[string]interface{}{"option": name})
+                return
+            }
+            v, err := to.check(name, body[name])
+            if err != nil {
+                invalidParameter(w, err.Error(), map[string]interface{}{"option": name, "value": body[name]})
+                return
+            }
+            values[name] = v
+        }
+        for _, name := range names {
+            if err := h.sim.Options.Set(name, values[name]); err != nil {
+                internalError(w, "Unable to set option", err)
+                return
+            }
+        }
+        if h.sim.Options.SuggestionsEnabled {
+            h.sim.RecomputeSuggestions()
+        }
+        writeOptions(w, h.sim)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+    "encoding/json"
+    "sort"
+    "strconv"
+    "sync"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// overviewCache holds the JSON overview representation of the signals,
+// tracks, routes and trains of a simulation.
+//
+// Entries are built when first needed and dropped when the change log shows
+// that their object changed, so that the dashboards polling the overview do
+// not rebuild the whole system on each request.
+type overviewCache struct {
+    mu      sync.Mutex
+    changes *overviewChangeLog
+    // sim, version and base are the simulation and the versions of the
+    // change log at the last refresh, and geo is the geographic reference of
+    // the simulation with which the entries were built.
+    sim     *simulation.Simulation
+    version int64
+    base    int64
+    geo     *simulation.GeoReference
+
+    // The track items and routes of a simulation never change, so their IDs
+    // and totals are computed once for each simulation.
+    signalIDs     []string
+    trackIDs      []string
+    routeIDs      []string
+    totalsByType  map[string]int
+    segmentsTotal int
+
+    trackItems map[string]json.RawMessage
+    routes     map[string]json.RawMessage
+    trains     map[string]json.RawMessage
+}
+
+// overviewSnapshots is the overview cache of the default simulation
+var overviewSnapshots = newOverviewCache(overviewChanges)
+
+// newOverviewCache returns an empty overview cache following the given
+// change log
+func newOverviewCache(changes *overviewChangeLog) *overviewCache {
+    return &overviewCache{changes: changes}
+}
+
+// refresh drops the entries of the objects of s that changed since the last
+// refresh and returns the current version of the change log. The whole cache
+// is dropped if the simulation has been replaced or restarted, or if its
+// geographic reference changed.
+//
+// oc.mu must be held by the caller.
+func (oc *overviewCache) refresh(s *simulation.Simulation) (version, base int64) {
+    version, base = oc.changes.currentVersion()
+    switch {
+    case s != oc.sim || base != oc.base || version < oc.version || s.Options.GeoReference != oc.geo:
+        oc.reset(s)
+        oc.base = base
+    case version > oc.version:
+        tiIDs, rteIDs, trainIDs, allTrains := oc.changes.changedSince(oc.version)
+        for _, id := range tiIDs {
+            delete(oc.trackItems, id)
+        }
+        for _, id := range rteIDs {
+            delete(oc.routes, id)
+        }
+        if allTrains {
+            oc.trains = make(map[string]json.RawMessage)
+        }
+        for _, id := range trainIDs {
+            delete(oc.trains, id)
+        }
+    }
+    oc.version = version
+    return version, base
+}
+
+// reset empties the cache and lists the track items and routes of s
+func (oc *overviewCache) reset(s *simulation.Simulation) {
+    oc.sim = s
+    oc.geo = s.Options.GeoReference
+    oc.signalIDs, oc.trackIDs, oc.routeIDs = nil, nil, nil
+    oc.totalsByType = make(map[string]int)
+    oc.segmentsTotal = 0
+    for id, ti := range s.TrackItems {
+        oc.totalsByType[string(ti.Type())]++
+        switch ti.Type() {
+        case simulation.TypeLine, simulation.TypeInvisibleLink, simulation.TypeLevelCrossing, simulation.TypeSignal, simulation.TypePoints:
+            oc.segmentsTotal++
+        }
+        switch ti.(type) {
+        case *simulation.SignalItem:
+            oc.signalIDs = append(oc.signalIDs, id)
+        case *simulation.PointsItem, *simulation.LineItem, *simulation.InvisibleLinkItem, *simulation.LevelCrossingItem:
+            oc.trackIDs = append(oc.trackIDs, id)
+        }
+    }
+    for id := range s.Routes {
+        oc.routeIDs = append(oc.routeIDs, id)
+    }
+    sortIDs(oc.signalIDs)
+    sortIDs(oc.trackIDs)
+    sortIDs(oc.routeIDs)
+    oc.trackItems = make(map[string]json.RawMessage)
+    oc.routes = make(map[string]json.RawMessage)
+    oc.trains = make(map[string]json.RawMessage)
+}
+
+// sortIDs sorts the given object IDs, numerically if they are numbers
+func sortIDs(ids []string) {
+    sort.Slice(ids, func(i, j int) bool {
+        if len(ids[i]) != len(ids[j]) {
+            return len(ids[i]) < len(ids[j])
+        }
+        return ids[i] < ids[j]
+    })
+}
+
+// encodeOverview returns the JSON encoding of the overview representation
+// v of an object, or nil if it cannot be encoded.
+func encodeOverview(kind, id string, v map[string]interface{}) json.RawMessage {
+    data, err := json.Marshal(v)
+    if err != nil {
+        logger.Error("Unable to encode overview", "submodule", "http", "kind", kind, "id", id, "error", err)
+        return nil
+    }
+    return data
+}
+
+// trackItem returns the overview representation of the signal or track with
+// the given ID, or nil if it is not a signal or a track.
+func (oc *overviewCache) trackItem(id string) json.RawMessage {
+    if data, ok := oc.trackItems[id]; ok {
+        return data
+    }
+    var data json.RawMessage
+    switch v := oc.sim.TrackItems[id].(type) {
+    case *simulation.SignalItem:
+        data = encodeOverview("signal", id, overviewSignal(oc.sim, id, v))
+    case *simulation.PointsItem, *simulation.LineItem, *simulation.InvisibleLinkItem, *simulation.LevelCrossingItem:
+        data = encodeOverview("track", id, overviewTrack(oc.sim, id, v))
+    }
+    oc.trackItems[id] = data
+    return data
+}
+
+// route returns the overview representation of the route with the given ID
+func (oc *overviewCache) route(id string) json.RawMessage {
+    if data, ok := oc.routes[id]; ok {
+        return data
+    }
+    var data json.RawMessage
+    if rte, ok := oc.sim.Routes[id]; ok {
+        data = encodeOverview("route", id, overviewRoute(id, rte))
+    }
+    oc.routes[id] = data
+    return data
+}
+
+// train returns the overview representation of t
+func (oc *overviewCache) train(t *simulation.Train) json.RawMessage {
+    if data, ok := oc.trains[t.ID()]; ok {
+        return data
+    }
+    data := encodeOverview("train", t.ID(), overviewTrain(oc.sim, t))
+    oc.trains[t.ID()] = data
+    return data
+}
+
+// appendEntries appends the non nil entries returned by get for each ID
+func appendEntries(list []json.RawMessage, ids []string, get func(string) json.RawMessage) []json.RawMessage {
+    for _, id := range ids {
+        if data := get(id); data != nil {
+            list = append(list, data)
+        }
+    }
+    return list
+}
+
+// allTrainEntries returns the overview representations of all the trains of
+// the simulation.
+func (oc *overviewCache) allTrainEntries() []json.RawMessage {
+    res := []json.RawMessage{}
+    for _, t := range oc.sim.Trains {
+        if data := oc.train(t); data != nil {
+            res = append(res, data)
+        }
+    }
+    return res
+}
+
+// trainEntries returns the overview representations of the trains of the
+// simulation with the given IDs.
+func (oc *overviewCache) trainEntries(ids []string) []json.RawMessage {
+    res := []json.RawMessage{}
+    sortIDs(ids)
+    for _, id := range ids {
+        idx, err := strconv.Atoi(id)
+        if err != nil || idx < 0 || idx >= len(oc.sim.Trains) {
+            continue
+        }
+        if data := oc.train(oc.sim.Trains[idx]); data != nil {
+            res = append(res, data)
+        }
+    }
+    return res
+}
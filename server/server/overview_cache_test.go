@@ -0,0 +1,138 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// overviewCacheLists returns the lists of the overview built by oc
+func overviewCacheLists(oc *overviewCache, s *simulation.Simulation) (signals, tracks, routes, trains []json.RawMessage) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.refresh(s)
+	signals = appendEntries(nil, oc.signalIDs, oc.trackItem)
+	tracks = appendEntries(nil, oc.trackIDs, oc.trackItem)
+	routes = appendEntries(nil, oc.routeIDs, oc.route)
+	trains = oc.allTrainEntries()
+	return
+}
+
+// trainSpeed returns the speed of the given train overview
+func trainSpeed(data json.RawMessage) float64 {
+	var tr struct {
+		SpeedKmh float64 `json:"speedKmh"`
+	}
+	So(json.Unmarshal(data, &tr), ShouldBeNil)
+	return tr.SpeedKmh
+}
+
+func TestOverviewCache(t *testing.T) {
+	Convey("Testing the overview cache", t, func() {
+		s := loadBenchmarkSimulation(t, 4)
+		changes := newOverviewChangeLog()
+		oc := newOverviewCache(changes)
+		signals, tracks, routes, trains := overviewCacheLists(oc, s)
+		So(trains, ShouldHaveLength, 4)
+		So(routes, ShouldHaveLength, len(s.Routes))
+		So(len(signals)+len(tracks), ShouldBeGreaterThan, 0)
+		expected, _ := json.Marshal(overviewSignal(s, oc.signalIDs[0], s.TrackItems[oc.signalIDs[0]].(*simulation.SignalItem)))
+		So(string(signals[0]), ShouldEqual, string(expected))
+		speed := trainSpeed(trains[1])
+		speed2 := trainSpeed(trains[2])
+		s.Trains[1].Speed += 10
+
+		Convey("Unchanged objects should be served from the cache", func() {
+			_, _, _, trains := overviewCacheLists(oc, s)
+			So(trainSpeed(trains[1]), ShouldEqual, speed)
+		})
+		Convey("Changed objects should be rebuilt", func() {
+			s.Trains[2].Speed += 10
+			changes.record(&simulation.Event{Name: simulation.TrainChangedEvent, Object: s.Trains[1]})
+			_, _, _, trains := overviewCacheLists(oc, s)
+			So(trainSpeed(trains[1]), ShouldAlmostEqual, speed+36)
+			So(trainSpeed(trains[2]), ShouldEqual, speed2)
+		})
+		Convey("All trains should be rebuilt after a speed restriction change", func() {
+			s.Trains[2].Speed += 10
+			changes.record(&simulation.Event{Name: simulation.SpeedRestrictionChangedEvent, Object: simulation.IntObject{Value: 1}})
+			_, _, _, trains := overviewCacheLists(oc, s)
+			So(trainSpeed(trains[1]), ShouldAlmostEqual, speed+36)
+			expected, _ := json.Marshal(overviewTrain(s, s.Trains[2]))
+			So(string(trains[2]), ShouldEqual, string(expected))
+		})
+		Convey("The whole cache should be rebuilt after a restart", func() {
+			changes.reset()
+			_, _, _, trains := overviewCacheLists(oc, s)
+			So(trainSpeed(trains[1]), ShouldAlmostEqual, speed+36)
+		})
+		Convey("Entries should be placed with the geographic reference of their simulation", func() {
+			s.Options.GeoReference = &simulation.GeoReference{Affine: []float64{0.0009765625, 0, 2, 0, -0.0009765625, 48}}
+			signals, tracks, _, _ := overviewCacheLists(oc, s)
+			So(string(signals[0]), ShouldContainSubstring, `"lat"`)
+			So(string(tracks[0]), ShouldContainSubstring, `"lat"`)
+		})
+		Convey("The whole cache should be rebuilt for another simulation", func() {
+			other := loadBenchmarkSimulation(t, 6)
+			_, _, _, trains := overviewCacheLists(oc, other)
+			So(trains, ShouldHaveLength, 6)
+		})
+	})
+}
+
+// BenchmarkOverviewCached measures the build of the overview lists of a
+// 100-train simulation in which 10 trains and 10 track items changed since
+// the previous request.
+func BenchmarkOverviewCached(b *testing.B) {
+	s := loadBenchmarkSimulation(b, 100)
+	changes := newOverviewChangeLog()
+	oc := newOverviewCache(changes)
+	var ids []string
+	for id := range s.TrackItems {
+		ids = append(ids, id)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10; j++ {
+			changes.record(&simulation.Event{Name: simulation.TrainChangedEvent, Object: s.Trains[(i*10+j)%100]})
+			changes.record(&simulation.Event{Name: simulation.TrackItemChangedEvent, Object: s.TrackItems[ids[(i*10+j)%len(ids)]]})
+		}
+		oc.mu.Lock()
+		oc.refresh(s)
+		appendEntries(nil, oc.signalIDs, oc.trackItem)
+		appendEntries(nil, oc.trackIDs, oc.trackItem)
+		appendEntries(nil, oc.routeIDs, oc.route)
+		oc.allTrainEntries()
+		oc.mu.Unlock()
+	}
+}
+
+// BenchmarkOverviewUncached measures the build of all the overview lists of
+// a 100-train simulation, as done without the cache.
+func BenchmarkOverviewUncached(b *testing.B) {
+	s := loadBenchmarkSimulation(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for id, ti := range s.TrackItems {
+			switch v := ti.(type) {
+			case *simulation.SignalItem:
+				_, _ = json.Marshal(overviewSignal(s, id, v))
+			case *simulation.PointsItem, *simulation.LineItem, *simulation.InvisibleLinkItem, *simulation.LevelCrossingItem:
+				_, _ = json.Marshal(overviewTrack(s, id, v))
+			}
+		}
+		for id, rte := range s.Routes {
+			_, _ = json.Marshal(overviewRoute(id, rte))
+		}
+		for _, t := range s.Trains {
+			_, _ = json.Marshal(overviewTrain(s, t))
+		}
+	}
+}
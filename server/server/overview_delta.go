@@ -0,0 +1,196 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// overviewChangeLog keeps track of the version at which each object of the
+// overview was last modified, so that clients can poll for deltas only.
+type overviewChangeLog struct {
+    mu         sync.RWMutex
+    version    int64
+    base       int64
+    trackItems map[string]int64
+    routes     map[string]int64
+    trains     map[string]int64
+    // allTrains is the version of the last change that may affect all trains,
+    // such as a new speed restriction.
+    allTrains int64
+}
+
+var overviewChanges = newOverviewChangeLog()
+
+func newOverviewChangeLog() *overviewChangeLog {
+    return &overviewChangeLog{
+        trackItems: make(map[string]int64),
+        routes:     make(map[string]int64),
+        trains:     make(map[string]int64),
+    }
+}
+
+// currentVersion returns the version of the last recorded change and the
+// version of the last reset of the log.
+func (o *overviewChangeLog) currentVersion() (version, base int64) {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    return o.version, o.base
+}
+
+// reset forgets all recorded changes and bumps the version so that clients
+// holding an older version receive a full snapshot on their next poll.
+func (o *overviewChangeLog) reset() {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.version++
+    o.base = o.version
+    o.trackItems = make(map[string]int64)
+    o.routes = make(map[string]int64)
+    o.trains = make(map[string]int64)
+    o.allTrains = 0
+}
+
+// record updates the change log from a simulation event
+func (o *overviewChangeLog) record(e *simulation.Event) {
+    if e == nil || e.Object == nil {
+        return
+    }
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    switch e.Name {
+    case simulation.TrackItemChangedEvent, simulation.SignalaspectChangedEvent,
+        simulation.SignalFailedEvent, simulation.SignalRepairedEvent,
+        simulation.PointsFailedEvent, simulation.PointsRepairedEvent,
+        simulation.LevelCrossingChangedEvent, simulation.LevelCrossingFailedEvent, simulation.LevelCrossingRepairedEvent:
+        o.version++
+        o.trackItems[e.Object.ID()] = o.version
+    case simulation.RouteActivatedEvent, simulation.RouteDeactivatedEvent:
+        o.version++
+        o.routes[e.Object.ID()] = o.version
+        if r, ok := e.Object.(*simulation.Route); ok {
+            // begin and end signals expose the active routes around them
+            o.trackItems[r.BeginSignalId] = o.version
+            o.trackItems[r.EndSignalId] = o.version
+        }
+    case simulation.TrainChangedEvent, simulation.TrainStoppedAtStationEvent, simulation.TrainDepartedFromStationEvent,
+        simulation.TrainAddedEvent, simulation.TrainCancelledEvent:
+        o.version++
+        o.trains[e.Object.ID()] = o.version
+    case simulation.TrainSplitEvent, simulation.TrainJoinedEvent:
+        o.version++
+        o.trains[e.Object.ID()] = o.version
+        if tc, ok := e.Object.(*simulation.TrainCoupling); ok {
+            o.trains[tc.OtherTrainID] = o.version
+        }
+    case simulation.SpeedRestrictionChangedEvent, simulation.PossessionChangedEvent:
+        // The maximum speed of the trains may change
+        o.version++
+        o.allTrains = o.version
+    }
+}
+
+// changedSince returns the IDs of track items, routes and trains that changed
+// strictly after the given version. allTrains is true if all the trains may
+// have changed.
+func (o *overviewChangeLog) changedSince(since int64) (trackItems, routes, trains []string, allTrains bool) {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    allTrains = o.allTrains > since
+    for id, v := range o.trackItems {
+        if v > since {
+            trackItems = append(trackItems, id)
+        }
+    }
+    for id, v := range o.routes {
+        if v > since {
+            routes = append(routes, id)
+        }
+    }
+    for id, v := range o.trains {
+        if v > since {
+            trains = append(trains, id)
+        }
+    }
+    return
+}
+
+// GET /api/systems/overview/delta?since=<version>
+// Returns only the signals, tracks, routes and trains that changed since the
+// given version. A full snapshot is returned when since is 0, or when it is
+// older than the last simulation restart or newer than the current version.
+func serveSystemOverviewDelta(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    var since int64
+    if sp := r.URL.Query().Get("since"); sp != "" {
+        var err error
+        since, err = strconv.ParseInt(sp, 10, 64)
+        if err != nil || since < 0 {
+            invalidParameter(w, "Bad since", map[string]interface{}{"since": sp})
+            return
+        }
+    }
+    // The cache reads the version before building objects, so that changes
+    // happening while we build the response are sent again on the next poll.
+    oc := h.snapshots
+    oc.mu.Lock()
+    version, base := oc.refresh(h.sim)
+    full := since == 0 || since < base || since > version
+
+    signals := []json.RawMessage{}
+    tracks := []json.RawMessage{}
+    var routes, trains []json.RawMessage
+    if full {
+        signals = appendEntries(signals, oc.signalIDs, oc.trackItem)
+        tracks = appendEntries(tracks, oc.trackIDs, oc.trackItem)
+        routes = appendEntries([]json.RawMessage{}, oc.routeIDs, oc.route)
+        trains = oc.allTrainEntries()
+    } else {
+        tiIDs, rteIDs, trainIDs, allTrains := h.overview.changedSince(since)
+        sortIDs(tiIDs)
+        for _, id := range tiIDs {
+            if _, ok := h.sim.TrackItems[id].(*simulation.SignalItem); ok {
+                signals = appendEntries(signals, []string{id}, oc.trackItem)
+            } else {
+                tracks = appendEntries(tracks, []string{id}, oc.trackItem)
+            }
+        }
+        sortIDs(rteIDs)
+        routes = appendEntries([]json.RawMessage{}, rteIDs, oc.route)
+        if allTrains {
+            trains = oc.allTrainEntries()
+        } else {
+            trains = oc.trainEntries(trainIDs)
+        }
+    }
+    oc.mu.Unlock()
+
+    resp := map[string]interface{}{
+        "timestamp": time.Now().UTC().Format(time.RFC3339),
+        "version": version,
+        "since": since,
+        "full": full,
+        "currentTime": h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
+        "running": h.sim.IsStarted(),
+        "signals": signals,
+        "tracks": tracks,
+        "routes": routes,
+        "trains": trains,
+        "speedRestrictions": h.sim.SpeedRestrictions(),
+        "possessions": h.sim.Possessions(),
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// perturbationsReport returns the configuration and statistics of the
+// perturbation generator of the simulation s
+func perturbationsReport(s *simulation.Simulation) map[string]interface{} {
+    return map[string]interface{}{
+        "perturbations": s.Perturbations(),
+        "stats":         s.PerturbationStats(),
+    }
+}
+
+// GET /api/simulation/perturbations
+// PUT /api/simulation/perturbations
+func servePerturbations(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPut:
+        var body simulation.Perturbations
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        if err := h.sim.SetPerturbations(body); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+    default:
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(perturbationsReport(h.sim))
+}
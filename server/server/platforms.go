@@ -0,0 +1,157 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// platformAssignmentRequest assigns a line of a service to another platform
+type platformAssignmentRequest struct {
+    ServiceCode string `json:"serviceCode"`
+    LineIndex   int    `json:"lineIndex"`
+    TrackCode   string `json:"trackCode"`
+}
+
+// clockTime returns t as a time of s, or an empty string if t is zero
+func clockTime(s *simulation.Simulation, t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return s.FormatTime(t)
+}
+
+// platformAllocationView returns the JSON representation of a platform allocation
+func platformAllocationView(s *simulation.Simulation, pa *simulation.PlatformAllocation) map[string]interface{} {
+    line := pa.Line()
+    trainID := ""
+    if pa.Train != nil {
+        trainID = pa.Train.ID()
+    }
+    return map[string]interface{}{
+        "serviceCode":        pa.Service.ID(),
+        "lineIndex":          pa.LineIndex,
+        "trainId":            trainID,
+        "plannedTrack":       pa.PlannedTrack,
+        "assignedTrack":      pa.AssignedTrack,
+        "actualTrack":        pa.ActualTrack,
+        "reassigned":         pa.Reassigned(),
+        "scheduledArrival":   clockTime(s, line.ScheduledArrivalTime.Time),
+        "scheduledDeparture": clockTime(s, line.ScheduledDepartureTime.Time),
+        "expectedArrival":    clockTime(s, pa.Arrival),
+        "expectedDeparture":  clockTime(s, pa.Departure),
+        "delayMinutes":       int(pa.Delay / time.Minute),
+    }
+}
+
+// platformConflictView returns the JSON representation of a double-booked platform
+func platformConflictView(s *simulation.Simulation, pc simulation.PlatformConflict) map[string]interface{} {
+    return map[string]interface{}{
+        "placeCode": pc.PlaceCode,
+        "trackCode": pc.TrackCode,
+        "first":     platformAllocationView(s, pc.First),
+        "second":    platformAllocationView(s, pc.Second),
+    }
+}
+
+// platformPlanView returns the platform allocation plan of the given place
+// of s
+func platformPlanView(s *simulation.Simulation, pl *simulation.Place) map[string]interface{} {
+    allocs, _ := s.PlatformPlan(pl.PlaceCode)
+    conflicts, _ := s.PlatformConflicts(pl.PlaceCode)
+    tracks := make([]map[string]interface{}, 0)
+    for _, tc := range pl.TrackCodes() {
+        tracks = append(tracks, map[string]interface{}{"trackCode": tc, "length": pl.PlatformLength(tc)})
+    }
+    allocations := make([]map[string]interface{}, len(allocs))
+    for i, pa := range allocs {
+        allocations[i] = platformAllocationView(s, pa)
+    }
+    cs := make([]map[string]interface{}, len(conflicts))
+    for i, pc := range conflicts {
+        cs[i] = platformConflictView(s, pc)
+    }
+    return map[string]interface{}{
+        "placeCode":   pl.PlaceCode,
+        "name":        pl.Name(),
+        "tracks":      tracks,
+        "allocations": allocations,
+        "conflicts":   cs,
+    }
+}
+
+// GET /api/places/{code}/platforms
+// POST /api/places/{code}/platforms
+//
+// GET returns the platform allocation plan of the place, for station display
+// boards. POST assigns a service to another platform of the place and returns
+// the updated plan.
+func servePlacePlatforms(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/places/"), "/")
+    if len(parts) != 2 || parts[1] != "platforms" {
+        serveAPINotFound(w, r)
+        return
+    }
+    pl, ok := h.sim.Places[parts[0]]
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodePlaceNotFound, "Place not found", map[string]interface{}{"placeCode": parts[0]})
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var body platformAssignmentRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        srv, ok := h.sim.Services[body.ServiceCode]
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeServiceNotFound, "Service not found", map[string]interface{}{"serviceId": body.ServiceCode})
+            return
+        }
+        if body.LineIndex < 0 || body.LineIndex >= len(srv.Lines) || srv.Lines[body.LineIndex].PlaceCode != pl.PlaceCode {
+            invalidParameter(w, "The service line is not at this place", map[string]interface{}{"serviceId": body.ServiceCode, "lineIndex": body.LineIndex})
+            return
+        }
+        if err := h.sim.AssignPlatform(body.ServiceCode, body.LineIndex, body.TrackCode); err != nil {
+            invalidParameter(w, err.Error(), map[string]interface{}{"serviceId": body.ServiceCode, "trackCode": body.TrackCode})
+            return
+        }
+    default:
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(platformPlanView(h.sim, pl))
+}
+
+// GET /api/platforms/conflicts
+//
+// Returns the platforms booked by two services at the same time at all places.
+func servePlatformConflicts(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    conflicts, _ := h.sim.PlatformConflicts("")
+    items := make([]map[string]interface{}, len(conflicts))
+    for i, pc := range conflicts {
+        items[i] = platformConflictView(h.sim, pc)
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
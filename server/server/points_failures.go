@@ -0,0 +1,129 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// pointsFailureRequest is the body of a points failure request, from HTTP or
+// from the hub. RepairMinutes works as for signal failures.
+type pointsFailureRequest struct {
+    PointsID      string `json:"pointsId"`
+    Mode          string `json:"mode"`
+    Reason        string `json:"reason"`
+    RepairMinutes *int   `json:"repairMinutes"`
+}
+
+// failPoints makes the points of fr malfunction in the simulation s
+func failPoints(s *simulation.Simulation, fr pointsFailureRequest) (*simulation.PointsItem, error) {
+    pi, ok := s.TrackItems[fr.PointsID].(*simulation.PointsItem)
+    if !ok {
+        return nil, fmt.Errorf("unknown points: %s", fr.PointsID)
+    }
+    mode, err := simulation.ParsePointsFailureMode(strings.ToUpper(fr.Mode))
+    if err != nil {
+        return nil, err
+    }
+    repairIn := s.PointsMTTR()
+    if fr.RepairMinutes != nil {
+        if *fr.RepairMinutes < 0 {
+            return nil, fmt.Errorf("repairMinutes must be positive")
+        }
+        repairIn = time.Duration(*fr.RepairMinutes) * time.Minute
+    }
+    cause := fr.Reason
+    if cause == "" {
+        cause = "Manual failure"
+    }
+    pi.Fail(mode, cause, repairIn)
+    return pi, nil
+}
+
+// pointsStatus returns the position and malfunction state of the given points
+func pointsStatus(id string, pi *simulation.PointsItem) map[string]interface{} {
+    status := "OPERATIONAL"
+    if pi.Failed() {
+        status = "FAILED"
+    }
+    var repairTime string
+    if !pi.RepairTime().IsZero() {
+        repairTime = pi.RepairTime().Format(time.RFC3339)
+    }
+    return map[string]interface{}{
+        "pointsId":          id,
+        "name":              pi.Name(),
+        "reversed":          pi.Reversed(),
+        "locked":            pi.Locked(),
+        "malfunctionStatus": status,
+        "failureMode":       string(pi.FailureMode()),
+        "cause":             pi.FailureCause(),
+        "repairTime":        repairTime,
+    }
+}
+
+// GET /api/systems/points
+func servePoints(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    points := []map[string]interface{}{}
+    for id, ti := range h.sim.TrackItems {
+        if pi, ok := ti.(*simulation.PointsItem); ok {
+            points = append(points, pointsStatus(id, pi))
+        }
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"points": points})
+}
+
+// PUT /api/systems/points/{pointsId}/failure
+// DELETE /api/systems/points/{pointsId}/failure
+func servePointsFailure(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    pid := strings.TrimPrefix(r.URL.Path, "/api/systems/points/")
+    if !strings.HasSuffix(pid, "/failure") {
+        serveAPINotFound(w, r)
+        return
+    }
+    pid = strings.TrimSuffix(pid, "/failure")
+    pi, ok := h.sim.TrackItems[pid].(*simulation.PointsItem)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodePointsNotFound, "Points not found", map[string]interface{}{"pointsId": pid})
+        return
+    }
+    switch r.Method {
+    case http.MethodPut:
+        var body pointsFailureRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        body.PointsID = pid
+        if _, err := failPoints(h.sim, body); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+    case http.MethodDelete:
+        pi.Repair()
+    default:
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(pointsStatus(pid, pi))
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// possessionRequest is the body of a possession creation request, from
+// HTTP or from the hub.
+type possessionRequest struct {
+    TrackItemID     string `json:"trackItemId"`
+    ToTrackItemID   string `json:"toTrackItemId"`
+    StartTime       string `json:"startTime"`
+    EndTime         string `json:"endTime"`
+    DurationMinutes int    `json:"durationMinutes"`
+    Reason          string `json:"reason"`
+}
+
+// planPossession creates the possession described by pr and adds it to the simulation s
+func planPossession(s *simulation.Simulation, pr possessionRequest) (*simulation.Possession, error) {
+    p := &simulation.Possession{
+        TrackItemID:   pr.TrackItemID,
+        ToTrackItemID: pr.ToTrackItemID,
+        Reason:        pr.Reason,
+    }
+    start, err := parseSimTime(s, pr.StartTime)
+    if err != nil {
+        return nil, err
+    }
+    end, err := parseSimTime(s, pr.EndTime)
+    if err != nil {
+        return nil, err
+    }
+    if pr.DurationMinutes < 0 {
+        return nil, fmt.Errorf("durationMinutes must be positive")
+    }
+    if end.IsZero() && pr.DurationMinutes > 0 {
+        from := start.Time
+        if from.IsZero() {
+            from = s.Options.CurrentTime.Time
+        }
+        end.Time = from.Add(time.Duration(pr.DurationMinutes) * time.Minute)
+    }
+    p.StartTime.Time = start.Time
+    p.EndTime.Time = end.Time
+    if err := s.AddPossession(p); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+// GET /api/possessions
+// POST /api/possessions
+func servePossessions(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.Possessions()})
+    case http.MethodPost:
+        var body possessionRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        p, err := planPossession(h.sim, body)
+        if err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", resourceLocation(r, "/api/possessions/"+p.ID()))
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(p)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/possessions/{id}
+// DELETE /api/possessions/{id}
+func servePossession(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/possessions/")
+    switch r.Method {
+    case http.MethodGet:
+        p, ok := h.sim.GetPossession(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodePossessionNotFound, "Possession not found", map[string]interface{}{"possessionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(p)
+    case http.MethodDelete:
+        if err := h.sim.RemovePossession(id); err != nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodePossessionNotFound, "Possession not found", map[string]interface{}{"possessionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
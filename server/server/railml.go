@@ -0,0 +1,50 @@
+package server
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+)
+
+// maxRailMLSize is the maximum size in bytes of an uploaded railML document
+const maxRailMLSize = 64 << 20
+
+// GET /api/systems/railml
+// POST /api/systems/railml
+//
+// GET exports the infrastructure, train types and timetable of the
+// simulation as railML. POST imports the timetable and the speed limits of a
+// railML document into the simulation.
+func serveRailML(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        data, err := h.sim.ExportRailML()
+        if err != nil {
+            internalError(w, "Unable to export railML", err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+        w.Header().Set("Content-Disposition", `attachment; filename="simulation.railml"`)
+        _, _ = w.Write(data)
+    case http.MethodPost:
+        data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRailMLSize))
+        if err != nil {
+            badRequest(w, err)
+            return
+        }
+        res, err := h.sim.ImportRailML(data)
+        if err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(res)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
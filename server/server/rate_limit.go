@@ -0,0 +1,119 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitWindow is the period over which rejected requests are counted
+	// to decide whether a client must be disconnected.
+	rateLimitWindow = 10 * time.Second
+	// rateLimitMaxRejections is the number of rejected requests within
+	// rateLimitWindow after which a client is disconnected.
+	rateLimitMaxRejections = 100
+)
+
+var (
+	// requestRate is the number of requests per second a websocket client may
+	// send in the long run. Zero disables rate limiting.
+	requestRate float64 = 50
+	// requestBurst is the number of requests a websocket client may send at
+	// once above requestRate.
+	requestBurst   = 100
+	rateLimitMutex sync.RWMutex
+)
+
+// SetRateLimit sets the number of requests per second and the burst allowed
+// for each websocket client. A zero rate disables rate limiting.
+func SetRateLimit(rate float64, burst int) error {
+	if rate < 0 {
+		return fmt.Errorf("rate limit must be positive")
+	}
+	if rate > 0 && burst < 1 {
+		return fmt.Errorf("rate limit burst must be at least 1")
+	}
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+	requestRate = rate
+	requestBurst = burst
+	return nil
+}
+
+// A rateLimiter is a token bucket limiting the requests of a connection. It
+// also counts rejected requests to detect clients that persistently exceed
+// their limit.
+//
+// A nil rateLimiter allows all requests. It is not safe for concurrent use.
+type rateLimiter struct {
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	windowStart time.Time
+	rejections  int
+}
+
+// newRateLimiter returns a rateLimiter with the current settings, or nil if
+// rate limiting is disabled.
+func newRateLimiter() *rateLimiter {
+	rateLimitMutex.RLock()
+	defer rateLimitMutex.RUnlock()
+	if requestRate == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   requestRate,
+		burst:  float64(requestBurst),
+		tokens: float64(requestBurst),
+	}
+}
+
+// allow returns true if a request received at now may be processed.
+func (rl *rateLimiter) allow(now time.Time) bool {
+	if rl == nil {
+		return true
+	}
+	if !rl.last.IsZero() {
+		rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+	}
+	rl.last = now
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return true
+	}
+	if now.Sub(rl.windowStart) > rateLimitWindow {
+		rl.windowStart = now
+		rl.rejections = 0
+	}
+	rl.rejections++
+	return false
+}
+
+// exceeded returns true if the client has been rejected too many times within
+// the current window and must be disconnected.
+func (rl *rateLimiter) exceeded() bool {
+	return rl != nil && rl.rejections > rateLimitMaxRejections
+}
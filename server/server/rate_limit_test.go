@@ -0,0 +1,69 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	Convey("Testing request rate limiting", t, func() {
+		now := time.Date(2019, 1, 1, 6, 0, 0, 0, time.UTC)
+		Convey("A disabled limiter should allow everything", func() {
+			var rl *rateLimiter
+			So(rl.allow(now), ShouldBeTrue)
+			So(rl.exceeded(), ShouldBeFalse)
+		})
+		Convey("Requests above the burst should be rejected until tokens are refilled", func() {
+			rl := &rateLimiter{rate: 2, burst: 3, tokens: 3}
+			So(rl.allow(now), ShouldBeTrue)
+			So(rl.allow(now), ShouldBeTrue)
+			So(rl.allow(now), ShouldBeTrue)
+			So(rl.allow(now), ShouldBeFalse)
+			So(rl.allow(now.Add(500*time.Millisecond)), ShouldBeTrue)
+			So(rl.allow(now.Add(500*time.Millisecond)), ShouldBeFalse)
+			So(rl.allow(now.Add(time.Hour)), ShouldBeTrue)
+			So(rl.tokens, ShouldEqual, 2)
+		})
+		Convey("Clients persistently exceeding their limit should be flagged", func() {
+			rl := &rateLimiter{rate: 1, burst: 1}
+			for i := 0; i < rateLimitMaxRejections; i++ {
+				So(rl.allow(now), ShouldBeFalse)
+			}
+			So(rl.exceeded(), ShouldBeFalse)
+			So(rl.allow(now), ShouldBeFalse)
+			So(rl.exceeded(), ShouldBeTrue)
+		})
+		Convey("Rejections should be forgotten after the window", func() {
+			rl := &rateLimiter{rate: 1, burst: 1}
+			for i := 0; i < rateLimitMaxRejections; i++ {
+				rl.allow(now)
+			}
+			rl.tokens = 0
+			rl.last = now.Add(rateLimitWindow + time.Second)
+			So(rl.allow(now.Add(rateLimitWindow+time.Second)), ShouldBeFalse)
+			So(rl.rejections, ShouldEqual, 1)
+		})
+		Convey("Invalid settings should be refused", func() {
+			So(SetRateLimit(-1, 10), ShouldNotBeNil)
+			So(SetRateLimit(10, 0), ShouldNotBeNil)
+		})
+		Convey("Clients should get RATE_LIMITED responses", func() {
+			So(SetRateLimit(0.1, 2), ShouldBeNil)
+			defer func() { So(SetRateLimit(50, 100), ShouldBeNil) }()
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "server", "renotify", "")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "server", "renotify", "")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "server", "renotify", "")
+			So(resp.Data.Status, ShouldEqual, RateLimited)
+		})
+	})
+}
@@ -0,0 +1,445 @@
+package server
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "math"
+    "net/http"
+    "net/url"
+    "os"
+    "regexp"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    remoteWriteMaxAttempts = 3
+    remoteWriteTimeout     = 30 * time.Second
+)
+
+// remoteWriteRetryDelay is the delay before the first retry of a failed push.
+// It doubles at each attempt.
+var remoteWriteRetryDelay = time.Second
+
+// processStart is the time the server started, for the uptime metric
+var processStart = time.Now()
+
+// remoteWriteLabelName matches valid Prometheus label names
+var remoteWriteLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// RemoteWriteConfig is the configuration of the Prometheus remote-write
+// client, which pushes the KPI and runtime metrics of the server.
+type RemoteWriteConfig struct {
+    // URL is the remote-write endpoint, e.g. http://prometheus:9090/api/v1/write
+    URL string
+    // Interval is the time between two pushes
+    Interval time.Duration
+    // Headers are added to the requests, e.g. for authentication
+    Headers map[string]string
+    // Labels are added to all series. job and instance default to
+    // ts2-sim-server and the host name.
+    Labels map[string]string
+}
+
+// DefaultRemoteWriteConfig returns the default remote-write configuration,
+// without URL.
+func DefaultRemoteWriteConfig() RemoteWriteConfig {
+    return RemoteWriteConfig{Interval: 30 * time.Second}
+}
+
+// validate checks the configuration
+func (rc RemoteWriteConfig) validate() error {
+    u, err := url.Parse(rc.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("invalid remote-write URL: %s", rc.URL)
+    }
+    if rc.Interval < time.Second {
+        return fmt.Errorf("remote-write interval must be at least 1s")
+    }
+    for k := range rc.Labels {
+        if !remoteWriteLabelName.MatchString(k) || strings.HasPrefix(k, "__") {
+            return fmt.Errorf("invalid remote-write label name: %s", k)
+        }
+    }
+    return nil
+}
+
+// A remoteWriteSeries is a time series with one sample
+type remoteWriteSeries struct {
+    labels [][2]string
+    value  float64
+}
+
+// A remoteWriter pushes the metrics of the server to a Prometheus remote-write
+// endpoint every interval.
+//
+// Each push holds one sample of each series, taken at the time of the push.
+// Failed pushes are retried with an exponential backoff, unless the endpoint
+// refuses the data with a 4xx status. Samples that could not be pushed are
+// dropped.
+type remoteWriter struct {
+    config RemoteWriteConfig
+    labels [][2]string
+    client *http.Client
+
+    mutex      sync.Mutex
+    pushes     int
+    samples    int
+    failed     int
+    retries    int
+    lastStatus int
+    lastError  string
+    lastPushAt time.Time
+}
+
+var (
+    remoteWrite      *remoteWriter
+    remoteWriteMutex sync.RWMutex
+)
+
+// SetRemoteWriteConfig configures the Prometheus remote-write client. Metrics
+// are pushed when the server runs.
+func SetRemoteWriteConfig(rc RemoteWriteConfig) error {
+    if err := rc.validate(); err != nil {
+        return err
+    }
+    labels := map[string]string{"job": "ts2-sim-server"}
+    if host, err := os.Hostname(); err == nil {
+        labels["instance"] = host
+    }
+    for k, v := range rc.Labels {
+        labels[k] = v
+    }
+    rw := &remoteWriter{config: rc, client: &http.Client{Timeout: remoteWriteTimeout}}
+    for k, v := range labels {
+        rw.labels = append(rw.labels, [2]string{k, v})
+    }
+    remoteWriteMutex.Lock()
+    defer remoteWriteMutex.Unlock()
+    remoteWrite = rw
+    return nil
+}
+
+// currentRemoteWriter returns the remote-write client or nil if it is not
+// configured
+func currentRemoteWriter() *remoteWriter {
+    remoteWriteMutex.RLock()
+    defer remoteWriteMutex.RUnlock()
+    return remoteWrite
+}
+
+// startRemoteWriter starts pushing metrics if remote-write is configured
+func startRemoteWriter() {
+    if rw := currentRemoteWriter(); rw != nil {
+        go rw.run()
+    }
+}
+
+// run pushes the metrics every interval
+func (rw *remoteWriter) run() {
+    ticker := time.NewTicker(rw.config.Interval)
+    defer ticker.Stop()
+    for range ticker.C {
+        rw.push()
+    }
+}
+
+// push collects the metrics and sends them
+func (rw *remoteWriter) push() {
+    now := time.Now()
+    series := rw.collect()
+    body := snappyEncode(remoteWriteRequest(series, now))
+    delay := remoteWriteRetryDelay
+    var (
+        status int
+        err    error
+    )
+    for attempt := 1; attempt <= remoteWriteMaxAttempts; attempt++ {
+        var retry bool
+        status, retry, err = rw.send(body)
+        if err == nil || !retry || attempt == remoteWriteMaxAttempts {
+            break
+        }
+        rw.mutex.Lock()
+        rw.retries++
+        rw.mutex.Unlock()
+        time.Sleep(delay)
+        delay *= 2
+    }
+    rw.mutex.Lock()
+    defer rw.mutex.Unlock()
+    rw.lastStatus = status
+    rw.lastPushAt = now.UTC()
+    if err != nil {
+        rw.failed++
+        rw.lastError = err.Error()
+        logger.Warn("Unable to push metrics", "submodule", "remotewrite", "url", rw.config.URL, "error", err)
+        return
+    }
+    rw.pushes++
+    rw.samples += len(series)
+    rw.lastError = ""
+}
+
+// send posts the compressed write request. It returns whether the push may
+// be retried if it failed.
+func (rw *remoteWriter) send(body []byte) (int, bool, error) {
+    req, err := http.NewRequest(http.MethodPost, rw.config.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, false, err
+    }
+    req.Header.Set("Content-Type", "application/x-protobuf")
+    req.Header.Set("Content-Encoding", "snappy")
+    req.Header.Set("User-Agent", "ts2-sim-server")
+    req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+    for k, v := range rw.config.Headers {
+        req.Header.Set(k, v)
+    }
+    resp, err := rw.client.Do(req)
+    if err != nil {
+        return 0, true, err
+    }
+    msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+    _ = resp.Body.Close()
+    if resp.StatusCode/100 == 2 {
+        return resp.StatusCode, false, nil
+    }
+    err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+    return resp.StatusCode, resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// series returns a series with the given name, labels and value
+func (rw *remoteWriter) series(name string, value float64, labels ...string) remoteWriteSeries {
+    s := remoteWriteSeries{value: value}
+    s.labels = append(s.labels, [2]string{"__name__", name})
+    s.labels = append(s.labels, rw.labels...)
+    for i := 0; i+1 < len(labels); i += 2 {
+        s.labels = append(s.labels, [2]string{labels[i], labels[i+1]})
+    }
+    sort.Slice(s.labels, func(i, j int) bool { return s.labels[i][0] < s.labels[j][0] })
+    return s
+}
+
+// collect returns the current value of the runtime metrics of the server and
+// of the KPI and state metrics of each simulation.
+func (rw *remoteWriter) collect() []remoteWriteSeries {
+    var ms runtime.MemStats
+    runtime.ReadMemStats(&ms)
+    conns := connStats.report()
+    res := []remoteWriteSeries{
+        rw.series("process_uptime_seconds", time.Since(processStart).Seconds()),
+        rw.series("go_goroutines", float64(runtime.NumGoroutine())),
+        rw.series("go_memstats_heap_alloc_bytes", float64(ms.HeapAlloc)),
+        rw.series("go_memstats_sys_bytes", float64(ms.Sys)),
+        rw.series("go_gc_cycles_total", float64(ms.NumGC)),
+        rw.series("ts2_websocket_connections", float64(conns["active"].(int64))),
+        rw.series("ts2_websocket_connections_opened_total", float64(conns["opened"].(int64))),
+    }
+    for _, h := range simulations.list() {
+        running := 0.0
+        if h.sim.IsStarted() {
+            running = 1
+        }
+        active := 0
+        for _, t := range h.sim.Trains {
+            if t.IsActive() {
+                active++
+            }
+        }
+        res = append(res,
+            rw.series("ts2_simulation_running", running, "simulation", h.id),
+            rw.series("ts2_simulation_trains_active", float64(active), "simulation", h.id),
+            rw.series("ts2_simulation_clients", float64(atomic.LoadInt32(&h.clientCount)), "simulation", h.id),
+        )
+        h.metrics.mu.RLock()
+        if n := len(h.metrics.snapshots); n > 0 {
+            for name, v := range h.metrics.snapshots[n-1].kpis() {
+                var f float64
+                switch val := v.(type) {
+                case float64:
+                    f = val
+                case int:
+                    f = float64(val)
+                default:
+                    continue
+                }
+                if math.IsNaN(f) {
+                    continue
+                }
+                res = append(res, rw.series("ts2_kpi_"+prometheusName(name), f, "simulation", h.id))
+            }
+        }
+        h.metrics.mu.RUnlock()
+    }
+    return res
+}
+
+// prometheusName converts a camel case KPI name to a snake case metric name
+func prometheusName(s string) string {
+    var b strings.Builder
+    for i, r := range s {
+        if r >= 'A' && r <= 'Z' {
+            if i > 0 {
+                b.WriteByte('_')
+            }
+            r += 'a' - 'A'
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
+
+// view returns the status of the remote-write client for the API
+func (rw *remoteWriter) view() map[string]interface{} {
+    rw.mutex.Lock()
+    defer rw.mutex.Unlock()
+    labels := make(map[string]string)
+    for _, l := range rw.labels {
+        labels[l[0]] = l[1]
+    }
+    res := map[string]interface{}{
+        "enabled":         true,
+        "url":             rw.config.URL,
+        "intervalSeconds": rw.config.Interval.Seconds(),
+        "labels":          labels,
+        "stats": map[string]interface{}{
+            "pushes":  rw.pushes,
+            "samples": rw.samples,
+            "failed":  rw.failed,
+            "retries": rw.retries,
+        },
+        "lastStatus": rw.lastStatus,
+        "lastError":  rw.lastError,
+    }
+    if !rw.lastPushAt.IsZero() {
+        res["lastPushAt"] = rw.lastPushAt.Format(time.RFC3339)
+    }
+    return res
+}
+
+// remoteWriteRequest returns the protobuf encoding of a prometheus.WriteRequest
+// holding the given series with one sample at time t.
+func remoteWriteRequest(series []remoteWriteSeries, t time.Time) []byte {
+    var req []byte
+    for _, s := range series {
+        var ts []byte
+        for _, l := range s.labels {
+            var label []byte
+            label = protoString(label, 1, l[0])
+            label = protoString(label, 2, l[1])
+            ts = protoBytes(ts, 1, label)
+        }
+        var sample []byte
+        sample = append(sample, 1<<3|1)
+        sample = append(sample, make([]byte, 8)...)
+        binary.LittleEndian.PutUint64(sample[1:], math.Float64bits(s.value))
+        sample = append(sample, 2<<3)
+        sample = appendUvarint(sample, uint64(t.UnixNano()/int64(time.Millisecond)))
+        ts = protoBytes(ts, 2, sample)
+        req = protoBytes(req, 1, ts)
+    }
+    return req
+}
+
+// appendUvarint appends the varint encoding of v to b
+func appendUvarint(b []byte, v uint64) []byte {
+    for v >= 0x80 {
+        b = append(b, byte(v)|0x80)
+        v >>= 7
+    }
+    return append(b, byte(v))
+}
+
+// protoBytes appends a length-delimited field to b
+func protoBytes(b []byte, field int, v []byte) []byte {
+    b = appendUvarint(b, uint64(field<<3|2))
+    b = appendUvarint(b, uint64(len(v)))
+    return append(b, v...)
+}
+
+// protoString appends a string field to b
+func protoString(b []byte, field int, v string) []byte {
+    return protoBytes(b, field, []byte(v))
+}
+
+// snappyEncode compresses src in the snappy block format, as required by
+// the remote-write protocol. Matches are looked for with a hash table of the
+// last position of each 4 byte sequence.
+func snappyEncode(src []byte) []byte {
+    dst := appendUvarint(nil, uint64(len(src)))
+    var table [1 << 14]int32
+    lit := 0
+    for i := 0; i+4 <= len(src); {
+        cur := binary.LittleEndian.Uint32(src[i:])
+        h := (cur * 0x1e35a7bd) >> 18
+        cand := int(table[h]) - 1
+        table[h] = int32(i + 1)
+        if cand < 0 || i-cand > math.MaxUint16 || binary.LittleEndian.Uint32(src[cand:]) != cur {
+            i++
+            continue
+        }
+        dst = snappyLiteral(dst, src[lit:i])
+        n := 4
+        for i+n < len(src) && src[cand+n] == src[i+n] {
+            n++
+        }
+        offset := i - cand
+        i += n
+        lit = i
+        for n > 0 {
+            l := n
+            if l > 64 {
+                l = 64
+            }
+            dst = append(dst, byte((l-1)<<2|2), byte(offset), byte(offset>>8))
+            n -= l
+        }
+    }
+    return snappyLiteral(dst, src[lit:])
+}
+
+// snappyLiteral appends a literal element holding lit to dst
+func snappyLiteral(dst, lit []byte) []byte {
+    if len(lit) == 0 {
+        return dst
+    }
+    n := uint32(len(lit) - 1)
+    switch {
+    case n < 60:
+        dst = append(dst, byte(n<<2))
+    case n < 1<<8:
+        dst = append(dst, 60<<2, byte(n))
+    case n < 1<<16:
+        dst = append(dst, 61<<2, byte(n), byte(n>>8))
+    case n < 1<<24:
+        dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+    default:
+        dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+    }
+    return append(dst, lit...)
+}
+
+// GET /api/remote-write
+//
+// Returns the configuration and statistics of the Prometheus remote-write
+// client.
+func serveRemoteWrite(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    res := map[string]interface{}{"enabled": false}
+    if rw := currentRemoteWriter(); rw != nil {
+        res = rw.view()
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,255 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// snappyTestDecode decompresses a snappy block
+func snappyTestDecode(src []byte) ([]byte, error) {
+	n, l := binary.Uvarint(src)
+	if l <= 0 {
+		return nil, errors.New("invalid length")
+	}
+	src = src[l:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 3 {
+		case 0:
+			m := int(tag >> 2)
+			src = src[1:]
+			if m >= 60 {
+				k := m - 59
+				m = 0
+				for i := 0; i < k; i++ {
+					m |= int(src[i]) << (8 * uint(i))
+				}
+				src = src[k:]
+			}
+			m++
+			dst = append(dst, src[:m]...)
+			src = src[m:]
+		case 2:
+			m := int(tag>>2) + 1
+			offset := int(src[1]) | int(src[2])<<8
+			src = src[3:]
+			if offset == 0 || offset > len(dst) {
+				return nil, errors.New("invalid offset")
+			}
+			for i := 0; i < m; i++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+		default:
+			return nil, errors.New("unsupported element")
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errors.New("length mismatch")
+	}
+	return dst, nil
+}
+
+// protoTestFields returns the fields of a protobuf message by number. Varint
+// and fixed64 values are returned as uint64, others as []byte.
+func protoTestFields(b []byte) map[int][]interface{} {
+	res := make(map[int][]interface{})
+	for len(b) > 0 {
+		key, l := binary.Uvarint(b)
+		b = b[l:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, l := binary.Uvarint(b)
+			b = b[l:]
+			res[field] = append(res[field], v)
+		case 1:
+			res[field] = append(res[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			n, l := binary.Uvarint(b)
+			b = b[l:]
+			res[field] = append(res[field], b[:n])
+			b = b[n:]
+		}
+	}
+	return res
+}
+
+// remoteWriteTestSample is a decoded series with its sample
+type remoteWriteTestSample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// decodeTestWriteRequest decodes a prometheus.WriteRequest
+func decodeTestWriteRequest(b []byte) []remoteWriteTestSample {
+	var res []remoteWriteTestSample
+	for _, ts := range protoTestFields(b)[1] {
+		fields := protoTestFields(ts.([]byte))
+		s := remoteWriteTestSample{labels: make(map[string]string)}
+		for _, l := range fields[1] {
+			lf := protoTestFields(l.([]byte))
+			s.labels[string(lf[1][0].([]byte))] = string(lf[2][0].([]byte))
+		}
+		sf := protoTestFields(fields[2][0].([]byte))
+		s.value = math.Float64frombits(sf[1][0].(uint64))
+		s.timestamp = int64(sf[2][0].(uint64))
+		res = append(res, s)
+	}
+	return res
+}
+
+func TestRemoteWrite(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the Prometheus remote-write client", t, func() {
+		Convey("Snappy compression should round trip", func() {
+			rnd := rand.New(rand.NewSource(1))
+			random := make([]byte, 5000)
+			rnd.Read(random)
+			for _, data := range [][]byte{
+				nil,
+				[]byte("abc"),
+				bytes.Repeat([]byte("ts2_kpi_punctuality"), 500),
+				random,
+				append(bytes.Repeat([]byte{0}, 70000), random...),
+			} {
+				enc := snappyEncode(data)
+				dec, err := snappyTestDecode(enc)
+				So(err, ShouldBeNil)
+				So(bytes.Equal(dec, data), ShouldBeTrue)
+			}
+			So(len(snappyEncode(bytes.Repeat([]byte("abcd"), 1000))), ShouldBeLessThan, 200)
+		})
+		Convey("Configuration should be validated", func() {
+			rc := DefaultRemoteWriteConfig()
+			So(SetRemoteWriteConfig(rc), ShouldNotBeNil)
+			rc.URL = "ftp://prometheus/write"
+			So(SetRemoteWriteConfig(rc), ShouldNotBeNil)
+			rc.URL = "http://prometheus:9090/api/v1/write"
+			rc.Interval = 100 * time.Millisecond
+			So(SetRemoteWriteConfig(rc), ShouldNotBeNil)
+			rc.Interval = time.Minute
+			rc.Labels = map[string]string{"__name__": "x"}
+			So(SetRemoteWriteConfig(rc), ShouldNotBeNil)
+			So(currentRemoteWriter(), ShouldBeNil)
+		})
+		Convey("Metrics should be pushed", func() {
+			var (
+				mutex    sync.Mutex
+				requests []*http.Request
+				bodies   [][]byte
+				failures = 1
+			)
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				mutex.Lock()
+				defer mutex.Unlock()
+				if failures > 0 {
+					failures--
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				requests = append(requests, r)
+				bodies = append(bodies, body)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer target.Close()
+			retryDelay := remoteWriteRetryDelay
+			remoteWriteRetryDelay = 10 * time.Millisecond
+			defer func() { remoteWriteRetryDelay = retryDelay }()
+
+			rc := DefaultRemoteWriteConfig()
+			rc.URL = target.URL
+			rc.Headers = map[string]string{"Authorization": "Bearer token"}
+			rc.Labels = map[string]string{"cluster": "test"}
+			So(SetRemoteWriteConfig(rc), ShouldBeNil)
+			defer func() {
+				remoteWriteMutex.Lock()
+				remoteWrite = nil
+				remoteWriteMutex.Unlock()
+			}()
+			hub.takeSnapshot()
+			rw := currentRemoteWriter()
+			rw.push()
+
+			mutex.Lock()
+			So(requests, ShouldHaveLength, 1)
+			req, body := requests[0], bodies[0]
+			mutex.Unlock()
+			So(req.Header.Get("Content-Encoding"), ShouldEqual, "snappy")
+			So(req.Header.Get("Content-Type"), ShouldEqual, "application/x-protobuf")
+			So(req.Header.Get("X-Prometheus-Remote-Write-Version"), ShouldEqual, "0.1.0")
+			So(req.Header.Get("Authorization"), ShouldEqual, "Bearer token")
+			data, err := snappyTestDecode(body)
+			So(err, ShouldBeNil)
+			samples := decodeTestWriteRequest(data)
+			byName := make(map[string]remoteWriteTestSample)
+			for _, s := range samples {
+				So(s.labels["job"], ShouldEqual, "ts2-sim-server")
+				So(s.labels["cluster"], ShouldEqual, "test")
+				So(s.timestamp, ShouldBeGreaterThan, time.Now().Add(-time.Minute).UnixNano()/int64(time.Millisecond))
+				if s.labels["simulation"] == "" || s.labels["simulation"] == "default" {
+					byName[s.labels["__name__"]] = s
+				}
+			}
+			So(byName, ShouldContainKey, "go_goroutines")
+			So(byName["go_goroutines"].value, ShouldBeGreaterThan, 0)
+			So(byName, ShouldContainKey, "process_uptime_seconds")
+			So(byName, ShouldContainKey, "ts2_simulation_trains_active")
+			So(byName, ShouldContainKey, "ts2_kpi_punctuality")
+			So(byName, ShouldContainKey, "ts2_kpi_average_delay")
+
+			view := rw.view()
+			So(view["stats"].(map[string]interface{})["pushes"], ShouldEqual, 1)
+			So(view["stats"].(map[string]interface{})["retries"], ShouldEqual, 1)
+			So(view["lastStatus"], ShouldEqual, http.StatusNoContent)
+
+			resp, err := http.Get("http://127.0.0.1:22222/api/remote-write")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var status map[string]interface{}
+			So(json.NewDecoder(resp.Body).Decode(&status), ShouldBeNil)
+			So(status["enabled"], ShouldBeTrue)
+			So(status["url"], ShouldEqual, target.URL)
+		})
+		Convey("Refused pushes should not be retried", func() {
+			var calls int
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			defer target.Close()
+			rc := DefaultRemoteWriteConfig()
+			rc.URL = target.URL
+			So(SetRemoteWriteConfig(rc), ShouldBeNil)
+			defer func() {
+				remoteWriteMutex.Lock()
+				remoteWrite = nil
+				remoteWriteMutex.Unlock()
+			}()
+			rw := currentRemoteWriter()
+			rw.push()
+			So(calls, ShouldEqual, 1)
+			So(rw.view()["stats"].(map[string]interface{})["failed"], ShouldEqual, 1)
+			So(rw.view()["lastError"], ShouldStartWith, "unexpected status 400")
+		})
+	})
+}
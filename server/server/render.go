@@ -0,0 +1,260 @@
+package server
+
+import (
+    "bytes"
+    "fmt"
+    "html"
+    "image"
+    "image/color"
+    "image/png"
+    "math"
+    "net/http"
+    "strconv"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+    renderDefaultWidth = 1200
+    renderMaxWidth     = 8000
+    renderPadding      = 20.0
+)
+
+var (
+    renderColorBackground = color.RGBA{0x1e, 0x1e, 0x1e, 0xff}
+    renderColorTrack      = color.RGBA{0xa0, 0xa0, 0xa0, 0xff}
+    renderColorRoute      = color.RGBA{0x33, 0xcc, 0x33, 0xff}
+    renderColorOccupied   = color.RGBA{0xff, 0x33, 0x33, 0xff}
+    renderColorProceed    = color.RGBA{0x00, 0xff, 0x00, 0xff}
+    renderColorDanger     = color.RGBA{0xff, 0x00, 0x00, 0xff}
+    renderColorTrain      = color.RGBA{0x33, 0x99, 0xff, 0xff}
+    renderColorText       = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+type renderLine struct {
+    x1, y1, x2, y2 float64
+    col            color.RGBA
+    width          float64
+}
+
+type renderCircle struct {
+    x, y, r float64
+    col     color.RGBA
+}
+
+type renderLabel struct {
+    x, y float64
+    text string
+    col  color.RGBA
+}
+
+// A layoutScene holds the drawing primitives of the layout in layout coordinates.
+type layoutScene struct {
+    lines   []renderLine
+    circles []renderCircle
+    labels  []renderLabel
+    minX    float64
+    minY    float64
+    maxX    float64
+    maxY    float64
+}
+
+func (ls *layoutScene) extend(x, y float64) {
+    ls.minX = math.Min(ls.minX, x)
+    ls.minY = math.Min(ls.minY, y)
+    ls.maxX = math.Max(ls.maxX, x)
+    ls.maxY = math.Max(ls.maxY, y)
+}
+
+func (ls *layoutScene) addLine(a, b simulation.Point, col color.RGBA, width float64) {
+    ls.lines = append(ls.lines, renderLine{a.X, a.Y, b.X, b.Y, col, width})
+    ls.extend(a.X, a.Y)
+    ls.extend(b.X, b.Y)
+}
+
+func (ls *layoutScene) addCircle(x, y, r float64, col color.RGBA) {
+    ls.circles = append(ls.circles, renderCircle{x, y, r, col})
+    ls.extend(x, y)
+}
+
+// trackColor returns the color in which the given track item should be drawn
+func trackColor(ti simulation.TrackItem) color.RGBA {
+    switch {
+    case ti.ShowsOccupied():
+        return renderColorOccupied
+    case ti.ActiveRoute() != nil:
+        return renderColorRoute
+    default:
+        return renderColorTrack
+    }
+}
+
+// buildLayoutScene computes the drawing primitives of the current state of the given simulation.
+func buildLayoutScene(s *simulation.Simulation) *layoutScene {
+    ls := &layoutScene{
+        minX: math.Inf(1), minY: math.Inf(1),
+        maxX: math.Inf(-1), maxY: math.Inf(-1),
+    }
+    for _, ti := range s.TrackItems {
+        switch v := ti.(type) {
+        case *simulation.LineItem:
+            ls.addLine(v.Origin(), v.End(), trackColor(v), 3)
+        case *simulation.PointsItem:
+            col := trackColor(v)
+            ls.addLine(v.Origin(), v.Center(), col, 3)
+            if v.Reversed() {
+                ls.addLine(v.Center(), v.Reverse(), col, 3)
+                ls.addLine(v.Center(), v.End(), renderColorTrack, 1)
+            } else {
+                ls.addLine(v.Center(), v.End(), col, 3)
+                ls.addLine(v.Center(), v.Reverse(), renderColorTrack, 1)
+            }
+        case *simulation.SignalItem:
+            col := renderColorDanger
+            if v.ActiveAspect().MeansProceed() {
+                col = renderColorProceed
+            }
+            ls.addCircle(v.Origin().X, v.Origin().Y-8, 4, col)
+        }
+    }
+    for _, pl := range s.Places {
+        ls.labels = append(ls.labels, renderLabel{pl.Origin().X, pl.Origin().Y, pl.Name(), renderColorText})
+        ls.extend(pl.Origin().X, pl.Origin().Y)
+    }
+    for _, t := range s.Trains {
+        if !t.IsActive() {
+            continue
+        }
+        x, y := positionXY(t.TrainHead)
+        ls.addCircle(x, y, 6, renderColorTrain)
+        ls.labels = append(ls.labels, renderLabel{x, y - 12, t.ServiceCode, renderColorTrain})
+    }
+    if math.IsInf(ls.minX, 1) {
+        ls.minX, ls.minY, ls.maxX, ls.maxY = 0, 0, 0, 0
+    }
+    return ls
+}
+
+// scale returns the scale factor and output height for the given output width
+func (ls *layoutScene) scale(width int) (float64, int) {
+    w := ls.maxX - ls.minX
+    h := ls.maxY - ls.minY
+    if w <= 0 {
+        w = 1
+    }
+    k := (float64(width) - 2*renderPadding) / w
+    if k <= 0 {
+        k = 1
+    }
+    if h*k+2*renderPadding > renderMaxWidth {
+        // Keep very tall layouts within bounds
+        k = (renderMaxWidth - 2*renderPadding) / h
+    }
+    return k, int(h*k + 2*renderPadding + 0.5)
+}
+
+func svgColor(c color.RGBA) string {
+    return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// svg renders the scene as a SVG document of the given width
+func (ls *layoutScene) svg(width int) []byte {
+    k, height := ls.scale(width)
+    tx := func(x float64) float64 { return (x-ls.minX)*k + renderPadding }
+    ty := func(y float64) float64 { return (y-ls.minY)*k + renderPadding }
+    var b bytes.Buffer
+    fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+    fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", svgColor(renderColorBackground))
+    for _, l := range ls.lines {
+        fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%.0f"/>`+"\n",
+            tx(l.x1), ty(l.y1), tx(l.x2), ty(l.y2), svgColor(l.col), l.width)
+    }
+    for _, c := range ls.circles {
+        fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="%.0f" fill="%s"/>`+"\n", tx(c.x), ty(c.y), c.r, svgColor(c.col))
+    }
+    for _, l := range ls.labels {
+        fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" fill="%s" font-family="sans-serif" font-size="11" text-anchor="middle">%s</text>`+"\n",
+            tx(l.x), ty(l.y), svgColor(l.col), html.EscapeString(l.text))
+    }
+    b.WriteString("</svg>\n")
+    return b.Bytes()
+}
+
+// png rasterizes the scene as a PNG image of the given width. Labels are not rendered.
+func (ls *layoutScene) png(width int) ([]byte, error) {
+    k, height := ls.scale(width)
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for i := 0; i < len(img.Pix); i += 4 {
+        img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = renderColorBackground.R, renderColorBackground.G, renderColorBackground.B, 0xff
+    }
+    tx := func(x float64) float64 { return (x-ls.minX)*k + renderPadding }
+    ty := func(y float64) float64 { return (y-ls.minY)*k + renderPadding }
+    for _, l := range ls.lines {
+        x1, y1, x2, y2 := tx(l.x1), ty(l.y1), tx(l.x2), ty(l.y2)
+        steps := int(math.Max(math.Abs(x2-x1), math.Abs(y2-y1))) + 1
+        for i := 0; i <= steps; i++ {
+            t := float64(i) / float64(steps)
+            fillDisc(img, x1+(x2-x1)*t, y1+(y2-y1)*t, l.width/2, l.col)
+        }
+    }
+    for _, c := range ls.circles {
+        fillDisc(img, tx(c.x), ty(c.y), c.r, c.col)
+    }
+    var b bytes.Buffer
+    if err := png.Encode(&b, img); err != nil {
+        return nil, err
+    }
+    return b.Bytes(), nil
+}
+
+// fillDisc paints a filled disc of radius r centered on (cx, cy)
+func fillDisc(img *image.RGBA, cx, cy, r float64, col color.RGBA) {
+    if r < 0.5 {
+        r = 0.5
+    }
+    for y := int(cy - r); y <= int(cy+r); y++ {
+        for x := int(cx - r); x <= int(cx+r); x++ {
+            dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+            if dx*dx+dy*dy <= r*r {
+                img.SetRGBA(x, y, col)
+            }
+        }
+    }
+}
+
+// GET /api/systems/layout.svg?width=1200
+// GET /api/systems/layout.png?width=1200
+func serveLayoutRender(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    width := renderDefaultWidth
+    if wp := r.URL.Query().Get("width"); wp != "" {
+        v, err := strconv.Atoi(wp)
+        if err != nil || v < 100 || v > renderMaxWidth {
+            invalidParameter(w, "Bad width", map[string]interface{}{"width": wp, "min": 100, "max": renderMaxWidth})
+            return
+        }
+        width = v
+    }
+    scene := buildLayoutScene(h.sim)
+    switch r.URL.Path {
+    case "/api/systems/layout.png":
+        data, err := scene.png(width)
+        if err != nil {
+            internalError(w, "Unable to render layout", err)
+            return
+        }
+        w.Header().Set("Content-Type", "image/png")
+        _, _ = w.Write(data)
+    default:
+        w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+        _, _ = w.Write(scene.svg(width))
+    }
+}
@@ -59,6 +59,10 @@ type Request struct {
 	Object string  `json:"object"`
 	Action string  `json:"action"`
 	Params RawJSON `json:"params"`
+	// Async requests are answered with job messages, see JobState.
+	Async bool `json:"async,omitempty"`
+	// TimeoutMs overrides the default timeout of this request.
+	TimeoutMs int `json:"timeoutMs,omitempty"`
 }
 
 // ParamsRegister is the struct of the Request Params for a RequestRegister
@@ -66,6 +70,20 @@ type ParamsRegister struct {
 	ClientType    ClientType  `json:"type"`
 	ClientSubType ManagerType `json:"subType"`
 	Token         string      `json:"token"`
+	// Role is the ClientRole of this client. It defaults to the role of the
+	// user of Token, and cannot be more privileged. Observer clients can only
+	// be observers.
+	Role string `json:"role"`
+	// User is the name of the dispatcher using this client, to which control
+	// areas can be assigned. It must be the user of Token, if any.
+	User string `json:"user"`
+	// CoalesceMs is the interval in milliseconds during which notifications
+	// of the same object are merged. 0 uses the server default, negative
+	// values disable coalescing.
+	CoalesceMs int `json:"coalesceMs"`
+	// SimID is the ID of the simulation to attach to. It overrides the sim
+	// parameter of the websocket URL.
+	SimID string `json:"simId"`
 }
 
 // RequestRegister is a request made by a websocket client to log onto the server.
@@ -78,8 +96,20 @@ type RequestRegister struct {
 
 // ParamsListener is the struct of the Request Params for a RequestListener
 type ParamsListener struct {
-	Event simulation.EventName `json:"event"`
-	IDs   []string             `json:"ids"`
+	Event  simulation.EventName `json:"event"`
+	IDs    []string             `json:"ids"`
+	Filter *ListenerFilter      `json:"filter,omitempty"`
+}
+
+// ListenerFilter narrows down the notifications sent to a listener.
+//
+// Each non empty field must match for the event to be sent. Objects that do
+// not relate to trains, places or sections never match a filter that sets
+// the corresponding field.
+type ListenerFilter struct {
+	TrainIDs   []string `json:"trainIds"`
+	PlaceCodes []string `json:"placeCodes"`
+	SectionIDs []string `json:"sectionIds"`
 }
 
 // RequestListener is a request made by a websocket client to add or remove a listener.
@@ -20,6 +20,7 @@ package server
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ts2/ts2-sim-server/simulation"
 )
@@ -27,8 +28,11 @@ import (
 type StatusCode string
 
 const (
-	Ok   StatusCode = "OK"
-	Fail StatusCode = "FAIL"
+	Ok               StatusCode = "OK"
+	Fail             StatusCode = "FAIL"
+	PermissionDenied StatusCode = "PERMISSION_DENIED"
+	RateLimited      StatusCode = "RATE_LIMITED"
+	Timeout          StatusCode = "TIMEOUT"
 )
 
 // A MessageType defines the type of a JSON message on websocket
@@ -37,6 +41,8 @@ type MessageType string
 const (
 	TypeResponse     MessageType = "response"
 	TypeNotification MessageType = "notification"
+	TypeJob          MessageType = "job"
+	TypeChunk        MessageType = "chunk"
 )
 
 // Response is a status message sent to a websocket client
@@ -66,9 +72,46 @@ type DataEvent struct {
 }
 
 // ResponseNotification is a message sent by the server to the clients when an event is triggered in the simulation
+//
+// Seq is the sequence number of the event, which can be given to the server
+// resume action after a reconnection.
 type ResponseNotification struct {
 	MsgType MessageType `json:"msgType"`
+	Seq     uint64      `json:"seq,omitempty"`
 	Data    DataEvent   `json:"data"`
+
+	// encoded holds the encodings of broadcast notifications
+	encoded *encodedNotification
+	// objectID is the ID of the object of the event, for coalescing
+	objectID string
+}
+
+// DataJob is the Data part of a ResponseJob message
+type DataJob struct {
+	JobID  string      `json:"jobId"`
+	State  JobState    `json:"state"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// ResponseJob is a message sent to a websocket client about an asynchronous request.
+// Result holds the response of the request once the job is finished.
+type ResponseJob struct {
+	ID      int         `json:"id"`
+	MsgType MessageType `json:"msgType"`
+	Data    DataJob     `json:"data"`
+}
+
+// NewJobResponse returns a ResponseJob for the given job
+func NewJobResponse(id int, jobID string, state JobState, result interface{}) *ResponseJob {
+	return &ResponseJob{
+		ID:      id,
+		MsgType: TypeJob,
+		Data: DataJob{
+			JobID:  jobID,
+			State:  state,
+			Result: result,
+		},
+	}
 }
 
 // NewResponse returns a Response with the given data
@@ -94,6 +137,61 @@ func NewErrorResponse(id int, e error) *ResponseStatus {
 	return &sr
 }
 
+// NewPermissionDeniedResponse returns a ResponseStatus object with PERMISSION_DENIED
+// status for the given request.
+func NewPermissionDeniedResponse(id int, role ClientRole, req Request) *ResponseStatus {
+	sr := ResponseStatus{
+		ID:      id,
+		MsgType: TypeResponse,
+		Data: DataStatus{
+			PermissionDenied,
+			fmt.Sprintf("Error: role %s is not allowed to call %s/%s", role, req.Object, req.Action),
+		},
+	}
+	return &sr
+}
+
+// NewControlAreaDeniedResponse returns a ResponseStatus object with
+// PERMISSION_DENIED status for a request acting in a control area that is
+// not assigned to the user of the client.
+func NewControlAreaDeniedResponse(id int, areaID, dispatcher string, req Request) *ResponseStatus {
+	sr := ResponseStatus{
+		ID:      id,
+		MsgType: TypeResponse,
+		Data: DataStatus{
+			PermissionDenied,
+			fmt.Sprintf("Error: %s/%s acts in control area %s assigned to %q", req.Object, req.Action, areaID, dispatcher),
+		},
+	}
+	return &sr
+}
+
+// NewRateLimitedResponse returns a ResponseStatus object with RATE_LIMITED status.
+func NewRateLimitedResponse(id int) *ResponseStatus {
+	sr := ResponseStatus{
+		ID:      id,
+		MsgType: TypeResponse,
+		Data: DataStatus{
+			RateLimited,
+			"Error: too many requests, slow down",
+		},
+	}
+	return &sr
+}
+
+// NewTimeoutResponse returns a ResponseStatus object with TIMEOUT status.
+func NewTimeoutResponse(id int, timeout time.Duration) *ResponseStatus {
+	sr := ResponseStatus{
+		ID:      id,
+		MsgType: TypeResponse,
+		Data: DataStatus{
+			Timeout,
+			fmt.Sprintf("Error: request timed out after %s", timeout),
+		},
+	}
+	return &sr
+}
+
 // NewOkResponse returns a new ResponseStatus object with OK status and empty message.
 func NewOkResponse(id int, msg string) *ResponseStatus {
 	sr := ResponseStatus{
@@ -115,6 +213,7 @@ func NewNotificationResponse(e *simulation.Event) *ResponseNotification {
 			Name:   e.Name,
 			Object: e.Object,
 		},
+		objectID: e.Object.ID(),
 	}
 	return &er
 }
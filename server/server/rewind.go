@@ -0,0 +1,92 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A rewindRequest asks to rewind the simulation by the given number of
+// simulation minutes.
+type rewindRequest struct {
+    Minutes   int  `json:"minutes"`
+    AutoStart bool `json:"autoStart"`
+}
+
+// rewind rewinds the simulation of h as asked by req and returns the
+// simulation time it was rewound to.
+func (h *Hub) rewind(req rewindRequest) (string, error) {
+    if req.Minutes <= 0 {
+        return "", fmt.Errorf("minutes must be positive")
+    }
+    t, err := h.rewindSimulation(time.Duration(req.Minutes) * time.Minute)
+    if err != nil {
+        return "", err
+    }
+    if req.AutoStart {
+        h.sim.Start()
+    }
+    return h.sim.FormatTime(t), nil
+}
+
+// timelineReport returns the rewind points of s with the events recorded
+// after each of them.
+func timelineReport(s *simulation.Simulation) map[string]interface{} {
+    points := []map[string]interface{}{}
+    for _, p := range s.RewindPoints() {
+        events := []map[string]interface{}{}
+        for _, e := range p.Events {
+            events = append(events, map[string]interface{}{
+                "name":     e.Name,
+                "objectId": e.ObjectID,
+                "time":     s.FormatTime(e.Time),
+            })
+        }
+        points = append(points, map[string]interface{}{
+            "time":   s.FormatTime(p.Time),
+            "events": events,
+        })
+    }
+    return map[string]interface{}{
+        "currentTime":    s.FormatTime(s.Options.CurrentTime.Time),
+        "historyMinutes": int(s.RewindHistory() / time.Minute),
+        "points":         points,
+    }
+}
+
+// GET /api/simulation/rewind
+// POST /api/simulation/rewind
+//
+// GET returns the timeline of the simulation: the points it can be rewound to
+// and the events recorded after each of them. POST takes
+// {"minutes": 10, "autoStart": false} and rewinds the simulation.
+func serveRewind(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(timelineReport(h.sim))
+    case http.MethodPost:
+        var req rewindRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            badRequest(w, err)
+            return
+        }
+        t, err := h.rewind(req)
+        if err != nil {
+            invalidParameter(w, err.Error(), map[string]interface{}{"minutes": req.Minutes})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "rewoundTo": t})
+    default:
+        methodNotAllowed(w, r)
+    }
+}
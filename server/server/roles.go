@@ -0,0 +1,227 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A ClientRole defines what a websocket client is allowed to do.
+type ClientRole string
+
+const (
+	// RoleObserver clients can only read data and listen to events.
+	RoleObserver ClientRole = "observer"
+	// RoleOperator clients can also act on routes, trains, track items and
+	// suggestions, and start or pause the simulation. When the simulation
+	// has control areas, they can only act in the areas of their user.
+	RoleOperator ClientRole = "operator"
+	// RoleSupervisor clients can act in all the control areas and assign
+	// them to the dispatchers.
+	RoleSupervisor ClientRole = "supervisor"
+	// RoleAdmin clients can do everything, including restarting the
+	// simulation and changing its options.
+	RoleAdmin ClientRole = "admin"
+)
+
+// roleLevels orders the roles from the least to the most privileged.
+var roleLevels = map[ClientRole]int{
+	RoleObserver:   0,
+	RoleOperator:   1,
+	RoleSupervisor: 2,
+	RoleAdmin:      3,
+}
+
+// readOnlyActions are the actions any registered client may call, whatever
+// the object.
+var readOnlyActions = map[string]bool{
+	"list":        true,
+	"show":        true,
+	"dump":        true,
+	"isStarted":   true,
+	"disruptions": true,
+}
+
+// actionRoles lists the actions that need a role other than RoleOperator.
+// Actions that are neither read-only nor listed here require RoleOperator.
+var actionRoles = map[string]map[string]ClientRole{
+	"server": {
+		"register":       RoleObserver,
+		"addListener":    RoleObserver,
+		"removeListener": RoleObserver,
+		"renotify":       RoleObserver,
+		"resume":         RoleObserver,
+	},
+	"metrics": {
+		"current":     RoleObserver,
+		"historical":  RoleObserver,
+		"subscribe":   RoleObserver,
+		"unsubscribe": RoleObserver,
+	},
+	"simulation": {
+		"restart":     RoleAdmin,
+		"checkpoint":  RoleAdmin,
+		"checkpoints": RoleObserver,
+		"restore":     RoleAdmin,
+		"rewind":      RoleAdmin,
+		"timeline":    RoleObserver,
+		"fastForward": RoleAdmin,
+	},
+	"score": {
+		"sessions": RoleObserver,
+		"end":      RoleAdmin,
+	},
+	"training": {
+		"status":  RoleObserver,
+		"results": RoleObserver,
+		"start":   RoleAdmin,
+		"stop":    RoleAdmin,
+	},
+	"chat": {
+		"history": RoleObserver,
+	},
+	"controlArea": {
+		"assign":  RoleSupervisor,
+		"release": RoleSupervisor,
+	},
+	"section": {
+		"setDirection": RoleSupervisor,
+	},
+	"train": {
+		"spawn": RoleAdmin,
+	},
+	"service": {
+		"addLine":    RoleAdmin,
+		"updateLine": RoleAdmin,
+		"removeLine": RoleAdmin,
+	},
+	"disruption": {
+		"create": RoleAdmin,
+		"clear":  RoleAdmin,
+	},
+	"trackItem": {
+		"disrupt":         RoleAdmin,
+		"clearDisruption": RoleAdmin,
+		"failSignal":      RoleAdmin,
+		"repairSignal":    RoleAdmin,
+		"failPoints":      RoleAdmin,
+		"repairPoints":    RoleAdmin,
+	},
+	"option": {
+		"set": RoleAdmin,
+	},
+	"perturbation": {
+		"set": RoleAdmin,
+	},
+}
+
+// A UserCredential binds a token to a user and to the most privileged role
+// that clients registering with this token may have.
+type UserCredential struct {
+	User  string
+	Role  ClientRole
+	Token string
+}
+
+// parseUserCredential returns the credential written as user:role:token
+func parseUserCredential(s string) (UserCredential, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return UserCredential{}, fmt.Errorf("expecting user:role:token, got %q", s)
+	}
+	role, err := parseClientRole(parts[1])
+	if err != nil {
+		return UserCredential{}, fmt.Errorf("user %s: %s", parts[0], err)
+	}
+	return UserCredential{User: parts[0], Role: role, Token: parts[2]}, nil
+}
+
+// grantedRole returns the role of a client registering with c and asking for
+// the given role. Clients get the role of their credential if they do not ask
+// for one, and may only ask for a less privileged role.
+func (c UserCredential) grantedRole(name string) (ClientRole, error) {
+	if name == "" {
+		return c.Role, nil
+	}
+	role, err := parseClientRole(name)
+	if err != nil {
+		return "", err
+	}
+	if roleLevels[role] > roleLevels[c.Role] {
+		return "", fmt.Errorf("role %s is not granted to this token", role)
+	}
+	return role, nil
+}
+
+// requireUser returns the credential of the bearer token of an HTTP request
+// to s, which must be the token of a user with at least the given role. It
+// writes an error and returns false otherwise.
+func requireUser(w http.ResponseWriter, r *http.Request, s *simulation.Simulation, role ClientRole) (UserCredential, bool) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	credential, ok := clientCredential(s, given)
+	if !ok || credential.User == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ts2"`)
+		writeAPIError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid user token", nil)
+		return UserCredential{}, false
+	}
+	if roleLevels[credential.Role] < roleLevels[role] {
+		writeAPIError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("This action needs the %s role", role),
+			map[string]interface{}{"user": credential.User, "role": credential.Role})
+		return UserCredential{}, false
+	}
+	return credential, true
+}
+
+// parseClientRole returns the role with the given name. An empty name is the
+// least privileged role.
+func parseClientRole(name string) (ClientRole, error) {
+	if name == "" {
+		return RoleObserver, nil
+	}
+	role := ClientRole(name)
+	if _, ok := roleLevels[role]; !ok {
+		return "", fmt.Errorf("unknown role %s", name)
+	}
+	return role, nil
+}
+
+// requiredRole returns the least privileged role allowed to call the given
+// action on the given object.
+func requiredRole(object, action string) ClientRole {
+	if readOnlyActions[action] {
+		return RoleObserver
+	}
+	if role, ok := actionRoles[object][action]; ok {
+		return role
+	}
+	return RoleOperator
+}
+
+// allows returns true if this role may call the given action on the given object.
+func (r ClientRole) allows(object, action string) bool {
+	level, ok := roleLevels[r]
+	if !ok {
+		return false
+	}
+	return level >= roleLevels[requiredRole(object, action)]
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+)
+
+// GET /api/routes/{id}/conflicts
+//
+// Returns the routes which cannot be set at the same time as the given route,
+// from the route conflict graph computed when the simulation is loaded, with
+// the reason of each conflict and the current state of the other route.
+func serveRouteConflicts(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    id := strings.TrimPrefix(r.URL.Path, "/api/routes/")
+    if !strings.HasSuffix(id, "/conflicts") {
+        serveAPINotFound(w, r)
+        return
+    }
+    id = strings.TrimSuffix(id, "/conflicts")
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    conflicts, ok := h.sim.RouteConflicts(id)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeRouteNotFound, "Route not found", map[string]interface{}{"routeId": id})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"routeId": id, "items": conflicts})
+}
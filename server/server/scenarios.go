@@ -0,0 +1,227 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+const maxScenarios = 100
+
+// A scenario is a saved what-if run: the input changes, the resulting
+// predictions and the simulation snapshot they were based on.
+type scenario struct {
+    ID          string                 `json:"id"`
+    Name        string                 `json:"name"`
+    Description string                 `json:"description,omitempty"`
+    CreatedAt   time.Time              `json:"createdAt"`
+    Input       whatIfRequest          `json:"input"`
+    Result      map[string]interface{} `json:"result"`
+    Snapshot    json.RawMessage        `json:"snapshot,omitempty"`
+}
+
+// summary returns the scenario without its result details and snapshot
+func (s *scenario) summary() map[string]interface{} {
+    return map[string]interface{}{
+        "id": s.ID,
+        "name": s.Name,
+        "description": s.Description,
+        "createdAt": s.CreatedAt.Format(time.RFC3339),
+        "basedOn": s.Result["basedOn"],
+        "horizonMinutes": s.Result["horizonMinutes"],
+        "changes": s.Input.Changes,
+        "predictions": s.Result["predictions"],
+        "delta": s.Result["delta"],
+    }
+}
+
+type scenarioStore struct {
+    mu     sync.RWMutex
+    nextID int64
+    items  map[string]*scenario
+}
+
+var scenarios = &scenarioStore{items: make(map[string]*scenario)}
+
+// add stores the given scenario and assigns it an ID. The oldest scenario is
+// dropped when the store is full.
+func (ss *scenarioStore) add(s *scenario) {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    ss.nextID++
+    s.ID = fmt.Sprintf("scn_%d", ss.nextID)
+    s.Result["scenarioId"] = s.ID
+    if s.Name == "" {
+        s.Name = s.ID
+    }
+    if len(ss.items) >= maxScenarios {
+        var oldest *scenario
+        for _, it := range ss.items {
+            if oldest == nil || it.CreatedAt.Before(oldest.CreatedAt) {
+                oldest = it
+            }
+        }
+        delete(ss.items, oldest.ID)
+    }
+    ss.items[s.ID] = s
+}
+
+func (ss *scenarioStore) get(id string) (*scenario, bool) {
+    ss.mu.RLock()
+    defer ss.mu.RUnlock()
+    s, ok := ss.items[id]
+    return s, ok
+}
+
+func (ss *scenarioStore) remove(id string) bool {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    if _, ok := ss.items[id]; !ok {
+        return false
+    }
+    delete(ss.items, id)
+    return true
+}
+
+// list returns all scenarios, oldest first
+func (ss *scenarioStore) list() []*scenario {
+    ss.mu.RLock()
+    defer ss.mu.RUnlock()
+    res := make([]*scenario, 0, len(ss.items))
+    for _, s := range ss.items {
+        res = append(res, s)
+    }
+    sort.Slice(res, func(i, j int) bool {
+        if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+            return res[i].CreatedAt.Before(res[j].CreatedAt)
+        }
+        return res[i].ID < res[j].ID
+    })
+    return res
+}
+
+// GET /api/scenarios
+// POST /api/scenarios
+func serveScenarios(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    switch r.Method {
+    case http.MethodGet:
+        items := []map[string]interface{}{}
+        for _, s := range scenarios.list() {
+            items = append(items, s.summary())
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    case http.MethodPost:
+        if h.sim == nil {
+            simulationNotInitialized(w)
+            return
+        }
+        var body struct {
+            whatIfRequest
+            Name        string `json:"name"`
+            Description string `json:"description"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        snapshot, err := json.Marshal(h.sim)
+        if err != nil {
+            internalError(w, "Failed to snapshot simulation", err)
+            return
+        }
+        result, err := evaluateWhatIf(h.sim, body.whatIfRequest)
+        if err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        s := &scenario{
+            Name:        body.Name,
+            Description: body.Description,
+            CreatedAt:   time.Now().UTC(),
+            Input:       body.whatIfRequest,
+            Result:      result,
+            Snapshot:    snapshot,
+        }
+        s.Input.DurationMinutes = result["horizonMinutes"].(int)
+        scenarios.add(s)
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", "/api/scenarios/"+s.ID)
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(scenarioDetails(s, false))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// scenarioDetails returns the full scenario, with its snapshot if withSnapshot is true
+func scenarioDetails(s *scenario, withSnapshot bool) scenario {
+    res := *s
+    if !withSnapshot {
+        res.Snapshot = nil
+    }
+    return res
+}
+
+// GET /api/scenarios/compare?ids=scn_1,scn_2
+// Returns the predictions of the given scenarios side by side.
+func serveScenariosCompare(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    ids := strings.Split(r.URL.Query().Get("ids"), ",")
+    items := []map[string]interface{}{}
+    for _, id := range ids {
+        id = strings.TrimSpace(id)
+        if id == "" {
+            continue
+        }
+        s, ok := scenarios.get(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeScenarioNotFound, fmt.Sprintf("Unknown scenario: %s", id), map[string]interface{}{"scenarioId": id})
+            return
+        }
+        items = append(items, s.summary())
+    }
+    if len(items) == 0 {
+        invalidParameter(w, "Missing ids", nil)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// GET /api/scenarios/{id}?snapshot=1
+// DELETE /api/scenarios/{id}
+func serveScenario(w http.ResponseWriter, r *http.Request) {
+    id := strings.TrimPrefix(r.URL.Path, "/api/scenarios/")
+    if id == "compare" {
+        serveScenariosCompare(w, r)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        s, ok := scenarios.get(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeScenarioNotFound, "Scenario not found", map[string]interface{}{"scenarioId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(scenarioDetails(s, r.URL.Query().Get("snapshot") == "1"))
+    case http.MethodDelete:
+        if !scenarios.remove(id) {
+            writeAPIError(w, http.StatusNotFound, ErrCodeScenarioNotFound, "Scenario not found", map[string]interface{}{"scenarioId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,505 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// SessionScoredEvent is sent to its listeners with the summary of a
+// dispatcher session when it ends.
+const SessionScoredEvent simulation.EventName = "sessionScored"
+
+const (
+	// maxScoredSessions is the number of ended sessions kept for each
+	// simulation
+	maxScoredSessions = 20
+	// maxScoreEntries is the number of penalties kept in a session summary
+	maxScoreEntries = 100
+)
+
+// Reasons for which a scoring session ends
+const (
+	sessionEndRequested = "requested"
+	sessionEndRestarted = "restarted"
+	sessionEndRestored  = "restored"
+	sessionEndRewound   = "rewound"
+	sessionEndRemoved   = "removed"
+	sessionEndTraining  = "training"
+)
+
+// ScoringRules are the points given to a dispatcher during a session.
+// Penalties are given as positive numbers and subtracted from the score.
+type ScoringRules struct {
+	// OnTimeDeparture is given for each departure at most OnTimeMinutes
+	// after its scheduled time
+	OnTimeDeparture float64 `json:"onTimeDeparture"`
+	OnTimeMinutes   float64 `json:"onTimeMinutes"`
+	// LateMinute is the penalty for each minute of delay of a late
+	// departure beyond OnTimeMinutes, up to MaxLatePenalty per departure
+	LateMinute     float64 `json:"lateMinute"`
+	MaxLatePenalty float64 `json:"maxLatePenalty"`
+	// SPAD is the penalty for each signal passed at danger
+	SPAD float64 `json:"spad"`
+	// Overspeed is the penalty for each train running too fast
+	Overspeed float64 `json:"overspeed"`
+	// Conflict is the penalty for each route conflict detected
+	Conflict float64 `json:"conflict"`
+	// Override is the penalty for each suggestion overridden beyond the
+	// first FreeOverrides of the session
+	Override      float64 `json:"override"`
+	FreeOverrides int     `json:"freeOverrides"`
+}
+
+// DefaultScoringRules returns the default scoring rules
+func DefaultScoringRules() ScoringRules {
+	return ScoringRules{
+		OnTimeDeparture: 10,
+		OnTimeMinutes:   defaultOnTimeWindow.Minutes(),
+		LateMinute:      1,
+		MaxLatePenalty:  10,
+		SPAD:            100,
+		Overspeed:       20,
+		Conflict:        25,
+		Override:        10,
+		FreeOverrides:   3,
+	}
+}
+
+// validate checks the rules
+func (sr ScoringRules) validate() error {
+	for name, v := range map[string]float64{
+		"onTimeDeparture": sr.OnTimeDeparture,
+		"onTimeMinutes":   sr.OnTimeMinutes,
+		"lateMinute":      sr.LateMinute,
+		"maxLatePenalty":  sr.MaxLatePenalty,
+		"spad":            sr.SPAD,
+		"overspeed":       sr.Overspeed,
+		"conflict":        sr.Conflict,
+		"override":        sr.Override,
+		"freeOverrides":   float64(sr.FreeOverrides),
+	} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("scoring rule %s must be a positive number, got %v", name, v)
+		}
+	}
+	return nil
+}
+
+var (
+	scoringRules      = DefaultScoringRules()
+	scoringRulesMutex sync.RWMutex
+)
+
+// SetScoringRules sets the rules of the scoring sessions started from now on
+func SetScoringRules(rules ScoringRules) error {
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	scoringRulesMutex.Lock()
+	defer scoringRulesMutex.Unlock()
+	scoringRules = rules
+	return nil
+}
+
+// LoadScoringRules reads scoring rules from a JSON file. Rules that are not
+// in the file keep their default value.
+func LoadScoringRules(fileName string) (ScoringRules, error) {
+	rules := DefaultScoringRules()
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("invalid scoring rules %s: %s", fileName, err)
+	}
+	return rules, nil
+}
+
+// currentScoringRules returns the rules of new scoring sessions
+func currentScoringRules() ScoringRules {
+	scoringRulesMutex.RLock()
+	defer scoringRulesMutex.RUnlock()
+	return scoringRules
+}
+
+// A ScoreEntry is a penalty given during a session
+type ScoreEntry struct {
+	Kind    string  `json:"kind"`
+	SimTime string  `json:"simTime"`
+	Object  string  `json:"object"`
+	Details string  `json:"details,omitempty"`
+	Points  float64 `json:"points"`
+}
+
+// A ScoreCount is the number of occurrences of a scored fact in a session
+// and the points they gave.
+type ScoreCount struct {
+	Count  int     `json:"count"`
+	Points float64 `json:"points"`
+}
+
+// A SessionScore is the score of a dispatcher session
+type SessionScore struct {
+	SessionID    string `json:"sessionId"`
+	SimulationID string `json:"simulationId"`
+	Running      bool   `json:"running"`
+	StartedAt    string `json:"startedAt"`
+	EndedAt      string `json:"endedAt,omitempty"`
+	SimStart     string `json:"simStart"`
+	SimEnd       string `json:"simEnd"`
+	EndReason    string `json:"endReason,omitempty"`
+	// Score is the sum of the points, and Percent the score as a share of
+	// the points of a session where all the departures are on time and
+	// nothing is penalised.
+	Score    float64 `json:"score"`
+	MaxScore float64 `json:"maxScore"`
+	Percent  float64 `json:"percent"`
+	// Grade is A to F from Percent, or empty if nothing was scored yet
+	Grade            string       `json:"grade"`
+	OnTimeDepartures ScoreCount   `json:"onTimeDepartures"`
+	LateDepartures   ScoreCount   `json:"lateDepartures"`
+	LateMinutes      float64      `json:"lateMinutes"`
+	SPADs            ScoreCount   `json:"spads"`
+	Overspeeds       ScoreCount   `json:"overspeeds"`
+	Conflicts        ScoreCount   `json:"conflicts"`
+	Overrides        ScoreCount   `json:"overrides"`
+	Penalties        []ScoreEntry `json:"penalties"`
+	Rules            ScoringRules `json:"rules"`
+}
+
+// ID returns an empty string since the event is about the whole session
+func (ss SessionScore) ID() string {
+	return ""
+}
+
+// scoreGrade returns the grade of a session scoring the given percentage
+func scoreGrade(percent float64) string {
+	switch {
+	case percent >= 90:
+		return "A"
+	case percent >= 75:
+		return "B"
+	case percent >= 60:
+		return "C"
+	case percent >= 40:
+		return "D"
+	}
+	return "F"
+}
+
+// scoreSession holds the scored facts of the running session
+type scoreSession struct {
+	id          int
+	rules       ScoringRules
+	startedAt   time.Time
+	simStart    string
+	onTime      int
+	late        int
+	lateMinutes float64
+	latePoints  float64
+	spads       int
+	overspeeds  int
+	conflicts   int
+	overrides   int
+	penalties   []ScoreEntry
+}
+
+// scoreState holds the scoring sessions of a simulation
+type scoreState struct {
+	mu      sync.Mutex
+	nextID  int
+	current *scoreSession
+	// history holds the ended sessions, latest first
+	history []SessionScore
+}
+
+// scores holds the scoring sessions of the default simulation
+var scores = newScoreState()
+
+// newScoreState returns a score state without session
+func newScoreState() *scoreState {
+	return new(scoreState)
+}
+
+// sessionLocked returns the running session, starting one if needed.
+//
+// ss.mu must be held by the caller.
+func (ss *scoreState) sessionLocked(sim *simulation.Simulation) *scoreSession {
+	if ss.current == nil {
+		ss.nextID++
+		ss.current = &scoreSession{
+			id:        ss.nextID,
+			rules:     currentScoringRules(),
+			startedAt: time.Now().UTC(),
+			simStart:  sim.FormatTime(sim.CurrentTime()),
+		}
+	}
+	return ss.current
+}
+
+// penalise records a penalty in the running session
+func (s *scoreSession) penalise(kind, simTime, object, details string, points float64) {
+	if points == 0 {
+		return
+	}
+	if len(s.penalties) == maxScoreEntries {
+		copy(s.penalties, s.penalties[1:])
+		s.penalties = s.penalties[:maxScoreEntries-1]
+	}
+	s.penalties = append(s.penalties, ScoreEntry{Kind: kind, SimTime: simTime, Object: object, Details: details, Points: -points})
+}
+
+// summary returns the score of this session in sim
+func (s *scoreSession) summary(simID string, sim *simulation.Simulation) SessionScore {
+	r := s.rules
+	extraOverrides := s.overrides - r.FreeOverrides
+	if extraOverrides < 0 {
+		extraOverrides = 0
+	}
+	res := SessionScore{
+		SessionID:        strconv.Itoa(s.id),
+		SimulationID:     simID,
+		Running:          true,
+		StartedAt:        s.startedAt.Format(time.RFC3339),
+		SimStart:         s.simStart,
+		SimEnd:           sim.FormatTime(sim.CurrentTime()),
+		OnTimeDepartures: ScoreCount{Count: s.onTime, Points: float64(s.onTime) * r.OnTimeDeparture},
+		LateDepartures:   ScoreCount{Count: s.late, Points: -s.latePoints},
+		LateMinutes:      s.lateMinutes,
+		SPADs:            ScoreCount{Count: s.spads, Points: -float64(s.spads) * r.SPAD},
+		Overspeeds:       ScoreCount{Count: s.overspeeds, Points: -float64(s.overspeeds) * r.Overspeed},
+		Conflicts:        ScoreCount{Count: s.conflicts, Points: -float64(s.conflicts) * r.Conflict},
+		Overrides:        ScoreCount{Count: s.overrides, Points: -float64(extraOverrides) * r.Override},
+		Penalties:        append([]ScoreEntry{}, s.penalties...),
+		Rules:            r,
+	}
+	for _, c := range []ScoreCount{res.OnTimeDepartures, res.LateDepartures, res.SPADs, res.Overspeeds, res.Conflicts, res.Overrides} {
+		res.Score += c.Points
+	}
+	res.MaxScore = float64(s.onTime+s.late) * r.OnTimeDeparture
+	switch {
+	case res.MaxScore > 0:
+		res.Percent = math.Max(0, math.Min(100, res.Score*100/res.MaxScore))
+		res.Grade = scoreGrade(res.Percent)
+	case res.Score < 0:
+		res.Grade = scoreGrade(0)
+	}
+	return res
+}
+
+// updateScore scores the given event in the running session
+func (h *Hub) updateScore(e *simulation.Event) {
+	switch e.Name {
+	case simulation.TrainDepartedFromStationEvent, simulation.SignalPassedAtDangerEvent, simulation.OverspeedEvent:
+	default:
+		return
+	}
+	ss := h.scores
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.sessionLocked(h.sim)
+	snap := h.eventSnapshot(e)
+	simTime := h.sim.FormatTime(snap.simTime)
+	switch e.Name {
+	case simulation.TrainDepartedFromStationEvent:
+		t := snap.train
+		if t == nil || !t.hasService || t.stop == nil || t.stop.scheduled.IsZero() {
+			return
+		}
+		delay, place := snap.simTime.Sub(t.stop.scheduled), t.stop.placeCode
+		late := delay.Minutes() - s.rules.OnTimeMinutes
+		if late <= 0 {
+			s.onTime++
+			return
+		}
+		s.late++
+		s.lateMinutes += late
+		points := math.Min(late*s.rules.LateMinute, s.rules.MaxLatePenalty)
+		s.latePoints += points
+		s.penalise("LATE_DEPARTURE", simTime, t.serviceCode, fmt.Sprintf("%.1f min late from %s", delay.Minutes(), place), points)
+	case simulation.SignalPassedAtDangerEvent, simulation.OverspeedEvent:
+		sv := e.Object.(*simulation.SafetyViolation)
+		details := fmt.Sprintf("train %s at item %s", sv.TrainID, sv.TrackItemID)
+		if e.Name == simulation.SignalPassedAtDangerEvent {
+			s.spads++
+			s.penalise("SPAD", simTime, sv.ServiceCode, details, s.rules.SPAD)
+			return
+		}
+		s.overspeeds++
+		s.penalise("OVERSPEED", simTime, sv.ServiceCode, details, s.rules.Overspeed)
+	}
+}
+
+// recordConflictScore penalises the route conflict detected with the given
+// route ID in the running session.
+func (h *Hub) recordConflictScore(routeID string) {
+	ss := h.scores
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.sessionLocked(h.sim)
+	s.conflicts++
+	s.penalise("CONFLICT", h.sim.FormatTime(h.sim.CurrentTime()), routeID, "", s.rules.Conflict)
+}
+
+// recordOverrideScore records the override of the given suggestion in the
+// running session, penalising it beyond the free overrides.
+func (h *Hub) recordOverrideScore(suggestionID string) {
+	ss := h.scores
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.sessionLocked(h.sim)
+	s.overrides++
+	if s.overrides > s.rules.FreeOverrides {
+		s.penalise("OVERRIDE", h.sim.FormatTime(h.sim.CurrentTime()), suggestionID, "", s.rules.Override)
+	}
+}
+
+// currentScore returns the score of the running session of h
+func (h *Hub) currentScore() SessionScore {
+	ss := h.scores
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.sessionLocked(h.sim).summary(h.id, h.sim)
+}
+
+// scoredSessions returns the ended sessions of h, latest first
+func (h *Hub) scoredSessions() []SessionScore {
+	ss := h.scores
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]SessionScore{}, ss.history...)
+}
+
+// endScoringSession ends the running session of h for the given reason,
+// records its summary in the audit log and sends it to the listeners of
+// SessionScoredEvent. It returns false if no session was running.
+func (h *Hub) endScoringSession(reason string) (SessionScore, bool) {
+	ss := h.scores
+	ss.mu.Lock()
+	if ss.current == nil {
+		ss.mu.Unlock()
+		return SessionScore{}, false
+	}
+	res := ss.current.summary(h.id, h.sim)
+	res.Running = false
+	res.EndedAt = time.Now().UTC().Format(time.RFC3339)
+	res.EndReason = reason
+	ss.current = nil
+	ss.history = append([]SessionScore{res}, ss.history...)
+	if len(ss.history) > maxScoredSessions {
+		ss.history = ss.history[:maxScoredSessions]
+	}
+	ss.mu.Unlock()
+
+	logger.Info("Dispatcher session scored", "submodule", "hub", "simulation", h.id, "session", res.SessionID, "score", res.Score, "grade", res.Grade)
+	h.audits.append(AuditEntry{
+		SimTime:  res.SimEnd,
+		Event:    "SESSION_SCORED",
+		Category: "score",
+		Severity: "INFO",
+		Object:   map[string]interface{}{"id": res.SessionID},
+		Details: map[string]interface{}{
+			"score":     res.Score,
+			"percent":   res.Percent,
+			"grade":     res.Grade,
+			"endReason": reason,
+		},
+	})
+	select {
+	case h.events <- &simulation.Event{Name: SessionScoredEvent, Object: res}:
+	case <-h.done:
+	}
+	return res, true
+}
+
+// GET /api/score
+//
+// Returns the score of the running dispatcher session of the default
+// simulation.
+func serveScore(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(h.currentScore())
+}
+
+// GET /api/score/sessions
+//
+// Returns the summaries of the last ended sessions, latest first.
+func serveScoreSessions(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.scoredSessions()})
+}
+
+// POST /api/score/end
+//
+// Ends the running session, e.g. at the end of an exercise, and returns its
+// summary. The next session starts with the next scored fact.
+func serveScoreEnd(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	h.currentScore()
+	res, _ := h.endScoringSession(sessionEndRequested)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+type scoreObject struct{}
+
+// dispatch processes requests made on the score object
+func (s *scoreObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	logger.Debug("Request for score received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	var res interface{}
+	switch req.Action {
+	case "show":
+		res = h.currentScore()
+	case "sessions":
+		res = h.scoredSessions()
+	case "end":
+		h.currentScore()
+		res, _ = h.endScoringSession(sessionEndRequested)
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		return
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+		return
+	}
+	ch <- NewResponse(req.ID, data)
+}
+
+var _ hubObject = new(scoreObject)
+
+func init() {
+	hub.objects["score"] = new(scoreObject)
+}
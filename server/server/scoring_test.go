@@ -0,0 +1,145 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestScoring(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing dispatcher scoring", t, func() {
+		Convey("Rules should be validated and loaded", func() {
+			rules := DefaultScoringRules()
+			rules.SPAD = -1
+			So(SetScoringRules(rules), ShouldNotBeNil)
+			dir, err := ioutil.TempDir("", "ts2-scoring")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			fileName := filepath.Join(dir, "rules.json")
+			So(ioutil.WriteFile(fileName, []byte(`{"spad": 200, "freeOverrides": 0}`), 0644), ShouldBeNil)
+			rules, err = LoadScoringRules(fileName)
+			So(err, ShouldBeNil)
+			So(rules.SPAD, ShouldEqual, 200)
+			So(rules.FreeOverrides, ShouldEqual, 0)
+			So(rules.OnTimeDeparture, ShouldEqual, DefaultScoringRules().OnTimeDeparture)
+			So(RoleOperator.allows("score", "end"), ShouldBeFalse)
+			So(RoleObserver.allows("score", "sessions"), ShouldBeTrue)
+		})
+		Convey("Sessions should be scored", func() {
+			h := newHub("scoring")
+			h.sim = hub.sim
+			h.scores = newScoreState()
+			h.audits = newAuditState("scoring")
+			train := h.sim.Trains[0]
+			now, nextPlace := h.sim.Options.CurrentTime.Time, train.NextPlaceIndex
+			defer func() {
+				h.sim.Options.CurrentTime.Time = now
+				train.NextPlaceIndex = nextPlace
+			}()
+			// The train leaves the first place of its service
+			train.NextPlaceIndex = 1
+			scheduled := train.Service().Lines[0].ScheduledDepartureTime.Time
+			depart := func(delay time.Duration) {
+				h.sim.Options.CurrentTime.Time = scheduled.Add(delay)
+				h.updateScore(&simulation.Event{Name: simulation.TrainDepartedFromStationEvent, Object: train})
+			}
+
+			depart(2 * time.Minute)
+			score := h.currentScore()
+			So(score.Running, ShouldBeTrue)
+			So(score.SessionID, ShouldEqual, "1")
+			So(score.Score, ShouldEqual, 10)
+			So(score.Percent, ShouldEqual, 100)
+			So(score.Grade, ShouldEqual, "A")
+
+			depart(9 * time.Minute)
+			h.updateScore(&simulation.Event{Name: simulation.SignalPassedAtDangerEvent, Object: &simulation.SafetyViolation{Kind: simulation.SafetySPAD, TrainID: "0", ServiceCode: "S001", TrackItemID: "11"}})
+			h.updateScore(&simulation.Event{Name: simulation.OverspeedEvent, Object: &simulation.SafetyViolation{Kind: simulation.SafetyOverspeed, TrainID: "0", ServiceCode: "S001", TrackItemID: "2"}})
+			h.recordConflictScore("4")
+			for i := 0; i < 4; i++ {
+				h.recordOverrideScore("ROUTE_DEACTIVATE:4")
+			}
+			score = h.currentScore()
+			So(score.OnTimeDepartures, ShouldResemble, ScoreCount{Count: 1, Points: 10})
+			So(score.LateDepartures, ShouldResemble, ScoreCount{Count: 1, Points: -4})
+			So(score.LateMinutes, ShouldAlmostEqual, 4)
+			So(score.SPADs, ShouldResemble, ScoreCount{Count: 1, Points: -100})
+			So(score.Overspeeds, ShouldResemble, ScoreCount{Count: 1, Points: -20})
+			So(score.Conflicts, ShouldResemble, ScoreCount{Count: 1, Points: -25})
+			So(score.Overrides, ShouldResemble, ScoreCount{Count: 4, Points: -10})
+			So(score.Score, ShouldEqual, 10-4-100-20-25-10)
+			So(score.MaxScore, ShouldEqual, 20)
+			So(score.Percent, ShouldEqual, 0)
+			So(score.Grade, ShouldEqual, "F")
+			So(score.Penalties, ShouldHaveLength, 5)
+			So(score.Penalties[0].Kind, ShouldEqual, "LATE_DEPARTURE")
+			So(score.Penalties[0].Object, ShouldEqual, train.ServiceCode)
+			So(score.Penalties[1].Points, ShouldEqual, -100)
+
+			events := make(chan *simulation.Event, 1)
+			go func() { events <- <-h.events }()
+			summary, ok := h.endScoringSession(sessionEndRequested)
+			So(ok, ShouldBeTrue)
+			So(summary.Running, ShouldBeFalse)
+			So(summary.EndReason, ShouldEqual, sessionEndRequested)
+			So(summary.Score, ShouldEqual, score.Score)
+			e := <-events
+			So(e.Name, ShouldEqual, SessionScoredEvent)
+			So(e.Object.(SessionScore).SessionID, ShouldEqual, "1")
+			entries := h.audits.getSince(0, 10)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Event, ShouldEqual, "SESSION_SCORED")
+			So(entries[0].Details["grade"], ShouldEqual, "F")
+			So(h.scoredSessions(), ShouldHaveLength, 1)
+
+			_, ok = h.endScoringSession(sessionEndRequested)
+			So(ok, ShouldBeFalse)
+			score = h.currentScore()
+			So(score.SessionID, ShouldEqual, "2")
+			So(score.Score, ShouldEqual, 0)
+			So(score.Grade, ShouldEqual, "")
+		})
+		Convey("Scores should be served over HTTP", func() {
+			var score SessionScore
+			res, err := http.Get("http://127.0.0.1:22222/api/score")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&score), ShouldBeNil)
+			So(score.Running, ShouldBeTrue)
+			So(score.SimulationID, ShouldEqual, DefaultSimulationID)
+			id := score.SessionID
+
+			res, err = http.Post("http://127.0.0.1:22222/api/score/end", "application/json", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(json.NewDecoder(res.Body).Decode(&score), ShouldBeNil)
+			So(score.Running, ShouldBeFalse)
+			So(score.SessionID, ShouldEqual, id)
+
+			var sessions struct {
+				Items []SessionScore `json:"items"`
+			}
+			res, err = http.Get("http://127.0.0.1:22222/api/score/sessions")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&sessions), ShouldBeNil)
+			So(sessions.Items, ShouldNotBeEmpty)
+			So(sessions.Items[0].SessionID, ShouldEqual, id)
+
+			res, err = http.Get("http://127.0.0.1:22222/api/score/end")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
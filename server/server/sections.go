@@ -0,0 +1,260 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// defaultSectionLookahead is the default distance in meters ahead of trains in
+// which incoming trains are searched.
+const defaultSectionLookahead = 5000.0
+
+// sectionTrainOut is a train in the section API responses
+type sectionTrainOut struct {
+    ID           string                 `json:"id"`
+    ServiceCode  string                 `json:"serviceCode"`
+    Status       string                 `json:"status"`
+    Speed        float64                `json:"speed"`
+    MaxSpeed     float64                `json:"maxSpeed"`
+    Position     map[string]float64     `json:"position"`
+    Route        []string               `json:"route"`
+    Delay        int                    `json:"delay"`
+    Specs        map[string]interface{} `json:"specs"`
+    Distance     *float64               `json:"distance,omitempty"`
+    ETA          *string                `json:"eta,omitempty"`
+    ETASeconds   *float64               `json:"etaSeconds,omitempty"`
+    StopSignalID string                 `json:"stopSignalId,omitempty"`
+}
+
+// newSectionTrainOut returns the section API representation of the train t
+// of s
+func newSectionTrainOut(s *simulation.Simulation, t *simulation.Train) sectionTrainOut {
+    line := t.Service()
+    delayMin := 0
+    if line != nil && t.NextPlaceIndex != simulation.NoMorePlace && t.NextPlaceIndex < len(line.Lines) {
+        sl := line.Lines[t.NextPlaceIndex]
+        if !sl.ScheduledDepartureTime.IsZero() {
+            d := s.Options.CurrentTime.Sub(sl.ScheduledDepartureTime)
+            if d > 0 {
+                delayMin = int(d / time.Minute)
+            }
+        }
+    }
+    routeNames := []string{}
+    if line != nil {
+        for _, sl := range line.Lines {
+            if sl.Place() != nil {
+                routeNames = append(routeNames, sl.Place().Name())
+            } else {
+                routeNames = append(routeNames, sl.PlaceCode)
+            }
+        }
+    }
+    x, y := positionXY(t.TrainHead)
+    return sectionTrainOut{
+        ID:          t.ID(),
+        ServiceCode: t.ServiceCode,
+        Status:      trainStatusToString(t.Status),
+        Speed:       t.Speed * 3.6, // km/h for FE
+        MaxSpeed:    t.MaxSpeedForTrainTrackItems(),
+        Position:    map[string]float64{"x": x, "y": y},
+        Route:       routeNames,
+        Delay:       delayMin,
+        Specs:       map[string]interface{}{"type": t.TrainType().Description, "length": t.TrainType().Length},
+    }
+}
+
+// sectionView returns the JSON representation of a section
+func sectionView(s *simulation.Section) map[string]interface{} {
+    return map[string]interface{}{
+        "id":         s.ID(),
+        "name":       s.Name,
+        "trackItems": s.TrackItemIDs,
+        "placeCode":  s.PlaceCode(),
+        "singleLine":   s.SingleLine,
+        "direction":    string(s.Direction()),
+        "acceptingEnd": s.AcceptingEnd(),
+    }
+}
+
+// GET /api/trains/section/{sectionId}?lookahead={meters}
+//
+// Returns the trains whose head is in the section and the trains that will
+// enter it on their current path, with their ETA.
+func serveTrainsBySection(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    sectionID := strings.TrimPrefix(r.URL.Path, "/api/trains/section/")
+    section, ok := h.sim.Section(sectionID)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeSectionNotFound, "Section not found", map[string]interface{}{"sectionId": sectionID})
+        return
+    }
+    lookahead := defaultSectionLookahead
+    if lp := r.URL.Query().Get("lookahead"); lp != "" {
+        v, err := strconv.ParseFloat(lp, 64)
+        if err != nil || v <= 0 {
+            invalidParameter(w, "Bad lookahead", map[string]interface{}{"lookahead": lp})
+            return
+        }
+        lookahead = v
+    }
+    current := []sectionTrainOut{}
+    for _, t := range section.TrainsInside() {
+        current = append(current, newSectionTrainOut(h.sim, t))
+    }
+    incoming := []sectionTrainOut{}
+    for _, sa := range section.IncomingTrains(lookahead) {
+        out := newSectionTrainOut(h.sim, sa.Train)
+        distance := sa.Distance
+        out.Distance = &distance
+        if sa.ETA >= 0 {
+            eta := h.sim.FormatTime(h.sim.Options.CurrentTime.Time.Add(sa.ETA))
+            secs := sa.ETA.Seconds()
+            out.ETA = &eta
+            out.ETASeconds = &secs
+        }
+        if sa.StopSignal != nil {
+            out.StopSignalID = sa.StopSignal.ID()
+        }
+        incoming = append(incoming, out)
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{
+        "sectionId":      sectionID,
+        "section":        sectionView(section),
+        "currentTrains":  current,
+        "incomingTrains": incoming,
+    })
+}
+
+// GET /api/sections
+// POST /api/sections
+func serveSections(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        items := []map[string]interface{}{}
+        for _, s := range h.sim.Sections() {
+            items = append(items, sectionView(s))
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    case http.MethodPost:
+        var body struct {
+            ID               string                         `json:"id"`
+            Name             string                         `json:"name"`
+            TrackItems       []string                       `json:"trackItems"`
+            SingleLine       bool                           `json:"singleLine"`
+            InitialDirection simulation.SingleLineDirection `json:"initialDirection"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        s := &simulation.Section{Name: body.Name, TrackItemIDs: body.TrackItems, SingleLine: body.SingleLine,
+            InitialDirection: body.InitialDirection}
+        if err := h.sim.AddSection(body.ID, s); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", resourceLocation(r, apiPrefix+"/sections/"+s.ID()))
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(sectionView(s))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/sections/{id}
+// DELETE /api/sections/{id}
+func serveSection(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/sections/")
+    if strings.HasSuffix(id, "/direction") {
+        serveSectionDirection(w, r, strings.TrimSuffix(id, "/direction"))
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        s, ok := h.sim.Section(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeSectionNotFound, "Section not found", map[string]interface{}{"sectionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(sectionView(s))
+    case http.MethodDelete:
+        if err := h.sim.RemoveSection(id); err != nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodeSectionNotFound, "Section not found", map[string]interface{}{"sectionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// PUT /api/sections/{id}/direction
+//
+// Locks the single line section in the given direction, or frees it with an
+// empty direction. The direction can only be changed when no route is set
+// over the line and no train runs on it. Only supervisors may change it.
+func serveSectionDirection(w http.ResponseWriter, r *http.Request, id string) {
+    h := requestHub(r)
+    if r.Method != http.MethodPut {
+        methodNotAllowed(w, r)
+        return
+    }
+    if _, ok := requireUser(w, r, h.sim, RoleSupervisor); !ok {
+        return
+    }
+    s, ok := h.sim.Section(id)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeSectionNotFound, "Section not found", map[string]interface{}{"sectionId": id})
+        return
+    }
+    var body struct {
+        Direction simulation.SingleLineDirection `json:"direction"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        badRequest(w, err)
+        return
+    }
+    switch {
+    case !s.SingleLine:
+        invalidParameter(w, "Section is not a single line", map[string]interface{}{"sectionId": id})
+        return
+    case body.Direction != simulation.SingleLineFree && body.Direction != simulation.SingleLineUp && body.Direction != simulation.SingleLineDown:
+        invalidParameter(w, "Unknown direction", map[string]interface{}{"direction": body.Direction})
+        return
+    }
+    if err := s.SetDirection(body.Direction); err != nil {
+        writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"sectionId": id})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(sectionView(s))
+}
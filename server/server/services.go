@@ -0,0 +1,145 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A serviceLineRequest adds a line to a service. Without index, the line is
+// appended to the service.
+type serviceLineRequest struct {
+    Index *int `json:"index"`
+    simulation.ServiceLine
+}
+
+// insertServiceLine adds the line described by slr to the service with the
+// given code of the simulation s.
+func insertServiceLine(s *simulation.Simulation, code string, slr *serviceLineRequest) error {
+    index := -1
+    if slr.Index != nil {
+        index = *slr.Index
+    } else if srv, ok := s.Services[code]; ok {
+        index = len(srv.Lines)
+    }
+    sl := &simulation.ServiceLine{
+        MustStop:  slr.MustStop,
+        PlaceCode: slr.PlaceCode,
+        TrackCode: slr.TrackCode,
+    }
+    sl.ScheduledArrivalTime.Time = slr.ScheduledArrivalTime.Time
+    sl.ScheduledDepartureTime.Time = slr.ScheduledDepartureTime.Time
+    return s.InsertServiceLine(code, index, sl)
+}
+
+// GET /api/services
+func serveServices(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    items := make([]*simulation.Service, 0, len(h.sim.Services))
+    for _, s := range h.sim.Services {
+        items = append(items, s)
+    }
+    sort.Slice(items, func(i, j int) bool { return items[i].ID() < items[j].ID() })
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// GET /api/services/{code}
+// POST /api/services/{code}/lines
+// PATCH /api/services/{code}/lines/{index}
+// DELETE /api/services/{code}/lines/{index}
+// POST /api/services/{code}/cancel
+//
+// POST, PATCH and DELETE edit the timetable of the service while the
+// simulation runs, and return the updated service.
+func serveService(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/services/"), "/")
+    code := parts[0]
+    srv, ok := h.sim.Services[code]
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeServiceNotFound, "Service not found", map[string]interface{}{"serviceId": code})
+        return
+    }
+    var err error
+    switch {
+    case len(parts) == 2 && parts[1] == "cancel":
+        if r.Method != http.MethodPost {
+            methodNotAllowed(w, r)
+            return
+        }
+        trains, err := h.sim.CancelService(code)
+        if err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"serviceId": code})
+            return
+        }
+        ids := make([]string, len(trains))
+        for i, t := range trains {
+            ids[i] = t.ID()
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "serviceId": code, "cancelledTrains": ids})
+        return
+    case len(parts) == 1:
+        if r.Method != http.MethodGet {
+            methodNotAllowed(w, r)
+            return
+        }
+    case len(parts) == 2 && parts[1] == "lines":
+        if r.Method != http.MethodPost {
+            methodNotAllowed(w, r)
+            return
+        }
+        var body serviceLineRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        err = insertServiceLine(h.sim, code, &body)
+    case len(parts) == 3 && parts[1] == "lines":
+        index, convErr := strconv.Atoi(parts[2])
+        if convErr != nil {
+            invalidParameter(w, "Invalid line index", map[string]interface{}{"index": parts[2]})
+            return
+        }
+        switch r.Method {
+        case http.MethodPatch:
+            var body simulation.ServiceLineChange
+            if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+                badRequest(w, err)
+                return
+            }
+            err = h.sim.UpdateServiceLine(code, index, body)
+        case http.MethodDelete:
+            err = h.sim.RemoveServiceLine(code, index)
+        default:
+            methodNotAllowed(w, r)
+            return
+        }
+    default:
+        serveAPINotFound(w, r)
+        return
+    }
+    if err != nil {
+        invalidParameter(w, err.Error(), map[string]interface{}{"serviceId": code})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(srv)
+}
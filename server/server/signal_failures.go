@@ -0,0 +1,100 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// signalFailureRequest is the body of a signal failure request, from HTTP
+// or from the hub. A nil RepairMinutes repairs the signal after the
+// simulation MTTR, and 0 keeps it failed until it is repaired on request.
+type signalFailureRequest struct {
+    SignalID      string `json:"signalId"`
+    Mode          string `json:"mode"`
+    Reason        string `json:"reason"`
+    RepairMinutes *int   `json:"repairMinutes"`
+}
+
+// failSignal makes the signal of fr malfunction in the simulation s
+func failSignal(s *simulation.Simulation, fr signalFailureRequest) (*simulation.SignalItem, error) {
+    si, ok := s.TrackItems[fr.SignalID].(*simulation.SignalItem)
+    if !ok {
+        return nil, fmt.Errorf("unknown signal: %s", fr.SignalID)
+    }
+    mode, err := simulation.ParseSignalFailureMode(strings.ToUpper(fr.Mode))
+    if err != nil {
+        return nil, err
+    }
+    repairIn := s.SignalMTTR()
+    if fr.RepairMinutes != nil {
+        if *fr.RepairMinutes < 0 {
+            return nil, fmt.Errorf("repairMinutes must be positive")
+        }
+        repairIn = time.Duration(*fr.RepairMinutes) * time.Minute
+    }
+    cause := fr.Reason
+    if cause == "" {
+        cause = "Manual failure"
+    }
+    si.Fail(mode, cause, repairIn)
+    return si, nil
+}
+
+// signalFailureStatus returns the malfunction state of the given signal
+func signalFailureStatus(id string, si *simulation.SignalItem) map[string]interface{} {
+    status := "OPERATIONAL"
+    if si.Failed() {
+        status = "FAILED"
+    }
+    var repairTime string
+    if !si.RepairTime().IsZero() {
+        repairTime = si.RepairTime().Format(time.RFC3339)
+    }
+    return map[string]interface{}{
+        "signalId":          id,
+        "malfunctionStatus": status,
+        "failureMode":       string(si.FailureMode()),
+        "cause":             si.FailureCause(),
+        "repairTime":        repairTime,
+    }
+}
+
+// PUT /api/systems/signals/{signalId}/failure
+// DELETE /api/systems/signals/{signalId}/failure
+func serveSignalFailure(w http.ResponseWriter, r *http.Request, sid string) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    si, ok := h.sim.TrackItems[sid].(*simulation.SignalItem)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeSignalNotFound, "Signal not found", map[string]interface{}{"signalId": sid})
+        return
+    }
+    switch r.Method {
+    case http.MethodPut:
+        var body signalFailureRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        body.SignalID = sid
+        if _, err := failSignal(h.sim, body); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+    case http.MethodDelete:
+        si.Repair()
+    default:
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(signalFailureStatus(sid, si))
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// maxSimulationFileSize is the maximum size in bytes of a simulation file
+// uploaded for validation
+const maxSimulationFileSize = 64 << 20
+
+// GET /api/simulation/schema
+//
+// Returns the JSON Schema of the simulation file format.
+func serveSimulationSchema(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/schema+json; charset=utf-8")
+    _, _ = w.Write([]byte(simulation.FileSchema))
+}
+
+// POST /api/simulation/validate
+//
+// Takes a simulation file in the body and returns the problems found in it.
+// The running simulation is not modified.
+func serveSimulationValidate(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSimulationFileSize))
+    if err != nil {
+        badRequest(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(simulation.ValidateFile(data))
+}
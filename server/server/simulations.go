@@ -0,0 +1,276 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// DefaultSimulationID is the ID of the simulation given to Run. It is the
+// simulation served to the clients that do not ask for a specific one.
+const DefaultSimulationID = "default"
+
+var simulationIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// simulationManager holds the hubs of the simulations hosted by the server,
+// indexed by simulation ID.
+type simulationManager struct {
+    mu   sync.RWMutex
+    hubs map[string]*Hub
+}
+
+var simulations = &simulationManager{hubs: make(map[string]*Hub)}
+
+// get returns the hub of the simulation with the given ID
+func (sm *simulationManager) get(id string) (*Hub, bool) {
+    sm.mu.RLock()
+    defer sm.mu.RUnlock()
+    h, ok := sm.hubs[id]
+    return h, ok
+}
+
+// add adds the given hub to the manager. It fails if a simulation with the
+// same ID already exists.
+func (sm *simulationManager) add(h *Hub) error {
+    sm.mu.Lock()
+    defer sm.mu.Unlock()
+    if _, ok := sm.hubs[h.id]; ok {
+        return fmt.Errorf("simulation %s already exists", h.id)
+    }
+    sm.hubs[h.id] = h
+    return nil
+}
+
+// remove removes the simulation with the given ID and stops its hub, which
+// disconnects its clients. The default simulation cannot be removed and
+// running simulations must be paused first.
+func (sm *simulationManager) remove(id string) error {
+    sm.mu.Lock()
+    defer sm.mu.Unlock()
+    h, ok := sm.hubs[id]
+    if !ok {
+        return fmt.Errorf("unknown simulation %s", id)
+    }
+    if h == hub {
+        return fmt.Errorf("the default simulation cannot be removed")
+    }
+    if h.sim.IsStarted() {
+        return fmt.Errorf("simulation %s is running, pause it first", id)
+    }
+    h.stopTraining(trainingEndAborted)
+    h.endScoringSession(sessionEndRemoved)
+    delete(sm.hubs, id)
+    close(h.done)
+    h.sim.Close()
+    return nil
+}
+
+// list returns the hubs of all simulations, sorted by ID
+func (sm *simulationManager) list() []*Hub {
+    sm.mu.RLock()
+    defer sm.mu.RUnlock()
+    res := make([]*Hub, 0, len(sm.hubs))
+    for _, h := range sm.hubs {
+        res = append(res, h)
+    }
+    sort.Slice(res, func(i, j int) bool {
+        return res[i].id < res[j].id
+    })
+    return res
+}
+
+// hubContextKey is the context key of the hub of the simulation that an API
+// request is addressed to
+type hubContextKey struct{}
+
+// requestHub returns the hub of the simulation that r is addressed to: the
+// simulation of its /api/simulations/{id}/ path, or the default simulation.
+func requestHub(r *http.Request) *Hub {
+    if h, ok := r.Context().Value(hubContextKey{}).(*Hub); ok {
+        return h
+    }
+    return hub
+}
+
+// scopedSimulationPath splits the path of a request made under
+// /api/simulations/{id}/ or under the same path of the current API version
+// into the simulation ID and the path that follows it.
+func scopedSimulationPath(p string) (id, rest string, ok bool) {
+    p = strings.TrimPrefix(strings.TrimPrefix(p, apiPrefix), "/api")
+    rest = strings.TrimPrefix(p, "/simulations/")
+    i := strings.Index(rest, "/")
+    if rest == p || i < 0 {
+        return "", "", false
+    }
+    return rest[:i], rest[i:], true
+}
+
+// resourceLocation returns the given API path of a resource, such as
+// /api/disruptions/1, under the path of the simulation that r is addressed
+// to if r was made under /api/simulations/{id}/.
+func resourceLocation(r *http.Request, path string) string {
+    h, ok := r.Context().Value(hubContextKey{}).(*Hub)
+    if !ok {
+        return path
+    }
+    prefix := "/api"
+    if strings.HasPrefix(path, apiPrefix+"/") {
+        prefix = apiPrefix
+    }
+    return prefix + "/simulations/" + h.id + strings.TrimPrefix(path, prefix)
+}
+
+// simulationScoped serves the requests made under /api/simulations/{id}/
+// with the given API handler, as requests addressed to the simulation with
+// that ID. /api/simulations/exercise1/trains lists the trains of exercise1
+// as /api/trains lists those of the default simulation.
+func simulationScoped(api http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id, path, ok := scopedSimulationPath(r.URL.Path)
+        if !ok {
+            api.ServeHTTP(w, r)
+            return
+        }
+        h, ok := simulations.get(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeSimulationNotFound, "Simulation not found", map[string]interface{}{"simulationId": id})
+            return
+        }
+        if path == "/simulations" || strings.HasPrefix(path, "/simulations/") {
+            serveAPINotFound(w, r)
+            return
+        }
+        r2 := r.WithContext(context.WithValue(r.Context(), hubContextKey{}, h))
+        u := *r.URL
+        u.Path = "/api" + path
+        u.RawPath = ""
+        r2.URL = &u
+        api.ServeHTTP(w, r2)
+    })
+}
+
+// AddSimulation hosts the simulation s on this server with the given ID, next
+// to the default simulation given to Run. Clients attach to it by passing its
+// ID in the websocket URL or when registering.
+//
+// s must have been loaded but not initialized: AddSimulation initializes it.
+func AddSimulation(id string, s *simulation.Simulation) error {
+    if !simulationIDPattern.MatchString(id) {
+        return fmt.Errorf("invalid simulation ID %q", id)
+    }
+    applyConfigOptions(s)
+    snapshot, err := takeSimulationSnapshot(s)
+    if err != nil {
+        return fmt.Errorf("unable to snapshot simulation: %s", err)
+    }
+    h := newHub(id)
+    h.objects = hub.objects
+    h.setSimulation(s)
+    h.initialSnapshot = snapshot
+    h.metrics = newMetricsState()
+    h.audits = newAuditState(id)
+    h.overview = newOverviewChangeLog()
+    h.snapshots = newOverviewCache(h.overview)
+    h.scores = newScoreState()
+    h.trainings = newTrainingState()
+    h.areas = newAreaState()
+    h.chats = newChatState()
+    if err := simulations.add(h); err != nil {
+        return err
+    }
+    // The hub must be running to receive the events sent during initialization
+    go h.run(make(chan bool, 1))
+    if err := s.Initialize(); err != nil {
+        simulations.mu.Lock()
+        delete(simulations.hubs, id)
+        simulations.mu.Unlock()
+        close(h.done)
+        return fmt.Errorf("invalid simulation: %s", err)
+    }
+    logger.Info("Simulation added", "simulation", id, "title", s.Options.Title)
+    return nil
+}
+
+// simulationSummary returns the description of the simulation of h
+func simulationSummary(h *Hub) map[string]interface{} {
+    return map[string]interface{}{
+        "id":          h.id,
+        "title":       h.sim.Options.Title,
+        "description": h.sim.Options.Description,
+        "started":     h.sim.IsStarted(),
+        "default":     h == hub,
+    }
+}
+
+// GET /api/simulations
+// POST /api/simulations?id=exercise1
+func serveSimulations(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        items := []map[string]interface{}{}
+        for _, h := range simulations.list() {
+            items = append(items, simulationSummary(h))
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    case http.MethodPost:
+        id := r.URL.Query().Get("id")
+        if !simulationIDPattern.MatchString(id) {
+            invalidParameter(w, "Invalid simulation ID", map[string]interface{}{"id": id})
+            return
+        }
+        if _, ok := simulations.get(id); ok {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, "Simulation already exists", map[string]interface{}{"simulationId": id})
+            return
+        }
+        var s simulation.Simulation
+        if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+            badRequest(w, err)
+            return
+        }
+        if err := AddSimulation(id, &s); err != nil {
+            invalidParameter(w, err.Error(), map[string]interface{}{"simulationId": id})
+            return
+        }
+        h, _ := simulations.get(id)
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", "/api/simulations/"+id)
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(simulationSummary(h))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/simulations/{id}
+// DELETE /api/simulations/{id}
+func serveSimulation(w http.ResponseWriter, r *http.Request) {
+    id := strings.TrimPrefix(r.URL.Path, "/api/simulations/")
+    h, ok := simulations.get(id)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeSimulationNotFound, "Simulation not found", map[string]interface{}{"simulationId": id})
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(simulationSummary(h))
+    case http.MethodDelete:
+        if err := simulations.remove(id); err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"simulationId": id})
+            return
+        }
+        logger.Info("Simulation removed", "simulation", id)
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
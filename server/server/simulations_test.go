@@ -0,0 +1,266 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSimulations(t *testing.T) {
+	Convey("Testing multiple simulations", t, func() {
+		Convey("Simulations should be added with a valid and unique ID", func() {
+			So(AddSimulation("bad id", loadDemoWith(nil)), ShouldNotBeNil)
+			So(AddSimulation(DefaultSimulationID, loadDemoWith(nil)), ShouldNotBeNil)
+			So(AddSimulation("exercise1", loadDemoWith(nil)), ShouldBeNil)
+			h, ok := simulations.get("exercise1")
+			So(ok, ShouldBeTrue)
+			So(h == hub, ShouldBeFalse)
+			So(h.sim, ShouldNotEqual, sim)
+			So(h.sim.SuggestionEngine(), ShouldNotEqual, sim.SuggestionEngine())
+		})
+		Convey("Clients should be attached to the simulation of the URL", func() {
+			h, _ := simulations.get("exercise1")
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws", RawQuery: "sim=exercise1"}
+			c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "option", "set", `{"name": "timeFactor", "value": 7}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(h.sim.Options.TimeFactor, ShouldEqual, 7)
+			So(sim.Options.TimeFactor, ShouldNotEqual, 7)
+		})
+		Convey("Clients should be attached to the simulation given at register", func() {
+			h, _ := simulations.get("exercise1")
+			c := clientDial(t)
+			defer c.Close()
+			So(c.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: "client-secret", SimID: "exercise1"}}), ShouldBeNil)
+			var resp ResponseStatus
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "option", "set", `{"name": "timeFactor", "value": 3}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(h.sim.Options.TimeFactor, ShouldEqual, 3)
+			So(sim.Options.TimeFactor, ShouldNotEqual, 3)
+		})
+		Convey("Unknown simulations should be refused", func() {
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws", RawQuery: "sim=unknown"}
+			_, res, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldNotBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			c := clientDial(t)
+			defer c.Close()
+			So(c.WriteJSON(RequestRegister{ID: 1, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: "client-secret", SimID: "unknown"}}), ShouldBeNil)
+			var resp ResponseStatus
+			So(c.ReadJSON(&resp), ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Fail)
+		})
+		Convey("Clients should be notified when the simulation restarts", func() {
+			h, _ := simulations.get("exercise1")
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws", RawQuery: "sim=exercise1"}
+			c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			other, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer other.Close()
+			So(register(t, other, Client, "", "client-secret"), ShouldBeNil)
+
+			old := h.sim
+			So(c.WriteJSON(Request{ID: 2, Object: "simulation", Action: "restart"}), ShouldBeNil)
+			var restarted, answered bool
+			for !restarted || !answered {
+				_, data, err := c.ReadMessage()
+				So(err, ShouldBeNil)
+				var msg Response
+				So(json.Unmarshal(data, &msg), ShouldBeNil)
+				switch msg.MsgType {
+				case TypeResponse:
+					var resp ResponseStatus
+					So(json.Unmarshal(data, &resp), ShouldBeNil)
+					So(resp.ID, ShouldEqual, 2)
+					So(resp.Data.Status, ShouldEqual, Ok)
+					answered = true
+				case TypeNotification:
+					var n ResponseNotification
+					So(json.Unmarshal(data, &n), ShouldBeNil)
+					So(n.Data.Name, ShouldEqual, SimulationRestartedEvent)
+					restarted = true
+				}
+			}
+			So(h.sim, ShouldNotEqual, old)
+			So(h.sim.Options.TimeFactor, ShouldNotEqual, 3)
+
+			// Clients without listeners are notified too
+			var n ResponseNotification
+			So(other.ReadJSON(&n), ShouldBeNil)
+			So(n.MsgType, ShouldEqual, TypeNotification)
+			So(n.Data.Name, ShouldEqual, SimulationRestartedEvent)
+			So(n.Seq, ShouldBeGreaterThan, 0)
+
+			// Events of the new simulation are forwarded to the clients
+			resp := sendRequestStatus(other, "server", "addListener", `{"event": "optionsChanged"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "option", "set", `{"name": "timeFactor", "value": 4}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(other.ReadJSON(&n), ShouldBeNil)
+			So(n.Data.Name, ShouldEqual, simulation.OptionsChangedEvent)
+		})
+		Convey("Simulations should be restored from checkpoints", func() {
+			h, _ := simulations.get("exercise1")
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws", RawQuery: "sim=exercise1"}
+			c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "option", "set", `{"name": "timeFactor", "value": 7}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			So(c.WriteJSON(Request{ID: 3, Object: "simulation", Action: "checkpoint", Params: RawJSON(`{"name": "before_peak"}`)}), ShouldBeNil)
+			var saved Response
+			So(c.ReadJSON(&saved), ShouldBeNil)
+			var info checkpointInfo
+			So(json.Unmarshal(saved.Data, &info), ShouldBeNil)
+			So(info.Name, ShouldEqual, "before_peak")
+			So(info.Size, ShouldBeGreaterThan, 0)
+			resp = sendRequestStatus(c, "simulation", "checkpoint", `{"name": "../escape"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+
+			So(c.WriteJSON(Request{ID: 4, Object: "simulation", Action: "checkpoints"}), ShouldBeNil)
+			var listed Response
+			So(c.ReadJSON(&listed), ShouldBeNil)
+			var items []checkpointInfo
+			So(json.Unmarshal(listed.Data, &items), ShouldBeNil)
+			So(items, ShouldHaveLength, 1)
+			So(items[0].Name, ShouldEqual, "before_peak")
+
+			resp = sendRequestStatus(c, "option", "set", `{"name": "timeFactor", "value": 2}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "simulation", "restore", `{"name": "unknown"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			old := h.sim
+			So(c.WriteJSON(Request{ID: 5, Object: "simulation", Action: "restore", Params: RawJSON(`{"name": "before_peak"}`)}), ShouldBeNil)
+			var restored, answered bool
+			for !restored || !answered {
+				_, data, err := c.ReadMessage()
+				So(err, ShouldBeNil)
+				var msg Response
+				So(json.Unmarshal(data, &msg), ShouldBeNil)
+				switch msg.MsgType {
+				case TypeResponse:
+					var resp ResponseStatus
+					So(json.Unmarshal(data, &resp), ShouldBeNil)
+					So(resp.ID, ShouldEqual, 5)
+					So(resp.Data.Status, ShouldEqual, Ok)
+					answered = true
+				case TypeNotification:
+					var n ResponseNotification
+					So(json.Unmarshal(data, &n), ShouldBeNil)
+					So(n.Data.Name, ShouldEqual, SimulationRestartedEvent)
+					So(n.Data.Object.(map[string]interface{})["checkpoint"], ShouldEqual, "before_peak")
+					restored = true
+				}
+			}
+			So(h.sim, ShouldNotEqual, old)
+			So(h.sim.Options.TimeFactor, ShouldEqual, 7)
+			So(h.sim.IsStarted(), ShouldBeFalse)
+		})
+		Convey("Simulations should be managed through the HTTP API", func() {
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			res, err := http.Get("http://127.0.0.1:22222/api/simulations")
+			So(err, ShouldBeNil)
+			So(json.NewDecoder(res.Body).Decode(&list), ShouldBeNil)
+			So(list.Items, ShouldHaveLength, 2)
+			So(list.Items[0]["id"], ShouldEqual, DefaultSimulationID)
+			So(list.Items[0]["default"], ShouldBeTrue)
+			So(list.Items[1]["id"], ShouldEqual, "exercise1")
+
+			data, err := ioutil.ReadFile("../simulation/testdata/demo.json")
+			So(err, ShouldBeNil)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulations?id=exercise2", "application/json", strings.NewReader(string(data)))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusCreated)
+			res, err = http.Post("http://127.0.0.1:22222/api/simulations?id=exercise2", "application/json", strings.NewReader(string(data)))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusConflict)
+			res, err = http.Get("http://127.0.0.1:22222/api/simulations/exercise2")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws", RawQuery: "sim=exercise2"}
+			c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+
+			del := func(id string) *http.Response {
+				req, err := http.NewRequest(http.MethodDelete, "http://127.0.0.1:22222/api/simulations/"+id, nil)
+				So(err, ShouldBeNil)
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				return res
+			}
+			So(del(DefaultSimulationID).StatusCode, ShouldEqual, http.StatusConflict)
+			So(del("exercise2").StatusCode, ShouldEqual, http.StatusOK)
+			So(del("exercise2").StatusCode, ShouldEqual, http.StatusNotFound)
+			// Clients of removed simulations are disconnected
+			var resp ResponseStatus
+			So(c.ReadJSON(&resp), ShouldNotBeNil)
+		})
+		Convey("The API of each simulation should be served under its path", func() {
+			h, _ := simulations.get("exercise1")
+			patch := func(path string) int {
+				req, err := http.NewRequest(http.MethodPatch, "http://127.0.0.1:22222"+path, strings.NewReader(`{"timeFactor": 9}`))
+				So(err, ShouldBeNil)
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				res.Body.Close()
+				return res.StatusCode
+			}
+			So(patch("/api/v1/simulations/exercise1/simulation/options"), ShouldEqual, http.StatusOK)
+			So(h.sim.Options.TimeFactor, ShouldEqual, 9)
+			So(sim.Options.TimeFactor, ShouldNotEqual, 9)
+			So(patch("/api/v1/simulations/unknown/simulation/options"), ShouldEqual, http.StatusNotFound)
+			var audited bool
+			for _, entry := range h.audits.getSince(0, 1000) {
+				if entry.Event == "HTTP_COMMAND" && entry.Details["path"] == "/api/v1/simulations/exercise1/simulation/options" {
+					audited = true
+				}
+			}
+			So(audited, ShouldBeTrue)
+
+			res, err := http.Get("http://127.0.0.1:22222/api/v1/simulations/exercise1/simulation/options")
+			So(err, ShouldBeNil)
+			var opts struct {
+				Options map[string]interface{} `json:"options"`
+			}
+			So(json.NewDecoder(res.Body).Decode(&opts), ShouldBeNil)
+			res.Body.Close()
+			So(opts.Options["timeFactor"], ShouldEqual, 9)
+			res, err = http.Get("http://127.0.0.1:22222/api/v1/simulations/exercise1/simulations")
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			So(resourceLocation(req, "/api/disruptions/1"), ShouldEqual, "/api/disruptions/1")
+			req = req.WithContext(context.WithValue(req.Context(), hubContextKey{}, h))
+			So(resourceLocation(req, "/api/disruptions/1"), ShouldEqual, "/api/simulations/exercise1/disruptions/1")
+			So(resourceLocation(req, apiPrefix+"/sections/S"), ShouldEqual, apiPrefix+"/simulations/exercise1/sections/S")
+		})
+	})
+}
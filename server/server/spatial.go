@@ -0,0 +1,201 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// maxSpatialResults is the default maximum number of items returned by
+// /api/systems/layout/items
+const maxSpatialResults = 100
+
+// A layoutArea is a rectangle of the layout coordinates
+type layoutArea struct {
+    min, max simulation.Point
+}
+
+// contains returns true if p is inside this area
+func (la *layoutArea) contains(p simulation.Point) bool {
+    return p.X >= la.min.X && p.X <= la.max.X && p.Y >= la.min.Y && p.Y <= la.max.Y
+}
+
+// parseLayoutArea parses a bbox given as "minX,minY,maxX,maxY"
+func parseLayoutArea(s string) (*layoutArea, error) {
+    parts := strings.Split(s, ",")
+    if len(parts) != 4 {
+        return nil, fmt.Errorf("bbox must have 4 comma separated values, got %d", len(parts))
+    }
+    var vals [4]float64
+    for i, p := range parts {
+        v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid bbox value %q: %s", p, err)
+        }
+        vals[i] = v
+    }
+    if vals[0] > vals[2] || vals[1] > vals[3] {
+        return nil, fmt.Errorf("bbox minimum must not be greater than its maximum")
+    }
+    return &layoutArea{min: simulation.Point{X: vals[0], Y: vals[1]}, max: simulation.Point{X: vals[2], Y: vals[3]}}, nil
+}
+
+// queryFloat parses the given query parameter as a float. It returns false if
+// the parameter is not set.
+func queryFloat(r *http.Request, name string) (float64, bool, error) {
+    s := r.URL.Query().Get(name)
+    if s == "" {
+        return 0, false, nil
+    }
+    v, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+        return 0, true, fmt.Errorf("invalid %s %q", name, s)
+    }
+    return v, true, nil
+}
+
+// queryLayoutPoint returns the layout point of a position query, given either
+// in layout coordinates with x and y, or in geographic coordinates with lat
+// and lon when the simulation is geo-referenced.
+func queryLayoutPoint(r *http.Request) (simulation.Point, error) {
+    x, hasX, errX := queryFloat(r, "x")
+    y, hasY, errY := queryFloat(r, "y")
+    lat, hasLat, errLat := queryFloat(r, "lat")
+    lon, hasLon, errLon := queryFloat(r, "lon")
+    for _, err := range []error{errX, errY, errLat, errLon} {
+        if err != nil {
+            return simulation.Point{}, err
+        }
+    }
+    switch {
+    case hasX && hasY && !hasLat && !hasLon:
+        return simulation.Point{X: x, Y: y}, nil
+    case hasLat && hasLon && !hasX && !hasY:
+        p, ok := requestHub(r).sim.LayoutPointAt(simulation.LatLon{Lat: lat, Lon: lon})
+        if !ok {
+            return simulation.Point{}, fmt.Errorf("the layout of the simulation cannot be placed from geographic coordinates")
+        }
+        return p, nil
+    }
+    return simulation.Point{}, fmt.Errorf("either x and y or lat and lon must be given")
+}
+
+// queryTypeFilter returns a filter keeping the track items of the types given
+// in the comma separated type parameter, or nil if it is not set.
+func queryTypeFilter(r *http.Request) func(simulation.TrackItem) bool {
+    tp := r.URL.Query().Get("type")
+    if tp == "" {
+        return nil
+    }
+    types := make(map[simulation.TrackItemType]bool)
+    for _, t := range strings.Split(tp, ",") {
+        types[simulation.TrackItemType(strings.TrimSpace(t))] = true
+    }
+    return func(ti simulation.TrackItem) bool {
+        return types[ti.Type()]
+    }
+}
+
+// spatialMatchView returns the JSON representation of a spatial query result
+func spatialMatchView(m simulation.SpatialMatch) map[string]interface{} {
+    res := map[string]interface{}{
+        "id":       m.Item.ID(),
+        "type":     string(m.Item.Type()),
+        "name":     m.Item.Name(),
+        "distance": m.Distance,
+        "origin":   map[string]float64{"x": m.Item.Origin().X, "y": m.Item.Origin().Y},
+    }
+    if m.Item.Place() != nil {
+        res["place"] = m.Item.Place().PlaceCode
+    }
+    return res
+}
+
+// GET /api/systems/layout/items?x=&y=&radius=&type=&limit=
+// GET /api/systems/layout/items?lat=&lon=&radius=&type=&limit=
+//
+// Returns the track items drawn within radius layout units of the point,
+// nearest first.
+func serveLayoutItems(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    p, err := queryLayoutPoint(r)
+    if err != nil {
+        invalidParameter(w, err.Error(), nil)
+        return
+    }
+    radius, ok, err := queryFloat(r, "radius")
+    if err != nil || !ok || radius < 0 {
+        invalidParameter(w, "radius must be a positive number", map[string]interface{}{"radius": r.URL.Query().Get("radius")})
+        return
+    }
+    limit := maxSpatialResults
+    if lp := r.URL.Query().Get("limit"); lp != "" {
+        if limit, err = strconv.Atoi(lp); err != nil || limit <= 0 {
+            invalidParameter(w, "Bad limit", map[string]interface{}{"limit": lp})
+            return
+        }
+    }
+    matches := h.sim.ItemsWithin(p, radius, queryTypeFilter(r))
+    truncated := len(matches) > limit
+    if truncated {
+        matches = matches[:limit]
+    }
+    items := make([]map[string]interface{}, len(matches))
+    for i, m := range matches {
+        items[i] = spatialMatchView(m)
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{
+        "x":         p.X,
+        "y":         p.Y,
+        "radius":    radius,
+        "items":     items,
+        "truncated": truncated,
+    })
+}
+
+// GET /api/systems/layout/nearest?x=&y=&type=
+// GET /api/systems/layout/nearest?lat=&lon=&type=
+//
+// Returns the track item drawn nearest to the point, e.g. the nearest signal
+// with type=SignalItem.
+func serveLayoutNearest(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    p, err := queryLayoutPoint(r)
+    if err != nil {
+        invalidParameter(w, err.Error(), nil)
+        return
+    }
+    m, ok := h.sim.NearestItem(p, queryTypeFilter(r))
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "No matching track item",
+            map[string]interface{}{"type": r.URL.Query().Get("type")})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{
+        "x":    p.X,
+        "y":    p.Y,
+        "item": spatialMatchView(m),
+    })
+}
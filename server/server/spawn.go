@@ -0,0 +1,65 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A trainSpawnRequest asks to add a train to the running simulation. The
+// appear time is given as HH:MM:SS and may be past midnight, or as a date
+// time of the simulation.
+type trainSpawnRequest struct {
+    simulation.TrainSpawn
+    AppearTime string `json:"appearTime"`
+}
+
+// spawnTrain adds the train described by req to the simulation s.
+func spawnTrain(s *simulation.Simulation, req *trainSpawnRequest) (*simulation.Train, error) {
+    if req.AppearTime != "" {
+        appear, ok := s.ParseDateTime(req.AppearTime)
+        if !ok {
+            var err error
+            appear, err = nextTimeOfDay(s.Options.CurrentTime.Time, req.AppearTime)
+            if err != nil {
+                return nil, err
+            }
+        }
+        req.TrainSpawn.AppearTime.Time = appear
+    }
+    return s.SpawnTrain(&req.TrainSpawn)
+}
+
+// GET /api/trains
+// POST /api/trains
+//
+// POST adds a train to the running simulation and returns it.
+func serveTrains(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.Trains})
+    case http.MethodPost:
+        var req trainSpawnRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            badRequest(w, err)
+            return
+        }
+        t, err := spawnTrain(h.sim, &req)
+        if err != nil {
+            invalidParameter(w, err.Error(), map[string]interface{}{"trainTypeCode": req.TrainTypeCode, "serviceCode": req.ServiceCode, "entryPoint": req.EntryPoint})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(t)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
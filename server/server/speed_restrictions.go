@@ -0,0 +1,119 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// speedRestrictionRequest is the body of a speed restriction creation
+// request, from HTTP or from the hub.
+type speedRestrictionRequest struct {
+    TrackItemID     string  `json:"trackItemId"`
+    ToTrackItemID   string  `json:"toTrackItemId"`
+    SpeedLimit      float64 `json:"speedLimit"`
+    StartTime       string  `json:"startTime"`
+    EndTime         string  `json:"endTime"`
+    DurationMinutes int     `json:"durationMinutes"`
+    Reason          string  `json:"reason"`
+}
+
+// imposeSpeedRestriction creates the speed restriction described by sr and adds it to the simulation s
+func imposeSpeedRestriction(s *simulation.Simulation, sr speedRestrictionRequest) (*simulation.SpeedRestriction, error) {
+    tsr := &simulation.SpeedRestriction{
+        TrackItemID:   sr.TrackItemID,
+        ToTrackItemID: sr.ToTrackItemID,
+        SpeedLimit:    sr.SpeedLimit,
+        Reason:        sr.Reason,
+    }
+    start, err := parseSimTime(s, sr.StartTime)
+    if err != nil {
+        return nil, err
+    }
+    end, err := parseSimTime(s, sr.EndTime)
+    if err != nil {
+        return nil, err
+    }
+    if sr.DurationMinutes < 0 {
+        return nil, fmt.Errorf("durationMinutes must be positive")
+    }
+    if end.IsZero() && sr.DurationMinutes > 0 {
+        from := start.Time
+        if from.IsZero() {
+            from = s.Options.CurrentTime.Time
+        }
+        end.Time = from.Add(time.Duration(sr.DurationMinutes) * time.Minute)
+    }
+    tsr.StartTime.Time = start.Time
+    tsr.EndTime.Time = end.Time
+    if err := s.AddSpeedRestriction(tsr); err != nil {
+        return nil, err
+    }
+    return tsr, nil
+}
+
+// GET /api/speed-restrictions
+// POST /api/speed-restrictions
+func serveSpeedRestrictions(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.SpeedRestrictions()})
+    case http.MethodPost:
+        var body speedRestrictionRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        sr, err := imposeSpeedRestriction(h.sim, body)
+        if err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", resourceLocation(r, "/api/speed-restrictions/"+sr.ID()))
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(sr)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/speed-restrictions/{id}
+// DELETE /api/speed-restrictions/{id}
+func serveSpeedRestriction(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/speed-restrictions/")
+    switch r.Method {
+    case http.MethodGet:
+        sr, ok := h.sim.GetSpeedRestriction(id)
+        if !ok {
+            writeAPIError(w, http.StatusNotFound, ErrCodeSpeedRestrictionNotFound, "Speed restriction not found", map[string]interface{}{"speedRestrictionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(sr)
+    case http.MethodDelete:
+        if err := h.sim.RemoveSpeedRestriction(id); err != nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodeSpeedRestrictionNotFound, "Speed restriction not found", map[string]interface{}{"speedRestrictionId": id})
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
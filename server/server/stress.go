@@ -0,0 +1,487 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "net/url"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// stressDialTimeout is the time given to each synthetic client to connect
+// and register.
+const stressDialTimeout = 10 * time.Second
+
+// A StressCommand is a request sent by the synthetic clients of a stress run
+type StressCommand struct {
+    Object string          `json:"object"`
+    Action string          `json:"action"`
+    Params json.RawMessage `json:"params,omitempty"`
+}
+
+// name returns the name of the command in the report
+func (sc StressCommand) name() string {
+    return sc.Object + "/" + sc.Action
+}
+
+// DefaultStressScript is the script of the synthetic clients when none is
+// given: the reads of a typical client, and a recomputation of the
+// suggestions to load the suggestion engine.
+var DefaultStressScript = []StressCommand{
+    {Object: "train", Action: "list"},
+    {Object: "route", Action: "list"},
+    {Object: "trackItem", Action: "list"},
+    {Object: "simulation", Action: "isStarted"},
+    {Object: "suggestions", Action: "list"},
+    {Object: "suggestions", Action: "recompute"},
+}
+
+// StressConfig is the configuration of a synthetic traffic run against a
+// server.
+type StressConfig struct {
+    // URL is the websocket endpoint of the server, e.g. ws://localhost:22222/ws
+    URL string
+    // Token is the register token of the clients, see ParamsRegister
+    Token string
+    // Clients is the number of synthetic websocket clients
+    Clients int
+    // Duration is the time during which the clients send commands
+    Duration time.Duration
+    // RampUp is the time over which the clients connect
+    RampUp time.Duration
+    // Interval is the time between two commands of each client
+    Interval time.Duration
+    // Script holds the commands sent by each client in turn. Each client
+    // starts at a different command.
+    Script []StressCommand
+    // Events are listened to by each client
+    Events []simulation.EventName
+    // StartSimulation makes the first client start the simulation
+    StartSimulation bool
+}
+
+// DefaultStressConfig returns the default stress configuration, without URL.
+func DefaultStressConfig() StressConfig {
+    return StressConfig{
+        Clients:         100,
+        Duration:        time.Minute,
+        RampUp:          5 * time.Second,
+        Interval:        time.Second,
+        Script:          DefaultStressScript,
+        Events:          []simulation.EventName{simulation.ClockEvent, simulation.TrainChangedEvent, simulation.SignalaspectChangedEvent, simulation.SuggestionsUpdatedEvent},
+        StartSimulation: true,
+    }
+}
+
+// validate checks the configuration
+func (sc StressConfig) validate() error {
+    u, err := url.Parse(sc.URL)
+    if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+        return fmt.Errorf("stress URL must be a ws:// or wss:// URL, got %q", sc.URL)
+    }
+    if sc.Clients <= 0 {
+        return fmt.Errorf("stress clients must be positive, got %d", sc.Clients)
+    }
+    if sc.Duration <= 0 {
+        return fmt.Errorf("stress duration must be positive, got %s", sc.Duration)
+    }
+    if sc.RampUp < 0 {
+        return fmt.Errorf("stress ramp up must not be negative, got %s", sc.RampUp)
+    }
+    if sc.Interval <= 0 {
+        return fmt.Errorf("stress interval must be positive, got %s", sc.Interval)
+    }
+    if len(sc.Script) == 0 {
+        return fmt.Errorf("stress script has no command")
+    }
+    for i, cmd := range sc.Script {
+        if cmd.Object == "" || cmd.Action == "" {
+            return fmt.Errorf("command %d of the stress script has no object or action", i)
+        }
+    }
+    return nil
+}
+
+// LoadStressScript reads a stress script from a JSON file holding a list of
+// commands such as {"object": "train", "action": "list"}.
+func LoadStressScript(fileName string) ([]StressCommand, error) {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, err
+    }
+    var script []StressCommand
+    if err := json.Unmarshal(data, &script); err != nil {
+        return nil, fmt.Errorf("invalid stress script %s: %s", fileName, err)
+    }
+    return script, nil
+}
+
+// ScaleSimulationTrains duplicates the trains of the simulation file data
+// until it has the given number of trains, to stress the server with a large
+// simulation. Each copy appears spacing later than the previous copy of the
+// same train.
+func ScaleSimulationTrains(data []byte, trains int, spacing time.Duration) ([]byte, error) {
+    var raw map[string]interface{}
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return nil, err
+    }
+    original, _ := raw["trains"].([]interface{})
+    if len(original) == 0 {
+        return nil, fmt.Errorf("the simulation has no train to duplicate")
+    }
+    scaled := original
+    for i := len(original); i < trains; i++ {
+        tr := make(map[string]interface{})
+        for k, v := range original[i%len(original)].(map[string]interface{}) {
+            tr[k] = v
+        }
+        tr["trainId"] = fmt.Sprint(i)
+        if at, ok := tr["appearTime"].(string); ok {
+            tr["appearTime"] = simulation.ParseTime(at).Add(time.Duration(i/len(original)) * spacing)
+        }
+        scaled = append(scaled, tr)
+    }
+    raw["trains"] = scaled
+    return json.Marshal(raw)
+}
+
+// stressLatencies holds the response times of a command
+type stressLatencies struct {
+    durations []time.Duration
+    errors    int
+}
+
+// report returns the statistics of these latencies in milliseconds
+func (sl *stressLatencies) report() map[string]interface{} {
+    res := map[string]interface{}{"count": len(sl.durations), "errors": sl.errors}
+    if len(sl.durations) == 0 {
+        return res
+    }
+    sort.Slice(sl.durations, func(i, j int) bool { return sl.durations[i] < sl.durations[j] })
+    ms := func(d time.Duration) float64 {
+        return float64(d) / float64(time.Millisecond)
+    }
+    percentile := func(p float64) float64 {
+        idx := int(math.Ceil(p*float64(len(sl.durations)))) - 1
+        if idx < 0 {
+            idx = 0
+        }
+        return ms(sl.durations[idx])
+    }
+    var total time.Duration
+    for _, d := range sl.durations {
+        total += d
+    }
+    res["avgMs"] = ms(total / time.Duration(len(sl.durations)))
+    res["p50Ms"] = percentile(0.5)
+    res["p90Ms"] = percentile(0.9)
+    res["p99Ms"] = percentile(0.99)
+    res["maxMs"] = ms(sl.durations[len(sl.durations)-1])
+    return res
+}
+
+// A StressReport holds the results of a stress run
+type StressReport struct {
+    Clients             int     `json:"clients"`
+    Connected           int     `json:"connected"`
+    ConnectFailures     int     `json:"connectFailures"`
+    Disconnections      int     `json:"disconnections"`
+    DurationSeconds     float64 `json:"durationSeconds"`
+    Requests            int     `json:"requests"`
+    Responses           int     `json:"responses"`
+    Errors              int     `json:"errors"`
+    Unanswered          int     `json:"unanswered"`
+    Notifications       int64   `json:"notifications"`
+    BytesReceived       int64   `json:"bytesReceived"`
+    RequestsPerSec      float64 `json:"requestsPerSecond"`
+    ResponsesPerSec     float64 `json:"responsesPerSecond"`
+    NotificationsPerSec float64 `json:"notificationsPerSecond"`
+    // Latency is the response time of all the commands, and Commands the
+    // response time of each command by object/action.
+    Latency  map[string]interface{} `json:"latency"`
+    Commands map[string]interface{} `json:"commands"`
+    // FirstErrors holds the first error messages, to diagnose failures
+    FirstErrors []string `json:"firstErrors,omitempty"`
+    // ServerTimings are the timings of the operations of the server, when
+    // it runs in the same process as the stress run.
+    ServerTimings map[string]interface{} `json:"serverTimings,omitempty"`
+}
+
+// maxStressErrors is the number of error messages kept in a stress report
+const maxStressErrors = 10
+
+// stressRun holds the results of a stress run while it runs
+type stressRun struct {
+    config        StressConfig
+    mutex         sync.Mutex
+    connected     int
+    failures      int
+    disconnected  int
+    requests      int
+    unanswered    int
+    all           stressLatencies
+    commands      map[string]*stressLatencies
+    errors        []string
+    notifications int64
+    bytes         int64
+}
+
+// addError records an error message of the run
+func (sr *stressRun) addError(format string, args ...interface{}) {
+    sr.mutex.Lock()
+    defer sr.mutex.Unlock()
+    if len(sr.errors) < maxStressErrors {
+        sr.errors = append(sr.errors, fmt.Sprintf(format, args...))
+    }
+}
+
+// addResponse records the response to a command
+func (sr *stressRun) addResponse(name string, d time.Duration, failed bool) {
+    sr.mutex.Lock()
+    defer sr.mutex.Unlock()
+    cl, ok := sr.commands[name]
+    if !ok {
+        cl = new(stressLatencies)
+        sr.commands[name] = cl
+    }
+    for _, l := range []*stressLatencies{cl, &sr.all} {
+        l.durations = append(l.durations, d)
+        if failed {
+            l.errors++
+        }
+    }
+}
+
+// stressPending is a command waiting for its response
+type stressPending struct {
+    name string
+    sent time.Time
+}
+
+// stressResponse is the part of the messages of the server read by the
+// synthetic clients
+type stressResponse struct {
+    ID      int             `json:"id"`
+    MsgType MessageType     `json:"msgType"`
+    Data    json.RawMessage `json:"data"`
+}
+
+// failed returns the error message of this response, or an empty string
+func (r stressResponse) failed() string {
+    var status DataStatus
+    if len(r.Data) == 0 || r.Data[0] != '{' || json.Unmarshal(r.Data, &status) != nil {
+        return ""
+    }
+    if status.Status != "" && status.Status != Ok {
+        return fmt.Sprintf("%s: %s", status.Status, status.Message)
+    }
+    return ""
+}
+
+// client runs the synthetic client number n until stop is closed
+func (sr *stressRun) client(n int, stop <-chan struct{}) {
+    dialer := websocket.Dialer{HandshakeTimeout: stressDialTimeout}
+    ws, _, err := dialer.Dial(sr.config.URL, nil)
+    if err == nil {
+        err = sr.register(ws)
+    }
+    if err != nil {
+        sr.mutex.Lock()
+        sr.failures++
+        sr.mutex.Unlock()
+        sr.addError("client %d: %s", n, err)
+        if ws != nil {
+            _ = ws.Close()
+        }
+        return
+    }
+    defer ws.Close()
+    sr.mutex.Lock()
+    sr.connected++
+    sr.mutex.Unlock()
+
+    var (
+        mutex   sync.Mutex
+        pending = make(map[int]stressPending)
+        nextID  = 1
+        writeMu sync.Mutex
+    )
+    send := func(cmd StressCommand) error {
+        mutex.Lock()
+        id := nextID
+        nextID++
+        pending[id] = stressPending{name: cmd.name(), sent: time.Now()}
+        mutex.Unlock()
+        sr.mutex.Lock()
+        sr.requests++
+        sr.mutex.Unlock()
+        req := Request{ID: id, Object: cmd.Object, Action: cmd.Action, Params: RawJSON(cmd.Params)}
+        if req.Params == nil {
+            req.Params = RawJSON("{}")
+        }
+        writeMu.Lock()
+        defer writeMu.Unlock()
+        return ws.WriteJSON(req)
+    }
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for {
+            _, data, err := ws.ReadMessage()
+            if err != nil {
+                select {
+                case <-stop:
+                default:
+                    sr.mutex.Lock()
+                    sr.disconnected++
+                    sr.mutex.Unlock()
+                    sr.addError("client %d: %s", n, err)
+                }
+                return
+            }
+            atomic.AddInt64(&sr.bytes, int64(len(data)))
+            var resp stressResponse
+            if err := json.Unmarshal(data, &resp); err != nil {
+                sr.addError("client %d: unparsable message: %s", n, err)
+                continue
+            }
+            if resp.MsgType == TypeNotification {
+                atomic.AddInt64(&sr.notifications, 1)
+                continue
+            }
+            if resp.MsgType != TypeResponse {
+                continue
+            }
+            mutex.Lock()
+            p, ok := pending[resp.ID]
+            delete(pending, resp.ID)
+            mutex.Unlock()
+            if !ok {
+                continue
+            }
+            msg := resp.failed()
+            if msg != "" {
+                sr.addError("client %d: %s: %s", n, p.name, msg)
+            }
+            sr.addResponse(p.name, time.Since(p.sent), msg != "")
+        }
+    }()
+
+    for _, event := range sr.config.Events {
+        params, _ := json.Marshal(ParamsListener{Event: event})
+        if err := send(StressCommand{Object: "server", Action: "addListener", Params: params}); err != nil {
+            sr.addError("client %d: %s", n, err)
+        }
+    }
+    if n == 0 && sr.config.StartSimulation {
+        if err := send(StressCommand{Object: "simulation", Action: "start"}); err != nil {
+            sr.addError("client %d: %s", n, err)
+        }
+    }
+    ticker := time.NewTicker(sr.config.Interval)
+    defer ticker.Stop()
+    for i := n; ; i++ {
+        select {
+        case <-stop:
+            // Let the last responses arrive before counting the unanswered
+            // commands.
+            time.Sleep(sr.config.Interval)
+            writeMu.Lock()
+            _ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+            writeMu.Unlock()
+            _ = ws.Close()
+            <-done
+            mutex.Lock()
+            sr.mutex.Lock()
+            sr.unanswered += len(pending)
+            sr.mutex.Unlock()
+            mutex.Unlock()
+            return
+        case <-done:
+            return
+        case <-ticker.C:
+            if err := send(sr.config.Script[i%len(sr.config.Script)]); err != nil {
+                sr.addError("client %d: %s", n, err)
+            }
+        }
+    }
+}
+
+// register logs the synthetic client onto the server
+func (sr *stressRun) register(ws *websocket.Conn) error {
+    _ = ws.SetReadDeadline(time.Now().Add(stressDialTimeout))
+    defer ws.SetReadDeadline(time.Time{})
+    if err := ws.WriteJSON(RequestRegister{ID: 0, Object: "server", Action: "register", Params: ParamsRegister{ClientType: Client, Token: sr.config.Token}}); err != nil {
+        return err
+    }
+    var resp ResponseStatus
+    if err := ws.ReadJSON(&resp); err != nil {
+        return err
+    }
+    if resp.Data.Status != Ok {
+        return fmt.Errorf("unable to register: %s", resp.Data.Message)
+    }
+    return nil
+}
+
+// RunStress drives the server at config.URL with synthetic websocket clients
+// sending the commands of the script, and reports the throughput and the
+// response times of the server.
+func RunStress(config StressConfig) (*StressReport, error) {
+    if err := config.validate(); err != nil {
+        return nil, err
+    }
+    sr := &stressRun{config: config, commands: make(map[string]*stressLatencies)}
+    stop := make(chan struct{})
+    var wg sync.WaitGroup
+    start := time.Now()
+    for n := 0; n < config.Clients; n++ {
+        wg.Add(1)
+        go func(n int) {
+            defer wg.Done()
+            sr.client(n, stop)
+        }(n)
+        if config.RampUp > 0 && n < config.Clients-1 {
+            time.Sleep(config.RampUp / time.Duration(config.Clients))
+        }
+    }
+    time.Sleep(time.Until(start.Add(config.RampUp + config.Duration)))
+    elapsed := time.Since(start).Seconds()
+    close(stop)
+    wg.Wait()
+
+    report := &StressReport{
+        Clients:         config.Clients,
+        Connected:       sr.connected,
+        ConnectFailures: sr.failures,
+        Disconnections:  sr.disconnected,
+        DurationSeconds: elapsed,
+        Requests:        sr.requests,
+        Responses:       len(sr.all.durations),
+        Errors:          sr.all.errors,
+        Unanswered:      sr.unanswered,
+        Notifications:   sr.notifications,
+        BytesReceived:   sr.bytes,
+        FirstErrors:     sr.errors,
+        Commands:        make(map[string]interface{}),
+    }
+    report.RequestsPerSec = float64(report.Requests) / elapsed
+    report.ResponsesPerSec = float64(report.Responses) / elapsed
+    report.NotificationsPerSec = float64(report.Notifications) / elapsed
+    report.Latency = sr.all.report()
+    for name, cl := range sr.commands {
+        report.Commands[name] = cl.report()
+    }
+    if t := timings.report(); len(t) > 0 {
+        report.ServerTimings = t
+    }
+    return report, nil
+}
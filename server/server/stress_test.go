@@ -0,0 +1,98 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestStress(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing the stress harness", t, func() {
+		Convey("Configuration should be validated", func() {
+			sc := DefaultStressConfig()
+			_, err := RunStress(sc)
+			So(err, ShouldNotBeNil)
+			sc.URL = "http://127.0.0.1:22222/ws"
+			So(sc.validate(), ShouldNotBeNil)
+			sc.URL = "ws://127.0.0.1:22222/ws"
+			So(sc.validate(), ShouldBeNil)
+			sc.Clients = 0
+			So(sc.validate(), ShouldNotBeNil)
+			sc.Clients = 1
+			sc.Script = []StressCommand{{Object: "train"}}
+			So(sc.validate(), ShouldNotBeNil)
+		})
+		Convey("Simulations should be scaled", func() {
+			data, err := ioutil.ReadFile("../simulation/testdata/demo.json")
+			So(err, ShouldBeNil)
+			data, err = ScaleSimulationTrains(data, 7, time.Minute)
+			So(err, ShouldBeNil)
+			var s simulation.Simulation
+			So(json.Unmarshal(data, &s), ShouldBeNil)
+			So(s.Trains, ShouldHaveLength, 7)
+			var raw struct {
+				Trains []map[string]interface{} `json:"trains"`
+			}
+			So(json.Unmarshal(data, &raw), ShouldBeNil)
+			So(raw.Trains[6]["trainId"], ShouldEqual, "6")
+			So(raw.Trains[6]["serviceCode"], ShouldEqual, "S001")
+			So(raw.Trains[6]["appearTime"], ShouldEqual, "06:03:00")
+			So(raw.Trains[5]["serviceCode"], ShouldEqual, "S003")
+			So(raw.Trains[5]["appearTime"], ShouldEqual, "06:05:00")
+			_, err = ScaleSimulationTrains([]byte(`{"trains": []}`), 7, time.Minute)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Clients should drive the server and report", func() {
+			sc := DefaultStressConfig()
+			sc.URL = "ws://127.0.0.1:22222/ws"
+			sc.Token = "client-secret"
+			sc.Clients = 10
+			sc.Duration = time.Second
+			sc.RampUp = 100 * time.Millisecond
+			sc.Interval = 50 * time.Millisecond
+			sc.Script = []StressCommand{
+				{Object: "train", Action: "list"},
+				{Object: "route", Action: "show", Params: json.RawMessage(`{"ids": ["1"]}`)},
+				{Object: "route", Action: "unknown"},
+			}
+			sc.Events = []simulation.EventName{simulation.ClockEvent}
+			sc.StartSimulation = false
+			report, err := RunStress(sc)
+			So(err, ShouldBeNil)
+			So(report.Connected, ShouldEqual, 10)
+			So(report.ConnectFailures, ShouldEqual, 0)
+			So(report.Disconnections, ShouldEqual, 0)
+			So(report.Unanswered, ShouldEqual, 0)
+			So(report.Requests, ShouldBeGreaterThan, 100)
+			So(report.Responses, ShouldEqual, report.Requests)
+			So(report.Commands, ShouldContainKey, "train/list")
+			So(report.Commands, ShouldContainKey, "route/show")
+			So(report.Commands, ShouldContainKey, "server/addListener")
+			So(report.Commands["train/list"].(map[string]interface{})["errors"], ShouldEqual, 0)
+			unknown := report.Commands["route/unknown"].(map[string]interface{})
+			So(unknown["errors"], ShouldEqual, unknown["count"])
+			So(report.Errors, ShouldEqual, unknown["count"])
+			So(report.FirstErrors, ShouldNotBeEmpty)
+			So(report.Latency["p99Ms"], ShouldBeGreaterThanOrEqualTo, report.Latency["p50Ms"])
+			So(report.ResponsesPerSec, ShouldBeGreaterThan, 0)
+			So(report.BytesReceived, ShouldBeGreaterThan, 0)
+			So(report.ServerTimings, ShouldContainKey, operationHubRequest)
+
+			sc.Token = "wrong"
+			sc.Clients = 2
+			report, err = RunStress(sc)
+			So(err, ShouldBeNil)
+			So(report.Connected, ShouldEqual, 0)
+			So(report.ConnectFailures, ShouldEqual, 2)
+		})
+	})
+}
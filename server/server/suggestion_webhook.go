@@ -0,0 +1,308 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// Decisions sent to the suggestion webhook
+const (
+    decisionGenerated  = "generated"
+    decisionAccepted   = "accepted"
+    decisionDismissed  = "dismissed"
+    decisionOverridden = "overridden"
+)
+
+const suggestionWebhookQueueSize = 1024
+
+// SuggestionWebhookConfig is the configuration of the suggestion decision
+// webhook, to which each generated suggestion and each dispatcher decision
+// on a suggestion is POSTed.
+type SuggestionWebhookConfig struct {
+    // URL is the http or https endpoint receiving the decisions
+    URL string
+    // Secret signs the payloads with HMAC-SHA256 in the X-TS2-Signature
+    // header, as for audit webhooks. Payloads are not signed if it is empty.
+    Secret string
+    // Decisions are the decisions sent among generated, accepted, dismissed
+    // and overridden. All decisions are sent if it is empty.
+    Decisions []string
+}
+
+// validate checks the configuration
+func (sc SuggestionWebhookConfig) validate() error {
+    u, err := url.Parse(sc.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("invalid suggestion webhook URL: %s", sc.URL)
+    }
+    for _, d := range sc.Decisions {
+        switch strings.ToLower(d) {
+        case decisionGenerated, decisionAccepted, decisionDismissed, decisionOverridden:
+        default:
+            return fmt.Errorf("unknown suggestion decision: %s", d)
+        }
+    }
+    return nil
+}
+
+// A suggestionDecision is the body POSTed to the suggestion webhook
+type suggestionDecision struct {
+    DeliveryID string                `json:"deliveryId"`
+    Decision   string                `json:"decision"`
+    Simulation string                `json:"simulation"`
+    SimTime    string                `json:"simTime"`
+    DecidedAt  string                `json:"decidedAt"`
+    Suggestion simulation.Suggestion `json:"suggestion"`
+    // Source is the API the decision was made on: websocket or http
+    Source         string                 `json:"source,omitempty"`
+    Role           ClientRole             `json:"role,omitempty"`
+    UserID         string                 `json:"userId,omitempty"`
+    DismissMinutes int                    `json:"dismissMinutes,omitempty"`
+    OverrideAction map[string]interface{} `json:"overrideAction,omitempty"`
+    // Error is set when an accepted suggestion could not be applied
+    Error string `json:"error,omitempty"`
+}
+
+// A suggestionWebhook delivers suggestion decisions to an external endpoint.
+//
+// Decisions are queued and delivered in order by a single goroutine, with
+// the retries of audit webhooks. A decision is dropped when the queue is full
+// or when all its attempts failed, so that the simulation is never delayed.
+type suggestionWebhook struct {
+    config SuggestionWebhookConfig
+    queue  chan suggestionDecision
+
+    mutex      sync.Mutex
+    delivered  int
+    failed     int
+    dropped    int
+    retries    int
+    lastStatus int
+    lastError  string
+    lastSentAt time.Time
+}
+
+var (
+    suggestionHook      *suggestionWebhook
+    suggestionHookMutex sync.RWMutex
+)
+
+// SetSuggestionWebhookConfig configures the suggestion decision webhook.
+// Decisions are delivered when the server runs.
+func SetSuggestionWebhookConfig(sc SuggestionWebhookConfig) error {
+    if err := sc.validate(); err != nil {
+        return err
+    }
+    suggestionHookMutex.Lock()
+    defer suggestionHookMutex.Unlock()
+    suggestionHook = &suggestionWebhook{
+        config: sc,
+        queue:  make(chan suggestionDecision, suggestionWebhookQueueSize),
+    }
+    return nil
+}
+
+// currentSuggestionWebhook returns the suggestion webhook or nil if it is
+// not configured
+func currentSuggestionWebhook() *suggestionWebhook {
+    suggestionHookMutex.RLock()
+    defer suggestionHookMutex.RUnlock()
+    return suggestionHook
+}
+
+// startSuggestionWebhook starts delivering decisions if the webhook is configured
+func startSuggestionWebhook() {
+    if sw := currentSuggestionWebhook(); sw != nil {
+        go sw.run()
+    }
+}
+
+// sends returns true if the given decision is sent to the webhook
+func (sw *suggestionWebhook) sends(decision string) bool {
+    return len(sw.config.Decisions) == 0 || containsFold(sw.config.Decisions, decision)
+}
+
+// enqueue queues the given decision, or drops it if the queue is full
+func (sw *suggestionWebhook) enqueue(d suggestionDecision) {
+    select {
+    case sw.queue <- d:
+    default:
+        sw.mutex.Lock()
+        sw.dropped++
+        sw.mutex.Unlock()
+    }
+}
+
+// run delivers the queued decisions
+func (sw *suggestionWebhook) run() {
+    for d := range sw.queue {
+        sw.deliver(d)
+    }
+}
+
+// deliver POSTs the given decision, retrying with exponential backoff on
+// network errors and non 2xx responses.
+func (sw *suggestionWebhook) deliver(d suggestionDecision) {
+    body, err := json.Marshal(d)
+    if err != nil {
+        logger.Error("Unable to marshal suggestion decision", "submodule", "webhooks", "error", err)
+        return
+    }
+    delay := webhookRetryDelay
+    for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+        status, err := sw.post(d, body, attempt)
+        sw.mutex.Lock()
+        sw.lastStatus = status
+        sw.lastSentAt = time.Now().UTC()
+        switch {
+        case err == nil:
+            sw.delivered++
+            sw.lastError = ""
+        case attempt == webhookMaxAttempts:
+            sw.failed++
+            sw.lastError = err.Error()
+        default:
+            sw.retries++
+            sw.lastError = err.Error()
+        }
+        sw.mutex.Unlock()
+        if err == nil {
+            return
+        }
+        logger.Warn("Suggestion decision delivery failed", "submodule", "webhooks", "delivery", d.DeliveryID,
+            "attempt", attempt, "status", status, "error", err)
+        if attempt < webhookMaxAttempts {
+            time.Sleep(delay)
+            delay *= 2
+        }
+    }
+}
+
+// post sends one delivery attempt and returns the response status
+func (sw *suggestionWebhook) post(d suggestionDecision, body []byte, attempt int) (int, error) {
+    req, err := http.NewRequest(http.MethodPost, sw.config.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    req.Header.Set("User-Agent", "ts2-sim-server-webhooks")
+    req.Header.Set("X-TS2-Event", "suggestion."+d.Decision)
+    req.Header.Set("X-TS2-Delivery", d.DeliveryID)
+    req.Header.Set("X-TS2-Attempt", fmt.Sprint(attempt))
+    if sw.config.Secret != "" {
+        req.Header.Set(webhookSignatureHeader, signWebhookPayload(sw.config.Secret, body))
+    }
+    resp, err := webhookClient.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    _ = resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// view returns the status of the webhook for the API
+func (sw *suggestionWebhook) view() map[string]interface{} {
+    sw.mutex.Lock()
+    defer sw.mutex.Unlock()
+    decisions := sw.config.Decisions
+    if len(decisions) == 0 {
+        decisions = []string{decisionGenerated, decisionAccepted, decisionDismissed, decisionOverridden}
+    }
+    res := map[string]interface{}{
+        "enabled":   true,
+        "url":       sw.config.URL,
+        "signed":    sw.config.Secret != "",
+        "decisions": decisions,
+        "queued":    len(sw.queue),
+        "stats": map[string]interface{}{
+            "delivered": sw.delivered,
+            "failed":    sw.failed,
+            "dropped":   sw.dropped,
+            "retries":   sw.retries,
+        },
+        "lastStatus": sw.lastStatus,
+        "lastError":  sw.lastError,
+    }
+    if !sw.lastSentAt.IsZero() {
+        res["lastSentAt"] = sw.lastSentAt.Format(time.RFC3339)
+    }
+    return res
+}
+
+// findSuggestion returns the suggestion with the given ID in the current
+// suggestions of sim.
+func findSuggestion(sim *simulation.Simulation, id string) (simulation.Suggestion, bool) {
+    if sim.Suggestions == nil {
+        return simulation.Suggestion{}, false
+    }
+    for _, s := range sim.Suggestions.Items {
+        if s.ID == id {
+            return s, true
+        }
+    }
+    return simulation.Suggestion{}, false
+}
+
+// sendSuggestionDecision queues the decision d on the suggestion s of this
+// hub's simulation for the suggestion webhook, if it is configured. Overrides
+// are also scored in the running session.
+func (h *Hub) sendSuggestionDecision(s simulation.Suggestion, d suggestionDecision) {
+    if d.Decision == decisionOverridden {
+        h.recordOverrideScore(s.ID)
+    }
+    sw := currentSuggestionWebhook()
+    if sw == nil || !sw.sends(d.Decision) {
+        return
+    }
+    d.DeliveryID = nextDeliveryID()
+    d.Simulation = h.id
+    d.SimTime = h.sim.FormatTime(h.sim.Options.CurrentTime.Time)
+    d.DecidedAt = time.Now().UTC().Format(time.RFC3339)
+    d.Suggestion = s
+    sw.enqueue(d)
+}
+
+// sendGeneratedSuggestions sends the suggestions of a suggestionsUpdated
+// event that were not in the previous update to the suggestion webhook.
+func (h *Hub) sendGeneratedSuggestions(e *simulation.Event) {
+    if e.Name != simulation.SuggestionsUpdatedEvent {
+        return
+    }
+    items := h.eventSnapshot(e).suggestions
+    known := make(map[string]bool, len(items))
+    for _, it := range items {
+        known[it.ID] = true
+        if !h.knownSuggestions[it.ID] {
+            h.sendSuggestionDecision(it, suggestionDecision{Decision: decisionGenerated})
+        }
+    }
+    h.knownSuggestions = known
+}
+
+// GET /api/suggestions/webhook
+//
+// Returns the configuration and delivery statistics of the suggestion
+// decision webhook.
+func serveSuggestionWebhook(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    res := map[string]interface{}{"enabled": false}
+    if sw := currentSuggestionWebhook(); sw != nil {
+        res = sw.view()
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
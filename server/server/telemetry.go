@@ -0,0 +1,677 @@
+package server
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    mrand "math/rand"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+    maxTelemetrySpans      = 2048
+    telemetryBatchSize     = 512
+    telemetrySpanInterval  = 5 * time.Second
+    telemetryExportTimeout = 10 * time.Second
+    defaultMetricsInterval = time.Minute
+    telemetryScopeName     = "github.com/ts2/ts2-sim-server/server"
+    traceparentHeader      = "Traceparent"
+)
+
+// OTLP span kinds and status codes
+const (
+    spanKindInternal = 1
+    spanKindServer   = 2
+    spanStatusError  = 2
+)
+
+// Names of the recorded metrics
+const (
+    metricHTTPDuration        = "http.server.request.duration"
+    metricHubDuration         = "ts2.hub.request.duration"
+    metricStepDuration        = "ts2.simulation.step.duration"
+    metricSuggestionsDuration = "ts2.suggestions.compute.duration"
+)
+
+// metricDescriptions are the descriptions of the recorded metrics
+var metricDescriptions = map[string]string{
+    metricHTTPDuration:        "Duration of the HTTP API requests.",
+    metricHubDuration:         "Duration of the websocket requests dispatched by the hub.",
+    metricStepDuration:        "Duration of the clock ticks of the simulations.",
+    metricSuggestionsDuration: "Duration of the computations of the suggestions.",
+}
+
+// durationBounds are the bucket bounds in seconds of the duration histograms,
+// as advised by the OpenTelemetry HTTP semantic conventions.
+var durationBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// TelemetryConfig is the configuration of the OpenTelemetry exporter
+type TelemetryConfig struct {
+    // Endpoint is the base URL of the OTLP/HTTP receiver, e.g.
+    // http://localhost:4318. Traces are sent to /v1/traces and metrics to
+    // /v1/metrics.
+    Endpoint    string
+    ServiceName string
+    // Headers are added to the export requests, e.g. for authentication
+    Headers map[string]string
+    // SampleRatio is the share of the traces that are exported. Traces
+    // started by a sampled traceparent header are always exported.
+    SampleRatio float64
+    // MetricsInterval is the interval between two exports of the metrics
+    MetricsInterval time.Duration
+}
+
+// DefaultTelemetryConfig returns a TelemetryConfig with no endpoint
+func DefaultTelemetryConfig() TelemetryConfig {
+    return TelemetryConfig{
+        ServiceName:     "ts2-sim-server",
+        SampleRatio:     1,
+        MetricsInterval: defaultMetricsInterval,
+    }
+}
+
+// validate checks the configuration
+func (tc TelemetryConfig) validate() error {
+    u, err := url.Parse(tc.Endpoint)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("OTLP endpoint must be an absolute http or https URL")
+    }
+    if tc.ServiceName == "" {
+        return fmt.Errorf("OTLP service name cannot be empty")
+    }
+    if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+        return fmt.Errorf("OTLP sample ratio must be between 0 and 1")
+    }
+    if tc.MetricsInterval < time.Second {
+        return fmt.Errorf("OTLP metrics interval must be at least 1s")
+    }
+    return nil
+}
+
+// ParseOTLPHeaders parses a comma separated list of key=value headers
+func ParseOTLPHeaders(s string) (map[string]string, error) {
+    res := make(map[string]string)
+    if s == "" {
+        return res, nil
+    }
+    for _, kv := range strings.Split(s, ",") {
+        parts := strings.SplitN(kv, "=", 2)
+        if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+            return nil, fmt.Errorf("invalid OTLP header %s, expected key=value", kv)
+        }
+        res[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+    }
+    return res, nil
+}
+
+// An attribute is a key/value pair of a span or a metric data point. Values
+// are strings, ints, float64 or bools.
+type attribute struct {
+    key   string
+    value interface{}
+}
+
+// otlp returns the OTLP JSON representation of this attribute
+func (a attribute) otlp() map[string]interface{} {
+    var v map[string]interface{}
+    switch val := a.value.(type) {
+    case int:
+        v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+    case float64:
+        v = map[string]interface{}{"doubleValue": val}
+    case bool:
+        v = map[string]interface{}{"boolValue": val}
+    default:
+        v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+    }
+    return map[string]interface{}{"key": a.key, "value": v}
+}
+
+func otlpAttributes(attrs []attribute) []map[string]interface{} {
+    res := make([]map[string]interface{}, len(attrs))
+    for i, a := range attrs {
+        res[i] = a.otlp()
+    }
+    return res
+}
+
+// A span is a timed operation of a trace
+type span struct {
+    traceID    [16]byte
+    spanID     [8]byte
+    parentID   [8]byte
+    sampled    bool
+    name       string
+    kind       int
+    start      time.Time
+    end        time.Time
+    attributes []attribute
+    status     int
+    message    string
+}
+
+// setAttributes adds the given attributes to the span. Spans may be nil when
+// telemetry is not configured.
+func (s *span) setAttributes(attrs ...attribute) {
+    if s == nil {
+        return
+    }
+    s.attributes = append(s.attributes, attrs...)
+}
+
+// setError marks the span as failed with the given message
+func (s *span) setError(msg string) {
+    if s == nil {
+        return
+    }
+    s.status = spanStatusError
+    s.message = msg
+}
+
+// otlp returns the OTLP JSON representation of this span
+func (s *span) otlp() map[string]interface{} {
+    res := map[string]interface{}{
+        "traceId":           hex.EncodeToString(s.traceID[:]),
+        "spanId":            hex.EncodeToString(s.spanID[:]),
+        "name":              s.name,
+        "kind":              s.kind,
+        "startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+        "endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+        "attributes":        otlpAttributes(s.attributes),
+        "status":            map[string]interface{}{"code": s.status, "message": s.message},
+    }
+    if s.parentID != [8]byte{} {
+        res["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+    }
+    return res
+}
+
+// parseTraceparent returns the trace ID, parent span ID and sampled flag of
+// a W3C traceparent header value, or false if it is invalid.
+func parseTraceparent(value string) ([16]byte, [8]byte, bool, bool) {
+    var traceID [16]byte
+    var parentID [8]byte
+    parts := strings.Split(strings.TrimSpace(value), "-")
+    if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+        return traceID, parentID, false, false
+    }
+    if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+        return traceID, parentID, false, false
+    }
+    if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+        return traceID, parentID, false, false
+    }
+    flags, err := hex.DecodeString(parts[3])
+    if err != nil {
+        return traceID, parentID, false, false
+    }
+    return traceID, parentID, flags[0]&0x01 == 1, true
+}
+
+// A histogram is the cumulative distribution of a duration metric for one
+// set of attributes
+type histogram struct {
+    name       string
+    attributes []attribute
+    counts     []uint64
+    count      uint64
+    sum        float64
+    min        float64
+    max        float64
+}
+
+// record adds a value to the histogram
+func (h *histogram) record(v float64) {
+    i := sort.SearchFloat64s(durationBounds, v)
+    h.counts[i]++
+    if h.count == 0 || v < h.min {
+        h.min = v
+    }
+    if h.count == 0 || v > h.max {
+        h.max = v
+    }
+    h.count++
+    h.sum += v
+}
+
+// otlp returns the OTLP JSON data point of this histogram
+func (h *histogram) otlp(start, now time.Time) map[string]interface{} {
+    counts := make([]string, len(h.counts))
+    for i, c := range h.counts {
+        counts[i] = strconv.FormatUint(c, 10)
+    }
+    return map[string]interface{}{
+        "attributes":        otlpAttributes(h.attributes),
+        "startTimeUnixNano": strconv.FormatInt(start.UnixNano(), 10),
+        "timeUnixNano":      strconv.FormatInt(now.UnixNano(), 10),
+        "count":             strconv.FormatUint(h.count, 10),
+        "sum":               h.sum,
+        "min":               h.min,
+        "max":               h.max,
+        "bucketCounts":      counts,
+        "explicitBounds":    durationBounds,
+    }
+}
+
+// A telemetryExporter records spans and duration metrics and exports them
+// to an OpenTelemetry collector with OTLP/HTTP in JSON.
+//
+// Spans are exported every 5 seconds and dropped when more than 2048 are
+// waiting. Metrics are cumulative and exported every MetricsInterval.
+type telemetryExporter struct {
+    config    TelemetryConfig
+    client    *http.Client
+    startTime time.Time
+
+    mutex         sync.Mutex
+    spans         []*span
+    histograms    map[string]*histogram
+    exportedSpans int
+    droppedSpans  int
+    exportErrors  int
+    lastError     string
+    lastExportAt  time.Time
+}
+
+var (
+    telemetry      *telemetryExporter
+    telemetryMutex sync.RWMutex
+)
+
+// SetTelemetryConfig configures the OpenTelemetry exporter. Export starts
+// when the server runs.
+func SetTelemetryConfig(tc TelemetryConfig) error {
+    if err := tc.validate(); err != nil {
+        return err
+    }
+    telemetryMutex.Lock()
+    defer telemetryMutex.Unlock()
+    telemetry = &telemetryExporter{
+        config:     tc,
+        client:     &http.Client{Timeout: telemetryExportTimeout},
+        startTime:  time.Now(),
+        histograms: make(map[string]*histogram),
+    }
+    return nil
+}
+
+// currentTelemetry returns the telemetry exporter or nil if it is not configured
+func currentTelemetry() *telemetryExporter {
+    telemetryMutex.RLock()
+    defer telemetryMutex.RUnlock()
+    return telemetry
+}
+
+// startTelemetry instruments the simulations and starts the export if
+// telemetry is configured.
+func startTelemetry() {
+    t := currentTelemetry()
+    if t == nil {
+        return
+    }
+    go t.run()
+}
+
+// startSpan starts a span with the given name and kind. If traceparent is a
+// valid W3C traceparent header value, the span is its child. The span is
+// nil if telemetry is not configured.
+func startSpan(name string, kind int, traceparent string) *span {
+    t := currentTelemetry()
+    if t == nil {
+        return nil
+    }
+    s := &span{name: name, kind: kind, start: time.Now()}
+    if traceID, parentID, sampled, ok := parseTraceparent(traceparent); ok {
+        s.traceID, s.parentID, s.sampled = traceID, parentID, sampled
+    } else {
+        _, _ = rand.Read(s.traceID[:])
+        s.sampled = mrand.Float64() < t.config.SampleRatio
+    }
+    _, _ = rand.Read(s.spanID[:])
+    return s
+}
+
+// endSpan ends the given span, records its duration in the given metric
+// with the given metric attributes, and queues it for export if it is sampled.
+func endSpan(s *span, metric string, metricAttrs ...attribute) {
+    t := currentTelemetry()
+    if s == nil || t == nil {
+        return
+    }
+    s.end = time.Now()
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    t.recordLocked(metric, s.end.Sub(s.start), metricAttrs)
+    if !s.sampled {
+        return
+    }
+    if len(t.spans) >= maxTelemetrySpans {
+        t.droppedSpans++
+        return
+    }
+    t.spans = append(t.spans, s)
+}
+
+// recordLocked adds d to the histogram of the given metric and attributes.
+// It must be called with the mutex held.
+func (t *telemetryExporter) recordLocked(metric string, d time.Duration, attrs []attribute) {
+    key := metric
+    for _, a := range attrs {
+        key += fmt.Sprintf("|%s=%v", a.key, a.value)
+    }
+    h, ok := t.histograms[key]
+    if !ok {
+        h = &histogram{name: metric, attributes: attrs, counts: make([]uint64, len(durationBounds)+1)}
+        t.histograms[key] = h
+    }
+    h.record(d.Seconds())
+}
+
+// simulationTracer reports the steps and suggestion computations of the
+// simulations as spans.
+type simulationTracer struct{}
+
+// StartOperation starts a span for the given operation
+func (simulationTracer) StartOperation(sim *simulation.Simulation, name string) func() {
+    simID := simulationIDOf(sim)
+    s := startSpan(name, spanKindInternal, "")
+    if s == nil {
+        return func() {}
+    }
+    if sim.IsFastForwarding() {
+        // Fast-forwarding runs thousands of steps per second: they are
+        // only measured in the metrics.
+        s.sampled = false
+    }
+    s.setAttributes(attribute{"ts2.simulation", simID})
+    metric := metricStepDuration
+    if name == simulation.OperationSuggestions {
+        metric = metricSuggestionsDuration
+    }
+    return func() {
+        s.setAttributes(attribute{"ts2.sim_time", sim.FormatTime(sim.Options.CurrentTime.Time)})
+        endSpan(s, metric, attribute{"ts2.simulation", simID})
+    }
+}
+
+// simulationIDOf returns the ID of the hosted simulation sim, or an empty string
+func simulationIDOf(sim *simulation.Simulation) string {
+    for _, h := range simulations.list() {
+        if h.sim == sim {
+            return h.id
+        }
+    }
+    return ""
+}
+
+// traceHTTP records a span and the duration of each request served by mux.
+// Routes are the patterns of mux, e.g. /api/trains/, so that the metrics do
+// not depend on object IDs.
+func traceHTTP(mux *http.ServeMux) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer timings.since(operationHTTP, time.Now())
+        if currentTelemetry() == nil {
+            mux.ServeHTTP(w, r)
+            return
+        }
+        _, route := mux.Handler(r)
+        s := startSpan(r.Method+" "+route, spanKindServer, r.Header.Get(traceparentHeader))
+        sr := &statusRecorder{ResponseWriter: w}
+        mux.ServeHTTP(sr, r)
+        if sr.status == 0 {
+            sr.status = http.StatusOK
+        }
+        s.setAttributes(
+            attribute{"http.request.method", r.Method},
+            attribute{"http.route", route},
+            attribute{"url.path", r.URL.Path},
+            attribute{"http.response.status_code", sr.status},
+        )
+        if sr.status >= 500 {
+            s.setError(http.StatusText(sr.status))
+        }
+        endSpan(s, metricHTTPDuration,
+            attribute{"http.request.method", r.Method},
+            attribute{"http.route", route},
+            attribute{"http.response.status_code", sr.status},
+        )
+    })
+}
+
+// traceHubRequest starts the span of a websocket request. The returned
+// function ends it with the response sent to the client, or with nil if the
+// request was cancelled because the connection closed.
+func (h *Hub) traceHubRequest(req Request) func(resp interface{}) {
+    start := time.Now()
+    s := startSpan("hub "+req.Object+"/"+req.Action, spanKindServer, "")
+    if s == nil {
+        return func(interface{}) {
+            timings.since(operationHubRequest, start)
+        }
+    }
+    return func(resp interface{}) {
+        timings.since(operationHubRequest, start)
+        status := string(Ok)
+        if resp == nil {
+            status = "CANCELLED"
+            s.setError("connection closed")
+        }
+        if rs, ok := resp.(*ResponseStatus); ok {
+            status = string(rs.Data.Status)
+            if rs.Data.Status != Ok {
+                s.setError(rs.Data.Message)
+            }
+        }
+        s.setAttributes(
+            attribute{"ts2.simulation", h.id},
+            attribute{"ts2.object", req.Object},
+            attribute{"ts2.action", req.Action},
+            attribute{"ts2.request.id", req.ID},
+            attribute{"ts2.status", status},
+        )
+        endSpan(s, metricHubDuration,
+            attribute{"ts2.object", req.Object},
+            attribute{"ts2.action", req.Action},
+            attribute{"ts2.status", status},
+        )
+    }
+}
+
+// resource returns the OTLP JSON resource of the server
+func (t *telemetryExporter) resource() map[string]interface{} {
+    return map[string]interface{}{
+        "attributes": otlpAttributes([]attribute{
+            {"service.name", t.config.ServiceName},
+            {"service.version", simulation.Version},
+            {"telemetry.sdk.language", "go"},
+        }),
+    }
+}
+
+// scope returns the OTLP JSON instrumentation scope of the server
+func (t *telemetryExporter) scope() map[string]interface{} {
+    return map[string]interface{}{"name": telemetryScopeName, "version": simulation.Version}
+}
+
+// run exports the spans and the metrics periodically
+func (t *telemetryExporter) run() {
+    spanTicker := time.NewTicker(telemetrySpanInterval)
+    metricsTicker := time.NewTicker(t.config.MetricsInterval)
+    for {
+        select {
+        case <-spanTicker.C:
+            t.exportSpans()
+        case <-metricsTicker.C:
+            t.exportMetrics()
+        }
+    }
+}
+
+// exportSpans sends the waiting spans. Spans that cannot be sent are dropped.
+func (t *telemetryExporter) exportSpans() {
+    for {
+        t.mutex.Lock()
+        n := len(t.spans)
+        if n > telemetryBatchSize {
+            n = telemetryBatchSize
+        }
+        batch := t.spans[:n]
+        t.spans = t.spans[n:]
+        t.mutex.Unlock()
+        if n == 0 {
+            return
+        }
+        spans := make([]map[string]interface{}, n)
+        for i, s := range batch {
+            spans[i] = s.otlp()
+        }
+        err := t.post("/v1/traces", map[string]interface{}{
+            "resourceSpans": []map[string]interface{}{{
+                "resource":   t.resource(),
+                "scopeSpans": []map[string]interface{}{{"scope": t.scope(), "spans": spans}},
+            }},
+        })
+        t.mutex.Lock()
+        if err != nil {
+            t.droppedSpans += n
+        } else {
+            t.exportedSpans += n
+        }
+        t.mutex.Unlock()
+        if err != nil {
+            return
+        }
+    }
+}
+
+// exportMetrics sends the current value of all histograms
+func (t *telemetryExporter) exportMetrics() {
+    now := time.Now()
+    byName := make(map[string][]map[string]interface{})
+    t.mutex.Lock()
+    for _, h := range t.histograms {
+        byName[h.name] = append(byName[h.name], h.otlp(t.startTime, now))
+    }
+    t.mutex.Unlock()
+    if len(byName) == 0 {
+        return
+    }
+    names := make([]string, 0, len(byName))
+    for name := range byName {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    metrics := make([]map[string]interface{}, len(names))
+    for i, name := range names {
+        metrics[i] = map[string]interface{}{
+            "name":        name,
+            "description": metricDescriptions[name],
+            "unit":        "s",
+            "histogram": map[string]interface{}{
+                "aggregationTemporality": 2,
+                "dataPoints":             byName[name],
+            },
+        }
+    }
+    _ = t.post("/v1/metrics", map[string]interface{}{
+        "resourceMetrics": []map[string]interface{}{{
+            "resource":     t.resource(),
+            "scopeMetrics": []map[string]interface{}{{"scope": t.scope(), "metrics": metrics}},
+        }},
+    })
+}
+
+// send posts the given OTLP JSON payload to the given path of the endpoint
+func (t *telemetryExporter) send(path string, payload interface{}) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(t.config.Endpoint, "/")+path, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for k, v := range t.config.Headers {
+        req.Header.Set(k, v)
+    }
+    resp, err := t.client.Do(req)
+    if err != nil {
+        return err
+    }
+    _, _ = io.Copy(ioutil.Discard, resp.Body)
+    _ = resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return nil
+}
+
+// post sends the given OTLP JSON payload and records the outcome
+func (t *telemetryExporter) post(path string, payload interface{}) error {
+    err := t.send(path, payload)
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if err != nil {
+        t.exportErrors++
+        t.lastError = err.Error()
+        logger.Warn("OTLP export failed", "submodule", "telemetry", "path", path, "error", err)
+        return err
+    }
+    t.lastError = ""
+    t.lastExportAt = time.Now().UTC()
+    return nil
+}
+
+// view returns the JSON representation of this exporter, without its headers
+func (t *telemetryExporter) view() map[string]interface{} {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    lastExport := ""
+    if !t.lastExportAt.IsZero() {
+        lastExport = t.lastExportAt.Format(time.RFC3339)
+    }
+    return map[string]interface{}{
+        "enabled":                true,
+        "endpoint":               t.config.Endpoint,
+        "serviceName":            t.config.ServiceName,
+        "sampleRatio":            t.config.SampleRatio,
+        "metricsIntervalSeconds": t.config.MetricsInterval.Seconds(),
+        "stats": map[string]interface{}{
+            "pendingSpans":  len(t.spans),
+            "exportedSpans": t.exportedSpans,
+            "droppedSpans":  t.droppedSpans,
+            "histograms":    len(t.histograms),
+            "exportErrors":  t.exportErrors,
+            "lastError":     t.lastError,
+            "lastExportAt":  lastExport,
+        },
+    }
+}
+
+// GET /api/telemetry
+// Returns the configuration and the statistics of the OpenTelemetry exporter.
+func serveTelemetry(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    res := map[string]interface{}{"enabled": false}
+    if t := currentTelemetry(); t != nil {
+        res = t.view()
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// otlpTestCollector records the OTLP payloads it receives by path
+type otlpTestCollector struct {
+	mutex    sync.Mutex
+	payloads map[string][]map[string]interface{}
+	headers  http.Header
+}
+
+func (oc *otlpTestCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]interface{}
+	data, _ := ioutil.ReadAll(r.Body)
+	_ = json.Unmarshal(data, &payload)
+	oc.mutex.Lock()
+	oc.payloads[r.URL.Path] = append(oc.payloads[r.URL.Path], payload)
+	oc.headers = r.Header
+	oc.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// otlpItems returns the spans or metrics of all received payloads of the given kind
+func (oc *otlpTestCollector) otlpItems(path, resources, scopes, items string) map[string]map[string]interface{} {
+	oc.mutex.Lock()
+	defer oc.mutex.Unlock()
+	res := make(map[string]map[string]interface{})
+	for _, p := range oc.payloads[path] {
+		for _, r := range p[resources].([]interface{}) {
+			for _, s := range r.(map[string]interface{})[scopes].([]interface{}) {
+				for _, it := range s.(map[string]interface{})[items].([]interface{}) {
+					item := it.(map[string]interface{})
+					res[item["name"].(string)] = item
+				}
+			}
+		}
+	}
+	return res
+}
+
+// otlpAttribute returns the value of the attribute with the given key
+func otlpAttribute(item map[string]interface{}, key string) interface{} {
+	for _, a := range item["attributes"].([]interface{}) {
+		attr := a.(map[string]interface{})
+		if attr["key"] == key {
+			for _, v := range attr["value"].(map[string]interface{}) {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func TestTelemetry(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing OpenTelemetry export", t, func() {
+		Convey("traceparent headers should be parsed", func() {
+			traceID, parentID, sampled, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			So(ok, ShouldBeTrue)
+			So(sampled, ShouldBeTrue)
+			So(traceID[0], ShouldEqual, 0x4b)
+			So(parentID[7], ShouldEqual, 0xb7)
+			_, _, _, ok = parseTraceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+			So(ok, ShouldBeFalse)
+			_, _, _, ok = parseTraceparent("garbage")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Configuration should be validated", func() {
+			tc := DefaultTelemetryConfig()
+			So(SetTelemetryConfig(tc), ShouldNotBeNil)
+			tc.Endpoint = "http://localhost:4318"
+			tc.SampleRatio = 2
+			So(SetTelemetryConfig(tc), ShouldNotBeNil)
+			_, err := ParseOTLPHeaders("api-key")
+			So(err, ShouldNotBeNil)
+			headers, err := ParseOTLPHeaders("api-key=secret, x-tenant=ts2")
+			So(err, ShouldBeNil)
+			So(headers, ShouldResemble, map[string]string{"api-key": "secret", "x-tenant": "ts2"})
+			So(currentTelemetry(), ShouldBeNil)
+		})
+		Convey("Spans and metrics should be exported with OTLP", func() {
+			collector := &otlpTestCollector{payloads: make(map[string][]map[string]interface{})}
+			srv := httptest.NewServer(collector)
+			defer srv.Close()
+			tc := DefaultTelemetryConfig()
+			tc.Endpoint = srv.URL
+			tc.Headers = map[string]string{"Api-Key": "secret"}
+			So(SetTelemetryConfig(tc), ShouldBeNil)
+			defer func() {
+				telemetryMutex.Lock()
+				telemetry = nil
+				telemetryMutex.Unlock()
+			}()
+
+			req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:22222/api/v1/trains", nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			done := hub.traceHubRequest(Request{ID: 3, Object: "train", Action: "proceed"})
+			done(NewErrorResponse(3, errors.New("unknown train")))
+			simulationTracer{}.StartOperation(hub.sim, simulation.OperationStep)()
+
+			tel := currentTelemetry()
+			tel.exportSpans()
+			tel.exportMetrics()
+			So(collector.headers.Get("Api-Key"), ShouldEqual, "secret")
+			spans := collector.otlpItems("/v1/traces", "resourceSpans", "scopeSpans", "spans")
+			So(spans, ShouldContainKey, "GET /api/trains")
+			httpSpan := spans["GET /api/trains"]
+			So(httpSpan["traceId"], ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(httpSpan["parentSpanId"], ShouldEqual, "00f067aa0ba902b7")
+			So(httpSpan["kind"], ShouldEqual, spanKindServer)
+			So(otlpAttribute(httpSpan, "http.route"), ShouldEqual, "/api/trains")
+			So(otlpAttribute(httpSpan, "http.response.status_code"), ShouldEqual, "200")
+			So(spans, ShouldContainKey, "hub train/proceed")
+			So(spans["hub train/proceed"]["status"].(map[string]interface{})["code"], ShouldEqual, spanStatusError)
+			So(otlpAttribute(spans["hub train/proceed"], "ts2.status"), ShouldEqual, "FAIL")
+			So(spans, ShouldContainKey, simulation.OperationStep)
+			So(otlpAttribute(spans[simulation.OperationStep], "ts2.simulation"), ShouldEqual, "default")
+
+			metrics := collector.otlpItems("/v1/metrics", "resourceMetrics", "scopeMetrics", "metrics")
+			So(metrics, ShouldContainKey, metricHTTPDuration)
+			So(metrics, ShouldContainKey, metricHubDuration)
+			So(metrics, ShouldContainKey, metricStepDuration)
+			hist := metrics[metricHubDuration]["histogram"].(map[string]interface{})
+			So(hist["aggregationTemporality"], ShouldEqual, 2)
+			point := hist["dataPoints"].([]interface{})[0].(map[string]interface{})
+			So(point["count"], ShouldEqual, "1")
+			So(point["bucketCounts"], ShouldHaveLength, len(durationBounds)+1)
+
+			So(tel.view()["stats"].(map[string]interface{})["exportedSpans"], ShouldBeGreaterThanOrEqualTo, 3)
+		})
+	})
+}
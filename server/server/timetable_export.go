@@ -0,0 +1,268 @@
+package server
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// simDayStart is the start of the first day of simulations
+var simDayStart = simulation.ParseTime("00:00:00").Time
+
+// icsWeekdays are the iCalendar names of the days of service calendars
+var icsWeekdays = map[string]string{
+    "MON": "MO", "TUE": "TU", "WED": "WE", "THU": "TH", "FRI": "FR", "SAT": "SA", "SUN": "SU",
+}
+
+// A timetableRow is a line of a service in a timetable export
+type timetableRow struct {
+    service *simulation.Service
+    index   int
+    line    *simulation.ServiceLine
+}
+
+// time returns the first scheduled time of the row, its arrival if any
+func (tr timetableRow) time() time.Time {
+    if !tr.line.ScheduledArrivalTime.IsZero() {
+        return tr.line.ScheduledArrivalTime.Time
+    }
+    return tr.line.ScheduledDepartureTime.Time
+}
+
+// timetableRows returns the lines of the services of s, of the service with
+// the given code only if it is not empty, and at the given place only if it
+// is not empty. Lines of a place are sorted by time, and other lines by
+// service and order in the service.
+func timetableRows(s *simulation.Simulation, serviceCode, placeCode string) []timetableRow {
+    var rows []timetableRow
+    for code, srv := range s.Services {
+        if serviceCode != "" && code != serviceCode {
+            continue
+        }
+        for i, line := range srv.Lines {
+            if placeCode != "" && line.PlaceCode != placeCode {
+                continue
+            }
+            rows = append(rows, timetableRow{service: srv, index: i, line: line})
+        }
+    }
+    sort.Slice(rows, func(i, j int) bool {
+        if placeCode != "" && !rows[i].time().Equal(rows[j].time()) {
+            return rows[i].time().Before(rows[j].time())
+        }
+        if rows[i].service.ID() != rows[j].service.ID() {
+            return rows[i].service.ID() < rows[j].service.ID()
+        }
+        return rows[i].index < rows[j].index
+    })
+    return rows
+}
+
+// placeName returns the name of the place with the given code of s
+func placeName(s *simulation.Simulation, code string) string {
+    if pl, ok := s.Places[code]; ok {
+        return pl.Name()
+    }
+    return code
+}
+
+// timetableCSV returns rows of s as CSV, with a header line
+func timetableCSV(s *simulation.Simulation, rows []timetableRow) []byte {
+    var buf bytes.Buffer
+    cw := csv.NewWriter(&buf)
+    _ = cw.Write([]string{"service", "description", "sequence", "placeCode", "placeName", "trackCode",
+        "mustStop", "arrival", "departure", "plannedTrainType"})
+    clock := func(t time.Time) string {
+        if t.IsZero() {
+            return ""
+        }
+        return s.FormatTime(t)
+    }
+    for _, r := range rows {
+        _ = cw.Write([]string{
+            r.service.ID(),
+            r.service.Description,
+            strconv.Itoa(r.index + 1),
+            r.line.PlaceCode,
+            placeName(s, r.line.PlaceCode),
+            r.line.TrackCode,
+            strconv.FormatBool(r.line.MustStop),
+            clock(r.line.ScheduledArrivalTime.Time),
+            clock(r.line.ScheduledDepartureTime.Time),
+            r.service.PlannedTrainTypeCode,
+        })
+    }
+    cw.Flush()
+    return buf.Bytes()
+}
+
+// icsEscape escapes the special characters of iCalendar text values
+func icsEscape(s string) string {
+    return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icsFold writes the content line to buf, folded at 75 octets as required by
+// RFC 5545.
+func icsFold(buf *bytes.Buffer, line string) {
+    for len(line) > 75 {
+        cut := 75
+        // Do not split UTF-8 sequences
+        for cut > 0 && line[cut]&0xC0 == 0x80 {
+            cut--
+        }
+        buf.WriteString(line[:cut] + "\r\n ")
+        line = line[cut:]
+    }
+    buf.WriteString(line + "\r\n")
+}
+
+// firstRunDate returns the first date from date on which the service with
+// the given calendar runs, or false if it does not run within a year.
+func firstRunDate(c *simulation.ServiceCalendar, date time.Time) (time.Time, bool) {
+    for i := 0; i < 366; i++ {
+        if c.RunsOn(date) {
+            return date, true
+        }
+        date = date.AddDate(0, 0, 1)
+    }
+    return time.Time{}, false
+}
+
+// icsRecurrence returns the RRULE and EXDATE properties of a service with the
+// given calendar whose event starts at the time of day of start.
+func icsRecurrence(c *simulation.ServiceCalendar, start time.Time) []string {
+    if c == nil {
+        return []string{"RRULE:FREQ=DAILY"}
+    }
+    rule := "RRULE:FREQ=DAILY"
+    if len(c.Days) > 0 {
+        days := make([]string, len(c.Days))
+        for i, d := range c.Days {
+            days[i] = icsWeekdays[strings.ToUpper(d)]
+        }
+        rule = "RRULE:FREQ=WEEKLY;BYDAY=" + strings.Join(days, ",")
+    }
+    if c.ValidUntil != "" {
+        rule += ";UNTIL=" + c.ValidUntil.Time().Format("20060102") + "T235959"
+    }
+    props := []string{rule}
+    for _, d := range c.ExceptDates {
+        dt := d.Time().Add(start.Sub(start.Truncate(24 * time.Hour)))
+        props = append(props, "EXDATE:"+dt.Format("20060102T150405"))
+    }
+    return props
+}
+
+// timetableICS returns rows of s as an iCalendar file with an event for each
+// call. Events repeat on the days of the calendar of their service, from the
+// start date of the simulation, or from today if it has none. Times are local
+// times.
+func timetableICS(s *simulation.Simulation, rows []timetableRow, now time.Time) []byte {
+    base := s.Options.StartDate.Time()
+    if base.IsZero() {
+        base = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    }
+    var buf bytes.Buffer
+    for _, l := range []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//TS2//ts2-sim-server//EN",
+        "CALSCALE:GREGORIAN", "X-WR-CALNAME:" + icsEscape(s.Options.Title)} {
+        icsFold(&buf, l)
+    }
+    for _, r := range rows {
+        if r.time().IsZero() {
+            continue
+        }
+        c := r.service.Calendar
+        date := base
+        if c != nil && c.ValidFrom != "" && c.ValidFrom.Time().After(date) {
+            date = c.ValidFrom.Time()
+        }
+        date, ok := firstRunDate(c, date)
+        if !ok {
+            continue
+        }
+        start := date.Add(r.time().Sub(simDayStart))
+        end := start
+        if !r.line.ScheduledDepartureTime.IsZero() {
+            end = date.Add(r.line.ScheduledDepartureTime.Time.Sub(simDayStart))
+        }
+        place := placeName(s, r.line.PlaceCode)
+        location := place
+        if r.line.TrackCode != "" {
+            location = fmt.Sprintf("%s track %s", place, r.line.TrackCode)
+        }
+        summary := fmt.Sprintf("%s at %s", r.service.ID(), place)
+        if !r.line.MustStop {
+            summary = fmt.Sprintf("%s passes %s", r.service.ID(), place)
+        }
+        lines := []string{
+            "BEGIN:VEVENT",
+            fmt.Sprintf("UID:%s-%d@ts2-sim-server", r.service.ID(), r.index+1),
+            "DTSTAMP:" + now.UTC().Format("20060102T150405Z"),
+            "DTSTART:" + start.Format("20060102T150405"),
+            "DTEND:" + end.Format("20060102T150405"),
+            "SUMMARY:" + icsEscape(summary),
+            "LOCATION:" + icsEscape(location),
+            "DESCRIPTION:" + icsEscape(r.service.Description),
+        }
+        lines = append(lines, icsRecurrence(c, start)...)
+        lines = append(lines, "END:VEVENT")
+        for _, l := range lines {
+            icsFold(&buf, l)
+        }
+    }
+    icsFold(&buf, "END:VCALENDAR")
+    return buf.Bytes()
+}
+
+// GET /api/timetable/export?format=csv|ics&service={code}&place={code}
+//
+// Exports the timetable, or the lines of a service or the calls at a place,
+// as CSV or iCalendar.
+func serveTimetableExport(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    q := r.URL.Query()
+    serviceCode, placeCode := q.Get("service"), q.Get("place")
+    if _, ok := h.sim.Services[serviceCode]; serviceCode != "" && !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeServiceNotFound, "Service not found", map[string]interface{}{"serviceId": serviceCode})
+        return
+    }
+    if _, ok := h.sim.Places[placeCode]; placeCode != "" && !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodePlaceNotFound, "Place not found", map[string]interface{}{"placeCode": placeCode})
+        return
+    }
+    rows := timetableRows(h.sim, serviceCode, placeCode)
+    name := "timetable"
+    if serviceCode != "" {
+        name += "-" + serviceCode
+    }
+    if placeCode != "" {
+        name += "-" + placeCode
+    }
+    switch format := q.Get("format"); format {
+    case "", "csv":
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+        _, _ = w.Write(timetableCSV(h.sim, rows))
+    case "ics":
+        w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ics"`, name))
+        _, _ = w.Write(timetableICS(h.sim, rows, time.Now()))
+    default:
+        invalidParameter(w, "format must be csv or ics", map[string]interface{}{"format": format})
+    }
+}
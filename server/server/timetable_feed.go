@@ -0,0 +1,575 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// TimetableUpdateEvent is sent to clients each time an update of an external
+// timetable feed is applied or rejected.
+const TimetableUpdateEvent simulation.EventName = "timetableUpdate"
+
+// Types of timetable updates
+const (
+    timetableUpdateDelay        = "DELAY"
+    timetableUpdateCancellation = "CANCELLATION"
+    timetableUpdatePlatform     = "PLATFORM"
+)
+
+// Status of applied timetable updates
+const (
+    timetableUpdateApplied   = "APPLIED"
+    timetableUpdateRejected  = "REJECTED"
+    timetableUpdateDuplicate = "DUPLICATE"
+)
+
+const (
+    // timetableFeedHistory is the number of update results kept for the API
+    timetableFeedHistory = 100
+    // timetableFeedSeenIDs is the number of update IDs remembered to skip
+    // updates that were already applied
+    timetableFeedSeenIDs = 10000
+)
+
+// A timetableUpdate is an incremental change of the timetable sent by an
+// external traffic management system.
+type timetableUpdate struct {
+    // UpdateID identifies the update, so that it is applied only once
+    UpdateID    string `json:"id,omitempty"`
+    Type        string `json:"type"`
+    ServiceCode string `json:"serviceCode"`
+    // PlaceCode and LineIndex select the line of the service. Without
+    // them, a delay applies to the next place of the train running the
+    // service and a cancellation to the whole service.
+    PlaceCode string `json:"placeCode,omitempty"`
+    LineIndex *int   `json:"lineIndex,omitempty"`
+    // DelayMinutes is the predicted delay of the departure from the line,
+    // or ExpectedDepartureTime the predicted departure time. A zero delay
+    // clears the prediction.
+    DelayMinutes          *float64 `json:"delayMinutes,omitempty"`
+    ExpectedDepartureTime string   `json:"expectedDepartureTime,omitempty"`
+    // TrackCode is the new platform of a platform change
+    TrackCode string `json:"trackCode,omitempty"`
+    Reason    string `json:"reason,omitempty"`
+}
+
+// A timetableFeed is a batch of timetable updates, as pushed to the API or
+// returned by a polled feed.
+type timetableFeed struct {
+    Source string `json:"source"`
+    // Simulation is the ID of the simulation to update, the default one if empty
+    Simulation string            `json:"simulation"`
+    Updates    []timetableUpdate `json:"updates"`
+}
+
+// A timetableUpdateResult is the outcome of a timetable update. It is the
+// object of TimetableUpdateEvent.
+type timetableUpdateResult struct {
+    timetableUpdate
+    Source     string `json:"source,omitempty"`
+    Simulation string `json:"simulation"`
+    Status     string `json:"status"`
+    Error      string `json:"error,omitempty"`
+    // AppliedLineIndex is the service line the update was applied to
+    AppliedLineIndex *int  `json:"appliedLineIndex,omitempty"`
+    // CancelledTrains are the trains cancelled by a cancellation
+    CancelledTrains []string `json:"cancelledTrains,omitempty"`
+    ReceivedAt      string   `json:"receivedAt"`
+    SimTime         string   `json:"simTime"`
+}
+
+// ID returns the code of the updated service
+func (tur timetableUpdateResult) ID() string {
+    return tur.ServiceCode
+}
+
+// runningTrain returns the train running the service with the given code
+// that has not finished it, if any.
+func runningTrain(s *simulation.Simulation, code string) *simulation.Train {
+    for _, t := range s.Trains {
+        if t.ServiceCode != code {
+            continue
+        }
+        switch t.Status {
+        case simulation.Out, simulation.EndOfService, simulation.Cancelled, simulation.Joined, simulation.Stabled:
+            continue
+        }
+        return t
+    }
+    return nil
+}
+
+// lineIndex returns the index of the service line the update applies to, or
+// -1 if it applies to the whole service.
+func (tu timetableUpdate) lineIndex(s *simulation.Simulation, srv *simulation.Service) (int, error) {
+    if tu.LineIndex != nil {
+        if *tu.LineIndex < 0 || *tu.LineIndex >= len(srv.Lines) {
+            return 0, fmt.Errorf("service %s has no line %d", tu.ServiceCode, *tu.LineIndex)
+        }
+        if tu.PlaceCode != "" && srv.Lines[*tu.LineIndex].PlaceCode != tu.PlaceCode {
+            return 0, fmt.Errorf("line %d of service %s is not at %s", *tu.LineIndex, tu.ServiceCode, tu.PlaceCode)
+        }
+        return *tu.LineIndex, nil
+    }
+    from := 0
+    t := runningTrain(s, tu.ServiceCode)
+    if t != nil && t.NextPlaceIndex != simulation.NoMorePlace {
+        from = t.NextPlaceIndex
+    }
+    if tu.PlaceCode == "" {
+        if tu.Type == timetableUpdateDelay && t != nil && t.NextPlaceIndex != simulation.NoMorePlace {
+            return t.NextPlaceIndex, nil
+        }
+        return -1, nil
+    }
+    for _, start := range []int{from, 0} {
+        for i := start; i < len(srv.Lines); i++ {
+            if srv.Lines[i].PlaceCode == tu.PlaceCode {
+                return i, nil
+            }
+        }
+    }
+    return 0, fmt.Errorf("service %s does not call at %s", tu.ServiceCode, tu.PlaceCode)
+}
+
+// apply applies the update to the simulation s
+func (tu timetableUpdate) apply(s *simulation.Simulation, res *timetableUpdateResult) error {
+    srv, ok := s.Services[tu.ServiceCode]
+    if !ok {
+        return fmt.Errorf("unknown service: %s", tu.ServiceCode)
+    }
+    index, err := tu.lineIndex(s, srv)
+    if err != nil {
+        return err
+    }
+    if index >= 0 {
+        res.AppliedLineIndex = &index
+    }
+    switch strings.ToUpper(tu.Type) {
+    case timetableUpdateDelay:
+        if index < 0 {
+            return fmt.Errorf("no line to delay: give placeCode or lineIndex")
+        }
+        var expected time.Time
+        switch {
+        case tu.ExpectedDepartureTime != "":
+            expected = simulation.ParseTime(tu.ExpectedDepartureTime).Time
+            if expected.IsZero() {
+                return fmt.Errorf("invalid expectedDepartureTime: %s", tu.ExpectedDepartureTime)
+            }
+        case tu.DelayMinutes != nil:
+            line := srv.Lines[index]
+            scheduled := line.ScheduledDepartureTime.Time
+            if scheduled.IsZero() {
+                scheduled = line.ScheduledArrivalTime.Time
+            }
+            if scheduled.IsZero() {
+                return fmt.Errorf("line %d of service %s has no scheduled time", index, tu.ServiceCode)
+            }
+            if *tu.DelayMinutes > 0 {
+                expected = scheduled.Add(time.Duration(*tu.DelayMinutes * float64(time.Minute)))
+            }
+        default:
+            return fmt.Errorf("delayMinutes or expectedDepartureTime is required")
+        }
+        return s.SetExpectedDeparture(tu.ServiceCode, index, expected)
+    case timetableUpdateCancellation:
+        if index >= 0 {
+            return s.RemoveServiceLine(tu.ServiceCode, index)
+        }
+        trains, err := s.CancelService(tu.ServiceCode)
+        for _, t := range trains {
+            res.CancelledTrains = append(res.CancelledTrains, t.ID())
+        }
+        return err
+    case timetableUpdatePlatform:
+        if index < 0 {
+            return fmt.Errorf("no line to change: give placeCode or lineIndex")
+        }
+        if tu.TrackCode == "" {
+            return fmt.Errorf("trackCode is required")
+        }
+        return s.AssignPlatform(tu.ServiceCode, index, tu.TrackCode)
+    default:
+        return fmt.Errorf("unknown update type: %s", tu.Type)
+    }
+}
+
+// timetableFeedState holds the IDs of the applied updates and the last
+// results, for all simulations.
+type timetableFeedState struct {
+    mutex    sync.Mutex
+    seen     map[string]bool
+    seenIDs  []string
+    results  []timetableUpdateResult
+    applied  int
+    rejected int
+    skipped  int
+}
+
+var timetableUpdates = &timetableFeedState{seen: make(map[string]bool)}
+
+// markSeen returns false if the update with the given key was already
+// applied, and remembers it otherwise.
+func (tfs *timetableFeedState) markSeen(key string) bool {
+    tfs.mutex.Lock()
+    defer tfs.mutex.Unlock()
+    if tfs.seen[key] {
+        tfs.skipped++
+        return false
+    }
+    tfs.seen[key] = true
+    tfs.seenIDs = append(tfs.seenIDs, key)
+    if len(tfs.seenIDs) > timetableFeedSeenIDs {
+        delete(tfs.seen, tfs.seenIDs[0])
+        tfs.seenIDs = tfs.seenIDs[1:]
+    }
+    return true
+}
+
+// forget removes the given key, so that a rejected update can be sent again
+func (tfs *timetableFeedState) forget(key string) {
+    tfs.mutex.Lock()
+    defer tfs.mutex.Unlock()
+    delete(tfs.seen, key)
+}
+
+// record keeps the given result for the API
+func (tfs *timetableFeedState) record(res timetableUpdateResult) {
+    tfs.mutex.Lock()
+    defer tfs.mutex.Unlock()
+    if res.Status == timetableUpdateApplied {
+        tfs.applied++
+    } else {
+        tfs.rejected++
+    }
+    tfs.results = append(tfs.results, res)
+    if len(tfs.results) > timetableFeedHistory {
+        tfs.results = tfs.results[len(tfs.results)-timetableFeedHistory:]
+    }
+}
+
+// applyTimetableFeed applies the updates of the feed to the simulation of h,
+// in order, and returns their results. Updates whose ID was already applied
+// are skipped. Each applied or rejected update is recorded in the audit log
+// and sent to clients as a TimetableUpdateEvent.
+func (h *Hub) applyTimetableFeed(feed timetableFeed) []timetableUpdateResult {
+    results := make([]timetableUpdateResult, 0, len(feed.Updates))
+    for _, tu := range feed.Updates {
+        tu.Type = strings.ToUpper(tu.Type)
+        res := timetableUpdateResult{
+            timetableUpdate: tu,
+            Source:          feed.Source,
+            Simulation:      h.id,
+            ReceivedAt:      time.Now().UTC().Format(time.RFC3339),
+            SimTime:         h.sim.FormatTime(h.sim.Options.CurrentTime.Time),
+        }
+        key := h.id + "/" + feed.Source + "/" + tu.UpdateID
+        if tu.UpdateID != "" && !timetableUpdates.markSeen(key) {
+            res.Status = timetableUpdateDuplicate
+            results = append(results, res)
+            continue
+        }
+        res.Status = timetableUpdateApplied
+        if err := tu.apply(h.sim, &res); err != nil {
+            res.Status = timetableUpdateRejected
+            res.Error = err.Error()
+            if tu.UpdateID != "" {
+                timetableUpdates.forget(key)
+            }
+        }
+        timetableUpdates.record(res)
+        h.auditTimetableUpdate(res)
+        select {
+        case h.events <- &simulation.Event{Name: TimetableUpdateEvent, Object: res}:
+        case <-h.done:
+        }
+        results = append(results, res)
+    }
+    return results
+}
+
+// auditTimetableUpdate records the result of a timetable update in the audit log
+func (h *Hub) auditTimetableUpdate(res timetableUpdateResult) {
+    event, severity := "TIMETABLE_UPDATE_APPLIED", "INFO"
+    if res.Status == timetableUpdateRejected {
+        event, severity = "TIMETABLE_UPDATE_REJECTED", "WARNING"
+    }
+    details := map[string]interface{}{
+        "type":   res.Type,
+        "source": res.Source,
+    }
+    if res.PlaceCode != "" {
+        details["placeCode"] = res.PlaceCode
+    }
+    if res.AppliedLineIndex != nil {
+        details["lineIndex"] = *res.AppliedLineIndex
+    }
+    if res.Reason != "" {
+        details["reason"] = res.Reason
+    }
+    if res.Error != "" {
+        details["error"] = res.Error
+    }
+    h.audits.append(AuditEntry{
+        SimTime:  res.SimTime,
+        Event:    event,
+        Category: "train",
+        Severity: severity,
+        Object:   map[string]interface{}{"serviceCode": res.ServiceCode},
+        Details:  details,
+    })
+}
+
+// TimetableFeedConfig is the configuration of the polling of an external
+// timetable feed.
+type TimetableFeedConfig struct {
+    // URL returns the timetable updates as JSON
+    URL string
+    // Interval is the time between two polls
+    Interval time.Duration
+    // Headers are added to the requests, e.g. for authentication
+    Headers map[string]string
+}
+
+// DefaultTimetableFeedConfig returns the default timetable feed
+// configuration, without URL.
+func DefaultTimetableFeedConfig() TimetableFeedConfig {
+    return TimetableFeedConfig{Interval: 30 * time.Second}
+}
+
+// validate checks the configuration
+func (tc TimetableFeedConfig) validate() error {
+    u, err := url.Parse(tc.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("invalid timetable feed URL: %s", tc.URL)
+    }
+    if tc.Interval < time.Second {
+        return fmt.Errorf("timetable feed interval must be at least 1s")
+    }
+    return nil
+}
+
+// A timetableFeedPoller fetches the timetable updates of an external
+// traffic management system periodically and applies them.
+//
+// The feed is requested with the ETag of the previous response, so that an
+// unchanged feed is not parsed again. Updates with an ID are applied once even
+// if the feed returns them at each poll.
+type timetableFeedPoller struct {
+    config TimetableFeedConfig
+    client *http.Client
+
+    mutex      sync.Mutex
+    etag       string
+    polls      int
+    errors     int
+    lastStatus int
+    lastError  string
+    lastPollAt time.Time
+}
+
+var (
+    timetablePoller      *timetableFeedPoller
+    timetablePollerMutex sync.RWMutex
+)
+
+// SetTimetableFeedConfig configures the polling of an external timetable
+// feed. Polling starts when the server runs.
+func SetTimetableFeedConfig(tc TimetableFeedConfig) error {
+    if err := tc.validate(); err != nil {
+        return err
+    }
+    timetablePollerMutex.Lock()
+    defer timetablePollerMutex.Unlock()
+    timetablePoller = &timetableFeedPoller{config: tc, client: &http.Client{Timeout: 30 * time.Second}}
+    return nil
+}
+
+// currentTimetableFeedPoller returns the timetable feed poller or nil if it
+// is not configured
+func currentTimetableFeedPoller() *timetableFeedPoller {
+    timetablePollerMutex.RLock()
+    defer timetablePollerMutex.RUnlock()
+    return timetablePoller
+}
+
+// startTimetableFeedPoller starts polling the timetable feed if it is configured
+func startTimetableFeedPoller() {
+    if tp := currentTimetableFeedPoller(); tp != nil {
+        go tp.run()
+    }
+}
+
+// run polls the feed every interval
+func (tp *timetableFeedPoller) run() {
+    ticker := time.NewTicker(tp.config.Interval)
+    defer ticker.Stop()
+    for {
+        tp.poll()
+        <-ticker.C
+    }
+}
+
+// poll fetches the feed once and applies its updates
+func (tp *timetableFeedPoller) poll() {
+    status, err := tp.fetchAndApply()
+    tp.mutex.Lock()
+    defer tp.mutex.Unlock()
+    tp.polls++
+    tp.lastStatus = status
+    tp.lastPollAt = time.Now().UTC()
+    if err != nil {
+        tp.errors++
+        tp.lastError = err.Error()
+        logger.Warn("Unable to poll timetable feed", "submodule", "timetable", "url", tp.config.URL, "error", err)
+        return
+    }
+    tp.lastError = ""
+}
+
+// fetchAndApply fetches the feed and applies its updates. It returns the
+// HTTP status of the response.
+func (tp *timetableFeedPoller) fetchAndApply() (int, error) {
+    req, err := http.NewRequest(http.MethodGet, tp.config.URL, nil)
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Accept", "application/json")
+    for k, v := range tp.config.Headers {
+        req.Header.Set(k, v)
+    }
+    tp.mutex.Lock()
+    if tp.etag != "" {
+        req.Header.Set("If-None-Match", tp.etag)
+    }
+    tp.mutex.Unlock()
+    resp, err := tp.client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotModified {
+        return resp.StatusCode, nil
+    }
+    if resp.StatusCode != http.StatusOK {
+        return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    var feed timetableFeed
+    if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&feed); err != nil {
+        return resp.StatusCode, fmt.Errorf("unable to parse timetable feed: %s", err)
+    }
+    if feed.Source == "" {
+        feed.Source = tp.config.URL
+    }
+    h, err := timetableFeedHub(feed)
+    if err != nil {
+        return resp.StatusCode, err
+    }
+    h.applyTimetableFeed(feed)
+    tp.mutex.Lock()
+    tp.etag = resp.Header.Get("ETag")
+    tp.mutex.Unlock()
+    return resp.StatusCode, nil
+}
+
+// view returns the status of the poller for the API
+func (tp *timetableFeedPoller) view() map[string]interface{} {
+    tp.mutex.Lock()
+    defer tp.mutex.Unlock()
+    res := map[string]interface{}{
+        "url":             tp.config.URL,
+        "intervalSeconds": tp.config.Interval.Seconds(),
+        "polls":           tp.polls,
+        "errors":          tp.errors,
+        "lastStatus":      tp.lastStatus,
+        "lastError":       tp.lastError,
+    }
+    if !tp.lastPollAt.IsZero() {
+        res["lastPollAt"] = tp.lastPollAt.Format(time.RFC3339)
+    }
+    return res
+}
+
+// timetableFeedHub returns the hub of the simulation updated by the feed
+func timetableFeedHub(feed timetableFeed) (*Hub, error) {
+    id := feed.Simulation
+    if id == "" {
+        id = DefaultSimulationID
+    }
+    h, ok := simulations.get(id)
+    if !ok {
+        return nil, fmt.Errorf("unknown simulation %s", id)
+    }
+    return h, nil
+}
+
+// GET /api/timetable/updates
+// POST /api/timetable/updates
+//
+// POST applies a batch of timetable updates pushed by an external traffic
+// management system and returns their results. GET returns the last results,
+// the statistics and the status of the polled feed.
+func serveTimetableUpdates(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        limit := timetableFeedHistory
+        if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l < limit {
+            limit = l
+        }
+        timetableUpdates.mutex.Lock()
+        items := timetableUpdates.results
+        if len(items) > limit {
+            items = items[len(items)-limit:]
+        }
+        res := map[string]interface{}{
+            "items": append([]timetableUpdateResult{}, items...),
+            "stats": map[string]interface{}{
+                "applied":    timetableUpdates.applied,
+                "rejected":   timetableUpdates.rejected,
+                "duplicates": timetableUpdates.skipped,
+            },
+        }
+        timetableUpdates.mutex.Unlock()
+        res["feed"] = map[string]interface{}{"enabled": false}
+        if tp := currentTimetableFeedPoller(); tp != nil {
+            feed := tp.view()
+            feed["enabled"] = true
+            res["feed"] = feed
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(res)
+    case http.MethodPost:
+        var feed timetableFeed
+        if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&feed); err != nil {
+            badRequest(w, err)
+            return
+        }
+        if len(feed.Updates) == 0 {
+            invalidParameter(w, "No updates given", nil)
+            return
+        }
+        if feed.Simulation == "" {
+            feed.Simulation = requestHub(r).id
+        }
+        h, err := timetableFeedHub(feed)
+        if err != nil {
+            writeAPIError(w, http.StatusNotFound, ErrCodeSimulationNotFound, err.Error(), map[string]interface{}{"simulation": feed.Simulation})
+            return
+        }
+        results := h.applyTimetableFeed(feed)
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": results})
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+    "crypto/tls"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// A certificateLoader serves a TLS certificate loaded from PEM files and
+// reloads it when the files change on disk, so that certificates can be
+// renewed without restarting the server.
+type certificateLoader struct {
+    certFile       string
+    keyFile        string
+    reloadInterval time.Duration
+
+    mutex     sync.Mutex
+    cert      *tls.Certificate
+    modTime   time.Time
+    lastCheck time.Time
+}
+
+// newCertificateLoader returns a certificateLoader for the given files after
+// checking that they can be loaded. If reloadInterval is 0, the certificate
+// is never reloaded.
+func newCertificateLoader(certFile, keyFile string, reloadInterval time.Duration) (*certificateLoader, error) {
+    cl := &certificateLoader{
+        certFile:       certFile,
+        keyFile:        keyFile,
+        reloadInterval: reloadInterval,
+    }
+    if err := cl.load(); err != nil {
+        return nil, err
+    }
+    return cl, nil
+}
+
+// filesModTime returns the most recent modification time of the certificate and key files
+func (cl *certificateLoader) filesModTime() (time.Time, error) {
+    var latest time.Time
+    for _, f := range []string{cl.certFile, cl.keyFile} {
+        fi, err := os.Stat(f)
+        if err != nil {
+            return time.Time{}, err
+        }
+        if fi.ModTime().After(latest) {
+            latest = fi.ModTime()
+        }
+    }
+    return latest, nil
+}
+
+// load reads the certificate and key files. It must be called with the mutex held
+// or before the loader is shared.
+func (cl *certificateLoader) load() error {
+    modTime, err := cl.filesModTime()
+    if err != nil {
+        return fmt.Errorf("unable to read TLS files: %s", err)
+    }
+    cert, err := tls.LoadX509KeyPair(cl.certFile, cl.keyFile)
+    if err != nil {
+        return fmt.Errorf("unable to load TLS certificate: %s", err)
+    }
+    cl.cert = &cert
+    cl.modTime = modTime
+    cl.lastCheck = time.Now()
+    return nil
+}
+
+// getCertificate returns the current certificate, reloading it first if the
+// files have changed since the last check. If reloading fails, the previous
+// certificate is kept.
+//
+// It is meant to be used as tls.Config.GetCertificate.
+func (cl *certificateLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    cl.mutex.Lock()
+    defer cl.mutex.Unlock()
+    if cl.reloadInterval <= 0 || time.Since(cl.lastCheck) < cl.reloadInterval {
+        return cl.cert, nil
+    }
+    cl.lastCheck = time.Now()
+    modTime, err := cl.filesModTime()
+    if err != nil || !modTime.After(cl.modTime) {
+        return cl.cert, nil
+    }
+    if err := cl.load(); err != nil {
+        logger.Error("Unable to reload TLS certificate, keeping the previous one", "submodule", "http", "error", err)
+        return cl.cert, nil
+    }
+    logger.Info("TLS certificate reloaded", "submodule", "http", "cert", cl.certFile)
+    return cl.cert, nil
+}
+
+var tlsCertificates *certificateLoader
+
+// SetTLSConfig makes the server serve HTTPS and WSS with the certificate and
+// key in the given PEM files. If reloadInterval is positive, the files are
+// checked for changes at most once per interval and reloaded when modified.
+func SetTLSConfig(certFile, keyFile string, reloadInterval time.Duration) error {
+    if certFile == "" || keyFile == "" {
+        return fmt.Errorf("both a certificate and a key file are required for TLS")
+    }
+    cl, err := newCertificateLoader(certFile, keyFile, reloadInterval)
+    if err != nil {
+        return err
+    }
+    tlsCertificates = cl
+    return nil
+}
+
+// tlsConfig returns the TLS configuration of the server, or nil if TLS is not enabled.
+func tlsConfig() *tls.Config {
+    if tlsCertificates == nil {
+        return nil
+    }
+    return &tls.Config{
+        MinVersion:     tls.VersionTLS12,
+        GetCertificate: tlsCertificates.getCertificate,
+    }
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTestCertificate writes a self-signed certificate for the given common
+// name and its key to certFile and keyFile.
+func writeTestCertificate(certFile, keyFile, cn string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func TestCertificateLoader(t *testing.T) {
+	Convey("Testing TLS certificate loading", t, func() {
+		dir, err := ioutil.TempDir("", "ts2-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		So(writeTestCertificate(certFile, keyFile, "first"), ShouldBeNil)
+		Convey("Missing or invalid files should fail", func() {
+			_, err := newCertificateLoader(filepath.Join(dir, "none.pem"), keyFile, 0)
+			So(err, ShouldNotBeNil)
+			_, err = newCertificateLoader(keyFile, certFile, 0)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Modified files should be reloaded", func() {
+			cl, err := newCertificateLoader(certFile, keyFile, time.Millisecond)
+			So(err, ShouldBeNil)
+			cert, err := cl.getCertificate(nil)
+			So(err, ShouldBeNil)
+			leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+			So(leaf.Subject.CommonName, ShouldEqual, "first")
+			So(writeTestCertificate(certFile, keyFile, "second"), ShouldBeNil)
+			future := time.Now().Add(time.Minute)
+			So(os.Chtimes(certFile, future, future), ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+			cert, err = cl.getCertificate(nil)
+			So(err, ShouldBeNil)
+			leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+			So(leaf.Subject.CommonName, ShouldEqual, "second")
+		})
+	})
+}
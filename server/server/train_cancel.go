@@ -0,0 +1,35 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+)
+
+// POST /api/trains/{trainId}/cancel
+//
+// Cancels the train: it is removed from the area and the routes it held are
+// released.
+func serveTrainCancel(w http.ResponseWriter, r *http.Request, trainID string) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    tid, err := strconv.Atoi(trainID)
+    if err != nil || tid < 0 || tid >= len(h.sim.Trains) {
+        writeAPIError(w, http.StatusNotFound, ErrCodeTrainNotFound, "Train not found", map[string]interface{}{"trainId": trainID})
+        return
+    }
+    t := h.sim.Trains[tid]
+    if err := t.Cancel(); err != nil {
+        writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"trainId": trainID})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "trainId": trainID, "serviceCode": t.ServiceCode})
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A trainDelayRequest artificially delays a train, either by holding it for
+// some minutes or by reducing its performance for some time, or both.
+type trainDelayRequest struct {
+    HoldMinutes       float64 `json:"holdMinutes"`
+    PerformanceFactor float64 `json:"performanceFactor"`
+    DurationMinutes   float64 `json:"durationMinutes"`
+    Reason            string  `json:"reason"`
+}
+
+// validate checks that the request makes sense
+func (tdr trainDelayRequest) validate() error {
+    if tdr.HoldMinutes < 0 || tdr.DurationMinutes < 0 {
+        return fmt.Errorf("durations cannot be negative")
+    }
+    if tdr.PerformanceFactor < 0 || tdr.PerformanceFactor > 1 {
+        return fmt.Errorf("performanceFactor must be between 0 and 1")
+    }
+    if tdr.PerformanceFactor > 0 && tdr.PerformanceFactor < 1 && tdr.DurationMinutes == 0 {
+        return fmt.Errorf("durationMinutes is required with performanceFactor")
+    }
+    if tdr.HoldMinutes == 0 && (tdr.PerformanceFactor == 0 || tdr.PerformanceFactor == 1) {
+        return fmt.Errorf("nothing to do: give holdMinutes and/or performanceFactor")
+    }
+    return nil
+}
+
+// trainDelayState returns the injected delay state of the given train of s
+func trainDelayState(s *simulation.Simulation, id string, t *simulation.Train) map[string]interface{} {
+    res := map[string]interface{}{
+        "trainId":       id,
+        "serviceCode":   t.ServiceCode,
+        "held":          t.IsHeld(),
+        "heldUntil":     "",
+        "performance":   t.Performance(),
+        "degradedUntil": "",
+    }
+    if t.IsHeld() {
+        res["heldUntil"] = s.FormatTime(t.HeldUntil().Time)
+    }
+    if t.Performance() < 1 {
+        res["degradedUntil"] = s.FormatTime(t.DegradedUntil())
+    }
+    return res
+}
+
+// auditTrainDelay records an injected (or cleared) train delay in the audit log
+// of the simulation of h
+func (h *Hub) auditTrainDelay(event, id string, details map[string]interface{}) {
+    h.audits.append(AuditEntry{
+        Event:    event,
+        Category: "train",
+        Severity: "INFO",
+        Object:   map[string]interface{}{"id": id},
+        Details:  details,
+    })
+}
+
+// POST /api/trains/{trainId}/delay
+// DELETE /api/trains/{trainId}/delay
+//
+// POST holds the train for holdMinutes and/or reduces its acceleration and
+// maximum speed to performanceFactor for durationMinutes. DELETE releases the
+// train and restores its nominal performance.
+func serveTrainDelay(w http.ResponseWriter, r *http.Request, trainID string) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    tid, err := strconv.Atoi(trainID)
+    if err != nil || tid < 0 || tid >= len(h.sim.Trains) {
+        writeAPIError(w, http.StatusNotFound, ErrCodeTrainNotFound, "Train not found", map[string]interface{}{"trainId": trainID})
+        return
+    }
+    t := h.sim.Trains[tid]
+    switch r.Method {
+    case http.MethodPost:
+        var body trainDelayRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        if err := body.validate(); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        if body.HoldMinutes > 0 {
+            t.Hold(time.Duration(body.HoldMinutes * float64(time.Minute)))
+        }
+        if body.PerformanceFactor > 0 && body.PerformanceFactor < 1 {
+            t.Degrade(body.PerformanceFactor, time.Duration(body.DurationMinutes*float64(time.Minute)))
+        }
+        h.auditTrainDelay("TRAIN_DELAY_INJECTED", trainID, map[string]interface{}{
+            "holdMinutes":       body.HoldMinutes,
+            "performanceFactor": body.PerformanceFactor,
+            "durationMinutes":   body.DurationMinutes,
+            "reason":            body.Reason,
+        })
+    case http.MethodDelete:
+        t.Hold(0)
+        t.Degrade(1, 0)
+        h.auditTrainDelay("TRAIN_DELAY_CLEARED", trainID, map[string]interface{}{})
+    default:
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(trainDelayState(h.sim, trainID, t))
+}
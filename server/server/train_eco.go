@@ -0,0 +1,72 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// ecoAdvisoryJSON returns the eco-driving advisory of the given train for the
+// API, or nil if the train is not running to a scheduled stop.
+func ecoAdvisoryJSON(s *simulation.Simulation, t *simulation.Train) map[string]interface{} {
+    ea, ok := t.EcoAdvisory()
+    if !ok {
+        return nil
+    }
+    profile := make([]map[string]interface{}, len(ea.Profile))
+    for i, p := range ea.Profile {
+        profile[i] = map[string]interface{}{
+            "trackItemId":      p.TrackItem.ID(),
+            "distanceM":        p.Distance,
+            "advisorySpeedKmh": p.Speed * 3.6,
+        }
+    }
+    return map[string]interface{}{
+        "placeCode":        ea.PlaceCode,
+        "distanceM":        ea.Distance,
+        "scheduledArrival": s.FormatTime(ea.ScheduledArrival),
+        "slackSeconds":     int(ea.Slack.Seconds()),
+        "advisorySpeedKmh": ea.AdvisorySpeed * 3.6,
+        "coast":            ea.Coast,
+        "profile":          profile,
+    }
+}
+
+// GET /api/trains/{trainId}/eco
+// POST /api/trains/{trainId}/eco
+//
+// GET returns the energy-saving speed profile of the train to its next stop.
+// POST makes the train coast down to its advisory speed until its next stop.
+func serveTrainEco(w http.ResponseWriter, r *http.Request, trainID string) {
+    h := requestHub(r)
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    tid, err := strconv.Atoi(trainID)
+    if err != nil || tid < 0 || tid >= len(h.sim.Trains) {
+        writeAPIError(w, http.StatusNotFound, ErrCodeTrainNotFound, "Train not found", map[string]interface{}{"trainId": trainID})
+        return
+    }
+    t := h.sim.Trains[tid]
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        if err := t.Coast(); err != nil {
+            writeAPIError(w, http.StatusConflict, ErrCodeConflict, err.Error(), map[string]interface{}{"trainId": trainID})
+            return
+        }
+    default:
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{
+        "trainId":     trainID,
+        "serviceCode": t.ServiceCode,
+        "coasting":    t.IsCoasting(),
+        "advisory":    ecoAdvisoryJSON(h.sim, t),
+    })
+}
@@ -0,0 +1,644 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// TrainingChangedEvent is sent to its listeners when a training scenario
+// starts, when one of its objectives is met or failed and when it ends.
+const TrainingChangedEvent simulation.EventName = "trainingChanged"
+
+// maxTrainingResults is the number of ended training runs kept for each
+// simulation
+const maxTrainingResults = 20
+
+// Reasons for which a training run ends
+const (
+	trainingEndCompleted = "completed"
+	trainingEndStopped   = "stopped"
+	trainingEndAborted   = "aborted"
+)
+
+// Statuses of the objectives of a training run
+const (
+	objectivePending = "PENDING"
+	objectiveMet     = "MET"
+	objectiveFailed  = "FAILED"
+)
+
+// errTrainingRunning is returned when starting a training scenario while
+// another one is running
+var errTrainingRunning = errors.New("a training scenario is already running")
+
+// An ObjectiveResult is the evaluation of an objective of a training run
+type ObjectiveResult struct {
+	Description string                     `json:"description"`
+	Condition   string                     `json:"condition"`
+	Metric      simulation.ObjectiveMetric `json:"metric"`
+	Status      string                     `json:"status"`
+	// Value is the last value of the metric, or nil if it has no value yet,
+	// e.g. the punctuality before any train moved.
+	Value     *float64 `json:"value"`
+	DecidedAt string   `json:"decidedAt,omitempty"`
+}
+
+// A TrainingRun is a training scenario played on a simulation, with the
+// evaluation of its objectives.
+type TrainingRun struct {
+	RunID        string `json:"runId"`
+	ScenarioID   string `json:"scenarioId"`
+	Title        string `json:"title"`
+	SimulationID string `json:"simulationId"`
+	Running      bool   `json:"running"`
+	StartedAt    string `json:"startedAt"`
+	EndedAt      string `json:"endedAt,omitempty"`
+	SimStart     string `json:"simStart"`
+	SimEnd       string `json:"simEnd,omitempty"`
+	EndReason    string `json:"endReason,omitempty"`
+	// Passed is true if the run ended with all its objectives met
+	Passed bool `json:"passed"`
+	Met    int  `json:"met"`
+	Failed int  `json:"failed"`
+	// Disruptions are the IDs of the disruptions injected by the run
+	Disruptions []string          `json:"disruptions"`
+	Objectives  []ObjectiveResult `json:"objectives"`
+}
+
+// ID returns the ID of the run
+func (tr TrainingRun) ID() string {
+	return tr.RunID
+}
+
+// A metricsBaseline holds the counters of the KPIs of a simulation when a
+// training run starts, so that its objectives only count what happens during
+// the run.
+type metricsBaseline struct {
+	rtpOnTime         int
+	rtpTotal          int
+	cancellations     int
+	missedConnections int
+	spads             int
+	overspeeds        int
+}
+
+// baseline returns the current counters of m
+func (m *metricsState) baseline() metricsBaseline {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return metricsBaseline{
+		rtpOnTime:         m.rtpOnTime,
+		rtpTotal:          m.rtpTotal,
+		cancellations:     m.cancellations,
+		missedConnections: m.missedConnections,
+		spads:             m.spads,
+		overspeeds:        m.overspeeds,
+	}
+}
+
+// trainingRun is the running training scenario of a simulation
+type trainingRun struct {
+	view       TrainingRun
+	objectives []*simulation.TrainingObjective
+	base       metricsBaseline
+}
+
+// snapshot returns a copy of the state of the run
+func (r *trainingRun) snapshot() TrainingRun {
+	res := r.view
+	res.Disruptions = append([]string{}, r.view.Disruptions...)
+	res.Objectives = append([]ObjectiveResult{}, r.view.Objectives...)
+	return res
+}
+
+// decide sets the status of the objective at index i, returning false if it
+// was already decided.
+func (r *trainingRun) decide(i int, status, simTime string) bool {
+	res := &r.view.Objectives[i]
+	if res.Status != objectivePending {
+		return false
+	}
+	res.Status = status
+	res.DecidedAt = simTime
+	if status == objectiveMet {
+		r.view.Met++
+	} else {
+		r.view.Failed++
+	}
+	return true
+}
+
+// evaluate updates the objectives of the run with the given values of the
+// metrics at now, and returns the indexes of the objectives decided.
+//
+// If final is set, the run is ending and all the pending objectives are
+// decided: those to reach are failed unless they hold, and those to
+// maintain are met unless they do not hold.
+func (r *trainingRun) evaluate(values map[simulation.ObjectiveMetric]float64, now time.Time, simTime string, final bool) []int {
+	var decided []int
+	for i, o := range r.objectives {
+		v, ok := values[o.Metric]
+		if ok {
+			r.view.Objectives[i].Value = &v
+		}
+		deadline := o.DeadlineTime()
+		over := final || (!deadline.IsZero() && !now.Before(deadline))
+		status := ""
+		switch o.Mode {
+		case simulation.ObjectiveMaintain:
+			switch {
+			case ok && !o.Holds(v):
+				status = objectiveFailed
+			case over:
+				status = objectiveMet
+			}
+		default:
+			switch {
+			case ok && o.Holds(v):
+				status = objectiveMet
+			case over:
+				status = objectiveFailed
+			}
+		}
+		if status != "" && r.decide(i, status, simTime) {
+			decided = append(decided, i)
+		}
+	}
+	return decided
+}
+
+// done returns true if all the objectives of the run are decided
+func (r *trainingRun) done() bool {
+	return r.view.Met+r.view.Failed == len(r.objectives)
+}
+
+// trainingState holds the training runs of a simulation
+type trainingState struct {
+	mu      sync.Mutex
+	nextID  int
+	current *trainingRun
+	// history holds the ended runs, latest first
+	history []TrainingRun
+}
+
+// trainings holds the training runs of the default simulation
+var trainings = newTrainingState()
+
+// newTrainingState returns a training state without run
+func newTrainingState() *trainingState {
+	return new(trainingState)
+}
+
+// trainingMetrics returns the values of the objective metrics of h since
+// base. Metrics without value, such as the punctuality before any train
+// moved, are left out.
+func (h *Hub) trainingMetrics(base metricsBaseline) map[simulation.ObjectiveMetric]float64 {
+	m := h.metrics
+	res := make(map[simulation.ObjectiveMetric]float64)
+	m.mu.RLock()
+	if total := m.rtpTotal - base.rtpTotal; total > 0 {
+		res[simulation.ObjectivePunctuality] = float64(m.rtpOnTime-base.rtpOnTime) * 100 / float64(total)
+	}
+	avgDelay := 0.0
+	for _, d := range m.delays {
+		avgDelay += d.minutes
+	}
+	if len(m.delays) > 0 {
+		avgDelay /= float64(len(m.delays))
+	}
+	res[simulation.ObjectiveAverageDelay] = avgDelay
+	res[simulation.ObjectiveOpenConflicts] = float64(m.openConflicts)
+	res[simulation.ObjectiveCancellations] = float64(m.cancellations - base.cancellations)
+	res[simulation.ObjectiveMissedConnections] = float64(m.missedConnections - base.missedConnections)
+	res[simulation.ObjectiveSPADs] = float64(m.spads - base.spads)
+	res[simulation.ObjectiveOverspeeds] = float64(m.overspeeds - base.overspeeds)
+	m.mu.RUnlock()
+	if score := h.currentScore(); score.Grade != "" {
+		res[simulation.ObjectiveScore] = score.Percent
+	}
+	return res
+}
+
+// startTraining starts the training scenario with the given ID on h: it
+// starts a new scoring session and injects the disruptions of the scenario.
+func (h *Hub) startTraining(id string) (TrainingRun, error) {
+	ts := h.sim.TrainingScenario(id)
+	if ts == nil {
+		return TrainingRun{}, fmt.Errorf("unknown training scenario: %s", id)
+	}
+	tst := h.trainings
+	tst.mu.Lock()
+	if tst.current != nil {
+		tst.mu.Unlock()
+		return TrainingRun{}, errTrainingRunning
+	}
+	tst.nextID++
+	now := h.sim.Options.CurrentTime.Time
+	run := &trainingRun{
+		view: TrainingRun{
+			RunID:        strconv.Itoa(tst.nextID),
+			ScenarioID:   ts.ID(),
+			Title:        ts.Title,
+			SimulationID: h.id,
+			Running:      true,
+			StartedAt:    time.Now().UTC().Format(time.RFC3339),
+			SimStart:     h.sim.FormatTime(now),
+			Disruptions:  []string{},
+		},
+		objectives: ts.Objectives,
+		base:       h.metrics.baseline(),
+	}
+	for _, o := range ts.Objectives {
+		run.view.Objectives = append(run.view.Objectives, ObjectiveResult{
+			Description: o.Description,
+			Condition:   o.String(),
+			Metric:      o.Metric,
+			Status:      objectivePending,
+		})
+	}
+	tst.current = run
+	tst.mu.Unlock()
+
+	// The lock is not held while injecting the disruptions since their
+	// events go through the hub loop, which evaluates the running scenario.
+	h.endScoringSession(sessionEndTraining)
+	var injected []string
+	for i, td := range ts.Disruptions {
+		d := td.Disruption(now)
+		if err := h.sim.AddDisruption(d); err != nil {
+			tst.mu.Lock()
+			tst.current = nil
+			tst.mu.Unlock()
+			h.clearTrainingDisruptions(injected)
+			return TrainingRun{}, fmt.Errorf("error in disruption %d: %s", i, err)
+		}
+		injected = append(injected, d.ID())
+	}
+	tst.mu.Lock()
+	run.view.Disruptions = injected
+	res := run.snapshot()
+	tst.mu.Unlock()
+
+	logger.Info("Training scenario started", "submodule", "hub", "simulation", h.id, "scenario", id, "run", res.RunID)
+	h.audits.append(AuditEntry{
+		SimTime:  res.SimStart,
+		Event:    "TRAINING_STARTED",
+		Category: "training",
+		Severity: "INFO",
+		Object:   map[string]interface{}{"id": res.RunID},
+		Details:  map[string]interface{}{"scenarioId": id, "disruptions": injected},
+	})
+	h.sendTrainingEvent(res)
+	return res, nil
+}
+
+// clearTrainingDisruptions removes the disruptions with the given IDs that
+// have not been cleared yet.
+func (h *Hub) clearTrainingDisruptions(ids []string) {
+	for _, id := range ids {
+		if _, ok := h.sim.GetDisruption(id); ok {
+			_ = h.sim.RemoveDisruption(id)
+		}
+	}
+}
+
+// sendTrainingEvent sends a TrainingChangedEvent with run to the listeners.
+// It must not be called from the hub loop.
+func (h *Hub) sendTrainingEvent(run TrainingRun) {
+	select {
+	case h.events <- &simulation.Event{Name: TrainingChangedEvent, Object: run}:
+	case <-h.done:
+	}
+}
+
+// finishTrainingLocked ends the running training run for the given reason, deciding
+// its pending objectives, and records it in the history.
+//
+// tst.mu must be held by the caller.
+func (h *Hub) finishTrainingLocked(reason string, values map[simulation.ObjectiveMetric]float64) TrainingRun {
+	tst := h.trainings
+	run := tst.current
+	now := h.sim.Options.CurrentTime.Time
+	simTime := h.sim.FormatTime(now)
+	run.evaluate(values, now, simTime, true)
+	run.view.Running = false
+	run.view.EndedAt = time.Now().UTC().Format(time.RFC3339)
+	run.view.SimEnd = simTime
+	run.view.EndReason = reason
+	run.view.Passed = run.view.Failed == 0
+	res := run.snapshot()
+	tst.current = nil
+	tst.history = append([]TrainingRun{res}, tst.history...)
+	if len(tst.history) > maxTrainingResults {
+		tst.history = tst.history[:maxTrainingResults]
+	}
+	return res
+}
+
+// auditTrainingResult records the result of an ended training run in the
+// audit log
+func (h *Hub) auditTrainingResult(res TrainingRun) {
+	logger.Info("Training scenario evaluated", "submodule", "hub", "simulation", h.id, "scenario", res.ScenarioID, "run", res.RunID, "passed", res.Passed)
+	severity := "INFO"
+	if !res.Passed {
+		severity = "WARNING"
+	}
+	h.audits.append(AuditEntry{
+		SimTime:  res.SimEnd,
+		Event:    "TRAINING_EVALUATED",
+		Category: "training",
+		Severity: severity,
+		Object:   map[string]interface{}{"id": res.RunID},
+		Details: map[string]interface{}{
+			"scenarioId": res.ScenarioID,
+			"passed":     res.Passed,
+			"met":        res.Met,
+			"failed":     res.Failed,
+			"endReason":  res.EndReason,
+		},
+	})
+}
+
+// updateTraining evaluates the objectives of the running training run at
+// each clock tick. It returns the TrainingChangedEvent to send to the
+// listeners if an objective has been decided, or nil.
+//
+// The run ends when all its objectives are decided.
+func (h *Hub) updateTraining(e *simulation.Event) *simulation.Event {
+	if e.Name != simulation.ClockEvent {
+		return nil
+	}
+	tst := h.trainings
+	tst.mu.Lock()
+	if tst.current == nil {
+		tst.mu.Unlock()
+		return nil
+	}
+	run := tst.current
+	values := h.trainingMetrics(run.base)
+	now := h.eventSnapshot(e).simTime
+	simTime := h.sim.FormatTime(now)
+	decided := run.evaluate(values, now, simTime, false)
+	if len(decided) == 0 {
+		tst.mu.Unlock()
+		return nil
+	}
+	for _, i := range decided {
+		res := run.view.Objectives[i]
+		h.audits.append(AuditEntry{
+			SimTime:  simTime,
+			Event:    "TRAINING_OBJECTIVE",
+			Category: "training",
+			Severity: "INFO",
+			Object:   map[string]interface{}{"id": run.view.RunID},
+			Details:  map[string]interface{}{"objective": i, "condition": res.Condition, "status": res.Status},
+		})
+	}
+	if !run.done() {
+		res := run.snapshot()
+		tst.mu.Unlock()
+		return &simulation.Event{Name: TrainingChangedEvent, Object: res}
+	}
+	res := h.finishTrainingLocked(trainingEndCompleted, values)
+	tst.mu.Unlock()
+	h.auditTrainingResult(res)
+	// Removing disruptions sends events to the hub loop, from which this
+	// method is called.
+	go h.clearTrainingDisruptions(res.Disruptions)
+	return &simulation.Event{Name: TrainingChangedEvent, Object: res}
+}
+
+// currentTraining returns the running training run of h, if any
+func (h *Hub) currentTraining() (TrainingRun, bool) {
+	tst := h.trainings
+	tst.mu.Lock()
+	defer tst.mu.Unlock()
+	if tst.current == nil {
+		return TrainingRun{}, false
+	}
+	return tst.current.snapshot(), true
+}
+
+// trainingResults returns the ended training runs of h, latest first
+func (h *Hub) trainingResults() []TrainingRun {
+	tst := h.trainings
+	tst.mu.Lock()
+	defer tst.mu.Unlock()
+	return append([]TrainingRun{}, tst.history...)
+}
+
+// stopTraining ends the running training run of h for the given reason and
+// evaluates its objectives. The disruptions of the run are cleared unless it
+// is aborted because the simulation is replaced or removed. It returns false
+// if no run was running.
+func (h *Hub) stopTraining(reason string) (TrainingRun, bool) {
+	tst := h.trainings
+	tst.mu.Lock()
+	if tst.current == nil {
+		tst.mu.Unlock()
+		return TrainingRun{}, false
+	}
+	res := h.finishTrainingLocked(reason, h.trainingMetrics(tst.current.base))
+	tst.mu.Unlock()
+	h.auditTrainingResult(res)
+	if reason != trainingEndAborted {
+		h.clearTrainingDisruptions(res.Disruptions)
+	}
+	h.sendTrainingEvent(res)
+	return res, true
+}
+
+// GET /api/training/scenarios
+//
+// Returns the training scenarios of the simulation.
+func serveTrainingScenarios(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.sim.TrainingScenarios()})
+}
+
+// GET /api/training/scenarios/{id}
+// POST /api/training/scenarios/{id}/start
+func serveTrainingScenario(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/training/scenarios/")
+	id := strings.TrimSuffix(path, "/start")
+	ts := h.sim.TrainingScenario(id)
+	if ts == nil {
+		writeAPIError(w, http.StatusNotFound, ErrCodeTrainingNotFound, "Training scenario not found", map[string]interface{}{"scenarioId": id})
+		return
+	}
+	if id == path {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(ts)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	res, err := h.startTraining(id)
+	switch {
+	case err == errTrainingRunning:
+		current, _ := h.currentTraining()
+		writeAPIError(w, http.StatusConflict, ErrCodeConflict, "A training scenario is already running",
+			map[string]interface{}{"runId": current.RunID, "scenarioId": current.ScenarioID})
+		return
+	case err != nil:
+		invalidParameter(w, err.Error(), map[string]interface{}{"scenarioId": id})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", resourceLocation(r, "/api/training/run"))
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// GET /api/training/run
+//
+// Returns the running training scenario and the evaluation of its
+// objectives so far.
+func serveTrainingRun(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	res, ok := h.currentTraining()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "No training scenario running", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// POST /api/training/run/stop
+//
+// Stops the running training scenario, clears its disruptions and returns
+// the evaluation of its objectives.
+func serveTrainingStop(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if h.sim == nil {
+		simulationNotInitialized(w)
+		return
+	}
+	res, ok := h.stopTraining(trainingEndStopped)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "No training scenario running", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// GET /api/training/results
+//
+// Returns the last ended training runs, latest first.
+func serveTrainingResults(w http.ResponseWriter, r *http.Request) {
+	h := requestHub(r)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": h.trainingResults()})
+}
+
+type trainingObject struct{}
+
+// dispatch processes requests made on the training object
+func (t *trainingObject) dispatch(h *Hub, req Request, conn *connection, ch chan<- interface{}) {
+	logger.Debug("Request for training received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	var idParams = struct {
+		ID string `json:"id"`
+	}{}
+	var res interface{}
+	switch req.Action {
+	case "list":
+		res = h.sim.TrainingScenarios()
+	case "show", "start":
+		if err := json.Unmarshal(req.Params, &idParams); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ts := h.sim.TrainingScenario(idParams.ID)
+		if ts == nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown training scenario: %s", idParams.ID))
+			return
+		}
+		res = ts
+		if req.Action == "start" {
+			run, err := h.startTraining(idParams.ID)
+			if err != nil {
+				ch <- NewErrorResponse(req.ID, err)
+				return
+			}
+			res = run
+		}
+	case "status":
+		run, ok := h.currentTraining()
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("no training scenario running"))
+			return
+		}
+		res = run
+	case "stop":
+		run, ok := h.stopTraining(trainingEndStopped)
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("no training scenario running"))
+			return
+		}
+		res = run
+	case "results":
+		res = h.trainingResults()
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		return
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+		return
+	}
+	ch <- NewResponse(req.ID, data)
+}
+
+var _ hubObject = new(trainingObject)
+
+func init() {
+	hub.objects["training"] = new(trainingObject)
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestTraining(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(2 * time.Second)
+	Convey("Testing training scenarios", t, func() {
+		So(RoleOperator.allows("training", "start"), ShouldBeFalse)
+		So(RoleObserver.allows("training", "status"), ShouldBeTrue)
+		So(RoleObserver.allows("training", "list"), ShouldBeTrue)
+
+		// The demo simulation with a training scenario
+		scenario := loadDemoWith(func(raw map[string]interface{}) {
+			raw["trainingScenarios"] = map[string]interface{}{
+				"SF": map[string]interface{}{
+					"title": "Signal failure",
+					"disruptions": []interface{}{
+						map[string]interface{}{"type": "SIGNAL_FAILED", "trackItemId": "5", "durationMinutes": 60},
+					},
+					"objectives": []interface{}{
+						map[string]interface{}{"metric": "punctuality", "operator": ">=", "value": 90, "deadline": "07:00:00"},
+						map[string]interface{}{"metric": "spads", "operator": "<", "value": 1, "deadline": "06:30:00", "mode": "MAINTAIN"},
+					},
+				},
+			}
+		})
+		So(AddSimulation("training", scenario), ShouldBeNil)
+		h, _ := simulations.get("training")
+		defer func() { So(simulations.remove("training"), ShouldBeNil) }()
+		// Each clock tick is a new event, with the time at which it is sent
+		clock := func() *simulation.Event { return &simulation.Event{Name: simulation.ClockEvent} }
+		movements := func(onTime, total int) {
+			h.metrics.mu.Lock()
+			h.metrics.rtpOnTime += onTime
+			h.metrics.rtpTotal += total
+			h.metrics.mu.Unlock()
+		}
+		// cleared waits for the disruptions of the run to be cleared
+		cleared := func() bool {
+			for i := 0; i < 20; i++ {
+				if len(h.sim.Disruptions()) == 0 {
+					return true
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			return false
+		}
+
+		_, err := h.startTraining("XX")
+		So(err, ShouldNotBeNil)
+		run, err := h.startTraining("SF")
+		So(err, ShouldBeNil)
+		So(run.Running, ShouldBeTrue)
+		So(run.SimStart, ShouldEqual, "06:00:00")
+		So(run.Disruptions, ShouldHaveLength, 1)
+		d, ok := h.sim.GetDisruption(run.Disruptions[0])
+		So(ok, ShouldBeTrue)
+		So(d.Status(), ShouldEqual, "ACTIVE")
+		So(run.Objectives, ShouldHaveLength, 2)
+		So(run.Objectives[0].Condition, ShouldEqual, "punctuality >= 90 by 07:00:00")
+		So(run.Objectives[1].Status, ShouldEqual, objectivePending)
+		_, err = h.startTraining("SF")
+		So(err, ShouldEqual, errTrainingRunning)
+
+		Convey("Objectives should be evaluated until the run completes", func() {
+			movements(8, 10)
+			So(h.updateTraining(clock()), ShouldBeNil)
+			current, ok := h.currentTraining()
+			So(ok, ShouldBeTrue)
+			So(*current.Objectives[0].Value, ShouldEqual, 80)
+			So(current.Objectives[0].Status, ShouldEqual, objectivePending)
+
+			movements(11, 11)
+			e := h.updateTraining(clock())
+			So(e, ShouldNotBeNil)
+			So(e.Name, ShouldEqual, TrainingChangedEvent)
+			current = e.Object.(TrainingRun)
+			So(current.Running, ShouldBeTrue)
+			So(current.Objectives[0].Status, ShouldEqual, objectiveMet)
+			So(current.Objectives[0].DecidedAt, ShouldEqual, "06:00:00")
+
+			h.sim.Options.CurrentTime.Lock()
+			h.sim.Options.CurrentTime.Time = simulation.ParseTime("06:31:00").Time
+			h.sim.Options.CurrentTime.Unlock()
+			e = h.updateTraining(clock())
+			So(e, ShouldNotBeNil)
+			res := e.Object.(TrainingRun)
+			So(res.Running, ShouldBeFalse)
+			So(res.EndReason, ShouldEqual, trainingEndCompleted)
+			So(res.Passed, ShouldBeTrue)
+			So(res.Met, ShouldEqual, 2)
+			So(res.Objectives[1].Status, ShouldEqual, objectiveMet)
+			_, ok = h.currentTraining()
+			So(ok, ShouldBeFalse)
+			So(h.trainingResults()[0].RunID, ShouldEqual, res.RunID)
+			So(cleared(), ShouldBeTrue)
+		})
+		Convey("Stopped runs should fail the objectives not met", func() {
+			h.metrics.mu.Lock()
+			h.metrics.spads++
+			h.metrics.mu.Unlock()
+			e := h.updateTraining(clock())
+			So(e, ShouldNotBeNil)
+			So(e.Object.(TrainingRun).Objectives[1].Status, ShouldEqual, objectiveFailed)
+
+			res, ok := h.stopTraining(trainingEndStopped)
+			So(ok, ShouldBeTrue)
+			So(res.Passed, ShouldBeFalse)
+			So(res.Failed, ShouldEqual, 2)
+			So(res.Objectives[0].Value, ShouldBeNil)
+			So(res.EndReason, ShouldEqual, trainingEndStopped)
+			So(h.sim.Disruptions(), ShouldBeEmpty)
+			_, ok = h.stopTraining(trainingEndStopped)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Training scenarios should be served over HTTP", func() {
+			h.stopTraining(trainingEndStopped)
+			resp, err := http.Get("http://127.0.0.1:22222/api/training/scenarios")
+			So(err, ShouldBeNil)
+			var list struct {
+				Items []interface{} `json:"items"`
+			}
+			So(json.NewDecoder(resp.Body).Decode(&list), ShouldBeNil)
+			resp.Body.Close()
+			So(list.Items, ShouldBeEmpty)
+			for _, path := range []string{"/api/training/scenarios/SF", "/api/training/run"} {
+				resp, err = http.Get("http://127.0.0.1:22222" + path)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			}
+			resp, err = http.Post("http://127.0.0.1:22222/api/training/run/stop", "application/json", nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+// GET /api/transfers[?status=PENDING|MADE|MISSED]
+//
+// Returns the connections between services defined in the simulation with
+// their current status.
+func serveTransfers(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodGet {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    status := simulation.TransferStatus(r.URL.Query().Get("status"))
+    items := make([]*simulation.Transfer, 0)
+    for _, tr := range h.sim.Transfers() {
+        if status != "" && tr.Status() != status {
+            continue
+        }
+        items = append(items, tr)
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
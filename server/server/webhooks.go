@@ -0,0 +1,393 @@
+package server
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    maxWebhooks            = 50
+    webhookMaxAttempts     = 4
+    webhookMaxConcurrent   = 8
+    webhookRequestTimeout  = 10 * time.Second
+    webhookSignatureHeader = "X-TS2-Signature"
+)
+
+// webhookRetryDelay is the delay before the first retry of a failed delivery.
+// It doubles at each attempt.
+var webhookRetryDelay = 2 * time.Second
+
+// A webhook is an operator configured URL to which matching audit events are POSTed.
+type webhook struct {
+    ID         string    `json:"id"`
+    URL        string    `json:"url"`
+    Events     []string  `json:"events"`
+    Categories []string  `json:"categories"`
+    Enabled    bool      `json:"enabled"`
+    CreatedAt  time.Time `json:"createdAt"`
+    secret     string
+
+    mutex      sync.Mutex
+    delivered  int
+    failed     int
+    lastStatus int
+    lastError  string
+    lastSentAt time.Time
+}
+
+// matches returns true if the given entry passes the filters of this webhook.
+// Empty filters match everything.
+func (wh *webhook) matches(e AuditEntry) bool {
+    if !wh.Enabled {
+        return false
+    }
+    if len(wh.Events) > 0 && !containsFold(wh.Events, e.Event) {
+        return false
+    }
+    if len(wh.Categories) > 0 && !containsFold(wh.Categories, e.Category) {
+        return false
+    }
+    return true
+}
+
+// view returns the JSON representation of this webhook, without its secret
+func (wh *webhook) view() map[string]interface{} {
+    wh.mutex.Lock()
+    defer wh.mutex.Unlock()
+    lastSent := ""
+    if !wh.lastSentAt.IsZero() {
+        lastSent = wh.lastSentAt.Format(time.RFC3339)
+    }
+    return map[string]interface{}{
+        "id":         wh.ID,
+        "url":        wh.URL,
+        "events":     wh.Events,
+        "categories": wh.Categories,
+        "enabled":    wh.Enabled,
+        "signed":     wh.secret != "",
+        "createdAt":  wh.CreatedAt.Format(time.RFC3339),
+        "stats": map[string]interface{}{
+            "delivered":  wh.delivered,
+            "failed":     wh.failed,
+            "lastStatus": wh.lastStatus,
+            "lastError":  wh.lastError,
+            "lastSentAt": lastSent,
+        },
+    }
+}
+
+// recordAttempt updates the delivery statistics of this webhook
+func (wh *webhook) recordAttempt(status int, err error, final bool) {
+    wh.mutex.Lock()
+    defer wh.mutex.Unlock()
+    wh.lastStatus = status
+    wh.lastSentAt = time.Now().UTC()
+    wh.lastError = ""
+    if err != nil {
+        wh.lastError = err.Error()
+    }
+    switch {
+    case err == nil:
+        wh.delivered++
+    case final:
+        wh.failed++
+    }
+}
+
+func containsFold(list []string, s string) bool {
+    for _, it := range list {
+        if strings.EqualFold(it, s) {
+            return true
+        }
+    }
+    return false
+}
+
+type webhookStore struct {
+    mu     sync.RWMutex
+    nextID int64
+    items  map[string]*webhook
+}
+
+var webhooks = &webhookStore{items: make(map[string]*webhook)}
+
+func (ws *webhookStore) add(wh *webhook) error {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+    if len(ws.items) >= maxWebhooks {
+        return fmt.Errorf("too many webhooks (max %d)", maxWebhooks)
+    }
+    ws.nextID++
+    wh.ID = fmt.Sprintf("wh_%d", ws.nextID)
+    ws.items[wh.ID] = wh
+    return nil
+}
+
+func (ws *webhookStore) get(id string) (*webhook, bool) {
+    ws.mu.RLock()
+    defer ws.mu.RUnlock()
+    wh, ok := ws.items[id]
+    return wh, ok
+}
+
+func (ws *webhookStore) remove(id string) bool {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+    if _, ok := ws.items[id]; !ok {
+        return false
+    }
+    delete(ws.items, id)
+    return true
+}
+
+// list returns all webhooks sorted by creation
+func (ws *webhookStore) list() []*webhook {
+    ws.mu.RLock()
+    defer ws.mu.RUnlock()
+    res := make([]*webhook, 0, len(ws.items))
+    for _, wh := range ws.items {
+        res = append(res, wh)
+    }
+    sort.Slice(res, func(i, j int) bool {
+        if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+            return res[i].CreatedAt.Before(res[j].CreatedAt)
+        }
+        return res[i].ID < res[j].ID
+    })
+    return res
+}
+
+// webhookPayload is the body POSTed to webhooks
+type webhookPayload struct {
+    DeliveryID string     `json:"deliveryId"`
+    WebhookID  string     `json:"webhookId"`
+    Event      string     `json:"event"`
+    SentAt     string     `json:"sentAt"`
+    Entry      AuditEntry `json:"entry"`
+}
+
+// signWebhookPayload returns the signature header value of body for the given secret
+func signWebhookPayload(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    _, _ = mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var (
+    webhookClient     = &http.Client{Timeout: webhookRequestTimeout}
+    webhookSemaphore  = make(chan struct{}, webhookMaxConcurrent)
+    webhookDeliveries int64
+    webhookDeliveryMu sync.Mutex
+)
+
+func nextDeliveryID() string {
+    webhookDeliveryMu.Lock()
+    defer webhookDeliveryMu.Unlock()
+    webhookDeliveries++
+    return fmt.Sprintf("dlv_%d", webhookDeliveries)
+}
+
+// deliver POSTs the given entry to the webhook, retrying with exponential
+// backoff on network errors and non 2xx responses.
+func (wh *webhook) deliver(e AuditEntry) {
+    payload := webhookPayload{
+        DeliveryID: nextDeliveryID(),
+        WebhookID:  wh.ID,
+        Event:      e.Event,
+        SentAt:     time.Now().UTC().Format(time.RFC3339),
+        Entry:      e,
+    }
+    body, err := json.Marshal(payload)
+    if err != nil {
+        logger.Error("Unable to marshal webhook payload", "submodule", "webhooks", "webhook", wh.ID, "error", err)
+        return
+    }
+    delay := webhookRetryDelay
+    for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+        status, err := wh.post(payload, body, attempt)
+        final := err == nil || attempt == webhookMaxAttempts
+        wh.recordAttempt(status, err, final)
+        if err == nil {
+            return
+        }
+        logger.Warn("Webhook delivery failed", "submodule", "webhooks", "webhook", wh.ID, "delivery", payload.DeliveryID,
+            "attempt", attempt, "status", status, "error", err)
+        if final {
+            return
+        }
+        time.Sleep(delay)
+        delay *= 2
+    }
+}
+
+// post sends one delivery attempt and returns the response status
+func (wh *webhook) post(payload webhookPayload, body []byte, attempt int) (int, error) {
+    req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    req.Header.Set("User-Agent", "ts2-sim-server-webhooks")
+    req.Header.Set("X-TS2-Event", payload.Event)
+    req.Header.Set("X-TS2-Delivery", payload.DeliveryID)
+    req.Header.Set("X-TS2-Attempt", fmt.Sprint(attempt))
+    if wh.secret != "" {
+        req.Header.Set(webhookSignatureHeader, signWebhookPayload(wh.secret, body))
+    }
+    resp, err := webhookClient.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    _ = resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// dispatchWebhooks sends the given audit entry to all matching webhooks
+func dispatchWebhooks(e AuditEntry) {
+    for _, wh := range webhooks.list() {
+        if !wh.matches(e) {
+            continue
+        }
+        go func(wh *webhook) {
+            webhookSemaphore <- struct{}{}
+            defer func() { <-webhookSemaphore }()
+            wh.deliver(e)
+        }(wh)
+    }
+}
+
+// startWebhookDispatcher forwards audit entries to the configured webhooks
+func startWebhookDispatcher() {
+    ch := audits.subscribe()
+    go func() {
+        for e := range ch {
+            dispatchWebhooks(e)
+        }
+    }()
+}
+
+// webhookRequest is the body of webhook creation requests
+type webhookRequest struct {
+    URL        string   `json:"url"`
+    Events     []string `json:"events"`
+    Categories []string `json:"categories"`
+    Secret     string   `json:"secret"`
+    Enabled    *bool    `json:"enabled"`
+}
+
+// validate checks the webhook URL
+func (wr webhookRequest) validate() error {
+    u, err := url.Parse(wr.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("url must be an absolute http or https URL")
+    }
+    return nil
+}
+
+// GET /api/webhooks
+// POST /api/webhooks
+func serveWebhooks(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        items := []map[string]interface{}{}
+        for _, wh := range webhooks.list() {
+            items = append(items, wh.view())
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    case http.MethodPost:
+        var body webhookRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            badRequest(w, err)
+            return
+        }
+        if err := body.validate(); err != nil {
+            invalidParameter(w, err.Error(), map[string]interface{}{"url": body.URL})
+            return
+        }
+        wh := &webhook{
+            URL:        body.URL,
+            Events:     body.Events,
+            Categories: body.Categories,
+            Enabled:    body.Enabled == nil || *body.Enabled,
+            CreatedAt:  time.Now().UTC(),
+            secret:     body.Secret,
+        }
+        if wh.Events == nil {
+            wh.Events = []string{}
+        }
+        if wh.Categories == nil {
+            wh.Categories = []string{}
+        }
+        if err := webhooks.add(wh); err != nil {
+            invalidParameter(w, err.Error(), nil)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.Header().Set("Location", apiPrefix+"/webhooks/"+wh.ID)
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(wh.view())
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// GET /api/webhooks/{id}
+// DELETE /api/webhooks/{id}
+// POST /api/webhooks/{id}/test
+func serveWebhook(w http.ResponseWriter, r *http.Request) {
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/webhooks/"), "/")
+    id := parts[0]
+    wh, ok := webhooks.get(id)
+    if !ok {
+        writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "Webhook not found", map[string]interface{}{"webhookId": id})
+        return
+    }
+    if len(parts) == 2 && parts[1] == "test" {
+        if r.Method != http.MethodPost {
+            methodNotAllowed(w, r)
+            return
+        }
+        go wh.deliver(AuditEntry{
+            ID:        "0",
+            Timestamp: time.Now().UTC().Format(time.RFC3339),
+            Event:     "WEBHOOK_TEST",
+            Category:  "system",
+            Severity:  "INFO",
+            Object:    map[string]interface{}{"id": wh.ID},
+            Details:   map[string]interface{}{},
+        })
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        w.WriteHeader(http.StatusAccepted)
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+        return
+    }
+    if len(parts) > 1 {
+        serveAPINotFound(w, r)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _ = json.NewEncoder(w).Encode(wh.view())
+    case http.MethodDelete:
+        webhooks.remove(id)
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        _, _ = w.Write([]byte("{\"status\":\"OK\"}"))
+    default:
+        methodNotAllowed(w, r)
+    }
+}
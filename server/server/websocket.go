@@ -19,36 +19,103 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 
 	"context"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// jsonSubprotocol is the default websocket subprotocol, with JSON text messages.
+	jsonSubprotocol = "ts2.json"
+	// msgpackSubprotocol is the binary websocket subprotocol, with MessagePack encoded messages.
+	msgpackSubprotocol = "ts2.msgpack"
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{jsonSubprotocol, msgpackSubprotocol},
+	CheckOrigin:     checkWebsocketOrigin,
+}
+
+var (
+	// maxClients is the maximum number of websocket clients connected at
+	// once, or 0 if there is no limit
+	maxClients int64
+	// activeClients is the number of websocket clients connected, including
+	// those being upgraded
+	activeClients int64
+)
+
+// SetMaxClients sets the maximum number of websocket clients connected at
+// once to all the simulations. Further connections are refused with a 503
+// Service Unavailable status. Zero means no limit.
+func SetMaxClients(max int) error {
+	if max < 0 {
+		return fmt.Errorf("maximum number of clients cannot be negative")
+	}
+	atomic.StoreInt64(&maxClients, int64(max))
+	return nil
 }
 
 // serveWs serves the WebSocket endpoint of the server.
 //
 // It reads JSON from the client and sends a Request object to the hub.
 // It receives Response objects from the hub and send JSON to the client.
+// Clients asking for the ts2.msgpack subprotocol exchange MessagePack binary
+// messages instead. Messages are compressed for clients that support the
+// permessage-deflate extension, unless compression is disabled.
+//
+// Clients are pinged regularly and disconnected when they have been silent
+// for the idle timeout, see SetIdleTimeout.
+//
+// The sim URL parameter selects the simulation the client attaches to. It
+// defaults to the default simulation.
 func serveWs(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	h := hub
+	if id := r.URL.Query().Get("sim"); id != "" {
+		var ok bool
+		if h, ok = simulations.get(id); !ok {
+			http.Error(w, "Unknown simulation", http.StatusNotFound)
+			return
+		}
+	}
+	defer atomic.AddInt64(&activeClients, -1)
+	if n, max := atomic.AddInt64(&activeClients, 1), atomic.LoadInt64(&maxClients); max > 0 && n > max {
+		atomic.AddInt64(&connStats.rejected, 1)
+		logger.Warn("Too many clients, connection refused", "submodule", "http", "remote", r.RemoteAddr, "maxClients", max)
+		http.Error(w, "Too many clients", http.StatusServiceUnavailable)
+		return
+	}
+	level := currentCompressionLevel()
+	u := upgrader
+	u.EnableCompression = level > 0
+	ws, err := u.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("Unable to upgrade to WebSocket", "submodule", "http", "error", err)
 		return
 	}
 	conn := &connection{
-		Conn:     *ws,
-		pushChan: make(chan interface{}, 256),
+		Conn:        *ws,
+		hub:         h,
+		pushChan:    make(chan interface{}, 256),
+		idleTimeout: currentIdleTimeout(),
+	}
+	if level > 0 {
+		// Only applies if the client negotiated permessage-deflate
+		_ = conn.SetCompressionLevel(level)
 	}
+	atomic.AddInt64(&connStats.opened, 1)
+	conn.startKeepalive()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
 		cancel()
 		conn.Close()
+		atomic.AddInt64(&connStats.closed, 1)
 	}()
 	conn.loop(ctx)
 }
@@ -0,0 +1,375 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+    whatIfDefaultHorizon = 30
+    whatIfMaxHorizon     = 240
+    whatIfMaxTimeFactor  = 10
+)
+
+// A whatIfChange is a hypothetical change applied to the cloned simulation
+// before it is run.
+type whatIfChange struct {
+    Type        string  `json:"type"`
+    TrainID     string  `json:"trainId,omitempty"`
+    TrackItemID string  `json:"trackItemId,omitempty"`
+    Minutes     float64 `json:"minutes,omitempty"`
+    Value       float64 `json:"value,omitempty"`
+}
+
+type whatIfRequest struct {
+    DurationMinutes int            `json:"durationMinutes"`
+    Changes         []whatIfChange `json:"changes"`
+}
+
+// whatIfConflict is a train that has been held at a stop signal during the run
+type whatIfConflict struct {
+    TrainID     string  `json:"trainId"`
+    ServiceCode string  `json:"serviceCode"`
+    SignalID    string  `json:"signalId,omitempty"`
+    StartTime   string  `json:"startTime"`
+    WaitMinutes float64 `json:"waitMinutes"`
+}
+
+// whatIfResult holds the KPIs predicted by a headless run
+type whatIfResult struct {
+    Punctuality  float64          `json:"punctuality"`
+    AverageDelay float64          `json:"averageDelay"`
+    P90Delay     float64          `json:"p90Delay"`
+    Throughput   int              `json:"throughput"`
+    Arrivals     int              `json:"arrivals"`
+    TrainsExited int              `json:"trainsExited"`
+    Utilization  float64          `json:"utilization"`
+    Bottlenecks  []string         `json:"bottlenecks"`
+    Conflicts    []whatIfConflict `json:"conflicts"`
+    EndTime      string           `json:"endTime"`
+}
+
+// applyWhatIfChange applies the given hypothetical change to the simulation
+func applyWhatIfChange(s *simulation.Simulation, c whatIfChange) error {
+    switch strings.ToUpper(c.Type) {
+    case "HOLD_TRAIN":
+        t, err := whatIfTrain(s, c.TrainID)
+        if err != nil {
+            return err
+        }
+        if c.Minutes <= 0 {
+            return fmt.Errorf("HOLD_TRAIN requires positive minutes")
+        }
+        t.Hold(time.Duration(c.Minutes * float64(time.Minute)))
+    case "BLOCK_TRACK":
+        ti, ok := s.TrackItems[c.TrackItemID]
+        if !ok {
+            return fmt.Errorf("unknown track item: %s", c.TrackItemID)
+        }
+        ti.SetBlocked(true)
+    case "SET_TIME_FACTOR":
+        if c.Value < 1 || c.Value > whatIfMaxTimeFactor {
+            return fmt.Errorf("time factor must be between 1 and %d", whatIfMaxTimeFactor)
+        }
+        s.Options.TimeFactor = int(c.Value)
+    default:
+        return fmt.Errorf("unknown change type: %s", c.Type)
+    }
+    return nil
+}
+
+func whatIfTrain(s *simulation.Simulation, id string) (*simulation.Train, error) {
+    for _, t := range s.Trains {
+        if t.ID() == id {
+            return t, nil
+        }
+    }
+    return nil, fmt.Errorf("unknown train: %s", id)
+}
+
+// trainProgress is the state of a train that we need to detect arrivals and departures
+type trainProgress struct {
+    status         simulation.TrainStatus
+    nextPlaceIndex int
+    service        *simulation.Service
+}
+
+// runWhatIf runs the given simulation headlessly for the given duration and
+// returns the KPIs observed during the run. The simulation must not be started.
+func runWhatIf(s *simulation.Simulation, horizon time.Duration) whatIfResult {
+    // Events are not used, but the channel must be drained for the simulation to proceed.
+    done := make(chan struct{})
+    go func() {
+        for {
+            select {
+            case <-s.EventChan:
+            case <-done:
+                return
+            }
+        }
+    }()
+    defer close(done)
+
+    var (
+        res       whatIfResult
+        delays    []float64
+        onTime    int
+        total     int
+        utilSum   float64
+        steps     int
+        conflicts []*whatIfConflict
+        waiting   = make(map[string]*whatIfConflict)
+        waitBySig = make(map[string]float64)
+    )
+    onTimeWindow := currentKPITuning().OnTimeWindow
+    scoreDelay := func(scheduled time.Time) {
+        delay := s.Options.CurrentTime.Time.Sub(scheduled)
+        if delay >= -onTimeWindow && delay <= onTimeWindow {
+            onTime++
+        }
+        total++
+        if delay > 0 {
+            delays = append(delays, delay.Minutes())
+        }
+    }
+    snapshot := func() []trainProgress {
+        ps := make([]trainProgress, len(s.Trains))
+        for i, t := range s.Trains {
+            ps[i] = trainProgress{status: t.Status, nextPlaceIndex: t.NextPlaceIndex, service: t.Service()}
+        }
+        return ps
+    }
+
+    end := s.Options.CurrentTime.Time.Add(horizon)
+    prev := snapshot()
+    for s.Options.CurrentTime.Time.Before(end) {
+        before := s.Options.CurrentTime.Time
+        s.Step()
+        stepMinutes := s.Options.CurrentTime.Time.Sub(before).Minutes()
+        for i, t := range s.Trains {
+            p := prev[i]
+            switch {
+            case p.status == simulation.Running && t.Status == simulation.Stopped:
+                // Arrival at the next scheduled place
+                res.Arrivals++
+                if srv := t.Service(); srv != nil && t.NextPlaceIndex >= 0 && t.NextPlaceIndex < len(srv.Lines) {
+                    if sl := srv.Lines[t.NextPlaceIndex]; !sl.ScheduledArrivalTime.IsZero() {
+                        scoreDelay(sl.ScheduledArrivalTime.Time)
+                    }
+                }
+            case p.status == simulation.Stopped && t.Status == simulation.Running:
+                // Departure from the place the train was stopped at
+                res.Throughput++
+                if p.service != nil && p.nextPlaceIndex >= 0 && p.nextPlaceIndex < len(p.service.Lines) {
+                    if sl := p.service.Lines[p.nextPlaceIndex]; !sl.ScheduledDepartureTime.IsZero() {
+                        scoreDelay(sl.ScheduledDepartureTime.Time)
+                    }
+                }
+            case p.status != simulation.Out && t.Status == simulation.Out:
+                res.TrainsExited++
+            }
+            // A train standing still without being at a scheduled stop is held by a signal
+            if t.Status != simulation.Waiting || t.Speed > 0 {
+                delete(waiting, t.ID())
+                continue
+            }
+            c, ok := waiting[t.ID()]
+            if !ok {
+                c = &whatIfConflict{
+                    TrainID:     t.ID(),
+                    ServiceCode: t.ServiceCode,
+                    StartTime:   s.FormatTime(before),
+                }
+                if nsp := t.NextSignalPosition(); !nsp.IsNull() {
+                    c.SignalID = nsp.TrackItem().ID()
+                }
+                waiting[t.ID()] = c
+                conflicts = append(conflicts, c)
+            }
+            c.WaitMinutes += stepMinutes
+            if c.SignalID != "" {
+                waitBySig[c.SignalID] += stepMinutes
+            }
+        }
+        prev = snapshot()
+        utilSum += trackUtilization(s)
+        steps++
+    }
+
+    if total > 0 {
+        res.Punctuality = float64(onTime) * 100 / float64(total)
+    }
+    if len(delays) > 0 {
+        sum := 0.0
+        for _, d := range delays {
+            sum += d
+        }
+        res.AverageDelay = sum / float64(len(delays))
+        sort.Float64s(delays)
+        res.P90Delay = delays[int(math.Ceil(0.9*float64(len(delays))))-1]
+    }
+    if steps > 0 {
+        res.Utilization = utilSum / float64(steps)
+    }
+    res.Conflicts = []whatIfConflict{}
+    for _, c := range conflicts {
+        res.Conflicts = append(res.Conflicts, *c)
+    }
+    res.Bottlenecks = []string{}
+    for sig := range waitBySig {
+        res.Bottlenecks = append(res.Bottlenecks, sig)
+    }
+    sort.Slice(res.Bottlenecks, func(i, j int) bool {
+        wi, wj := waitBySig[res.Bottlenecks[i]], waitBySig[res.Bottlenecks[j]]
+        if wi != wj {
+            return wi > wj
+        }
+        return res.Bottlenecks[i] < res.Bottlenecks[j]
+    })
+    if len(res.Bottlenecks) > 5 {
+        res.Bottlenecks = res.Bottlenecks[:5]
+    }
+    res.EndTime = s.FormatTime(s.Options.CurrentTime.Time)
+    return res
+}
+
+// trackUtilization returns the percentage of track items occupied by a train
+func trackUtilization(s *simulation.Simulation) float64 {
+    return s.Utilization()
+}
+
+// whatIfRecommendations derives human readable recommendations from the
+// comparison of a scenario with its baseline.
+func whatIfRecommendations(baseline, scenario whatIfResult) []string {
+    recs := []string{}
+    if scenario.AverageDelay > baseline.AverageDelay+1 {
+        recs = append(recs, fmt.Sprintf("Scenario increases average delay by %.1f minutes", scenario.AverageDelay-baseline.AverageDelay))
+    }
+    if scenario.Throughput < baseline.Throughput {
+        recs = append(recs, fmt.Sprintf("Scenario reduces throughput by %d departures", baseline.Throughput-scenario.Throughput))
+    }
+    if len(scenario.Bottlenecks) > 0 {
+        recs = append(recs, fmt.Sprintf("Monitor signal %s where trains wait the longest", scenario.Bottlenecks[0]))
+    }
+    if len(scenario.Conflicts) > len(baseline.Conflicts) {
+        recs = append(recs, "Consider staggering train movements to reduce conflicts")
+    }
+    return recs
+}
+
+// simulateWhatIf clones the running simulation twice, applies the changes to
+// one of the clones and runs both headlessly for the given horizon.
+func simulateWhatIf(live *simulation.Simulation, horizon time.Duration, changes []whatIfChange) (baseline, scenario whatIfResult, err error) {
+    base, err := live.Clone()
+    if err != nil {
+        return
+    }
+    defer base.Close()
+    scen, err := live.Clone()
+    if err != nil {
+        return
+    }
+    defer scen.Close()
+    // Changes may send events, so drain them while applying
+    done := make(chan struct{})
+    go func() {
+        for {
+            select {
+            case <-scen.EventChan:
+            case <-done:
+                return
+            }
+        }
+    }()
+    for _, c := range changes {
+        if err = applyWhatIfChange(scen, c); err != nil {
+            break
+        }
+    }
+    close(done)
+    if err != nil {
+        return
+    }
+    baseline = runWhatIf(base, horizon)
+    scenario = runWhatIf(scen, horizon)
+    return
+}
+
+// evaluateWhatIf runs the given what-if request against the running
+// simulation s and returns the response sent to clients.
+func evaluateWhatIf(s *simulation.Simulation, body whatIfRequest) (map[string]interface{}, error) {
+    if body.DurationMinutes == 0 {
+        body.DurationMinutes = whatIfDefaultHorizon
+    }
+    if body.DurationMinutes < 0 || body.DurationMinutes > whatIfMaxHorizon {
+        return nil, fmt.Errorf("durationMinutes must be between 1 and %d", whatIfMaxHorizon)
+    }
+    startTime := s.FormatTime(s.Options.CurrentTime.Time)
+    baseline, scenario, err := simulateWhatIf(s, time.Duration(body.DurationMinutes)*time.Minute, body.Changes)
+    if err != nil {
+        return nil, err
+    }
+    predictions := map[string]interface{}{
+        "punctuality": scenario.Punctuality,
+        "throughput": scenario.Throughput,
+        "averageDelay": scenario.AverageDelay,
+        "p90Delay": scenario.P90Delay,
+        "utilization": scenario.Utilization,
+        "arrivals": scenario.Arrivals,
+        "trainsExited": scenario.TrainsExited,
+        "bottlenecks": scenario.Bottlenecks,
+        "recommendations": whatIfRecommendations(baseline, scenario),
+    }
+    return map[string]interface{}{
+        "scenarioId": "scenario_" + time.Now().UTC().Format("20060102150405"),
+        "basedOn": map[string]interface{}{"currentTime": startTime},
+        "horizonMinutes": body.DurationMinutes,
+        "endTime": scenario.EndTime,
+        "changes": body.Changes,
+        "baseline": baseline,
+        "predictions": predictions,
+        "conflicts": scenario.Conflicts,
+        "delta": map[string]interface{}{
+            "punctuality": scenario.Punctuality - baseline.Punctuality,
+            "throughput": scenario.Throughput - baseline.Throughput,
+            "averageDelay": scenario.AverageDelay - baseline.AverageDelay,
+            "p90Delay": scenario.P90Delay - baseline.P90Delay,
+            "utilization": scenario.Utilization - baseline.Utilization,
+            "conflicts": len(scenario.Conflicts) - len(baseline.Conflicts),
+        },
+    }, nil
+}
+
+// POST /api/simulation/whatif
+// Clones the running simulation, applies the requested hypothetical changes
+// and runs the clone headlessly to predict KPIs and conflicts.
+func serveWhatIf(w http.ResponseWriter, r *http.Request) {
+    h := requestHub(r)
+    if r.Method != http.MethodPost {
+        methodNotAllowed(w, r)
+        return
+    }
+    if h.sim == nil {
+        simulationNotInitialized(w)
+        return
+    }
+    var body whatIfRequest
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        badRequest(w, err)
+        return
+    }
+    resp, err := evaluateWhatIf(h.sim, body)
+    if err != nil {
+        invalidParameter(w, err.Error(), nil)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    _ = json.NewEncoder(w).Encode(resp)
+}
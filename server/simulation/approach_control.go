@@ -0,0 +1,101 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import "sort"
+
+// DefaultApproachReleaseDistance is the distance in metres before an approach
+// controlled signal within which the approaching train may release it, when no
+// distance is given in the signal custom properties.
+const DefaultApproachReleaseDistance float64 = 200
+
+// registerApproachControlledSignal adds si to the signals whose aspect must be
+// checked at each step because it depends on the approaching train movement.
+func (sim *Simulation) registerApproachControlledSignal(si *SignalItem) {
+	i := sort.Search(len(sim.approachControlled), func(i int) bool {
+		return sim.approachControlled[i].ID() >= si.ID()
+	})
+	if i < len(sim.approachControlled) && sim.approachControlled[i].ID() == si.ID() {
+		return
+	}
+	sim.approachControlled = append(sim.approachControlled, nil)
+	copy(sim.approachControlled[i+1:], sim.approachControlled[i:])
+	sim.approachControlled[i] = si
+}
+
+// updateApproachControlledSignals updates the aspect of the approach controlled
+// signals that have been released or put back to danger since the last step.
+func (sim *Simulation) updateApproachControlledSignals() {
+	for _, si := range sim.approachControlled {
+		if si.Failed() || si.manualOverride {
+			continue
+		}
+		var aspect *SignalAspect
+		switch signalItemManager {
+		case nil:
+			aspect = si.SignalType().GetAspect(si)
+		default:
+			aspect = signalItemManager.GetAspect(si)
+		}
+		if !aspect.Equals(si.activeAspect) {
+			si.updateSignalState()
+		}
+	}
+}
+
+// isWithin returns true if this signal is ahead of pos, at most distance metres away.
+func (si *SignalItem) isWithin(pos Position, distance float64) bool {
+	travelled := -pos.PositionOnTI
+	for ; !pos.IsOut() && travelled <= distance; pos = pos.Next(DirectionCurrent) {
+		if si.IsOnPosition(pos) {
+			return true
+		}
+		travelled += pos.TrackItem().RealLength()
+	}
+	return false
+}
+
+// IsApproachControlled returns true if this signal is held at danger until the
+// approaching train is close and slow enough, i.e. if its type uses the
+// APPROACH_RELEASED condition.
+func (si *SignalItem) IsApproachControlled() bool {
+	_, _, ok := si.approachRelease()
+	return ok
+}
+
+// approachRelease returns the release distance and speed of this signal. If
+// several aspects are approach released, the longest distance and the lowest
+// speed are returned. ok is false if this signal is not approach controlled.
+func (si *SignalItem) approachRelease() (distance float64, speed float64, ok bool) {
+	code := ApproachReleased{}.Code()
+	for _, state := range si.SignalType().States {
+		if _, has := state.Conditions[code]; !has {
+			continue
+		}
+		d, s := approachReleaseParams(si, si.CustomProperties[code][state.AspectName])
+		if !ok || d > distance {
+			distance = d
+		}
+		if !ok || s < speed {
+			speed = s
+		}
+		ok = true
+	}
+	return
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestApproachControl(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing approach controlled signals", t, func() {
+		// Make all proceed aspects of UK_3_ASPECTS signals approach released
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		data = []byte(strings.Replace(string(data), `"NEXT_ROUTE_ACTIVE": []`,
+			`"NEXT_ROUTE_ACTIVE": [], "APPROACH_RELEASED": []`, -1))
+		var sim simulation.Simulation
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		train := sim.Trains[0]
+		sig5 := sim.TrackItems["5"].(*simulation.SignalItem)
+		Convey("Signals should know whether they are approach controlled", func() {
+			So(sig5.IsApproachControlled(), ShouldBeTrue)
+			So(sim.TrackItems["11"].(*simulation.SignalItem).IsApproachControlled(), ShouldBeFalse)
+		})
+		Convey("Signal should be held at danger until the train is close and slow", func() {
+			So(sim.Routes["1"].IsActive(), ShouldBeTrue)
+			So(sig5.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			So(stepUntil(&sim, 500, func() bool {
+				return train.IsActive() && sig5.ActiveAspect().MeansProceed() == false && train.Speed > sim.Options.WarningSpeed
+			}), ShouldBeTrue)
+			So(stepUntil(&sim, 1000, func() bool { return sig5.ActiveAspect().MeansProceed() }), ShouldBeTrue)
+			So(train.Speed, ShouldBeLessThanOrEqualTo, sim.Options.WarningSpeed)
+			// The train then goes through the signal
+			So(stepUntil(&sim, 500, func() bool { return train.TrainHead.TrackItemID == "6" }), ShouldBeTrue)
+		})
+		Convey("Released signals should go back to danger when the train goes away", func() {
+			So(stepUntil(&sim, 1000, func() bool { return sig5.ActiveAspect().MeansProceed() }), ShouldBeTrue)
+			train.Reverse()
+			So(stepUntil(&sim, 50, func() bool { return !sig5.ActiveAspect().MeansProceed() }), ShouldBeTrue)
+		})
+	})
+}
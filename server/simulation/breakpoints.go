@@ -0,0 +1,280 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// BreakpointType is the kind of condition of a Breakpoint
+type BreakpointType string
+
+const (
+	// BreakpointTime pauses the simulation when its time is reached
+	BreakpointTime BreakpointType = "TIME"
+
+	// BreakpointTrainAtPlace pauses the simulation when a train reaches a
+	// place
+	BreakpointTrainAtPlace BreakpointType = "TRAIN_AT_PLACE"
+
+	// BreakpointConflict pauses the simulation each time a conflict is
+	// predicted between a running train and another train
+	BreakpointConflict BreakpointType = "CONFLICT"
+)
+
+// A Breakpoint pauses the running simulation when its condition is met, so
+// that instructors can let a scenario run until a given moment.
+//
+// TIME and TRAIN_AT_PLACE breakpoints are removed once hit. CONFLICT
+// breakpoints stay until they are removed.
+type Breakpoint struct {
+	Type      BreakpointType `json:"type"`
+	Time      Time           `json:"time"`
+	TrainID   string         `json:"trainId"`
+	PlaceCode string         `json:"placeCode"`
+
+	breakpointID string
+	hits         int
+	simulation   *Simulation
+	// conflicts are the conflicts predicted at the last check, keyed by
+	// train ID, so that each one is reported only once
+	conflicts map[string]string
+}
+
+// ID returns the unique identifier of this breakpoint
+func (b *Breakpoint) ID() string {
+	return b.breakpointID
+}
+
+// Hits returns the number of times this breakpoint paused the simulation
+func (b *Breakpoint) Hits() int {
+	return b.hits
+}
+
+// MarshalJSON method for Breakpoint
+func (b *Breakpoint) MarshalJSON() ([]byte, error) {
+	type auxBreakpoint struct {
+		ID        string         `json:"id"`
+		Type      BreakpointType `json:"type"`
+		Time      string         `json:"time,omitempty"`
+		TrainID   string         `json:"trainId,omitempty"`
+		PlaceCode string         `json:"placeCode,omitempty"`
+		Hits      int            `json:"hits"`
+	}
+	return json.Marshal(auxBreakpoint{
+		ID:        b.breakpointID,
+		Type:      b.Type,
+		Time:      formatScheduleTime(b.simulation, b.Time.Time),
+		TrainID:   b.TrainID,
+		PlaceCode: b.PlaceCode,
+		Hits:      b.hits,
+	})
+}
+
+// A BreakpointHit is sent with a BreakpointHitEvent when a breakpoint pauses
+// the simulation.
+type BreakpointHit struct {
+	Breakpoint *Breakpoint `json:"breakpoint"`
+	Reason     string      `json:"reason"`
+	Time       Time        `json:"time"`
+}
+
+// MarshalJSON method for BreakpointHit
+func (bh *BreakpointHit) MarshalJSON() ([]byte, error) {
+	type auxBreakpointHit BreakpointHit
+	return json.Marshal(struct {
+		*auxBreakpointHit
+		Time string `json:"time"`
+	}{
+		auxBreakpointHit: (*auxBreakpointHit)(bh),
+		Time:             formatObjectTime(bh.Breakpoint.simulation, bh.Time.Time),
+	})
+}
+
+// ID returns the ID of the breakpoint that was hit
+func (bh *BreakpointHit) ID() string {
+	return bh.Breakpoint.ID()
+}
+
+// check checks this breakpoint of the simulation sim. It returns the reason
+// why the simulation should pause, or an empty string.
+func (b *Breakpoint) check(sim *Simulation, conflicts func() map[string]string) string {
+	switch b.Type {
+	case BreakpointTime:
+		if !sim.Options.CurrentTime.Time.Before(b.Time.Time) {
+			return fmt.Sprintf("time %s reached", FormatTime(b.Time.Time))
+		}
+	case BreakpointTrainAtPlace:
+		t := sim.Trains[mustAtoi(b.TrainID)]
+		if !t.IsActive() {
+			return ""
+		}
+		if pl := t.TrainHead.TrackItem().Place(); pl != nil && pl.PlaceCode == b.PlaceCode {
+			return fmt.Sprintf("train %s reached %s", t.ServiceCode, pl.Name())
+		}
+	case BreakpointConflict:
+		current := conflicts()
+		var reason string
+		ids := make([]string, 0, len(current))
+		for id := range current {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if b.conflicts[id] != current[id] {
+				reason = fmt.Sprintf("train %s: %s", sim.Trains[mustAtoi(id)].ServiceCode, current[id])
+				break
+			}
+		}
+		b.conflicts = current
+		return reason
+	}
+	return ""
+}
+
+// AddBreakpoint checks the given breakpoint and adds it to the simulation.
+func (sim *Simulation) AddBreakpoint(b *Breakpoint) error {
+	switch b.Type {
+	case BreakpointTime:
+		if b.Time.IsZero() {
+			return fmt.Errorf("time is required for %s breakpoints", b.Type)
+		}
+	case BreakpointTrainAtPlace:
+		i, err := strconv.Atoi(b.TrainID)
+		if err != nil || i < 0 || i >= len(sim.Trains) {
+			return fmt.Errorf("unknown train: %s", b.TrainID)
+		}
+		if _, ok := sim.Places[b.PlaceCode]; !ok {
+			return fmt.Errorf("unknown place: %s", b.PlaceCode)
+		}
+	case BreakpointConflict:
+		b.conflicts = sim.predictedConflicts()
+	default:
+		return fmt.Errorf("unknown breakpoint type: %s", b.Type)
+	}
+	sim.breakpointsMutex.Lock()
+	defer sim.breakpointsMutex.Unlock()
+	if sim.breakpoints == nil {
+		sim.breakpoints = make(map[string]*Breakpoint)
+	}
+	sim.lastBreakpointID++
+	b.breakpointID = strconv.Itoa(sim.lastBreakpointID)
+	b.simulation = sim
+	sim.breakpoints[b.breakpointID] = b
+	return nil
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID from the
+// simulation.
+func (sim *Simulation) RemoveBreakpoint(id string) error {
+	sim.breakpointsMutex.Lock()
+	defer sim.breakpointsMutex.Unlock()
+	if _, ok := sim.breakpoints[id]; !ok {
+		return fmt.Errorf("unknown breakpoint: %s", id)
+	}
+	delete(sim.breakpoints, id)
+	return nil
+}
+
+// Breakpoints returns all the breakpoints of the simulation ordered by ID.
+func (sim *Simulation) Breakpoints() []*Breakpoint {
+	sim.breakpointsMutex.Lock()
+	defer sim.breakpointsMutex.Unlock()
+	return sim.sortedBreakpointsLocked()
+}
+
+func (sim *Simulation) sortedBreakpointsLocked() []*Breakpoint {
+	res := make([]*Breakpoint, 0, len(sim.breakpoints))
+	for _, b := range sim.breakpoints {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, _ := strconv.Atoi(res[i].breakpointID)
+		b, _ := strconv.Atoi(res[j].breakpointID)
+		return a < b
+	})
+	return res
+}
+
+// checkBreakpoints checks all the breakpoints of the simulation after a step
+// and returns true if one of them is hit, in which case the simulation
+// should pause. A BreakpointHitEvent is sent for each breakpoint hit.
+func (sim *Simulation) checkBreakpoints() bool {
+	sim.breakpointsMutex.Lock()
+	defer sim.breakpointsMutex.Unlock()
+	if len(sim.breakpoints) == 0 {
+		return false
+	}
+	var conflicts map[string]string
+	predicted := func() map[string]string {
+		if conflicts == nil {
+			conflicts = sim.predictedConflicts()
+		}
+		return conflicts
+	}
+	hit := false
+	for _, b := range sim.sortedBreakpointsLocked() {
+		reason := b.check(sim, predicted)
+		if reason == "" {
+			continue
+		}
+		hit = true
+		b.hits++
+		if b.Type != BreakpointConflict {
+			delete(sim.breakpoints, b.breakpointID)
+		}
+		sim.MessageLogger.addMessage(fmt.Sprintf("Simulation paused at breakpoint %s: %s", b.breakpointID, reason), simulationMsg)
+		sim.sendEvent(&Event{
+			Name:   BreakpointHitEvent,
+			Object: &BreakpointHit{Breakpoint: b, Reason: reason, Time: Time{Time: sim.Options.CurrentTime.Time}},
+		})
+	}
+	return hit
+}
+
+// predictedConflicts returns the crossing and head-on conflicts that the
+// suggestion engine predicts for running trains before their next signal,
+// keyed by train ID.
+func (sim *Simulation) predictedConflicts() map[string]string {
+	res := make(map[string]string)
+	e := sim.suggestionEngine
+	if e == nil {
+		return res
+	}
+	for _, t := range sim.Trains {
+		if t.Status != Running {
+			continue
+		}
+		nsp := t.NextSignalPosition()
+		if nsp.IsNull() {
+			continue
+		}
+		if pred, reason := e.predictsCrossingConflictAlongPath(t, nsp); pred {
+			res[t.ID()] = reason
+			continue
+		}
+		if pred, reason := e.predictsHeadOnConflictAlongPath(t, nsp); pred {
+			res[t.ID()] = reason
+		}
+	}
+	return res
+}
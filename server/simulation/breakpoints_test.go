@@ -0,0 +1,96 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestBreakpoints(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing simulation breakpoints", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		hits := make(chan *simulation.BreakpointHit, 10)
+		paused := make(chan bool, 10)
+		go func() {
+			for {
+				select {
+				case e := <-sim.EventChan:
+					switch e.Name {
+					case simulation.BreakpointHitEvent:
+						hits <- e.Object.(*simulation.BreakpointHit)
+					case simulation.StateChangedEvent:
+						if !e.Object.(simulation.BoolObject).Value {
+							paused <- true
+						}
+					}
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		So(sim.Initialize(), ShouldBeNil)
+		start := sim.Options.CurrentTime.Time
+		Convey("Invalid breakpoints should be refused", func() {
+			So(sim.AddBreakpoint(&simulation.Breakpoint{Type: "UNKNOWN"}), ShouldNotBeNil)
+			So(sim.AddBreakpoint(&simulation.Breakpoint{Type: simulation.BreakpointTime}), ShouldNotBeNil)
+			So(sim.AddBreakpoint(&simulation.Breakpoint{Type: simulation.BreakpointTrainAtPlace, TrainID: "99", PlaceCode: "STN"}), ShouldNotBeNil)
+			So(sim.AddBreakpoint(&simulation.Breakpoint{Type: simulation.BreakpointTrainAtPlace, TrainID: "0", PlaceCode: "XXX"}), ShouldNotBeNil)
+			So(sim.Breakpoints(), ShouldBeEmpty)
+		})
+		Convey("A time breakpoint should stop fast-forward", func() {
+			b := &simulation.Breakpoint{Type: simulation.BreakpointTime}
+			b.Time.Time = start.Add(2 * time.Minute)
+			So(sim.AddBreakpoint(b), ShouldBeNil)
+			So(b.ID(), ShouldEqual, "1")
+			So(sim.Breakpoints(), ShouldHaveLength, 1)
+			So(sim.FastForward(start.Add(10*time.Minute)), ShouldBeNil)
+			So(sim.Options.CurrentTime.Time, ShouldResemble, start.Add(2*time.Minute))
+			hit := <-hits
+			So(hit.Breakpoint, ShouldEqual, b)
+			So(hit.Reason, ShouldEqual, "time 06:02:00 reached")
+			So(b.Hits(), ShouldEqual, 1)
+			So(sim.Breakpoints(), ShouldBeEmpty)
+		})
+		Convey("A train at place breakpoint should stop when the train reaches the place", func() {
+			b := &simulation.Breakpoint{Type: simulation.BreakpointTrainAtPlace, TrainID: "0", PlaceCode: "STN"}
+			So(sim.AddBreakpoint(b), ShouldBeNil)
+			So(sim.FastForward(start.Add(30*time.Minute)), ShouldBeNil)
+			So(sim.Options.CurrentTime.Time.Before(start.Add(30*time.Minute)), ShouldBeTrue)
+			So(sim.Trains[0].TrainHead.TrackItem().Place().PlaceCode, ShouldEqual, "STN")
+			hit := <-hits
+			So(hit.Breakpoint, ShouldEqual, b)
+		})
+		Convey("A breakpoint should pause the running simulation", func() {
+			b := &simulation.Breakpoint{Type: simulation.BreakpointTime}
+			b.Time.Time = start.Add(sim.Tick())
+			So(sim.AddBreakpoint(b), ShouldBeNil)
+			sim.Start()
+			<-hits
+			<-paused
+			So(sim.IsStarted(), ShouldBeFalse)
+			So(sim.Options.CurrentTime.Time, ShouldResemble, start.Add(sim.Tick()))
+			// Pausing a simulation paused by a breakpoint does nothing
+			sim.Pause()
+		})
+		Convey("Conflict breakpoints should stay until removed", func() {
+			b := &simulation.Breakpoint{Type: simulation.BreakpointConflict}
+			So(sim.AddBreakpoint(b), ShouldBeNil)
+			So(sim.FastForward(start.Add(time.Minute)), ShouldBeNil)
+			So(sim.Breakpoints(), ShouldHaveLength, 1)
+			So(sim.RemoveBreakpoint(b.ID()), ShouldBeNil)
+			So(sim.RemoveBreakpoint(b.ID()), ShouldNotBeNil)
+			So(sim.Breakpoints(), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateLayout is the format of Date values
+const dateLayout = "2006-01-02"
+
+// A Date is a calendar date in the format 2006-01-02
+type Date string
+
+// Validate returns an error if d is not a valid date. An empty date is valid.
+func (d Date) Validate() error {
+	if d == "" {
+		return nil
+	}
+	if _, err := time.Parse(dateLayout, string(d)); err != nil {
+		return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", d)
+	}
+	return nil
+}
+
+// Time returns the start of day d, or a zero time if d is empty or invalid
+func (d Date) Time() time.Time {
+	t, err := time.Parse(dateLayout, string(d))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// weekdays are the names of the days of the week in calendars, indexed by
+// time.Weekday.
+var weekdays = [...]string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// A ServiceCalendar defines the days on which a service runs.
+//
+// Days are the days of the week of the service, among MON, TUE, WED, THU,
+// FRI, SAT and SUN, or every day if empty. ValidFrom and ValidUntil bound the
+// period of the service, and the service does not run on ExceptDates.
+type ServiceCalendar struct {
+	Days        []string `json:"days,omitempty"`
+	ValidFrom   Date     `json:"validFrom,omitempty"`
+	ValidUntil  Date     `json:"validUntil,omitempty"`
+	ExceptDates []Date   `json:"exceptDates,omitempty"`
+}
+
+// Validate returns an error if c is not a valid calendar
+func (c *ServiceCalendar) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, day := range c.Days {
+		if weekday(day) < 0 {
+			return fmt.Errorf("unknown day: %s", day)
+		}
+	}
+	for _, d := range append([]Date{c.ValidFrom, c.ValidUntil}, c.ExceptDates...) {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.ValidFrom != "" && c.ValidUntil != "" && c.ValidUntil.Time().Before(c.ValidFrom.Time()) {
+		return fmt.Errorf("validUntil %s is before validFrom %s", c.ValidUntil, c.ValidFrom)
+	}
+	return nil
+}
+
+// weekday returns the time.Weekday of the given day name, or -1 if unknown
+func weekday(name string) time.Weekday {
+	for i, n := range weekdays {
+		if strings.EqualFold(n, name) {
+			return time.Weekday(i)
+		}
+	}
+	return -1
+}
+
+// RunsOn returns true if the calendar c allows the service to run on date.
+// A nil calendar runs every day.
+func (c *ServiceCalendar) RunsOn(date time.Time) bool {
+	if c == nil {
+		return true
+	}
+	if c.ValidFrom != "" && date.Before(c.ValidFrom.Time()) {
+		return false
+	}
+	if c.ValidUntil != "" && date.After(c.ValidUntil.Time()) {
+		return false
+	}
+	for _, d := range c.ExceptDates {
+		if d.Time().Equal(date) {
+			return false
+		}
+	}
+	if len(c.Days) == 0 {
+		return true
+	}
+	for _, day := range c.Days {
+		if weekday(day) == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// DateOf returns the calendar date of the simulation time h, counting days
+// from Options.StartDate. It returns a zero time if the simulation has no
+// start date.
+func (sim *Simulation) DateOf(h time.Time) time.Time {
+	start := sim.Options.StartDate.Time()
+	if start.IsZero() {
+		return time.Time{}
+	}
+	return start.AddDate(0, 0, dayNumber(h))
+}
+
+// firstScheduledTime returns the first scheduled time of this service, or a
+// zero time if it has none.
+func (s *Service) firstScheduledTime() time.Time {
+	for _, line := range s.Lines {
+		if !line.ScheduledArrivalTime.IsZero() {
+			return line.ScheduledArrivalTime.Time
+		}
+		if !line.ScheduledDepartureTime.IsZero() {
+			return line.ScheduledDepartureTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// normalizeTimes moves the scheduled times of this service that are more
+// than 12 hours earlier than the previous ones to the following day, so that
+// services written across midnight with times of day run in order.
+func (s *Service) normalizeTimes() {
+	var last time.Time
+	normalize := func(t *Time) {
+		if t.IsZero() {
+			return
+		}
+		for !last.IsZero() && last.Sub(t.Time) > 12*time.Hour {
+			t.Time = t.Time.Add(24 * time.Hour)
+		}
+		last = t.Time
+	}
+	for _, line := range s.Lines {
+		normalize(&line.ScheduledArrivalTime)
+		normalize(&line.ScheduledDepartureTime)
+	}
+}
+
+// runDate returns the calendar date of the run of this train, that is the
+// date of the first scheduled time of its service, or of its appear time if
+// the service has no time. It is zero if the simulation has no start date.
+func (t *Train) runDate() time.Time {
+	var h time.Time
+	if s := t.Service(); s != nil {
+		h = s.firstScheduledTime()
+	}
+	if h.IsZero() {
+		h = t.AppearTime.Time
+	}
+	return t.simulation.DateOf(h)
+}
+
+// runsOnCalendar returns true if the service of this train runs on the date
+// of its run.
+func (t *Train) runsOnCalendar() bool {
+	s := t.Service()
+	if s == nil || s.Calendar == nil {
+		return true
+	}
+	date := t.runDate()
+	if date.IsZero() {
+		return true
+	}
+	return s.Calendar.RunsOn(date)
+}
+
+// RealDateTime returns true if the times of this simulation are given as
+// RFC3339 date times, that is if Options.RealDateTime is set and the
+// simulation has a start date.
+func (sim *Simulation) RealDateTime() bool {
+	return sim.Options.RealDateTime && !sim.Options.StartDate.Time().IsZero()
+}
+
+// DateTimeOf returns the date and time of the simulation time h, counting
+// days from Options.StartDate. It returns a zero time if the simulation has no
+// start date or if h is zero.
+func (sim *Simulation) DateTimeOf(h time.Time) time.Time {
+	start := sim.Options.StartDate.Time()
+	if start.IsZero() || h.Before(firstDay) {
+		return time.Time{}
+	}
+	return start.Add(h.Sub(firstDay))
+}
+
+// FormatTime returns the simulation time h as a RFC3339 date time in real
+// date mode, or in the 15:04:05 format of FormatTime otherwise.
+func (sim *Simulation) FormatTime(h time.Time) string {
+	if !sim.RealDateTime() {
+		return FormatTime(h)
+	}
+	dt := sim.DateTimeOf(h)
+	if dt.IsZero() {
+		return FormatTime(h)
+	}
+	return dt.Format(time.RFC3339)
+}
+
+// ParseDateTime returns the simulation time of the RFC3339 date time s. It
+// returns false if s is not a RFC3339 date time, or if the simulation has no
+// start date or s is before it.
+func (sim *Simulation) ParseDateTime(s string) (time.Time, bool) {
+	dt, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return sim.simTimeOf(dt)
+}
+
+// simTimeOf returns the simulation time of the date time dt, counting days
+// from Options.StartDate. It returns false if the simulation has no start date
+// or if dt is before it.
+func (sim *Simulation) simTimeOf(dt time.Time) (time.Time, bool) {
+	start := sim.Options.StartDate.Time()
+	if start.IsZero() || dt.Before(start) {
+		return time.Time{}, false
+	}
+	return firstDay.Add(dt.Sub(start)), true
+}
+
+// formatObjectTime returns h as the objects of sim write it in JSON, that is
+// with sim.FormatTime, or with FormatTime for zero times and objects that do
+// not belong to a simulation yet.
+func formatObjectTime(sim *Simulation, h time.Time) string {
+	if sim == nil || h.IsZero() {
+		return FormatTime(h)
+	}
+	return sim.FormatTime(h)
+}
+
+// rebaseTime turns h into a simulation time if it has been read from a date
+// time, as written by the objects of simulations in real date mode. See
+// Time.UnmarshalJSON.
+func (sim *Simulation) rebaseTime(h *Time) error {
+	if h == nil || !h.isDateTime() {
+		return nil
+	}
+	t, ok := sim.simTimeOf(h.Time)
+	if !ok {
+		return fmt.Errorf("date time %s is not after the start date of the simulation", h.Format(time.RFC3339))
+	}
+	h.Time = t
+	return nil
+}
+
+// rebaseServiceLine turns the date times of line into simulation times, see
+// rebaseTime.
+func (sim *Simulation) rebaseServiceLine(line *ServiceLine) error {
+	for _, h := range []*Time{&line.ScheduledArrivalTime, &line.ScheduledDepartureTime, line.ExpectedDepartureTime} {
+		if err := sim.rebaseTime(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
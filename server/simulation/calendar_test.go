@@ -0,0 +1,147 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestCalendar(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given options and S001
+	// changes.
+	loadSim := func(options map[string]interface{}, s001 map[string]interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			for k, v := range options {
+				raw["options"].(map[string]interface{})[k] = v
+			}
+			srv := raw["services"].(map[string]interface{})["S001"].(map[string]interface{})
+			for k, v := range s001 {
+				srv[k] = v
+			}
+		})
+	}
+	Convey("Testing multi-day simulations", t, func() {
+		Convey("Times past midnight should be times of the following days", func() {
+			h := simulation.ParseTime("25:30:00")
+			So(h.IsZero(), ShouldBeFalse)
+			So(h.Sub(simulation.ParseTime("01:30:00")), ShouldEqual, 24*time.Hour)
+			So(simulation.FormatTime(h.Time), ShouldEqual, "25:30:00")
+			So(simulation.FormatTime(simulation.ParseTime("06:00:00").Time), ShouldEqual, "06:00:00")
+			So(simulation.ParseTime("49:00:00").Sub(simulation.ParseTime("01:00:00")), ShouldEqual, 48*time.Hour)
+			So(simulation.ParseTime("24:61:00").IsZero(), ShouldBeTrue)
+			data, err := json.Marshal(simulation.ParseTime("24:05:00"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `"24:05:00"`)
+		})
+		Convey("Services written across midnight should run in order", func() {
+			sim, err := loadSim(map[string]interface{}{"currentTime": "23:59:00"}, map[string]interface{}{
+				"lines": []interface{}{
+					map[string]interface{}{"placeCode": "LFT", "scheduledDepartureTime": "23:59:30"},
+					map[string]interface{}{"placeCode": "STN", "mustStop": true, "trackCode": "2",
+						"scheduledArrivalTime": "00:00:30", "scheduledDepartureTime": "00:01:00"},
+				},
+			})
+			So(err, ShouldBeNil)
+			s := sim.Services["S001"]
+			So(simulation.FormatTime(s.Lines[1].ScheduledArrivalTime.Time), ShouldEqual, "24:00:30")
+			train := sim.Trains[0]
+			train.AppearTime = simulation.ParseTime("23:59:00")
+			So(stepUntil(sim, 1000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+			So(simulation.FormatTime(sim.Options.CurrentTime.Time), ShouldStartWith, "24:")
+			delay := sim.Options.CurrentTime.Time.Sub(s.Lines[1].ScheduledArrivalTime.Time)
+			So(delay, ShouldBeLessThan, 5*time.Minute)
+			So(delay, ShouldBeGreaterThan, -5*time.Minute)
+		})
+		Convey("Service calendars should be validated", func() {
+			c := &simulation.ServiceCalendar{Days: []string{"MON", "FUNDAY"}}
+			So(c.Validate(), ShouldNotBeNil)
+			c = &simulation.ServiceCalendar{ValidFrom: "2026-10-20", ValidUntil: "2026-10-01"}
+			So(c.Validate(), ShouldNotBeNil)
+			So(simulation.Date("2026-13-01").Validate(), ShouldNotBeNil)
+			_, err := loadSim(nil, map[string]interface{}{"calendar": map[string]interface{}{"days": []string{"XYZ"}}})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Calendars should tell the days on which services run", func() {
+			c := &simulation.ServiceCalendar{
+				Days:        []string{"MON", "TUE", "WED", "THU", "FRI"},
+				ValidFrom:   "2026-10-01",
+				ExceptDates: []simulation.Date{"2026-10-14"},
+			}
+			So(c.Validate(), ShouldBeNil)
+			So(c.RunsOn(simulation.Date("2026-10-13").Time()), ShouldBeTrue)
+			So(c.RunsOn(simulation.Date("2026-10-14").Time()), ShouldBeFalse)
+			So(c.RunsOn(simulation.Date("2026-10-17").Time()), ShouldBeFalse)
+			So(c.RunsOn(simulation.Date("2026-09-30").Time()), ShouldBeFalse)
+			var none *simulation.ServiceCalendar
+			So(none.RunsOn(simulation.Date("2026-10-17").Time()), ShouldBeTrue)
+		})
+		Convey("Trains should not run on the days their service does not run", func() {
+			weekdays := map[string]interface{}{"days": []string{"MON", "TUE", "WED", "THU", "FRI"}}
+			// 2026-10-17 is a Saturday
+			sim, err := loadSim(map[string]interface{}{"startDate": "2026-10-17"}, map[string]interface{}{"calendar": weekdays})
+			So(err, ShouldBeNil)
+			So(sim.DateOf(sim.Options.CurrentTime.Time).Weekday(), ShouldEqual, time.Saturday)
+			train := sim.Trains[0]
+			So(stepUntil(sim, 100, func() bool { return train.Status != simulation.Inactive }), ShouldBeTrue)
+			So(train.Status, ShouldEqual, simulation.NotRunning)
+			So(train.IsActive(), ShouldBeFalse)
+			// The next day of the simulation is still a weekend day, but the
+			// following one is a Monday
+			So(sim.DateOf(simulation.ParseTime("30:00:00").Time).Weekday(), ShouldEqual, time.Sunday)
+			So(sim.DateOf(simulation.ParseTime("54:00:00").Time).Weekday(), ShouldEqual, time.Monday)
+			sim, err = loadSim(map[string]interface{}{"startDate": "2026-10-19"}, map[string]interface{}{"calendar": weekdays})
+			So(err, ShouldBeNil)
+			train = sim.Trains[0]
+			So(stepUntil(sim, 100, train.IsActive), ShouldBeTrue)
+			// Without start date, calendars do not apply
+			sim, err = loadSim(nil, map[string]interface{}{"calendar": map[string]interface{}{"days": []string{"SUN"}}})
+			So(err, ShouldBeNil)
+			So(stepUntil(sim, 100, sim.Trains[0].IsActive), ShouldBeTrue)
+		})
+		Convey("Real date times should be given as RFC3339", func() {
+			sim, err := loadSim(map[string]interface{}{"startDate": "2026-10-17", "realDateTime": true}, nil)
+			So(err, ShouldBeNil)
+			So(sim.RealDateTime(), ShouldBeTrue)
+			So(sim.FormatTime(sim.Options.CurrentTime.Time), ShouldEqual, "2026-10-17T06:00:00Z")
+			So(sim.FormatTime(simulation.ParseTime("25:30:00").Time), ShouldEqual, "2026-10-18T01:30:00Z")
+			h, ok := sim.ParseDateTime("2026-10-18T01:30:00Z")
+			So(ok, ShouldBeTrue)
+			So(simulation.FormatTime(h), ShouldEqual, "25:30:00")
+			_, ok = sim.ParseDateTime("2026-10-16T23:00:00Z")
+			So(ok, ShouldBeFalse)
+			_, ok = sim.ParseDateTime("06:00:00")
+			So(ok, ShouldBeFalse)
+
+			// Objects are written with date times and read back
+			data, err := json.Marshal(sim.Trains[0])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"appearTime":"2026-10-17T06:00:00Z"`)
+			data, err = json.Marshal(sim.Services["S001"])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"scheduledDepartureTime":"2026-10-17T06:00:30Z"`)
+			data, err = json.Marshal(&sim.Options)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"currentTime":"2026-10-17T06:00:00Z"`)
+			data, err = json.Marshal(sim)
+			So(err, ShouldBeNil)
+			var reloaded simulation.Simulation
+			So(json.Unmarshal(data, &reloaded), ShouldBeNil)
+			So(simulation.FormatTime(reloaded.Options.CurrentTime.Time), ShouldEqual, "06:00:00")
+			So(simulation.FormatTime(reloaded.Trains[0].AppearTime.Time), ShouldEqual, "06:00:00")
+			So(simulation.FormatTime(reloaded.Services["S001"].Lines[0].ScheduledDepartureTime.Time), ShouldEqual, "06:00:30")
+			// Without start date, times stay times of day
+			sim, err = loadSim(map[string]interface{}{"realDateTime": true}, nil)
+			So(err, ShouldBeNil)
+			So(sim.RealDateTime(), ShouldBeFalse)
+			So(sim.FormatTime(sim.Options.CurrentTime.Time), ShouldEqual, "06:00:00")
+		})
+	})
+}
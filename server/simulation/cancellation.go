@@ -0,0 +1,96 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import "fmt"
+
+// Cancel cancels this train and removes it from the area.
+//
+// The train leaves the track items it occupies. The routes set on these items
+// and the route set from its next signal are released, unless they are
+// persistent or another train is on them. The train gets the Cancelled status
+// and will not enter the area if it was not active yet.
+func (t *Train) Cancel() error {
+	switch t.Status {
+	case Out, Joined, Cancelled:
+		return fmt.Errorf("train %s is not in the simulation anymore", t.ID())
+	}
+	items := make(map[TrackItem]bool)
+	if t.isOnLine() {
+		routes := make(map[string]*Route)
+		for _, ti := range t.trainTrackItems() {
+			items[ti] = true
+			if r := ti.ActiveRoute(); r != nil {
+				routes[r.ID()] = r
+			}
+		}
+		if ns := t.findNextSignal(); ns != nil {
+			if ns.train == t {
+				ns.setTrain(nil)
+			}
+			if r := ns.nextActiveRoute; r != nil {
+				routes[r.ID()] = r
+			}
+		}
+		t.vacate()
+		for _, r := range routes {
+			if r.State() == Persistent || routeHasAnyTrain(r) {
+				continue
+			}
+			_ = r.Deactivate()
+		}
+	}
+	t.Status = Cancelled
+	t.Speed = 0
+	t.NextPlaceIndex = NoMorePlace
+
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s (%s) cancelled", t.ID(), t.ServiceCode), simulationMsg)
+	for ti := range items {
+		t.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: ti})
+	}
+	t.simulation.sendEvent(&Event{Name: TrainChangedEvent, Object: t})
+	t.simulation.sendEvent(&Event{Name: TrainCancelledEvent, Object: t})
+	t.simulation.missTransfers(t.ServiceCode)
+	if t.simulation.suggestionEngine != nil && t.simulation.Options.SuggestionsEnabled {
+		t.simulation.suggestionEngine.Recompute()
+	}
+	return nil
+}
+
+// CancelService cancels all the trains running the service with the given
+// code that have not finished it, and returns them.
+func (sim *Simulation) CancelService(code string) ([]*Train, error) {
+	if _, ok := sim.Services[code]; !ok {
+		return nil, fmt.Errorf("unknown service: %s", code)
+	}
+	var cancelled []*Train
+	for _, t := range sim.trainsOfService(code) {
+		if t.Status == Out {
+			continue
+		}
+		if err := t.Cancel(); err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, t)
+	}
+	if len(cancelled) == 0 {
+		return nil, fmt.Errorf("no train runs service %s", code)
+	}
+	return cancelled, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestCancellation(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing train cancellation", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		So(sim.FastForward(sim.Options.CurrentTime.Time.Add(5*time.Second)), ShouldBeNil)
+		train := sim.Trains[0]
+		So(train.IsActive(), ShouldBeTrue)
+		So(sim.TrackItems["2"].TrainPresent(), ShouldBeTrue)
+		So(sim.Routes["1"].State(), ShouldEqual, simulation.Activated)
+		Convey("A cancelled train should leave the area and release its routes", func() {
+			So(train.Cancel(), ShouldBeNil)
+			So(train.Status, ShouldEqual, simulation.Cancelled)
+			So(train.IsActive(), ShouldBeFalse)
+			So(train.NextPlaceIndex, ShouldEqual, simulation.NoMorePlace)
+			So(sim.TrackItems["2"].TrainPresent(), ShouldBeFalse)
+			So(sim.Routes["1"].State(), ShouldEqual, simulation.Deactivated)
+			So(train.Cancel(), ShouldNotBeNil)
+			Convey("And stay out of the area", func() {
+				So(sim.FastForward(sim.Options.CurrentTime.Time.Add(10*time.Second)), ShouldBeNil)
+				So(train.Status, ShouldEqual, simulation.Cancelled)
+				So(sim.TrackItems["2"].TrainPresent(), ShouldBeFalse)
+			})
+		})
+		Convey("Cancelling a service should cancel its trains not in the area yet", func() {
+			inactive := sim.Trains[1]
+			So(inactive.Status, ShouldEqual, simulation.Inactive)
+			trains, err := sim.CancelService("S003")
+			So(err, ShouldBeNil)
+			So(trains, ShouldHaveLength, 1)
+			So(trains[0], ShouldEqual, inactive)
+			So(inactive.Status, ShouldEqual, simulation.Cancelled)
+			So(sim.FastForward(sim.Options.CurrentTime.Time.Add(4*time.Minute)), ShouldBeNil)
+			So(inactive.Status, ShouldEqual, simulation.Cancelled)
+			_, err = sim.CancelService("S003")
+			So(err, ShouldNotBeNil)
+			_, err = sim.CancelService("XXX")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
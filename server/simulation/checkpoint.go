@@ -0,0 +1,640 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// checkpointFormat is the version of the checkpoint format. It is increased
+// each time the format changes in an incompatible way.
+const checkpointFormat = 1
+
+// checkpoint is the serialized form of a running simulation. Simulation holds
+// the simulation as it would be saved to a file, and State the internal state
+// that this file does not hold.
+type checkpoint struct {
+	Format     int             `json:"format"`
+	Simulation json.RawMessage `json:"simulation"`
+	State      checkpointState `json:"state"`
+}
+
+// checkpointState is the internal state of a running simulation. Objects are
+// referred to by their IDs and times keep their date.
+type checkpointState struct {
+	CurrentTime            time.Time                             `json:"currentTime"`
+	RandomDraws            uint64                                `json:"randomDraws"`
+	Trains                 []trainState                          `json:"trains"`
+	TrackItems             map[string]trackItemState             `json:"trackItems"`
+	Disruptions            []restrictionState                    `json:"disruptions"`
+	LastDisruptionID       int                                   `json:"lastDisruptionId"`
+	SpeedRestrictions      []restrictionState                    `json:"speedRestrictions"`
+	LastSpeedRestrictionID int                                   `json:"lastSpeedRestrictionId"`
+	Possessions            []restrictionState                    `json:"possessions"`
+	LastPossessionID       int                                   `json:"lastPossessionId"`
+	PerturbationStats      map[PerturbationKind]PerturbationStat `json:"perturbationStats"`
+	Transfers              map[string]transferState              `json:"transfers,omitempty"`
+	Overlaps               map[string]overlapState               `json:"overlaps,omitempty"`
+	Suggestions            suggestionsState                      `json:"suggestions"`
+}
+
+// trainState is the internal state of a train
+type trainState struct {
+	ID              string        `json:"id"`
+	EffInitialDelay time.Duration `json:"effInitialDelay"`
+	MinStopTime     time.Duration `json:"minStopTime"`
+	SignalActions   [][3]float64  `json:"signalActions"`
+	ActionIndex     int           `json:"actionIndex"`
+	ActionTime      time.Time     `json:"actionTime"`
+	LastSignal      string        `json:"lastSignal,omitempty"`
+	IgnoredSignal   string        `json:"ignoredSignal,omitempty"`
+	HeldUntil       time.Time     `json:"heldUntil"`
+	Performance     float64       `json:"performance"`
+	DegradedUntil   time.Time     `json:"degradedUntil"`
+	EntryPerturbed  bool          `json:"entryPerturbed"`
+	TractionEnergy  float64       `json:"tractionEnergy"`
+	CoastSpeed      float64       `json:"coastSpeed"`
+	Boarded         float64       `json:"boarded"`
+	Alighted        float64       `json:"alighted"`
+	PassengerDwell  time.Duration `json:"passengerDwell"`
+	Shunting        bool          `json:"shunting,omitempty"`
+	ShuntRoute      string        `json:"shuntRoute,omitempty"`
+	Depot           string        `json:"depot,omitempty"`
+	Withdrawn       bool          `json:"withdrawn,omitempty"`
+	Overspeeding    bool          `json:"overspeeding,omitempty"`
+}
+
+// trackItemState is the internal state of a track item. Points and signals
+// fields are only used for these items.
+type trackItemState struct {
+	ActiveRoute      string             `json:"activeRoute,omitempty"`
+	ARPreviousItem   string             `json:"arPreviousItem,omitempty"`
+	Blocked          bool               `json:"blocked,omitempty"`
+	DetectionFailure string             `json:"detectionFailure,omitempty"`
+	SpeedLimit       float64            `json:"speedLimit,omitempty"`
+	TrainEndsFW      map[string]float64 `json:"trainEndsFW,omitempty"`
+	TrainEndsBK      map[string]float64 `json:"trainEndsBK,omitempty"`
+
+	Direction    *PointDirection `json:"direction,omitempty"`
+	Locked       bool            `json:"locked,omitempty"`
+	FailureMode  string          `json:"failureMode,omitempty"`
+	FailureCause string          `json:"failureCause,omitempty"`
+	RepairAt     time.Time       `json:"repairAt"`
+
+	Train               string    `json:"train,omitempty"`
+	PreviousActiveRoute string    `json:"previousActiveRoute,omitempty"`
+	NextActiveRoute     string    `json:"nextActiveRoute,omitempty"`
+	ActiveAspect        string    `json:"activeAspect,omitempty"`
+	ManualOverride      bool      `json:"manualOverride,omitempty"`
+	ManualAspect        string    `json:"manualAspect,omitempty"`
+	Failed              bool      `json:"failed,omitempty"`
+	LastChanged         time.Time `json:"lastChanged"`
+
+	WaitingPassengers float64   `json:"waitingPassengers,omitempty"`
+	LastBoarding      time.Time `json:"lastBoarding"`
+
+	LevelCrossing *levelCrossingState `json:"levelCrossing,omitempty"`
+}
+
+// levelCrossingState is the state of the barriers of a level crossing.
+// Failures are stored in the trackItemState fields.
+type levelCrossingState struct {
+	State        LevelCrossingState   `json:"state"`
+	Since        time.Time            `json:"since"`
+	Manual       bool                 `json:"manual,omitempty"`
+	ManualClosed bool                 `json:"manualClosed,omitempty"`
+	Metrics      LevelCrossingMetrics `json:"metrics"`
+}
+
+// restrictionState is the state of a disruption, a speed restriction or a
+// possession.
+type restrictionState struct {
+	ID            string         `json:"id"`
+	Type          DisruptionType `json:"type,omitempty"`
+	TrackItemID   string         `json:"trackItemId"`
+	ToTrackItemID string         `json:"toTrackItemId"`
+	SpeedLimit    float64        `json:"speedLimit,omitempty"`
+	StartTime     time.Time      `json:"startTime"`
+	EndTime       time.Time      `json:"endTime"`
+	Reason        string         `json:"reason"`
+	FailureMode   string         `json:"failureMode,omitempty"`
+	Items         []string       `json:"items"`
+	Active        bool           `json:"active"`
+	Pending       bool           `json:"pending,omitempty"`
+}
+
+// transferState is the state of a transfer
+type transferState struct {
+	Status        TransferStatus `json:"status"`
+	ArrivalTime   time.Time      `json:"arrivalTime"`
+	DepartureTime time.Time      `json:"departureTime"`
+}
+
+// overlapState is the state of the locked overlap of a route
+type overlapState struct {
+	ReleaseAt time.Time `json:"releaseAt"`
+}
+
+// suggestionsState is the state of the suggestion engine
+type suggestionsState struct {
+	LastComputedAt time.Time            `json:"lastComputedAt"`
+	RejectedUntil  map[string]time.Time `json:"rejectedUntil"`
+	GeneratedAt    time.Time            `json:"generatedAt"`
+	Items          []Suggestion         `json:"items"`
+}
+
+// Checkpoint returns the complete state of this simulation as JSON: the
+// simulation data, the clock with its date, trains, active routes, points
+// directions, signal aspects, failures, restrictions, the suggestion engine
+// and the position of the random generator.
+//
+// The simulation should be paused while the checkpoint is taken. Use
+// RestoreCheckpoint to rebuild a simulation from the checkpoint.
+func (sim *Simulation) Checkpoint() ([]byte, error) {
+	data, err := json.Marshal(sim)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize simulation: %s", err)
+	}
+	cp := checkpoint{
+		Format:     checkpointFormat,
+		Simulation: data,
+		State: checkpointState{
+			CurrentTime: sim.Options.CurrentTime.Time,
+			RandomDraws: sim.randomDraws(),
+			TrackItems:  make(map[string]trackItemState, len(sim.TrackItems)),
+		},
+	}
+	for _, t := range sim.Trains {
+		cp.State.Trains = append(cp.State.Trains, t.checkpointState())
+	}
+	for id, ti := range sim.TrackItems {
+		cp.State.TrackItems[id] = trackItemCheckpointState(ti)
+	}
+	sim.disruptionsMutex.RLock()
+	for _, d := range sim.disruptions {
+		cp.State.Disruptions = append(cp.State.Disruptions, restrictionState{
+			ID:            d.disruptionID,
+			Type:          d.Type,
+			TrackItemID:   d.TrackItemID,
+			ToTrackItemID: d.ToTrackItemID,
+			SpeedLimit:    d.SpeedLimit,
+			StartTime:     d.StartTime.Time,
+			EndTime:       d.EndTime.Time,
+			Reason:        d.Reason,
+			FailureMode:   string(d.FailureMode),
+			Items:         d.items,
+			Active:        d.active,
+		})
+	}
+	cp.State.LastDisruptionID = sim.lastDisruptionID
+	for _, sr := range sim.speedRestrictions {
+		cp.State.SpeedRestrictions = append(cp.State.SpeedRestrictions, restrictionState{
+			ID:            sr.restrictionID,
+			TrackItemID:   sr.TrackItemID,
+			ToTrackItemID: sr.ToTrackItemID,
+			SpeedLimit:    sr.SpeedLimit,
+			StartTime:     sr.StartTime.Time,
+			EndTime:       sr.EndTime.Time,
+			Reason:        sr.Reason,
+			Items:         sr.items,
+			Active:        sr.active,
+		})
+	}
+	cp.State.LastSpeedRestrictionID = sim.lastSpeedRestrictionID
+	for _, p := range sim.possessions {
+		cp.State.Possessions = append(cp.State.Possessions, restrictionState{
+			ID:            p.possessionID,
+			TrackItemID:   p.TrackItemID,
+			ToTrackItemID: p.ToTrackItemID,
+			StartTime:     p.StartTime.Time,
+			EndTime:       p.EndTime.Time,
+			Reason:        p.Reason,
+			Items:         p.items,
+			Active:        p.active,
+			Pending:       p.pending,
+		})
+	}
+	cp.State.LastPossessionID = sim.lastPossessionID
+	sim.disruptionsMutex.RUnlock()
+	for _, rs := range [][]restrictionState{cp.State.Disruptions, cp.State.SpeedRestrictions, cp.State.Possessions} {
+		sort.Slice(rs, func(i, j int) bool {
+			return rs[i].ID < rs[j].ID
+		})
+	}
+	cp.State.PerturbationStats = sim.PerturbationStats()
+	if len(sim.transfers) > 0 {
+		cp.State.Transfers = make(map[string]transferState, len(sim.transfers))
+		for id, tr := range sim.transfers {
+			cp.State.Transfers[id] = transferState{
+				Status:        tr.status,
+				ArrivalTime:   tr.arrivalTime,
+				DepartureTime: tr.departureTime,
+			}
+		}
+	}
+	for _, r := range sim.lockedOverlaps() {
+		if cp.State.Overlaps == nil {
+			cp.State.Overlaps = make(map[string]overlapState)
+		}
+		cp.State.Overlaps[r.ID()] = overlapState{ReleaseAt: r.overlapReleaseAt}
+	}
+	if e := sim.suggestionEngine; e != nil {
+		cp.State.Suggestions.LastComputedAt = e.lastComputedAt.Time
+		cp.State.Suggestions.RejectedUntil = make(map[string]time.Time, len(e.rejectedUntil))
+		for id := range e.rejectedUntil {
+			cp.State.Suggestions.RejectedUntil[id] = e.rejectedUntil[id].Time
+		}
+	}
+	if sim.Suggestions != nil {
+		cp.State.Suggestions.GeneratedAt = sim.Suggestions.GeneratedAt.Time
+		cp.State.Suggestions.Items = sim.Suggestions.Items
+	}
+	return json.Marshal(cp)
+}
+
+// checkpointState returns the internal state of this train
+func (t *Train) checkpointState() trainState {
+	ts := trainState{
+		ID:              t.trainID,
+		EffInitialDelay: t.effInitialDelay,
+		MinStopTime:     t.minStopTime,
+		ActionIndex:     t.actionIndex,
+		ActionTime:      t.actionTime.Time,
+		HeldUntil:       t.heldUntil.Time,
+		Performance:     t.performance,
+		DegradedUntil:   t.degradedUntil.Time,
+		EntryPerturbed:  t.entryPerturbed,
+		TractionEnergy:  t.tractionEnergy,
+		CoastSpeed:      t.coastSpeed,
+		Boarded:         t.boarded,
+		Alighted:        t.alighted,
+		PassengerDwell:  t.passengerDwell,
+		Shunting:        t.shunting,
+		ShuntRoute:      t.shuntRoute,
+		Depot:           t.depot,
+		Withdrawn:       t.withdrawn,
+		Overspeeding:    t.overspeeding,
+	}
+	for _, sa := range t.signalActions {
+		ts.SignalActions = append(ts.SignalActions, [3]float64{float64(sa.Target), sa.Speed, float64(sa.Duration)})
+	}
+	if t.lastSignal != nil {
+		ts.LastSignal = t.lastSignal.ID()
+	}
+	if t.ignoredSignal != nil {
+		ts.IgnoredSignal = t.ignoredSignal.ID()
+	}
+	return ts
+}
+
+// trackItemCheckpointState returns the internal state of the given item
+func trackItemCheckpointState(ti TrackItem) trackItemState {
+	u := ti.underlying()
+	var ts trackItemState
+	if u.activeRoute != nil {
+		ts.ActiveRoute = u.activeRoute.ID()
+	}
+	if u.arPreviousItem != nil {
+		ts.ARPreviousItem = u.arPreviousItem.ID()
+	}
+	ts.Blocked = u.blocked
+	ts.DetectionFailure = string(u.detectionFailure)
+	ts.SpeedLimit = u.speedLimit
+	u.trainEndMutex.RLock()
+	if len(u.trainEndsFW) > 0 {
+		ts.TrainEndsFW = make(map[string]float64, len(u.trainEndsFW))
+		for t, v := range u.trainEndsFW {
+			ts.TrainEndsFW[t.ID()] = v
+		}
+	}
+	if len(u.trainEndsBK) > 0 {
+		ts.TrainEndsBK = make(map[string]float64, len(u.trainEndsBK))
+		for t, v := range u.trainEndsBK {
+			ts.TrainEndsBK[t.ID()] = v
+		}
+	}
+	u.trainEndMutex.RUnlock()
+	switch v := ti.(type) {
+	case *Place:
+		ts.WaitingPassengers = v.waitingPassengers
+		ts.LastBoarding = v.lastBoarding
+	case *LevelCrossingItem:
+		ts.LevelCrossing = &levelCrossingState{
+			State:        v.state,
+			Since:        v.since,
+			Manual:       v.manual,
+			ManualClosed: v.manualClosed,
+			Metrics:      v.metrics,
+		}
+		ts.FailureMode = string(v.failureMode)
+		ts.FailureCause = v.failureCause
+		ts.RepairAt = v.repairAt
+	case *PointsItem:
+		if pointsItemManager != nil {
+			dir := pointsItemManager.Direction(v)
+			ts.Direction = &dir
+		}
+		ts.Locked = v.locked
+		ts.FailureMode = string(v.failureMode)
+		ts.FailureCause = v.failureCause
+		ts.RepairAt = v.repairAt
+	case *SignalItem:
+		if v.train != nil {
+			ts.Train = v.train.ID()
+		}
+		if v.previousActiveRoute != nil {
+			ts.PreviousActiveRoute = v.previousActiveRoute.ID()
+		}
+		if v.nextActiveRoute != nil {
+			ts.NextActiveRoute = v.nextActiveRoute.ID()
+		}
+		if v.activeAspect != nil {
+			ts.ActiveAspect = v.activeAspect.Name
+		}
+		ts.ManualOverride = v.manualOverride
+		if v.manualAspect != nil {
+			ts.ManualAspect = v.manualAspect.Name
+		}
+		ts.Failed = v.failed
+		ts.FailureMode = string(v.failureMode)
+		ts.FailureCause = v.failureCause
+		ts.RepairAt = v.repairAt
+		ts.LastChanged = v.lastChanged
+	}
+	return ts
+}
+
+// RestoreCheckpoint rebuilds a simulation from data returned by Checkpoint.
+//
+// The returned simulation is initialized, in the state it was when the
+// checkpoint was taken, and its clock is not started. It must not be
+// initialized again.
+func RestoreCheckpoint(data []byte) (*Simulation, error) {
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("unable to decode checkpoint: %s", err)
+	}
+	if cp.Format != checkpointFormat {
+		return nil, fmt.Errorf("unsupported checkpoint format %d", cp.Format)
+	}
+	sim := new(Simulation)
+	if err := json.Unmarshal(cp.Simulation, sim); err != nil {
+		return nil, fmt.Errorf("unable to rebuild simulation: %s", err)
+	}
+	st := cp.State
+	sim.Options.CurrentTime.Time = st.CurrentTime
+
+	// Trains are rebuilt one by one to keep the same order and IDs
+	var rawTrains struct {
+		Trains []json.RawMessage `json:"trains"`
+	}
+	if err := json.Unmarshal(cp.Simulation, &rawTrains); err != nil {
+		return nil, fmt.Errorf("unable to decode trains: %s", err)
+	}
+	if len(rawTrains.Trains) != len(st.Trains) {
+		return nil, fmt.Errorf("inconsistent checkpoint: %d trains for %d train states", len(rawTrains.Trains), len(st.Trains))
+	}
+	sim.Trains = make([]*Train, len(rawTrains.Trains))
+	trains := make(map[string]*Train, len(sim.Trains))
+	for i, td := range rawTrains.Trains {
+		t := new(Train)
+		if err := json.Unmarshal(td, t); err != nil {
+			return nil, fmt.Errorf("unable to rebuild train %d: %s", i, err)
+		}
+		t.setSimulation(sim)
+		t.trainManager = defaultTrainManager
+		ts := st.Trains[i]
+		t.trainID = ts.ID
+		t.effInitialDelay = ts.EffInitialDelay
+		t.minStopTime = ts.MinStopTime
+		for _, sa := range ts.SignalActions {
+			t.signalActions = append(t.signalActions, SignalAction{
+				Target:   ActionTarget(sa[0]),
+				Speed:    sa[1],
+				Duration: time.Duration(sa[2]),
+			})
+		}
+		t.actionIndex = ts.ActionIndex
+		t.actionTime.Time = ts.ActionTime
+		t.heldUntil.Time = ts.HeldUntil
+		t.performance = ts.Performance
+		t.degradedUntil.Time = ts.DegradedUntil
+		t.entryPerturbed = ts.EntryPerturbed
+		t.tractionEnergy = ts.TractionEnergy
+		t.coastSpeed = ts.CoastSpeed
+		t.boarded = ts.Boarded
+		t.alighted = ts.Alighted
+		t.passengerDwell = ts.PassengerDwell
+		t.shunting = ts.Shunting
+		t.shuntRoute = ts.ShuntRoute
+		t.depot = ts.Depot
+		t.withdrawn = ts.Withdrawn
+		t.overspeeding = ts.Overspeeding
+		sim.Trains[i] = t
+		trains[t.trainID] = t
+	}
+	signal := func(id string) *SignalItem {
+		si, _ := sim.TrackItems[id].(*SignalItem)
+		return si
+	}
+	for i, ts := range st.Trains {
+		sim.Trains[i].lastSignal = signal(ts.LastSignal)
+		sim.Trains[i].ignoredSignal = signal(ts.IgnoredSignal)
+	}
+
+	// Routes are initialized without activation, their state is restored
+	// from the track items below.
+	for num, r := range sim.Routes {
+		if err := r.initialize(num); err != nil {
+			return nil, fmt.Errorf("error initializing route %s: %s", num, err)
+		}
+	}
+	sim.computeRouteConflicts()
+	sim.occupancy = newOccupancyIndex(sim)
+	for id, ts := range st.TrackItems {
+		ti, ok := sim.TrackItems[id]
+		if !ok {
+			return nil, fmt.Errorf("inconsistent checkpoint: unknown track item %s", id)
+		}
+		ti.restoreCheckpointState(ts, trains)
+	}
+
+	sim.lastDisruptionID = st.LastDisruptionID
+	sim.disruptions = make(map[string]*Disruption, len(st.Disruptions))
+	for _, rs := range st.Disruptions {
+		d := &Disruption{
+			Type:          rs.Type,
+			TrackItemID:   rs.TrackItemID,
+			ToTrackItemID: rs.ToTrackItemID,
+			SpeedLimit:    rs.SpeedLimit,
+			Reason:        rs.Reason,
+			FailureMode:   TrackCircuitFailureMode(rs.FailureMode),
+			disruptionID:  rs.ID,
+			items:         rs.Items,
+			active:        rs.Active,
+			simulation:    sim,
+		}
+		d.StartTime.Time = rs.StartTime
+		d.EndTime.Time = rs.EndTime
+		sim.disruptions[rs.ID] = d
+	}
+	sim.lastSpeedRestrictionID = st.LastSpeedRestrictionID
+	sim.speedRestrictions = make(map[string]*SpeedRestriction, len(st.SpeedRestrictions))
+	for _, rs := range st.SpeedRestrictions {
+		sr := &SpeedRestriction{
+			TrackItemID:   rs.TrackItemID,
+			ToTrackItemID: rs.ToTrackItemID,
+			SpeedLimit:    rs.SpeedLimit,
+			Reason:        rs.Reason,
+			restrictionID: rs.ID,
+			items:         rs.Items,
+			active:        rs.Active,
+			simulation:    sim,
+		}
+		sr.StartTime.Time = rs.StartTime
+		sr.EndTime.Time = rs.EndTime
+		sim.speedRestrictions[rs.ID] = sr
+	}
+	sim.lastPossessionID = st.LastPossessionID
+	sim.possessions = make(map[string]*Possession, len(st.Possessions))
+	for _, rs := range st.Possessions {
+		p := &Possession{
+			TrackItemID:   rs.TrackItemID,
+			ToTrackItemID: rs.ToTrackItemID,
+			Reason:        rs.Reason,
+			possessionID:  rs.ID,
+			items:         rs.Items,
+			active:        rs.Active,
+			pending:       rs.Pending,
+			simulation:    sim,
+		}
+		p.StartTime.Time = rs.StartTime
+		p.EndTime.Time = rs.EndTime
+		sim.possessions[rs.ID] = p
+	}
+
+	sim.perturbationStats = st.PerturbationStats
+	for id, ts := range st.Transfers {
+		tr, ok := sim.transfers[id]
+		if !ok {
+			return nil, fmt.Errorf("inconsistent checkpoint: unknown transfer %s", id)
+		}
+		tr.status = ts.Status
+		tr.arrivalTime = ts.ArrivalTime
+		tr.departureTime = ts.DepartureTime
+	}
+	for id, ov := range st.Overlaps {
+		r, ok := sim.Routes[id]
+		if !ok {
+			return nil, fmt.Errorf("inconsistent checkpoint: unknown route %s", id)
+		}
+		r.overlapLocked = true
+		r.overlapReleaseAt = ov.ReleaseAt
+	}
+	sim.suggestionEngine = NewSuggestionEngine(sim)
+	sim.suggestionEngine.lastComputedAt.Time = st.Suggestions.LastComputedAt
+	for id, t := range st.Suggestions.RejectedUntil {
+		sim.suggestionEngine.rejectedUntil[id] = Time{Time: t}
+	}
+	if st.Suggestions.Items != nil {
+		sim.Suggestions = &Suggestions{Items: st.Suggestions.Items, simulation: sim}
+		sim.Suggestions.GeneratedAt.Time = st.Suggestions.GeneratedAt
+	}
+	sim.restoreRandom(st.RandomDraws)
+	return sim, nil
+}
+
+// restoreCheckpointState sets the internal state of this item from ts.
+// trains are the trains of the simulation by ID.
+func (t *trackStruct) restoreCheckpointState(ts trackItemState, trains map[string]*Train) {
+	t.activeRoute = t.simulation.Routes[ts.ActiveRoute]
+	t.arPreviousItem = nil
+	if ts.ARPreviousItem != "" {
+		t.arPreviousItem = t.simulation.TrackItems[ts.ARPreviousItem]
+	}
+	t.blocked = ts.Blocked
+	t.detectionFailure = TrackCircuitFailureMode(ts.DetectionFailure)
+	t.speedLimit = ts.SpeedLimit
+	t.trainEndMutex.Lock()
+	t.trainEndsFW = make(map[*Train]float64)
+	for id, v := range ts.TrainEndsFW {
+		t.trainEndsFW[trains[id]] = v
+	}
+	t.trainEndsBK = make(map[*Train]float64)
+	for id, v := range ts.TrainEndsBK {
+		t.trainEndsBK[trains[id]] = v
+	}
+	t.indexTrains()
+	t.trainEndMutex.Unlock()
+}
+
+// restoreCheckpointState sets the internal state of this place from ts
+func (pl *Place) restoreCheckpointState(ts trackItemState, trains map[string]*Train) {
+	pl.trackStruct.restoreCheckpointState(ts, trains)
+	pl.waitingPassengers = ts.WaitingPassengers
+	pl.lastBoarding = ts.LastBoarding
+}
+
+// restoreCheckpointState sets the internal state of this level crossing from ts
+func (lc *LevelCrossingItem) restoreCheckpointState(ts trackItemState, trains map[string]*Train) {
+	lc.trackStruct.restoreCheckpointState(ts, trains)
+	if ts.LevelCrossing != nil {
+		lc.state = ts.LevelCrossing.State
+		lc.since = ts.LevelCrossing.Since
+		lc.manual = ts.LevelCrossing.Manual
+		lc.manualClosed = ts.LevelCrossing.ManualClosed
+		lc.metrics = ts.LevelCrossing.Metrics
+	}
+	lc.failureMode = LevelCrossingFailureMode(ts.FailureMode)
+	lc.failureCause = ts.FailureCause
+	lc.repairAt = ts.RepairAt
+}
+
+// restoreCheckpointState sets the internal state of these points from ts
+func (pi *PointsItem) restoreCheckpointState(ts trackItemState, trains map[string]*Train) {
+	pi.trackStruct.restoreCheckpointState(ts, trains)
+	if ts.Direction != nil && pointsItemManager != nil {
+		pointsItemManager.SetDirection(pi, *ts.Direction)
+	}
+	pi.locked = ts.Locked
+	pi.failureMode = PointsFailureMode(ts.FailureMode)
+	pi.failureCause = ts.FailureCause
+	pi.repairAt = ts.RepairAt
+}
+
+// restoreCheckpointState sets the internal state of this signal from ts
+func (si *SignalItem) restoreCheckpointState(ts trackItemState, trains map[string]*Train) {
+	si.trackStruct.restoreCheckpointState(ts, trains)
+	si.train = trains[ts.Train]
+	si.previousActiveRoute = si.simulation.Routes[ts.PreviousActiveRoute]
+	si.nextActiveRoute = si.simulation.Routes[ts.NextActiveRoute]
+	if aspect, ok := si.simulation.SignalLib.Aspects[ts.ActiveAspect]; ok {
+		si.activeAspect = aspect
+	}
+	si.manualOverride = ts.ManualOverride
+	si.manualAspect = si.simulation.SignalLib.Aspects[ts.ManualAspect]
+	si.failed = ts.Failed
+	si.failureMode = SignalFailureMode(ts.FailureMode)
+	si.failureCause = ts.FailureCause
+	si.repairAt = ts.RepairAt
+	si.lastChanged = ts.LastChanged
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// simState returns a description of the trains, routes and signals of sim
+func simState(sim *simulation.Simulation) []string {
+	var res []string
+	for _, t := range sim.Trains {
+		res = append(res, fmt.Sprintf("train %s %s %d %.3f %.3f %s", t.ID(), t.TrainHead.TrackItemID, t.Status, t.TrainHead.PositionOnTI, t.Speed, t.ServiceCode))
+	}
+	for id, ti := range sim.TrackItems {
+		route := ""
+		if ti.ActiveRoute() != nil {
+			route = ti.ActiveRoute().ID()
+		}
+		res = append(res, fmt.Sprintf("item %s %s %v", id, route, ti.TrainPresent()))
+		if si, ok := ti.(*simulation.SignalItem); ok {
+			res = append(res, fmt.Sprintf("signal %s %s %s", id, si.ActiveAspect().Name, si.FailureMode()))
+		}
+	}
+	sort.Strings(res)
+	return append(res, sim.Options.CurrentTime.Time.String())
+}
+
+func TestCheckpoint(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing simulation checkpoints", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		sim.Options.SignalFailureRate = 2
+		So(sim.SetPerturbations(simulation.Perturbations{Enabled: true, DwellProbability: 0.5, TrainFaultRate: 2}), ShouldBeNil)
+		So(stepUntil(&sim, 3000, sim.Trains[0].IsActive), ShouldBeTrue)
+		for i := 0; i < 200; i++ {
+			sim.Step()
+		}
+		So(sim.AddSpeedRestriction(&simulation.SpeedRestriction{TrackItemID: "3", SpeedLimit: 20}), ShouldBeNil)
+		cp, err := sim.Checkpoint()
+		So(err, ShouldBeNil)
+		Convey("A restored simulation should be in the same state", func() {
+			restored, err := simulation.RestoreCheckpoint(cp)
+			So(err, ShouldBeNil)
+			drainEvents(restored, endChan)
+			So(simState(restored), ShouldResemble, simState(&sim))
+			So(restored.SpeedRestrictions(), ShouldHaveLength, 1)
+			So(restored.Options.CurrentTime.Time.Equal(sim.Options.CurrentTime.Time), ShouldBeTrue)
+			Convey("And evolve as the original simulation", func() {
+				for i := 0; i < 1200; i++ {
+					sim.Step()
+					restored.Step()
+				}
+				So(simState(restored), ShouldResemble, simState(&sim))
+				So(restored.PerturbationStats(), ShouldResemble, sim.PerturbationStats())
+			})
+		})
+		Convey("Invalid checkpoints should be refused", func() {
+			_, err := simulation.RestoreCheckpoint([]byte(`{"format": 99}`))
+			So(err, ShouldNotBeNil)
+			_, err = simulation.RestoreCheckpoint([]byte(`not json`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"time"
+)
+
+// IsCircular returns true if the trains of this service start it again at its
+// first line after its last one.
+func (s *Service) IsCircular() bool {
+	return s.CycleMinutes > 0 && len(s.Lines) > 1
+}
+
+// loopIndex returns the index of the line at which the trains of this
+// circular service go on after its last line. The first line is skipped if it
+// is at the same place as the last one.
+func (s *Service) loopIndex() int {
+	if s.Lines[0].PlaceCode == s.Lines[len(s.Lines)-1].PlaceCode {
+		return 1
+	}
+	return 0
+}
+
+// shiftTimes moves all the scheduled times of this service by d
+func (s *Service) shiftTimes(d time.Duration) {
+	for _, line := range s.Lines {
+		if !line.ScheduledArrivalTime.IsZero() {
+			line.ScheduledArrivalTime.Time = line.ScheduledArrivalTime.Time.Add(d)
+		}
+		if !line.ScheduledDepartureTime.IsZero() {
+			line.ScheduledDepartureTime.Time = line.ScheduledDepartureTime.Time.Add(d)
+		}
+	}
+}
+
+// loops returns true if this train starts its circular service again instead
+// of ending it at its last line. In this case, the times of the service are
+// moved to the next cycle and the next line of the train is set.
+func (t *Train) loops() bool {
+	s := t.Service()
+	if !s.IsCircular() || t.withdrawn {
+		return false
+	}
+	s.shiftTimes(time.Duration(s.CycleMinutes) * time.Minute)
+	t.NextPlaceIndex = s.loopIndex()
+	t.simulation.sendEvent(&Event{
+		Name:   ServiceChangedEvent,
+		Object: s,
+	})
+	return true
+}
+
+// Withdraw takes this train out of its circular service at the end of the
+// current cycle. The train then ends its service at the last line and the
+// post actions of the service are performed.
+func (t *Train) Withdraw() error {
+	if t.Service() == nil || !t.Service().IsCircular() {
+		return fmt.Errorf("train %s is not running a circular service", t.ID())
+	}
+	if t.withdrawn {
+		return fmt.Errorf("train %s is already withdrawn", t.ID())
+	}
+	t.withdrawn = true
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s will be withdrawn at the end of its cycle", t.ServiceCode), simulationMsg)
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+	return nil
+}
+
+// IsWithdrawn returns true if this train leaves its circular service at the
+// end of the current cycle.
+func (t *Train) IsWithdrawn() bool {
+	return t.withdrawn
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestCircularServices(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing circular services", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		s001 := sim.Services["S001"]
+		s001.CycleMinutes = 10
+		So(s001.IsCircular(), ShouldBeTrue)
+		So(sim.Services["S002"].IsCircular(), ShouldBeFalse)
+		train := sim.Trains[0]
+		clock := func(t *simulation.Time) string {
+			return t.Time.Format("15:04:05")
+		}
+		Convey("Services should be saved with their cycle", func() {
+			data, err := json.Marshal(s001)
+			So(err, ShouldBeNil)
+			var saved map[string]interface{}
+			So(json.Unmarshal(data, &saved), ShouldBeNil)
+			So(saved["cycleMinutes"], ShouldEqual, 10)
+		})
+		Convey("Trains should start the service again after its last line", func() {
+			So(stepUntil(&sim, 1000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+			So(train.NextPlaceIndex, ShouldEqual, 1)
+			So(stepUntil(&sim, 1000, func() bool { return train.NextPlaceIndex == 0 }), ShouldBeTrue)
+			So(train.ServiceCode, ShouldEqual, "S001")
+			So(train.Status, ShouldEqual, simulation.Running)
+			So(clock(&s001.Lines[0].ScheduledDepartureTime), ShouldEqual, "06:10:30")
+			So(clock(&s001.Lines[1].ScheduledArrivalTime), ShouldEqual, "06:11:30")
+			So(clock(&s001.Lines[1].ScheduledDepartureTime), ShouldEqual, "06:12:00")
+		})
+		Convey("Withdrawn trains should end the service at the end of the cycle", func() {
+			So(sim.Trains[1].Withdraw(), ShouldNotBeNil)
+			So(train.Withdraw(), ShouldBeNil)
+			So(train.IsWithdrawn(), ShouldBeTrue)
+			So(train.Withdraw(), ShouldNotBeNil)
+			So(stepUntil(&sim, 1000, func() bool { return train.ServiceCode != "S001" }), ShouldBeTrue)
+			// The post actions of S001 are performed
+			So(train.ServiceCode, ShouldEqual, "S002")
+			So(train.IsWithdrawn(), ShouldBeFalse)
+			So(clock(&s001.Lines[1].ScheduledArrivalTime), ShouldEqual, "06:01:30")
+		})
+	})
+}
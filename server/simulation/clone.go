@@ -0,0 +1,245 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Clone returns a deep copy of this simulation in its current state.
+//
+// The clone is fully independent from this simulation: it has its own
+// objects, its own event channel and its clock is not started. Active routes,
+// points directions, signal aspects, trains positions and internal states are
+// copied, so that the clone evolves as this simulation would. Messages of the
+// clone are not written to the Logger.
+//
+// Events of the clone are sent on its EventChan which must be drained by the
+// caller. Call Close on the clone when it is not needed anymore.
+func (sim *Simulation) Clone() (*Simulation, error) {
+	data, err := json.Marshal(sim)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize simulation: %s", err)
+	}
+	clone := new(Simulation)
+	if err = json.Unmarshal(data, clone); err != nil {
+		return nil, fmt.Errorf("unable to rebuild simulation: %s", err)
+	}
+	clone.quiet = true
+	// Time is serialized without date, which would be lost after midnight
+	clone.Options.CurrentTime.Time = sim.Options.CurrentTime.Time
+
+	// Trains are copied one by one to keep the same order and IDs
+	clone.Trains = make([]*Train, len(sim.Trains))
+	for i, t := range sim.Trains {
+		td, err := json.Marshal(t)
+		if err != nil {
+			return nil, fmt.Errorf("unable to serialize train %s: %s", t.ID(), err)
+		}
+		ct := new(Train)
+		if err = json.Unmarshal(td, ct); err != nil {
+			return nil, fmt.Errorf("unable to rebuild train %s: %s", t.ID(), err)
+		}
+		ct.setSimulation(clone)
+		ct.trainID = t.trainID
+		ct.trainManager = t.trainManager
+		ct.effInitialDelay = t.effInitialDelay
+		ct.minStopTime = t.minStopTime
+		ct.signalActions = append([]SignalAction{}, t.signalActions...)
+		ct.actionIndex = t.actionIndex
+		ct.actionTime.Time = t.actionTime.Time
+		ct.heldUntil.Time = t.heldUntil.Time
+		ct.performance = t.performance
+		ct.degradedUntil.Time = t.degradedUntil.Time
+		ct.entryPerturbed = t.entryPerturbed
+		ct.tractionEnergy = t.tractionEnergy
+		ct.coastSpeed = t.coastSpeed
+		ct.boarded = t.boarded
+		ct.alighted = t.alighted
+		ct.passengerDwell = t.passengerDwell
+		ct.shunting = t.shunting
+		ct.shuntRoute = t.shuntRoute
+		ct.depot = t.depot
+		ct.withdrawn = t.withdrawn
+		ct.overspeeding = t.overspeeding
+		clone.Trains[i] = ct
+	}
+	cloneSignal := func(si *SignalItem) *SignalItem {
+		if si == nil {
+			return nil
+		}
+		return clone.TrackItems[si.ID()].(*SignalItem)
+	}
+	cloneTrain := func(t *Train) *Train {
+		if t == nil {
+			return nil
+		}
+		return clone.Trains[mustAtoi(t.ID())]
+	}
+	cloneRoute := func(r *Route) *Route {
+		if r == nil {
+			return nil
+		}
+		return clone.Routes[r.ID()]
+	}
+	for i, t := range sim.Trains {
+		clone.Trains[i].lastSignal = cloneSignal(t.lastSignal)
+		clone.Trains[i].ignoredSignal = cloneSignal(t.ignoredSignal)
+	}
+
+	// Routes are initialized without activation, their state is copied from
+	// the track items below.
+	for num, r := range clone.Routes {
+		if err := r.initialize(num); err != nil {
+			return nil, fmt.Errorf("error initializing route %s: %s", num, err)
+		}
+		r.Persistent = sim.Routes[num].Persistent
+		r.overlapLocked = sim.Routes[num].overlapLocked
+		r.overlapReleaseAt = sim.Routes[num].overlapReleaseAt
+	}
+	clone.computeRouteConflicts()
+
+	clone.occupancy = newOccupancyIndex(clone)
+	for id, ti := range sim.TrackItems {
+		cti := clone.TrackItems[id]
+		u, cu := ti.underlying(), cti.underlying()
+		cu.activeRoute = cloneRoute(u.activeRoute)
+		if u.arPreviousItem != nil {
+			cu.arPreviousItem = clone.TrackItems[u.arPreviousItem.ID()]
+		}
+		cu.blocked = u.blocked
+		cu.detectionFailure = u.detectionFailure
+		cu.speedLimit = u.speedLimit
+		u.trainEndMutex.RLock()
+		for t, v := range u.trainEndsFW {
+			cu.trainEndsFW[cloneTrain(t)] = v
+		}
+		for t, v := range u.trainEndsBK {
+			cu.trainEndsBK[cloneTrain(t)] = v
+		}
+		cu.indexTrains()
+		u.trainEndMutex.RUnlock()
+		switch v := ti.(type) {
+		case *PointsItem:
+			if pointsItemManager != nil {
+				pointsItemManager.SetDirection(cti.(*PointsItem), pointsItemManager.Direction(v))
+			}
+			cp := cti.(*PointsItem)
+			cp.locked = v.locked
+			cp.failureMode = v.failureMode
+			cp.failureCause = v.failureCause
+			cp.repairAt = v.repairAt
+		case *LevelCrossingItem:
+			clc := cti.(*LevelCrossingItem)
+			clc.state = v.state
+			clc.since = v.since
+			clc.manual = v.manual
+			clc.manualClosed = v.manualClosed
+			clc.failureMode = v.failureMode
+			clc.failureCause = v.failureCause
+			clc.repairAt = v.repairAt
+			clc.metrics = v.metrics
+		case *Place:
+			cpl := cti.(*Place)
+			cpl.waitingPassengers = v.waitingPassengers
+			cpl.lastBoarding = v.lastBoarding
+		case *SignalItem:
+			cs := cti.(*SignalItem)
+			cs.train = cloneTrain(v.train)
+			cs.previousActiveRoute = cloneRoute(v.previousActiveRoute)
+			cs.nextActiveRoute = cloneRoute(v.nextActiveRoute)
+			if v.activeAspect != nil {
+				cs.activeAspect = clone.SignalLib.Aspects[v.activeAspect.Name]
+			}
+			cs.manualOverride = v.manualOverride
+			if v.manualAspect != nil {
+				cs.manualAspect = clone.SignalLib.Aspects[v.manualAspect.Name]
+			}
+			cs.failed = v.failed
+			cs.failureMode = v.failureMode
+			cs.failureCause = v.failureCause
+			cs.repairAt = v.repairAt
+			cs.lastChanged = v.lastChanged
+		}
+	}
+	sim.disruptionsMutex.RLock()
+	clone.lastDisruptionID = sim.lastDisruptionID
+	clone.disruptions = make(map[string]*Disruption, len(sim.disruptions))
+	for id, d := range sim.disruptions {
+		cd := &Disruption{
+			Type:          d.Type,
+			TrackItemID:   d.TrackItemID,
+			ToTrackItemID: d.ToTrackItemID,
+			SpeedLimit:    d.SpeedLimit,
+			Reason:        d.Reason,
+			FailureMode:   d.FailureMode,
+			disruptionID:  d.disruptionID,
+			items:         append([]string{}, d.items...),
+			active:        d.active,
+			simulation:    clone,
+		}
+		cd.StartTime.Time = d.StartTime.Time
+		cd.EndTime.Time = d.EndTime.Time
+		clone.disruptions[id] = cd
+	}
+	clone.lastSpeedRestrictionID = sim.lastSpeedRestrictionID
+	clone.speedRestrictions = make(map[string]*SpeedRestriction, len(sim.speedRestrictions))
+	for id, sr := range sim.speedRestrictions {
+		csr := &SpeedRestriction{
+			TrackItemID:   sr.TrackItemID,
+			ToTrackItemID: sr.ToTrackItemID,
+			SpeedLimit:    sr.SpeedLimit,
+			Reason:        sr.Reason,
+			restrictionID: sr.restrictionID,
+			items:         append([]string{}, sr.items...),
+			active:        sr.active,
+			simulation:    clone,
+		}
+		csr.StartTime.Time = sr.StartTime.Time
+		csr.EndTime.Time = sr.EndTime.Time
+		clone.speedRestrictions[id] = csr
+	}
+	clone.lastPossessionID = sim.lastPossessionID
+	clone.possessions = make(map[string]*Possession, len(sim.possessions))
+	for id, p := range sim.possessions {
+		cp := &Possession{
+			TrackItemID:   p.TrackItemID,
+			ToTrackItemID: p.ToTrackItemID,
+			Reason:        p.Reason,
+			possessionID:  p.possessionID,
+			items:         append([]string{}, p.items...),
+			active:        p.active,
+			pending:       p.pending,
+			simulation:    clone,
+		}
+		cp.StartTime.Time = p.StartTime.Time
+		cp.EndTime.Time = p.EndTime.Time
+		clone.possessions[id] = cp
+	}
+	sim.disruptionsMutex.RUnlock()
+	for id, tr := range sim.transfers {
+		ctr := clone.transfers[id]
+		ctr.status = tr.status
+		ctr.arrivalTime = tr.arrivalTime
+		ctr.departureTime = tr.departureTime
+	}
+	return clone, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func drainEvents(sim *simulation.Simulation, endChan chan struct{}) {
+	go func() {
+		for {
+			select {
+			case <-sim.EventChan:
+			case <-endChan:
+				return
+			}
+		}
+	}()
+}
+
+// loadDemoWith loads the demo simulation after patch, if not nil, has changed
+// its JSON document, and initializes it with its events drained until endChan
+// is closed.
+func loadDemoWith(endChan chan struct{}, patch func(raw map[string]interface{})) (*simulation.Simulation, error) {
+	data, err := ioutil.ReadFile("testdata/demo.json")
+	if err != nil {
+		return nil, err
+	}
+	if patch != nil {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		patch(raw)
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+	var sim simulation.Simulation
+	if err := json.Unmarshal(data, &sim); err != nil {
+		return nil, err
+	}
+	drainEvents(&sim, endChan)
+	if err := sim.Initialize(); err != nil {
+		return nil, err
+	}
+	return &sim, nil
+}
+
+func TestSimulationClone(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing simulation cloning", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		sim.Trains[0].AppearTime = simulation.ParseTime("05:00:00")
+		for i := 0; i < 10; i++ {
+			sim.Step()
+		}
+		clone, err := sim.Clone()
+		So(err, ShouldBeNil)
+		drainEvents(clone, endChan)
+		Convey("The clone should have the same state", func() {
+			So(clone, ShouldNotEqual, &sim)
+			So(clone.Options.CurrentTime.Time, ShouldResemble, sim.Options.CurrentTime.Time)
+			So(clone.TrackItems, ShouldHaveLength, len(sim.TrackItems))
+			So(clone.Trains, ShouldHaveLength, len(sim.Trains))
+			So(clone.Trains[0].ID(), ShouldEqual, sim.Trains[0].ID())
+			So(clone.Trains[0].TrainHead.TrackItemID, ShouldEqual, sim.Trains[0].TrainHead.TrackItemID)
+			So(clone.Trains[0].TrainHead.PositionOnTI, ShouldEqual, sim.Trains[0].TrainHead.PositionOnTI)
+			So(clone.Trains[0].Speed, ShouldEqual, sim.Trains[0].Speed)
+			So(clone.Routes["1"].State(), ShouldEqual, sim.Routes["1"].State())
+			So(clone.TrackItems["3"].(*simulation.SignalItem).ActiveAspect().Name, ShouldEqual,
+				sim.TrackItems["3"].(*simulation.SignalItem).ActiveAspect().Name)
+		})
+		Convey("The clone should evolve as the original", func() {
+			for i := 0; i < 10; i++ {
+				sim.Step()
+				clone.Step()
+			}
+			So(clone.Options.CurrentTime.Time, ShouldResemble, sim.Options.CurrentTime.Time)
+			So(clone.Trains[0].TrainHead.PositionOnTI, ShouldEqual, sim.Trains[0].TrainHead.PositionOnTI)
+			So(clone.Trains[0].Speed, ShouldEqual, sim.Trains[0].Speed)
+		})
+		Convey("The clone should be independent from the original", func() {
+			clone.TrackItems["4"].SetBlocked(true)
+			So(clone.TrackItems["4"].Blocked(), ShouldBeTrue)
+			So(sim.TrackItems["4"].Blocked(), ShouldBeFalse)
+			clone.Trains[0].Hold(10 * time.Minute)
+			So(clone.Trains[0].IsHeld(), ShouldBeTrue)
+			So(sim.Trains[0].IsHeld(), ShouldBeFalse)
+			for i := 0; i < 20; i++ {
+				clone.Step()
+			}
+			So(clone.Options.CurrentTime.Time, ShouldNotResemble, sim.Options.CurrentTime.Time)
+			So(clone.Trains[0].Speed, ShouldBeLessThan, sim.Trains[0].Speed)
+			clone.Close()
+		})
+		Convey("A degraded train should run slower than the original", func() {
+			clone.Trains[0].Degrade(0.5, 10*time.Minute)
+			So(clone.Trains[0].Performance(), ShouldEqual, 0.5)
+			So(sim.Trains[0].Performance(), ShouldEqual, 1)
+			So(clone.Trains[0].DegradedUntil().IsZero(), ShouldBeFalse)
+			for i := 0; i < 20; i++ {
+				sim.Step()
+				clone.Step()
+			}
+			So(clone.Trains[0].Speed, ShouldBeLessThanOrEqualTo, clone.Trains[0].TrainType().MaxSpeed*0.5)
+			So(clone.Trains[0].TrainHead.PositionOnTI, ShouldNotEqual, sim.Trains[0].TrainHead.PositionOnTI)
+			clone.Trains[0].Degrade(1, 0)
+			So(clone.Trains[0].Performance(), ShouldEqual, 1)
+			clone.Close()
+		})
+	})
+}
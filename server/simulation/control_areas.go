@@ -0,0 +1,100 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// A ControlArea is a part of the network controlled by one dispatcher.
+//
+// The control areas of a simulation do not overlap: each track item belongs
+// to at most one of them. Track items outside all areas can be controlled by
+// any dispatcher.
+type ControlArea struct {
+	Name       string   `json:"name"`
+	TrackItems []string `json:"trackItems"`
+	// Dispatcher is the user controlling this area when the simulation is
+	// loaded
+	Dispatcher string `json:"dispatcher"`
+
+	areaID string
+}
+
+// ID returns the unique identifier of this control area
+func (ca *ControlArea) ID() string {
+	return ca.areaID
+}
+
+// MarshalJSON for the ControlArea type
+func (ca ControlArea) MarshalJSON() ([]byte, error) {
+	type auxArea ControlArea
+	return json.Marshal(struct {
+		auxArea
+		ID string `json:"id"`
+	}{
+		auxArea: auxArea(ca),
+		ID:      ca.areaID,
+	})
+}
+
+// initialize checks this control area against the track items and the other
+// areas of sim
+func (ca *ControlArea) initialize(sim *Simulation, id string) error {
+	ca.areaID = id
+	if len(ca.TrackItems) == 0 {
+		return fmt.Errorf("control area %s has no track items", id)
+	}
+	for _, tiID := range ca.TrackItems {
+		if _, ok := sim.TrackItems[tiID]; !ok {
+			return fmt.Errorf("unknown track item %s in control area %s", tiID, id)
+		}
+		if other, ok := sim.areaOfItem[tiID]; ok && other != id {
+			return fmt.Errorf("track item %s is in control areas %s and %s", tiID, other, id)
+		}
+		sim.areaOfItem[tiID] = id
+	}
+	return nil
+}
+
+// ControlAreas returns the control areas of the simulation sorted by ID
+func (sim *Simulation) ControlAreas() []*ControlArea {
+	res := make([]*ControlArea, 0, len(sim.controlAreas))
+	for _, ca := range sim.controlAreas {
+		res = append(res, ca)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].areaID < res[j].areaID
+	})
+	return res
+}
+
+// ControlArea returns the control area with the given ID, or nil if it does
+// not exist
+func (sim *Simulation) ControlArea(id string) *ControlArea {
+	return sim.controlAreas[id]
+}
+
+// ControlAreaOf returns the ID of the control area of the track item with
+// the given ID, or an empty string if it is in no area.
+func (sim *Simulation) ControlAreaOf(tiID string) string {
+	return sim.areaOfItem[tiID]
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestControlAreas(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given control areas
+	loadSim := func(areas map[string]interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["controlAreas"] = areas
+		})
+	}
+	west := map[string]interface{}{"name": "West", "trackItems": []string{"1", "2", "4", "5", "6", "7"}, "dispatcher": "alice"}
+	east := map[string]interface{}{"name": "East", "trackItems": []string{"9", "15", "3", "17"}}
+	Convey("Testing control areas", t, func() {
+		Convey("Control areas should be loaded and saved", func() {
+			sim, err := loadSim(map[string]interface{}{"WEST": west, "EAST": east})
+			So(err, ShouldBeNil)
+			areas := sim.ControlAreas()
+			So(areas, ShouldHaveLength, 2)
+			So(areas[0].ID(), ShouldEqual, "EAST")
+			So(sim.ControlArea("WEST").Dispatcher, ShouldEqual, "alice")
+			So(sim.ControlArea("XX"), ShouldBeNil)
+			So(sim.ControlAreaOf("5"), ShouldEqual, "WEST")
+			So(sim.ControlAreaOf("17"), ShouldEqual, "EAST")
+			So(sim.ControlAreaOf("101"), ShouldBeEmpty)
+
+			data, err := json.Marshal(sim)
+			So(err, ShouldBeNil)
+			var saved struct {
+				ControlAreas map[string]map[string]interface{} `json:"controlAreas"`
+			}
+			So(json.Unmarshal(data, &saved), ShouldBeNil)
+			So(saved.ControlAreas, ShouldContainKey, "WEST")
+			So(saved.ControlAreas["WEST"]["id"], ShouldEqual, "WEST")
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			drainEvents(clone, endChan)
+			So(clone.ControlAreaOf("5"), ShouldEqual, "WEST")
+		})
+		Convey("Simulations without control areas should have none", func() {
+			sim, err := loadSim(nil)
+			So(err, ShouldBeNil)
+			So(sim.ControlAreas(), ShouldBeEmpty)
+			So(sim.ControlAreaOf("5"), ShouldBeEmpty)
+		})
+		Convey("Invalid control areas should be rejected", func() {
+			_, err := loadSim(map[string]interface{}{"WEST": west, "OTHER": map[string]interface{}{"trackItems": []string{"7", "8"}}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "track item 7 is in control areas")
+			_, err = loadSim(map[string]interface{}{"WEST": map[string]interface{}{"trackItems": []string{"999"}}})
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(map[string]interface{}{"WEST": map[string]interface{}{"name": "West"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,389 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// couplingDistance is the maximum distance in meters between two trains for
+// them to be joined. Trains stop at a safety distance from the train in front
+// of them, so that the joining train has to be drawn up to the other one.
+const couplingDistance float64 = 150
+
+// TrainCoupling describes a train that has been split or joined at a place. It
+// is the object of TrainSplitEvent and TrainJoinedEvent.
+type TrainCoupling struct {
+	// TrainID is the ID of the train that has been split, or of the train
+	// that absorbed the other one when joining.
+	TrainID string `json:"trainId"`
+	// OtherTrainID is the ID of the train created by the split, or of the
+	// train that has been absorbed by the join.
+	OtherTrainID string `json:"otherTrainId"`
+	// TrackItemID is the ID of the track item of the head of the train.
+	TrackItemID string `json:"trackItemId"`
+	// PlaceCode is the code of the place where the train has been split or
+	// joined, if any.
+	PlaceCode string `json:"placeCode"`
+}
+
+// ID returns the ID of the train that has been split or joined.
+func (tc *TrainCoupling) ID() string {
+	return tc.TrainID
+}
+
+// newTrainCoupling returns the TrainCoupling describing the split or the join
+// of t with other.
+func newTrainCoupling(t, other *Train) *TrainCoupling {
+	tc := &TrainCoupling{
+		TrainID:      t.ID(),
+		OtherTrainID: other.ID(),
+		TrackItemID:  t.TrainHead.TrackItemID,
+	}
+	if pl := t.TrainHead.TrackItem().Place(); pl != nil {
+		tc.PlaceCode = pl.PlaceCode
+	}
+	return tc
+}
+
+// elementCodes returns the codes of the train types this TrainType is made
+// of, that is its own code if it has no elements.
+func (tt *TrainType) elementCodes() []string {
+	if len(tt.ElementsStr) == 0 {
+		return []string{tt.ID()}
+	}
+	return tt.ElementsStr
+}
+
+// trainTypeOf returns the train type made of the train types with the given
+// codes, in order. If no such train type is defined in the simulation, a new
+// one is created with the characteristics of its weakest element.
+func (sim *Simulation) trainTypeOf(codes []string) (*TrainType, error) {
+	for _, code := range codes {
+		if _, ok := sim.TrainTypes[code]; !ok {
+			return nil, fmt.Errorf("unknown train type: %s", code)
+		}
+	}
+	if len(codes) == 1 {
+		return sim.TrainTypes[codes[0]], nil
+	}
+	for _, tt := range sim.TrainTypes {
+		if strings.Join(tt.ElementsStr, "+") == strings.Join(codes, "+") {
+			return tt, nil
+		}
+	}
+	tt := &TrainType{
+		ElementsStr: append([]string(nil), codes...),
+	}
+	descriptions := make([]string, len(codes))
+	for i, code := range codes {
+		element := sim.TrainTypes[code]
+		descriptions[i] = element.Description
+		tt.Length += element.Length
+		tt.Capacity += element.Capacity
+		if i == 0 || element.MaxSpeed < tt.MaxSpeed {
+			tt.MaxSpeed = element.MaxSpeed
+		}
+		if i == 0 {
+			tt.AccelCurve, tt.BrakingCurve = element.AccelCurve, element.BrakingCurve
+		} else {
+			tt.AccelCurve = lowerCurve(tt.AccelCurve, tt.StdAccel, element.AccelCurve, element.StdAccel)
+			tt.BrakingCurve = lowerCurve(tt.BrakingCurve, tt.StdBraking, element.BrakingCurve, element.StdBraking)
+		}
+		if i == 0 || element.StdAccel < tt.StdAccel {
+			tt.StdAccel = element.StdAccel
+		}
+		if i == 0 || element.StdBraking < tt.StdBraking {
+			tt.StdBraking = element.StdBraking
+		}
+		if i == 0 || element.EmergBraking < tt.EmergBraking {
+			tt.EmergBraking = element.EmergBraking
+		}
+	}
+	tt.Description = strings.Join(descriptions, " + ")
+	tt.setSimulation(sim)
+	if err := tt.initialize(strings.Join(codes, "+")); err != nil {
+		return nil, err
+	}
+	sim.TrainTypes[tt.ID()] = tt
+	return tt, nil
+}
+
+// isOnLine returns true if this train is standing or running in the area,
+// even if its service is finished.
+func (t *Train) isOnLine() bool {
+	return t.Status != Inactive && t.Status != Out && t.Status != Joined && t.Status != Cancelled
+}
+
+// vacate removes this train from the track items it occupies.
+func (t *Train) vacate() {
+	for _, ti := range t.trainTrackItems() {
+		ti.underlying().trainEndMutex.Lock()
+		delete(ti.underlying().trainEndsFW, t)
+		delete(ti.underlying().trainEndsBK, t)
+		ti.underlying().syncOccupancy(t)
+		ti.underlying().trainEndMutex.Unlock()
+	}
+}
+
+// occupy registers this train on the track items it occupies.
+func (t *Train) occupy() {
+	for _, ti := range t.trainTrackItems() {
+		t.updateItemWithTrainHead(ti)
+	}
+	t.updateItemWithTrainTail(t.TrainTail().TrackItem())
+}
+
+// distanceAhead returns the distance from pos to target if target is in front
+// of pos, in the same direction and at most maxDistance away.
+func distanceAhead(pos, target Position, maxDistance float64) (float64, bool) {
+	var distance float64
+	for distance <= maxDistance {
+		if pos.TrackItemID == target.TrackItemID && pos.PreviousItemID == target.PreviousItemID {
+			d := distance + target.PositionOnTI - pos.PositionOnTI
+			return d, d >= 0 && d <= maxDistance
+		}
+		if pos.TrackItem().Type() == TypeEnd {
+			return 0, false
+		}
+		distance += pos.TrackItem().RealLength() - pos.PositionOnTI
+		pos = pos.Next(DirectionCurrent)
+	}
+	return 0, false
+}
+
+// couplingCandidate returns the closest train in the area that is directly in
+// front of this train if ahead is true, or directly behind it otherwise, or
+// nil if no train is close enough to be joined.
+func (t *Train) couplingCandidate(ahead bool) *Train {
+	var res *Train
+	minDistance := couplingDistance
+	candidates := t.simulation.trainsWithin(t.TrainHead, couplingDistance)
+	if !ahead {
+		candidates = t.simulation.trainsWithin(t.TrainTail().Reversed(), couplingDistance)
+	}
+	for _, other := range candidates {
+		if other == t || !other.isOnLine() {
+			continue
+		}
+		var (
+			d  float64
+			ok bool
+		)
+		if ahead {
+			d, ok = distanceAhead(t.TrainHead, other.TrainTail(), couplingDistance)
+		} else {
+			d, ok = distanceAhead(other.TrainHead, t.TrainTail(), couplingDistance)
+		}
+		if ok && d <= minDistance {
+			res = other
+			minDistance = d
+		}
+	}
+	return res
+}
+
+// notifyCoupling sends the events related to the split or join of t and other,
+// with the given event name.
+func (t *Train) notifyCoupling(name EventName, other *Train, items map[TrackItem]bool) {
+	for ti := range items {
+		t.simulation.sendEvent(&Event{
+			Name:   TrackItemChangedEvent,
+			Object: ti,
+		})
+	}
+	for _, train := range []*Train{t, other} {
+		t.simulation.sendEvent(&Event{
+			Name:   TrainChangedEvent,
+			Object: train,
+		})
+	}
+	t.simulation.sendEvent(&Event{
+		Name:   name,
+		Object: newTrainCoupling(t, other),
+	})
+}
+
+// Split divides this train into two trains after its element at the given
+// index, counted from 1 at the head of the train.
+//
+// This train keeps the front portion and its service. The rear portion
+// becomes a new train which is added to the simulation and returned. It is
+// assigned the given service if serviceCode is not empty, and has no service
+// otherwise. The train must be stopped.
+func (t *Train) Split(after int, serviceCode string) (*Train, error) {
+	if !t.isOnLine() {
+		return nil, errors.New("train is not in the area")
+	}
+	if t.Speed != 0 {
+		return nil, errors.New("train is not stopped")
+	}
+	codes := t.TrainType().elementCodes()
+	if after < 1 || after >= len(codes) {
+		return nil, fmt.Errorf("train has %d elements and cannot be split after element %d", len(codes), after)
+	}
+	if _, ok := t.simulation.Services[serviceCode]; serviceCode != "" && !ok {
+		return nil, fmt.Errorf("unknown service: %s", serviceCode)
+	}
+	frontType, err := t.simulation.trainTypeOf(codes[:after])
+	if err != nil {
+		return nil, err
+	}
+	rearType, err := t.simulation.trainTypeOf(codes[after:])
+	if err != nil {
+		return nil, err
+	}
+	rear := &Train{
+		InitialDelay:  t.InitialDelay,
+		ServiceCode:   serviceCode,
+		PriorityClass: t.PriorityClass,
+		TrainTypeCode: rearType.ID(),
+		TrainHead:     t.TrainHead.Add(-frontType.Length),
+		trainManager:  t.trainManager,
+	}
+	rear.setSimulation(t.simulation)
+	rear.initialize(strconv.Itoa(len(t.simulation.Trains)))
+	rear.effInitialDelay = t.effInitialDelay
+	rear.NextPlaceIndex = NoMorePlace
+	rear.Status = EndOfService
+	if serviceCode != "" {
+		rear.NextPlaceIndex = 0
+		rear.Status = Stopped
+	}
+	rear.signalActions = []SignalAction{{
+		Target: ASAP,
+		Speed:  VeryHighSpeed,
+	}}
+	rear.setActionIndex(0)
+	// Passengers are shared between both portions by their lengths
+	rear.Passengers = math.Round(t.Passengers * rearType.Length / t.TrainType().Length)
+	t.Passengers -= rear.Passengers
+
+	items := make(map[TrackItem]bool)
+	for _, ti := range t.trainTrackItems() {
+		items[ti] = true
+	}
+	t.vacate()
+	t.TrainTypeCode = frontType.ID()
+	t.occupy()
+	rear.occupy()
+	t.simulation.Trains = append(t.simulation.Trains, rear)
+
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s split: rear portion is train %s (%s)",
+		t.ServiceCode, rear.ID(), rear.TrainTypeCode), simulationMsg)
+	t.notifyCoupling(TrainSplitEvent, rear, items)
+	return rear, nil
+}
+
+// Join couples this train with the train directly in front of it if ahead is
+// true, or with the train directly behind it otherwise, and returns the
+// latter.
+//
+// This train absorbs the other one and keeps its own service: the other train
+// gets the Joined status and leaves the area. Both trains must be stopped.
+func (t *Train) Join(ahead bool) (*Train, error) {
+	if !t.isOnLine() {
+		return nil, errors.New("train is not in the area")
+	}
+	if t.Speed != 0 {
+		return nil, errors.New("train is not stopped")
+	}
+	other := t.couplingCandidate(ahead)
+	if other == nil {
+		return nil, errors.New("no train to join")
+	}
+	if other.Speed != 0 {
+		return nil, fmt.Errorf("train %s is not stopped", other.ID())
+	}
+	codes := append(append([]string(nil), t.TrainType().elementCodes()...), other.TrainType().elementCodes()...)
+	head := t.TrainHead
+	if ahead {
+		codes = append(append([]string(nil), other.TrainType().elementCodes()...), t.TrainType().elementCodes()...)
+		head = other.TrainHead
+	}
+	tt, err := t.simulation.trainTypeOf(codes)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[TrackItem]bool)
+	for _, train := range []*Train{t, other} {
+		for _, ti := range train.trainTrackItems() {
+			items[ti] = true
+		}
+		if ns := train.findNextSignal(); ns != nil && ns.train == train {
+			ns.setTrain(nil)
+		}
+		train.vacate()
+	}
+	other.Status = Joined
+	other.Speed = 0
+	t.Passengers += other.Passengers
+	other.Passengers = 0
+	t.TrainHead = head
+	t.TrainTypeCode = tt.ID()
+	t.occupy()
+	for _, ti := range t.trainTrackItems() {
+		items[ti] = true
+	}
+	if ns := t.findNextSignal(); ns != nil {
+		ns.setTrain(t)
+	}
+
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s joined with train %s (%s)",
+		t.ServiceCode, other.ServiceCode, t.TrainTypeCode), simulationMsg)
+	t.notifyCoupling(TrainJoinedEvent, other, items)
+	return other, nil
+}
+
+// splitFromAction splits this train as required by a SPLIT service action
+// with the given parameter, that is the element after which to split,
+// optionally followed by a colon and the service of the rear portion.
+func (t *Train) splitFromAction(param string) error {
+	parts := strings.SplitN(param, ":", 2)
+	after, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return fmt.Errorf("invalid split parameter: %s", param)
+	}
+	var serviceCode string
+	if len(parts) == 2 {
+		serviceCode = strings.TrimSpace(parts[1])
+	}
+	_, err = t.Split(after, serviceCode)
+	return err
+}
+
+// joinFromAction joins this train as required by a JOIN service action with
+// the given parameter, that is 'ahead' or 'behind'.
+func (t *Train) joinFromAction(param string) error {
+	switch strings.ToLower(strings.TrimSpace(param)) {
+	case "ahead":
+		_, err := t.Join(true)
+		return err
+	case "behind":
+		_, err := t.Join(false)
+		return err
+	default:
+		return fmt.Errorf("invalid join parameter: %s", param)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestCoupling(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing train coupling and splitting", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		// Keep the first train out of the way of the double unit
+		sim.Trains[0].Hold(24 * time.Hour)
+		train := sim.Trains[1]
+		stoppedAtStation := func() bool {
+			return train.Status == simulation.Stopped && train.Speed == 0
+		}
+		Convey("Trains should be split and joined", func() {
+			_, err := train.Split(1, "")
+			So(err, ShouldNotBeNil)
+			So(stepUntil(&sim, 500, stoppedAtStation), ShouldBeTrue)
+			So(train.TrainHead.TrackItem().Place().PlaceCode, ShouldEqual, "STN")
+			_, err = train.Split(2, "")
+			So(err, ShouldNotBeNil)
+			_, err = train.Split(1, "UNKNOWN")
+			So(err, ShouldNotBeNil)
+			head := train.TrainHead
+			rear, err := train.Split(1, "")
+			So(err, ShouldBeNil)
+			So(sim.Trains, ShouldHaveLength, 3)
+			So(rear.ID(), ShouldEqual, "2")
+			So(train.TrainTypeCode, ShouldEqual, "UT")
+			So(rear.TrainTypeCode, ShouldEqual, "UT")
+			So(rear.Status, ShouldEqual, simulation.EndOfService)
+			So(rear.TrainHead, ShouldResemble, train.TrainTail())
+			So(rear.TrainTail().TrackItem().TrainPresent(), ShouldBeTrue)
+			_, err = train.Split(1, "")
+			So(err, ShouldNotBeNil)
+
+			_, err = rear.Join(false)
+			So(err, ShouldNotBeNil)
+			other, err := rear.Join(true)
+			So(err, ShouldBeNil)
+			So(other, ShouldEqual, train)
+			So(train.Status, ShouldEqual, simulation.Joined)
+			So(train.IsActive(), ShouldBeFalse)
+			So(rear.TrainTypeCode, ShouldEqual, "UT2")
+			So(rear.TrainHead, ShouldResemble, head)
+			_, err = rear.Join(true)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Service actions should split trains", func() {
+			service := sim.Services["S003"]
+			service.Lines = service.Lines[:2]
+			service.PostActions = []*simulation.ServiceAction{{ActionCode: "SPLIT", ActionParam: "1:S002"}}
+			So(stepUntil(&sim, 500, stoppedAtStation), ShouldBeTrue)
+			So(stepUntil(&sim, 500, func() bool {
+				return len(sim.Trains) == 3
+			}), ShouldBeTrue)
+			rear := sim.Trains[2]
+			So(rear.ServiceCode, ShouldEqual, "S002")
+			So(rear.Status, ShouldEqual, simulation.Stopped)
+			So(train.Status, ShouldEqual, simulation.EndOfService)
+			So(train.TrainTypeCode, ShouldEqual, "UT")
+		})
+	})
+}
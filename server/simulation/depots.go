@@ -0,0 +1,244 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// A Depot is a group of sidings where trains can be stabled out of traffic and
+// from which they can later be called back into service.
+//
+// Stabled trains stay on the track items of the depot, which they keep
+// occupied. A train sent to a depot reserves a place as soon as it starts its
+// shunting movement towards it.
+type Depot struct {
+	Name string `json:"name"`
+	// TrackItems are the IDs of the line items of the sidings of the depot
+	TrackItems []string `json:"trackItems"`
+	// Capacity is the maximum number of trains in the depot, or 0 for no limit
+	Capacity int `json:"capacity"`
+
+	depotID    string
+	simulation *Simulation
+}
+
+// ID returns the unique identifier of this depot
+func (d *Depot) ID() string {
+	return d.depotID
+}
+
+// MarshalJSON for the Depot type
+func (d Depot) MarshalJSON() ([]byte, error) {
+	type auxDepot Depot
+	type depotJSON struct {
+		auxDepot
+		ID        string   `json:"id"`
+		Occupancy int      `json:"occupancy"`
+		Trains    []string `json:"trains"`
+	}
+	dj := depotJSON{
+		auxDepot: auxDepot(d),
+		ID:       d.depotID,
+		Trains:   []string{},
+	}
+	if d.simulation != nil {
+		for _, t := range d.Trains() {
+			dj.Trains = append(dj.Trains, t.ID())
+		}
+	}
+	dj.Occupancy = len(dj.Trains)
+	return json.Marshal(dj)
+}
+
+// initialize checks this depot against the track items of sim
+func (d *Depot) initialize(sim *Simulation, id string) error {
+	d.simulation = sim
+	d.depotID = id
+	if len(d.TrackItems) == 0 {
+		return fmt.Errorf("depot %s has no track items", id)
+	}
+	for _, tiID := range d.TrackItems {
+		ti, ok := sim.TrackItems[tiID]
+		if !ok || ti.Type() != TypeLine {
+			return fmt.Errorf("unknown line item %s in depot %s", tiID, id)
+		}
+	}
+	if d.Capacity < 0 {
+		return fmt.Errorf("negative capacity in depot %s", id)
+	}
+	return nil
+}
+
+// Contains returns true if ti is one of the sidings of this depot
+func (d *Depot) Contains(ti TrackItem) bool {
+	for _, tiID := range d.TrackItems {
+		if ti.ID() == tiID {
+			return true
+		}
+	}
+	return false
+}
+
+// Trains returns the trains stabled in this depot or on their way to it
+func (d *Depot) Trains() []*Train {
+	var res []*Train
+	for _, t := range d.simulation.Trains {
+		if t.depot == d.depotID {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// IsFull returns true if no more trains can be sent to this depot
+func (d *Depot) IsFull() bool {
+	return d.Capacity > 0 && len(d.Trains()) >= d.Capacity
+}
+
+// reachedBy returns true if a train running on r enters this depot
+func (d *Depot) reachedBy(r *Route) bool {
+	for _, pos := range r.Positions[1:] {
+		if d.Contains(pos.TrackItem()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Depots returns the depots of the simulation sorted by ID
+func (sim *Simulation) Depots() []*Depot {
+	res := make([]*Depot, 0, len(sim.depots))
+	for _, d := range sim.depots {
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].depotID < res[j].depotID
+	})
+	return res
+}
+
+// Depot returns the depot with the given ID, or nil if it does not exist
+func (sim *Simulation) Depot(id string) *Depot {
+	return sim.depots[id]
+}
+
+// Depot returns the depot in which this train is stabled or to which it is
+// being sent, or nil.
+func (t *Train) Depot() *Depot {
+	return t.simulation.depots[t.depot]
+}
+
+// SendToDepot sends this train to be stabled in the depot with the given ID.
+//
+// The train must be able to shunt (see Shunt). It is stabled at once if it is
+// already in the depot, otherwise the routes to the depot are set from its
+// next signal and the train shunts there before being stabled. The returned
+// path is nil in the first case.
+func (t *Train) SendToDepot(depotID string) (*RoutePath, error) {
+	d, ok := t.simulation.depots[depotID]
+	if !ok {
+		return nil, fmt.Errorf("unknown depot: %s", depotID)
+	}
+	if err := t.checkCanShunt(); err != nil {
+		return nil, err
+	}
+	if t.depot != "" {
+		return nil, fmt.Errorf("train %s is already sent to depot %s", t.ID(), t.depot)
+	}
+	if d.IsFull() {
+		return nil, fmt.Errorf("depot %s is full", depotID)
+	}
+	if d.Contains(t.TrainHead.TrackItem()) {
+		t.depot = depotID
+		t.stable()
+		return nil, nil
+	}
+	sig := t.findNextSignal()
+	if sig == nil {
+		return nil, fmt.Errorf("no signal ahead of train %s", t.ID())
+	}
+	path, err := t.simulation.findRoutePath(sig.ID(), d.reachedBy)
+	if err != nil {
+		return nil, fmt.Errorf("no path found from signal %s to depot %s", sig.ID(), depotID)
+	}
+	if err := t.setRoutePath(sig, path); err != nil {
+		return nil, err
+	}
+	t.depot = depotID
+	t.startShunting(path)
+	return path, nil
+}
+
+// stable parks this train in its depot. The train leaves its service and
+// stays on the siding until it is called back.
+func (t *Train) stable() {
+	d := t.Depot()
+	if !d.Contains(t.TrainHead.TrackItem()) {
+		// The shunting movement did not end in the depot
+		t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s could not be stabled in %s", t.ServiceCode, d.Name), simulationMsg)
+		t.depot = ""
+		return
+	}
+	if ns := t.findNextSignal(); ns != nil && ns.train == t {
+		ns.setTrain(nil)
+	}
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s stabled in %s", t.ServiceCode, d.Name), simulationMsg)
+	t.Speed = 0
+	t.Status = Stabled
+	t.ServiceCode = ""
+	t.NextPlaceIndex = NoMorePlace
+	t.StoppedTime = 0
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+}
+
+// CallBack takes this stabled train out of its depot and back into traffic.
+// If serviceCode is not empty, the train is assigned this service. The train
+// leaves the depot through the routes set from its next signal, typically
+// after having been reversed.
+func (t *Train) CallBack(serviceCode string) error {
+	if t.Status != Stabled {
+		return fmt.Errorf("train %s is not stabled", t.ID())
+	}
+	if _, ok := t.simulation.Services[serviceCode]; serviceCode != "" && !ok {
+		return fmt.Errorf("unknown service: %s", serviceCode)
+	}
+	d := t.Depot()
+	t.depot = ""
+	t.Status = Waiting
+	if serviceCode != "" {
+		_ = t.AssignService(serviceCode)
+	} else {
+		t.simulation.sendEvent(&Event{
+			Name:   TrainChangedEvent,
+			Object: t,
+		})
+	}
+	name := t.ServiceCode
+	if name == "" {
+		name = t.ID()
+	}
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s called back into service from %s", name, d.Name), simulationMsg)
+	return nil
+}
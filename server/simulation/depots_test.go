@@ -0,0 +1,113 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestDepots(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given depots
+	loadSim := func(depots map[string]interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["depots"] = depots
+		})
+	}
+	depot := func(capacity int, items ...string) map[string]interface{} {
+		return map[string]interface{}{"name": "Station siding", "trackItems": items, "capacity": capacity}
+	}
+	Convey("Testing depots and stabling", t, func() {
+		Convey("Depots should be loaded and saved", func() {
+			sim, err := loadSim(map[string]interface{}{"D1": depot(1, "16")})
+			So(err, ShouldBeNil)
+			So(sim.Depots(), ShouldHaveLength, 1)
+			d := sim.Depot("D1")
+			So(d, ShouldNotBeNil)
+			So(d.ID(), ShouldEqual, "D1")
+			So(d.Contains(sim.TrackItems["16"]), ShouldBeTrue)
+			So(d.Contains(sim.TrackItems["10"]), ShouldBeFalse)
+			So(d.Trains(), ShouldBeEmpty)
+			So(d.IsFull(), ShouldBeFalse)
+			data, err := json.Marshal(sim)
+			So(err, ShouldBeNil)
+			var saved struct {
+				Depots map[string]map[string]interface{} `json:"depots"`
+			}
+			So(json.Unmarshal(data, &saved), ShouldBeNil)
+			So(saved.Depots["D1"]["name"], ShouldEqual, "Station siding")
+			So(saved.Depots["D1"]["occupancy"], ShouldEqual, 0)
+		})
+		Convey("Invalid depots should not be loaded", func() {
+			_, err := loadSim(map[string]interface{}{"D1": depot(1, "9")})
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(map[string]interface{}{"D1": depot(-1, "16")})
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(map[string]interface{}{"D1": depot(1)})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Trains can be stabled and called back", func() {
+			sim, err := loadSim(map[string]interface{}{"D1": depot(1, "16")})
+			So(err, ShouldBeNil)
+			train := sim.Trains[0]
+			// Keep the tracks free for the shunting movements
+			So(sim.Trains[1].Cancel(), ShouldBeNil)
+			So(stepUntil(sim, 3000, func() bool { return train.ServiceCode == "S002" }), ShouldBeTrue)
+			_, err = train.SendToDepot("XXX")
+			So(err, ShouldNotBeNil)
+			// The depot cannot be reached from STN track 1 without reversing
+			_, err = train.SendToDepot("D1")
+			So(err, ShouldNotBeNil)
+			So(train.Depot(), ShouldBeNil)
+			_, err = train.Shunt("3")
+			So(err, ShouldBeNil)
+			So(stepUntil(sim, 500, func() bool { return !train.IsShunting() }), ShouldBeTrue)
+			So(train.Reverse(), ShouldBeNil)
+
+			path, err := train.SendToDepot("D1")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"2"})
+			So(train.IsShunting(), ShouldBeTrue)
+			So(train.Depot(), ShouldEqual, sim.Depot("D1"))
+			// The place is reserved as soon as the train is sent
+			So(sim.Depot("D1").IsFull(), ShouldBeTrue)
+			_, err = train.SendToDepot("D1")
+			So(err, ShouldNotBeNil)
+			So(stepUntil(sim, 500, func() bool { return train.Status == simulation.Stabled }), ShouldBeTrue)
+			So(train.IsShunting(), ShouldBeFalse)
+			So(train.IsActive(), ShouldBeFalse)
+			So(train.ServiceCode, ShouldEqual, "")
+			So(train.TrainHead.TrackItemID, ShouldEqual, "16")
+			So(sim.TrackItems["16"].TrainPresent(), ShouldBeTrue)
+			So(sim.Depot("D1").Trains(), ShouldResemble, []*simulation.Train{train})
+			// A stabled train does not move
+			head := train.TrainHead
+			for i := 0; i < 20; i++ {
+				sim.Step()
+			}
+			So(train.TrainHead, ShouldResemble, head)
+
+			cp, err := sim.Checkpoint()
+			So(err, ShouldBeNil)
+			restored, err := simulation.RestoreCheckpoint(cp)
+			So(err, ShouldBeNil)
+			So(restored.Trains[0].Status, ShouldEqual, simulation.Stabled)
+			So(restored.Depot("D1").Trains(), ShouldHaveLength, 1)
+
+			So(train.CallBack("XXX"), ShouldNotBeNil)
+			So(train.Status, ShouldEqual, simulation.Stabled)
+			So(train.CallBack("S002"), ShouldBeNil)
+			So(train.IsActive(), ShouldBeTrue)
+			So(train.ServiceCode, ShouldEqual, "S002")
+			So(train.Depot(), ShouldBeNil)
+			So(sim.Depot("D1").Trains(), ShouldBeEmpty)
+			So(train.CallBack(""), ShouldNotBeNil)
+		})
+	})
+}
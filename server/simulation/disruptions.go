@@ -0,0 +1,392 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DisruptionType is the kind of incident of a Disruption
+type DisruptionType string
+
+const (
+	// DisruptionTrackBlocked closes track items to traffic
+	DisruptionTrackBlocked DisruptionType = "TRACK_BLOCKED"
+
+	// DisruptionSignalFailed fails a signal to danger
+	DisruptionSignalFailed DisruptionType = "SIGNAL_FAILED"
+
+	// DisruptionPointsLocked locks points in their current direction
+	DisruptionPointsLocked DisruptionType = "POINTS_LOCKED"
+
+	// DisruptionSpeedRestriction imposes a temporary speed limit on the
+	// track items between two items
+	DisruptionSpeedRestriction DisruptionType = "SPEED_RESTRICTION"
+
+	// DisruptionTrackCircuitFailed fails the train detection of the track
+	// items between two items
+	DisruptionTrackCircuitFailed DisruptionType = "TRACK_CIRCUIT_FAILED"
+)
+
+// maxRestrictionItems is the maximum number of track items a speed
+// restriction can span.
+const maxRestrictionItems = 200
+
+// A Disruption is an incident injected in the simulation that affects the
+// infrastructure between StartTime and EndTime.
+//
+// A zero StartTime means that the disruption starts immediately and a zero
+// EndTime that it lasts until it is removed.
+type Disruption struct {
+	Type          DisruptionType `json:"type"`
+	TrackItemID   string         `json:"trackItemId"`
+	ToTrackItemID string         `json:"toTrackItemId"`
+	SpeedLimit    float64        `json:"speedLimit"`
+	StartTime     Time           `json:"startTime"`
+	EndTime       Time           `json:"endTime"`
+	Reason        string         `json:"reason"`
+	// FailureMode is what the failed detection of a TRACK_CIRCUIT_FAILED
+	// disruption shows
+	FailureMode TrackCircuitFailureMode `json:"failureMode"`
+
+	disruptionID string
+	items        []string
+	active       bool
+	simulation   *Simulation
+}
+
+// ID returns the unique identifier of this disruption
+func (d *Disruption) ID() string {
+	return d.disruptionID
+}
+
+// Items returns the IDs of the track items affected by this disruption
+func (d *Disruption) Items() []string {
+	return d.items
+}
+
+// IsActive returns true if this disruption is currently affecting the simulation
+func (d *Disruption) IsActive() bool {
+	return d.active
+}
+
+// Status returns PLANNED, ACTIVE or ENDED
+func (d *Disruption) Status() string {
+	return scheduleStatus(d.active, d.EndTime.Time, d.simulation.Options.CurrentTime.Time)
+}
+
+// shouldBeActive returns true if this disruption is scheduled at the given time
+func (d *Disruption) shouldBeActive(now time.Time) bool {
+	return isScheduledAt(d.StartTime.Time, d.EndTime.Time, now)
+}
+
+// scheduleStatus returns PLANNED, ACTIVE or ENDED for an object that is
+// active or not and scheduled until end.
+func scheduleStatus(active bool, end, now time.Time) string {
+	switch {
+	case active:
+		return "ACTIVE"
+	case !end.IsZero() && !now.Before(end):
+		return "ENDED"
+	default:
+		return "PLANNED"
+	}
+}
+
+// isScheduledAt returns true if now is between start and end. A zero start
+// or end leaves the schedule open on that side.
+func isScheduledAt(start, end, now time.Time) bool {
+	if !start.IsZero() && now.Before(start) {
+		return false
+	}
+	if !end.IsZero() && !now.Before(end) {
+		return false
+	}
+	return true
+}
+
+// formatScheduleTime formats a time of a schedule of sim as its objects do,
+// or as an empty string for a zero time.
+func formatScheduleTime(sim *Simulation, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatObjectTime(sim, t)
+}
+
+// MarshalJSON method for Disruption
+func (d *Disruption) MarshalJSON() ([]byte, error) {
+	type auxDisruption struct {
+		ID            string         `json:"id"`
+		Type          DisruptionType `json:"type"`
+		TrackItemID   string         `json:"trackItemId"`
+		ToTrackItemID string         `json:"toTrackItemId,omitempty"`
+		SpeedLimit    float64        `json:"speedLimit,omitempty"`
+		StartTime     string         `json:"startTime"`
+		EndTime       string         `json:"endTime"`
+		Reason        string         `json:"reason,omitempty"`
+		FailureMode   string         `json:"failureMode,omitempty"`
+		Items         []string       `json:"items"`
+		Status        string         `json:"status"`
+	}
+	return json.Marshal(auxDisruption{
+		ID:            d.disruptionID,
+		Type:          d.Type,
+		TrackItemID:   d.TrackItemID,
+		ToTrackItemID: d.ToTrackItemID,
+		SpeedLimit:    d.SpeedLimit,
+		StartTime:     formatScheduleTime(d.simulation, d.StartTime.Time),
+		EndTime:       formatScheduleTime(d.simulation, d.EndTime.Time),
+		Reason:        d.Reason,
+		FailureMode:   string(d.FailureMode),
+		Items:         d.items,
+		Status:        d.Status(),
+	})
+}
+
+// resolveItems checks this disruption and computes the track items it affects.
+func (d *Disruption) resolveItems() error {
+	ti, ok := d.simulation.TrackItems[d.TrackItemID]
+	if !ok {
+		return fmt.Errorf("unknown track item: %s", d.TrackItemID)
+	}
+	if !d.StartTime.IsZero() && !d.EndTime.IsZero() && !d.StartTime.Time.Before(d.EndTime.Time) {
+		return fmt.Errorf("end time must be after start time")
+	}
+	switch d.Type {
+	case DisruptionTrackBlocked:
+		d.items = []string{ti.ID()}
+	case DisruptionSignalFailed:
+		if _, ok := ti.(*SignalItem); !ok {
+			return fmt.Errorf("track item %s is not a signal", ti.ID())
+		}
+		d.items = []string{ti.ID()}
+	case DisruptionPointsLocked:
+		if _, ok := ti.(*PointsItem); !ok {
+			return fmt.Errorf("track item %s is not a points item", ti.ID())
+		}
+		d.items = []string{ti.ID()}
+	case DisruptionSpeedRestriction, DisruptionTrackCircuitFailed:
+		if d.Type == DisruptionSpeedRestriction && d.SpeedLimit <= 0 {
+			return fmt.Errorf("speed restriction requires a positive speed limit")
+		}
+		if d.Type == DisruptionTrackCircuitFailed {
+			mode, err := ParseTrackCircuitFailureMode(string(d.FailureMode))
+			if err != nil {
+				return err
+			}
+			d.FailureMode = mode
+		}
+		if d.ToTrackItemID == "" {
+			d.items = []string{ti.ID()}
+			break
+		}
+		to, ok := d.simulation.TrackItems[d.ToTrackItemID]
+		if !ok {
+			return fmt.Errorf("unknown track item: %s", d.ToTrackItemID)
+		}
+		items, err := itemsBetween(ti, to)
+		if err != nil {
+			return err
+		}
+		d.items = items
+	default:
+		return fmt.Errorf("unknown disruption type: %s", d.Type)
+	}
+	return nil
+}
+
+// itemsBetween returns the IDs of the track items on the shortest path
+// between from and to, both included.
+func itemsBetween(from, to TrackItem) ([]string, error) {
+	previous := map[string]string{from.ID(): ""}
+	queue := []TrackItem{from}
+	for len(queue) > 0 && len(previous) <= maxRestrictionItems {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur.ID() == to.ID() {
+			var res []string
+			for id := to.ID(); id != ""; id = previous[id] {
+				res = append([]string{id}, res...)
+			}
+			return res, nil
+		}
+		neighbours := []TrackItem{cur.NextItem(), cur.PreviousItem()}
+		if pi, ok := cur.(*PointsItem); ok {
+			neighbours = append(neighbours, pi.ReverseItem())
+		}
+		for _, n := range neighbours {
+			if n == nil {
+				continue
+			}
+			if _, seen := previous[n.ID()]; seen {
+				continue
+			}
+			previous[n.ID()] = cur.ID()
+			queue = append(queue, n)
+		}
+	}
+	return nil, fmt.Errorf("no path found between track items %s and %s", from.ID(), to.ID())
+}
+
+// AddDisruption checks the given disruption and adds it to the simulation.
+// The disruption is applied immediately if it is already scheduled.
+func (sim *Simulation) AddDisruption(d *Disruption) error {
+	d.simulation = sim
+	if err := d.resolveItems(); err != nil {
+		return err
+	}
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	if sim.disruptions == nil {
+		sim.disruptions = make(map[string]*Disruption)
+	}
+	sim.lastDisruptionID++
+	d.disruptionID = strconv.Itoa(sim.lastDisruptionID)
+	sim.disruptions[d.disruptionID] = d
+	sim.sendEvent(&Event{Name: DisruptionChangedEvent, Object: d})
+	sim.updateDisruptionsLocked()
+	return nil
+}
+
+// RemoveDisruption removes the disruption with the given ID from the
+// simulation and clears its effects.
+func (sim *Simulation) RemoveDisruption(id string) error {
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	d, ok := sim.disruptions[id]
+	if !ok {
+		return fmt.Errorf("unknown disruption: %s", id)
+	}
+	delete(sim.disruptions, id)
+	if d.active {
+		d.active = false
+		sim.applyDisruptionEffects(d)
+		sim.MessageLogger.addMessage(fmt.Sprintf("Disruption %s cleared on %s", d.Type, d.TrackItemID), simulationMsg)
+		sim.disruptionsChanged()
+	}
+	sim.sendEvent(&Event{Name: DisruptionChangedEvent, Object: d})
+	return nil
+}
+
+// Disruptions returns all the disruptions of the simulation ordered by ID.
+func (sim *Simulation) Disruptions() []*Disruption {
+	sim.disruptionsMutex.RLock()
+	defer sim.disruptionsMutex.RUnlock()
+	res := make([]*Disruption, 0, len(sim.disruptions))
+	for _, d := range sim.disruptions {
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, _ := strconv.Atoi(res[i].disruptionID)
+		b, _ := strconv.Atoi(res[j].disruptionID)
+		return a < b
+	})
+	return res
+}
+
+// GetDisruption returns the disruption with the given ID
+func (sim *Simulation) GetDisruption(id string) (*Disruption, bool) {
+	sim.disruptionsMutex.RLock()
+	defer sim.disruptionsMutex.RUnlock()
+	d, ok := sim.disruptions[id]
+	return d, ok
+}
+
+// updateDisruptions starts and ends disruptions according to their schedule.
+func (sim *Simulation) updateDisruptions() {
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	sim.updateDisruptionsLocked()
+	sim.updateSpeedRestrictionsLocked()
+	sim.updatePossessionsLocked()
+}
+
+func (sim *Simulation) updateDisruptionsLocked() {
+	changed := false
+	for _, d := range sim.disruptions {
+		active := d.shouldBeActive(sim.Options.CurrentTime.Time)
+		if active == d.active {
+			continue
+		}
+		d.active = active
+		sim.applyDisruptionEffects(d)
+		if active {
+			sim.MessageLogger.addMessage(fmt.Sprintf("Disruption %s started on %s", d.Type, d.TrackItemID), simulationMsg)
+		} else {
+			sim.MessageLogger.addMessage(fmt.Sprintf("Disruption %s ended on %s", d.Type, d.TrackItemID), simulationMsg)
+		}
+		sim.sendEvent(&Event{Name: DisruptionChangedEvent, Object: d})
+		changed = true
+	}
+	if changed {
+		sim.disruptionsChanged()
+	}
+}
+
+// applyDisruptionEffects sets the state of the items of the given disruption
+// according to all active disruptions of the same type.
+func (sim *Simulation) applyDisruptionEffects(d *Disruption) {
+	for _, id := range d.items {
+		ti := sim.TrackItems[id]
+		active := sim.activeDisruptionsOn(id, d.Type)
+		switch d.Type {
+		case DisruptionTrackBlocked:
+			sim.applyBlocked(id)
+		case DisruptionSignalFailed:
+			ti.(*SignalItem).SetFailed(len(active) > 0)
+		case DisruptionPointsLocked:
+			ti.(*PointsItem).SetLocked(len(active) > 0)
+		case DisruptionSpeedRestriction:
+			sim.applySpeedLimit(id)
+		case DisruptionTrackCircuitFailed:
+			sim.applyDetectionFailure(id)
+		}
+	}
+}
+
+// activeDisruptionsOn returns the active disruptions of the given type that
+// affect the given track item.
+func (sim *Simulation) activeDisruptionsOn(id string, typ DisruptionType) []*Disruption {
+	var res []*Disruption
+	for _, d := range sim.disruptions {
+		if !d.active || d.Type != typ {
+			continue
+		}
+		for _, iid := range d.items {
+			if iid == id {
+				res = append(res, d)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// disruptionsChanged makes the suggestion engine react to disruptions
+// starting or ending.
+func (sim *Simulation) disruptionsChanged() {
+	if sim.suggestionEngine != nil && sim.Options.SuggestionsEnabled {
+		sim.suggestionEngine.Recompute()
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestDisruptions(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing disruptions", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+		Convey("Blocked track items should refuse route activation", func() {
+			d := &simulation.Disruption{Type: simulation.DisruptionTrackBlocked, TrackItemID: "14"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(d.ID(), ShouldNotBeEmpty)
+			So(d.Status(), ShouldEqual, "ACTIVE")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeTrue)
+			So(sim.TrackItems["14"].MaxSpeed(), ShouldEqual, 0)
+			So(sim.Routes["2"].Activate(false), ShouldNotBeNil)
+			So(sim.RemoveDisruption(d.ID()), ShouldBeNil)
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+			So(sim.Disruptions(), ShouldBeEmpty)
+			So(sim.Routes["2"].Activate(false), ShouldBeNil)
+		})
+		Convey("Locked points should refuse routes in the other direction", func() {
+			d := &simulation.Disruption{Type: simulation.DisruptionPointsLocked, TrackItemID: "7"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sim.TrackItems["7"].(*simulation.PointsItem).Locked(), ShouldBeTrue)
+			So(sim.Routes["2"].Activate(false), ShouldNotBeNil)
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+		})
+		Convey("Failed signals should show danger", func() {
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			sig := sim.TrackItems["5"].(*simulation.SignalItem)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			d := &simulation.Disruption{Type: simulation.DisruptionSignalFailed, TrackItemID: "5"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sig.Failed(), ShouldBeTrue)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			So(sim.RemoveDisruption(d.ID()), ShouldBeNil)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			So(sim.AddDisruption(&simulation.Disruption{Type: simulation.DisruptionSignalFailed, TrackItemID: "4"}), ShouldNotBeNil)
+		})
+		Convey("Failed track circuits should hold signals at danger", func() {
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			sig := sim.TrackItems["5"].(*simulation.SignalItem)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			So(sim.AddDisruption(&simulation.Disruption{Type: simulation.DisruptionTrackCircuitFailed, TrackItemID: "8", FailureMode: "BROKEN"}), ShouldNotBeNil)
+			d := &simulation.Disruption{Type: simulation.DisruptionTrackCircuitFailed, TrackItemID: "6", ToTrackItemID: "8",
+				FailureMode: simulation.TrackCircuitShowsClear}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(d.Items(), ShouldResemble, []string{"6", "7", "8"})
+			ti := sim.TrackItems["8"]
+			So(ti.DetectionFailure(), ShouldEqual, simulation.TrackCircuitShowsClear)
+			So(ti.ShowsOccupied(), ShouldBeFalse)
+			So(ti.Occupied(), ShouldBeTrue)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			So(sim.RemoveDisruption(d.ID()), ShouldBeNil)
+			So(ti.DetectionFailure(), ShouldBeEmpty)
+			So(ti.Occupied(), ShouldBeFalse)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			d = &simulation.Disruption{Type: simulation.DisruptionTrackCircuitFailed, TrackItemID: "10"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sim.TrackItems["10"].ShowsOccupied(), ShouldBeTrue)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeFalse)
+		})
+		Convey("Speed restrictions should apply between two items", func() {
+			d := &simulation.Disruption{Type: simulation.DisruptionSpeedRestriction, TrackItemID: "2", ToTrackItemID: "6", SpeedLimit: 5}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(d.Items(), ShouldResemble, []string{"2", "3", "4", "5", "6"})
+			So(sim.TrackItems["2"].MaxSpeed(), ShouldEqual, 5)
+			So(sim.TrackItems["6"].MaxSpeed(), ShouldEqual, 5)
+			So(sim.TrackItems["8"].MaxSpeed(), ShouldEqual, 10)
+			d2 := &simulation.Disruption{Type: simulation.DisruptionSpeedRestriction, TrackItemID: "6", SpeedLimit: 3}
+			So(sim.AddDisruption(d2), ShouldBeNil)
+			So(sim.TrackItems["6"].MaxSpeed(), ShouldEqual, 3)
+			So(sim.RemoveDisruption(d2.ID()), ShouldBeNil)
+			So(sim.TrackItems["6"].MaxSpeed(), ShouldEqual, 5)
+			So(sim.AddDisruption(&simulation.Disruption{Type: simulation.DisruptionSpeedRestriction, TrackItemID: "6"}), ShouldNotBeNil)
+		})
+		Convey("Disruptions should follow their schedule", func() {
+			d := &simulation.Disruption{Type: simulation.DisruptionTrackBlocked, TrackItemID: "14"}
+			d.StartTime.Time = sim.Options.CurrentTime.Time.Add(time.Second)
+			d.EndTime.Time = sim.Options.CurrentTime.Time.Add(3 * time.Second)
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(d.Status(), ShouldEqual, "PLANNED")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+			sim.Step()
+			So(d.Status(), ShouldEqual, "ACTIVE")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeTrue)
+			sim.Step()
+			So(d.Status(), ShouldEqual, "ENDED")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+		})
+	})
+}
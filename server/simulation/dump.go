@@ -0,0 +1,166 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// dumpBufferSize is the size of the buffer of WriteJSON
+const dumpBufferSize = 32 * 1024
+
+// A jsonStreamWriter writes a JSON document to an io.Writer piece by piece.
+// The first error is kept and the following writes are ignored.
+type jsonStreamWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// raw writes s as is
+func (jw *jsonStreamWriter) raw(s string) {
+	if jw.err != nil {
+		return
+	}
+	_, jw.err = jw.w.WriteString(s)
+}
+
+// value writes the JSON encoding of v
+func (jw *jsonStreamWriter) value(v interface{}) {
+	if jw.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		jw.err = err
+		return
+	}
+	_, jw.err = jw.w.Write(data)
+}
+
+// object writes a JSON object with the given keys in sorted order, as
+// json.Marshal does for maps. get returns the value of each key.
+func (jw *jsonStreamWriter) object(keys []string, get func(string) interface{}) {
+	sort.Strings(keys)
+	jw.raw("{")
+	for i, k := range keys {
+		if i > 0 {
+			jw.raw(",")
+		}
+		jw.value(k)
+		jw.raw(":")
+		jw.value(get(k))
+	}
+	jw.raw("}")
+}
+
+// WriteJSON writes the JSON encoding of the simulation to w, the same as
+// json.Marshal. Routes, track items and trains are encoded one at a time, so
+// that the whole document is never held in memory.
+func (sim *Simulation) WriteJSON(w io.Writer) error {
+	jw := &jsonStreamWriter{w: bufio.NewWriterSize(w, dumpBufferSize)}
+	jw.raw(`{"__type__":"Simulation","messageLogger":`)
+	jw.value(sim.MessageLogger)
+	jw.raw(`,"options":`)
+	jw.value(&sim.Options)
+
+	jw.raw(`,"routes":`)
+	keys := make([]string, 0, len(sim.Routes))
+	for k := range sim.Routes {
+		keys = append(keys, k)
+	}
+	jw.object(keys, func(k string) interface{} { return sim.Routes[k] })
+
+	jw.raw(`,"trainTypes":`)
+	jw.value(sim.TrainTypes)
+
+	jw.raw(`,"services":`)
+	keys = make([]string, 0, len(sim.Services))
+	for k := range sim.Services {
+		keys = append(keys, k)
+	}
+	jw.object(keys, func(k string) interface{} { return sim.Services[k] })
+
+	// Places are track items too
+	jw.raw(`,"trackItems":`)
+	places := make(map[string]*Place, len(sim.Places))
+	for _, pl := range sim.Places {
+		places[pl.ID()] = pl
+	}
+	keys = make([]string, 0, len(sim.TrackItems)+len(places))
+	for k := range sim.TrackItems {
+		if _, ok := places[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range places {
+		keys = append(keys, k)
+	}
+	jw.object(keys, func(k string) interface{} {
+		if pl, ok := places[k]; ok {
+			return pl
+		}
+		return sim.TrackItems[k]
+	})
+
+	if len(sim.sections) > 0 {
+		jw.raw(`,"sections":`)
+		jw.value(sim.sections)
+	}
+	if len(sim.transfers) > 0 {
+		jw.raw(`,"transfers":`)
+		jw.value(sim.transfers)
+	}
+	if len(sim.depots) > 0 {
+		jw.raw(`,"depots":`)
+		jw.value(sim.depots)
+	}
+	if len(sim.trainingScenarios) > 0 {
+		jw.raw(`,"trainingScenarios":`)
+		jw.value(sim.trainingScenarios)
+	}
+	if len(sim.controlAreas) > 0 {
+		jw.raw(`,"controlAreas":`)
+		jw.value(sim.controlAreas)
+	}
+
+	jw.raw(`,"trains":`)
+	if sim.Trains == nil {
+		jw.raw("null")
+	} else {
+		jw.raw("[")
+		for i, t := range sim.Trains {
+			if i > 0 {
+				jw.raw(",")
+			}
+			jw.value(t)
+		}
+		jw.raw("]")
+	}
+
+	jw.raw(`,"signalLibrary":`)
+	jw.value(&sim.SignalLib)
+	jw.raw("}")
+	if jw.err != nil {
+		return jw.err
+	}
+	return jw.w.Flush()
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// ecoMaxDistance is the maximum distance in meters looked ahead of a
+	// train to find its next stop.
+	ecoMaxDistance float64 = 20000
+	// ecoMinSpeed is the lowest advisory speed in m/s.
+	ecoMinSpeed float64 = 5
+	// ecoCoastMargin is the margin in m/s above its advisory speed from which
+	// a train is advised to coast.
+	ecoCoastMargin float64 = 1
+	// coastingDeceleration is the deceleration in m/s² of a coasting train on
+	// a flat and straight track.
+	coastingDeceleration float64 = 0.05
+)
+
+// An EcoProfilePoint is the advisory speed of a train on a track item ahead.
+type EcoProfilePoint struct {
+	TrackItem TrackItem
+	// Distance is the distance in meters from the train head to this item.
+	Distance float64
+	// Speed is the advisory speed in m/s on this item.
+	Speed float64
+}
+
+// An EcoAdvisory is the energy-saving driving advice for a train running to
+// its next scheduled stop.
+//
+// The train saves energy by running as slow as possible while still arriving
+// on time: the advisory speed is the lowest cruising speed with which the
+// train reaches its next stop at the scheduled time, given the speed limits,
+// slopes and curves on the way.
+type EcoAdvisory struct {
+	Train *Train
+	// PlaceCode is the code of the next stop of the train.
+	PlaceCode string
+	// Distance is the distance in meters from the train head to the next stop.
+	Distance float64
+	// ScheduledArrival is the scheduled arrival time at the next stop.
+	ScheduledArrival time.Time
+	// Slack is the time the train would arrive early at full performance, or
+	// a negative duration if it is running late.
+	Slack time.Duration
+	// AdvisorySpeed is the energy-optimal cruising speed in m/s.
+	AdvisorySpeed float64
+	// Coast is true if the train runs faster than needed and should coast now.
+	Coast bool
+	// Profile is the advisory speed on each track item up to the next stop.
+	Profile []EcoProfilePoint
+}
+
+// An ecoSegment is the part of a track item that a train runs on to reach its
+// next stop.
+type ecoSegment struct {
+	pos        Position
+	start      float64
+	length     float64
+	limit      float64
+	resistance float64
+}
+
+// pathToPlace returns the segments ahead of this train up to the first item of
+// place and the distance to it. The last result is false if the place cannot be
+// reached by following the current points directions.
+func (t *Train) pathToPlace(place *Place) ([]ecoSegment, float64, bool) {
+	tt := t.TrainType()
+	var segs []ecoSegment
+	pos := t.TrainHead
+	var distance float64
+	for distance < ecoMaxDistance {
+		ti := pos.TrackItem()
+		if ti == nil || ti.Type() == TypeEnd {
+			return nil, 0, false
+		}
+		if ti.Place() == place {
+			return segs, distance, true
+		}
+		seg := ecoSegment{
+			pos:        pos,
+			start:      distance,
+			length:     ti.RealLength() - pos.PositionOnTI,
+			limit:      tt.balancingSpeed(pos.resistance()),
+			resistance: pos.resistance(),
+		}
+		if lineSpeed := ti.MaxSpeed(); lineSpeed > 0 && lineSpeed < seg.limit {
+			seg.limit = lineSpeed
+		}
+		if restriction := ti.SpeedRestriction(); restriction > 0 && restriction < seg.limit {
+			seg.limit = restriction
+		}
+		segs = append(segs, seg)
+		distance += seg.length
+		pos = pos.Next(DirectionCurrent)
+		pos.PositionOnTI = 0
+	}
+	return nil, 0, false
+}
+
+// ecoRunningTime returns the time in seconds for this train to run on the given
+// segments without exceeding the cruise speed.
+func (t *Train) ecoRunningTime(segs []ecoSegment, cruise float64) float64 {
+	tt := t.TrainType()
+	speed := t.Speed
+	var seconds float64
+	for _, seg := range segs {
+		seconds += tt.runningTime(seg.length, &speed, math.Min(cruise, seg.limit), seg.resistance)
+	}
+	return seconds
+}
+
+// EcoAdvisory returns the energy-saving driving advice for this train to its
+// next stop. The second result is false if the train is not running to a
+// scheduled stop ahead.
+func (t *Train) EcoAdvisory() (*EcoAdvisory, bool) {
+	if !t.IsActive() || t.Status != Running || t.IsHeld() {
+		return nil, false
+	}
+	sl := t.nextStopLine()
+	if sl == nil || sl.Place() == nil {
+		return nil, false
+	}
+	scheduled := sl.ScheduledArrivalTime.Time
+	if sl.ScheduledArrivalTime.IsZero() {
+		scheduled = sl.ScheduledDepartureTime.Time
+	}
+	if scheduled.IsZero() {
+		return nil, false
+	}
+	segs, distance, ok := t.pathToPlace(sl.Place())
+	if !ok {
+		return nil, false
+	}
+	tt := t.TrainType()
+	available := scheduled.Sub(t.simulation.Options.CurrentTime.Time).Seconds()
+	fastest := t.ecoRunningTime(segs, tt.MaxSpeed)
+	cruise := tt.MaxSpeed
+	if available > fastest {
+		// Lowest cruising speed that still arrives on time
+		low, high := math.Min(ecoMinSpeed, tt.MaxSpeed), tt.MaxSpeed
+		for i := 0; i < 20; i++ {
+			mid := (low + high) / 2
+			if t.ecoRunningTime(segs, mid) > available {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+		cruise = high
+	}
+	ea := EcoAdvisory{
+		Train:            t,
+		PlaceCode:        sl.PlaceCode,
+		Distance:         distance,
+		ScheduledArrival: scheduled,
+		Slack:            time.Duration((available - fastest) * float64(time.Second)),
+		AdvisorySpeed:    cruise,
+		Coast:            t.Speed > cruise+ecoCoastMargin,
+	}
+	for _, seg := range segs {
+		ea.Profile = append(ea.Profile, EcoProfilePoint{
+			TrackItem: seg.pos.TrackItem(),
+			Distance:  seg.start,
+			Speed:     math.Min(cruise, seg.limit),
+		})
+	}
+	return &ea, true
+}
+
+// IsCoasting returns true if this train is coasting down to its advisory speed
+// until its next stop.
+func (t *Train) IsCoasting() bool {
+	return t.coastSpeed > 0
+}
+
+// Coast makes this train coast down to its advisory speed and keep it until
+// its next stop, to save energy while still arriving on time.
+func (t *Train) Coast() error {
+	ea, ok := t.EcoAdvisory()
+	if !ok {
+		return fmt.Errorf("train %s is not running to a scheduled stop", t.ID())
+	}
+	if ea.Slack <= 0 {
+		return fmt.Errorf("train %s is running late", t.ID())
+	}
+	t.coastSpeed = ea.AdvisorySpeed
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+	return nil
+}
+
+// coast limits the speed of this coasting train, which slows down by rolling
+// resistance only until it reaches its advisory speed. The train stops
+// coasting when it stops at a station.
+func (t *Train) coast(previousSpeed, secs float64) {
+	if t.coastSpeed <= 0 {
+		return
+	}
+	deceleration := math.Max(0, coastingDeceleration+t.TrainHead.resistance())
+	t.Speed = math.Min(t.Speed, math.Max(t.coastSpeed, previousSpeed-deceleration*secs))
+}
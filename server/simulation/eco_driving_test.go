@@ -0,0 +1,88 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestEcoDriving(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the stop of the first train at
+	// STN two minutes later, so that it is early.
+	loadSim := func() *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, nil)
+		So(err, ShouldBeNil)
+		line := sim.Services["S001"].Lines[1]
+		line.ScheduledArrivalTime.Time = line.ScheduledArrivalTime.Time.Add(2 * time.Minute)
+		line.ScheduledDepartureTime.Time = line.ScheduledDepartureTime.Time.Add(2 * time.Minute)
+		return sim
+	}
+	// runToStop makes the first train run until it stops at its station.
+	runToStop := func(sim *simulation.Simulation) {
+		train := sim.Trains[0]
+		So(stepUntil(sim, 3000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+	}
+	Convey("Testing eco-driving advisories", t, func() {
+		sim := loadSim()
+		train := sim.Trains[0]
+		_, ok := train.EcoAdvisory()
+		So(ok, ShouldBeFalse)
+		So(stepUntil(sim, 600, func() bool { return train.Speed > 8 }), ShouldBeTrue)
+		Convey("An early train should get an advisory speed below its maximum speed", func() {
+			ea, ok := train.EcoAdvisory()
+			So(ok, ShouldBeTrue)
+			So(ea.Train, ShouldEqual, train)
+			So(ea.PlaceCode, ShouldEqual, "STN")
+			So(ea.Distance, ShouldBeGreaterThan, 0)
+			So(ea.Slack.Seconds(), ShouldBeGreaterThan, 30)
+			So(ea.AdvisorySpeed, ShouldBeLessThan, train.TrainType().MaxSpeed)
+			So(ea.Coast, ShouldBeTrue)
+			So(ea.Profile, ShouldNotBeEmpty)
+			So(ea.Profile[0].TrackItem, ShouldEqual, train.TrainHead.TrackItem())
+			for _, p := range ea.Profile {
+				So(p.Speed, ShouldBeLessThanOrEqualTo, ea.AdvisorySpeed)
+			}
+		})
+		Convey("Suggestions should advise early trains to coast", func() {
+			sim.RecomputeSuggestions()
+			var coast *simulation.Suggestion
+			for i, s := range sim.Suggestions.Items {
+				if s.Kind == simulation.SuggestionTrainCoast {
+					coast = &sim.Suggestions.Items[i]
+				}
+			}
+			So(coast, ShouldNotBeNil)
+			So(coast.ID, ShouldEqual, "TRAIN_COAST:0")
+			So(coast.Actions[0].Object, ShouldEqual, "train")
+			So(coast.Actions[0].Action, ShouldEqual, "coast")
+			So(sim.AcceptSuggestion(coast.ID), ShouldBeNil)
+			So(train.IsCoasting(), ShouldBeTrue)
+			speed := train.Speed
+			for i := 0; i < 10; i++ {
+				sim.Step()
+			}
+			So(train.Speed, ShouldBeLessThan, speed)
+			sim.RecomputeSuggestions()
+			for _, s := range sim.Suggestions.Items {
+				So(s.Kind, ShouldNotEqual, simulation.SuggestionTrainCoast)
+			}
+		})
+		Convey("Coasting trains should use less energy and stop coasting at their stop", func() {
+			other := loadSim()
+			So(stepUntil(other, 600, func() bool { return other.Trains[0].Speed > 8 }), ShouldBeTrue)
+			So(train.Coast(), ShouldBeNil)
+			runToStop(sim)
+			runToStop(other)
+			So(train.IsCoasting(), ShouldBeFalse)
+			So(train.TractionEnergy(), ShouldBeLessThan, other.Trains[0].TractionEnergy())
+			So(train.Coast(), ShouldNotBeNil)
+		})
+	})
+}
@@ -18,6 +18,8 @@
 
 package simulation
 
+import "encoding/json"
+
 // An EventName is the name of a event
 type EventName string
 
@@ -35,6 +37,26 @@ const (
 	TrackItemChangedEvent         EventName = "trackItemChanged"
 	MessageReceivedEvent          EventName = "messageReceived"
 	SuggestionsUpdatedEvent       EventName = "suggestionsUpdated"
+	DisruptionChangedEvent        EventName = "disruptionChanged"
+	SpeedRestrictionChangedEvent  EventName = "speedRestrictionChanged"
+	PossessionChangedEvent        EventName = "possessionChanged"
+	TrainSplitEvent               EventName = "trainSplit"
+	TrainJoinedEvent              EventName = "trainJoined"
+	SignalFailedEvent             EventName = "signalFailed"
+	SignalRepairedEvent           EventName = "signalRepaired"
+	PointsFailedEvent             EventName = "pointsFailed"
+	PointsRepairedEvent           EventName = "pointsRepaired"
+	PerturbationEvent             EventName = "perturbation"
+	BreakpointHitEvent            EventName = "breakpointHit"
+	ServiceChangedEvent           EventName = "serviceChanged"
+	TrainAddedEvent               EventName = "trainAdded"
+	TrainCancelledEvent           EventName = "trainCancelled"
+	TransferChangedEvent          EventName = "transferChanged"
+	LevelCrossingChangedEvent     EventName = "levelCrossingChanged"
+	LevelCrossingFailedEvent      EventName = "levelCrossingFailed"
+	LevelCrossingRepairedEvent    EventName = "levelCrossingRepaired"
+	OverspeedEvent                EventName = "overspeed"
+	SignalPassedAtDangerEvent     EventName = "signalPassedAtDanger"
 )
 
 // A SimObject can be serialized in an event
@@ -43,9 +65,33 @@ type SimObject interface {
 }
 
 // Event is a wrapper around an object that is sent to the server hub to notify clients of a change.
+//
+// Data is the JSON encoding of Object when the event was sent, and Snapshot
+// the state of the simulation taken then by the registered EventSnapshotter.
+// The simulation keeps changing Object and its other objects once the event
+// is received, so that they may only be read from the goroutine of the
+// simulation, whereas Data and Snapshot can be read from anywhere.
 type Event struct {
-	Name   EventName
-	Object SimObject
+	Name     EventName
+	Object   SimObject
+	Data     json.RawMessage
+	Snapshot interface{}
+}
+
+// An EventSnapshotter returns the state of sim that the receivers of event e
+// need besides its object. It is called when e is sent, on the goroutine of
+// the simulation.
+type EventSnapshotter func(sim *Simulation, e *Event) interface{}
+
+var snapshotter EventSnapshotter
+
+// RegisterEventSnapshotter registers the given snapshotter for all
+// simulations.
+//
+// If a snapshotter was already registered, it is replaced by s. It must be
+// called before the simulations are started.
+func RegisterEventSnapshotter(s EventSnapshotter) {
+	snapshotter = s
 }
 
 // An IntObject is a SimObject that wraps a single integer value
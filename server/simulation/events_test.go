@@ -0,0 +1,53 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// loadBenchmarkSimulation returns the demo simulation with its trains
+// duplicated until it has the given number of trains.
+func loadBenchmarkSimulation(tb testing.TB, trains int) *Simulation {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(loadSim("testdata/demo.json"), &raw); err != nil {
+		tb.Fatal(err)
+	}
+	demoTrains := raw["trains"].([]interface{})
+	for i := len(demoTrains); i < trains; i++ {
+		tr := make(map[string]interface{})
+		for k, v := range demoTrains[i%len(demoTrains)].(map[string]interface{}) {
+			tr[k] = v
+		}
+		tr["trainId"] = fmt.Sprint(i)
+		demoTrains = append(demoTrains, tr)
+	}
+	raw["trains"] = demoTrains
+	data, _ := json.Marshal(raw)
+	sim := new(Simulation)
+	if err := json.Unmarshal(data, sim); err != nil {
+		tb.Fatal(err)
+	}
+	return sim
+}
+
+// BenchmarkSendTrainEvents measures the sending of the changes of the trains
+// of a 100-train simulation, which are encoded on the goroutine of the
+// simulation.
+func BenchmarkSendTrainEvents(b *testing.B) {
+	sim := loadBenchmarkSimulation(b, 100)
+	sim.EventChan = make(chan *Event, len(sim.Trains))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, t := range sim.Trains {
+			sim.sendEvent(&Event{Name: TrainChangedEvent, Object: t})
+		}
+		for range sim.Trains {
+			<-sim.EventChan
+		}
+	}
+}
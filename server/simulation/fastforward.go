@@ -0,0 +1,59 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// FastForward runs the simulation as fast as possible until its time reaches
+// until, then returns. The simulation is stepped in the calling goroutine
+// without waiting for the clock, with the current time factor.
+//
+// FastForward stops before until if a breakpoint is hit. Events are sent as
+// usual, except clock events which are sent only once at the end. The
+// simulation must be paused and cannot be started until FastForward returns.
+func (sim *Simulation) FastForward(until time.Time) error {
+	if sim.started {
+		return fmt.Errorf("simulation must be paused to fast-forward")
+	}
+	if !until.After(sim.Options.CurrentTime.Time) {
+		return fmt.Errorf("cannot fast-forward to %s which is not after the current time %s",
+			FormatTime(until), FormatTime(sim.Options.CurrentTime.Time))
+	}
+	if !atomic.CompareAndSwapInt32(&sim.fastForwarding, 0, 1) {
+		return fmt.Errorf("simulation is already fast-forwarding")
+	}
+	for sim.Options.CurrentTime.Time.Before(until) {
+		sim.Step()
+		if sim.checkBreakpoints() {
+			break
+		}
+	}
+	atomic.StoreInt32(&sim.fastForwarding, 0)
+	sim.sendEvent(&Event{Name: ClockEvent, Object: Time{Time: sim.Options.CurrentTime.Time}})
+	return nil
+}
+
+// IsFastForwarding returns true while FastForward runs the simulation.
+func (sim *Simulation) IsFastForwarding() bool {
+	return atomic.LoadInt32(&sim.fastForwarding) == 1
+}
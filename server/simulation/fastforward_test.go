@@ -0,0 +1,70 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestFastForward(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing simulation fast-forward", t, func() {
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		var sim, ref simulation.Simulation
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		So(json.Unmarshal(data, &ref), ShouldBeNil)
+		ref.Options.Seed = sim.Options.Seed
+		clocks := make(chan time.Time, 10)
+		go func() {
+			for {
+				select {
+				case e := <-sim.EventChan:
+					if e.Name == simulation.ClockEvent {
+						clocks <- e.Object.(simulation.Time).Time
+					}
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		drainEvents(&ref, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		So(ref.Initialize(), ShouldBeNil)
+		target := sim.Options.CurrentTime.Time.Add(10 * time.Minute)
+		Convey("Fast-forward should run the simulation until the given time", func() {
+			So(sim.FastForward(target), ShouldBeNil)
+			So(sim.Options.CurrentTime.Time, ShouldResemble, target)
+			So(sim.IsFastForwarding(), ShouldBeFalse)
+			So(sim.IsStarted(), ShouldBeFalse)
+			Convey("Sending a single clock event", func() {
+				So(<-clocks, ShouldResemble, target)
+				So(clocks, ShouldBeEmpty)
+			})
+			Convey("As if it had been run normally", func() {
+				for ref.Options.CurrentTime.Time.Before(target) {
+					ref.Step()
+				}
+				So(simState(&sim), ShouldResemble, simState(&ref))
+			})
+		})
+		Convey("Fast-forward by a tick should make a single step", func() {
+			start := sim.Options.CurrentTime.Time
+			So(sim.FastForward(start.Add(sim.Tick())), ShouldBeNil)
+			ref.Step()
+			So(sim.Options.CurrentTime.Time, ShouldResemble, ref.Options.CurrentTime.Time)
+			So(sim.Options.CurrentTime.Time.Sub(start), ShouldEqual, time.Duration(sim.Options.TimeFactor)*500*time.Millisecond)
+		})
+		Convey("Fast-forward to the past should fail", func() {
+			So(sim.FastForward(sim.Options.CurrentTime.Time), ShouldNotBeNil)
+			So(sim.FastForward(sim.Options.CurrentTime.Time.Add(-time.Minute)), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sort"
+)
+
+// initializeFlankProtection checks the flank protection points of this route
+// and registers the route on them.
+func (r *Route) initializeFlankProtection() error {
+	ids := make([]string, 0, len(r.FlankProtection))
+	for id := range r.FlankProtection {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		pi, ok := r.simulation.TrackItems[id].(*PointsItem)
+		if !ok {
+			return fmt.Errorf("route Error: flank protection item %s is not a points item", id)
+		}
+		if dir := r.FlankProtection[id]; dir != DirectionNormal && dir != DirectionReversed {
+			return fmt.Errorf("route Error: invalid flank protection direction for points %s", id)
+		}
+		if _, onRoute := r.Directions[id]; onRoute {
+			return fmt.Errorf("route Error: flank protection points %s are on the route", id)
+		}
+		pi.addFlankRoute(r)
+	}
+	return nil
+}
+
+// FlankPoints returns the points which must be set to protect this route from
+// movements on converging lines, sorted by ID.
+func (r *Route) FlankPoints() []*PointsItem {
+	res := make([]*PointsItem, 0, len(r.FlankProtection))
+	for id := range r.FlankProtection {
+		res = append(res, r.simulation.TrackItems[id].(*PointsItem))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID() < res[j].ID()
+	})
+	return res
+}
+
+// checkFlankProtection returns an error if the flank protection of this route
+// cannot be provided, or if setting this route would move points that currently
+// provide flank protection to another route.
+func (r *Route) checkFlankProtection() error {
+	for _, pos := range r.Positions {
+		pi, ok := pos.TrackItem().(*PointsItem)
+		if !ok {
+			continue
+		}
+		for _, fr := range pi.FlankProtectedRoutes() {
+			if !fr.Equals(r) && fr.FlankProtection[pi.ID()] != r.Directions[pi.ID()] {
+				return fmt.Errorf("points %s provide flank protection to route %s", pi.ID(), fr.ID())
+			}
+		}
+	}
+	for _, pi := range r.FlankPoints() {
+		dir := r.FlankProtection[pi.ID()]
+		if ar := pi.ActiveRoute(); ar != nil && ar.Directions[pi.ID()] != dir {
+			return fmt.Errorf("flank protection points %s are set for route %s", pi.ID(), ar.ID())
+		}
+		for _, fr := range pi.FlankProtectedRoutes() {
+			if !fr.Equals(r) && fr.FlankProtection[pi.ID()] != dir {
+				return fmt.Errorf("conflicting flank protection for route %s on points %s", fr.ID(), pi.ID())
+			}
+		}
+		if pi.Reversed() == (dir == DirectionReversed) {
+			continue
+		}
+		if pi.Locked() {
+			return fmt.Errorf("flank protection points %s are locked", pi.ID())
+		}
+		if pi.Failed() {
+			return fmt.Errorf("flank protection points %s have failed", pi.ID())
+		}
+	}
+	return nil
+}
+
+// setFlankProtection sets the flank protection points of this route in their
+// protecting direction.
+func (r *Route) setFlankProtection() {
+	for _, pi := range r.FlankPoints() {
+		if !pi.locked && !pi.Failed() {
+			pointsItemManager.SetDirection(pi, r.FlankProtection[pi.ID()])
+		}
+		r.simulation.sendEvent(&Event{
+			Name:   TrackItemChangedEvent,
+			Object: pi,
+		})
+	}
+}
+
+// addFlankRoute registers r as a route that these points may protect.
+func (pi *PointsItem) addFlankRoute(r *Route) {
+	for _, fr := range pi.flankRoutes {
+		if fr.Equals(r) {
+			return
+		}
+	}
+	pi.flankRoutes = append(pi.flankRoutes, r)
+}
+
+// FlankProtectedRoutes returns the routes that are set or still being
+// released to which these points currently provide flank protection. Such points
+// are locked in the protecting direction.
+func (pi *PointsItem) FlankProtectedRoutes() []*Route {
+	var res []*Route
+	for _, r := range pi.flankRoutes {
+		if r.State() != Deactivated {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// FlankLocked returns true if these points currently provide flank protection
+// to at least one route.
+func (pi *PointsItem) FlankLocked() bool {
+	return len(pi.FlankProtectedRoutes()) > 0
+}
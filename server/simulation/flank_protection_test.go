@@ -0,0 +1,82 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestFlankProtection(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given flank protection on route 11
+	loadSim := func(flank map[string]interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["routes"].(map[string]interface{})["11"].(map[string]interface{})["flankProtection"] = flank
+		})
+	}
+	Convey("Testing flank protection", t, func() {
+		Convey("Invalid flank protection should not be loaded", func() {
+			_, err := loadSim(map[string]interface{}{"8": 1})
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(map[string]interface{}{"7": 3})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Flank protection should be saved", func() {
+			sim, err := loadSim(map[string]interface{}{"7": 1})
+			So(err, ShouldBeNil)
+			r11 := sim.Routes["11"]
+			So(r11.FlankPoints(), ShouldHaveLength, 1)
+			data, err := json.Marshal(r11)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"flankProtection":{"7":1}`)
+		})
+		Convey("Flank protection points should be set and locked", func() {
+			sim, err := loadSim(map[string]interface{}{"7": 1})
+			So(err, ShouldBeNil)
+			for _, tr := range sim.Trains {
+				So(tr.Cancel(), ShouldBeNil)
+			}
+			points := sim.TrackItems["7"].(*simulation.PointsItem)
+			r1, r2, r11 := sim.Routes["1"], sim.Routes["2"], sim.Routes["11"]
+			So(r1.IsActive(), ShouldBeTrue)
+			So(points.Reversed(), ShouldBeFalse)
+			// Route 1 needs the points normal
+			err = r11.Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "set for route 1")
+			So(r11.IsActive(), ShouldBeFalse)
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r11.Activate(false), ShouldBeNil)
+			So(points.Reversed(), ShouldBeTrue)
+			So(points.FlankLocked(), ShouldBeTrue)
+			So(points.FlankProtectedRoutes(), ShouldHaveLength, 1)
+			// Conflicting flank move is refused
+			err = r1.Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "flank protection to route 11")
+			// Moves compatible with the flank protection are allowed
+			So(r2.Activate(false), ShouldBeNil)
+			So(r2.Deactivate(), ShouldBeNil)
+			So(r11.Deactivate(), ShouldBeNil)
+			So(points.FlankLocked(), ShouldBeFalse)
+			So(r1.Activate(false), ShouldBeNil)
+			So(points.Reversed(), ShouldBeFalse)
+		})
+		Convey("Flank protection should not move locked points", func() {
+			sim, err := loadSim(map[string]interface{}{"7": 1})
+			So(err, ShouldBeNil)
+			points := sim.TrackItems["7"].(*simulation.PointsItem)
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			points.SetLocked(true)
+			err = sim.Routes["11"].Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "locked")
+		})
+	})
+}
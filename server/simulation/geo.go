@@ -0,0 +1,287 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// earthRadius is the mean radius of the Earth in metres
+const earthRadius = 6371008.8
+
+// A LatLon is a geographic position in decimal degrees (WGS84)
+type LatLon struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// validate returns an error if l is out of the WGS84 ranges
+func (l LatLon) validate() error {
+	if l.Lat < -90 || l.Lat > 90 || l.Lon < -180 || l.Lon > 180 {
+		return fmt.Errorf("invalid coordinates (%g, %g)", l.Lat, l.Lon)
+	}
+	return nil
+}
+
+// A GeoReference maps the layout of a simulation to geographic coordinates
+// so that it can be shown on real maps.
+//
+// Layout coordinates are mapped either by an affine transform or by a local
+// projection around an origin. Track items listed in Items are placed on
+// their own coordinates instead.
+type GeoReference struct {
+	// Affine gives the longitude and latitude of the layout point (x, y) as
+	// [a, b, c, d, e, f] with lon = a*x + b*y + c and lat = d*x + e*y + f.
+	Affine []float64 `json:"affine,omitempty"`
+
+	// Origin is the position of the layout point (0, 0) and MetersPerUnit
+	// the length of one layout unit. The layout x axis points east, rotated
+	// counterclockwise by Rotation degrees. The y axis points down, as on
+	// screen.
+	Origin        *LatLon `json:"origin,omitempty"`
+	MetersPerUnit float64 `json:"metersPerUnit,omitempty"`
+	Rotation      float64 `json:"rotation,omitempty"`
+
+	// Items gives the coordinates of track items by ID, from the origin to
+	// the end of the item. Positions on these items are interpolated along
+	// the coordinates.
+	Items map[string][]LatLon `json:"items,omitempty"`
+}
+
+// Validate returns an error if this GeoReference is inconsistent. A nil
+// GeoReference is valid.
+func (g *GeoReference) Validate() error {
+	if g == nil {
+		return nil
+	}
+	switch {
+	case g.Affine != nil && g.Origin != nil:
+		return errors.New("affine and origin cannot be both set")
+	case g.Affine != nil && len(g.Affine) != 6:
+		return fmt.Errorf("affine must have 6 values, got %d", len(g.Affine))
+	case g.Origin != nil && g.MetersPerUnit <= 0:
+		return errors.New("metersPerUnit must be positive")
+	case g.Affine == nil && g.Origin == nil && len(g.Items) == 0:
+		return errors.New("one of affine, origin or items must be set")
+	}
+	if g.Origin != nil {
+		if err := g.Origin.validate(); err != nil {
+			return fmt.Errorf("origin: %s", err)
+		}
+	}
+	for id, coords := range g.Items {
+		if len(coords) == 0 {
+			return fmt.Errorf("no coordinates for track item %s", id)
+		}
+		for _, c := range coords {
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("track item %s: %s", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mapPoint returns the geographic coordinates of the layout point p. It
+// returns false if this GeoReference only places some track items.
+func (g *GeoReference) mapPoint(p Point) (LatLon, bool) {
+	switch {
+	case g.Affine != nil:
+		a := g.Affine
+		return LatLon{Lat: a[3]*p.X + a[4]*p.Y + a[5], Lon: a[0]*p.X + a[1]*p.Y + a[2]}, true
+	case g.Origin != nil:
+		rot := g.Rotation * math.Pi / 180
+		e0, n0 := p.X*g.MetersPerUnit, -p.Y*g.MetersPerUnit
+		east := e0*math.Cos(rot) - n0*math.Sin(rot)
+		north := e0*math.Sin(rot) + n0*math.Cos(rot)
+		lat := g.Origin.Lat + north/earthRadius*180/math.Pi
+		lon := g.Origin.Lon + east/(earthRadius*math.Cos(g.Origin.Lat*math.Pi/180))*180/math.Pi
+		return LatLon{Lat: lat, Lon: lon}, true
+	}
+	return LatLon{}, false
+}
+
+// unmapPoint returns the layout point mapped to the geographic coordinates
+// ll. It returns false if this GeoReference only places some track items or
+// if its affine transform cannot be inverted.
+func (g *GeoReference) unmapPoint(ll LatLon) (Point, bool) {
+	switch {
+	case g.Affine != nil:
+		a := g.Affine
+		det := a[0]*a[4] - a[1]*a[3]
+		if det == 0 {
+			return Point{}, false
+		}
+		lon, lat := ll.Lon-a[2], ll.Lat-a[5]
+		return Point{X: (a[4]*lon - a[1]*lat) / det, Y: (a[0]*lat - a[3]*lon) / det}, true
+	case g.Origin != nil:
+		rot := g.Rotation * math.Pi / 180
+		north := (ll.Lat - g.Origin.Lat) * math.Pi / 180 * earthRadius
+		east := (ll.Lon - g.Origin.Lon) * math.Pi / 180 * earthRadius * math.Cos(g.Origin.Lat*math.Pi/180)
+		e0 := east*math.Cos(rot) + north*math.Sin(rot)
+		n0 := -east*math.Sin(rot) + north*math.Cos(rot)
+		return Point{X: e0 / g.MetersPerUnit, Y: -n0 / g.MetersPerUnit}, true
+	}
+	return Point{}, false
+}
+
+// interpolateLatLon returns the point at the fraction f of the length of the
+// polyline coords.
+func interpolateLatLon(coords []LatLon, f float64) LatLon {
+	if len(coords) == 1 || f <= 0 {
+		return coords[0]
+	}
+	dist := func(a, b LatLon) float64 {
+		dx := (b.Lon - a.Lon) * math.Cos((a.Lat+b.Lat)/2*math.Pi/180)
+		return math.Hypot(dx, b.Lat-a.Lat)
+	}
+	var total float64
+	for i := 1; i < len(coords); i++ {
+		total += dist(coords[i-1], coords[i])
+	}
+	target := math.Min(f, 1) * total
+	for i := 1; i < len(coords); i++ {
+		d := dist(coords[i-1], coords[i])
+		if d > 0 && target <= d {
+			t := target / d
+			return LatLon{
+				Lat: coords[i-1].Lat + (coords[i].Lat-coords[i-1].Lat)*t,
+				Lon: coords[i-1].Lon + (coords[i].Lon-coords[i-1].Lon)*t,
+			}
+		}
+		target -= d
+	}
+	return coords[len(coords)-1]
+}
+
+// IsGeoReferenced returns true if the simulation has a mapping to geographic
+// coordinates.
+func (sim *Simulation) IsGeoReferenced() bool {
+	return sim.Options.GeoReference != nil
+}
+
+// GeoPoint returns the geographic coordinates of the layout point p, or
+// false if the simulation has no mapping for layout coordinates.
+func (sim *Simulation) GeoPoint(p Point) (LatLon, bool) {
+	if sim.Options.GeoReference == nil {
+		return LatLon{}, false
+	}
+	return sim.Options.GeoReference.mapPoint(p)
+}
+
+// LayoutPointAt returns the layout point at the geographic coordinates ll,
+// or false if the simulation has no mapping for layout coordinates.
+func (sim *Simulation) LayoutPointAt(ll LatLon) (Point, bool) {
+	if sim.Options.GeoReference == nil {
+		return Point{}, false
+	}
+	return sim.Options.GeoReference.unmapPoint(ll)
+}
+
+// GeoPath returns the geographic coordinates of the track item ti from its
+// origin to its end, or false if it cannot be placed. Points are given from
+// their common end to their normal end through their center.
+func (sim *Simulation) GeoPath(ti TrackItem) ([]LatLon, bool) {
+	g := sim.Options.GeoReference
+	if g == nil {
+		return nil, false
+	}
+	if coords, ok := g.Items[ti.ID()]; ok {
+		return coords, true
+	}
+	points := []Point{ti.Origin(), ti.End()}
+	if pi, ok := ti.(*PointsItem); ok {
+		points = []Point{pi.Origin(), pi.Center(), pi.End()}
+	}
+	res := make([]LatLon, len(points))
+	for i, p := range points {
+		ll, ok := g.mapPoint(p)
+		if !ok {
+			return nil, false
+		}
+		res[i] = ll
+	}
+	return res, true
+}
+
+// GeoPosition returns the geographic coordinates of pos, or false if it
+// cannot be placed.
+func (sim *Simulation) GeoPosition(pos Position) (LatLon, bool) {
+	g := sim.Options.GeoReference
+	ti := pos.TrackItem()
+	if g == nil || ti == nil {
+		return LatLon{}, false
+	}
+	coords, ok := g.Items[ti.ID()]
+	if !ok {
+		return g.mapPoint(pos.LayoutPoint())
+	}
+	var f float64
+	if ti.RealLength() > 0 {
+		f = pos.PositionOnTI / ti.RealLength()
+	}
+	if !pos.fromOrigin() {
+		f = 1 - f
+	}
+	return interpolateLatLon(coords, f), true
+}
+
+// LayoutPoint returns the layout coordinates of this position
+func (pos Position) LayoutPoint() Point {
+	ti := pos.TrackItem()
+	if ti == nil {
+		return Point{}
+	}
+	var f float64
+	if ti.RealLength() > 0 {
+		f = math.Max(0, math.Min(1, pos.PositionOnTI/ti.RealLength()))
+	}
+	along := func(a, b Point, t float64) Point {
+		return Point{a.X + (b.X-a.X)*t, a.Y + (b.Y-a.Y)*t}
+	}
+	if pi, ok := ti.(*PointsItem); ok {
+		// Points are drawn as two half lines meeting at their center
+		start, end := pi.Origin(), pi.End()
+		if pi.Reversed() {
+			end = pi.Reverse()
+		}
+		switch pos.PreviousItemID {
+		case pi.NextTiID:
+			start, end = pi.End(), pi.Origin()
+		case pi.ReverseTiId:
+			start, end = pi.Reverse(), pi.Origin()
+		}
+		if f < 0.5 {
+			return along(start, pi.Center(), f*2)
+		}
+		return along(pi.Center(), end, f*2-1)
+	}
+	if !pos.fromOrigin() {
+		return along(ti.End(), ti.Origin(), f)
+	}
+	return along(ti.Origin(), ti.End(), f)
+}
+
+// fromOrigin returns true if this position runs from the origin of its
+// track item towards its end.
+func (pos Position) fromOrigin() bool {
+	prev := pos.TrackItem().PreviousItem()
+	return prev != nil && pos.PreviousItemID == prev.ID()
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestGeoReference(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	loadSim := func(geoRef interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["options"].(map[string]interface{})["geoReference"] = geoRef
+		})
+	}
+	Convey("Testing geographic coordinates", t, func() {
+		Convey("Geo references should be validated", func() {
+			So((*simulation.GeoReference)(nil).Validate(), ShouldBeNil)
+			So((&simulation.GeoReference{}).Validate(), ShouldNotBeNil)
+			So((&simulation.GeoReference{Affine: []float64{1, 2, 3}}).Validate(), ShouldNotBeNil)
+			So((&simulation.GeoReference{Origin: &simulation.LatLon{Lat: 48.8, Lon: 2.3}}).Validate(), ShouldNotBeNil)
+			So((&simulation.GeoReference{Origin: &simulation.LatLon{Lat: 98.8, Lon: 2.3}, MetersPerUnit: 1}).Validate(), ShouldNotBeNil)
+			So((&simulation.GeoReference{Items: map[string][]simulation.LatLon{"2": {}}}).Validate(), ShouldNotBeNil)
+			_, err := loadSim(map[string]interface{}{"affine": []float64{1}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "error in geoReference")
+		})
+		Convey("Simulations without geo reference should have no coordinates", func() {
+			sim, err := loadSim(nil)
+			So(err, ShouldBeNil)
+			So(sim.IsGeoReferenced(), ShouldBeFalse)
+			_, ok := sim.GeoPosition(sim.Trains[0].TrainHead)
+			So(ok, ShouldBeFalse)
+			_, ok = sim.LayoutPointAt(simulation.LatLon{Lat: 45, Lon: 5})
+			So(ok, ShouldBeFalse)
+			data, _ := json.Marshal(sim.Trains[0])
+			So(string(data), ShouldNotContainSubstring, `"geo"`)
+		})
+		Convey("Positions should be placed on the layout", func() {
+			sim, err := loadSim(nil)
+			So(err, ShouldBeNil)
+			pos := sim.Trains[0].TrainHead
+			pos.TrackItemID, pos.PreviousItemID, pos.PositionOnTI = "2", "1", 100
+			So(pos.LayoutPoint(), ShouldResemble, simulation.Point{X: 22.5, Y: 0})
+			pos.PreviousItemID = "3"
+			So(pos.LayoutPoint(), ShouldResemble, simulation.Point{X: 67.5, Y: 0})
+			// Points of the demo have no length
+			pos.TrackItemID, pos.PreviousItemID, pos.PositionOnTI = "7", "6", 0
+			So(pos.LayoutPoint(), ShouldResemble, simulation.Point{X: 245, Y: 0})
+			pos.PreviousItemID = "14"
+			So(pos.LayoutPoint(), ShouldResemble, simulation.Point{X: 255, Y: 5})
+		})
+		Convey("Affine transforms should map layout coordinates", func() {
+			sim, err := loadSim(map[string]interface{}{"affine": []float64{0.0001, 0, 2.3, 0, -0.0001, 48.8}})
+			So(err, ShouldBeNil)
+			So(sim.IsGeoReferenced(), ShouldBeTrue)
+			ll, ok := sim.GeoPoint(simulation.Point{X: 100, Y: 50})
+			So(ok, ShouldBeTrue)
+			So(ll.Lon, ShouldAlmostEqual, 2.31)
+			So(ll.Lat, ShouldAlmostEqual, 48.795)
+			ll, ok = sim.GeoPosition(sim.Trains[0].TrainHead)
+			So(ok, ShouldBeTrue)
+			So(ll.Lon, ShouldAlmostEqual, 2.3+0.0001*90*3/400)
+			p, ok := sim.LayoutPointAt(simulation.LatLon{Lat: 48.795, Lon: 2.31})
+			So(ok, ShouldBeTrue)
+			So(p.X, ShouldAlmostEqual, 100, 1e-6)
+			So(p.Y, ShouldAlmostEqual, 50, 1e-6)
+			var train map[string]interface{}
+			data, _ := json.Marshal(sim.Trains[0])
+			So(json.Unmarshal(data, &train), ShouldBeNil)
+			So(train["geo"], ShouldResemble, map[string]interface{}{"lat": ll.Lat, "lon": ll.Lon})
+		})
+		Convey("Local projections should map layout coordinates in metres", func() {
+			sim, err := loadSim(map[string]interface{}{
+				"origin":        map[string]float64{"lat": 45, "lon": 5},
+				"metersPerUnit": 10,
+				"rotation":      90,
+			})
+			So(err, ShouldBeNil)
+			// 1 km along x is 1 km north when the layout is rotated by 90°
+			ll, _ := sim.GeoPoint(simulation.Point{X: 100, Y: 0})
+			So(ll.Lon, ShouldAlmostEqual, 5, 1e-9)
+			So(ll.Lat, ShouldAlmostEqual, 45+1000/6371008.8*180/math.Pi, 1e-9)
+			path, ok := sim.GeoPath(sim.TrackItems["2"])
+			So(ok, ShouldBeTrue)
+			So(path, ShouldHaveLength, 2)
+			So(path[0], ShouldResemble, simulation.LatLon{Lat: 45, Lon: 5})
+			p, ok := sim.LayoutPointAt(ll)
+			So(ok, ShouldBeTrue)
+			So(p.X, ShouldAlmostEqual, 100, 1e-6)
+			So(p.Y, ShouldAlmostEqual, 0, 1e-6)
+		})
+		Convey("Track item coordinates should be interpolated", func() {
+			sim, err := loadSim(map[string]interface{}{
+				"items": map[string]interface{}{
+					"2": []map[string]float64{{"lat": 45, "lon": 5}, {"lat": 45, "lon": 5.002}, {"lat": 45.002, "lon": 5.002}},
+				},
+			})
+			So(err, ShouldBeNil)
+			pos := sim.Trains[0].TrainHead
+			pos.TrackItemID, pos.PreviousItemID, pos.PositionOnTI = "2", "1", 100
+			ll, ok := sim.GeoPosition(pos)
+			So(ok, ShouldBeTrue)
+			So(ll.Lat, ShouldAlmostEqual, 45)
+			So(ll.Lon, ShouldBeBetween, 5, 5.002)
+			pos.PreviousItemID = "3"
+			ll, _ = sim.GeoPosition(pos)
+			So(ll.Lon, ShouldAlmostEqual, 5.002)
+			So(ll.Lat, ShouldBeBetween, 45, 45.002)
+			// Other items cannot be placed
+			pos.TrackItemID, pos.PreviousItemID = "4", "3"
+			_, ok = sim.GeoPosition(pos)
+			So(ok, ShouldBeFalse)
+			_, ok = sim.GeoPath(sim.TrackItems["4"])
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import "math"
+
+const (
+	// gravity is the standard acceleration of gravity in m/s²
+	gravity float64 = 9.81
+	// minTractionShare is the share of its standard acceleration or braking
+	// that a train always keeps, however steep the slope.
+	minTractionShare float64 = 0.1
+	// joulesPerKWh converts energies from J to kWh
+	joulesPerKWh float64 = 3.6e6
+)
+
+// Gradient returns the gradient in per mille of the track at this position,
+// positive if the track climbs in the direction of the position.
+func (pos Position) Gradient() float64 {
+	ti := pos.TrackItem()
+	if ti == nil {
+		return 0
+	}
+	if pi := ti.PreviousItem(); pi != nil && pi.ID() == pos.PreviousItemID {
+		return ti.Gradient()
+	}
+	return -ti.Gradient()
+}
+
+// curveResistance returns the deceleration in m/s² caused by a curve of the
+// given radius in meters, after Röckl's formula.
+func curveResistance(radius float64) float64 {
+	var r float64
+	switch {
+	case radius <= 0:
+		return 0
+	case radius >= 300:
+		r = 650 / (radius - 55)
+	default:
+		r = 500 / math.Max(radius-30, 10)
+	}
+	// r is given in N/kN
+	return r / 1000 * gravity
+}
+
+// resistance returns the deceleration in m/s² caused by the slope and the
+// curve of the track at this position.
+func (pos Position) resistance() float64 {
+	ti := pos.TrackItem()
+	if ti == nil {
+		return 0
+	}
+	return gravity*pos.Gradient()/1000 + curveResistance(ti.CurveRadius())
+}
+
+// balancingSpeed returns the maximum speed that a train of this type can
+// sustain against the given resistance in m/s².
+//
+// If the train type has no acceleration curve, its power is estimated from its
+// type: it can keep its standard acceleration up to half of its maximum speed.
+func (tt *TrainType) balancingSpeed(resistance float64) float64 {
+	if resistance <= 0 {
+		return tt.MaxSpeed
+	}
+	if len(tt.AccelCurve) == 0 {
+		return math.Min(tt.MaxSpeed, tt.StdAccel*tt.MaxSpeed/2/resistance)
+	}
+	// Highest speed of the curve at which the acceleration still balances
+	// the resistance.
+	for speed := tt.MaxSpeed; speed > 0; speed -= 0.5 {
+		if tt.AccelerationAt(speed) >= resistance {
+			return speed
+		}
+	}
+	return 0
+}
+
+// BalancingSpeed returns the maximum speed this train can sustain on the slope
+// and in the curve under its head.
+func (t *Train) BalancingSpeed() float64 {
+	return t.TrainType().balancingSpeed(t.TrainHead.resistance())
+}
+
+// TractionEnergy returns the estimated energy in kWh per tonne that this
+// train has used for traction since it entered the area.
+func (t *Train) TractionEnergy() float64 {
+	return t.tractionEnergy / joulesPerKWh * 1000
+}
+
+// updateTractionEnergy adds to the traction energy of this train the work
+// done to change its speed from previousSpeed during secs seconds against the
+// resistance of the track.
+func (t *Train) updateTractionEnergy(previousSpeed, secs float64) {
+	if secs <= 0 {
+		return
+	}
+	force := (t.Speed-previousSpeed)/secs + t.TrainHead.resistance()
+	if force <= 0 {
+		// Coasting or braking
+		return
+	}
+	t.tractionEnergy += force * (t.Speed + previousSpeed) / 2 * secs
+}
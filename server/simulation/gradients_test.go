@@ -0,0 +1,109 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestGradients(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation, with the given attributes set on
+	// the track items from the entry to the first station.
+	loadSim := func(gradient, curveRadius float64) *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, func(raw map[string]interface{}) {
+			items := raw["trackItems"].(map[string]interface{})
+			for _, id := range []string{"2", "4", "6"} {
+				ti := items[id].(map[string]interface{})
+				ti["gradient"] = gradient
+				ti["curveRadius"] = curveRadius
+			}
+		})
+		So(err, ShouldBeNil)
+		return sim
+	}
+	// run activates the first train and returns the distance it runs in
+	// 20 steps and its traction energy.
+	run := func(sim *simulation.Simulation) (float64, float64) {
+		train := sim.Trains[0]
+		So(stepUntil(sim, 600, train.IsActive), ShouldBeTrue)
+		start := train.TrainHead
+		for i := 0; i < 20; i++ {
+			sim.Step()
+		}
+		d, err := train.TrainHead.Sub(start)
+		So(err, ShouldBeNil)
+		return d, train.TractionEnergy()
+	}
+	Convey("Testing gradients and curves", t, func() {
+		Convey("Gradients and curve radii should be loaded", func() {
+			sim := loadSim(25, 400)
+			So(sim.TrackItems["2"].Gradient(), ShouldEqual, 25)
+			So(sim.TrackItems["2"].CurveRadius(), ShouldEqual, 400)
+			So(sim.TrackItems["8"].Gradient(), ShouldEqual, 0)
+			So(sim.TrackItems["8"].CurveRadius(), ShouldEqual, 0)
+			data, err := json.Marshal(sim.TrackItems["2"])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"gradient":25`)
+			So(string(data), ShouldContainSubstring, `"curveRadius":400`)
+		})
+		Convey("Gradient should be signed by the direction of the position", func() {
+			sim := loadSim(25, 0)
+			pos := sim.Trains[0].TrainHead
+			So(pos.Gradient(), ShouldEqual, 25)
+			So(pos.Reversed().Gradient(), ShouldEqual, -25)
+		})
+		Convey("Climbing should reduce acceleration and strengthen braking", func() {
+			flat := loadSim(0, 0).Trains[0]
+			up := loadSim(40, 0).Trains[0]
+			down := loadSim(-40, 0).Trains[0]
+			So(flat.Acceleration(), ShouldEqual, flat.TrainType().StdAccel)
+			So(up.Acceleration(), ShouldBeLessThan, flat.Acceleration())
+			So(up.Acceleration(), ShouldBeGreaterThan, 0)
+			So(down.Acceleration(), ShouldBeGreaterThan, flat.Acceleration())
+			So(up.Braking(), ShouldBeGreaterThan, flat.Braking())
+			So(down.Braking(), ShouldBeLessThan, flat.Braking())
+			So(down.Braking(), ShouldBeGreaterThan, 0)
+			So(up.BalancingSpeed(), ShouldBeLessThan, up.TrainType().MaxSpeed)
+			So(flat.BalancingSpeed(), ShouldEqual, flat.TrainType().MaxSpeed)
+		})
+		Convey("Curves should reduce acceleration", func() {
+			flat := loadSim(0, 0).Trains[0]
+			tight := loadSim(0, 150).Trains[0]
+			wide := loadSim(0, 1000).Trains[0]
+			So(tight.Acceleration(), ShouldBeLessThan, wide.Acceleration())
+			So(wide.Acceleration(), ShouldBeLessThan, flat.Acceleration())
+		})
+		Convey("Trains should run slower and use more energy uphill", func() {
+			flatDist, flatEnergy := run(loadSim(0, 0))
+			upDist, upEnergy := run(loadSim(40, 0))
+			So(flatEnergy, ShouldBeGreaterThan, 0)
+			So(upDist, ShouldBeLessThan, flatDist)
+			So(upEnergy, ShouldBeGreaterThan, flatEnergy)
+			data, err := json.Marshal(loadSim(0, 0).Trains[0])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"tractionEnergy":0`)
+		})
+		Convey("Section ETAs should be longer uphill", func() {
+			eta := func(sim *simulation.Simulation) float64 {
+				train := sim.Trains[0]
+				So(stepUntil(sim, 600, train.IsActive), ShouldBeTrue)
+				So(sim.AddSection("APP", &simulation.Section{TrackItemIDs: []string{"8", "9", "10"}}), ShouldBeNil)
+				app, _ := sim.Section("APP")
+				incoming := app.IncomingTrains(1000)
+				So(incoming, ShouldHaveLength, 1)
+				So(incoming[0].Train, ShouldEqual, train)
+				return float64(incoming[0].ETA)
+			}
+			flat := eta(loadSim(0, 0))
+			So(flat, ShouldBeGreaterThan, 0)
+			So(eta(loadSim(40, 0)), ShouldBeGreaterThan, flat)
+		})
+	})
+}
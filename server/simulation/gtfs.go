@@ -0,0 +1,412 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package simulation
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A GTFSFeed holds the tables of a GTFS feed that are needed to build the
+// services of a simulation.
+//
+// Each table is a list of rows, with the values of the row by column name.
+type GTFSFeed struct {
+	Stops         []map[string]string
+	Routes        []map[string]string
+	Trips         []map[string]string
+	StopTimes     []map[string]string
+	Calendar      []map[string]string
+	CalendarDates []map[string]string
+}
+
+// gtfsFiles are the files read from a GTFS feed, with whether they are
+// required.
+var gtfsFiles = []struct {
+	name     string
+	required bool
+	table    func(f *GTFSFeed) *[]map[string]string
+}{
+	{"stops.txt", true, func(f *GTFSFeed) *[]map[string]string { return &f.Stops }},
+	{"routes.txt", true, func(f *GTFSFeed) *[]map[string]string { return &f.Routes }},
+	{"trips.txt", true, func(f *GTFSFeed) *[]map[string]string { return &f.Trips }},
+	{"stop_times.txt", true, func(f *GTFSFeed) *[]map[string]string { return &f.StopTimes }},
+	{"calendar.txt", false, func(f *GTFSFeed) *[]map[string]string { return &f.Calendar }},
+	{"calendar_dates.txt", false, func(f *GTFSFeed) *[]map[string]string { return &f.CalendarDates }},
+}
+
+// ReadGTFS reads the GTFS feed from the zip archive r of the given size.
+func ReadGTFS(r io.ReaderAt, size int64) (*GTFSFeed, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GTFS archive: %s", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		// Some feeds are zipped with their directory
+		name := f.Name[strings.LastIndex(f.Name, "/")+1:]
+		files[name] = f
+	}
+	feed := new(GTFSFeed)
+	for _, gf := range gtfsFiles {
+		f, ok := files[gf.name]
+		if !ok {
+			if gf.required {
+				return nil, fmt.Errorf("missing %s in GTFS feed", gf.name)
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s: %s", gf.name, err)
+		}
+		rows, err := readGTFSTable(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", gf.name, err)
+		}
+		*gf.table(feed) = rows
+	}
+	return feed, nil
+}
+
+// readGTFSTable reads the CSV table of r, whose first row holds the column
+// names.
+func readGTFSTable(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+	}
+	var rows []map[string]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, v := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// GTFSImportOptions tell how to build services from a GTFS feed.
+//
+// StopPlaces maps GTFS stop IDs to place codes. Stops that are not listed
+// are matched through their parent station, then by stop code with the place
+// codes, then by stop name with the place names. Stops that match no place
+// are outside the simulated area and are left out of the services.
+//
+// Only the trips of RouteIDs are imported, or all trips if it is empty. The
+// services get the PlannedTrainType train type.
+type GTFSImportOptions struct {
+	StopPlaces       map[string]string `json:"stopPlaces"`
+	RouteIDs         []string          `json:"routeIds"`
+	PlannedTrainType string            `json:"plannedTrainType"`
+}
+
+// A GTFSSkippedTrip is a trip of a GTFS feed that has not been imported.
+type GTFSSkippedTrip struct {
+	TripID string `json:"tripId"`
+	Reason string `json:"reason"`
+}
+
+// A GTFSImportResult reports the services built from a GTFS feed.
+type GTFSImportResult struct {
+	Services      []string          `json:"services"`
+	SkippedTrips  []GTFSSkippedTrip `json:"skippedTrips"`
+	UnmappedStops []string          `json:"unmappedStops"`
+}
+
+// gtfsStopTime is a row of stop_times.txt
+type gtfsStopTime struct {
+	sequence  int
+	stopID    string
+	arrival   string
+	departure string
+	pickup    string
+	dropOff   string
+}
+
+// gtfsTime returns the simulation time of the GTFS time s, which may have a
+// single digit hour and hours of 24 and more for trips running past midnight.
+func gtfsTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if strings.Index(s, ":") == 1 {
+		s = "0" + s
+	}
+	t := ParseTime(s).Time
+	if t.IsZero() {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+// gtfsDate returns the Date of the GTFS date s in the format 20060102
+func gtfsDate(s string) Date {
+	if len(s) != 8 {
+		return Date(s)
+	}
+	return Date(fmt.Sprintf("%s-%s-%s", s[:4], s[4:6], s[6:]))
+}
+
+// gtfsCalendars returns the calendars of the services of feed by GTFS
+// service ID. Dates added by calendar_dates.txt are not supported and are
+// ignored.
+func gtfsCalendars(feed *GTFSFeed) map[string]*ServiceCalendar {
+	calendars := make(map[string]*ServiceCalendar)
+	days := []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+	for _, row := range feed.Calendar {
+		c := &ServiceCalendar{
+			ValidFrom:  gtfsDate(row["start_date"]),
+			ValidUntil: gtfsDate(row["end_date"]),
+		}
+		for _, d := range days {
+			if row[d] == "1" {
+				c.Days = append(c.Days, strings.ToUpper(d[:3]))
+			}
+		}
+		calendars[row["service_id"]] = c
+	}
+	for _, row := range feed.CalendarDates {
+		c, ok := calendars[row["service_id"]]
+		if !ok || row["exception_type"] != "2" {
+			continue
+		}
+		c.ExceptDates = append(c.ExceptDates, gtfsDate(row["date"]))
+	}
+	return calendars
+}
+
+// gtfsPlaces returns the place codes of the stops of feed that are in the
+// simulated area, by stop ID, and the platform codes of these stops.
+func (sim *Simulation) gtfsPlaces(feed *GTFSFeed, stopPlaces map[string]string) (map[string]string, map[string]string) {
+	byName := make(map[string]string)
+	for code, pl := range sim.Places {
+		byName[strings.ToLower(pl.Name())] = code
+	}
+	stops := make(map[string]map[string]string)
+	for _, row := range feed.Stops {
+		stops[row["stop_id"]] = row
+	}
+	var placeOf func(id string, depth int) string
+	placeOf = func(id string, depth int) string {
+		if code, ok := stopPlaces[id]; ok {
+			return code
+		}
+		row, ok := stops[id]
+		if !ok || depth > 2 {
+			return ""
+		}
+		if parent := row["parent_station"]; parent != "" {
+			if code := placeOf(parent, depth+1); code != "" {
+				return code
+			}
+		}
+		if _, ok := sim.Places[row["stop_code"]]; ok {
+			return row["stop_code"]
+		}
+		return byName[strings.ToLower(row["stop_name"])]
+	}
+	places := make(map[string]string)
+	platforms := make(map[string]string)
+	for id, row := range stops {
+		if code := placeOf(id, 0); code != "" {
+			places[id] = code
+			platforms[id] = row["platform_code"]
+		}
+	}
+	return places, platforms
+}
+
+// ImportGTFS adds to the simulation the services of the trips of feed, with
+// the lines of the stops of each trip that are in the simulated area.
+//
+// The service code is the short name of the trip, or its ID if it has none or
+// if several trips have the same short name. The service gets the calendar of
+// the trip. Trips with less than two stops in the area, or whose service code
+// is already used, are skipped.
+func (sim *Simulation) ImportGTFS(feed *GTFSFeed, opts GTFSImportOptions) (*GTFSImportResult, error) {
+	if opts.PlannedTrainType != "" {
+		if _, ok := sim.TrainTypes[opts.PlannedTrainType]; !ok {
+			return nil, fmt.Errorf("unknown train type: %s", opts.PlannedTrainType)
+		}
+	}
+	for stopID, code := range opts.StopPlaces {
+		if _, ok := sim.Places[code]; !ok {
+			return nil, fmt.Errorf("unknown place %s for stop %s", code, stopID)
+		}
+	}
+	routes := make(map[string]map[string]string)
+	for _, row := range feed.Routes {
+		routes[row["route_id"]] = row
+	}
+	routeIDs := make(map[string]bool)
+	for _, id := range opts.RouteIDs {
+		if _, ok := routes[id]; !ok {
+			return nil, fmt.Errorf("unknown route: %s", id)
+		}
+		routeIDs[id] = true
+	}
+	places, platforms := sim.gtfsPlaces(feed, opts.StopPlaces)
+	calendars := gtfsCalendars(feed)
+
+	stopTimes := make(map[string][]gtfsStopTime)
+	for _, row := range feed.StopTimes {
+		seq, err := strconv.Atoi(row["stop_sequence"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop_sequence %q of trip %s", row["stop_sequence"], row["trip_id"])
+		}
+		stopTimes[row["trip_id"]] = append(stopTimes[row["trip_id"]], gtfsStopTime{
+			sequence:  seq,
+			stopID:    row["stop_id"],
+			arrival:   row["arrival_time"],
+			departure: row["departure_time"],
+			pickup:    row["pickup_type"],
+			dropOff:   row["drop_off_type"],
+		})
+	}
+	shortNames := make(map[string]int)
+	for _, trip := range feed.Trips {
+		if sn := trip["trip_short_name"]; sn != "" {
+			shortNames[sn]++
+		}
+	}
+
+	res := &GTFSImportResult{Services: []string{}, SkippedTrips: []GTFSSkippedTrip{}, UnmappedStops: []string{}}
+	unmapped := make(map[string]bool)
+	skip := func(tripID, format string, args ...interface{}) {
+		res.SkippedTrips = append(res.SkippedTrips, GTFSSkippedTrip{TripID: tripID, Reason: fmt.Sprintf(format, args...)})
+	}
+	for _, trip := range feed.Trips {
+		tripID := trip["trip_id"]
+		route, ok := routes[trip["route_id"]]
+		if !ok {
+			skip(tripID, "unknown route %s", trip["route_id"])
+			continue
+		}
+		if len(routeIDs) > 0 && !routeIDs[trip["route_id"]] {
+			continue
+		}
+		code := trip["trip_short_name"]
+		if code == "" || shortNames[code] > 1 {
+			code = tripID
+		}
+		if _, exists := sim.Services[code]; exists {
+			skip(tripID, "service %s already exists", code)
+			continue
+		}
+		sts := stopTimes[tripID]
+		sort.Slice(sts, func(i, j int) bool { return sts[i].sequence < sts[j].sequence })
+		var lines []*ServiceLine
+		var err error
+		for _, st := range sts {
+			placeCode, ok := places[st.stopID]
+			if !ok {
+				unmapped[st.stopID] = true
+				continue
+			}
+			var arrival, departure time.Time
+			if arrival, err = gtfsTime(st.arrival); err != nil {
+				break
+			}
+			if departure, err = gtfsTime(st.departure); err != nil {
+				break
+			}
+			sl := &ServiceLine{
+				MustStop:  st.pickup != "1" || st.dropOff != "1",
+				PlaceCode: placeCode,
+				TrackCode: platforms[st.stopID],
+			}
+			sl.ScheduledArrivalTime.Time = arrival
+			sl.ScheduledDepartureTime.Time = departure
+			lines = append(lines, sl)
+		}
+		if err != nil {
+			skip(tripID, "%s", err)
+			continue
+		}
+		if len(lines) < 2 {
+			skip(tripID, "less than two stops in the simulated area")
+			continue
+		}
+		// The train enters the area at its first line and leaves it at its
+		// last one.
+		lines[0].ScheduledArrivalTime.Time = time.Time{}
+		lines[len(lines)-1].ScheduledDepartureTime.Time = time.Time{}
+		var calendar *ServiceCalendar
+		if c, ok := calendars[trip["service_id"]]; ok {
+			if len(c.Days) == 0 {
+				skip(tripID, "service %s runs on no day of the week", trip["service_id"])
+				continue
+			}
+			calendar = c
+		}
+		s := &Service{
+			Description:          gtfsDescription(route, trip),
+			Lines:                lines,
+			PlannedTrainTypeCode: opts.PlannedTrainType,
+			Calendar:             calendar,
+		}
+		sim.addService(code, s)
+		res.Services = append(res.Services, code)
+	}
+	for stopID := range unmapped {
+		res.UnmappedStops = append(res.UnmappedStops, stopID)
+	}
+	sort.Strings(res.Services)
+	sort.Strings(res.UnmappedStops)
+	if len(res.Services) > 0 {
+		sim.MessageLogger.addMessage(fmt.Sprintf("%d services imported from GTFS feed", len(res.Services)), simulationMsg)
+	}
+	return res, nil
+}
+
+// gtfsDescription returns the description of the service of trip of route
+func gtfsDescription(route, trip map[string]string) string {
+	name := route["route_short_name"]
+	if name == "" {
+		name = route["route_long_name"]
+	}
+	if hs := trip["trip_headsign"]; hs != "" {
+		return strings.TrimSpace(fmt.Sprintf("%s %s", name, hs))
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// gtfsZip returns a zip archive of the given GTFS files
+func gtfsZip(files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, _ := zw.Create(name)
+		_, _ = f.Write([]byte(content))
+	}
+	_ = zw.Close()
+	return bytes.NewReader(buf.Bytes())
+}
+
+var gtfsTestFeed = map[string]string{
+	"stops.txt": "\ufeffstop_id,stop_code,stop_name,platform_code,parent_station\n" +
+		"A,LFT,Left,,\n" +
+		"ST,,Station,,\n" +
+		"B1,,Station platform 1,1,ST\n" +
+		"C,,Faraway,,\n",
+	"routes.txt": "route_id,route_short_name,route_long_name,route_type\n" +
+		"R1,G,Green line,2\n" +
+		"R2,B,Blue line,2\n",
+	"trips.txt": "route_id,service_id,trip_id,trip_short_name,trip_headsign\n" +
+		"R1,WK,T1,G100,Faraway\n" +
+		"R1,WK,T2,,Faraway\n" +
+		"R2,WK,T3,B200,Left\n",
+	"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type\n" +
+		"T1,23:58:00,23:58:00,A,1,,\n" +
+		"T1,24:02:00,24:03:00,B1,2,,\n" +
+		"T1,0:20:00,0:20:00,C,3,,\n" +
+		"T2,9:00:00,9:00:00,C,1,,\n" +
+		"T2,9:10:00,9:11:00,B1,2,1,1\n" +
+		"T3,10:00:00,10:00:00,B1,1,,\n" +
+		"T3,10:05:00,10:05:00,A,2,,\n",
+	"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+		"WK,1,1,1,1,1,0,0,20260101,20261231\n",
+	"calendar_dates.txt": "service_id,date,exception_type\n" +
+		"WK,20261225,2\n",
+}
+
+func TestGTFSImport(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing the import of GTFS feeds", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		r := gtfsZip(gtfsTestFeed)
+		feed, err := simulation.ReadGTFS(r, r.Size())
+		So(err, ShouldBeNil)
+		So(feed.Trips, ShouldHaveLength, 3)
+		Convey("Services should be built from the trips in the area", func() {
+			res, err := sim.ImportGTFS(feed, simulation.GTFSImportOptions{
+				RouteIDs:         []string{"R1"},
+				PlannedTrainType: "UT",
+			})
+			So(err, ShouldBeNil)
+			So(res.Services, ShouldResemble, []string{"G100"})
+			So(res.SkippedTrips, ShouldHaveLength, 1)
+			So(res.SkippedTrips[0].TripID, ShouldEqual, "T2")
+			So(res.UnmappedStops, ShouldResemble, []string{"C"})
+			s := sim.Services["G100"]
+			So(s, ShouldNotBeNil)
+			So(s.Description, ShouldEqual, "G Faraway")
+			So(s.PlannedTrainTypeCode, ShouldEqual, "UT")
+			So(s.Lines, ShouldHaveLength, 2)
+			So(s.Lines[0].PlaceCode, ShouldEqual, "LFT")
+			So(s.Lines[0].ScheduledArrivalTime.IsZero(), ShouldBeTrue)
+			So(simulation.FormatTime(s.Lines[0].ScheduledDepartureTime.Time), ShouldEqual, "23:58:00")
+			So(s.Lines[1].PlaceCode, ShouldEqual, "STN")
+			So(s.Lines[1].TrackCode, ShouldEqual, "1")
+			So(s.Lines[1].MustStop, ShouldBeTrue)
+			So(simulation.FormatTime(s.Lines[1].ScheduledArrivalTime.Time), ShouldEqual, "24:02:00")
+			So(s.Lines[1].ScheduledDepartureTime.IsZero(), ShouldBeTrue)
+			So(s.Calendar.Days, ShouldResemble, []string{"MON", "TUE", "WED", "THU", "FRI"})
+			So(s.Calendar.ValidUntil, ShouldEqual, simulation.Date("2026-12-31"))
+			So(s.Calendar.RunsOn(time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC)), ShouldBeFalse)
+			So(s.Calendar.Validate(), ShouldBeNil)
+			res, err = sim.ImportGTFS(feed, simulation.GTFSImportOptions{RouteIDs: []string{"R1"}})
+			So(err, ShouldBeNil)
+			So(res.Services, ShouldBeEmpty)
+			So(res.SkippedTrips, ShouldHaveLength, 2)
+		})
+		Convey("Stops can be mapped explicitly to places", func() {
+			res, err := sim.ImportGTFS(feed, simulation.GTFSImportOptions{
+				RouteIDs:   []string{"R2"},
+				StopPlaces: map[string]string{"A": "RGT"},
+			})
+			So(err, ShouldBeNil)
+			So(res.Services, ShouldResemble, []string{"B200"})
+			So(sim.Services["B200"].Lines[1].PlaceCode, ShouldEqual, "RGT")
+		})
+		Convey("Invalid options and feeds should be refused", func() {
+			_, err := sim.ImportGTFS(feed, simulation.GTFSImportOptions{PlannedTrainType: "XX"})
+			So(err, ShouldNotBeNil)
+			_, err = sim.ImportGTFS(feed, simulation.GTFSImportOptions{RouteIDs: []string{"R9"}})
+			So(err, ShouldNotBeNil)
+			_, err = sim.ImportGTFS(feed, simulation.GTFSImportOptions{StopPlaces: map[string]string{"A": "XXX"}})
+			So(err, ShouldNotBeNil)
+			r := gtfsZip(map[string]string{"stops.txt": gtfsTestFeed["stops.txt"]})
+			_, err = simulation.ReadGTFS(r, r.Size())
+			So(err, ShouldNotBeNil)
+			_, err = simulation.ReadGTFS(bytes.NewReader([]byte("not a zip")), 9)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestLevelCrossings(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with item 6 turned into a level
+	// crossing protected by signal 5.
+	loadSim := func() *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, func(raw map[string]interface{}) {
+			// Signal states that need the next route also need the level
+			// crossings of their routes to be closed
+			types := raw["signalLibrary"].(map[string]interface{})["signalTypes"].(map[string]interface{})
+			for _, st := range types {
+				for _, state := range st.(map[string]interface{})["states"].([]interface{}) {
+					conditions := state.(map[string]interface{})["conditions"].(map[string]interface{})
+					if _, ok := conditions["NEXT_ROUTE_ACTIVE"]; ok {
+						conditions["LEVEL_CROSSING_CLOSED"] = []interface{}{}
+					}
+				}
+			}
+			items := raw["trackItems"].(map[string]interface{})
+			lc := items["6"].(map[string]interface{})
+			lc["__type__"] = "LevelCrossingItem"
+			lc["closingSeconds"] = 20
+			lc["openingSeconds"] = 10
+			props := items["5"].(map[string]interface{})["customProperties"].(map[string]interface{})
+			props["LEVEL_CROSSING_CLOSED"] = map[string]interface{}{"UK_CLEAR": []string{"6"}, "UK_CAUTION": []string{"6"}}
+		})
+		So(err, ShouldBeNil)
+		return sim
+	}
+	Convey("Testing level crossings", t, func() {
+		sim := loadSim()
+		lc := sim.TrackItems["6"].(*simulation.LevelCrossingItem)
+		sig5 := sim.TrackItems["5"].(*simulation.SignalItem)
+		So(sim.LevelCrossings(), ShouldHaveLength, 1)
+		So(lc.State(), ShouldEqual, simulation.LevelCrossingOpen)
+		Convey("Approaching trains should close the crossing", func() {
+			train := sim.Trains[0]
+			So(stepUntil(sim, 100, func() bool { return lc.State() == simulation.LevelCrossingClosing }), ShouldBeTrue)
+			So(train.IsActive(), ShouldBeTrue)
+			So(sig5.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			So(lc.Open(), ShouldNotBeNil)
+			So(stepUntil(sim, 100, func() bool { return lc.IsClosed() }), ShouldBeTrue)
+			So(sig5.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			data, err := json.Marshal(lc)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"state":"CLOSED"`)
+			So(stepUntil(sim, 1000, func() bool { return lc.Metrics().TrainsPassed == 1 }), ShouldBeTrue)
+			So(lc.Metrics().UnprotectedPassages, ShouldEqual, 0)
+			So(lc.Metrics().MinWarningSeconds, ShouldBeGreaterThan, 0)
+			So(stepUntil(sim, 1000, func() bool { return lc.State() == simulation.LevelCrossingOpen }), ShouldBeTrue)
+			m := lc.Metrics()
+			So(m.Closures, ShouldEqual, 1)
+			So(m.ClosedSeconds, ShouldBeGreaterThan, 0)
+			So(m.LongestClosureSeconds, ShouldEqual, m.ClosedSeconds)
+			So(m.ShortestClosureSeconds, ShouldEqual, m.ClosedSeconds)
+		})
+		Convey("Crossings should be operated manually", func() {
+			for _, tr := range sim.Trains {
+				So(tr.Cancel(), ShouldBeNil)
+			}
+			So(lc.Close(), ShouldBeNil)
+			So(lc.IsManual(), ShouldBeTrue)
+			So(lc.State(), ShouldEqual, simulation.LevelCrossingClosing)
+			So(stepUntil(sim, 100, func() bool { return lc.IsClosed() }), ShouldBeTrue)
+			So(lc.Open(), ShouldBeNil)
+			So(lc.State(), ShouldEqual, simulation.LevelCrossingOpening)
+			So(stepUntil(sim, 100, func() bool { return lc.State() == simulation.LevelCrossingOpen }), ShouldBeTrue)
+			lc.SetManual(false)
+			So(lc.IsManual(), ShouldBeFalse)
+		})
+		Convey("Failed barriers should not move", func() {
+			for _, tr := range sim.Trains {
+				So(tr.Cancel(), ShouldBeNil)
+			}
+			_, err := simulation.ParseLevelCrossingFailureMode("BROKEN")
+			So(err, ShouldNotBeNil)
+			lc.Fail(simulation.BarriersStuckOpen, "Vandalism", 0)
+			So(lc.Failed(), ShouldBeTrue)
+			So(lc.Close(), ShouldNotBeNil)
+			lc.Repair()
+			So(lc.Failed(), ShouldBeFalse)
+			So(lc.Close(), ShouldBeNil)
+			lc.Fail(simulation.BarriersStuckClosed, "Power failure", time.Minute)
+			So(lc.IsClosed(), ShouldBeTrue)
+			So(lc.Open(), ShouldNotBeNil)
+			So(stepUntil(sim, 1000, func() bool { return !lc.Failed() }), ShouldBeTrue)
+			So(lc.Open(), ShouldBeNil)
+		})
+	})
+}
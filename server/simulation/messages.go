@@ -18,6 +18,12 @@
 
 package simulation
 
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
 // MessageType defines the type of message of the Logger
 type MessageType uint8
 
@@ -27,6 +33,28 @@ const (
 	simulationMsg    MessageType = 2
 )
 
+// messageLevels are the names of the message types
+var messageLevels = map[MessageType]string{
+	softwareMsg:      "software",
+	playerWarningMsg: "playerWarning",
+	simulationMsg:    "simulation",
+}
+
+// Level returns the name of this message type
+func (mt MessageType) Level() string {
+	return messageLevels[mt]
+}
+
+// ParseMessageLevel returns the message type with the given name
+func ParseMessageLevel(name string) (MessageType, error) {
+	for mt, level := range messageLevels {
+		if level == name {
+			return mt, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown message level: %s", name)
+}
+
 // Message is one message emitted to the MessageLogger of the simulation.
 type Message struct {
 	MsgType MessageType `json:"msgType"`
@@ -43,6 +71,57 @@ func (m Message) ID() string {
 type MessageLogger struct {
 	Messages   []Message `json:"messages"`
 	simulation *Simulation
+	mutex      sync.RWMutex
+}
+
+// MarshalJSON for the MessageLogger type
+func (ml *MessageLogger) MarshalJSON() ([]byte, error) {
+	ml.mutex.RLock()
+	defer ml.mutex.RUnlock()
+	return json.Marshal(struct {
+		Messages []Message `json:"messages"`
+	}{
+		Messages: ml.Messages,
+	})
+}
+
+// A LoggedMessage is a Message with its index in the MessageLogger
+type LoggedMessage struct {
+	Message
+	Index int    `json:"index"`
+	Level string `json:"level"`
+}
+
+// Query returns at most limit messages of the given types, skipping the
+// first offset ones, and the number of messages of these types. Messages of
+// all types are returned if types is empty, and all the messages after
+// offset if limit is 0.
+func (ml *MessageLogger) Query(types []MessageType, offset, limit int) ([]LoggedMessage, int) {
+	ml.mutex.RLock()
+	defer ml.mutex.RUnlock()
+	res := []LoggedMessage{}
+	total := 0
+	for i, m := range ml.Messages {
+		if len(types) > 0 && !hasMessageType(types, m.MsgType) {
+			continue
+		}
+		total++
+		if total <= offset || (limit > 0 && len(res) >= limit) {
+			continue
+		}
+		res = append(res, LoggedMessage{Message: m, Index: i, Level: m.MsgType.Level()})
+	}
+	return res, total
+}
+
+// hasMessageType returns true if mt is in types
+func hasMessageType(types []MessageType, mt MessageType) bool {
+	for _, t := range types {
+		if t == mt {
+			return true
+		}
+	}
+	return false
 }
 
 // setSimulation() sets the Simulation this MessageLogger is part of.
@@ -57,8 +136,10 @@ func (ml *MessageLogger) addMessage(msg string, typ MessageType) {
 		MsgText: msg,
 		MsgType: typ,
 	}
+	ml.mutex.Lock()
 	ml.Messages = append(ml.Messages, newMsg)
-	if Logger != nil {
+	ml.mutex.Unlock()
+	if Logger != nil && !ml.simulation.quiet {
 		Logger.Info(msg, "msgType", typ)
 	}
 	ml.simulation.sendEvent(&Event{
@@ -0,0 +1,58 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestMessageLog(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing the message log", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		software, err := simulation.ParseMessageLevel("software")
+		So(err, ShouldBeNil)
+		warning, err := simulation.ParseMessageLevel("playerWarning")
+		So(err, ShouldBeNil)
+		So(warning.Level(), ShouldEqual, "playerWarning")
+		_, err = simulation.ParseMessageLevel("debug")
+		So(err, ShouldNotBeNil)
+
+		Convey("All the messages should be returned without filter", func() {
+			items, total := sim.MessageLogger.Query(nil, 0, 0)
+			So(total, ShouldEqual, 2)
+			So(items, ShouldHaveLength, 2)
+			So(items[0].MsgText, ShouldEqual, "Test message")
+			So(items[0].Level, ShouldEqual, "playerWarning")
+			So(items[1].Index, ShouldEqual, 1)
+			So(items[1].Level, ShouldEqual, "software")
+		})
+		Convey("Messages should be filtered by level and paginated", func() {
+			items, total := sim.MessageLogger.Query([]simulation.MessageType{software}, 0, 10)
+			So(total, ShouldEqual, 1)
+			So(items, ShouldHaveLength, 1)
+			So(items[0].MsgText, ShouldEqual, "Simulation initializing")
+			So(items[0].Index, ShouldEqual, 1)
+			items, total = sim.MessageLogger.Query(nil, 1, 10)
+			So(total, ShouldEqual, 2)
+			So(items, ShouldHaveLength, 1)
+			So(items[0].Index, ShouldEqual, 1)
+			items, _ = sim.MessageLogger.Query(nil, 0, 1)
+			So(items, ShouldHaveLength, 1)
+			So(items[0].Index, ShouldEqual, 0)
+			items, _ = sim.MessageLogger.Query([]simulation.MessageType{warning, software}, 5, 10)
+			So(items, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import "fmt"
+
+// SignallingMode is the way trains are kept apart in the simulation
+type SignallingMode string
+
+const (
+	// SignallingFixedBlock separates trains by fixed blocks: a signal does
+	// not clear while a train is detected in the section it protects.
+	SignallingFixedBlock SignallingMode = "FIXED_BLOCK"
+	// SignallingMovingBlock separates trains by braking-distance supervision,
+	// as with ETCS level 3 or CBTC: signals no longer check that the track
+	// ahead is clear of trains, and each train is allowed to run up to the
+	// tail of the train ahead, less its braking distance and a safety margin.
+	SignallingMovingBlock SignallingMode = "MOVING_BLOCK"
+)
+
+// defaultMovingBlockMargin is the safety margin in metres kept behind the
+// train ahead in moving block when the option is not set.
+const defaultMovingBlockMargin float64 = 50
+
+// SignallingModes returns all the signalling modes
+func SignallingModes() []SignallingMode {
+	return []SignallingMode{SignallingFixedBlock, SignallingMovingBlock}
+}
+
+// Validate returns an error if m is not a known signalling mode. An empty
+// mode is the same as SignallingFixedBlock.
+func (m SignallingMode) Validate() error {
+	switch m {
+	case "", SignallingFixedBlock, SignallingMovingBlock:
+		return nil
+	}
+	return fmt.Errorf("unknown signalling mode: %s", m)
+}
+
+// IsMovingBlock returns true if the simulation is signalled in moving block
+func (sim *Simulation) IsMovingBlock() bool {
+	return sim.Options.SignallingMode == SignallingMovingBlock
+}
+
+// blockOccupied returns true if the signals must consider ti occupied by a
+// train. In moving block, trains report their own position and are kept
+// apart by their braking curves, so that the train detection of track items
+// does not hold signals at danger.
+func (sim *Simulation) blockOccupied(ti TrackItem) bool {
+	if sim.IsMovingBlock() {
+		return false
+	}
+	return ti.Occupied()
+}
+
+// MovingBlockMargin returns the safety margin in metres that this train must
+// keep behind the tail of the train ahead. The second value is false if the
+// simulation is signalled in fixed block, in which case the train is kept
+// apart by signals.
+func (t *Train) MovingBlockMargin() (float64, bool) {
+	if !t.simulation.IsMovingBlock() {
+		return 0, false
+	}
+	if t.simulation.Options.MovingBlockMargin <= 0 {
+		return defaultMovingBlockMargin, true
+	}
+	return t.simulation.Options.MovingBlockMargin, true
+}
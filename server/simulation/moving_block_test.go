@@ -0,0 +1,57 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestMovingBlock(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing moving block signalling", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		Convey("Signalling modes should be validated", func() {
+			So(simulation.SignallingMode("").Validate(), ShouldBeNil)
+			So(simulation.SignallingMovingBlock.Validate(), ShouldBeNil)
+			So(simulation.SignallingMode("ETCS").Validate(), ShouldNotBeNil)
+			So(sim.Options.Set("signallingMode", "ETCS"), ShouldNotBeNil)
+			So(sim.IsMovingBlock(), ShouldBeFalse)
+			So(sim.Options.Set("signallingMode", "MOVING_BLOCK"), ShouldBeNil)
+			So(sim.IsMovingBlock(), ShouldBeTrue)
+		})
+		Convey("Trains should only keep a margin behind the train ahead in moving block", func() {
+			train := sim.Trains[0]
+			_, ok := train.MovingBlockMargin()
+			So(ok, ShouldBeFalse)
+			So(sim.Options.Set("signallingMode", "MOVING_BLOCK"), ShouldBeNil)
+			margin, ok := train.MovingBlockMargin()
+			So(ok, ShouldBeTrue)
+			So(margin, ShouldEqual, 50)
+			So(sim.Options.Set("movingBlockMargin", 20.0), ShouldBeNil)
+			margin, _ = train.MovingBlockMargin()
+			So(margin, ShouldEqual, 20)
+		})
+		Convey("Signals should not be held at danger by occupied items in moving block", func() {
+			sig := sim.TrackItems["5"].(*simulation.SignalItem)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			d := &simulation.Disruption{Type: simulation.DisruptionTrackCircuitFailed, TrackItemID: "8"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			So(sim.Options.Set("signallingMode", "MOVING_BLOCK"), ShouldBeNil)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeTrue)
+			So(sim.Options.Set("signallingMode", "FIXED_BLOCK"), ShouldBeNil)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeFalse)
+		})
+	})
+}
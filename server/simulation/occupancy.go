@@ -0,0 +1,205 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"sort"
+	"sync"
+)
+
+// occupancyIndex maps the IDs of track items to the trains present on them.
+//
+// It is updated each time a train end is set or removed on a track item, so
+// that queries on the trains present on an item and the utilization of the
+// network do not need to scan all the trains or all the track items.
+type occupancyIndex struct {
+	sync.RWMutex
+	trains map[string]map[*Train]struct{}
+	// segments are the IDs of the track items counted in the utilization
+	segments map[string]bool
+	// occupiedSegments is the number of these items with a train present
+	occupiedSegments int
+}
+
+// isUtilizationType returns true if items of the given type are counted in
+// the utilization of the network
+func isUtilizationType(t TrackItemType) bool {
+	switch t {
+	case TypeLine, TypeInvisibleLink, TypeLevelCrossing, TypeSignal, TypePoints:
+		return true
+	}
+	return false
+}
+
+// newOccupancyIndex returns an empty occupancy index for the track items of
+// sim.
+func newOccupancyIndex(sim *Simulation) *occupancyIndex {
+	oi := &occupancyIndex{
+		trains:   make(map[string]map[*Train]struct{}),
+		segments: make(map[string]bool),
+	}
+	for id, ti := range sim.TrackItems {
+		if isUtilizationType(ti.Type()) {
+			oi.segments[id] = true
+		}
+	}
+	return oi
+}
+
+// set records whether train tr is present on the track item with the given
+// ID.
+func (oi *occupancyIndex) set(id string, tr *Train, present bool) {
+	oi.Lock()
+	defer oi.Unlock()
+	trains, wasOccupied := oi.trains[id]
+	if present {
+		if !wasOccupied {
+			trains = make(map[*Train]struct{})
+			oi.trains[id] = trains
+			if oi.segments[id] {
+				oi.occupiedSegments++
+			}
+		}
+		trains[tr] = struct{}{}
+		return
+	}
+	if !wasOccupied {
+		return
+	}
+	delete(trains, tr)
+	if len(trains) == 0 {
+		delete(oi.trains, id)
+		if oi.segments[id] {
+			oi.occupiedSegments--
+		}
+	}
+}
+
+// syncOccupancy updates the occupancy index of the simulation for train tr
+// from the train ends known by this item. trainEndMutex must be held.
+func (t *trackStruct) syncOccupancy(tr *Train) {
+	if t.simulation == nil || t.simulation.occupancy == nil {
+		return
+	}
+	_, fw := t.trainEndsFW[tr]
+	_, bk := t.trainEndsBK[tr]
+	t.simulation.occupancy.set(t.ID(), tr, fw || bk)
+}
+
+// indexTrains adds all the trains present on this item to the occupancy
+// index of the simulation. trainEndMutex must be held.
+func (t *trackStruct) indexTrains() {
+	for tr := range t.trainEndsFW {
+		t.syncOccupancy(tr)
+	}
+	for tr := range t.trainEndsBK {
+		t.syncOccupancy(tr)
+	}
+}
+
+// TrainsOn returns the trains present on the track item with the given ID,
+// sorted by train ID.
+func (sim *Simulation) TrainsOn(itemID string) []*Train {
+	if sim.occupancy == nil {
+		return nil
+	}
+	sim.occupancy.RLock()
+	defer sim.occupancy.RUnlock()
+	res := make([]*Train, 0, len(sim.occupancy.trains[itemID]))
+	for tr := range sim.occupancy.trains[itemID] {
+		res = append(res, tr)
+	}
+	sortTrainsByID(res)
+	return res
+}
+
+// trainsOnItems returns the trains present on at least one of the track items
+// with the given IDs, sorted by train ID.
+func (sim *Simulation) trainsOnItems(ids []string) []*Train {
+	seen := make(map[*Train]bool)
+	var res []*Train
+	for _, id := range ids {
+		for _, tr := range sim.TrainsOn(id) {
+			if !seen[tr] {
+				seen[tr] = true
+				res = append(res, tr)
+			}
+		}
+	}
+	sortTrainsByID(res)
+	return res
+}
+
+// trainsWithin returns the trains present on the track items that are at most
+// maxDistance ahead of pos, the item of pos included, sorted by train ID.
+func (sim *Simulation) trainsWithin(pos Position, maxDistance float64) []*Train {
+	var (
+		ids      []string
+		distance float64
+	)
+	for distance <= maxDistance {
+		ids = append(ids, pos.TrackItemID)
+		if pos.TrackItem().Type() == TypeEnd {
+			break
+		}
+		distance += pos.TrackItem().RealLength() - pos.PositionOnTI
+		pos = pos.Next(DirectionCurrent)
+	}
+	return sim.trainsOnItems(ids)
+}
+
+// sortTrainsByID sorts trains by ID, i.e. in the order of sim.Trains
+func sortTrainsByID(trains []*Train) {
+	sort.Slice(trains, func(i, j int) bool {
+		return mustAtoi(trains[i].ID()) < mustAtoi(trains[j].ID())
+	})
+}
+
+// OccupiedItemsCount returns the number of track items on which at least one
+// train is present.
+func (sim *Simulation) OccupiedItemsCount() int {
+	if sim.occupancy == nil {
+		return 0
+	}
+	sim.occupancy.RLock()
+	defer sim.occupancy.RUnlock()
+	return len(sim.occupancy.trains)
+}
+
+// OccupiedSegments returns the number of lines, links, level crossings,
+// signals and points of the simulation on which a train is present, and the
+// total number of these items.
+func (sim *Simulation) OccupiedSegments() (occupied, total int) {
+	if sim.occupancy == nil {
+		return 0, 0
+	}
+	sim.occupancy.RLock()
+	defer sim.occupancy.RUnlock()
+	return sim.occupancy.occupiedSegments, len(sim.occupancy.segments)
+}
+
+// Utilization returns the percentage of the lines, links, level crossings,
+// signals and points of the simulation on which a train is present.
+func (sim *Simulation) Utilization() float64 {
+	occupied, total := sim.OccupiedSegments()
+	if total == 0 {
+		return 0
+	}
+	return float64(occupied) * 100 / float64(total)
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// checkOccupancy compares the occupancy index of sim with the train ends
+// known by each track item
+func checkOccupancy(sim *simulation.Simulation) {
+	occupied, total := 0, 0
+	for id, ti := range sim.TrackItems {
+		So(len(sim.TrainsOn(id)) > 0, ShouldEqual, ti.TrainPresent())
+		switch ti.Type() {
+		case simulation.TypeLine, simulation.TypeInvisibleLink, simulation.TypeLevelCrossing, simulation.TypeSignal, simulation.TypePoints:
+			total++
+			if ti.TrainPresent() {
+				occupied++
+			}
+		}
+	}
+	So(sim.Utilization(), ShouldAlmostEqual, float64(occupied)*100/float64(total))
+	o, t := sim.OccupiedSegments()
+	So(o, ShouldEqual, occupied)
+	So(t, ShouldEqual, total)
+}
+
+func TestOccupancyIndex(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing the occupancy index", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		So(sim.OccupiedItemsCount(), ShouldEqual, 0)
+		So(sim.Utilization(), ShouldEqual, 0)
+		sim.Trains[0].AppearTime = simulation.ParseTime("05:00:00")
+		sim.Trains[1].AppearTime = simulation.ParseTime("05:00:00")
+
+		Convey("The index should follow the trains as they advance", func() {
+			for i := 0; i < 60; i++ {
+				sim.Step()
+				checkOccupancy(&sim)
+			}
+			train := sim.Trains[0]
+			So(sim.TrainsOn(train.TrainHead.TrackItemID), ShouldContain, train)
+			So(sim.OccupiedItemsCount(), ShouldBeGreaterThan, 0)
+			So(sim.Utilization(), ShouldBeGreaterThan, 0)
+		})
+		Convey("The index should be rebuilt in clones and restored checkpoints", func() {
+			for i := 0; i < 20; i++ {
+				sim.Step()
+			}
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			drainEvents(clone, endChan)
+			checkOccupancy(clone)
+			So(clone.OccupiedItemsCount(), ShouldEqual, sim.OccupiedItemsCount())
+			So(clone.TrainsOn(sim.Trains[0].TrainHead.TrackItemID), ShouldContain, clone.Trains[0])
+
+			cp, err := sim.Checkpoint()
+			So(err, ShouldBeNil)
+			restored, err := simulation.RestoreCheckpoint(cp)
+			So(err, ShouldBeNil)
+			drainEvents(restored, endChan)
+			checkOccupancy(restored)
+			So(restored.OccupiedItemsCount(), ShouldEqual, sim.OccupiedItemsCount())
+			So(restored.Utilization(), ShouldEqual, sim.Utilization())
+		})
+	})
+}
@@ -19,6 +19,7 @@
 package simulation
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 )
@@ -52,11 +53,90 @@ type Options struct {
 	SuggestSafetyBufferSeconds     int     `json:"suggestSafetyBufferSeconds"`
 	SuggestMaxItems                int     `json:"suggestMaxItems"`
 
+	// Signal malfunctions: failures per signal per hour and mean time to
+	// repair. A zero rate disables random failures.
+	SignalFailureRate float64 `json:"signalFailureRate"`
+	SignalMTTRMinutes int     `json:"signalMTTRMinutes"`
+
+	// Points malfunctions, as for signals
+	PointsFailureRate float64 `json:"pointsFailureRate"`
+	PointsMTTRMinutes int     `json:"pointsMTTRMinutes"`
+
+	// Weather degrades the adhesion and dwell times of trains
+	Weather Weather `json:"weather"`
+
+	// Number of passengers per second boarding or alighting a train. 0 means
+	// 2 passengers per second.
+	PassengerFlowRate float64 `json:"passengerFlowRate"`
+
+	// Assign automatically the planned next service to trains ending their
+	// service at a terminus, and keep them at least MinTurnaroundSeconds
+	// there before they depart with their new service.
+	AutoLinkServices     bool `json:"autoLinkServices"`
+	MinTurnaroundSeconds int  `json:"minTurnaroundSeconds"`
+
+	// Reverse automatically the trains which are given a return working by
+	// the post actions of their service at a terminus, even without a
+	// REVERSE post action. Trains reversing at a terminus stay
+	// DriverChangeSeconds more there for the driver to change ends.
+	AutoReverseAtTerminus bool `json:"autoReverseAtTerminus"`
+	DriverChangeSeconds   int  `json:"driverChangeSeconds"`
+
+	// Length in metres of the overlap locked beyond the exit signal of routes
+	// which do not define their own. 0 means no overlap. Overlaps are released
+	// OverlapReleaseSeconds after the train has stopped at the exit signal (0
+	// means two minutes), or as soon as the train has cleared the route.
+	OverlapLength         float64 `json:"overlapLength"`
+	OverlapReleaseSeconds int     `json:"overlapReleaseSeconds"`
+
+	// SignallingMode is how trains are kept apart. In moving block, trains
+	// keep at least MovingBlockMargin metres (0 means 50) behind the tail of
+	// the train ahead in addition to their braking distance.
+	SignallingMode    SignallingMode `json:"signallingMode"`
+	MovingBlockMargin float64        `json:"movingBlockMargin"`
+
+	// StartDate is the calendar date of the first day of the simulation. It
+	// is needed for the calendars of services to apply. With RealDateTime,
+	// times are given to clients as RFC3339 date times from this date.
+	StartDate    Date `json:"startDate"`
+	RealDateTime bool `json:"realDateTime"`
+
+	// Stochastic perturbation generator
+	Perturbations Perturbations `json:"perturbations"`
+
+	// Seed of the random generator of the simulation. Runs with the same
+	// seed and the same inputs give the same results. A zero seed is replaced
+	// by a random one when the simulation starts.
+	Seed int64 `json:"seed"`
+
+	// Simulation time during which states are kept to rewind the simulation.
+	// 0 means one hour.
+	RewindHistoryMinutes int `json:"rewindHistoryMinutes"`
+
+	// GeoReference maps the layout to geographic coordinates. Positions are
+	// given with their latitude and longitude when it is set.
+	GeoReference *GeoReference `json:"geoReference,omitempty"`
+
 	simulation *Simulation
 }
 
+// MarshalJSON for the Options type
+func (o *Options) MarshalJSON() ([]byte, error) {
+	type auxOptions Options
+	o.CurrentTime.RLock()
+	current := o.CurrentTime.Time
+	o.CurrentTime.RUnlock()
+	return json.Marshal(struct {
+		*auxOptions
+		CurrentTime string `json:"currentTime"`
+	}{
+		auxOptions:  (*auxOptions)(o),
+		CurrentTime: formatObjectTime(o.simulation, current),
+	})
+}
+
 // ID func for options to that it implements SimObject. Returns an empty string.
-func (o Options) ID() string {
+func (o *Options) ID() string {
 	return ""
 }
 
@@ -88,7 +168,16 @@ func (o *Options) Set(option string, value interface{}) error {
 			if !valTyp.AssignableTo(typ.Field(i).Type) {
 				return fmt.Errorf("cannot assign %v (%T) to %s (%s)", value, value, option, typ.Field(i).Type.Name())
 			}
+			if v, ok := val.Interface().(interface{ Validate() error }); ok {
+				if err := v.Validate(); err != nil {
+					return err
+				}
+			}
 			stVal.Field(i).Set(val)
+			if _, ok := val.Interface().(SignallingMode); ok {
+				// Signals clear on other conditions in the new mode
+				o.simulation.updateAllSignals()
+			}
 			return nil
 		}
 	}
@@ -0,0 +1,159 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// defaultOverlapReleaseTime is the time after which the overlap of a route is
+// released once the train has stopped at its exit signal, if the
+// OverlapReleaseSeconds option is not set.
+const defaultOverlapReleaseTime = 2 * time.Minute
+
+// overlapLength returns the length of the overlap of this route, that is the
+// distance beyond its exit signal that is kept clear in case the train
+// overruns the signal. It is zero if the route has no overlap.
+func (r *Route) overlapLength() float64 {
+	if r.OverlapLength > 0 {
+		return r.OverlapLength
+	}
+	return r.simulation.Options.OverlapLength
+}
+
+// OverlapPositions returns the positions beyond the exit signal of this route
+// that make up its overlap, following the current direction of points.
+func (r *Route) OverlapPositions() []Position {
+	length := r.overlapLength()
+	if length <= 0 || len(r.Positions) == 0 {
+		return nil
+	}
+	var res []Position
+	travelled := 0.0
+	for pos := r.Positions[len(r.Positions)-1].Next(DirectionCurrent); !pos.IsOut() && travelled < length; pos = pos.Next(DirectionCurrent) {
+		res = append(res, pos)
+		travelled += pos.TrackItem().RealLength()
+	}
+	return res
+}
+
+// protectedPositions returns the positions of this route after its entry
+// signal, followed by the positions of its overlap.
+func (r *Route) protectedPositions() []Position {
+	overlap := r.OverlapPositions()
+	res := make([]Position, 0, len(r.Positions)-1+len(overlap))
+	res = append(res, r.Positions[1:]...)
+	return append(res, overlap...)
+}
+
+// OverlapLocked returns true if the overlap of this route is currently locked.
+func (r *Route) OverlapLocked() bool {
+	return r.overlapLocked
+}
+
+// lockOverlap locks the overlap of this route, if it has one.
+func (r *Route) lockOverlap() {
+	if r.overlapLength() <= 0 {
+		return
+	}
+	r.overlapLocked = true
+	r.overlapReleaseAt = time.Time{}
+}
+
+// releaseOverlap releases the overlap of this route.
+func (r *Route) releaseOverlap() {
+	r.overlapLocked = false
+	r.overlapReleaseAt = time.Time{}
+}
+
+// checkOverlaps returns an error if this route runs into the locked overlap of
+// another route, or if its own overlap conflicts with another active route.
+// Routes starting at the exit signal of a route may be set over its overlap.
+func (r *Route) checkOverlaps() error {
+	for _, o := range r.simulation.lockedOverlaps() {
+		if o.Equals(r) || o.EndSignalId == r.BeginSignalId {
+			continue
+		}
+		overlap := make(map[string]bool)
+		for _, pos := range o.OverlapPositions() {
+			overlap[pos.TrackItemID] = true
+		}
+		for _, pos := range r.Positions[1:] {
+			if overlap[pos.TrackItemID] {
+				return fmt.Errorf("track item %s is in the overlap of route %s", pos.TrackItemID, o.ID())
+			}
+		}
+	}
+	for _, pos := range r.OverlapPositions() {
+		ar := pos.TrackItem().ActiveRoute()
+		if ar == nil || ar.Equals(r) || ar.BeginSignalId == r.EndSignalId {
+			continue
+		}
+		if pos.TrackItem().ActiveRoutePreviousItem().ID() != pos.PreviousItemID {
+			return fmt.Errorf("overlap conflicts with route %s on track item %s", ar.ID(), pos.TrackItemID)
+		}
+	}
+	return nil
+}
+
+// lockedOverlaps returns the routes of the simulation with a locked overlap,
+// sorted by ID.
+func (sim *Simulation) lockedOverlaps() []*Route {
+	var res []*Route
+	for _, r := range sim.Routes {
+		if r.overlapLocked {
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID() < res[j].ID()
+	})
+	return res
+}
+
+// updateOverlaps releases the overlaps of routes that have been cleared by
+// trains and starts the release timer of routes with a train stopped at their
+// exit signal.
+func (sim *Simulation) updateOverlaps() {
+	releaseTime := time.Duration(sim.Options.OverlapReleaseSeconds) * time.Second
+	if releaseTime <= 0 {
+		releaseTime = defaultOverlapReleaseTime
+	}
+	now := sim.Options.CurrentTime.Time
+	for _, r := range sim.lockedOverlaps() {
+		if r.State() == Deactivated {
+			r.releaseOverlap()
+			continue
+		}
+		train := r.EndSignal().train
+		if r.State() != Destroying || train == nil || train.Speed > 0 {
+			continue
+		}
+		switch {
+		case r.overlapReleaseAt.IsZero():
+			r.overlapReleaseAt = now.Add(releaseTime)
+		case !now.Before(r.overlapReleaseAt):
+			r.releaseOverlap()
+			sim.MessageLogger.addMessage(fmt.Sprintf("Overlap of route %s released after train %s stopped at signal %s",
+				r.ID(), train.ServiceCode, r.EndSignal().Name()), simulationMsg)
+		}
+	}
+}
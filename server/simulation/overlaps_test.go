@@ -0,0 +1,103 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestOverlaps(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing overlaps beyond stop signals", t, func() {
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		var raw map[string]interface{}
+		So(json.Unmarshal(data, &raw), ShouldBeNil)
+		options := raw["options"].(map[string]interface{})
+		options["overlapLength"] = 200
+		options["overlapReleaseSeconds"] = 20
+		data, _ = json.Marshal(raw)
+		var sim simulation.Simulation
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		r1, r11 := sim.Routes["1"], sim.Routes["11"]
+		Convey("Overlaps should be locked with their route", func() {
+			positions := r1.OverlapPositions()
+			So(positions, ShouldHaveLength, 1)
+			So(positions[0].TrackItemID, ShouldEqual, "102")
+			So(r1.OverlapLocked(), ShouldBeTrue)
+			data, err := json.Marshal(r1)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"overlapLocked":true`)
+			// Onward routes may be set over the overlap
+			So(r11.Deactivate(), ShouldBeNil)
+			So(r11.Activate(false), ShouldBeNil)
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r1.OverlapLocked(), ShouldBeFalse)
+		})
+		Convey("Routes should not be set over a locked overlap", func() {
+			// Add signals facing LFT beyond route 1 and a route between them
+			// running over the overlap of route 1 in the opposite direction.
+			items := raw["trackItems"].(map[string]interface{})
+			lftSignal := func(id, previous, next string) map[string]interface{} {
+				return map[string]interface{}{
+					"__type__": "SignalItem", "tiId": id, "name": id, "signalType": "UK_3_ASPECTS",
+					"reverse": true, "previousTiId": previous, "nextTiId": next, "x": 470.0, "y": 0.0,
+					"xn": 475.0, "yn": 5.0, "customProperties": map[string]interface{}{},
+				}
+			}
+			items["103"] = lftSignal("103", "104", "102")
+			items["105"] = lftSignal("105", "102", "101")
+			items["101"].(map[string]interface{})["nextTiId"] = "105"
+			items["102"].(map[string]interface{})["previousTiId"] = "105"
+			raw["routes"].(map[string]interface{})["12"] = map[string]interface{}{
+				"__type__": "Route", "id": "12", "beginSignal": "103", "endSignal": "105",
+				"directions": map[string]interface{}{}, "initialState": 0,
+			}
+			data, _ = json.Marshal(raw)
+			var sim simulation.Simulation
+			So(json.Unmarshal(data, &sim), ShouldBeNil)
+			drainEvents(&sim, endChan)
+			So(sim.Initialize(), ShouldBeNil)
+			for _, tr := range sim.Trains {
+				So(tr.Cancel(), ShouldBeNil)
+			}
+			r1, r11, r12 := sim.Routes["1"], sim.Routes["11"], sim.Routes["12"]
+			So(r11.Deactivate(), ShouldBeNil)
+			So(r1.OverlapLocked(), ShouldBeTrue)
+			err := r12.Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "track item 102 is in the overlap of route 1")
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r12.Activate(false), ShouldBeNil)
+			err = r1.Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "track item 10 is in the overlap of route 12")
+		})
+		Convey("Overlaps should be released by timer when the train stops at the exit signal", func() {
+			train := sim.Trains[0]
+			So(stepUntil(&sim, 2000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+			So(r1.State(), ShouldEqual, simulation.Destroying)
+			So(r1.OverlapLocked(), ShouldBeTrue)
+			// The overlap survives cloning
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			So(clone.Routes["1"].OverlapLocked(), ShouldBeTrue)
+			So(stepUntil(&sim, 200, func() bool { return !r1.OverlapLocked() }), ShouldBeTrue)
+			So(r1.State(), ShouldEqual, simulation.Destroying)
+			var released bool
+			for _, m := range sim.MessageLogger.Messages {
+				released = released || strings.HasPrefix(m.MsgText, "Overlap of route 1 released")
+			}
+			So(released, ShouldBeTrue)
+		})
+	})
+}
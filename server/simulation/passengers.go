@@ -0,0 +1,147 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// defaultPassengerFlow is the number of passengers per second boarding or
+	// alighting a train when the simulation does not define
+	// passengerFlowRate.
+	defaultPassengerFlow float64 = 2
+	// maxPassengerWait is the longest time passengers wait for a train at a
+	// place. They give up after this time.
+	maxPassengerWait = time.Hour
+)
+
+// PassengerDemand is the passenger traffic at a place during a period of the
+// day. Periods ending before they start span midnight.
+type PassengerDemand struct {
+	Start Time `json:"start"`
+	End   Time `json:"end"`
+	// Boarding is the number of passengers per hour arriving at the place to
+	// take a train.
+	Boarding float64 `json:"boarding"`
+	// Alighting is the share, between 0 and 1, of the passengers on board a
+	// train who alight at the place.
+	Alighting float64 `json:"alighting"`
+}
+
+// includes returns true if the time of day of h is within this period.
+func (pd *PassengerDemand) includes(h time.Time) bool {
+	clock := func(t time.Time) time.Duration {
+		hour, min, sec := t.Clock()
+		return time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	}
+	start, end, now := clock(pd.Start.Time), clock(pd.End.Time), clock(h)
+	if end < start {
+		return now >= start || now < end
+	}
+	return now >= start && now < end
+}
+
+// demandAt returns the number of passengers per hour arriving at this place to
+// take a train and the share of passengers alighting at h.
+func (pl *Place) demandAt(h time.Time) (float64, float64) {
+	for i := range pl.PassengerDemand {
+		pd := &pl.PassengerDemand[i]
+		if pd.includes(h) {
+			return math.Max(0, pd.Boarding), math.Max(0, math.Min(1, pd.Alighting))
+		}
+	}
+	return 0, 0
+}
+
+// waitingAt returns the number of passengers waiting for a train at this place
+// at h.
+func (pl *Place) waitingAt(h time.Time) float64 {
+	from, waiting := pl.lastBoarding, pl.waitingPassengers
+	if from.IsZero() || h.Sub(from) > maxPassengerWait {
+		from, waiting = h.Add(-maxPassengerWait), 0
+	}
+	for t := from; t.Before(h); t = t.Add(time.Minute) {
+		step := h.Sub(t)
+		if step > time.Minute {
+			step = time.Minute
+		}
+		rate, _ := pl.demandAt(t)
+		waiting += rate * step.Hours()
+	}
+	return waiting
+}
+
+// WaitingPassengers returns the number of passengers waiting for a train at
+// this place.
+func (pl *Place) WaitingPassengers() float64 {
+	return pl.waitingAt(pl.simulation.Options.CurrentTime.Time)
+}
+
+// PassengerFlow returns the number of passengers per second who can board or
+// alight a train.
+func (sim *Simulation) PassengerFlow() float64 {
+	if sim.Options.PassengerFlowRate <= 0 {
+		return defaultPassengerFlow
+	}
+	return sim.Options.PassengerFlowRate
+}
+
+// LastPassengerExchange returns the number of passengers who boarded and who
+// alighted this train at its last stop.
+func (t *Train) LastPassengerExchange() (float64, float64) {
+	return t.boarded, t.alighted
+}
+
+// PassengerDwellTime returns the time needed at the current or last stop of
+// this train for the passengers to board and alight.
+func (t *Train) PassengerDwellTime() time.Duration {
+	return t.passengerDwell
+}
+
+// exchangePassengers makes passengers alight from and board this train which
+// has just stopped at a station, and sets the time needed for this. All
+// passengers alight at the last stop of the service. Passengers who do not fit
+// in the train wait for the next one.
+func (t *Train) exchangePassengers() {
+	t.boarded, t.alighted, t.passengerDwell = 0, 0, 0
+	pl := t.TrainHead.TrackItem().Place()
+	if pl == nil {
+		return
+	}
+	now := t.simulation.Options.CurrentTime.Time
+	_, share := pl.demandAt(now)
+	if t.nextStopLine() == nil {
+		share = 1
+	}
+	t.alighted = math.Round(t.Passengers * share)
+	t.Passengers -= t.alighted
+	waiting := pl.waitingAt(now)
+	boarding := waiting
+	if capacity := t.TrainType().Capacity; capacity > 0 {
+		boarding = math.Min(boarding, math.Max(0, capacity-t.Passengers))
+	}
+	t.boarded = math.Floor(boarding)
+	t.Passengers += t.boarded
+	pl.waitingPassengers = waiting - t.boarded
+	pl.lastBoarding = now
+	seconds := (t.boarded + t.alighted) / t.simulation.PassengerFlow()
+	t.passengerDwell = time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPassengers(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given boarding demand per
+	// hour at STN in the morning and the given capacity for UT trains.
+	loadSim := func(boarding, capacity float64) *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, func(raw map[string]interface{}) {
+			stn := raw["trackItems"].(map[string]interface{})["20"].(map[string]interface{})
+			stn["passengerDemand"] = []map[string]interface{}{
+				{"start": "05:00:00", "end": "10:00:00", "boarding": boarding, "alighting": 0.5},
+			}
+			raw["trainTypes"].(map[string]interface{})["UT"].(map[string]interface{})["capacity"] = capacity
+			raw["trains"].([]interface{})[0].(map[string]interface{})["passengers"] = 100
+		})
+		So(err, ShouldBeNil)
+		return sim
+	}
+	stopAtStation := func(sim *simulation.Simulation) *simulation.Train {
+		train := sim.Trains[0]
+		So(stepUntil(sim, 3000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+		return train
+	}
+	Convey("Testing passenger loads", t, func() {
+		Convey("Passenger demand should be loaded and saved", func() {
+			sim := loadSim(600, 0)
+			stn := sim.Places["STN"]
+			So(stn.PassengerDemand, ShouldHaveLength, 1)
+			So(stn.PassengerDemand[0].Boarding, ShouldEqual, 600)
+			So(sim.Trains[0].Passengers, ShouldEqual, 100)
+			data, err := json.Marshal(stn)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"passengerDemand":[{"start":"05:00:00","end":"10:00:00","boarding":600,"alighting":0.5}]`)
+			data, err = json.Marshal(sim.Places["LFT"])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, "passengerDemand")
+		})
+		Convey("Passengers should wait for trains", func() {
+			sim := loadSim(600, 0)
+			// Passengers who arrived in the last hour are waiting
+			So(sim.Places["STN"].WaitingPassengers(), ShouldAlmostEqual, 600, 1)
+			So(sim.Places["LFT"].WaitingPassengers(), ShouldEqual, 0)
+		})
+		Convey("Passengers should board and alight at stations", func() {
+			sim := loadSim(600, 0)
+			train := stopAtStation(sim)
+			boarded, alighted := train.LastPassengerExchange()
+			// STN is the last stop of S001
+			So(alighted, ShouldEqual, 100)
+			// Passengers wait one hour at most
+			So(boarded, ShouldAlmostEqual, 600, 1)
+			So(train.Passengers, ShouldEqual, boarded)
+			So(sim.Places["STN"].WaitingPassengers(), ShouldBeLessThan, 1)
+			So(train.PassengerDwellTime(), ShouldAlmostEqual, time.Duration((boarded+alighted)/2*float64(time.Second)), time.Second)
+			So(train.MinimumStopTime(), ShouldBeGreaterThanOrEqualTo, train.PassengerDwellTime())
+		})
+		Convey("Passengers should wait for the next train if the train is full", func() {
+			sim := loadSim(600, 150)
+			train := stopAtStation(sim)
+			boarded, _ := train.LastPassengerExchange()
+			So(boarded, ShouldEqual, 150)
+			So(train.Passengers, ShouldEqual, 150)
+			So(sim.Places["STN"].WaitingPassengers(), ShouldAlmostEqual, 450, 1)
+		})
+		Convey("Busy stations should lengthen dwell times", func() {
+			quiet := stopAtStation(loadSim(0, 0))
+			busy := stopAtStation(loadSim(3600, 0))
+			So(busy.MinimumStopTime(), ShouldBeGreaterThan, quiet.MinimumStopTime())
+		})
+		Convey("Passenger states should be kept in checkpoints", func() {
+			sim := loadSim(600, 150)
+			train := stopAtStation(sim)
+			data, err := sim.Checkpoint()
+			So(err, ShouldBeNil)
+			restored, err := simulation.RestoreCheckpoint(data)
+			So(err, ShouldBeNil)
+			drainEvents(restored, endChan)
+			rt := restored.Trains[0]
+			So(rt.Passengers, ShouldEqual, train.Passengers)
+			So(rt.PassengerDwellTime(), ShouldEqual, train.PassengerDwellTime())
+			So(restored.Places["STN"].WaitingPassengers(), ShouldAlmostEqual, sim.Places["STN"].WaitingPassengers(), 0.01)
+		})
+	})
+}
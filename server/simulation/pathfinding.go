@@ -0,0 +1,277 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A RoutePath is a sequence of routes, each one beginning at the end signal
+// of the previous one. It leads a train between two signals or places that
+// no single route links.
+type RoutePath struct {
+	Routes []*Route
+	// Length is the total length of the routes in meters
+	Length float64
+}
+
+// RouteIDs returns the IDs of the routes of this path, in order.
+func (p *RoutePath) RouteIDs() []string {
+	ids := make([]string, len(p.Routes))
+	for i, r := range p.Routes {
+		ids[i] = r.ID()
+	}
+	return ids
+}
+
+// length returns the length of the route in meters, from its begin signal to
+// its end signal.
+func (r *Route) length() float64 {
+	var res float64
+	for _, pos := range r.Positions[1:] {
+		res += pos.TrackItem().RealLength()
+	}
+	return res
+}
+
+// usable returns true if trains can be routed through r, that is if no
+// disruption prevents its activation and its begin signal can clear.
+func (r *Route) usable() bool {
+	return r.checkDisruptions() == nil && !r.BeginSignal().Failed()
+}
+
+// touches returns true if the route runs over a track item of the place with
+// the given code and, if trackCode is not empty, of this track.
+func (r *Route) touches(placeCode, trackCode string) bool {
+	for _, pos := range r.Positions {
+		ti := pos.TrackItem()
+		if ti.Place() == nil || ti.Place().PlaceCode != placeCode {
+			continue
+		}
+		if trackCode == "" || ti.TrackCode() == trackCode {
+			return true
+		}
+	}
+	return false
+}
+
+// FindRoutePath returns the shortest sequence of usable routes starting at
+// the signal with ID fromSignalID and going through all the given waypoints,
+// in order.
+//
+// A waypoint is either the ID of a signal, which the path must reach, or the
+// code of a place, optionally followed by a slash and a track code (e.g.
+// "STN/2"), whose tracks the path must run over.
+func (sim *Simulation) FindRoutePath(fromSignalID string, waypoints ...string) (*RoutePath, error) {
+	if _, ok := sim.TrackItems[fromSignalID].(*SignalItem); !ok {
+		return nil, fmt.Errorf("unknown signal: %s", fromSignalID)
+	}
+	if len(waypoints) == 0 {
+		return nil, fmt.Errorf("no waypoint given")
+	}
+	res := new(RoutePath)
+	from := fromSignalID
+	for _, wp := range waypoints {
+		target, err := sim.waypointTarget(wp)
+		if err != nil {
+			return nil, err
+		}
+		segment, err := sim.findRoutePath(from, target)
+		if err != nil {
+			return nil, fmt.Errorf("no path found from signal %s to %s", from, wp)
+		}
+		res.Routes = append(res.Routes, segment.Routes...)
+		res.Length += segment.Length
+		from = segment.Routes[len(segment.Routes)-1].EndSignalId
+	}
+	return res, nil
+}
+
+// waypointTarget returns a function that returns true if a path whose last
+// route is r reaches the given waypoint.
+func (sim *Simulation) waypointTarget(wp string) (func(r *Route) bool, error) {
+	if _, ok := sim.TrackItems[wp].(*SignalItem); ok {
+		return func(r *Route) bool {
+			return r.EndSignalId == wp
+		}, nil
+	}
+	placeCode, trackCode := wp, ""
+	if i := strings.Index(wp, "/"); i >= 0 {
+		placeCode, trackCode = wp[:i], wp[i+1:]
+	}
+	if _, ok := sim.Places[placeCode]; !ok {
+		return nil, fmt.Errorf("unknown signal or place: %s", wp)
+	}
+	return func(r *Route) bool {
+		return r.touches(placeCode, trackCode)
+	}, nil
+}
+
+// findRoutePath returns the shortest sequence of usable routes starting at the
+// signal with ID from and whose last route satisfies isTarget.
+//
+// Signals are the nodes of the graph and routes its edges, weighted by their
+// length.
+func (sim *Simulation) findRoutePath(from string, isTarget func(r *Route) bool) (*RoutePath, error) {
+	type node struct {
+		distance float64
+		route    *Route
+		done     bool
+	}
+	nodes := map[string]*node{from: {}}
+	var (
+		best     *Route
+		bestDist float64
+	)
+	for {
+		// Take the closest signal not yet visited
+		var (
+			cur   string
+			curNd *node
+		)
+		for id, nd := range nodes {
+			if nd.done {
+				continue
+			}
+			if curNd == nil || nd.distance < curNd.distance || (nd.distance == curNd.distance && id < cur) {
+				cur, curNd = id, nd
+			}
+		}
+		if curNd == nil || (best != nil && curNd.distance >= bestDist) {
+			break
+		}
+		curNd.done = true
+		for _, r := range sim.routesByBeginSignal[cur] {
+			if !r.usable() {
+				continue
+			}
+			dist := curNd.distance + r.length()
+			if isTarget(r) && (best == nil || dist < bestDist) {
+				best, bestDist = r, dist
+			}
+			nd, ok := nodes[r.EndSignalId]
+			if !ok {
+				nodes[r.EndSignalId] = &node{distance: dist, route: r}
+				continue
+			}
+			if !nd.done && dist < nd.distance {
+				nd.distance, nd.route = dist, r
+			}
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no path found")
+	}
+	res := RoutePath{Length: bestDist}
+	for r := best; r != nil; r = nodes[r.BeginSignalId].route {
+		res.Routes = append([]*Route{r}, res.Routes...)
+	}
+	return &res, nil
+}
+
+// activateRoutes activates the given routes in order, as persistent routes if
+// persistent is true. If one of them cannot be activated, the routes already
+// activated are deactivated and an error is returned.
+func activateRoutes(routes []*Route, persistent bool) error {
+	var activated []*Route
+	for _, r := range routes {
+		if r.IsActive() {
+			continue
+		}
+		if err := r.Activate(persistent); err != nil {
+			for i := len(activated) - 1; i >= 0; i-- {
+				_ = activated[i].Deactivate()
+			}
+			return fmt.Errorf("unable to activate route %s: %s", r.ID(), err)
+		}
+		activated = append(activated, r)
+	}
+	return nil
+}
+
+// SetThroughRoute sets a through route from the signal with ID beginSignalID to
+// the signal with ID endSignalID, that is the shortest sequence of usable
+// routes between them, going through the given intermediate signals or places
+// in order (see FindRoutePath).
+//
+// The routes of the path already set are kept. Nothing is changed if a route
+// is already set from the begin signal to another signal than the path's or
+// if the routes cannot all be activated.
+func (sim *Simulation) SetThroughRoute(beginSignalID, endSignalID string, persistent bool, via ...string) (*RoutePath, error) {
+	if _, ok := sim.TrackItems[endSignalID].(*SignalItem); !ok {
+		return nil, fmt.Errorf("unknown signal: %s", endSignalID)
+	}
+	path, err := sim.FindRoutePath(beginSignalID, append(append([]string{}, via...), endSignalID)...)
+	if err != nil {
+		return nil, err
+	}
+	if current := path.Routes[0].BeginSignal().nextActiveRoute; current != nil && !current.Equals(path.Routes[0]) {
+		return nil, fmt.Errorf("route %s is already set from signal %s", current.ID(), beginSignalID)
+	}
+	if err := activateRoutes(path.Routes, persistent); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// Reroute sets the routes leading this train from its next signal through the
+// given waypoints (see FindRoutePath).
+//
+// The route currently set from the next signal of the train is replaced if it
+// is not part of the new path and no train is on it. Nothing is changed if the
+// new routes cannot all be activated.
+func (t *Train) Reroute(waypoints ...string) (*RoutePath, error) {
+	if !t.IsActive() {
+		return nil, fmt.Errorf("train %s is not active", t.ID())
+	}
+	sig := t.findNextSignal()
+	if sig == nil {
+		return nil, fmt.Errorf("no signal ahead of train %s", t.ID())
+	}
+	path, err := t.simulation.FindRoutePath(sig.ID(), waypoints...)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.setRoutePath(sig, path); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// setRoutePath activates the routes of path, which starts at sig, the next
+// signal of this train. See Reroute.
+func (t *Train) setRoutePath(sig *SignalItem, path *RoutePath) error {
+	previous := sig.nextActiveRoute
+	if previous != nil && !previous.Equals(path.Routes[0]) {
+		if routeHasAnyTrain(previous) {
+			return fmt.Errorf("route %s is occupied", previous.ID())
+		}
+		if err := previous.Deactivate(); err != nil {
+			return err
+		}
+	}
+	if err := activateRoutes(path.Routes, false); err != nil {
+		if previous != nil && !previous.IsActive() {
+			_ = previous.Activate(previous.Persistent)
+		}
+		return err
+	}
+	return nil
+}
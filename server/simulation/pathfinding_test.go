@@ -0,0 +1,188 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPathfinding(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing pathfinding", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		Convey("Paths should be found between signals and places", func() {
+			path, err := sim.FindRoutePath("5", "17")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"2"})
+			path, err = sim.FindRoutePath("5", "11")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"1", "11"})
+			So(path.Length, ShouldBeGreaterThan, 0)
+			path, err = sim.FindRoutePath("5", "101", "11")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"1", "11"})
+			path, err = sim.FindRoutePath("5", "STN/1")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"1"})
+			path, err = sim.FindRoutePath("5", "STN/2")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"2"})
+		})
+		Convey("Invalid or unreachable waypoints should fail", func() {
+			_, err := sim.FindRoutePath("5", "3")
+			So(err, ShouldNotBeNil)
+			_, err = sim.FindRoutePath("5", "UNKNOWN")
+			So(err, ShouldNotBeNil)
+			_, err = sim.FindRoutePath("4", "17")
+			So(err, ShouldNotBeNil)
+			_, err = sim.FindRoutePath("5")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Disrupted routes should be avoided", func() {
+			So(sim.AddDisruption(&simulation.Disruption{Type: simulation.DisruptionTrackBlocked, TrackItemID: "16"}), ShouldBeNil)
+			_, err := sim.FindRoutePath("5", "STN/2")
+			So(err, ShouldNotBeNil)
+			path, err := sim.FindRoutePath("5", "STN")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"1"})
+		})
+		Convey("Through routes should be set between distant signals", func() {
+			So(sim.Routes["11"].Deactivate(), ShouldBeNil)
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			path, err := sim.SetThroughRoute("5", "11", true, "101")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"1", "11"})
+			So(sim.Routes["1"].State(), ShouldEqual, simulation.Persistent)
+			So(sim.Routes["11"].State(), ShouldEqual, simulation.Persistent)
+			_, err = sim.SetThroughRoute("5", "17", false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "route 1 is already set from signal 5")
+			_, err = sim.SetThroughRoute("5", "STN", false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown signal: STN")
+		})
+		Convey("Trains should be rerouted", func() {
+			train := sim.Trains[0]
+			_, err := train.Reroute("STN/2")
+			So(err, ShouldNotBeNil)
+			sim.Start()
+			time.Sleep(600 * time.Millisecond)
+			sim.Pause()
+			So(train.IsActive(), ShouldBeTrue)
+			path, err := train.Reroute("STN/2")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"2"})
+			So(sim.Routes["1"].IsActive(), ShouldBeFalse)
+			So(sim.Routes["2"].IsActive(), ShouldBeTrue)
+			path, err = train.Reroute("11")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"1", "11"})
+			So(sim.Routes["1"].IsActive(), ShouldBeTrue)
+			So(sim.Routes["2"].IsActive(), ShouldBeFalse)
+			So(sim.Routes["11"].IsActive(), ShouldBeTrue)
+		})
+		Convey("Diversions should be suggested when the planned track is unreachable", func() {
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			sim.Start()
+			time.Sleep(600 * time.Millisecond)
+			sim.Pause()
+			So(sim.AddDisruption(&simulation.Disruption{Type: simulation.DisruptionTrackBlocked, TrackItemID: "16"}), ShouldBeNil)
+			sim.RecomputeSuggestions()
+			var diversion *simulation.Suggestion
+			for i, s := range sim.Suggestions.Items {
+				if s.Kind == simulation.SuggestionRouteDiversion {
+					diversion = &sim.Suggestions.Items[i]
+				}
+			}
+			So(diversion, ShouldNotBeNil)
+			So(strings.HasSuffix(diversion.ID, ":1"), ShouldBeTrue)
+			So(diversion.Actions, ShouldHaveLength, 1)
+			So(sim.AcceptSuggestion(diversion.ID), ShouldBeNil)
+			So(sim.Routes["1"].IsActive(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestThroughRouteSuggestions(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Through routes should be suggested for departures crossing several blocks", t, func() {
+		// Split route 11 in two blocks with a signal at 103 and make S001 stop
+		// at RGT (item 104) after STN.
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		var raw map[string]interface{}
+		So(json.Unmarshal(data, &raw), ShouldBeNil)
+		items := raw["trackItems"].(map[string]interface{})
+		items["103"] = map[string]interface{}{
+			"__type__": "SignalItem", "tiId": "103", "name": "103", "signalType": "UK_3_ASPECTS",
+			"reverse": false, "previousTiId": "102", "nextTiId": "104", "x": 470.0, "y": 0.0,
+			"xn": 475.0, "yn": 5.0, "customProperties": map[string]interface{}{},
+		}
+		items["104"].(map[string]interface{})["placeCode"] = "RGT"
+		routes := raw["routes"].(map[string]interface{})
+		delete(routes, "11")
+		for id, begin := range map[string]string{"20": "101", "21": "103"} {
+			end := map[string]string{"20": "103", "21": "11"}[id]
+			routes[id] = map[string]interface{}{
+				"__type__": "Route", "id": id, "beginSignal": begin, "endSignal": end,
+				"directions": map[string]interface{}{}, "initialState": 0,
+			}
+		}
+		s001 := raw["services"].(map[string]interface{})["S001"].(map[string]interface{})
+		s001["lines"] = append(s001["lines"].([]interface{}), map[string]interface{}{
+			"__type__": "ServiceLine", "mustStop": true, "placeCode": "RGT", "trackCode": nil,
+			"scheduledArrivalTime": "06:03:00", "scheduledDepartureTime": "06:03:30",
+		})
+		data, _ = json.Marshal(raw)
+		var sim simulation.Simulation
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		train := sim.Trains[0]
+		So(stepUntil(&sim, 3000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+		So(train.TrainHead.TrackItem().Place().PlaceCode, ShouldEqual, "STN")
+		So(stepUntil(&sim, 3000, func() bool {
+			sim.RecomputeSuggestions()
+			for _, s := range sim.Suggestions.Items {
+				if s.Kind == simulation.SuggestionThroughRoute {
+					return true
+				}
+			}
+			return false
+		}), ShouldBeTrue)
+		var through *simulation.Suggestion
+		for i, s := range sim.Suggestions.Items {
+			if s.Kind == simulation.SuggestionThroughRoute {
+				through = &sim.Suggestions.Items[i]
+			}
+		}
+		So(through.ID, ShouldEqual, "THROUGH_ROUTE:0:20+21")
+		So(through.Actions, ShouldHaveLength, 1)
+		So(through.Actions[0].Action, ShouldEqual, "setThrough")
+		So(through.Actions[0].Params["via"], ShouldResemble, []string{"103"})
+		// The through route comes before setting the first block only
+		for _, s := range sim.Suggestions.Items {
+			if s.Kind == simulation.SuggestionRouteActivate {
+				So(s.Score, ShouldBeLessThan, through.Score)
+			}
+		}
+		So(sim.AcceptSuggestion(through.ID), ShouldBeNil)
+		So(sim.Routes["20"].IsActive(), ShouldBeTrue)
+		So(sim.Routes["21"].IsActive(), ShouldBeTrue)
+	})
+}
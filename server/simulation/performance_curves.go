@@ -0,0 +1,136 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+// etaStep is the distance in meters over which the speed of a train is
+// considered constant when estimating its running time.
+const etaStep float64 = 10
+
+// A PerformancePoint is the acceleration or braking rate in m/s² of a train
+// type at a given speed in m/s.
+type PerformancePoint struct {
+	Speed float64 `json:"speed"`
+	Rate  float64 `json:"rate"`
+}
+
+// A PerformanceCurve gives the acceleration or braking rate of a train type
+// depending on its speed. Points are sorted by increasing speed and the rate
+// is interpolated linearly between them. Below the first point and above the
+// last one, the rate of the nearest point applies.
+type PerformanceCurve []PerformancePoint
+
+// check returns an error if this curve is not sorted by increasing speeds or
+// has a rate that is not positive.
+func (c PerformanceCurve) check() error {
+	for i, p := range c {
+		if p.Rate <= 0 {
+			return fmt.Errorf("rate at speed %.2f must be positive", p.Speed)
+		}
+		if p.Speed < 0 || (i > 0 && p.Speed <= c[i-1].Speed) {
+			return fmt.Errorf("speeds must be positive and increasing")
+		}
+	}
+	return nil
+}
+
+// rateAt returns the rate of this curve at the given speed, or def if the
+// curve is empty.
+func (c PerformanceCurve) rateAt(speed, def float64) float64 {
+	if len(c) == 0 {
+		return def
+	}
+	if speed <= c[0].Speed {
+		return c[0].Rate
+	}
+	for i := 1; i < len(c); i++ {
+		if speed <= c[i].Speed {
+			ratio := (speed - c[i-1].Speed) / (c[i].Speed - c[i-1].Speed)
+			return c[i-1].Rate + ratio*(c[i].Rate-c[i-1].Rate)
+		}
+	}
+	return c[len(c)-1].Rate
+}
+
+// lowerCurve returns the curve made of the lowest rates of c1 and c2 at each
+// speed of both curves, def1 and def2 being the rates of empty curves. It
+// returns nil if both curves are empty.
+func lowerCurve(c1 PerformanceCurve, def1 float64, c2 PerformanceCurve, def2 float64) PerformanceCurve {
+	if len(c1) == 0 && len(c2) == 0 {
+		return nil
+	}
+	var res PerformanceCurve
+	i, j := 0, 0
+	for i < len(c1) || j < len(c2) {
+		var speed float64
+		switch {
+		case j >= len(c2) || (i < len(c1) && c1[i].Speed < c2[j].Speed):
+			speed = c1[i].Speed
+			i++
+		case i >= len(c1) || c2[j].Speed < c1[i].Speed:
+			speed = c2[j].Speed
+			j++
+		default:
+			speed = c1[i].Speed
+			i++
+			j++
+		}
+		res = append(res, PerformancePoint{
+			Speed: speed,
+			Rate:  math.Min(c1.rateAt(speed, def1), c2.rateAt(speed, def2)),
+		})
+	}
+	return res
+}
+
+// AccelerationAt returns the acceleration of this train type at the given
+// speed on a flat and straight track.
+func (tt *TrainType) AccelerationAt(speed float64) float64 {
+	return tt.AccelCurve.rateAt(speed, tt.StdAccel)
+}
+
+// BrakingAt returns the standard braking rate of this train type at the given
+// speed on a flat and straight track.
+func (tt *TrainType) BrakingAt(speed float64) float64 {
+	return tt.BrakingCurve.rateAt(speed, tt.StdBraking)
+}
+
+// runningTime returns the time in seconds for a train of this type to run
+// distance meters against resistance, starting at speed and accelerating up
+// to maxSpeed. speed is updated to the speed at the end of the distance.
+func (tt *TrainType) runningTime(distance float64, speed *float64, maxSpeed, resistance float64) float64 {
+	const minSpeed = 0.5
+	maxSpeed = math.Max(minSpeed, maxSpeed)
+	*speed = math.Max(minSpeed, math.Min(*speed, maxSpeed))
+	var seconds float64
+	for distance > 0 {
+		step := math.Min(etaStep, distance)
+		accel := tt.AccelerationAt(*speed)
+		accel = math.Max(accel*minTractionShare, accel-resistance)
+		next := math.Min(maxSpeed, math.Sqrt(*speed**speed+2*accel*step))
+		seconds += 2 * step / (*speed + next)
+		*speed = next
+		distance -= step
+	}
+	return seconds
+}
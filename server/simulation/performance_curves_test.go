@@ -0,0 +1,74 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPerformanceCurves(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given curves set on the UT
+	// train type.
+	loadSim := func(accel, braking []map[string]float64) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			ut := raw["trainTypes"].(map[string]interface{})["UT"].(map[string]interface{})
+			ut["accelCurve"] = accel
+			ut["brakingCurve"] = braking
+		})
+	}
+	strong := []map[string]float64{{"speed": 0, "rate": 1.2}, {"speed": 10, "rate": 0.8}, {"speed": 20, "rate": 0.2}}
+	Convey("Testing performance curves", t, func() {
+		Convey("Curves should be checked", func() {
+			_, err := loadSim([]map[string]float64{{"speed": 10, "rate": 1}, {"speed": 5, "rate": 0.5}}, nil)
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(nil, []map[string]float64{{"speed": 0, "rate": 0}})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Rates should be interpolated from the curves", func() {
+			sim, err := loadSim(strong, []map[string]float64{{"speed": 0, "rate": 0.4}, {"speed": 20, "rate": 0.8}})
+			So(err, ShouldBeNil)
+			ut := sim.TrainTypes["UT"]
+			So(ut.AccelerationAt(0), ShouldEqual, 1.2)
+			So(ut.AccelerationAt(5), ShouldAlmostEqual, 1.0)
+			So(ut.AccelerationAt(15), ShouldAlmostEqual, 0.5)
+			So(ut.AccelerationAt(30), ShouldEqual, 0.2)
+			So(ut.BrakingAt(10), ShouldAlmostEqual, 0.6)
+			So(sim.TrainTypes["UT2"].AccelerationAt(15), ShouldEqual, sim.TrainTypes["UT2"].StdAccel)
+			train := sim.Trains[0]
+			train.Speed = 15
+			So(train.Acceleration(), ShouldAlmostEqual, 0.5)
+			So(train.Braking(), ShouldAlmostEqual, 0.7)
+			data, err := json.Marshal(ut)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"accelCurve":[{"speed":0,"rate":1.2}`)
+			data, err = json.Marshal(sim.TrainTypes["UT2"])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, "accelCurve")
+		})
+		Convey("Trains should follow their acceleration curve", func() {
+			run := func(sim *simulation.Simulation) float64 {
+				train := sim.Trains[0]
+				So(stepUntil(sim, 600, train.IsActive), ShouldBeTrue)
+				start := train.TrainHead
+				for i := 0; i < 10; i++ {
+					sim.Step()
+				}
+				d, err := train.TrainHead.Sub(start)
+				So(err, ShouldBeNil)
+				return d
+			}
+			std, err := loadSim(nil, nil)
+			So(err, ShouldBeNil)
+			fast, err := loadSim(strong, nil)
+			So(err, ShouldBeNil)
+			So(run(fast), ShouldBeGreaterThan, run(std))
+		})
+	})
+}
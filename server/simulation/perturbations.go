@@ -0,0 +1,237 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A PerturbationKind is a type of random disturbance of the timetable
+type PerturbationKind string
+
+const (
+	// PerturbationDwellExtension keeps a train longer at a station
+	PerturbationDwellExtension PerturbationKind = "DWELL_EXTENSION"
+	// PerturbationEntryDelay makes a train enter the area late
+	PerturbationEntryDelay PerturbationKind = "ENTRY_DELAY"
+	// PerturbationTrainFault reduces the performance of a train for a while,
+	// e.g. after a minor traction or door fault
+	PerturbationTrainFault PerturbationKind = "TRAIN_FAULT"
+)
+
+// Default distributions of the perturbations, in seconds, used when the
+// simulation does not define them.
+var (
+	defaultDwellExtension     = DelayGenerator{data: []delayTuplet{{30, 120, 80}, {120, 300, 20}}}
+	defaultEntryDelay         = DelayGenerator{data: []delayTuplet{{60, 300, 70}, {300, 900, 30}}}
+	defaultTrainFaultDuration = DelayGenerator{data: []delayTuplet{{120, 600, 100}}}
+)
+
+// defaultTrainFaultPerformance is the performance of a train after a minor
+// fault when the simulation does not define trainFaultPerformance.
+const defaultTrainFaultPerformance = 0.5
+
+// Perturbations holds the configuration of the stochastic perturbation
+// generator. When enabled, it disturbs the deterministic timetable run with
+// random dwell extensions, entry delays and minor train faults.
+//
+// Probabilities are between 0 and 1. TrainFaultRate is a number of faults per
+// running train and per hour. Distributions that are not defined take
+// sensible defaults. The random draws use the seed of the simulation options,
+// so that perturbations can be replayed, e.g. for Monte Carlo analysis.
+type Perturbations struct {
+	Enabled               bool           `json:"enabled"`
+	DwellProbability      float64        `json:"dwellProbability"`
+	DwellExtension        DelayGenerator `json:"dwellExtension"`
+	EntryDelayProbability float64        `json:"entryDelayProbability"`
+	EntryDelay            DelayGenerator `json:"entryDelay"`
+	TrainFaultRate        float64        `json:"trainFaultRate"`
+	TrainFaultDuration    DelayGenerator `json:"trainFaultDuration"`
+	TrainFaultPerformance float64        `json:"trainFaultPerformance"`
+}
+
+// Validate returns an error if these perturbations are inconsistent
+func (p Perturbations) Validate() error {
+	for name, v := range map[string]float64{
+		"dwellProbability":      p.DwellProbability,
+		"entryDelayProbability": p.EntryDelayProbability,
+		"trainFaultPerformance": p.TrainFaultPerformance,
+	} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if p.TrainFaultRate < 0 {
+		return fmt.Errorf("trainFaultRate must be positive")
+	}
+	return nil
+}
+
+// A Perturbation is a random disturbance injected into the simulation
+type Perturbation struct {
+	Kind         PerturbationKind `json:"kind"`
+	TrainID      string           `json:"trainId"`
+	ServiceCode  string           `json:"serviceCode"`
+	DelaySeconds int              `json:"delaySeconds"`
+	Time         Time             `json:"time"`
+
+	simulation *Simulation
+}
+
+// MarshalJSON method for Perturbation
+func (p *Perturbation) MarshalJSON() ([]byte, error) {
+	type auxPerturbation Perturbation
+	return json.Marshal(struct {
+		*auxPerturbation
+		Time string `json:"time"`
+	}{
+		auxPerturbation: (*auxPerturbation)(p),
+		Time:            formatObjectTime(p.simulation, p.Time.Time),
+	})
+}
+
+// ID returns the ID of the train that is disturbed
+func (p *Perturbation) ID() string {
+	return p.TrainID
+}
+
+// A PerturbationStat sums up the perturbations of one kind injected since the
+// simulation started.
+type PerturbationStat struct {
+	Count        int `json:"count"`
+	DelaySeconds int `json:"delaySeconds"`
+}
+
+// SetPerturbations replaces the configuration of the perturbation generator.
+func (sim *Simulation) SetPerturbations(p Perturbations) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	sim.perturbationsMutex.Lock()
+	sim.Options.Perturbations = p
+	sim.perturbationsMutex.Unlock()
+	sim.sendEvent(&Event{Name: OptionsChangedEvent, Object: &sim.Options})
+	return nil
+}
+
+// PerturbationStats returns the number of perturbations and the delay they
+// caused, by kind.
+func (sim *Simulation) PerturbationStats() map[PerturbationKind]PerturbationStat {
+	sim.perturbationsMutex.Lock()
+	defer sim.perturbationsMutex.Unlock()
+	res := make(map[PerturbationKind]PerturbationStat, len(sim.perturbationStats))
+	for k, v := range sim.perturbationStats {
+		res[k] = v
+	}
+	return res
+}
+
+// Perturbations returns the current configuration of the perturbation
+// generator.
+func (sim *Simulation) Perturbations() Perturbations {
+	sim.perturbationsMutex.Lock()
+	defer sim.perturbationsMutex.Unlock()
+	return sim.Options.Perturbations
+}
+
+// perturb draws whether a perturbation of the given kind happens with the
+// given probability and, if so, returns its duration taken from dg, or def if
+// dg is not defined. It returns 0 if no perturbation happens.
+func (sim *Simulation) perturb(proba float64, dg, def DelayGenerator) time.Duration {
+	sim.perturbationsMutex.Lock()
+	defer sim.perturbationsMutex.Unlock()
+	if !sim.Options.Perturbations.Enabled || proba <= 0 {
+		return 0
+	}
+	r := sim.random()
+	if r.Float64() >= proba {
+		return 0
+	}
+	if dg.IsNull() {
+		dg = def
+	}
+	return dg.yieldFrom(r)
+}
+
+// recordPerturbation logs the given perturbation and notifies clients.
+func (sim *Simulation) recordPerturbation(kind PerturbationKind, t *Train, d time.Duration) {
+	sim.perturbationsMutex.Lock()
+	if sim.perturbationStats == nil {
+		sim.perturbationStats = make(map[PerturbationKind]PerturbationStat)
+	}
+	stat := sim.perturbationStats[kind]
+	stat.Count++
+	stat.DelaySeconds += int(d / time.Second)
+	sim.perturbationStats[kind] = stat
+	sim.perturbationsMutex.Unlock()
+	p := &Perturbation{
+		Kind:         kind,
+		TrainID:      t.ID(),
+		ServiceCode:  t.ServiceCode,
+		DelaySeconds: int(d / time.Second),
+		simulation:   sim,
+	}
+	p.Time.Time = sim.Options.CurrentTime.Time
+	sim.MessageLogger.addMessage(fmt.Sprintf("Perturbation %s on train %s (%s)", kind, t.ServiceCode, d), simulationMsg)
+	sim.sendEvent(&Event{Name: PerturbationEvent, Object: p})
+}
+
+// perturbEntry delays the entry of train t at random. It is called once,
+// when the train is first due to appear.
+func (sim *Simulation) perturbEntry(t *Train) {
+	pc := sim.Perturbations()
+	if d := sim.perturb(pc.EntryDelayProbability, pc.EntryDelay, defaultEntryDelay); d > 0 {
+		t.effInitialDelay += d
+		sim.recordPerturbation(PerturbationEntryDelay, t, d)
+	}
+}
+
+// perturbDwell extends at random the stop of train t that has just stopped
+// at a station.
+func (sim *Simulation) perturbDwell(t *Train) {
+	pc := sim.Perturbations()
+	if d := sim.perturb(pc.DwellProbability, pc.DwellExtension, defaultDwellExtension); d > 0 {
+		t.minStopTime += d
+		sim.recordPerturbation(PerturbationDwellExtension, t, d)
+	}
+}
+
+// updatePerturbations injects minor faults at random into running trains.
+// step is the simulation time elapsed since the last update.
+func (sim *Simulation) updatePerturbations(step time.Duration) {
+	pc := sim.Perturbations()
+	proba := pc.TrainFaultRate * step.Hours()
+	for _, t := range sim.Trains {
+		if t.Status != Running || t.Performance() < 1 {
+			continue
+		}
+		d := sim.perturb(proba, pc.TrainFaultDuration, defaultTrainFaultDuration)
+		if d <= 0 {
+			continue
+		}
+		perf := pc.TrainFaultPerformance
+		if perf <= 0 {
+			perf = defaultTrainFaultPerformance
+		}
+		t.Degrade(perf, d)
+		sim.recordPerturbation(PerturbationTrainFault, t, d)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPerturbations(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	loadSim := func(config string) *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["options"].(map[string]interface{})["seed"] = 42
+		})
+		So(err, ShouldBeNil)
+		var p simulation.Perturbations
+		So(json.Unmarshal([]byte(config), &p), ShouldBeNil)
+		So(sim.SetPerturbations(p), ShouldBeNil)
+		return sim
+	}
+	Convey("Testing the perturbation generator", t, func() {
+		Convey("Invalid configurations should be refused", func() {
+			sim := loadSim(`{}`)
+			So(sim.SetPerturbations(simulation.Perturbations{DwellProbability: 1.5}), ShouldNotBeNil)
+			So(sim.SetPerturbations(simulation.Perturbations{TrainFaultRate: -1}), ShouldNotBeNil)
+		})
+		Convey("Disabled perturbations should not disturb the simulation", func() {
+			sim := loadSim(`{"enabled": false, "entryDelayProbability": 1, "trainFaultRate": 1000}`)
+			So(stepUntil(sim, 600, sim.Trains[0].IsActive), ShouldBeTrue)
+			for i := 0; i < 20; i++ {
+				sim.Step()
+			}
+			So(sim.PerturbationStats(), ShouldBeEmpty)
+		})
+		Convey("Entry delays should make trains appear late", func() {
+			sim := loadSim(`{"enabled": true, "entryDelayProbability": 1, "entryDelay": 60}`)
+			train := sim.Trains[0]
+			So(stepUntil(sim, 1200, train.IsActive), ShouldBeTrue)
+			So(sim.Options.CurrentTime.Time.Sub(train.AppearTime.Time).Seconds(), ShouldBeGreaterThanOrEqualTo, 60)
+			stats := sim.PerturbationStats()
+			So(stats[simulation.PerturbationEntryDelay].Count, ShouldEqual, 1)
+			So(stats[simulation.PerturbationEntryDelay].DelaySeconds, ShouldEqual, 60)
+		})
+		Convey("Train faults should degrade running trains", func() {
+			sim := loadSim(`{"enabled": true, "trainFaultRate": 100000, "trainFaultDuration": 300, "trainFaultPerformance": 0.3}`)
+			train := sim.Trains[0]
+			So(stepUntil(sim, 600, func() bool { return train.Status == simulation.Running }), ShouldBeTrue)
+			sim.Step()
+			So(train.Performance(), ShouldEqual, 0.3)
+			So(sim.PerturbationStats()[simulation.PerturbationTrainFault].Count, ShouldEqual, 1)
+		})
+		Convey("Dwell extensions should keep trains longer at stations", func() {
+			sim := loadSim(`{"enabled": true, "dwellProbability": 1, "dwellExtension": 30}`)
+			found := stepUntil(sim, 3000, func() bool {
+				return sim.PerturbationStats()[simulation.PerturbationDwellExtension].Count > 0
+			})
+			So(found, ShouldBeTrue)
+			So(sim.PerturbationStats()[simulation.PerturbationDwellExtension].DelaySeconds, ShouldEqual, 30)
+		})
+		Convey("Seeded perturbations should be reproducible", func() {
+			config := `{"enabled": true, "entryDelayProbability": 1}`
+			run := func() int {
+				sim := loadSim(config)
+				So(stepUntil(sim, 3000, sim.Trains[0].IsActive), ShouldBeTrue)
+				return sim.PerturbationStats()[simulation.PerturbationEntryDelay].DelaySeconds
+			}
+			first := run()
+			So(first, ShouldBeGreaterThan, 0)
+			So(run(), ShouldEqual, first)
+		})
+	})
+}
@@ -0,0 +1,371 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// platformMargin is the minimum time between a train leaving a platform and
+// the next train arriving on it.
+const platformMargin = time.Minute
+
+// A PlatformAllocation is the use of a platform of a place by a service that
+// has not left this place yet.
+type PlatformAllocation struct {
+	Service *Service
+	// LineIndex is the index of the line of Service at the place.
+	LineIndex int
+	// Train is the train running the service, or the train that will run it
+	// after its current service. It is nil if no train is known yet.
+	Train *Train
+	// PlannedTrack is the track code of the timetable.
+	PlannedTrack string
+	// AssignedTrack is the track code the service is assigned to now.
+	AssignedTrack string
+	// ActualTrack is the track code on which the train is stopped, or an
+	// empty string if it is not at the place yet.
+	ActualTrack string
+	// Arrival and Departure are the expected times at which the service
+	// occupies and releases the platform, i.e. its scheduled times delayed by
+	// the current delay of the train.
+	Arrival   time.Time
+	Departure time.Time
+	// Delay is the current delay of the train.
+	Delay time.Duration
+}
+
+// Line returns the service line of this allocation
+func (pa *PlatformAllocation) Line() *ServiceLine {
+	return pa.Service.Lines[pa.LineIndex]
+}
+
+// PlaceCode returns the code of the place of this allocation
+func (pa *PlatformAllocation) PlaceCode() string {
+	return pa.Line().PlaceCode
+}
+
+// Reassigned returns true if the service is not assigned to the platform of
+// the timetable anymore.
+func (pa *PlatformAllocation) Reassigned() bool {
+	return pa.AssignedTrack != pa.PlannedTrack
+}
+
+// TrackCode returns the track code used by this allocation: the actual track
+// if the train is at the place, the assigned one otherwise.
+func (pa *PlatformAllocation) TrackCode() string {
+	if pa.ActualTrack != "" {
+		return pa.ActualTrack
+	}
+	return pa.AssignedTrack
+}
+
+// overlaps returns true if pa and other need the same platform at the same
+// time, with platformMargin between the departure of one and the arrival of
+// the other.
+func (pa *PlatformAllocation) overlaps(other *PlatformAllocation) bool {
+	if pa.Train != nil && pa.Train == other.Train {
+		return false
+	}
+	return pa.Arrival.Before(other.Departure.Add(platformMargin)) &&
+		other.Arrival.Before(pa.Departure.Add(platformMargin))
+}
+
+// length returns the length of the train of this allocation, or of the
+// planned train type of the service if there is no train yet.
+func (pa *PlatformAllocation) length() float64 {
+	if pa.Train != nil {
+		return pa.Train.TrainType().Length
+	}
+	if tt := pa.Service.PlannedTrainType(); tt != nil {
+		return tt.Length
+	}
+	return 0
+}
+
+// A PlatformConflict is a platform of a place booked by two services at the
+// same time. First arrives before Second.
+type PlatformConflict struct {
+	PlaceCode string
+	TrackCode string
+	First     *PlatformAllocation
+	Second    *PlatformAllocation
+}
+
+// PlannedTrackCode returns the track code of the timetable for this line.
+func (sl *ServiceLine) PlannedTrackCode() string {
+	if sl.OriginalTrackCode != "" {
+		return sl.OriginalTrackCode
+	}
+	return sl.TrackCode
+}
+
+// serviceTrain returns the train running the service s or, if none, the
+// train whose current service continues as s. The second result is false if
+// the service is cancelled or if its train has finished it.
+func (sim *Simulation) serviceTrain(s *Service) (*Train, bool) {
+	for _, t := range sim.Trains {
+		if t.ServiceCode != s.ID() {
+			continue
+		}
+		switch t.Status {
+		case Cancelled, Joined, Out, EndOfService:
+			return nil, false
+		}
+		if t.NextPlaceIndex == NoMorePlace && t.Status != Inactive {
+			return nil, false
+		}
+		return t, true
+	}
+	for _, t := range sim.Trains {
+		if (!t.IsActive() && t.Status != Inactive) || t.Service() == nil {
+			continue
+		}
+		for _, pa := range t.Service().PostActions {
+			if pa.ActionCode == actionSetService && pa.ActionParam == s.ID() {
+				return t, true
+			}
+		}
+	}
+	return nil, true
+}
+
+// followingService returns the service that the train of s runs after s, if
+// it starts at the last place of s.
+func (s *Service) followingService() *Service {
+	if len(s.Lines) == 0 {
+		return nil
+	}
+	last := s.Lines[len(s.Lines)-1]
+	for _, pa := range s.PostActions {
+		if pa.ActionCode != actionSetService {
+			continue
+		}
+		next, ok := s.simulation.Services[pa.ActionParam]
+		if ok && len(next.Lines) > 0 && next.Lines[0].PlaceCode == last.PlaceCode {
+			return next
+		}
+	}
+	return nil
+}
+
+// platformAllocations returns the allocations of the platforms of the place
+// with the given code sorted by arrival time.
+func (sim *Simulation) platformAllocations(placeCode string) []*PlatformAllocation {
+	now := sim.Options.CurrentTime.Time
+	codes := make([]string, 0, len(sim.Services))
+	for code := range sim.Services {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	var res []*PlatformAllocation
+	for _, code := range codes {
+		s := sim.Services[code]
+		train, running := sim.serviceTrain(s)
+		if !running {
+			continue
+		}
+		var delay time.Duration
+		if train != nil {
+			delay = train.currentDelay()
+		}
+		for i, line := range s.Lines {
+			if line.PlaceCode != placeCode || !line.MustStop {
+				continue
+			}
+			pa := &PlatformAllocation{
+				Service:       s,
+				LineIndex:     i,
+				Train:         train,
+				PlannedTrack:  line.PlannedTrackCode(),
+				AssignedTrack: line.TrackCode,
+				Delay:         delay,
+			}
+			if train != nil && train.ServiceCode == code {
+				if i < train.NextPlaceIndex {
+					continue
+				}
+				if i == train.NextPlaceIndex && train.Status == Stopped {
+					pa.ActualTrack = train.TrainHead.TrackItem().TrackCode()
+				}
+			}
+			arrival, departure := line.ScheduledArrivalTime.Time, line.ScheduledDepartureTime.Time
+			if i == len(s.Lines)-1 {
+				if next := s.followingService(); next != nil {
+					departure = next.Lines[0].ScheduledDepartureTime.Time
+				}
+			}
+			if arrival.IsZero() {
+				arrival = departure
+			}
+			if departure.IsZero() {
+				departure = arrival
+			}
+			if arrival.IsZero() {
+				continue
+			}
+			pa.Arrival, pa.Departure = arrival.Add(delay), departure.Add(delay)
+			if pa.ActualTrack != "" && pa.Departure.Before(now) {
+				pa.Departure = now
+			}
+			if pa.ActualTrack == "" && pa.Departure.Before(now) && train == nil {
+				// Past services without train
+				continue
+			}
+			res = append(res, pa)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Arrival.Before(res[j].Arrival)
+	})
+	return res
+}
+
+// PlatformPlan returns the allocations of the platforms of the place with the
+// given code for the services that have not left it yet, sorted by expected
+// arrival time.
+func (sim *Simulation) PlatformPlan(placeCode string) ([]*PlatformAllocation, error) {
+	if _, ok := sim.Places[placeCode]; !ok {
+		return nil, fmt.Errorf("unknown place: %s", placeCode)
+	}
+	return sim.platformAllocations(placeCode), nil
+}
+
+// platformConflicts returns the double-booked platforms in allocs
+func platformConflicts(allocs []*PlatformAllocation) []PlatformConflict {
+	var res []PlatformConflict
+	for i, first := range allocs {
+		if first.TrackCode() == "" {
+			continue
+		}
+		for _, second := range allocs[i+1:] {
+			if second.TrackCode() != first.TrackCode() || !first.overlaps(second) {
+				continue
+			}
+			res = append(res, PlatformConflict{
+				PlaceCode: first.PlaceCode(),
+				TrackCode: first.TrackCode(),
+				First:     first,
+				Second:    second,
+			})
+		}
+	}
+	return res
+}
+
+// PlatformConflicts returns the platforms booked by two services at the same
+// time at the place with the given code, or at all places if placeCode is
+// empty.
+func (sim *Simulation) PlatformConflicts(placeCode string) ([]PlatformConflict, error) {
+	if placeCode != "" {
+		allocs, err := sim.PlatformPlan(placeCode)
+		if err != nil {
+			return nil, err
+		}
+		return platformConflicts(allocs), nil
+	}
+	codes := make([]string, 0, len(sim.Places))
+	for code := range sim.Places {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	var res []PlatformConflict
+	for _, code := range codes {
+		res = append(res, platformConflicts(sim.platformAllocations(code))...)
+	}
+	return res, nil
+}
+
+// freePlatform returns a track of the place of pa, other than its current
+// track, that is long enough for its train and not used by other allocations
+// of allocs during its stay. The planned track is preferred. It returns an
+// empty string if there is none.
+func freePlatform(pa *PlatformAllocation, allocs []*PlatformAllocation) string {
+	place := pa.Line().Place()
+	if place == nil {
+		return ""
+	}
+	tracks := append([]string{pa.PlannedTrack}, place.TrackCodes()...)
+	for _, track := range tracks {
+		if track == "" || track == pa.TrackCode() {
+			continue
+		}
+		if length := place.PlatformLength(track); length > 0 && pa.length() > length {
+			continue
+		}
+		free := true
+		for _, other := range allocs {
+			if other != pa && other.TrackCode() == track && pa.overlaps(other) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return track
+		}
+	}
+	return ""
+}
+
+// AssignPlatform assigns the line at index of the service with the given code
+// to the platform of the track with the given code, while the simulation runs.
+// The track of the timetable is kept as the planned track, and the train
+// running the service is not penalized for stopping at the new platform.
+func (sim *Simulation) AssignPlatform(code string, index int, trackCode string) error {
+	s, err := sim.serviceLine(code, index)
+	if err != nil {
+		return err
+	}
+	line := s.Lines[index]
+	place := line.Place()
+	if place == nil {
+		return fmt.Errorf("unknown place: %s", line.PlaceCode)
+	}
+	found := false
+	for _, tc := range place.TrackCodes() {
+		if tc == trackCode {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("place %s has no track %s", place.PlaceCode, trackCode)
+	}
+	for _, t := range sim.trainsOfService(code) {
+		if t.NextPlaceIndex == index && t.Status == Stopped {
+			return fmt.Errorf("train %s is already stopped at %s", t.ID(), place.PlaceCode)
+		}
+		if err := t.fitsPlatform(place, trackCode); err != nil {
+			return err
+		}
+	}
+	if trackCode == line.TrackCode {
+		return nil
+	}
+	planned := line.PlannedTrackCode()
+	line.TrackCode = trackCode
+	line.OriginalTrackCode = planned
+	if trackCode == planned {
+		line.OriginalTrackCode = ""
+	}
+	sim.timetableChanged(s, fmt.Sprintf("Service %s assigned to platform %s at %s", code, trackCode, place.Name()))
+	return nil
+}
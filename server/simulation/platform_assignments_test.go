@@ -0,0 +1,118 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPlatformAssignments(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing platform assignments", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		services := func(allocs []*simulation.PlatformAllocation) []string {
+			res := make([]string, len(allocs))
+			for i, pa := range allocs {
+				res[i] = pa.Service.ID()
+			}
+			return res
+		}
+		Convey("The plan should list the services stopping at a place", func() {
+			allocs, err := sim.PlatformPlan("STN")
+			So(err, ShouldBeNil)
+			So(services(allocs), ShouldResemble, []string{"S001", "S003", "S002"})
+			s001 := allocs[0]
+			So(s001.Train, ShouldEqual, sim.Trains[0])
+			So(s001.PlannedTrack, ShouldEqual, "2")
+			So(s001.AssignedTrack, ShouldEqual, "2")
+			So(s001.Reassigned(), ShouldBeFalse)
+			So(s001.ActualTrack, ShouldBeEmpty)
+			So(s001.Arrival.Format("15:04:05"), ShouldEqual, "06:01:30")
+			// The train stays on the platform as S002
+			So(s001.Departure.Format("15:04:05"), ShouldEqual, "06:07:00")
+			So(allocs[2].Train, ShouldEqual, sim.Trains[0])
+			conflicts, err := sim.PlatformConflicts("")
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+			_, err = sim.PlatformPlan("XXX")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("The plan should follow the trains", func() {
+			train := sim.Trains[0]
+			So(stepUntil(&sim, 3000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+			allocs, err := sim.PlatformPlan("STN")
+			So(err, ShouldBeNil)
+			So(allocs[0].Service.ID(), ShouldEqual, "S001")
+			// The active route leads the train to track 1 instead of 2
+			So(allocs[0].ActualTrack, ShouldEqual, "1")
+			So(allocs[0].AssignedTrack, ShouldEqual, "2")
+			So(allocs[0].TrackCode(), ShouldEqual, "1")
+			conflicts, err := sim.PlatformConflicts("STN")
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldHaveLength, 1)
+			So(conflicts[0].Second.Service.ID(), ShouldEqual, "S003")
+			sim.RecomputeSuggestions()
+			ids := make([]string, 0)
+			for _, s := range sim.Suggestions.Items {
+				if s.Kind == simulation.SuggestionPlatformReassign {
+					ids = append(ids, s.ID)
+				}
+			}
+			So(ids, ShouldResemble, []string{"PLATFORM_REASSIGN:S003:1:2"})
+		})
+		Convey("Double-booked platforms should be detected and reassigned", func() {
+			track := "2"
+			So(sim.UpdateServiceLine("S003", 1, simulation.ServiceLineChange{TrackCode: &track}), ShouldBeNil)
+			conflicts, err := sim.PlatformConflicts("STN")
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldHaveLength, 1)
+			So(conflicts[0].TrackCode, ShouldEqual, "2")
+			So(conflicts[0].First.Service.ID(), ShouldEqual, "S001")
+			So(conflicts[0].Second.Service.ID(), ShouldEqual, "S003")
+			sim.RecomputeSuggestions()
+			var reassign *simulation.Suggestion
+			for i, s := range sim.Suggestions.Items {
+				if s.Kind == simulation.SuggestionPlatformReassign {
+					reassign = &sim.Suggestions.Items[i]
+				}
+			}
+			So(reassign, ShouldNotBeNil)
+			So(reassign.ID, ShouldEqual, "PLATFORM_REASSIGN:S003:1:1")
+			So(reassign.Actions[0].Object, ShouldEqual, "service")
+			So(reassign.Actions[0].Action, ShouldEqual, "assignPlatform")
+			So(sim.AcceptSuggestion(reassign.ID), ShouldBeNil)
+			line := sim.Services["S003"].Lines[1]
+			So(line.TrackCode, ShouldEqual, "1")
+			So(line.PlannedTrackCode(), ShouldEqual, "2")
+			conflicts, _ = sim.PlatformConflicts("")
+			So(conflicts, ShouldBeEmpty)
+			allocs, _ := sim.PlatformPlan("STN")
+			So(allocs[1].Reassigned(), ShouldBeTrue)
+			data, err := json.Marshal(line)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"originalTrackCode":"2"`)
+			Convey("Going back to the planned platform clears the reassignment", func() {
+				So(sim.AssignPlatform("S003", 1, "2"), ShouldBeNil)
+				So(line.TrackCode, ShouldEqual, "2")
+				So(line.OriginalTrackCode, ShouldBeEmpty)
+			})
+		})
+		Convey("Invalid assignments should fail", func() {
+			So(sim.AssignPlatform("S003", 1, "9"), ShouldNotBeNil)
+			So(sim.AssignPlatform("S003", 5, "1"), ShouldNotBeNil)
+			So(sim.AssignPlatform("XXX", 1, "1"), ShouldNotBeNil)
+			So(sim.Services["S003"].Lines[1].TrackCode, ShouldEqual, "1")
+		})
+	})
+}
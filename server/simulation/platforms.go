@@ -0,0 +1,99 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PlatformLength returns the usable length in meters of the platform of the
+// track with the given code at this place, or 0 if it is not known.
+func (pl *Place) PlatformLength(trackCode string) float64 {
+	return pl.PlatformLengths[trackCode]
+}
+
+// TrackCodes returns the sorted codes of the tracks of this place.
+func (pl *Place) TrackCodes() []string {
+	codes := make(map[string]bool)
+	for _, ti := range pl.simulation.TrackItems {
+		if ti.Type() == TypePlatform || ti.Place() == nil || ti.Place().PlaceCode != pl.PlaceCode || ti.TrackCode() == "" {
+			continue
+		}
+		codes[ti.TrackCode()] = true
+	}
+	res := make([]string, 0, len(codes))
+	for c := range codes {
+		res = append(res, c)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// fitsPlatform returns an error if this train is longer than the platform of
+// the track with the given code at the given place.
+func (t *Train) fitsPlatform(pl *Place, trackCode string) error {
+	length := pl.PlatformLength(trackCode)
+	if length > 0 && t.TrainType().Length > length {
+		return fmt.Errorf("train %s (%.0fm) is longer than platform %s at %s (%.0fm)",
+			t.ServiceCode, t.TrainType().Length, trackCode, pl.PlaceCode, length)
+	}
+	return nil
+}
+
+// nextStopLine returns the next service line at which this train must stop,
+// or nil if there is none.
+func (t *Train) nextStopLine() *ServiceLine {
+	if t.Service() == nil || t.NextPlaceIndex == NoMorePlace {
+		return nil
+	}
+	// If currently stopped at a stop place, look ahead from the next index
+	start := t.NextPlaceIndex
+	if t.Status == Stopped {
+		start = t.NextPlaceIndex + 1
+	}
+	for i := start; i < len(t.Service().Lines); i++ {
+		if sl := t.Service().Lines[i]; sl.MustStop {
+			return sl
+		}
+	}
+	return nil
+}
+
+// checkPlatformLengths returns an error if route r leads train t to a
+// platform of its next stop which is too short for it.
+func (r *Route) checkPlatformLengths(t *Train) error {
+	if t == nil {
+		return nil
+	}
+	sl := t.nextStopLine()
+	if sl == nil {
+		return nil
+	}
+	for _, pos := range r.Positions {
+		pl := pos.TrackItem().Place()
+		if pl == nil || pl.PlaceCode != sl.PlaceCode {
+			continue
+		}
+		if err := t.fitsPlatform(pl, pos.TrackItem().TrackCode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
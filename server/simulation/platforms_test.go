@@ -0,0 +1,69 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPlatformLengths(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing platform length constraints", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		stn := sim.Places["STN"]
+		So(stn.TrackCodes(), ShouldResemble, []string{"1", "2"})
+		So(stn.PlatformLength("1"), ShouldEqual, 0)
+		So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+		// Train 1 (140m) must stop on track 1 at STN
+		So(sim.Trains[0].Cancel(), ShouldBeNil)
+		until := simulation.ParseTime("06:03:05")
+		So(sim.FastForward(until.Time), ShouldBeNil)
+		train := sim.Trains[1]
+		So(train.IsActive(), ShouldBeTrue)
+		stn.PlatformLengths = map[string]float64{"1": 100}
+		Convey("Routes to a too short platform should be rejected", func() {
+			So(sim.Routes["1"].Activate(false), ShouldNotBeNil)
+			So(sim.Routes["1"].IsActive(), ShouldBeFalse)
+			So(sim.Routes["2"].Activate(false), ShouldBeNil)
+			So(sim.Routes["2"].Deactivate(), ShouldBeNil)
+			stn.PlatformLengths["1"] = 150
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+		})
+		Convey("Suggestions should divert the train to a platform long enough", func() {
+			sim.RecomputeSuggestions()
+			var diversion *simulation.Suggestion
+			for i, s := range sim.Suggestions.Items {
+				So(s.ID, ShouldNotContainSubstring, ":1:1")
+				if s.Kind == simulation.SuggestionRouteDiversion {
+					diversion = &sim.Suggestions.Items[i]
+				}
+			}
+			So(diversion, ShouldNotBeNil)
+			So(diversion.Actions, ShouldHaveLength, 1)
+			So(diversion.Actions[0].Params["id"], ShouldEqual, "2")
+			Convey("Unless no platform is long enough", func() {
+				stn.PlatformLengths["2"] = 100
+				sim.RecomputeSuggestions()
+				for _, s := range sim.Suggestions.Items {
+					So(s.Kind, ShouldNotEqual, simulation.SuggestionRouteDiversion)
+				}
+			})
+		})
+		Convey("Platform lengths should be serialized with the place", func() {
+			data, err := json.Marshal(stn)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"platformLengths":{"1":100}`)
+		})
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"time"
+)
+
+// A PointsFailureMode describes how failed points misbehave.
+type PointsFailureMode string
+
+const (
+	// PointsStuck are points that cannot be moved from their current
+	// direction. Routes requiring the other direction cannot be set.
+	PointsStuck PointsFailureMode = "STUCK"
+	// PointsOutOfCorrespondence are points whose detection does not prove
+	// their position. No route can be set over them.
+	PointsOutOfCorrespondence PointsFailureMode = "OUT_OF_CORRESPONDENCE"
+)
+
+// defaultPointsMTTR is the repair time of failed points when the simulation
+// does not define pointsMTTRMinutes.
+const defaultPointsMTTR = 30 * time.Minute
+
+// ParsePointsFailureMode returns the PointsFailureMode with the given name.
+// An empty name gives PointsStuck.
+func ParsePointsFailureMode(name string) (PointsFailureMode, error) {
+	switch PointsFailureMode(name) {
+	case "", PointsStuck:
+		return PointsStuck, nil
+	case PointsOutOfCorrespondence:
+		return PointsOutOfCorrespondence, nil
+	}
+	return "", fmt.Errorf("unknown points failure mode: %s", name)
+}
+
+// Failed returns true if these points are malfunctioning.
+func (pi *PointsItem) Failed() bool {
+	return pi.failureMode != ""
+}
+
+// FailureMode returns how these points have failed, or an empty string if
+// they are operational.
+func (pi *PointsItem) FailureMode() PointsFailureMode {
+	return pi.failureMode
+}
+
+// FailureCause returns why these points are malfunctioning, if they are.
+func (pi *PointsItem) FailureCause() string {
+	return pi.failureCause
+}
+
+// RepairTime returns the simulation time at which these points will be
+// repaired. It is zero if the points are not malfunctioning or if they will
+// only be repaired on request.
+func (pi *PointsItem) RepairTime() time.Time {
+	return pi.repairAt
+}
+
+// Fail makes these points malfunction with the given mode. The points are
+// repaired automatically after repairIn, or only when Repair is called if
+// repairIn is 0.
+func (pi *PointsItem) Fail(mode PointsFailureMode, cause string, repairIn time.Duration) {
+	pi.failureMode = mode
+	pi.failureCause = cause
+	pi.repairAt = time.Time{}
+	if repairIn > 0 {
+		pi.repairAt = pi.simulation.Options.CurrentTime.Time.Add(repairIn)
+	}
+	pi.simulation.MessageLogger.addMessage(fmt.Sprintf("Points %s have failed (%s)", pi.Name(), mode), simulationMsg)
+	pi.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: pi})
+	pi.simulation.sendEvent(&Event{Name: PointsFailedEvent, Object: pi})
+}
+
+// Repair puts these points back in service after a malfunction.
+func (pi *PointsItem) Repair() {
+	if pi.failureMode == "" {
+		return
+	}
+	pi.failureMode = ""
+	pi.failureCause = ""
+	pi.repairAt = time.Time{}
+	pi.simulation.MessageLogger.addMessage(fmt.Sprintf("Points %s have been repaired", pi.Name()), simulationMsg)
+	pi.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: pi})
+	pi.simulation.sendEvent(&Event{Name: PointsRepairedEvent, Object: pi})
+}
+
+// failedPoints returns the failed points that prevent this route from being
+// set, either because they are out of correspondence or because they are
+// stuck in the other direction.
+func (r *Route) failedPoints() []*PointsItem {
+	var res []*PointsItem
+	for _, pos := range r.Positions {
+		pi, ok := pos.TrackItem().(*PointsItem)
+		if !ok {
+			continue
+		}
+		switch pi.failureMode {
+		case PointsOutOfCorrespondence:
+			res = append(res, pi)
+		case PointsStuck:
+			if pi.Reversed() != (r.Directions[pi.ID()] == DirectionReversed) {
+				res = append(res, pi)
+			}
+		}
+	}
+	return res
+}
+
+// PointsMTTR returns the mean time to repair failed points.
+func (sim *Simulation) PointsMTTR() time.Duration {
+	if sim.Options.PointsMTTRMinutes <= 0 {
+		return defaultPointsMTTR
+	}
+	return time.Duration(sim.Options.PointsMTTRMinutes) * time.Minute
+}
+
+// updatePointsFailures repairs the points whose repair time has come and
+// makes operational points fail at random according to the
+// pointsFailureRate option. step is the simulation time elapsed since the
+// last update.
+func (sim *Simulation) updatePointsFailures(step time.Duration) {
+	now := sim.Options.CurrentTime.Time
+	// Probability that given points fail during this step
+	proba := sim.Options.PointsFailureRate * step.Hours()
+	r := sim.random()
+	for _, ti := range sim.sortedTrackItems() {
+		pi, ok := ti.(*PointsItem)
+		if !ok {
+			continue
+		}
+		switch {
+		case pi.failureMode != "":
+			if !pi.repairAt.IsZero() && !now.Before(pi.repairAt) {
+				pi.Repair()
+			}
+		case proba > 0 && r.Float64() < proba:
+			mode := PointsStuck
+			if r.Intn(2) == 0 {
+				mode = PointsOutOfCorrespondence
+			}
+			repairIn := time.Duration(r.ExpFloat64() * float64(sim.PointsMTTR()))
+			if repairIn < time.Second {
+				repairIn = time.Second
+			}
+			pi.Fail(mode, "Random failure", repairIn)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPointsFailures(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing points malfunctions", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+		pi := sim.TrackItems["7"].(*simulation.PointsItem)
+		So(pi.Reversed(), ShouldBeFalse)
+		Convey("Failure modes should be parsed", func() {
+			mode, err := simulation.ParsePointsFailureMode("")
+			So(err, ShouldBeNil)
+			So(mode, ShouldEqual, simulation.PointsStuck)
+			mode, err = simulation.ParsePointsFailureMode("OUT_OF_CORRESPONDENCE")
+			So(err, ShouldBeNil)
+			So(mode, ShouldEqual, simulation.PointsOutOfCorrespondence)
+			_, err = simulation.ParsePointsFailureMode("BROKEN")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Stuck points should only allow routes in their current direction", func() {
+			pi.Fail(simulation.PointsStuck, "Frozen", 0)
+			So(pi.Failed(), ShouldBeTrue)
+			So(pi.FailureCause(), ShouldEqual, "Frozen")
+			So(pi.RepairTime().IsZero(), ShouldBeTrue)
+			So(sim.Routes["2"].Activate(false), ShouldNotBeNil)
+			So(pi.Reversed(), ShouldBeFalse)
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			pi.Repair()
+			So(pi.Failed(), ShouldBeFalse)
+			So(sim.Routes["2"].Activate(false), ShouldBeNil)
+			So(pi.Reversed(), ShouldBeTrue)
+		})
+		Convey("Points out of correspondence should refuse all routes", func() {
+			pi.Fail(simulation.PointsOutOfCorrespondence, "Detection lost", 0)
+			So(sim.Routes["1"].Activate(false), ShouldNotBeNil)
+			So(sim.Routes["2"].Activate(false), ShouldNotBeNil)
+			pi.Repair()
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+		})
+		Convey("Failed points should be repaired after their repair time", func() {
+			pi.Fail(simulation.PointsOutOfCorrespondence, "Detection lost", 2*time.Second)
+			So(pi.RepairTime(), ShouldResemble, sim.Options.CurrentTime.Time.Add(2*time.Second))
+			for i := 0; i < 10 && pi.Failed(); i++ {
+				sim.Step()
+			}
+			So(pi.Failed(), ShouldBeFalse)
+		})
+		Convey("Points should fail at random with a failure rate", func() {
+			sim.Options.PointsFailureRate = 3600 * 10
+			sim.Options.PointsMTTRMinutes = 45
+			So(sim.PointsMTTR(), ShouldEqual, 45*time.Minute)
+			sim.Step()
+			So(pi.Failed(), ShouldBeTrue)
+			So(pi.FailureCause(), ShouldEqual, "Random failure")
+			So(pi.RepairTime().IsZero(), ShouldBeFalse)
+		})
+		Convey("Suggestions should divert trains around failed points", func() {
+			pi.Fail(simulation.PointsStuck, "Frozen", 0)
+			var route string
+			found := stepUntil(&sim, 600, func() bool {
+				sim.RecomputeSuggestions()
+				for _, s := range sim.Suggestions.Items {
+					if strings.Contains(s.Reason, "Diverts around failed points 7") {
+						route = s.Actions[0].Params["id"].(string)
+						return true
+					}
+				}
+				return false
+			})
+			So(found, ShouldBeTrue)
+			So(route, ShouldEqual, "1")
+		})
+	})
+}
@@ -0,0 +1,261 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// A Possession takes the track items between TrackItemID and ToTrackItemID
+// out of service between StartTime and EndTime, typically for engineering
+// works. Routes cannot be set through the items of an active possession and
+// trains cannot enter them.
+//
+// A possession is only taken once no train is on its items: if trains are
+// still there at StartTime, it starts as soon as they have left.
+//
+// A zero StartTime means that the possession starts immediately and a zero
+// EndTime that it lasts until it is given back. An empty ToTrackItemID takes
+// TrackItemID only.
+type Possession struct {
+	TrackItemID   string `json:"trackItemId"`
+	ToTrackItemID string `json:"toTrackItemId"`
+	StartTime     Time   `json:"startTime"`
+	EndTime       Time   `json:"endTime"`
+	Reason        string `json:"reason"`
+
+	possessionID string
+	items        []string
+	active       bool
+	pending      bool
+	simulation   *Simulation
+}
+
+// ID returns the unique identifier of this possession
+func (p *Possession) ID() string {
+	return p.possessionID
+}
+
+// Items returns the IDs of the track items taken by this possession
+func (p *Possession) Items() []string {
+	return p.items
+}
+
+// IsActive returns true if the items of this possession are currently out of
+// service.
+func (p *Possession) IsActive() bool {
+	return p.active
+}
+
+// Status returns PLANNED, PENDING, ACTIVE or ENDED. A possession is PENDING
+// when it is due but waits for trains to leave its items.
+func (p *Possession) Status() string {
+	if p.pending {
+		return "PENDING"
+	}
+	return scheduleStatus(p.active, p.EndTime.Time, p.simulation.Options.CurrentTime.Time)
+}
+
+// MarshalJSON method for Possession
+func (p *Possession) MarshalJSON() ([]byte, error) {
+	type auxPossession struct {
+		ID            string   `json:"id"`
+		TrackItemID   string   `json:"trackItemId"`
+		ToTrackItemID string   `json:"toTrackItemId,omitempty"`
+		StartTime     string   `json:"startTime"`
+		EndTime       string   `json:"endTime"`
+		Reason        string   `json:"reason,omitempty"`
+		Items         []string `json:"items"`
+		Status        string   `json:"status"`
+	}
+	return json.Marshal(auxPossession{
+		ID:            p.possessionID,
+		TrackItemID:   p.TrackItemID,
+		ToTrackItemID: p.ToTrackItemID,
+		StartTime:     formatScheduleTime(p.simulation, p.StartTime.Time),
+		EndTime:       formatScheduleTime(p.simulation, p.EndTime.Time),
+		Reason:        p.Reason,
+		Items:         p.items,
+		Status:        p.Status(),
+	})
+}
+
+// resolveItems checks this possession and computes the track items it takes.
+func (p *Possession) resolveItems() error {
+	ti, ok := p.simulation.TrackItems[p.TrackItemID]
+	if !ok {
+		return fmt.Errorf("unknown track item: %s", p.TrackItemID)
+	}
+	if !p.StartTime.IsZero() && !p.EndTime.IsZero() && !p.StartTime.Time.Before(p.EndTime.Time) {
+		return fmt.Errorf("end time must be after start time")
+	}
+	if p.ToTrackItemID == "" {
+		p.items = []string{ti.ID()}
+		return nil
+	}
+	to, ok := p.simulation.TrackItems[p.ToTrackItemID]
+	if !ok {
+		return fmt.Errorf("unknown track item: %s", p.ToTrackItemID)
+	}
+	items, err := itemsBetween(ti, to)
+	if err != nil {
+		return err
+	}
+	p.items = items
+	return nil
+}
+
+// trainPresent returns true if a train is on one of the items of this
+// possession, or may be because the train detection of an item has failed.
+func (p *Possession) trainPresent() bool {
+	for _, id := range p.items {
+		if p.simulation.TrackItems[id].Occupied() {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPossession checks the given possession and adds it to the simulation.
+// The possession is taken immediately if it is already scheduled and its
+// items are clear.
+func (sim *Simulation) AddPossession(p *Possession) error {
+	p.simulation = sim
+	if err := p.resolveItems(); err != nil {
+		return err
+	}
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	if sim.possessions == nil {
+		sim.possessions = make(map[string]*Possession)
+	}
+	sim.lastPossessionID++
+	p.possessionID = strconv.Itoa(sim.lastPossessionID)
+	sim.possessions[p.possessionID] = p
+	sim.sendEvent(&Event{Name: PossessionChangedEvent, Object: p})
+	sim.updatePossessionsLocked()
+	return nil
+}
+
+// RemovePossession gives back the track items of the possession with the
+// given ID and removes it from the simulation.
+func (sim *Simulation) RemovePossession(id string) error {
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	p, ok := sim.possessions[id]
+	if !ok {
+		return fmt.Errorf("unknown possession: %s", id)
+	}
+	delete(sim.possessions, id)
+	p.pending = false
+	if p.active {
+		p.active = false
+		for _, iid := range p.items {
+			sim.applyBlocked(iid)
+		}
+		sim.MessageLogger.addMessage(fmt.Sprintf("Possession %s given back on %s", p.possessionID, p.TrackItemID), simulationMsg)
+		sim.disruptionsChanged()
+	}
+	sim.sendEvent(&Event{Name: PossessionChangedEvent, Object: p})
+	return nil
+}
+
+// Possessions returns all the possessions of the simulation ordered by ID.
+func (sim *Simulation) Possessions() []*Possession {
+	sim.disruptionsMutex.RLock()
+	defer sim.disruptionsMutex.RUnlock()
+	res := make([]*Possession, 0, len(sim.possessions))
+	for _, p := range sim.possessions {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, _ := strconv.Atoi(res[i].possessionID)
+		b, _ := strconv.Atoi(res[j].possessionID)
+		return a < b
+	})
+	return res
+}
+
+// GetPossession returns the possession with the given ID
+func (sim *Simulation) GetPossession(id string) (*Possession, bool) {
+	sim.disruptionsMutex.RLock()
+	defer sim.disruptionsMutex.RUnlock()
+	p, ok := sim.possessions[id]
+	return p, ok
+}
+
+// updatePossessionsLocked takes and gives back possessions according to their
+// schedule. disruptionsMutex must be held.
+func (sim *Simulation) updatePossessionsLocked() {
+	changed := false
+	for _, p := range sim.possessions {
+		due := isScheduledAt(p.StartTime.Time, p.EndTime.Time, sim.Options.CurrentTime.Time)
+		if due && !p.active && p.trainPresent() {
+			if !p.pending {
+				p.pending = true
+				sim.MessageLogger.addMessage(fmt.Sprintf("Possession %s on %s waits for trains to clear", p.possessionID, p.TrackItemID), simulationMsg)
+				sim.sendEvent(&Event{Name: PossessionChangedEvent, Object: p})
+			}
+			continue
+		}
+		if due == p.active {
+			continue
+		}
+		p.active = due
+		p.pending = false
+		for _, iid := range p.items {
+			sim.applyBlocked(iid)
+		}
+		if due {
+			sim.MessageLogger.addMessage(fmt.Sprintf("Possession %s taken on %s", p.possessionID, p.TrackItemID), simulationMsg)
+		} else {
+			sim.MessageLogger.addMessage(fmt.Sprintf("Possession %s ended on %s", p.possessionID, p.TrackItemID), simulationMsg)
+		}
+		sim.sendEvent(&Event{Name: PossessionChangedEvent, Object: p})
+		changed = true
+	}
+	if changed {
+		sim.disruptionsChanged()
+	}
+}
+
+// applyBlocked closes the track item with the given ID to traffic if an active
+// possession or track blocked disruption covers it, and opens it otherwise.
+// disruptionsMutex must be held.
+func (sim *Simulation) applyBlocked(id string) {
+	blocked := len(sim.activeDisruptionsOn(id, DisruptionTrackBlocked)) > 0
+	for _, p := range sim.possessions {
+		if blocked {
+			break
+		}
+		if !p.active {
+			continue
+		}
+		for _, iid := range p.items {
+			if iid == id {
+				blocked = true
+				break
+			}
+		}
+	}
+	sim.TrackItems[id].SetBlocked(blocked)
+}
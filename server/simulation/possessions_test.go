@@ -0,0 +1,87 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPossessions(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing track possessions", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+		Convey("Possessions should take their items out of service", func() {
+			p := &simulation.Possession{TrackItemID: "14", ToTrackItemID: "16", Reason: "Track renewal"}
+			So(sim.AddPossession(p), ShouldBeNil)
+			So(p.ID(), ShouldNotBeEmpty)
+			So(p.Status(), ShouldEqual, "ACTIVE")
+			So(p.Items(), ShouldResemble, []string{"14", "15", "16"})
+			So(sim.TrackItems["14"].Blocked(), ShouldBeTrue)
+			So(sim.TrackItems["14"].MaxSpeed(), ShouldEqual, 0)
+			So(sim.Routes["2"].Activate(false), ShouldNotBeNil)
+			So(sim.Possessions(), ShouldHaveLength, 1)
+			So(sim.RemovePossession(p.ID()), ShouldBeNil)
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+			So(sim.Possessions(), ShouldBeEmpty)
+			So(sim.Routes["2"].Activate(false), ShouldBeNil)
+			So(sim.RemovePossession(p.ID()), ShouldNotBeNil)
+		})
+		Convey("Invalid possessions should be refused", func() {
+			So(sim.AddPossession(&simulation.Possession{TrackItemID: "UNKNOWN"}), ShouldNotBeNil)
+			So(sim.AddPossession(&simulation.Possession{TrackItemID: "14", ToTrackItemID: "UNKNOWN"}), ShouldNotBeNil)
+		})
+		Convey("Items should stay blocked while a disruption or a possession covers them", func() {
+			p := &simulation.Possession{TrackItemID: "14"}
+			So(sim.AddPossession(p), ShouldBeNil)
+			d := &simulation.Disruption{Type: simulation.DisruptionTrackBlocked, TrackItemID: "14"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sim.RemoveDisruption(d.ID()), ShouldBeNil)
+			So(sim.TrackItems["14"].Blocked(), ShouldBeTrue)
+			So(sim.RemovePossession(p.ID()), ShouldBeNil)
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+		})
+		Convey("Possessions should follow their schedule", func() {
+			p := &simulation.Possession{TrackItemID: "14"}
+			p.StartTime.Time = sim.Options.CurrentTime.Time.Add(time.Second)
+			p.EndTime.Time = sim.Options.CurrentTime.Time.Add(3 * time.Second)
+			So(sim.AddPossession(p), ShouldBeNil)
+			So(p.Status(), ShouldEqual, "PLANNED")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+			sim.Step()
+			So(p.Status(), ShouldEqual, "ACTIVE")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeTrue)
+			sim.Step()
+			So(p.Status(), ShouldEqual, "ENDED")
+			So(sim.TrackItems["14"].Blocked(), ShouldBeFalse)
+		})
+		Convey("Possessions should wait for trains to clear their items", func() {
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			train := sim.Trains[0]
+			So(stepUntil(&sim, 600, func() bool {
+				return train.IsActive() && train.TrainHead.TrackItemID == "4"
+			}), ShouldBeTrue)
+			p := &simulation.Possession{TrackItemID: "4"}
+			So(sim.AddPossession(p), ShouldBeNil)
+			So(p.Status(), ShouldEqual, "PENDING")
+			So(sim.TrackItems["4"].Blocked(), ShouldBeFalse)
+			So(stepUntil(&sim, 600, func() bool { return p.IsActive() }), ShouldBeTrue)
+			So(sim.TrackItems["4"].TrainPresent(), ShouldBeFalse)
+			So(sim.TrackItems["4"].Blocked(), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrainPriority is the priority class of a service or a train. It decides
+// which train goes first when several trains compete for the same tracks, and
+// how much the punctuality of the train weighs in the KPIs.
+type TrainPriority string
+
+const (
+	// PriorityExpress trains have precedence over all other trains
+	PriorityExpress TrainPriority = "express"
+
+	// PriorityRegional trains have precedence over freight trains
+	PriorityRegional TrainPriority = "regional"
+
+	// PriorityFreight trains give way to all other trains
+	PriorityFreight TrainPriority = "freight"
+)
+
+// DefaultPriority is the priority of the trains for which neither the train
+// nor its service define one.
+const DefaultPriority = PriorityRegional
+
+// ParseTrainPriority returns the TrainPriority with the given name, case
+// insensitive. An empty name returns an empty TrainPriority, which means
+// that no priority is defined.
+func ParseTrainPriority(name string) (TrainPriority, error) {
+	p := TrainPriority(strings.ToLower(strings.TrimSpace(name)))
+	switch p {
+	case "", PriorityExpress, PriorityRegional, PriorityFreight:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown priority %q, expected express, regional or freight", name)
+}
+
+// UnmarshalJSON for the TrainPriority type
+func (p *TrainPriority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	res, err := ParseTrainPriority(name)
+	if err != nil {
+		return err
+	}
+	*p = res
+	return nil
+}
+
+// Rank returns the rank of this priority: the higher the rank, the higher the
+// precedence. An undefined priority has the rank of DefaultPriority.
+func (p TrainPriority) Rank() int {
+	switch p {
+	case PriorityExpress:
+		return 3
+	case PriorityFreight:
+		return 1
+	case PriorityRegional:
+		return 2
+	default:
+		return DefaultPriority.Rank()
+	}
+}
+
+// Weight returns the weight of the trains of this priority in the weighted
+// punctuality KPIs.
+func (p TrainPriority) Weight() float64 {
+	switch p.Rank() {
+	case 3:
+		return 2
+	case 1:
+		return 0.5
+	default:
+		return 1
+	}
+}
+
+// Priority returns the priority class of this train, that is its own
+// priority if it is set, or the priority of its service otherwise.
+func (t *Train) Priority() TrainPriority {
+	if t.PriorityClass != "" {
+		return t.PriorityClass
+	}
+	if s := t.Service(); s != nil && s.Priority != "" {
+		return s.Priority
+	}
+	return DefaultPriority
+}
+
+// HasPrecedence returns true if train a must go before train b when both
+// compete for the same tracks: a has a higher priority than b or, for equal
+// priorities, a is later than b.
+func HasPrecedence(a, b *Train) bool {
+	if a.Priority().Rank() != b.Priority().Rank() {
+		return a.Priority().Rank() > b.Priority().Rank()
+	}
+	return a.currentDelay() > b.currentDelay()
+}
+
+// SortByPrecedence sorts the given trains in place so that each train has
+// precedence over the trains after it.
+func SortByPrecedence(trains []*Train) {
+	sort.SliceStable(trains, func(i, j int) bool {
+		return HasPrecedence(trains[i], trains[j])
+	})
+}
+
+// currentDelay returns how late this train is on the current line of its
+// service, or 0 if it is on time or has no service.
+func (t *Train) currentDelay() time.Duration {
+	if t.Service() == nil || t.NextPlaceIndex == NoMorePlace || t.NextPlaceIndex >= len(t.Service().Lines) {
+		return 0
+	}
+	line := t.Service().Lines[t.NextPlaceIndex]
+	scheduled := line.ScheduledArrivalTime.Time
+	if t.Status == Stopped || line.ScheduledArrivalTime.IsZero() {
+		scheduled = line.ScheduledDepartureTime.Time
+	}
+	if scheduled.IsZero() {
+		return 0
+	}
+	if d := t.simulation.Options.CurrentTime.Time.Sub(scheduled); d > 0 {
+		return d
+	}
+	return 0
+}
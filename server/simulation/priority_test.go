@@ -0,0 +1,69 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestPriority(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing train priorities", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		Convey("Priorities should be parsed and validated", func() {
+			p, err := simulation.ParseTrainPriority("Express")
+			So(err, ShouldBeNil)
+			So(p, ShouldEqual, simulation.PriorityExpress)
+			_, err = simulation.ParseTrainPriority("urgent")
+			So(err, ShouldNotBeNil)
+			var s simulation.Service
+			So(json.Unmarshal([]byte(`{"serviceCode": "X", "priority": "freight"}`), &s), ShouldBeNil)
+			So(s.Priority, ShouldEqual, simulation.PriorityFreight)
+			So(json.Unmarshal([]byte(`{"serviceCode": "X", "priority": "urgent"}`), &s), ShouldNotBeNil)
+			So(simulation.PriorityExpress.Rank(), ShouldBeGreaterThan, simulation.PriorityRegional.Rank())
+			So(simulation.PriorityRegional.Rank(), ShouldBeGreaterThan, simulation.PriorityFreight.Rank())
+			So(simulation.TrainPriority("").Weight(), ShouldEqual, 1)
+		})
+		Convey("Trains should inherit the priority of their service", func() {
+			t0, t1 := sim.Trains[0], sim.Trains[1]
+			So(t0.Priority(), ShouldEqual, simulation.DefaultPriority)
+			sim.Services["S001"].Priority = simulation.PriorityFreight
+			So(t0.Priority(), ShouldEqual, simulation.PriorityFreight)
+			t0.PriorityClass = simulation.PriorityExpress
+			So(t0.Priority(), ShouldEqual, simulation.PriorityExpress)
+			So(simulation.HasPrecedence(t0, t1), ShouldBeTrue)
+			So(simulation.HasPrecedence(t1, t0), ShouldBeFalse)
+			trains := []*simulation.Train{t1, t0}
+			simulation.SortByPrecedence(trains)
+			So(trains[0], ShouldEqual, t0)
+		})
+		Convey("Suggestions should favour trains of higher priority", func() {
+			sim.Services["S001"].Priority = simulation.PriorityExpress
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			found := stepUntil(&sim, 600, func() bool {
+				sim.RecomputeSuggestions()
+				for _, s := range sim.Suggestions.Items {
+					if strings.Contains(s.Reason, "express priority") {
+						return true
+					}
+				}
+				return false
+			})
+			So(found, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,595 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package simulation
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// railMLNamespace and railMLVersion are the railML schema written on export
+const (
+	railMLNamespace = "https://www.railml.org/schemas/2018"
+	railMLVersion   = "2.4"
+)
+
+// Prefixes of the railML IDs of the simulation objects
+const (
+	railMLTrackPrefix     = "tr_"
+	railMLOCPPrefix       = "ocp_"
+	railMLFormationPrefix = "fo_"
+	railMLTrainPartPrefix = "tp_"
+	railMLTrainPrefix     = "trn_"
+)
+
+// railML is the root element of a railML 2 document. Only the elements used
+// by the simulation are read and written.
+type railML struct {
+	XMLName        xml.Name              `xml:"railml"`
+	Xmlns          string                `xml:"xmlns,attr,omitempty"`
+	Version        string                `xml:"version,attr,omitempty"`
+	Infrastructure *railMLInfrastructure `xml:"infrastructure"`
+	Rollingstock   *railMLRollingstock   `xml:"rollingstock"`
+	Timetable      *railMLTimetable      `xml:"timetable"`
+}
+
+type railMLInfrastructure struct {
+	ID     string        `xml:"id,attr"`
+	Tracks []railMLTrack `xml:"tracks>track"`
+	OCPs   []railMLOCP   `xml:"operationControlPoints>ocp"`
+}
+
+type railMLOCP struct {
+	ID   string `xml:"id,attr"`
+	Code string `xml:"code,attr,omitempty"`
+	Name string `xml:"name,attr,omitempty"`
+}
+
+type railMLTrack struct {
+	ID            string               `xml:"id,attr"`
+	Name          string               `xml:"name,attr,omitempty"`
+	TrackTopology railMLTrackTopology  `xml:"trackTopology"`
+	TrackElements *railMLTrackElements `xml:"trackElements"`
+	OCSElements   *railMLOCSElements   `xml:"ocsElements"`
+}
+
+type railMLTrackTopology struct {
+	Begin       railMLTrackNode    `xml:"trackBegin"`
+	End         railMLTrackNode    `xml:"trackEnd"`
+	Connections *railMLConnections `xml:"connections"`
+}
+
+type railMLConnections struct {
+	Switches []railMLSwitch `xml:"switch"`
+}
+
+type railMLTrackElements struct {
+	SpeedChanges  []railMLSpeedChange  `xml:"speedChanges>speedChange"`
+	PlatformEdges []railMLPlatformEdge `xml:"platformEdges>platformEdge"`
+}
+
+type railMLOCSElements struct {
+	Signals        []railMLSignal        `xml:"signals>signal"`
+	LevelCrossings []railMLLevelCrossing `xml:"levelCrossings>levelCrossing"`
+}
+
+type railMLTrackNode struct {
+	ID         string            `xml:"id,attr"`
+	Pos        float64           `xml:"pos,attr"`
+	Connection *railMLConnection `xml:"connection"`
+	OpenEnd    *railMLOCP        `xml:"openEnd"`
+	BufferStop *railMLOCP        `xml:"bufferStop"`
+}
+
+type railMLConnection struct {
+	ID          string `xml:"id,attr"`
+	Ref         string `xml:"ref,attr"`
+	Course      string `xml:"course,attr,omitempty"`
+	Orientation string `xml:"orientation,attr,omitempty"`
+}
+
+type railMLSwitch struct {
+	ID          string             `xml:"id,attr"`
+	Name        string             `xml:"name,attr,omitempty"`
+	Pos         float64            `xml:"pos,attr"`
+	Connections []railMLConnection `xml:"connection"`
+}
+
+type railMLSpeedChange struct {
+	ID   string  `xml:"id,attr"`
+	Pos  float64 `xml:"pos,attr"`
+	Dir  string  `xml:"dir,attr"`
+	VMax string  `xml:"vMax,attr"`
+}
+
+type railMLPlatformEdge struct {
+	ID     string  `xml:"id,attr"`
+	Name   string  `xml:"name,attr,omitempty"`
+	OCPRef string  `xml:"ocpRef,attr,omitempty"`
+	Pos    float64 `xml:"pos,attr"`
+	Length float64 `xml:"length,attr"`
+}
+
+type railMLSignal struct {
+	ID   string  `xml:"id,attr"`
+	Name string  `xml:"name,attr,omitempty"`
+	Pos  float64 `xml:"pos,attr"`
+	Dir  string  `xml:"dir,attr"`
+	Type string  `xml:"type,attr"`
+}
+
+type railMLLevelCrossing struct {
+	ID  string  `xml:"id,attr"`
+	Pos float64 `xml:"pos,attr"`
+}
+
+type railMLRollingstock struct {
+	ID         string            `xml:"id,attr"`
+	Formations []railMLFormation `xml:"formations>formation"`
+}
+
+type railMLFormation struct {
+	ID     string  `xml:"id,attr"`
+	Name   string  `xml:"name,attr,omitempty"`
+	Length float64 `xml:"length,attr,omitempty"`
+	Speed  float64 `xml:"speed,attr,omitempty"`
+}
+
+type railMLTimetable struct {
+	ID         string            `xml:"id,attr"`
+	TrainParts []railMLTrainPart `xml:"trainParts>trainPart"`
+	Trains     []railMLTrain     `xml:"trains>train"`
+}
+
+type railMLTrainPart struct {
+	ID          string             `xml:"id,attr"`
+	FormationTT *railMLFormationTT `xml:"formationTT"`
+	OCPsTT      []railMLOCPTT      `xml:"ocpsTT>ocpTT"`
+}
+
+type railMLFormationTT struct {
+	FormationRef string `xml:"formationRef,attr"`
+}
+
+type railMLOCPTT struct {
+	OCPRef    string        `xml:"ocpRef,attr"`
+	Sequence  int           `xml:"sequence,attr"`
+	OCPType   string        `xml:"ocpType,attr"`
+	TrackInfo string        `xml:"trackInfo,attr,omitempty"`
+	Times     []railMLTimes `xml:"times"`
+}
+
+type railMLTimes struct {
+	Scope        string `xml:"scope,attr"`
+	Arrival      string `xml:"arrival,attr,omitempty"`
+	ArrivalDay   int    `xml:"arrivalDay,attr,omitempty"`
+	Departure    string `xml:"departure,attr,omitempty"`
+	DepartureDay int    `xml:"departureDay,attr,omitempty"`
+}
+
+type railMLTrain struct {
+	ID                 string                    `xml:"id,attr"`
+	Type               string                    `xml:"type,attr,omitempty"`
+	TrainNumber        string                    `xml:"trainNumber,attr,omitempty"`
+	Description        string                    `xml:"description,attr,omitempty"`
+	TrainPartSequences []railMLTrainPartSequence `xml:"trainPartSequence"`
+}
+
+type railMLTrainPartSequence struct {
+	Sequence      int                  `xml:"sequence,attr"`
+	TrainPartRefs []railMLTrainPartRef `xml:"trainPartRef"`
+}
+
+type railMLTrainPartRef struct {
+	Ref string `xml:"ref,attr"`
+}
+
+// railMLExportedItem returns true if ti is exported as a railML track. Ends
+// of the area are exported as open ends of the tracks they are connected to.
+func railMLExportedItem(ti TrackItem) bool {
+	switch ti.Type() {
+	case TypePlace, TypeText, TypeEnd:
+		return false
+	}
+	return true
+}
+
+// railMLConnectionID returns the ID of the connection of the end of the
+// track item with the given ID, which is "b" for its begin, "e" for its end
+// and "r" for the reverse branch of points.
+func railMLConnectionID(id, end string) string {
+	return fmt.Sprintf("c_%s_%s", id, end)
+}
+
+// railMLNode returns the railML node of the end of ti connected to the
+// neighbour track item with the given ID.
+func (sim *Simulation) railMLNode(ti TrackItem, end, neighbourID string, pos float64) railMLTrackNode {
+	node := railMLTrackNode{ID: fmt.Sprintf("n_%s_%s", ti.ID(), end), Pos: pos}
+	nb, ok := sim.TrackItems[neighbourID]
+	switch {
+	case !ok:
+		node.BufferStop = &railMLOCP{ID: fmt.Sprintf("bs_%s_%s", ti.ID(), end)}
+	case nb.Type() == TypeEnd:
+		node.OpenEnd = &railMLOCP{ID: "oe_" + nb.ID(), Name: nb.Name()}
+	default:
+		ref := railMLConnectionID(nb.ID(), "b")
+		switch {
+		case nb.NextItem() != nil && nb.NextItem().ID() == ti.ID():
+			ref = railMLConnectionID(nb.ID(), "e")
+		case nb.Type() == TypePoints && nb.(*PointsItem).ReverseTiId == ti.ID():
+			ref = railMLConnectionID(nb.ID(), "r")
+		}
+		node.Connection = &railMLConnection{ID: railMLConnectionID(ti.ID(), end), Ref: ref}
+	}
+	return node
+}
+
+// railMLTime returns the time and the day offset of h in railML
+func railMLTime(h time.Time) (string, int) {
+	if h.IsZero() {
+		return "", 0
+	}
+	return h.Format("15:04:05"), dayNumber(h)
+}
+
+// ExportRailML returns the infrastructure, the train types and the timetable
+// of the simulation as a railML 2.4 document.
+//
+// Each track item is a railML track between the items it is connected to,
+// with its length and speed limit. Points are switches at the begin of their
+// track, connected to their reverse item. Places are operational control
+// points, and services are trains stopping or passing at them.
+func (sim *Simulation) ExportRailML() ([]byte, error) {
+	doc := railML{
+		Xmlns:          railMLNamespace,
+		Version:        railMLVersion,
+		Infrastructure: &railMLInfrastructure{ID: "inf"},
+		Rollingstock:   &railMLRollingstock{ID: "rs"},
+		Timetable:      &railMLTimetable{ID: "tt"},
+	}
+	ids := make([]string, 0, len(sim.TrackItems))
+	for id := range sim.TrackItems {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ti := sim.TrackItems[id]
+		if !railMLExportedItem(ti) {
+			continue
+		}
+		ts := ti.underlying()
+		track := railMLTrack{
+			ID:   railMLTrackPrefix + id,
+			Name: ti.Name(),
+			TrackTopology: railMLTrackTopology{
+				Begin: sim.railMLNode(ti, "b", ts.PreviousTiID, 0),
+				End:   sim.railMLNode(ti, "e", ts.NextTiID, ts.TsRealLength),
+			},
+		}
+		if ts.TsMaxSpeed != 0 {
+			track.TrackElements = new(railMLTrackElements)
+			track.TrackElements.SpeedChanges = []railMLSpeedChange{{
+				ID:   "sc_" + id,
+				Dir:  "both",
+				VMax: strconv.FormatFloat(ts.TsMaxSpeed*3.6, 'f', -1, 64),
+			}}
+		}
+		switch ti.Type() {
+		case TypePoints:
+			pi := ti.(*PointsItem)
+			sw := railMLSwitch{ID: "sw_" + id, Name: ti.Name()}
+			if rev := pi.ReverseItem(); rev != nil {
+				ref := railMLConnectionID(rev.ID(), "b")
+				if rev.NextItem() != nil && rev.NextItem().ID() == id {
+					ref = railMLConnectionID(rev.ID(), "e")
+				}
+				sw.Connections = []railMLConnection{{ID: railMLConnectionID(id, "r"), Ref: ref, Course: "left", Orientation: "outgoing"}}
+			}
+			track.TrackTopology.Connections = &railMLConnections{Switches: []railMLSwitch{sw}}
+		case TypeSignal:
+			dir := "up"
+			if ti.(*SignalItem).Reverse {
+				dir = "down"
+			}
+			track.OCSElements = &railMLOCSElements{
+				Signals: []railMLSignal{{ID: "sig_" + id, Name: ti.Name(), Dir: dir, Type: "main"}},
+			}
+		case TypeLevelCrossing:
+			track.OCSElements = &railMLOCSElements{
+				LevelCrossings: []railMLLevelCrossing{{ID: "lc_" + id}},
+			}
+		case TypePlatform:
+			if track.TrackElements == nil {
+				track.TrackElements = new(railMLTrackElements)
+			}
+			track.TrackElements.PlatformEdges = []railMLPlatformEdge{{
+				ID:     "pe_" + id,
+				Name:   ti.TrackCode(),
+				OCPRef: railMLOCPPrefix + ts.PlaceCode,
+				Length: ts.TsRealLength,
+			}}
+		}
+		doc.Infrastructure.Tracks = append(doc.Infrastructure.Tracks, track)
+	}
+	placeCodes := make([]string, 0, len(sim.Places))
+	for code := range sim.Places {
+		placeCodes = append(placeCodes, code)
+	}
+	sort.Strings(placeCodes)
+	for _, code := range placeCodes {
+		doc.Infrastructure.OCPs = append(doc.Infrastructure.OCPs, railMLOCP{
+			ID:   railMLOCPPrefix + code,
+			Code: code,
+			Name: sim.Places[code].Name(),
+		})
+	}
+	ttCodes := make([]string, 0, len(sim.TrainTypes))
+	for code := range sim.TrainTypes {
+		ttCodes = append(ttCodes, code)
+	}
+	sort.Strings(ttCodes)
+	for _, code := range ttCodes {
+		tt := sim.TrainTypes[code]
+		doc.Rollingstock.Formations = append(doc.Rollingstock.Formations, railMLFormation{
+			ID:     railMLFormationPrefix + code,
+			Name:   tt.Description,
+			Length: tt.Length,
+			Speed:  tt.MaxSpeed * 3.6,
+		})
+	}
+	sCodes := make([]string, 0, len(sim.Services))
+	for code := range sim.Services {
+		sCodes = append(sCodes, code)
+	}
+	sort.Strings(sCodes)
+	for _, code := range sCodes {
+		s := sim.Services[code]
+		tp := railMLTrainPart{ID: railMLTrainPartPrefix + code}
+		if s.PlannedTrainTypeCode != "" {
+			tp.FormationTT = &railMLFormationTT{FormationRef: railMLFormationPrefix + s.PlannedTrainTypeCode}
+		}
+		for i, line := range s.Lines {
+			ocpType := "pass"
+			if line.MustStop {
+				ocpType = "stop"
+			}
+			times := railMLTimes{Scope: "scheduled"}
+			times.Arrival, times.ArrivalDay = railMLTime(line.ScheduledArrivalTime.Time)
+			times.Departure, times.DepartureDay = railMLTime(line.ScheduledDepartureTime.Time)
+			tp.OCPsTT = append(tp.OCPsTT, railMLOCPTT{
+				OCPRef:    railMLOCPPrefix + line.PlaceCode,
+				Sequence:  i + 1,
+				OCPType:   ocpType,
+				TrackInfo: line.TrackCode,
+				Times:     []railMLTimes{times},
+			})
+		}
+		doc.Timetable.TrainParts = append(doc.Timetable.TrainParts, tp)
+		train := railMLTrain{
+			ID:          railMLTrainPrefix + code,
+			Type:        "operational",
+			TrainNumber: code,
+			Description: s.Description,
+		}
+		train.TrainPartSequences = []railMLTrainPartSequence{{
+			Sequence:      1,
+			TrainPartRefs: []railMLTrainPartRef{{Ref: tp.ID}},
+		}}
+		doc.Timetable.Trains = append(doc.Timetable.Trains, train)
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// A RailMLSkippedTrain is a train of a railML timetable that has not been
+// imported.
+type RailMLSkippedTrain struct {
+	TrainID string `json:"trainId"`
+	Reason  string `json:"reason"`
+}
+
+// A RailMLImportResult reports the changes made to the simulation by a
+// railML import.
+type RailMLImportResult struct {
+	Services          []string             `json:"services"`
+	SkippedTrains     []RailMLSkippedTrain `json:"skippedTrains"`
+	UnmappedOCPs      []string             `json:"unmappedOcps"`
+	UpdatedTrackItems []string             `json:"updatedTrackItems"`
+}
+
+// railMLTimeOf returns the simulation time of the railML time s on the
+// given day offset. Fractions of seconds are ignored.
+func railMLTimeOf(s string, day int) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if i := strings.Index(s, "."); i >= 0 {
+		s = s[:i]
+	}
+	t := ParseTime(s).Time
+	if t.IsZero() {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Add(time.Duration(day) * 24 * time.Hour), nil
+}
+
+// railMLPlaces returns the place codes of the operational control points of
+// the infrastructure of doc by ID. Control points are matched with places by
+// code, then by name.
+func (sim *Simulation) railMLPlaces(doc *railML) map[string]string {
+	byName := make(map[string]string)
+	for code, pl := range sim.Places {
+		byName[strings.ToLower(pl.Name())] = code
+	}
+	places := make(map[string]string)
+	if doc.Infrastructure == nil {
+		return places
+	}
+	for _, ocp := range doc.Infrastructure.OCPs {
+		if _, ok := sim.Places[ocp.Code]; ok {
+			places[ocp.ID] = ocp.Code
+		} else if code, ok := byName[strings.ToLower(ocp.Name)]; ok {
+			places[ocp.ID] = code
+		}
+	}
+	return places
+}
+
+// ImportRailML applies the railML 2 document data to the simulation.
+//
+// The speed limits of the tracks of the infrastructure that have been
+// exported from this simulation are applied to their track items. Other
+// infrastructure elements are used to find the places of the operational
+// control points only, since the layout of the simulation cannot be built
+// from railML.
+//
+// Each train of the timetable is added as a service with the train number as
+// code, unless a service with this code exists already. Its lines are the
+// operational control points of its train parts that are places of the
+// simulation, with their scheduled times.
+func (sim *Simulation) ImportRailML(data []byte) (*RailMLImportResult, error) {
+	var doc railML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid railML document: %s", err)
+	}
+	res := &RailMLImportResult{
+		Services:          []string{},
+		SkippedTrains:     []RailMLSkippedTrain{},
+		UnmappedOCPs:      []string{},
+		UpdatedTrackItems: []string{},
+	}
+	if doc.Infrastructure != nil {
+		for _, track := range doc.Infrastructure.Tracks {
+			ti, ok := sim.TrackItems[strings.TrimPrefix(track.ID, railMLTrackPrefix)]
+			if !ok || !strings.HasPrefix(track.ID, railMLTrackPrefix) || !railMLExportedItem(ti) ||
+				track.TrackElements == nil || len(track.TrackElements.SpeedChanges) == 0 {
+				continue
+			}
+			sc := track.TrackElements.SpeedChanges[0]
+			vMax, err := strconv.ParseFloat(sc.VMax, 64)
+			if err != nil || vMax <= 0 {
+				return nil, fmt.Errorf("invalid vMax %q of track %s", sc.VMax, track.ID)
+			}
+			if ts := ti.underlying(); math.Abs(ts.TsMaxSpeed*3.6-vMax) > 1e-6 {
+				ts.TsMaxSpeed = vMax / 3.6
+				res.UpdatedTrackItems = append(res.UpdatedTrackItems, ti.ID())
+				sim.sendEvent(&Event{Name: TrackItemChangedEvent, Object: ti})
+			}
+		}
+	}
+	if doc.Timetable == nil {
+		return res, nil
+	}
+	places := sim.railMLPlaces(&doc)
+	parts := make(map[string]*railMLTrainPart)
+	for i := range doc.Timetable.TrainParts {
+		parts[doc.Timetable.TrainParts[i].ID] = &doc.Timetable.TrainParts[i]
+	}
+	unmapped := make(map[string]bool)
+	for _, train := range doc.Timetable.Trains {
+		skip := func(format string, args ...interface{}) {
+			res.SkippedTrains = append(res.SkippedTrains, RailMLSkippedTrain{TrainID: train.ID, Reason: fmt.Sprintf(format, args...)})
+		}
+		code := train.TrainNumber
+		if code == "" {
+			code = train.ID
+		}
+		if _, exists := sim.Services[code]; exists {
+			skip("service %s already exists", code)
+			continue
+		}
+		sort.Slice(train.TrainPartSequences, func(i, j int) bool {
+			return train.TrainPartSequences[i].Sequence < train.TrainPartSequences[j].Sequence
+		})
+		s := &Service{Description: train.Description}
+		var err error
+	sequences:
+		for _, seq := range train.TrainPartSequences {
+			for _, ref := range seq.TrainPartRefs {
+				tp, ok := parts[ref.Ref]
+				if !ok {
+					err = fmt.Errorf("unknown train part %s", ref.Ref)
+					break sequences
+				}
+				if tp.FormationTT != nil && s.PlannedTrainTypeCode == "" {
+					ttCode := strings.TrimPrefix(tp.FormationTT.FormationRef, railMLFormationPrefix)
+					if _, ok := sim.TrainTypes[ttCode]; ok {
+						s.PlannedTrainTypeCode = ttCode
+					}
+				}
+				ocps := append([]railMLOCPTT{}, tp.OCPsTT...)
+				sort.Slice(ocps, func(i, j int) bool { return ocps[i].Sequence < ocps[j].Sequence })
+				for _, ocp := range ocps {
+					placeCode, ok := places[ocp.OCPRef]
+					if !ok {
+						placeCode = strings.TrimPrefix(ocp.OCPRef, railMLOCPPrefix)
+					}
+					if _, ok := sim.Places[placeCode]; !ok {
+						unmapped[ocp.OCPRef] = true
+						continue
+					}
+					sl := &ServiceLine{
+						MustStop:  ocp.OCPType == "stop",
+						PlaceCode: placeCode,
+						TrackCode: ocp.TrackInfo,
+					}
+					for _, times := range ocp.Times {
+						if times.Scope != "scheduled" && len(ocp.Times) > 1 {
+							continue
+						}
+						if sl.ScheduledArrivalTime.Time, err = railMLTimeOf(times.Arrival, times.ArrivalDay); err != nil {
+							break sequences
+						}
+						if sl.ScheduledDepartureTime.Time, err = railMLTimeOf(times.Departure, times.DepartureDay); err != nil {
+							break sequences
+						}
+						break
+					}
+					s.Lines = append(s.Lines, sl)
+				}
+			}
+		}
+		if err != nil {
+			skip("%s", err)
+			continue
+		}
+		if len(s.Lines) < 2 {
+			skip("less than two places of the simulation")
+			continue
+		}
+		sim.addService(code, s)
+		res.Services = append(res.Services, code)
+	}
+	for id := range unmapped {
+		res.UnmappedOCPs = append(res.UnmappedOCPs, id)
+	}
+	sort.Strings(res.Services)
+	sort.Strings(res.UnmappedOCPs)
+	if len(res.Services) > 0 {
+		sim.MessageLogger.addMessage(fmt.Sprintf("%d services imported from railML", len(res.Services)), simulationMsg)
+	}
+	return res, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// railMLConnections lists the connections of a railML document with their
+// references.
+type railMLConnections struct {
+	Connections []struct {
+		ID  string `xml:"id,attr"`
+		Ref string `xml:"ref,attr"`
+	} `xml:"infrastructure>tracks>track>trackTopology>trackBegin>connection"`
+	EndConnections []struct {
+		ID  string `xml:"id,attr"`
+		Ref string `xml:"ref,attr"`
+	} `xml:"infrastructure>tracks>track>trackTopology>trackEnd>connection"`
+	SwitchConnections []struct {
+		ID  string `xml:"id,attr"`
+		Ref string `xml:"ref,attr"`
+	} `xml:"infrastructure>tracks>track>trackTopology>connections>switch>connection"`
+	OpenEnds []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"infrastructure>tracks>track>trackTopology>trackBegin>openEnd"`
+	Trains []struct {
+		TrainNumber string `xml:"trainNumber,attr"`
+	} `xml:"timetable>trains>train"`
+}
+
+const railMLTestTimetable = `<?xml version="1.0" encoding="UTF-8"?>
+<railml xmlns="https://www.railml.org/schemas/2018" version="2.4">
+  <infrastructure id="inf">
+    <tracks>
+      <track id="tr_4">
+        <trackTopology>
+          <trackBegin id="b" pos="0"/>
+          <trackEnd id="e" pos="100"/>
+        </trackTopology>
+        <trackElements>
+          <speedChanges>
+            <speedChange id="sc" pos="0" dir="both" vMax="40"/>
+          </speedChanges>
+        </trackElements>
+      </track>
+    </tracks>
+    <operationControlPoints>
+      <ocp id="o1" code="XLFT" name="Left"/>
+      <ocp id="o2" code="STN"/>
+      <ocp id="o3" code="FAR" name="Far away"/>
+    </operationControlPoints>
+  </infrastructure>
+  <timetable id="tt">
+    <trainParts>
+      <trainPart id="p1">
+        <formationTT formationRef="fo_UT2"/>
+        <ocpsTT>
+          <ocpTT ocpRef="o2" sequence="2" ocpType="stop" trackInfo="1">
+            <times scope="scheduled" arrival="00:05:00.0" arrivalDay="1" departure="00:06:00" departureDay="1"/>
+          </ocpTT>
+          <ocpTT ocpRef="o1" sequence="1" ocpType="pass">
+            <times scope="scheduled" departure="23:58:00"/>
+          </ocpTT>
+        </ocpsTT>
+      </trainPart>
+      <trainPart id="p2">
+        <ocpsTT>
+          <ocpTT ocpRef="o3" sequence="1" ocpType="stop"/>
+          <ocpTT ocpRef="o2" sequence="2" ocpType="stop"/>
+        </ocpsTT>
+      </trainPart>
+    </trainParts>
+    <trains>
+      <train id="t1" type="operational" trainNumber="R100" description="Night train">
+        <trainPartSequence sequence="1"><trainPartRef ref="p1"/></trainPartSequence>
+      </train>
+      <train id="t2" type="operational" trainNumber="R200">
+        <trainPartSequence sequence="1"><trainPartRef ref="p2"/></trainPartSequence>
+      </train>
+      <train id="t3" type="operational" trainNumber="S001">
+        <trainPartSequence sequence="1"><trainPartRef ref="p1"/></trainPartSequence>
+      </train>
+    </trains>
+  </timetable>
+</railml>`
+
+func TestRailML(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing railML import and export", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		Convey("The exported topology should be consistent", func() {
+			data, err := sim.ExportRailML()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldStartWith, "<?xml")
+			var doc railMLConnections
+			So(xml.Unmarshal(data, &doc), ShouldBeNil)
+			refs := make(map[string]string)
+			for _, c := range append(append(doc.Connections, doc.EndConnections...), doc.SwitchConnections...) {
+				refs[c.ID] = c.Ref
+			}
+			So(refs, ShouldNotBeEmpty)
+			for id, ref := range refs {
+				So(refs[ref], ShouldEqual, id)
+			}
+			So(doc.SwitchConnections, ShouldNotBeEmpty)
+			So(len(doc.OpenEnds), ShouldBeGreaterThan, 0)
+			So(doc.Trains, ShouldHaveLength, len(sim.Services))
+			So(string(data), ShouldContainSubstring, `<ocp id="ocp_STN" code="STN" name="STATION"></ocp>`)
+		})
+		Convey("Exported data should be imported back without changes", func() {
+			data, err := sim.ExportRailML()
+			So(err, ShouldBeNil)
+			res, err := sim.ImportRailML(data)
+			So(err, ShouldBeNil)
+			So(res.Services, ShouldBeEmpty)
+			So(res.UpdatedTrackItems, ShouldBeEmpty)
+			So(res.SkippedTrains, ShouldHaveLength, len(sim.Services))
+		})
+		Convey("Timetables and speed limits should be imported", func() {
+			res, err := sim.ImportRailML([]byte(railMLTestTimetable))
+			So(err, ShouldBeNil)
+			So(res.Services, ShouldResemble, []string{"R100"})
+			So(res.UpdatedTrackItems, ShouldResemble, []string{"4"})
+			So(sim.TrackItems["4"].MaxSpeed(), ShouldAlmostEqual, 40/3.6, 0.001)
+			So(res.UnmappedOCPs, ShouldResemble, []string{"o3"})
+			So(res.SkippedTrains, ShouldHaveLength, 2)
+			s := sim.Services["R100"]
+			So(s.Description, ShouldEqual, "Night train")
+			So(s.PlannedTrainTypeCode, ShouldEqual, "UT2")
+			So(s.Lines, ShouldHaveLength, 2)
+			So(s.Lines[0].PlaceCode, ShouldEqual, "LFT")
+			So(s.Lines[0].MustStop, ShouldBeFalse)
+			So(s.Lines[1].TrackCode, ShouldEqual, "1")
+			So(simulation.FormatTime(s.Lines[1].ScheduledArrivalTime.Time), ShouldEqual, "24:05:00")
+			So(simulation.FormatTime(s.Lines[1].ScheduledDepartureTime.Time), ShouldEqual, "24:06:00")
+			data, err := sim.ExportRailML()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `arrival="00:05:00" arrivalDay="1"`)
+			So(strings.Count(string(data), `vMax="40"`), ShouldEqual, 1)
+		})
+		Convey("Invalid documents should be refused", func() {
+			_, err := sim.ImportRailML([]byte("<railml><timetable"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// maxSeed is the largest seed generated by the simulation. It is kept within
+// the range of integers that JSON clients can represent exactly.
+const maxSeed = 1<<53 - 1
+
+// newSeed returns a new random non zero seed
+func newSeed() int64 {
+	return time.Now().UnixNano()%maxSeed + 1
+}
+
+// countingSource is a rand.Source that counts the numbers it yields, so that
+// the state of the random generator can be saved and restored by replaying
+// the same number of draws.
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+// Int63 returns a random int64 in [0,1<<63)
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+// Uint64 returns a random uint64
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+// Seed seeds the source and resets the number of draws
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+// seededRand is the randSource of a simulation. All the random behaviour of
+// the simulation draws from it so that two runs with the same seed and the
+// same inputs give the same results.
+type seededRand struct {
+	sim *Simulation
+}
+
+// Intn returns a random int in [0,n)
+func (r seededRand) Intn(n int) int {
+	r.sim.randMutex.Lock()
+	defer r.sim.randMutex.Unlock()
+	return r.sim.randLocked().Intn(n)
+}
+
+// Float64 returns a random float64 in [0.0,1.0)
+func (r seededRand) Float64() float64 {
+	r.sim.randMutex.Lock()
+	defer r.sim.randMutex.Unlock()
+	return r.sim.randLocked().Float64()
+}
+
+// ExpFloat64 returns an exponentially distributed float64 with rate 1
+func (r seededRand) ExpFloat64() float64 {
+	r.sim.randMutex.Lock()
+	defer r.sim.randMutex.Unlock()
+	return r.sim.randLocked().ExpFloat64()
+}
+
+// random returns the random generator of this simulation
+func (sim *Simulation) random() seededRand {
+	return seededRand{sim: sim}
+}
+
+// randLocked returns the random generator of the simulation, seeding it from
+// Options.Seed when it is first used or when the seed has changed. A zero seed
+// is replaced by a new random one, so that the seed of any run can be read
+// back and replayed. randMutex must be held by the caller.
+func (sim *Simulation) randLocked() *rand.Rand {
+	if sim.Options.Seed == 0 {
+		sim.Options.Seed = newSeed()
+	}
+	if sim.rng == nil || sim.rngSeed != sim.Options.Seed {
+		sim.rngSource = &countingSource{src: rand.NewSource(sim.Options.Seed).(rand.Source64)}
+		sim.rng = rand.New(sim.rngSource)
+		sim.rngSeed = sim.Options.Seed
+	}
+	return sim.rng
+}
+
+// randomDraws returns the number of draws made from the random generator
+// since it was last seeded.
+func (sim *Simulation) randomDraws() uint64 {
+	sim.randMutex.Lock()
+	defer sim.randMutex.Unlock()
+	sim.randLocked()
+	return sim.rngSource.draws
+}
+
+// restoreRandom reseeds the random generator and advances it by the given
+// number of draws, so that it yields the same numbers as the generator the
+// draws were counted on.
+func (sim *Simulation) restoreRandom(draws uint64) {
+	sim.randMutex.Lock()
+	defer sim.randMutex.Unlock()
+	sim.rng = nil
+	sim.randLocked()
+	for i := uint64(0); i < draws; i++ {
+		sim.rngSource.Int63()
+	}
+}
+
+// sortedTrackItems returns the track items of the simulation sorted by ID, so
+// that random draws over them happen in the same order on each run.
+func (sim *Simulation) sortedTrackItems() []TrackItem {
+	items := make([]TrackItem, 0, len(sim.TrackItems))
+	for _, ti := range sim.TrackItems {
+		items = append(items, ti)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID() < items[j].ID()
+	})
+	return items
+}
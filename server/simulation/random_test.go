@@ -0,0 +1,79 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSeededRuns(t *testing.T) {
+	// run loads the demo simulation with the given seed and returns the
+	// events it sends while running with failures and perturbations.
+	run := func(seed string) []string {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		data = bytes.Replace(data, []byte(`"options": {`), []byte(`"options": {"seed": `+seed+`,`), 1)
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		// Objects are only read once the simulation is stopped since they
+		// are changed by Step.
+		resChan := make(chan []*simulation.Event)
+		go func() {
+			var events []*simulation.Event
+			for evt := range sim.EventChan {
+				if evt.Name == "end" {
+					resChan <- events
+					return
+				}
+				events = append(events, evt)
+			}
+		}()
+		So(sim.Initialize(), ShouldBeNil)
+		sim.Options.SignalFailureRate = 20
+		sim.Options.PointsFailureRate = 20
+		So(sim.SetPerturbations(simulation.Perturbations{
+			Enabled:               true,
+			EntryDelayProbability: 0.5,
+			DwellProbability:      0.5,
+			TrainFaultRate:        5,
+		}), ShouldBeNil)
+		for i := 0; i < 1500; i++ {
+			sim.Step()
+		}
+		stats := sim.PerturbationStats()
+		sim.EventChan <- &simulation.Event{Name: "end"}
+		var events []string
+		for _, evt := range <-resChan {
+			events = append(events, fmt.Sprintf("%s %s", evt.Name, evt.Object.ID()))
+		}
+		for _, k := range []simulation.PerturbationKind{
+			simulation.PerturbationEntryDelay,
+			simulation.PerturbationDwellExtension,
+			simulation.PerturbationTrainFault,
+		} {
+			events = append(events, fmt.Sprintf("%s %+v", k, stats[k]))
+		}
+		return events
+	}
+	Convey("Testing seeded runs", t, func() {
+		Convey("Loading a simulation without seed should generate one", func() {
+			var sim simulation.Simulation
+			data, _ := ioutil.ReadFile("testdata/demo.json")
+			So(json.Unmarshal(data, &sim), ShouldBeNil)
+			So(sim.Options.Seed, ShouldNotEqual, 0)
+		})
+		Convey("Two runs with the same seed should send the same events", func() {
+			first := run("42")
+			So(len(first), ShouldBeGreaterThan, 100)
+			So(run("42"), ShouldResemble, first)
+			So(run("43"), ShouldNotResemble, first)
+		})
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// rewindInterval is the simulation time between two rewind points
+	rewindInterval = time.Minute
+	// defaultRewindHistory is the simulation time during which rewind points
+	// are kept when the simulation does not define rewindHistoryMinutes.
+	defaultRewindHistory = time.Hour
+)
+
+// A RecordedEvent is an event recorded in the timeline of the simulation
+type RecordedEvent struct {
+	Name     EventName `json:"name"`
+	ObjectID string    `json:"objectId"`
+	Time     time.Time `json:"time"`
+}
+
+// A RewindPoint is a state of the simulation recorded so that the simulation
+// can be rewound to it. Events are those sent after this point, until the
+// next one.
+type RewindPoint struct {
+	Time   time.Time       `json:"time"`
+	Events []RecordedEvent `json:"events"`
+
+	checkpoint []byte
+}
+
+// unrecordedEvents are the events that are sent too often to be recorded in
+// the timeline.
+var unrecordedEvents = map[EventName]bool{
+	ClockEvent:            true,
+	TrainChangedEvent:     true,
+	TrackItemChangedEvent: true,
+}
+
+// RewindHistory returns the simulation time during which rewind points are kept
+func (sim *Simulation) RewindHistory() time.Duration {
+	if sim.Options.RewindHistoryMinutes <= 0 {
+		return defaultRewindHistory
+	}
+	return time.Duration(sim.Options.RewindHistoryMinutes) * time.Minute
+}
+
+// recordRewindPoint saves the state of the simulation as a new rewind point
+// if the last one is older than rewindInterval, and drops the points older
+// than the rewind history.
+func (sim *Simulation) recordRewindPoint() {
+	if sim.quiet {
+		return
+	}
+	now := sim.CurrentTime()
+	sim.rewindMutex.Lock()
+	if n := len(sim.rewindPoints); n > 0 && now.Sub(sim.rewindPoints[n-1].Time) < rewindInterval {
+		sim.rewindMutex.Unlock()
+		return
+	}
+	sim.rewindMutex.Unlock()
+	data, err := sim.Checkpoint()
+	if err != nil {
+		Logger.Warn("Unable to record rewind point", "error", err)
+		return
+	}
+	sim.rewindMutex.Lock()
+	defer sim.rewindMutex.Unlock()
+	sim.rewindPoints = append(sim.rewindPoints, &RewindPoint{Time: now, checkpoint: data})
+	oldest := now.Add(-sim.RewindHistory())
+	for len(sim.rewindPoints) > 1 && sim.rewindPoints[0].Time.Before(oldest) {
+		sim.rewindPoints = sim.rewindPoints[1:]
+	}
+}
+
+// recordEvent adds the given event to the timeline of the last rewind point
+func (sim *Simulation) recordEvent(evt *Event) {
+	if sim.quiet || unrecordedEvents[evt.Name] {
+		return
+	}
+	sim.rewindMutex.Lock()
+	defer sim.rewindMutex.Unlock()
+	n := len(sim.rewindPoints)
+	if n == 0 {
+		return
+	}
+	last := sim.rewindPoints[n-1]
+	last.Events = append(last.Events, RecordedEvent{
+		Name:     evt.Name,
+		ObjectID: evt.Object.ID(),
+		Time:     sim.CurrentTime(),
+	})
+}
+
+// RewindPoints returns the recorded rewind points of the simulation, oldest
+// first, with the events sent after each of them.
+func (sim *Simulation) RewindPoints() []RewindPoint {
+	sim.rewindMutex.Lock()
+	defer sim.rewindMutex.Unlock()
+	res := make([]RewindPoint, len(sim.rewindPoints))
+	for i, p := range sim.rewindPoints {
+		res[i] = RewindPoint{Time: p.Time, Events: append([]RecordedEvent{}, p.Events...)}
+	}
+	return res
+}
+
+// Rewind returns a new simulation in the state of this simulation d ago,
+// rounded down to the previous rewind point. It fails if d goes back further
+// than the oldest rewind point.
+//
+// The new simulation is initialized, not started, and keeps the rewind points
+// up to the one it was restored from, so that it can be rewound again. This
+// simulation is not modified: it should be paused before calling Rewind and
+// closed once replaced by the new one.
+func (sim *Simulation) Rewind(d time.Duration) (*Simulation, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("rewind duration must be positive")
+	}
+	target := sim.CurrentTime().Add(-d)
+	sim.rewindMutex.Lock()
+	idx := -1
+	for i, p := range sim.rewindPoints {
+		if p.Time.After(target) {
+			break
+		}
+		idx = i
+	}
+	if idx < 0 {
+		sim.rewindMutex.Unlock()
+		return nil, fmt.Errorf("cannot rewind before %s", sim.oldestRewindTimeLocked())
+	}
+	points := make([]*RewindPoint, idx+1)
+	for i, p := range sim.rewindPoints[:idx+1] {
+		points[i] = &RewindPoint{Time: p.Time, Events: append([]RecordedEvent{}, p.Events...), checkpoint: p.checkpoint}
+	}
+	sim.rewindMutex.Unlock()
+	// Events after the restored point belong to the abandoned timeline
+	points[idx].Events = nil
+	rewound, err := RestoreCheckpoint(points[idx].checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	rewound.rewindPoints = points
+	return rewound, nil
+}
+
+// oldestRewindTimeLocked returns the time of the oldest rewind point as a
+// string. rewindMutex must be held by the caller.
+func (sim *Simulation) oldestRewindTimeLocked() string {
+	if len(sim.rewindPoints) == 0 {
+		return "the first step of the simulation"
+	}
+	return FormatTime(sim.rewindPoints[0].Time)
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestRewind(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing simulation rewind", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		So(sim.SetPerturbations(simulation.Perturbations{Enabled: true, DwellProbability: 0.5, TrainFaultRate: 2}), ShouldBeNil)
+		start := sim.Options.CurrentTime.Time
+		// 10 minutes of simulation
+		for i := 0; i < 240; i++ {
+			sim.Step()
+		}
+		Convey("Rewind points should be recorded every minute", func() {
+			points := sim.RewindPoints()
+			So(points, ShouldHaveLength, 10)
+			So(points[1].Time.Sub(points[0].Time), ShouldEqual, time.Minute)
+			var events int
+			for _, p := range points {
+				events += len(p.Events)
+				for _, e := range p.Events {
+					So(e.Name, ShouldNotEqual, simulation.ClockEvent)
+				}
+			}
+			So(events, ShouldBeGreaterThan, 0)
+		})
+		Convey("Rewinding should restore a former state", func() {
+			rewound, err := sim.Rewind(3 * time.Minute)
+			So(err, ShouldBeNil)
+			drainEvents(rewound, endChan)
+			target := sim.Options.CurrentTime.Time.Add(-3 * time.Minute)
+			So(rewound.Options.CurrentTime.Time.After(target), ShouldBeFalse)
+			So(rewound.Options.CurrentTime.Time.After(target.Add(-time.Minute)), ShouldBeTrue)
+			So(rewound.RewindPoints(), ShouldHaveLength, len(sim.RewindPoints())-3)
+			Convey("And resume along the same path given the same decisions", func() {
+				for rewound.Options.CurrentTime.Time.Before(sim.Options.CurrentTime.Time) {
+					rewound.Step()
+				}
+				So(simState(rewound), ShouldResemble, simState(&sim))
+			})
+		})
+		Convey("Rewinding too far should fail", func() {
+			_, err := sim.Rewind(sim.Options.CurrentTime.Time.Sub(start) + time.Minute)
+			So(err, ShouldNotBeNil)
+			_, err = sim.Rewind(0)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Rewind points older than the history should be dropped", func() {
+			sim.Options.RewindHistoryMinutes = 2
+			for i := 0; i < 24; i++ {
+				sim.Step()
+			}
+			So(len(sim.RewindPoints()), ShouldBeLessThanOrEqualTo, 3)
+		})
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package simulation
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// RouteConflictKind tells why two routes cannot be set at the same time
+type RouteConflictKind string
+
+const (
+	// ConflictSharedItems means that the routes run over the same track items
+	ConflictSharedItems RouteConflictKind = "SHARED_ITEMS"
+	// ConflictPoints means that the routes need the same points in different
+	// directions
+	ConflictPoints RouteConflictKind = "POINTS"
+	// ConflictCrossing means that the routes run over the two tracks of a
+	// crossing
+	ConflictCrossing RouteConflictKind = "CROSSING"
+	// ConflictFlank means that one route needs for flank protection points
+	// that the other route needs in the other direction
+	ConflictFlank RouteConflictKind = "FLANK"
+)
+
+// A RouteConflict describes a route which cannot be set at the same time as
+// the route it is attached to.
+type RouteConflict struct {
+	Route *Route
+	Kind  RouteConflictKind
+	// TrackItemID is the ID of the first track item along the route where the
+	// conflict appears, or of the points in the case of a flank conflict
+	TrackItemID string
+}
+
+// MarshalJSON method for RouteConflict
+func (rc RouteConflict) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		RouteID     string            `json:"routeId"`
+		Kind        RouteConflictKind `json:"kind"`
+		TrackItemID string            `json:"trackItemId"`
+		State       RouteState        `json:"state"`
+	}{
+		RouteID:     rc.Route.ID(),
+		Kind:        rc.Kind,
+		TrackItemID: rc.TrackItemID,
+		State:       rc.Route.State(),
+	})
+}
+
+// Conflicts returns the routes which conflict with this route, sorted by ID.
+func (r *Route) Conflicts() []RouteConflict {
+	return r.simulation.routeConflicts[r.ID()]
+}
+
+// RouteConflicts returns the routes which conflict with the route with the
+// given ID, sorted by ID, and false if there is no such route.
+func (sim *Simulation) RouteConflicts(routeID string) ([]RouteConflict, bool) {
+	r, ok := sim.Routes[routeID]
+	if !ok {
+		return nil, false
+	}
+	return r.Conflicts(), true
+}
+
+// computeRouteConflicts builds the conflict graph of the routes of the
+// simulation. It must be called once the positions of all routes are known.
+func (sim *Simulation) computeRouteConflicts() {
+	ids := make([]string, 0, len(sim.Routes))
+	interiors := make(map[string]map[string]bool, len(sim.Routes))
+	for id, r := range sim.Routes {
+		ids = append(ids, id)
+		interiors[id] = r.interiorItems()
+	}
+	sort.Strings(ids)
+	sim.routeConflicts = make(map[string][]RouteConflict, len(ids))
+	for _, id := range ids {
+		r := sim.Routes[id]
+		sim.routeConflicts[id] = []RouteConflict{}
+		for _, oid := range ids {
+			if oid == id {
+				continue
+			}
+			o := sim.Routes[oid]
+			if kind, tiID := r.conflictWith(o, interiors[id], interiors[oid]); kind != "" {
+				sim.routeConflicts[id] = append(sim.routeConflicts[id], RouteConflict{Route: o, Kind: kind, TrackItemID: tiID})
+			}
+		}
+	}
+}
+
+// interiorItems returns the set of the IDs of the items of this route between
+// its begin and end signals, which are those the route locks.
+func (r *Route) interiorItems() map[string]bool {
+	res := make(map[string]bool)
+	for _, pos := range r.Positions {
+		if pos.TrackItemID == r.BeginSignalId || pos.TrackItemID == r.EndSignalId {
+			continue
+		}
+		res[pos.TrackItemID] = true
+	}
+	return res
+}
+
+// conflictWith returns the kind of the conflict between this route and route
+// o, given the interior items of both routes, and the first item of this
+// route where it appears. Points set in different directions are reported
+// before other shared items. It returns an empty kind if the routes do not
+// conflict.
+func (r *Route) conflictWith(o *Route, items, oItems map[string]bool) (RouteConflictKind, string) {
+	var shared, crossing string
+	for _, pos := range r.Positions {
+		id := pos.TrackItemID
+		if !items[id] {
+			continue
+		}
+		if oItems[id] {
+			if _, ok := pos.TrackItem().(*PointsItem); ok && r.Directions[id] != o.Directions[id] {
+				return ConflictPoints, id
+			}
+			if shared == "" {
+				shared = id
+			}
+		}
+		if ci := pos.TrackItem().ConflictItem(); ci != nil && oItems[ci.ID()] && crossing == "" {
+			crossing = id
+		}
+	}
+	if shared != "" {
+		return ConflictSharedItems, shared
+	}
+	if crossing != "" {
+		return ConflictCrossing, crossing
+	}
+	for _, pi := range r.FlankPoints() {
+		id, dir := pi.ID(), r.FlankProtection[pi.ID()]
+		if oItems[id] && o.Directions[id] != dir {
+			return ConflictFlank, id
+		}
+		if oDir, ok := o.FlankProtection[id]; ok && oDir != dir {
+			return ConflictFlank, id
+		}
+	}
+	for _, pi := range o.FlankPoints() {
+		if id := pi.ID(); items[id] && r.Directions[id] != o.FlankProtection[id] {
+			return ConflictFlank, id
+		}
+	}
+	return "", ""
+}
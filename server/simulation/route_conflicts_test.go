@@ -0,0 +1,65 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestRouteConflicts(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing the route conflict graph", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		kinds := func(routeID string) map[string]string {
+			conflicts, ok := sim.RouteConflicts(routeID)
+			So(ok, ShouldBeTrue)
+			res := make(map[string]string)
+			for _, c := range conflicts {
+				res[c.Route.ID()] = string(c.Kind) + "@" + c.TrackItemID
+			}
+			return res
+		}
+		Convey("Conflicts should be computed at initialization", func() {
+			So(kinds("1"), ShouldResemble, map[string]string{
+				"2": "POINTS@7",
+				"3": "SHARED_ITEMS@6",
+				"4": "POINTS@7",
+			})
+			So(kinds("3"), ShouldResemble, map[string]string{
+				"1": "SHARED_ITEMS@8",
+				"2": "POINTS@7",
+				"4": "POINTS@7",
+			})
+			So(kinds("11"), ShouldBeEmpty)
+			_, ok := sim.RouteConflicts("99")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Conflicting routes should not be set together", func() {
+			So(sim.Routes["1"].IsActive(), ShouldBeTrue)
+			err := sim.Routes["4"].Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Standard Manager vetoed route activation: conflicting route 1 is active")
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			So(sim.Routes["4"].Activate(false), ShouldBeNil)
+		})
+		Convey("Clones should have the same conflict graph", func() {
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			conflicts, ok := clone.RouteConflicts("1")
+			So(ok, ShouldBeTrue)
+			So(conflicts, ShouldHaveLength, 3)
+			So(conflicts[0].Route, ShouldEqual, clone.Routes["2"])
+		})
+	})
+}
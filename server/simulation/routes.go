@@ -21,6 +21,7 @@ package simulation
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 // A RoutesManager checks if a route is activable or deactivable.
@@ -65,9 +66,18 @@ type Route struct {
 	Directions    map[string]PointDirection `json:"directions"`
 	Persistent    bool                      `json:"persistent"`
 	Positions     []Position                `json:"-"`
+	// FlankProtection gives the direction of the points which are not on the
+	// route but must be set and locked to keep movements on converging lines
+	// away from it.
+	FlankProtection map[string]PointDirection `json:"flankProtection,omitempty"`
+	// OverlapLength is the distance beyond the exit signal that is locked
+	// with the route. If zero, the OverlapLength option is used.
+	OverlapLength float64 `json:"overlapLength,omitempty"`
 
-	simulation *Simulation
-	triggers   []func(*Route)
+	simulation       *Simulation
+	triggers         []func(*Route)
+	overlapLocked    bool
+	overlapReleaseAt time.Time
 }
 
 // ID returns the unique identifier of this route
@@ -125,12 +135,30 @@ func (r *Route) Activate(persistent bool) error {
 			return fmt.Errorf("%s vetoed route activation: %s", rm.Name(), err)
 		}
 	}
+	if err := r.checkDisruptions(); err != nil {
+		return err
+	}
+	if err := r.checkFlankProtection(); err != nil {
+		return err
+	}
+	if err := r.checkOverlaps(); err != nil {
+		return err
+	}
+	if err := r.checkSingleLines(); err != nil {
+		return err
+	}
+	if err := r.checkPlatformLengths(r.BeginSignal().train); err != nil {
+		return err
+	}
 	for _, pos := range r.Positions {
 		if pos.TrackItem().Equals(r.BeginSignal()) || pos.TrackItem().Equals(r.EndSignal()) {
 			continue
 		}
 		pos.TrackItem().setActiveRoute(r, pos.PreviousItem())
 	}
+	r.setFlankProtection()
+	r.lockOverlap()
+	r.claimSingleLines()
 	r.EndSignal().previousActiveRoute = r
 	r.BeginSignal().nextActiveRoute = r
 	r.Persistent = persistent
@@ -145,6 +173,24 @@ func (r *Route) Activate(persistent bool) error {
 	return nil
 }
 
+// checkDisruptions returns an error if a disruption on the path of this route,
+// such as a blocked track item or locked or failed points, prevents its
+// activation.
+func (r *Route) checkDisruptions() error {
+	for _, pos := range r.Positions {
+		if pos.TrackItem().Blocked() {
+			return fmt.Errorf("track item %s is blocked", pos.TrackItemID)
+		}
+		if pi, ok := pos.TrackItem().(*PointsItem); ok && pi.Locked() && pi.Reversed() != (r.Directions[pi.ID()] == DirectionReversed) {
+			return fmt.Errorf("points %s are locked", pi.ID())
+		}
+	}
+	if fp := r.failedPoints(); len(fp) > 0 {
+		return fmt.Errorf("points %s have failed", fp[0].ID())
+	}
+	return nil
+}
+
 // Deactivate the given route. If the route cannot be Deactivated, an error is returned.
 func (r *Route) Deactivate() error {
 	for _, rm := range routesManagers {
@@ -160,6 +206,7 @@ func (r *Route) Deactivate() error {
 		}
 		pos.TrackItem().setActiveRoute(nil, nil)
 	}
+	r.releaseOverlap()
 	for _, t := range r.triggers {
 		t(r)
 	}
@@ -190,14 +237,7 @@ func (r *Route) initialize(routeNum string) error {
 	for !pos.IsOut() {
 		r.Positions = append(r.Positions, pos)
 		if pos.TrackItem().ID() == r.EndSignal().ID() {
-			// Initialize state to initial state
-			switch r.InitialState {
-			case Persistent:
-				_ = r.Activate(true)
-			case Activated:
-				_ = r.Activate(false)
-			}
-			return nil
+			return r.initializeFlankProtection()
 		}
 		dir := DirectionCurrent
 		if pi, ok := pos.TrackItem().(*PointsItem); ok {
@@ -221,13 +261,30 @@ func (r *Route) initialize(routeNum string) error {
 	return fmt.Errorf("route Error: unable to link signal %s to signal %s", r.BeginSignalId, r.EndSignalId)
 }
 
+// setInitialState activates this route if its initial state says so. It
+// must be called once all routes are initialized. Routes that would reverse a
+// single line section locked in its initial direction are not activated.
+func (r *Route) setInitialState() {
+	if r.reversesSingleLines() {
+		return
+	}
+	switch r.InitialState {
+	case Persistent:
+		_ = r.Activate(true)
+	case Activated:
+		_ = r.Activate(false)
+	}
+}
+
 // UnmarshalJSON for the Route type
 func (r *Route) UnmarshalJSON(data []byte) error {
 	type auxRoute struct {
-		BeginSignalId string                    `json:"beginSignal"`
-		EndSignalId   string                    `json:"endSignal"`
-		InitialState  RouteState                `json:"initialState"`
-		Directions    map[string]PointDirection `json:"directions"`
+		BeginSignalId   string                    `json:"beginSignal"`
+		EndSignalId     string                    `json:"endSignal"`
+		InitialState    RouteState                `json:"initialState"`
+		Directions      map[string]PointDirection `json:"directions"`
+		FlankProtection map[string]PointDirection `json:"flankProtection"`
+		OverlapLength   float64                   `json:"overlapLength"`
 	}
 	var rawRoute auxRoute
 	if err := json.Unmarshal(data, &rawRoute); err != nil {
@@ -236,30 +293,43 @@ func (r *Route) UnmarshalJSON(data []byte) error {
 	r.BeginSignalId = rawRoute.BeginSignalId
 	r.EndSignalId = rawRoute.EndSignalId
 	r.InitialState = rawRoute.InitialState
+	r.OverlapLength = rawRoute.OverlapLength
 	r.Directions = make(map[string]PointDirection)
 	for tiID, dir := range rawRoute.Directions {
 		r.Directions[tiID] = dir
 	}
+	if len(rawRoute.FlankProtection) > 0 {
+		r.FlankProtection = make(map[string]PointDirection)
+		for tiID, dir := range rawRoute.FlankProtection {
+			r.FlankProtection[tiID] = dir
+		}
+	}
 	return nil
 }
 
 // MarshalJSON for the Route type
 func (r *Route) MarshalJSON() ([]byte, error) {
 	type auxRoute struct {
-		ID            string                    `json:"id"`
-		BeginSignalId string                    `json:"beginSignal"`
-		EndSignalId   string                    `json:"endSignal"`
-		InitialState  RouteState                `json:"initialState"`
-		Directions    map[string]PointDirection `json:"directions"`
-		State         RouteState                `json:"state"`
+		ID              string                    `json:"id"`
+		BeginSignalId   string                    `json:"beginSignal"`
+		EndSignalId     string                    `json:"endSignal"`
+		InitialState    RouteState                `json:"initialState"`
+		Directions      map[string]PointDirection `json:"directions"`
+		State           RouteState                `json:"state"`
+		FlankProtection map[string]PointDirection `json:"flankProtection,omitempty"`
+		OverlapLength   float64                   `json:"overlapLength,omitempty"`
+		OverlapLocked   bool                      `json:"overlapLocked"`
 	}
 	ar := auxRoute{
-		ID:            r.ID(),
-		BeginSignalId: r.BeginSignalId,
-		EndSignalId:   r.EndSignalId,
-		InitialState:  r.InitialState,
-		Directions:    r.Directions,
-		State:         r.State(),
+		ID:              r.ID(),
+		BeginSignalId:   r.BeginSignalId,
+		EndSignalId:     r.EndSignalId,
+		InitialState:    r.InitialState,
+		Directions:      r.Directions,
+		State:           r.State(),
+		FlankProtection: r.FlankProtection,
+		OverlapLength:   r.OverlapLength,
+		OverlapLocked:   r.overlapLocked,
 	}
 	d, err := json.Marshal(ar)
 	return d, err
@@ -0,0 +1,75 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// stepUntil steps the simulation until cond is true, or until the given
+// number of steps has been done. It returns the value of cond.
+func stepUntil(sim *simulation.Simulation, steps int, cond func() bool) bool {
+	for i := 0; i < steps; i++ {
+		if cond() {
+			return true
+		}
+		sim.Step()
+	}
+	return cond()
+}
+
+func TestSectionalRelease(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing sectional route release", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		routeOn := func(id string) string {
+			if r := sim.TrackItems[id].ActiveRoute(); r != nil {
+				return r.ID()
+			}
+			return ""
+		}
+		tailOn := func(train *simulation.Train, id string) func() bool {
+			return func() bool {
+				return train.TrainTail().TrackItemID == id
+			}
+		}
+		Convey("Routes should be released element by element behind the train", func() {
+			So(stepUntil(&sim, 500, tailOn(sim.Trains[0], "8")), ShouldBeTrue)
+			So(routeOn("6"), ShouldEqual, "")
+			So(routeOn("8"), ShouldEqual, "1")
+			So(routeOn("10"), ShouldEqual, "1")
+			So(sim.Routes["1"].State(), ShouldEqual, simulation.Destroying)
+		})
+		Convey("Persistent routes should be released behind the train and set again", func() {
+			// Send the first train to STN/2 and keep route 1 for the second
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			So(sim.Routes["2"].Activate(false), ShouldBeNil)
+			So(stepUntil(&sim, 500, tailOn(sim.Trains[0], "16")), ShouldBeTrue)
+			So(sim.Routes["1"].Activate(true), ShouldBeNil)
+			train := sim.Trains[1]
+			So(stepUntil(&sim, 500, tailOn(train, "8")), ShouldBeTrue)
+			So(routeOn("6"), ShouldEqual, "")
+			So(routeOn("8"), ShouldEqual, "1")
+			So(routeOn("10"), ShouldEqual, "1")
+			So(sim.Routes["1"].State(), ShouldEqual, simulation.Persistent)
+			So(stepUntil(&sim, 500, tailOn(train, "102")), ShouldBeTrue)
+			So(sim.Routes["1"].State(), ShouldEqual, simulation.Persistent)
+			So(routeOn("6"), ShouldEqual, "1")
+			So(routeOn("8"), ShouldEqual, "1")
+			So(routeOn("10"), ShouldEqual, "1")
+		})
+	})
+}
@@ -0,0 +1,33 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPause(t *testing.T) {
+	Convey("Pausing should not block when the main loop stopped by itself", t, func() {
+		// The main loop stopped at a breakpoint after Pause saw the
+		// simulation started
+		sim := &Simulation{stopChan: make(chan bool), started: true, runDone: make(chan struct{})}
+		close(sim.runDone)
+		pausing := make(chan struct{})
+		go func() {
+			sim.Pause()
+			close(pausing)
+		}()
+		returned := false
+		select {
+		case <-pausing:
+			returned = true
+		case <-time.After(time.Second):
+		}
+		So(returned, ShouldBeTrue)
+		So(sim.IsStarted(), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// overspeedTolerance is the speed in m/s by which a train may exceed the
+// permitted speed before an overspeed is recorded, so that the small
+// overshoots of the speed control of trains are not reported.
+const overspeedTolerance float64 = 1
+
+// SafetyViolationKind is the kind of a SafetyViolation
+type SafetyViolationKind string
+
+const (
+	// SafetyOverspeed is a train running faster than the permitted speed of
+	// the track items it occupies
+	SafetyOverspeed SafetyViolationKind = "OVERSPEED"
+	// SafetySPAD is a train passing a signal at danger without authority
+	SafetySPAD SafetyViolationKind = "SPAD"
+)
+
+// A SafetyViolation is sent with an OverspeedEvent or a
+// SignalPassedAtDangerEvent when a train breaks a safety rule.
+//
+// TrackItemID is the signal passed at danger, or the item with the lowest
+// permitted speed under the train for an overspeed.
+type SafetyViolation struct {
+	Kind           SafetyViolationKind `json:"kind"`
+	TrainID        string              `json:"trainId"`
+	ServiceCode    string              `json:"serviceCode"`
+	TrackItemID    string              `json:"trackItemId"`
+	Speed          float64             `json:"speed"`
+	PermittedSpeed float64             `json:"permittedSpeed"`
+	Time           Time                `json:"time"`
+
+	simulation *Simulation
+}
+
+// MarshalJSON method for SafetyViolation
+func (sv *SafetyViolation) MarshalJSON() ([]byte, error) {
+	type auxSafetyViolation SafetyViolation
+	return json.Marshal(struct {
+		*auxSafetyViolation
+		Time string `json:"time"`
+	}{
+		auxSafetyViolation: (*auxSafetyViolation)(sv),
+		Time:               formatObjectTime(sv.simulation, sv.Time.Time),
+	})
+}
+
+// ID returns the ID of the train that broke the rule
+func (sv *SafetyViolation) ID() string {
+	return sv.TrainID
+}
+
+// signalPassedAtDanger returns the signal at danger ahead of this train that
+// its head passes by running advanceLength, or nil. The signal that the train
+// has been authorised to pass with ProceedWithCaution is not returned.
+func (t *Train) signalPassedAtDanger(advanceLength float64) *SignalItem {
+	nsp := t.NextSignalPosition()
+	if nsp.IsNull() {
+		return nil
+	}
+	si := nsp.TrackItem().(*SignalItem)
+	if si.ActiveAspect().MeansProceed() || (t.ignoredSignal != nil && si.Equals(t.ignoredSignal)) {
+		return nil
+	}
+	d, err := nsp.Sub(t.TrainHead)
+	if err != nil || d > advanceLength {
+		return nil
+	}
+	return si
+}
+
+// permittedSpeed returns the lowest speed permitted on the track items of this
+// train and the ID of the item where it applies, which is empty if the
+// maximum speed of the train type is the lowest.
+func (t *Train) permittedSpeed() (float64, string) {
+	speed := t.TrainType().MaxSpeed
+	var itemID string
+	for _, ti := range t.trainTrackItems() {
+		if ti.MaxSpeed() < speed {
+			speed = ti.MaxSpeed()
+			itemID = ti.ID()
+		}
+	}
+	return speed, itemID
+}
+
+// checkOverspeed records an overspeed when this train starts running faster
+// than the permitted speed. The same overspeed is recorded only once, until
+// the train is back under the permitted speed.
+func (t *Train) checkOverspeed() {
+	if !t.IsActive() {
+		t.overspeeding = false
+		return
+	}
+	permitted, itemID := t.permittedSpeed()
+	overspeeding := t.Speed > permitted+overspeedTolerance
+	if overspeeding == t.overspeeding {
+		return
+	}
+	t.overspeeding = overspeeding
+	if !overspeeding {
+		return
+	}
+	if itemID == "" {
+		itemID = t.TrainHead.TrackItemID
+	}
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s overspeeding at %.0f km/h, permitted speed is %.0f km/h",
+		t.ServiceCode, t.Speed*3.6, permitted*3.6), playerWarningMsg)
+	t.recordSafetyViolation(SafetyOverspeed, itemID, permitted)
+}
+
+// recordSPAD records that this train passed the given signal at danger
+func (t *Train) recordSPAD(si *SignalItem) {
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s passed signal %s at danger", t.ServiceCode, si.Name()),
+		playerWarningMsg)
+	t.recordSafetyViolation(SafetySPAD, si.ID(), 0)
+}
+
+// recordSafetyViolation sends the event of a safety violation of this train
+func (t *Train) recordSafetyViolation(kind SafetyViolationKind, itemID string, permitted float64) {
+	sv := &SafetyViolation{
+		Kind:           kind,
+		TrainID:        t.ID(),
+		ServiceCode:    t.ServiceCode,
+		TrackItemID:    itemID,
+		Speed:          t.Speed,
+		PermittedSpeed: permitted,
+		simulation:     t.simulation,
+	}
+	sv.Time.Time = t.simulation.Options.CurrentTime.Time
+	name := OverspeedEvent
+	if kind == SafetySPAD {
+		name = SignalPassedAtDangerEvent
+	}
+	t.simulation.sendEvent(&Event{Name: name, Object: sv})
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSafetyViolations(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing overspeed and SPAD detection", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		violations := make(chan *simulation.SafetyViolation, 100)
+		go func() {
+			for {
+				select {
+				case e := <-sim.EventChan:
+					switch e.Name {
+					case simulation.OverspeedEvent, simulation.SignalPassedAtDangerEvent:
+						violations <- e.Object.(*simulation.SafetyViolation)
+					}
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		So(sim.Initialize(), ShouldBeNil)
+		train := sim.Trains[0]
+		sig := sim.TrackItems["5"].(*simulation.SignalItem)
+		distanceToSignal := func() float64 {
+			d, err := train.NextSignalPosition().Sub(train.TrainHead)
+			So(err, ShouldBeNil)
+			return d
+		}
+		// collect returns the violations sent so far
+		collect := func() []*simulation.SafetyViolation {
+			// Let the listener forward the last events
+			sim.Step()
+			var res []*simulation.SafetyViolation
+			for {
+				select {
+				case sv := <-violations:
+					res = append(res, sv)
+				default:
+					return res
+				}
+			}
+		}
+		Convey("A sudden speed restriction under a running train should be an overspeed", func() {
+			So(stepUntil(&sim, 600, func() bool { return train.Speed > 5 }), ShouldBeTrue)
+			So(collect(), ShouldBeEmpty)
+			d := &simulation.Disruption{Type: simulation.DisruptionSpeedRestriction, TrackItemID: "1", ToTrackItemID: "6", SpeedLimit: 1}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			res := collect()
+			So(res, ShouldHaveLength, 1)
+			So(res[0].Kind, ShouldEqual, simulation.SafetyOverspeed)
+			So(res[0].TrainID, ShouldEqual, train.ID())
+			So(res[0].PermittedSpeed, ShouldEqual, 1)
+			So(res[0].Speed, ShouldBeGreaterThan, 2)
+			// The same overspeed is not reported again
+			sim.Step()
+			So(collect(), ShouldBeEmpty)
+		})
+		Convey("Trains stopping at a signal at danger should not be SPADs", func() {
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			So(stepUntil(&sim, 1200, func() bool {
+				return train.IsActive() && train.Speed == 0 && train.NextSignalPosition().TrackItemID == "5" && distanceToSignal() < 50
+			}), ShouldBeTrue)
+			for i := 0; i < 20; i++ {
+				sim.Step()
+			}
+			So(collect(), ShouldBeEmpty)
+			// Passing the signal with authority is not a SPAD either
+			So(train.ProceedWithCaution(), ShouldBeNil)
+			So(stepUntil(&sim, 600, func() bool { return train.NextSignalPosition().TrackItemID != "5" }), ShouldBeTrue)
+			So(collect(), ShouldBeEmpty)
+		})
+		Convey("A signal put back to danger in front of a train should be a SPAD", func() {
+			So(stepUntil(&sim, 1200, func() bool {
+				// The train is well within its emergency braking distance
+				return train.IsActive() && train.NextSignalPosition().TrackItemID == "5" &&
+					distanceToSignal() < train.Speed*train.Speed/(4*train.EmergencyBraking())
+			}), ShouldBeTrue)
+			So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+			So(sig.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			So(stepUntil(&sim, 100, func() bool { return train.NextSignalPosition().TrackItemID != "5" }), ShouldBeTrue)
+			res := collect()
+			So(res, ShouldHaveLength, 1)
+			So(res[0].Kind, ShouldEqual, simulation.SafetySPAD)
+			So(res[0].TrackItemID, ShouldEqual, "5")
+		})
+	})
+}
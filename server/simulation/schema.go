@@ -0,0 +1,1000 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileSchema is the JSON Schema (draft-07) of the simulation file format.
+//
+// It describes the structure of the file only. References between objects
+// are checked by ValidateFile.
+const FileSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://ts2.github.io/schemas/simulation-0.7.json",
+  "title": "TS2 simulation file",
+  "type": "object",
+  "required": ["options", "signalLibrary", "trackItems", "routes", "trainTypes", "services", "trains"],
+  "properties": {
+    "__type__": {"const": "Simulation"},
+    "options": {"$ref": "#/definitions/options"},
+    "signalLibrary": {"$ref": "#/definitions/signalLibrary"},
+    "trackItems": {
+      "type": "object",
+      "additionalProperties": {"$ref": "#/definitions/trackItem"}
+    },
+    "routes": {
+      "type": "object",
+      "additionalProperties": {"$ref": "#/definitions/route"}
+    },
+    "trainTypes": {
+      "type": "object",
+      "additionalProperties": {"$ref": "#/definitions/trainType"}
+    },
+    "services": {
+      "type": "object",
+      "additionalProperties": {"$ref": "#/definitions/service"}
+    },
+    "trains": {
+      "type": "array",
+      "items": {"$ref": "#/definitions/train"}
+    },
+    "messageLogger": {
+      "type": ["object", "null"],
+      "properties": {
+        "messages": {
+          "type": ["array", "null"],
+          "items": {
+            "type": "object",
+            "properties": {
+              "msgType": {"type": "integer"},
+              "msgText": {"type": "string"}
+            }
+          }
+        }
+      }
+    },
+    "sections": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "required": ["trackItems"],
+        "properties": {
+          "name": {"type": "string"},
+          "trackItems": {"type": "array", "items": {"type": "string"}},
+          "singleLine": {"type": "boolean"},
+          "initialDirection": {"enum": ["", "UP", "DOWN"]}
+        }
+      }
+    },
+    "transfers": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "required": ["placeCode", "fromService", "toService"],
+        "properties": {
+          "placeCode": {"type": "string"},
+          "fromService": {"type": "string"},
+          "toService": {"type": "string"},
+          "minConnectionTime": {"type": "integer", "minimum": 0}
+        }
+      }
+    },
+    "depots": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "required": ["trackItems"],
+        "properties": {
+          "name": {"type": "string"},
+          "trackItems": {"type": "array", "items": {"type": "string"}},
+          "capacity": {"type": "integer", "minimum": 0}
+        }
+      }
+    },
+    "controlAreas": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "required": ["trackItems"],
+        "properties": {
+          "name": {"type": "string"},
+          "trackItems": {"type": "array", "items": {"type": "string"}},
+          "dispatcher": {"type": "string"}
+        }
+      }
+    },
+    "trainingScenarios": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "required": ["objectives"],
+        "properties": {
+          "title": {"type": "string"},
+          "description": {"type": "string"},
+          "disruptions": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["type", "trackItemId"],
+              "properties": {
+                "type": {"type": "string"},
+                "trackItemId": {"type": "string"},
+                "toTrackItemId": {"type": "string"},
+                "speedLimit": {"type": "number", "minimum": 0},
+                "reason": {"type": "string"},
+                "failureMode": {"type": "string"},
+                "startAfterMinutes": {"type": "integer", "minimum": 0},
+                "durationMinutes": {"type": "integer", "minimum": 0}
+              }
+            }
+          },
+          "objectives": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["metric", "operator", "value"],
+              "properties": {
+                "description": {"type": "string"},
+                "metric": {"enum": ["punctuality", "averageDelay", "openConflicts", "cancellations", "missedConnections", "spads", "overspeeds", "score"]},
+                "operator": {"enum": [">=", ">", "<=", "<"]},
+                "value": {"type": "number"},
+                "deadline": {"$ref": "#/definitions/time"},
+                "mode": {"enum": ["REACH", "MAINTAIN", "reach", "maintain"]}
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "definitions": {
+    "id": {"type": ["string", "null"]},
+    "time": {
+      "type": ["string", "null"],
+      "pattern": "^([0-9]+:[0-5][0-9]:[0-5][0-9])?$"
+    },
+    "delay": {
+      "oneOf": [
+        {"type": "integer", "minimum": 0},
+        {
+          "type": "array",
+          "items": {"type": "array", "items": {"type": "number"}}
+        }
+      ]
+    },
+    "options": {
+      "type": "object",
+      "required": ["version", "currentTime"],
+      "properties": {
+        "version": {"const": "0.7"},
+        "title": {"type": "string"},
+        "description": {"type": "string"},
+        "clientToken": {"type": "string"},
+        "currentTime": {"$ref": "#/definitions/time"},
+        "currentScore": {"type": "integer"},
+        "timeFactor": {"type": "integer", "minimum": 0},
+        "trackCircuitBased": {"type": "boolean"},
+        "defaultDelayAtEntry": {"$ref": "#/definitions/delay"},
+        "defaultMinimumStopTime": {"$ref": "#/definitions/delay"},
+        "defaultMaxSpeed": {"type": "number", "minimum": 0},
+        "defaultSignalVisibility": {"type": "number", "minimum": 0},
+        "warningSpeed": {"type": "number", "minimum": 0},
+        "latePenalty": {"type": "integer"},
+        "wrongPlatformPenalty": {"type": "integer"},
+        "wrongDestinationPenalty": {"type": "integer"},
+        "geoReference": {"$ref": "#/definitions/geoReference"}
+      }
+    },
+    "latLon": {
+      "type": "object",
+      "required": ["lat", "lon"],
+      "properties": {
+        "lat": {"type": "number"},
+        "lon": {"type": "number"}
+      }
+    },
+    "geoReference": {
+      "type": ["object", "null"],
+      "properties": {
+        "affine": {"type": "array", "items": {"type": "number"}},
+        "origin": {"$ref": "#/definitions/latLon"},
+        "metersPerUnit": {"type": "number", "minimum": 0},
+        "rotation": {"type": "number"},
+        "items": {
+          "type": "object",
+          "additionalProperties": {"type": "array", "items": {"$ref": "#/definitions/latLon"}}
+        }
+      }
+    },
+    "signalLibrary": {
+      "type": "object",
+      "required": ["signalAspects", "signalTypes"],
+      "properties": {
+        "signalAspects": {
+          "type": "object",
+          "additionalProperties": {
+            "type": "object",
+            "properties": {
+              "lineStyle": {"type": "integer"},
+              "outerShapes": {"type": "array", "items": {"type": "integer"}},
+              "outerColors": {"type": "array", "items": {"type": "string"}},
+              "shapes": {"type": "array", "items": {"type": "integer"}},
+              "shapesColors": {"type": "array", "items": {"type": "string"}},
+              "actions": {
+                "type": "array",
+                "items": {"type": "array", "items": {"type": "number"}}
+              }
+            }
+          }
+        },
+        "signalTypes": {
+          "type": "object",
+          "additionalProperties": {
+            "type": "object",
+            "required": ["states"],
+            "properties": {
+              "states": {
+                "type": "array",
+                "items": {
+                  "type": "object",
+                  "required": ["aspectName"],
+                  "properties": {
+                    "aspectName": {"type": "string"},
+                    "conditions": {"type": "object"}
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "trackItem": {
+      "type": "object",
+      "required": ["__type__", "x", "y"],
+      "properties": {
+        "__type__": {
+          "enum": ["LineItem", "InvisibleLinkItem", "LevelCrossingItem", "EndItem", "PlatformItem",
+                   "TextItem", "PointsItem", "SignalItem", "Place"]
+        },
+        "tiId": {"type": "string"},
+        "name": {"type": ["string", "null"]},
+        "nextTiId": {"$ref": "#/definitions/id"},
+        "previousTiId": {"$ref": "#/definitions/id"},
+        "conflictTiId": {"$ref": "#/definitions/id"},
+        "placeCode": {"type": ["string", "null"]},
+        "trackCode": {"type": ["string", "null"]},
+        "maxSpeed": {"type": ["number", "null"], "minimum": 0},
+        "realLength": {"type": ["number", "null"], "minimum": 0},
+        "gradient": {"type": "number"},
+        "curveRadius": {"type": "number"},
+        "x": {"type": "number"},
+        "y": {"type": "number"},
+        "customProperties": {"type": ["object", "null"]}
+      },
+      "allOf": [
+        {
+          "if": {"properties": {"__type__": {"enum": ["LineItem", "InvisibleLinkItem", "PlatformItem"]}}},
+          "then": {"required": ["xf", "yf"]}
+        },
+        {
+          "if": {"properties": {"__type__": {"const": "Place"}}},
+          "then": {"required": ["placeCode"], "properties": {"placeCode": {"type": "string", "minLength": 1}}}
+        },
+        {
+          "if": {"properties": {"__type__": {"const": "PointsItem"}}},
+          "then": {
+            "required": ["reverseTiId", "xf", "yf", "xn", "yn", "xr", "yr"],
+            "properties": {"reverseTiId": {"$ref": "#/definitions/id"}}
+          }
+        },
+        {
+          "if": {"properties": {"__type__": {"const": "SignalItem"}}},
+          "then": {
+            "required": ["signalType", "reverse"],
+            "properties": {
+              "signalType": {"type": "string"},
+              "reverse": {"type": "boolean"}
+            }
+          }
+        }
+      ]
+    },
+    "route": {
+      "type": "object",
+      "required": ["beginSignal", "endSignal"],
+      "properties": {
+        "beginSignal": {"type": "string"},
+        "endSignal": {"type": "string"},
+        "initialState": {"enum": [0, 1, 2]},
+        "directions": {"$ref": "#/definitions/directions"},
+        "flankProtection": {"$ref": "#/definitions/directions"},
+        "overlapLength": {"type": "number", "minimum": 0},
+        "persistent": {"type": "boolean"}
+      }
+    },
+    "directions": {
+      "type": ["object", "null"],
+      "additionalProperties": {"enum": [0, 1]}
+    },
+    "trainType": {
+      "type": "object",
+      "properties": {
+        "description": {"type": "string"},
+        "emergBraking": {"type": "number", "minimum": 0},
+        "length": {"type": "number", "minimum": 0},
+        "maxSpeed": {"type": "number", "minimum": 0},
+        "stdAccel": {"type": "number", "minimum": 0},
+        "stdBraking": {"type": "number", "minimum": 0},
+        "capacity": {"type": "number", "minimum": 0},
+        "elements": {"type": ["array", "null"], "items": {"type": "string"}}
+      }
+    },
+    "service": {
+      "type": "object",
+      "required": ["lines"],
+      "properties": {
+        "serviceCode": {"type": "string"},
+        "description": {"type": "string"},
+        "plannedTrainType": {"type": ["string", "null"]},
+        "nextService": {"type": "string"},
+        "lines": {
+          "type": ["array", "null"],
+          "items": {
+            "type": "object",
+            "required": ["placeCode"],
+            "properties": {
+              "placeCode": {"type": "string"},
+              "trackCode": {"type": ["string", "null"]},
+              "mustStop": {"type": "boolean"},
+              "scheduledArrivalTime": {"$ref": "#/definitions/time"},
+              "scheduledDepartureTime": {"$ref": "#/definitions/time"}
+            }
+          }
+        },
+        "postActions": {
+          "type": ["array", "null"],
+          "items": {
+            "type": "object",
+            "required": ["actionCode"],
+            "properties": {
+              "actionCode": {"type": "string"},
+              "actionParam": {"type": ["string", "null"]}
+            }
+          }
+        }
+      }
+    },
+    "train": {
+      "type": "object",
+      "required": ["trainTypeCode", "trainHead"],
+      "properties": {
+        "serviceCode": {"type": ["string", "null"]},
+        "trainTypeCode": {"type": "string"},
+        "appearTime": {"$ref": "#/definitions/time"},
+        "initialDelay": {"$ref": "#/definitions/delay"},
+        "initialSpeed": {"type": "number", "minimum": 0},
+        "speed": {"type": "number", "minimum": 0},
+        "status": {"type": "integer"},
+        "trainHead": {
+          "type": "object",
+          "required": ["trackItem", "previousTI"],
+          "properties": {
+            "trackItem": {"type": "string"},
+            "previousTI": {"type": "string"},
+            "positionOnTI": {"type": "number", "minimum": 0}
+          }
+        }
+      }
+    }
+  }
+}`
+
+// Severities of the problems reported by ValidateFile
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// A ValidationProblem is a problem found in a simulation file.
+//
+// Path is a JSON pointer to the faulty value in the file.
+type ValidationProblem struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+// A ValidationReport is the result of the validation of a simulation file.
+type ValidationReport struct {
+	Valid    bool                `json:"valid"`
+	Errors   int                 `json:"errors"`
+	Warnings int                 `json:"warnings"`
+	Problems []ValidationProblem `json:"problems"`
+}
+
+// add appends a problem to the report
+func (vr *ValidationReport) add(severity, code, path, format string, args ...interface{}) {
+	vr.Problems = append(vr.Problems, ValidationProblem{
+		Severity: severity,
+		Code:     code,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == SeverityError {
+		vr.Errors++
+	} else {
+		vr.Warnings++
+	}
+}
+
+// ValidateFile checks the simulation file data against FileSchema and the
+// rules that the schema cannot express: links between track items, routes
+// between existing signals, places reachable from an entry point, and
+// references of services, trains and sections to other objects.
+//
+// The file is finally loaded in a scratch simulation when no error has been
+// found so that problems detected by the loader are reported too.
+func ValidateFile(data []byte) *ValidationReport {
+	report := &ValidationReport{Problems: []ValidationProblem{}}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		report.add(SeverityError, "invalid_json", "", "invalid JSON: %s", err)
+		report.Valid = false
+		return report
+	}
+	validateSchema(report, fileSchema(), doc, "")
+	if report.Errors == 0 {
+		root := doc.(map[string]interface{})
+		checkFileLinks(report, root)
+		checkFileRoutes(report, root)
+		checkFileReferences(report, root)
+		checkFilePlaces(report, root)
+	}
+	if report.Errors == 0 {
+		loadFile(report, data)
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		if report.Problems[i].Severity != report.Problems[j].Severity {
+			return report.Problems[i].Severity == SeverityError
+		}
+		return false
+	})
+	report.Valid = report.Errors == 0
+	return report
+}
+
+var (
+	parseFileSchema  sync.Once
+	parsedFileSchema map[string]interface{}
+	schemaPatterns   = make(map[string]*regexp.Regexp)
+)
+
+// fileSchema returns FileSchema decoded
+func fileSchema() map[string]interface{} {
+	parseFileSchema.Do(func() {
+		if err := json.Unmarshal([]byte(FileSchema), &parsedFileSchema); err != nil {
+			panic(fmt.Sprintf("invalid simulation file schema: %s", err))
+		}
+		collectSchemaPatterns(parsedFileSchema)
+	})
+	return parsedFileSchema
+}
+
+// collectSchemaPatterns compiles all the patterns of the given schema
+func collectSchemaPatterns(s interface{}) {
+	switch v := s.(type) {
+	case map[string]interface{}:
+		if p, ok := v["pattern"].(string); ok {
+			schemaPatterns[p] = regexp.MustCompile(p)
+		}
+		for _, sub := range v {
+			collectSchemaPatterns(sub)
+		}
+	case []interface{}:
+		for _, sub := range v {
+			collectSchemaPatterns(sub)
+		}
+	}
+}
+
+// jsonPointer returns the JSON pointer of key in the object at path
+func jsonPointer(path string, key interface{}) string {
+	k := fmt.Sprint(key)
+	k = strings.Replace(k, "~", "~0", -1)
+	k = strings.Replace(k, "/", "~1", -1)
+	return path + "/" + k
+}
+
+// jsonType returns the JSON Schema type name of v
+func jsonType(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// sortedKeys returns the keys of m sorted
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateSchema checks v at path against schema s and adds the violations to
+// report. It implements the subset of JSON Schema used by FileSchema.
+func validateSchema(report *ValidationReport, s map[string]interface{}, v interface{}, path string) {
+	if ref, ok := s["$ref"].(string); ok {
+		s = resolveSchemaRef(ref)
+	}
+	if !schemaTypeMatches(s["type"], v) {
+		report.add(SeverityError, "invalid_type", path, "expected %s, got %s", schemaTypeName(s["type"]), jsonType(v))
+		return
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(c, v) {
+		report.add(SeverityError, "invalid_value", path, "expected %v, got %v", c, v)
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report.add(SeverityError, "invalid_value", path, "%v is not one of %v", v, enum)
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if schemaMatches(sub.(map[string]interface{}), v) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			report.add(SeverityError, "invalid_value", path, "value does not match exactly one of the allowed forms")
+		}
+	}
+	switch val := v.(type) {
+	case float64:
+		if m, ok := s["minimum"].(float64); ok && val < m {
+			report.add(SeverityError, "invalid_value", path, "%v is less than %v", val, m)
+		}
+	case string:
+		if m, ok := s["minLength"].(float64); ok && float64(len(val)) < m {
+			report.add(SeverityError, "invalid_value", path, "string is shorter than %v", m)
+		}
+		if p, ok := s["pattern"].(string); ok && !schemaPatterns[p].MatchString(val) {
+			report.add(SeverityError, "invalid_format", path, "%q does not match %s", val, p)
+		}
+	case []interface{}:
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				validateSchema(report, items, item, jsonPointer(path, i))
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := val[r.(string)]; !ok {
+					report.add(SeverityError, "missing_property", jsonPointer(path, r), "missing required property %q", r)
+				}
+			}
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		for _, k := range sortedKeys(val) {
+			if ps, ok := props[k].(map[string]interface{}); ok {
+				validateSchema(report, ps, val[k], jsonPointer(path, k))
+				continue
+			}
+			switch ap := s["additionalProperties"].(type) {
+			case map[string]interface{}:
+				validateSchema(report, ap, val[k], jsonPointer(path, k))
+			case bool:
+				if !ap {
+					report.add(SeverityError, "unknown_property", jsonPointer(path, k), "unknown property %q", k)
+				}
+			}
+		}
+	}
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			validateSchema(report, sub.(map[string]interface{}), v, path)
+		}
+	}
+	if cond, ok := s["if"].(map[string]interface{}); ok && schemaMatches(cond, v) {
+		if then, ok := s["then"].(map[string]interface{}); ok {
+			validateSchema(report, then, v, path)
+		}
+	}
+}
+
+// schemaMatches returns true if v is valid against schema s
+func schemaMatches(s map[string]interface{}, v interface{}) bool {
+	var r ValidationReport
+	validateSchema(&r, s, v, "")
+	return r.Errors == 0
+}
+
+// resolveSchemaRef returns the schema referenced by the local reference ref
+func resolveSchemaRef(ref string) map[string]interface{} {
+	var cur interface{} = fileSchema()
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		cur = cur.(map[string]interface{})[part]
+	}
+	return cur.(map[string]interface{})
+}
+
+// schemaTypeMatches returns true if v has one of the types t, which is
+// either a type name, a list of type names or nil.
+func schemaTypeMatches(t interface{}, v interface{}) bool {
+	vt := jsonType(v)
+	matches := func(name string) bool {
+		return name == vt || name == "number" && vt == "integer"
+	}
+	switch typ := t.(type) {
+	case nil:
+		return true
+	case string:
+		return matches(typ)
+	case []interface{}:
+		for _, name := range typ {
+			if matches(name.(string)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaTypeName returns t as a readable string
+func schemaTypeName(t interface{}) string {
+	if types, ok := t.([]interface{}); ok {
+		names := make([]string, len(types))
+		for i, name := range types {
+			names[i] = name.(string)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonEqual returns true if the decoded JSON values a and b are equal
+func jsonEqual(a, b interface{}) bool {
+	return fmt.Sprintf("%#v", a) == fmt.Sprintf("%#v", b)
+}
+
+// fileObject returns the object of key in the object m, or nil
+func fileObject(m map[string]interface{}, key string) map[string]interface{} {
+	o, _ := m[key].(map[string]interface{})
+	return o
+}
+
+// fileString returns the string of key in the object m, or "" if it is
+// missing or null.
+func fileString(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// linkedTypes are the types of track items that must be linked on both ends
+var linkedTypes = map[string]bool{
+	"LineItem":          true,
+	"InvisibleLinkItem": true,
+	"LevelCrossingItem": true,
+	"SignalItem":        true,
+	"PointsItem":        true,
+}
+
+// fileItemLinks returns the links of the track item ti by attribute name
+func fileItemLinks(ti map[string]interface{}) map[string]string {
+	links := map[string]string{
+		"nextTiId":     fileString(ti, "nextTiId"),
+		"previousTiId": fileString(ti, "previousTiId"),
+	}
+	if fileString(ti, "__type__") == "PointsItem" {
+		links["reverseTiId"] = fileString(ti, "reverseTiId")
+	}
+	return links
+}
+
+// checkFileLinks reports track items linked to missing items, unlinked
+// items and links that are not reciprocated.
+func checkFileLinks(report *ValidationReport, root map[string]interface{}) {
+	items := fileObject(root, "trackItems")
+	for _, id := range sortedKeys(items) {
+		ti := items[id].(map[string]interface{})
+		path := jsonPointer("/trackItems", id)
+		if tiID, ok := ti["tiId"].(string); ok && tiID != id {
+			report.add(SeverityError, "id_mismatch", jsonPointer(path, "tiId"), "tiId %q does not match key %q", tiID, id)
+		}
+		if c := fileString(ti, "conflictTiId"); c != "" && items[c] == nil {
+			report.add(SeverityError, "dangling_link", jsonPointer(path, "conflictTiId"), "conflict item %s does not exist", c)
+		}
+		typ := fileString(ti, "__type__")
+		if !linkedTypes[typ] && typ != "EndItem" {
+			continue
+		}
+		links := fileItemLinks(ti)
+		for _, attr := range []string{"previousTiId", "nextTiId", "reverseTiId"} {
+			target, ok := links[attr]
+			if !ok || typ == "EndItem" && attr == "nextTiId" {
+				continue
+			}
+			if target == "" {
+				report.add(SeverityError, "missing_link", jsonPointer(path, attr), "track item %s is not linked at %s", id, attr)
+				continue
+			}
+			other, ok := items[target].(map[string]interface{})
+			if !ok {
+				report.add(SeverityError, "dangling_link", jsonPointer(path, attr), "linked track item %s does not exist", target)
+				continue
+			}
+			reciprocal := false
+			for _, back := range fileItemLinks(other) {
+				if back == id {
+					reciprocal = true
+					break
+				}
+			}
+			if !reciprocal {
+				report.add(SeverityError, "inconsistent_link", jsonPointer(path, attr), "track item %s is not linked back to %s", target, id)
+			}
+		}
+	}
+}
+
+// checkFileRoutes reports routes between missing signals or through missing
+// points, and signals of unknown types.
+func checkFileRoutes(report *ValidationReport, root map[string]interface{}) {
+	items := fileObject(root, "trackItems")
+	signalTypes := fileObject(fileObject(root, "signalLibrary"), "signalTypes")
+	for _, id := range sortedKeys(items) {
+		ti := items[id].(map[string]interface{})
+		if fileString(ti, "__type__") != "SignalItem" {
+			continue
+		}
+		if st := fileString(ti, "signalType"); signalTypes[st] == nil {
+			report.add(SeverityError, "unknown_signal_type", jsonPointer(jsonPointer("/trackItems", id), "signalType"), "signal type %q is not in the signal library", st)
+		}
+	}
+	isA := func(id, typ string) (exists, ok bool) {
+		ti, exists := items[id].(map[string]interface{})
+		return exists, exists && fileString(ti, "__type__") == typ
+	}
+	routes := fileObject(root, "routes")
+	for _, num := range sortedKeys(routes) {
+		r := routes[num].(map[string]interface{})
+		path := jsonPointer("/routes", num)
+		for _, attr := range []string{"beginSignal", "endSignal"} {
+			sigID := fileString(r, attr)
+			switch exists, ok := isA(sigID, "SignalItem"); {
+			case !exists:
+				report.add(SeverityError, "missing_signal", jsonPointer(path, attr), "route %s references missing signal %s", num, sigID)
+			case !ok:
+				report.add(SeverityError, "not_a_signal", jsonPointer(path, attr), "track item %s of route %s is not a signal", sigID, num)
+			}
+		}
+		for _, attr := range []string{"directions", "flankProtection"} {
+			dirs := fileObject(r, attr)
+			for _, pID := range sortedKeys(dirs) {
+				switch exists, ok := isA(pID, "PointsItem"); {
+				case !exists:
+					report.add(SeverityError, "missing_points", jsonPointer(jsonPointer(path, attr), pID), "route %s references missing points %s", num, pID)
+				case !ok:
+					report.add(SeverityError, "not_points", jsonPointer(jsonPointer(path, attr), pID), "track item %s of route %s is not a points item", pID, num)
+				}
+			}
+		}
+	}
+}
+
+// checkFileReferences reports references of track items, services, trains,
+// sections, transfers, depots and the geo reference to missing objects.
+func checkFileReferences(report *ValidationReport, root map[string]interface{}) {
+	items := fileObject(root, "trackItems")
+	places := make(map[string]bool)
+	for _, ti := range items {
+		if fileString(ti.(map[string]interface{}), "__type__") == "Place" {
+			places[fileString(ti.(map[string]interface{}), "placeCode")] = true
+		}
+	}
+	for _, id := range sortedKeys(items) {
+		ti := items[id].(map[string]interface{})
+		if pc := fileString(ti, "placeCode"); pc != "" && !places[pc] {
+			report.add(SeverityError, "missing_place", jsonPointer(jsonPointer("/trackItems", id), "placeCode"), "place %s does not exist", pc)
+		}
+	}
+	trainTypes := fileObject(root, "trainTypes")
+	for _, code := range sortedKeys(trainTypes) {
+		elements, _ := trainTypes[code].(map[string]interface{})["elements"].([]interface{})
+		for i, e := range elements {
+			if trainTypes[e.(string)] == nil {
+				report.add(SeverityError, "missing_train_type", jsonPointer(jsonPointer(jsonPointer("/trainTypes", code), "elements"), i), "train type %s does not exist", e)
+			}
+		}
+	}
+	services := fileObject(root, "services")
+	for _, code := range sortedKeys(services) {
+		s := services[code].(map[string]interface{})
+		path := jsonPointer("/services", code)
+		if tt := fileString(s, "plannedTrainType"); tt != "" && trainTypes[tt] == nil {
+			report.add(SeverityError, "missing_train_type", jsonPointer(path, "plannedTrainType"), "train type %s does not exist", tt)
+		}
+		if next := fileString(s, "nextService"); next != "" && services[next] == nil {
+			report.add(SeverityError, "missing_service", jsonPointer(path, "nextService"), "service %s does not exist", next)
+		}
+		lines, _ := s["lines"].([]interface{})
+		if len(lines) == 0 {
+			report.add(SeverityWarning, "empty_service", jsonPointer(path, "lines"), "service %s has no lines", code)
+		}
+		for i, l := range lines {
+			if pc := fileString(l.(map[string]interface{}), "placeCode"); !places[pc] {
+				report.add(SeverityError, "missing_place", jsonPointer(jsonPointer(jsonPointer(path, "lines"), i), "placeCode"), "place %s does not exist", pc)
+			}
+		}
+	}
+	trains, _ := root["trains"].([]interface{})
+	for i, t := range trains {
+		tr := t.(map[string]interface{})
+		path := jsonPointer("/trains", i)
+		if sc := fileString(tr, "serviceCode"); sc != "" && services[sc] == nil {
+			report.add(SeverityError, "missing_service", jsonPointer(path, "serviceCode"), "service %s does not exist", sc)
+		}
+		if tt := fileString(tr, "trainTypeCode"); trainTypes[tt] == nil {
+			report.add(SeverityError, "missing_train_type", jsonPointer(path, "trainTypeCode"), "train type %s does not exist", tt)
+		}
+		head := fileObject(tr, "trainHead")
+		for _, attr := range []string{"trackItem", "previousTI"} {
+			if id := fileString(head, attr); items[id] == nil {
+				report.add(SeverityError, "dangling_link", jsonPointer(jsonPointer(path, "trainHead"), attr), "track item %s does not exist", id)
+			}
+		}
+	}
+	for _, key := range []string{"sections", "depots", "controlAreas"} {
+		objs := fileObject(root, key)
+		for _, id := range sortedKeys(objs) {
+			tis, _ := objs[id].(map[string]interface{})["trackItems"].([]interface{})
+			for i, ti := range tis {
+				if items[ti.(string)] == nil {
+					report.add(SeverityError, "dangling_link", jsonPointer(jsonPointer(jsonPointer("/"+key, id), "trackItems"), i), "track item %s does not exist", ti)
+				}
+			}
+		}
+	}
+	geoItems := fileObject(fileObject(fileObject(root, "options"), "geoReference"), "items")
+	for _, id := range sortedKeys(geoItems) {
+		if items[id] == nil {
+			report.add(SeverityError, "dangling_link", jsonPointer("/options/geoReference/items", id), "track item %s does not exist", id)
+		}
+	}
+	transfers := fileObject(root, "transfers")
+	for _, id := range sortedKeys(transfers) {
+		tr := transfers[id].(map[string]interface{})
+		path := jsonPointer("/transfers", id)
+		if pc := fileString(tr, "placeCode"); !places[pc] {
+			report.add(SeverityError, "missing_place", jsonPointer(path, "placeCode"), "place %s does not exist", pc)
+		}
+		for _, attr := range []string{"fromService", "toService"} {
+			if sc := fileString(tr, attr); services[sc] == nil {
+				report.add(SeverityError, "missing_service", jsonPointer(path, attr), "service %s does not exist", sc)
+			}
+		}
+	}
+}
+
+// checkFilePlaces reports places that no train can reach, that is places
+// none of whose lines is connected to an entry point of the layout.
+func checkFilePlaces(report *ValidationReport, root map[string]interface{}) {
+	items := fileObject(root, "trackItems")
+	visited := make(map[string]bool)
+	var queue []string
+	for _, id := range sortedKeys(items) {
+		if fileString(items[id].(map[string]interface{}), "__type__") == "EndItem" {
+			visited[id] = true
+			queue = append(queue, id)
+		}
+	}
+	if len(queue) == 0 {
+		report.add(SeverityWarning, "no_entry_point", "/trackItems", "the layout has no end item where trains can enter")
+		return
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range fileItemLinks(items[id].(map[string]interface{})) {
+			if _, ok := items[next]; ok && !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	reachable := make(map[string]bool)
+	for id := range visited {
+		if pc := fileString(items[id].(map[string]interface{}), "placeCode"); pc != "" {
+			reachable[pc] = true
+		}
+	}
+	for _, id := range sortedKeys(items) {
+		ti := items[id].(map[string]interface{})
+		if fileString(ti, "__type__") != "Place" {
+			continue
+		}
+		if pc := fileString(ti, "placeCode"); !reachable[pc] {
+			report.add(SeverityWarning, "unreachable_place", jsonPointer("/trackItems", id), "place %s is not connected to any entry point", pc)
+		}
+	}
+}
+
+// loadFile loads data in a scratch simulation and initializes its routes,
+// reporting the errors of the loader.
+func loadFile(report *ValidationReport, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			report.add(SeverityError, "load_failed", "", "unable to load simulation: %v", r)
+		}
+	}()
+	var sim Simulation
+	if err := json.Unmarshal(data, &sim); err != nil {
+		report.add(SeverityError, "load_failed", "", "%s", err)
+		return
+	}
+	for _, num := range sortedRouteNums(sim.Routes) {
+		if err := sim.Routes[num].initialize(num); err != nil {
+			report.add(SeverityError, "invalid_route", jsonPointer("/routes", num), "%s", err)
+		}
+	}
+}
+
+// sortedRouteNums returns the keys of routes sorted
+func sortedRouteNums(routes map[string]*Route) []string {
+	nums := make([]string, 0, len(routes))
+	for num := range routes {
+		nums = append(nums, num)
+	}
+	sort.Strings(nums)
+	return nums
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// validateModifiedDemo validates the demo simulation file after applying
+// modify to its decoded content.
+func validateModifiedDemo(modify func(map[string]interface{})) *simulation.ValidationReport {
+	data, _ := ioutil.ReadFile("testdata/demo.json")
+	var doc map[string]interface{}
+	_ = json.Unmarshal(data, &doc)
+	modify(doc)
+	data, _ = json.Marshal(doc)
+	return simulation.ValidateFile(data)
+}
+
+// problemCodes returns the problems of report by path
+func problemCodes(report *simulation.ValidationReport) map[string]string {
+	res := make(map[string]string)
+	for _, p := range report.Problems {
+		res[p.Path] = p.Code
+	}
+	return res
+}
+
+func TestValidateFile(t *testing.T) {
+	Convey("Testing simulation file validation", t, func() {
+		Convey("The schema should be valid JSON", func() {
+			var schema map[string]interface{}
+			So(json.Unmarshal([]byte(simulation.FileSchema), &schema), ShouldBeNil)
+			So(schema["definitions"], ShouldContainKey, "trackItem")
+		})
+		Convey("The demo simulation should be valid", func() {
+			data, _ := ioutil.ReadFile("testdata/demo.json")
+			report := simulation.ValidateFile(data)
+			So(report.Problems, ShouldBeEmpty)
+			So(report.Valid, ShouldBeTrue)
+		})
+		Convey("Invalid JSON should be reported", func() {
+			report := simulation.ValidateFile([]byte(`{"options":`))
+			So(report.Valid, ShouldBeFalse)
+			So(report.Problems[0].Code, ShouldEqual, "invalid_json")
+		})
+		Convey("Schema violations should be reported with their path", func() {
+			report := validateModifiedDemo(func(doc map[string]interface{}) {
+				delete(doc, "routes")
+				doc["options"].(map[string]interface{})["version"] = "0.5"
+				doc["options"].(map[string]interface{})["currentTime"] = "6h"
+				doc["trackItems"].(map[string]interface{})["7"].(map[string]interface{})["__type__"] = "SwitchItem"
+				doc["trackItems"].(map[string]interface{})["5"].(map[string]interface{})["reverse"] = "no"
+				doc["trains"].([]interface{})[0].(map[string]interface{})["initialSpeed"] = -1
+			})
+			So(report.Valid, ShouldBeFalse)
+			So(report.Errors, ShouldEqual, 6)
+			So(problemCodes(report), ShouldResemble, map[string]string{
+				"/routes":                "missing_property",
+				"/options/version":       "invalid_value",
+				"/options/currentTime":   "invalid_format",
+				"/trackItems/7/__type__": "invalid_value",
+				"/trackItems/5/reverse":  "invalid_type",
+				"/trains/0/initialSpeed": "invalid_value",
+			})
+		})
+		Convey("Dangling item links should be reported", func() {
+			report := validateModifiedDemo(func(doc map[string]interface{}) {
+				delete(doc["trackItems"].(map[string]interface{}), "4")
+				doc["trackItems"].(map[string]interface{})["7"].(map[string]interface{})["reverseTiId"] = nil
+			})
+			So(report.Valid, ShouldBeFalse)
+			codes := problemCodes(report)
+			So(codes["/trackItems/3/previousTiId"], ShouldEqual, "dangling_link")
+			So(codes["/trackItems/5/previousTiId"], ShouldEqual, "dangling_link")
+			So(codes["/trackItems/7/reverseTiId"], ShouldEqual, "missing_link")
+			So(codes["/trackItems/14/previousTiId"], ShouldEqual, "inconsistent_link")
+		})
+		Convey("Inconsistent links should be reported", func() {
+			data, _ := ioutil.ReadFile("testdata/badlinks.json")
+			report := simulation.ValidateFile(data)
+			So(report.Valid, ShouldBeFalse)
+			So(problemCodes(report), ShouldContainKey, "/trackItems/1/previousTiId")
+			So(report.Problems[0].Code, ShouldEqual, "inconsistent_link")
+		})
+		Convey("Routes referencing missing signals should be reported", func() {
+			report := validateModifiedDemo(func(doc map[string]interface{}) {
+				routes := doc["routes"].(map[string]interface{})
+				routes["1"].(map[string]interface{})["beginSignal"] = "999"
+				routes["2"].(map[string]interface{})["endSignal"] = "7"
+				routes["3"].(map[string]interface{})["directions"] = map[string]interface{}{"8": 0}
+			})
+			So(report.Valid, ShouldBeFalse)
+			codes := problemCodes(report)
+			So(codes["/routes/1/beginSignal"], ShouldEqual, "missing_signal")
+			So(codes["/routes/2/endSignal"], ShouldEqual, "not_a_signal")
+			So(codes["/routes/3/directions/8"], ShouldEqual, "not_points")
+		})
+		Convey("Routes that cannot be set should be reported", func() {
+			data, _ := ioutil.ReadFile("testdata/badroutes.json")
+			report := simulation.ValidateFile(data)
+			So(report.Valid, ShouldBeFalse)
+			So(problemCodes(report)["/routes/1"], ShouldEqual, "invalid_route")
+		})
+		Convey("References to missing objects should be reported", func() {
+			report := validateModifiedDemo(func(doc map[string]interface{}) {
+				lines := doc["services"].(map[string]interface{})["S001"].(map[string]interface{})["lines"].([]interface{})
+				lines[1].(map[string]interface{})["placeCode"] = "XXX"
+				doc["trains"].([]interface{})[0].(map[string]interface{})["trainTypeCode"] = "HST"
+				doc["trackItems"].(map[string]interface{})["5"].(map[string]interface{})["signalType"] = "FR_BAL"
+				doc["options"].(map[string]interface{})["geoReference"] = map[string]interface{}{
+					"items": map[string]interface{}{"999": []interface{}{map[string]float64{"lat": 45, "lon": 5}}},
+				}
+			})
+			So(report.Valid, ShouldBeFalse)
+			codes := problemCodes(report)
+			So(codes["/services/S001/lines/1/placeCode"], ShouldEqual, "missing_place")
+			So(codes["/trains/0/trainTypeCode"], ShouldEqual, "missing_train_type")
+			So(codes["/trackItems/5/signalType"], ShouldEqual, "unknown_signal_type")
+			So(codes["/options/geoReference/items/999"], ShouldEqual, "dangling_link")
+		})
+		Convey("Unreachable places should be reported as warnings", func() {
+			report := validateModifiedDemo(func(doc map[string]interface{}) {
+				doc["trackItems"].(map[string]interface{})["99"] = map[string]interface{}{
+					"__type__":  "Place",
+					"tiId":      "99",
+					"name":      "ISLAND",
+					"placeCode": "ISL",
+					"x":         0,
+					"y":         100,
+				}
+			})
+			So(report.Valid, ShouldBeTrue)
+			So(report.Warnings, ShouldEqual, 1)
+			So(report.Problems[0], ShouldResemble, simulation.ValidationProblem{
+				Severity: simulation.SeverityWarning,
+				Code:     "unreachable_place",
+				Path:     "/trackItems/99",
+				Message:  "place ISL is not connected to any entry point",
+			})
+		})
+	})
+}
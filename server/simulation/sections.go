@@ -0,0 +1,286 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxApproachItems is the maximum number of track items looked ahead of a
+// train to find whether it is approaching a section.
+const maxApproachItems = 200
+
+// A Section is a named group of track items, such as a block section, a
+// station area or the area controlled by a signaller. Sections are defined in
+// the simulation file or added at runtime.
+//
+// A section with SingleLine set is a single track worked in both directions.
+// Routes are only set over it in the direction it is locked in, see
+// Direction. InitialDirection is the direction it is locked in when the
+// simulation is loaded.
+type Section struct {
+	Name             string              `json:"name"`
+	TrackItemIDs     []string            `json:"trackItems"`
+	SingleLine       bool                `json:"singleLine,omitempty"`
+	InitialDirection SingleLineDirection `json:"initialDirection,omitempty"`
+
+	sectionID  string
+	simulation *Simulation
+	items      map[string]bool
+	placeCode  string
+	upItems    map[string]bool
+	// exits holds the ID of the item through which trains leave this single
+	// line in each direction.
+	exits map[SingleLineDirection]string
+	// direction is the direction this single line is locked in, and
+	// acceptingEnd the ID of the item at the end of the line that accepts
+	// the trains running in this direction.
+	direction    SingleLineDirection
+	acceptingEnd string
+}
+
+// ID returns the unique identifier of this section
+func (s *Section) ID() string {
+	return s.sectionID
+}
+
+// PlaceCode returns the code of the place this section was derived from, or an
+// empty string if this section is explicitly defined.
+func (s *Section) PlaceCode() string {
+	return s.placeCode
+}
+
+// TrackItems returns the track items of this section
+func (s *Section) TrackItems() []TrackItem {
+	res := make([]TrackItem, 0, len(s.TrackItemIDs))
+	for _, id := range s.TrackItemIDs {
+		res = append(res, s.simulation.TrackItems[id])
+	}
+	return res
+}
+
+// Contains returns true if the given track item belongs to this section
+func (s *Section) Contains(ti TrackItem) bool {
+	if ti == nil {
+		return false
+	}
+	return s.items[ti.ID()]
+}
+
+// initialize checks the section items and builds its index
+func (s *Section) initialize(sim *Simulation, id string) error {
+	s.simulation = sim
+	s.sectionID = id
+	if len(s.TrackItemIDs) == 0 {
+		return fmt.Errorf("section %s has no track items", id)
+	}
+	s.items = make(map[string]bool)
+	for _, tiID := range s.TrackItemIDs {
+		if _, ok := sim.TrackItems[tiID]; !ok {
+			return fmt.Errorf("unknown track item %s in section %s", tiID, id)
+		}
+		s.items[tiID] = true
+	}
+	if s.Name == "" {
+		s.Name = id
+	}
+	if s.SingleLine {
+		return s.initializeSingleLine()
+	}
+	return nil
+}
+
+// TrainsInside returns the active trains whose head is in this section
+func (s *Section) TrainsInside() []*Train {
+	var res []*Train
+	for _, t := range s.trainsPresent() {
+		if t.IsActive() && s.Contains(t.TrainHead.TrackItem()) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// trainsPresent returns the trains present on the items of this section,
+// sorted by ID.
+func (s *Section) trainsPresent() []*Train {
+	ids := make([]string, 0, len(s.items))
+	for id := range s.items {
+		ids = append(ids, id)
+	}
+	return s.simulation.trainsOnItems(ids)
+}
+
+// A SectionApproach describes an active train that will enter a section on its
+// current path.
+type SectionApproach struct {
+	Train *Train
+	// Distance is the distance in meters between the train head and the section.
+	Distance float64
+	// ETA is the estimated running time to the section at the line speed, or -1
+	// if the train is held before the section.
+	ETA time.Duration
+	// StopSignal is the first signal at danger between the train and the
+	// section, if any.
+	StopSignal *SignalItem
+
+	entry Position
+}
+
+// IncomingTrains returns the active trains outside this section that will
+// reach it by following the current points directions, within maxDistance
+// meters, sorted by distance.
+func (s *Section) IncomingTrains(maxDistance float64) []SectionApproach {
+	var res []SectionApproach
+	for _, t := range s.simulation.Trains {
+		if !t.IsActive() || s.Contains(t.TrainHead.TrackItem()) {
+			continue
+		}
+		if sa, ok := s.approach(t, maxDistance); ok {
+			res = append(res, sa)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Distance < res[j].Distance
+	})
+	return res
+}
+
+// approach follows the path ahead of train t and returns its approach to this
+// section if it reaches it within maxDistance.
+func (s *Section) approach(t *Train, maxDistance float64) (SectionApproach, bool) {
+	sa := SectionApproach{Train: t}
+	var running float64
+	held := t.IsHeld()
+	cur := t.TrainHead
+	ti := cur.TrackItem()
+	if ti.Type() == TypeEnd {
+		return sa, false
+	}
+	remaining := ti.RealLength() - cur.PositionOnTI
+	for i := 0; i < maxApproachItems; i++ {
+		speed := math.Min(t.TrainType().balancingSpeed(cur.resistance()), ti.MaxSpeed())
+		if speed <= 0 {
+			held = true
+		} else {
+			running += remaining / speed
+		}
+		sa.Distance += remaining
+		if sa.Distance > maxDistance {
+			return sa, false
+		}
+		cur = cur.Next(DirectionCurrent)
+		ti = cur.TrackItem()
+		if ti == nil || ti.Type() == TypeEnd {
+			return sa, false
+		}
+		if s.Contains(ti) {
+			sa.entry = cur
+			sa.ETA = time.Duration(running * float64(time.Second))
+			if held || sa.StopSignal != nil {
+				sa.ETA = -1
+			}
+			return sa, true
+		}
+		if si, ok := ti.(*SignalItem); ok && sa.StopSignal == nil && si.IsOnPosition(cur) && !si.ActiveAspect().MeansProceed() {
+			sa.StopSignal = si
+		}
+		remaining = ti.RealLength()
+	}
+	return sa, false
+}
+
+// Sections returns the explicitly defined sections of the simulation, sorted by ID.
+func (sim *Simulation) Sections() []*Section {
+	sim.sectionsMutex.RLock()
+	defer sim.sectionsMutex.RUnlock()
+	res := make([]*Section, 0, len(sim.sections))
+	for _, s := range sim.sections {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].sectionID < res[j].sectionID
+	})
+	return res
+}
+
+// Section returns the section with the given ID.
+//
+// If no section is defined with this ID but id is the code of a place, a
+// section made of the track items of this place is returned.
+func (sim *Simulation) Section(id string) (*Section, bool) {
+	sim.sectionsMutex.RLock()
+	s, ok := sim.sections[id]
+	sim.sectionsMutex.RUnlock()
+	if ok {
+		return s, true
+	}
+	pl, ok := sim.Places[id]
+	if !ok {
+		return nil, false
+	}
+	var ids []string
+	for tiID, ti := range sim.TrackItems {
+		if ti.Type() != TypePlace && ti.Place() != nil && ti.Place().PlaceCode == id {
+			ids = append(ids, tiID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, false
+	}
+	sort.Strings(ids)
+	s = &Section{Name: pl.Name(), TrackItemIDs: ids, placeCode: id}
+	if err := s.initialize(sim, id); err != nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// AddSection checks the given section and adds it to the simulation with the
+// given ID, replacing any section with the same ID.
+func (sim *Simulation) AddSection(id string, s *Section) error {
+	if id == "" {
+		return fmt.Errorf("section ID is required")
+	}
+	if err := s.initialize(sim, id); err != nil {
+		return err
+	}
+	sim.sectionsMutex.Lock()
+	defer sim.sectionsMutex.Unlock()
+	if sim.sections == nil {
+		sim.sections = make(map[string]*Section)
+	}
+	sim.sections[id] = s
+	return nil
+}
+
+// RemoveSection removes the section with the given ID from the simulation
+func (sim *Simulation) RemoveSection(id string) error {
+	sim.sectionsMutex.Lock()
+	defer sim.sectionsMutex.Unlock()
+	if _, ok := sim.sections[id]; !ok {
+		return fmt.Errorf("unknown section: %s", id)
+	}
+	delete(sim.sections, id)
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSections(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing sections", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		sim.Trains[0].AppearTime = simulation.ParseTime("05:00:00")
+		sim.Step()
+		So(sim.Trains[0].IsActive(), ShouldBeTrue)
+		Convey("Sections should be checked", func() {
+			So(sim.AddSection("bad", &simulation.Section{TrackItemIDs: []string{"8", "999"}}), ShouldNotBeNil)
+			So(sim.AddSection("empty", &simulation.Section{}), ShouldNotBeNil)
+			So(sim.AddSection("", &simulation.Section{TrackItemIDs: []string{"8"}}), ShouldNotBeNil)
+			So(sim.Sections(), ShouldBeEmpty)
+		})
+		Convey("Trains should be found inside and approaching sections", func() {
+			So(sim.AddSection("APP", &simulation.Section{Name: "Station approach", TrackItemIDs: []string{"8", "9", "10"}}), ShouldBeNil)
+			So(sim.AddSection("LFT", &simulation.Section{TrackItemIDs: []string{"2"}}), ShouldBeNil)
+			So(sim.AddSection("BRANCH", &simulation.Section{TrackItemIDs: []string{"16"}}), ShouldBeNil)
+			So(sim.Sections(), ShouldHaveLength, 3)
+			lft, ok := sim.Section("LFT")
+			So(ok, ShouldBeTrue)
+			So(lft.Name, ShouldEqual, "LFT")
+			So(lft.TrainsInside(), ShouldHaveLength, 1)
+			So(lft.IncomingTrains(5000), ShouldBeEmpty)
+			app, _ := sim.Section("APP")
+			So(app.TrainsInside(), ShouldBeEmpty)
+			incoming := app.IncomingTrains(5000)
+			So(incoming, ShouldHaveLength, 1)
+			So(incoming[0].Train.ID(), ShouldEqual, "0")
+			So(incoming[0].Distance, ShouldBeGreaterThan, 0)
+			So(app.IncomingTrains(incoming[0].Distance-1), ShouldBeEmpty)
+			branch, _ := sim.Section("BRANCH")
+			So(branch.IncomingTrains(5000), ShouldBeEmpty)
+			So(sim.RemoveSection("BRANCH"), ShouldBeNil)
+			So(sim.RemoveSection("BRANCH"), ShouldNotBeNil)
+		})
+		Convey("Places should be usable as sections", func() {
+			stn, ok := sim.Section("STN")
+			So(ok, ShouldBeTrue)
+			So(stn.PlaceCode(), ShouldEqual, "STN")
+			So(stn.Contains(sim.TrackItems["10"]), ShouldBeTrue)
+			So(stn.Contains(sim.TrackItems["16"]), ShouldBeTrue)
+			So(stn.Contains(sim.TrackItems["8"]), ShouldBeFalse)
+			_, ok = sim.Section("UNKNOWN")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Sections should be saved with the simulation", func() {
+			So(sim.AddSection("APP", &simulation.Section{Name: "Station approach", TrackItemIDs: []string{"8", "9", "10"}}), ShouldBeNil)
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			drainEvents(clone, endChan)
+			s, ok := clone.Section("APP")
+			So(ok, ShouldBeTrue)
+			So(s.Name, ShouldEqual, "Station approach")
+			So(s.TrackItemIDs, ShouldResemble, []string{"8", "9", "10"})
+			clone.Close()
+		})
+	})
+}
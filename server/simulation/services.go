@@ -54,10 +54,39 @@ type ServiceLine struct {
 	ScheduledArrivalTime   Time   `json:"scheduledArrivalTime"`
 	ScheduledDepartureTime Time   `json:"scheduledDepartureTime"`
 	TrackCode              string `json:"trackCode"`
+	// OriginalTrackCode is the track code of the timetable when the line has
+	// been assigned to another platform.
+	OriginalTrackCode string `json:"originalTrackCode,omitempty"`
+	// ExpectedDepartureTime is the departure time predicted by an external
+	// traffic management system, if it is later than the timetable.
+	ExpectedDepartureTime *Time `json:"expectedDepartureTime,omitempty"`
 
 	service *Service
 }
 
+// MarshalJSON for the ServiceLine type
+func (sl *ServiceLine) MarshalJSON() ([]byte, error) {
+	type auxServiceLine ServiceLine
+	var sim *Simulation
+	if sl.service != nil {
+		sim = sl.service.simulation
+	}
+	line := struct {
+		*auxServiceLine
+		ScheduledArrivalTime   string `json:"scheduledArrivalTime"`
+		ScheduledDepartureTime string `json:"scheduledDepartureTime"`
+		ExpectedDepartureTime  string `json:"expectedDepartureTime,omitempty"`
+	}{
+		auxServiceLine:         (*auxServiceLine)(sl),
+		ScheduledArrivalTime:   formatObjectTime(sim, sl.ScheduledArrivalTime.Time),
+		ScheduledDepartureTime: formatObjectTime(sim, sl.ScheduledDepartureTime.Time),
+	}
+	if sl.ExpectedDepartureTime != nil {
+		line.ExpectedDepartureTime = formatObjectTime(sim, sl.ExpectedDepartureTime.Time)
+	}
+	return json.Marshal(line)
+}
+
 // Place associated with this service line
 func (sl *ServiceLine) Place() *Place {
 	return sl.service.simulation.Places[sl.PlaceCode]
@@ -73,6 +102,17 @@ type Service struct {
 	Lines                []*ServiceLine   `json:"lines"`
 	PlannedTrainTypeCode string           `json:"plannedTrainType"`
 	PostActions          []*ServiceAction `json:"postActions"`
+	Priority             TrainPriority    `json:"priority"`
+	// NextServiceCode is the service planned for the train of this service
+	// after it, when services are linked automatically at their terminus.
+	NextServiceCode string `json:"nextService,omitempty"`
+	// CycleMinutes is the time between two runs of a circular service. When
+	// set, the trains of the service start again at its first line after its
+	// last one, until they are withdrawn.
+	CycleMinutes int `json:"cycleMinutes,omitempty"`
+	// Calendar defines the days on which the service runs. A service without
+	// calendar runs every day.
+	Calendar *ServiceCalendar `json:"calendar,omitempty"`
 
 	simulation *Simulation
 }
@@ -99,6 +139,16 @@ func (s *Service) initialize(code string) {
 	for _, line := range s.Lines {
 		line.service = s
 	}
+	s.normalizeTimes()
+}
+
+// addService adds s to the simulation with the given code, and notifies
+// clients of the new service.
+func (sim *Simulation) addService(code string, s *Service) {
+	s.setSimulation(sim)
+	s.initialize(code)
+	sim.Services[code] = s
+	sim.sendEvent(&Event{Name: ServiceChangedEvent, Object: s})
 }
 
 // MarshalJSON for the Service type
@@ -109,6 +159,10 @@ func (s *Service) MarshalJSON() ([]byte, error) {
 		Lines                []*ServiceLine   `json:"lines"`
 		PlannedTrainTypeCode string           `json:"plannedTrainType"`
 		PostActions          []*ServiceAction `json:"postActions"`
+		Priority             TrainPriority    `json:"priority"`
+		NextServiceCode      string           `json:"nextService,omitempty"`
+		CycleMinutes         int              `json:"cycleMinutes,omitempty"`
+		Calendar             *ServiceCalendar `json:"calendar,omitempty"`
 	}
 	as := auxService{
 		ID:                   s.ID(),
@@ -116,6 +170,10 @@ func (s *Service) MarshalJSON() ([]byte, error) {
 		Lines:                s.Lines,
 		PlannedTrainTypeCode: s.PlannedTrainTypeCode,
 		PostActions:          s.PostActions,
+		Priority:             s.Priority,
+		NextServiceCode:      s.NextServiceCode,
+		CycleMinutes:         s.CycleMinutes,
+		Calendar:             s.Calendar,
 	}
 	d, err := json.Marshal(as)
 	return d, err
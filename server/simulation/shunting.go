@@ -0,0 +1,145 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+// ShuntingSpeed is the maximum speed of a train performing a shunting
+// movement, in m/s (25 km/h).
+const ShuntingSpeed float64 = 25 / 3.6
+
+// Shunt starts a shunting movement of this train through the given waypoints
+// (see FindRoutePath), typically to reposition empty stock into a siding or
+// onto another platform between two services.
+//
+// The train must be stopped and empty. While shunting, it runs at no more
+// than ShuntingSpeed, keeps no safety distance with other trains and does not
+// call at the places of its service. The movement ends when the train stops
+// at the end signal of the path, and the train then resumes its service, if
+// any.
+func (t *Train) Shunt(waypoints ...string) (*RoutePath, error) {
+	if err := t.checkCanShunt(); err != nil {
+		return nil, err
+	}
+	path, err := t.Reroute(waypoints...)
+	if err != nil {
+		return nil, err
+	}
+	t.startShunting(path)
+	return path, nil
+}
+
+// checkCanShunt returns an error if this train cannot start a shunting
+// movement.
+func (t *Train) checkCanShunt() error {
+	if !t.IsActive() {
+		return fmt.Errorf("train %s is not active", t.ID())
+	}
+	if t.Speed > minRunningSpeed {
+		return fmt.Errorf("train %s is not stopped", t.ID())
+	}
+	if math.Round(t.Passengers) > 0 {
+		return fmt.Errorf("train %s has passengers on board", t.ID())
+	}
+	return nil
+}
+
+// startShunting starts the shunting movement of this train along path, whose
+// routes are already set.
+func (t *Train) startShunting(path *RoutePath) {
+	t.shunting = true
+	t.shuntRoute = path.Routes[len(path.Routes)-1].ID()
+	t.Status = Running
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s shunting to signal %s", t.ServiceCode, path.Routes[len(path.Routes)-1].EndSignalId), simulationMsg)
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+}
+
+// DistanceToShuntEnd returns the distance in meters from the head of this
+// train to the end signal of its shunting movement, if this signal is within
+// maxDistance. The second value is false if the train is not shunting or if
+// the end signal is further away.
+func (t *Train) DistanceToShuntEnd(maxDistance float64) (float64, bool) {
+	if !t.shunting {
+		return 0, false
+	}
+	r, ok := t.simulation.Routes[t.shuntRoute]
+	if !ok {
+		return 0, false
+	}
+	end := r.Positions[len(r.Positions)-1]
+	pos := t.TrainHead
+	distance := pos.TrackItem().RealLength() - pos.PositionOnTI
+	for pos.TrackItem().Type() != TypeEnd && distance < maxDistance {
+		pos = pos.Next(DirectionCurrent)
+		if pos.TrackItemID == end.TrackItemID {
+			return distance, true
+		}
+		distance += pos.TrackItem().RealLength()
+	}
+	return 0, false
+}
+
+// updateShuntingStatus updates the status of a shunting train and ends the
+// shunting movement once the train has stopped on the last route of its path.
+func (t *Train) updateShuntingStatus() {
+	if t.Speed > minRunningSpeed {
+		t.Status = Running
+		return
+	}
+	if !t.shuntCompleted() {
+		t.Status = Waiting
+		return
+	}
+	t.shunting = false
+	t.shuntRoute = ""
+	t.Status = Waiting
+	if t.Service() != nil && t.NextPlaceIndex != NoMorePlace {
+		if pl := t.TrainHead.TrackItem().Place(); pl != nil && pl.PlaceCode == t.Service().Lines[t.NextPlaceIndex].PlaceCode {
+			// The train has been brought to the next place of its service
+			t.Status = Stopped
+			t.StoppedTime = 0
+		}
+	}
+	t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s shunting movement completed", t.ServiceCode), simulationMsg)
+	if t.depot != "" {
+		t.stable()
+	}
+}
+
+// shuntCompleted returns true if the head of this train is on the last route
+// of its shunting movement.
+func (t *Train) shuntCompleted() bool {
+	r, ok := t.simulation.Routes[t.shuntRoute]
+	if !ok {
+		// The route does not exist anymore, nothing to wait for
+		return true
+	}
+	for _, pos := range r.Positions[1:] {
+		if pos.TrackItemID == t.TrainHead.TrackItemID {
+			return true
+		}
+	}
+	return false
+}
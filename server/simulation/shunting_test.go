@@ -0,0 +1,92 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestShunting(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing shunting movements", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		train := sim.Trains[0]
+		// Keep the tracks free for the shunting movements
+		So(sim.Trains[1].Cancel(), ShouldBeNil)
+		// Train 0 ends S001 at STN track 1 and then waits for S002
+		So(stepUntil(&sim, 3000, func() bool { return train.ServiceCode == "S002" }), ShouldBeTrue)
+		So(train.Status, ShouldEqual, simulation.Stopped)
+		So(train.IsShunting(), ShouldBeFalse)
+		Convey("Inactive, running or loaded trains cannot shunt", func() {
+			_, err := sim.Trains[1].Shunt("3")
+			So(err, ShouldNotBeNil)
+			train.Passengers = 50
+			_, err = train.Shunt("3")
+			So(err, ShouldNotBeNil)
+			So(train.IsShunting(), ShouldBeFalse)
+			train.Passengers = 0
+			_, err = train.Shunt("XXX")
+			So(err, ShouldNotBeNil)
+			So(train.IsShunting(), ShouldBeFalse)
+		})
+		Convey("A train can be shunted to another platform", func() {
+			path, err := train.Shunt("3")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"3"})
+			So(train.IsShunting(), ShouldBeTrue)
+			So(train.Status, ShouldEqual, simulation.Running)
+			var maxSpeed float64
+			So(stepUntil(&sim, 500, func() bool {
+				if train.Speed > maxSpeed {
+					maxSpeed = train.Speed
+				}
+				return !train.IsShunting()
+			}), ShouldBeTrue)
+			So(maxSpeed, ShouldBeLessThanOrEqualTo, simulation.ShuntingSpeed)
+			// The train stops in front of the exit signal instead of leaving the area
+			So(train.TrainHead.TrackItemID, ShouldEqual, "4")
+			So(train.Status, ShouldEqual, simulation.Waiting)
+			So(train.ServiceCode, ShouldEqual, "S002")
+
+			So(train.Reverse(), ShouldBeNil)
+			path, err = train.Shunt("STN/2")
+			So(err, ShouldBeNil)
+			So(path.RouteIDs(), ShouldResemble, []string{"2"})
+			So(train.IsShunting(), ShouldBeTrue)
+			data, err := json.Marshal(train)
+			So(err, ShouldBeNil)
+			var tj map[string]interface{}
+			So(json.Unmarshal(data, &tj), ShouldBeNil)
+			So(tj["shunting"], ShouldBeTrue)
+			So(stepUntil(&sim, 500, func() bool { return !train.IsShunting() }), ShouldBeTrue)
+			So(train.TrainHead.TrackItemID, ShouldEqual, "16")
+			// The train is at STN, the first place of S002
+			So(train.Status, ShouldEqual, simulation.Stopped)
+			So(train.NextPlaceIndex, ShouldEqual, 0)
+		})
+		Convey("Shunting state should be saved in checkpoints", func() {
+			_, err := train.Shunt("3")
+			So(err, ShouldBeNil)
+			sim.Step()
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			So(clone.Trains[0].IsShunting(), ShouldBeTrue)
+			cp, err := sim.Checkpoint()
+			So(err, ShouldBeNil)
+			restored, err := simulation.RestoreCheckpoint(cp)
+			So(err, ShouldBeNil)
+			So(restored.Trains[0].IsShunting(), ShouldBeTrue)
+		})
+	})
+}
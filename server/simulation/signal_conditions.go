@@ -20,6 +20,7 @@ package simulation
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -104,7 +105,7 @@ func (tnpnr TrainNotPresentOnNextRoute) Solve(item *SignalItem, values []string,
 		return false
 	}
 	for _, pos := range item.nextActiveRoute.Positions {
-		if pos.TrackItem().TrainPresent() {
+		if item.Simulation().blockOccupied(pos.TrackItem()) {
 			return false
 		}
 	}
@@ -132,7 +133,7 @@ func (tnpbns TrainNotPresentBeforeNextSignal) Code() string {
 func (tnpbns TrainNotPresentBeforeNextSignal) Solve(item *SignalItem, values []string, params []string) bool {
 mainLoop:
 	for cur := item.Position(); !cur.IsOut(); cur = cur.Next(DirectionCurrent) {
-		if cur.TrackItem().TrainPresent() {
+		if item.Simulation().blockOccupied(cur.TrackItem()) {
 			return false
 		}
 		if !cur.Equals(item.Position()) && cur.TrackItem().Type() == TypeSignal && cur.TrackItem().IsOnPosition(cur) {
@@ -169,7 +170,7 @@ func (tnpoi TrainNotPresentOnItems) Code() string {
 // Solve returns if the condition is met for the given SignalItem and parameters
 func (tnpoi TrainNotPresentOnItems) Solve(item *SignalItem, values []string, params []string) bool {
 	for _, id := range params {
-		if item.Simulation().TrackItems[id].TrainPresent() {
+		if item.Simulation().blockOccupied(item.Simulation().TrackItems[id]) {
 			return false
 		}
 	}
@@ -205,7 +206,7 @@ func (tpoi TrainPresentOnItems) Code() string {
 // Solve returns if the condition is met for the given SignalItem and parameters
 func (tpoi TrainPresentOnItems) Solve(item *SignalItem, values []string, params []string) bool {
 	for _, id := range params {
-		if !item.Simulation().TrackItems[id].TrainPresent() {
+		if !item.Simulation().TrackItems[id].Occupied() {
 			return false
 		}
 	}
@@ -342,6 +343,94 @@ func (resa RouteExitSignalAspects) SetupTriggers(item *SignalItem, params []stri
 
 // ---------------------------------------------------------------------------------------------------------------
 
+// ApproachReleased is true if the train approaching this signal is close enough to it
+// and running slowly enough for the signal to be cleared. This is used for approach
+// controlled signals which are held at danger until the train has slowed down, for
+// instance in front of a diverging junction.
+//
+// The first custom parameter is the release distance in metres before the signal
+// (defaults to DefaultApproachReleaseDistance) and the second one the release speed
+// in m/s (defaults to the warning speed of the simulation).
+type ApproachReleased struct{}
+
+// Code of the ConditionType, uniquely defines this ConditionType
+func (ar ApproachReleased) Code() string {
+	return "APPROACH_RELEASED"
+}
+
+// approachReleaseParams returns the release distance and speed defined by params,
+// using the default values for the missing ones.
+func approachReleaseParams(item *SignalItem, params []string) (float64, float64) {
+	distance := DefaultApproachReleaseDistance
+	speed := item.Simulation().Options.WarningSpeed
+	if len(params) > 0 {
+		if d, err := strconv.ParseFloat(params[0], 64); err == nil {
+			distance = d
+		}
+	}
+	if len(params) > 1 {
+		if v, err := strconv.ParseFloat(params[1], 64); err == nil {
+			speed = v
+		}
+	}
+	return distance, speed
+}
+
+// Solve returns if the condition is met for the given SignalItem and parameters
+func (ar ApproachReleased) Solve(item *SignalItem, values []string, params []string) bool {
+	train := item.train
+	if train == nil || !train.IsActive() {
+		return false
+	}
+	distance, speed := approachReleaseParams(item, params)
+	return train.Speed <= speed && item.isWithin(train.TrainHead, distance)
+}
+
+// SetupTriggers installs needed triggers for the given SignalItem, with the
+// given Condition.
+func (ar ApproachReleased) SetupTriggers(item *SignalItem, params []string) {
+	item.Simulation().registerApproachControlledSignal(item)
+}
+
+// ---------------------------------------------------------------------------------------------------------------
+
+// LevelCrossingsClosed is true if all the level crossings defined by params have
+// their barriers down.
+type LevelCrossingsClosed struct{}
+
+// Code of the ConditionType, uniquely defines this ConditionType
+func (lcc LevelCrossingsClosed) Code() string {
+	return "LEVEL_CROSSING_CLOSED"
+}
+
+// Solve returns if the condition is met for the given SignalItem and parameters
+func (lcc LevelCrossingsClosed) Solve(item *SignalItem, values []string, params []string) bool {
+	for _, id := range params {
+		lc, ok := item.Simulation().TrackItems[id].(*LevelCrossingItem)
+		if !ok || !lc.IsClosed() {
+			return false
+		}
+	}
+	return true
+}
+
+// SetupTriggers installs needed triggers for the given SignalItem, with the
+// given Condition.
+func (lcc LevelCrossingsClosed) SetupTriggers(item *SignalItem, params []string) {
+	for _, id := range params {
+		lc, ok := item.Simulation().TrackItems[id].(*LevelCrossingItem)
+		if !ok {
+			panic(fmt.Errorf("LevelCrossingsClosed: error in simulation definition.\n"+
+				"SignalItem %s reference unknown LevelCrossingItem %s", item.ID(), id))
+		}
+		lc.addTrigger(func(t TrackItem) {
+			item.updateSignalState()
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------
+
 func init() {
 	signalConditionTypes = make(map[string]ConditionType)
 	nar := NextActiveRoute{}
@@ -364,4 +453,8 @@ func init() {
 	signalConditionTypes[nsa.Code()] = nsa
 	resa := RouteExitSignalAspects{}
 	signalConditionTypes[resa.Code()] = resa
+	ar := ApproachReleased{}
+	signalConditionTypes[ar.Code()] = ar
+	lcc := LevelCrossingsClosed{}
+	signalConditionTypes[lcc.Code()] = lcc
 }
@@ -0,0 +1,147 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"time"
+)
+
+// A SignalFailureMode describes how a failed signal misbehaves.
+type SignalFailureMode string
+
+const (
+	// SignalStuckAtDanger is a signal that shows its most restrictive aspect
+	// whatever the routes set through it.
+	SignalStuckAtDanger SignalFailureMode = "DANGER"
+	// SignalDark is a signal that shows no aspect at all. Drivers treat it
+	// as a signal at danger.
+	SignalDark SignalFailureMode = "DARK"
+)
+
+// defaultSignalMTTR is the repair time of a failed signal when the
+// simulation does not define signalMTTRMinutes.
+const defaultSignalMTTR = 15 * time.Minute
+
+// ParseSignalFailureMode returns the SignalFailureMode with the given name.
+// An empty name gives SignalStuckAtDanger.
+func ParseSignalFailureMode(name string) (SignalFailureMode, error) {
+	switch SignalFailureMode(name) {
+	case "", SignalStuckAtDanger:
+		return SignalStuckAtDanger, nil
+	case SignalDark:
+		return SignalDark, nil
+	}
+	return "", fmt.Errorf("unknown signal failure mode: %s", name)
+}
+
+// FailureMode returns how this signal has failed, or an empty string if it
+// is operational. Signals failed by a SIGNAL_FAILED disruption are stuck at
+// danger.
+func (si *SignalItem) FailureMode() SignalFailureMode {
+	switch {
+	case si.failureMode != "":
+		return si.failureMode
+	case si.failed:
+		return SignalStuckAtDanger
+	}
+	return ""
+}
+
+// FailureCause returns why this signal is malfunctioning, if it is.
+func (si *SignalItem) FailureCause() string {
+	return si.failureCause
+}
+
+// RepairTime returns the simulation time at which this signal will be
+// repaired. It is zero if the signal is not malfunctioning or if it will
+// only be repaired on request.
+func (si *SignalItem) RepairTime() time.Time {
+	return si.repairAt
+}
+
+// Fail makes this signal malfunction with the given mode. The signal is
+// repaired automatically after repairIn, or only when Repair is called if
+// repairIn is 0.
+func (si *SignalItem) Fail(mode SignalFailureMode, cause string, repairIn time.Duration) {
+	si.failureMode = mode
+	si.failureCause = cause
+	si.repairAt = time.Time{}
+	if repairIn > 0 {
+		si.repairAt = si.simulation.Options.CurrentTime.Time.Add(repairIn)
+	}
+	si.updateSignalState()
+	si.simulation.MessageLogger.addMessage(fmt.Sprintf("Signal %s has failed (%s)", si.Name(), mode), simulationMsg)
+	si.simulation.sendEvent(&Event{Name: SignalFailedEvent, Object: si})
+}
+
+// Repair puts this signal back in service after a malfunction. It does not
+// clear SIGNAL_FAILED disruptions, which end with their schedule.
+func (si *SignalItem) Repair() {
+	if si.failureMode == "" {
+		return
+	}
+	si.failureMode = ""
+	si.failureCause = ""
+	si.repairAt = time.Time{}
+	si.updateSignalState()
+	si.simulation.MessageLogger.addMessage(fmt.Sprintf("Signal %s has been repaired", si.Name()), simulationMsg)
+	si.simulation.sendEvent(&Event{Name: SignalRepairedEvent, Object: si})
+}
+
+// SignalMTTR returns the mean time to repair a failed signal.
+func (sim *Simulation) SignalMTTR() time.Duration {
+	if sim.Options.SignalMTTRMinutes <= 0 {
+		return defaultSignalMTTR
+	}
+	return time.Duration(sim.Options.SignalMTTRMinutes) * time.Minute
+}
+
+// updateSignalFailures repairs the signals whose repair time has come and
+// makes operational signals fail at random according to the
+// signalFailureRate option. step is the simulation time elapsed since the
+// last update.
+func (sim *Simulation) updateSignalFailures(step time.Duration) {
+	now := sim.Options.CurrentTime.Time
+	// Probability that a given signal fails during this step
+	proba := sim.Options.SignalFailureRate * step.Hours()
+	r := sim.random()
+	for _, ti := range sim.sortedTrackItems() {
+		si, ok := ti.(*SignalItem)
+		if !ok {
+			continue
+		}
+		switch {
+		case si.failureMode != "":
+			if !si.repairAt.IsZero() && !now.Before(si.repairAt) {
+				si.Repair()
+			}
+		case proba > 0 && r.Float64() < proba:
+			mode := SignalStuckAtDanger
+			if r.Intn(2) == 0 {
+				mode = SignalDark
+			}
+			repairIn := time.Duration(r.ExpFloat64() * float64(sim.SignalMTTR()))
+			if repairIn < time.Second {
+				repairIn = time.Second
+			}
+			si.Fail(mode, "Random failure", repairIn)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSignalFailures(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing signal malfunctions", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		si := sim.TrackItems["5"].(*simulation.SignalItem)
+		So(si.ActiveAspect().MeansProceed(), ShouldBeTrue)
+		Convey("Failure modes should be parsed", func() {
+			mode, err := simulation.ParseSignalFailureMode("")
+			So(err, ShouldBeNil)
+			So(mode, ShouldEqual, simulation.SignalStuckAtDanger)
+			mode, err = simulation.ParseSignalFailureMode("DARK")
+			So(err, ShouldBeNil)
+			So(mode, ShouldEqual, simulation.SignalDark)
+			_, err = simulation.ParseSignalFailureMode("GREEN")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Failed signals should show danger until repaired", func() {
+			si.Fail(simulation.SignalDark, "Lamp failure", 0)
+			So(si.Failed(), ShouldBeTrue)
+			So(si.FailureMode(), ShouldEqual, simulation.SignalDark)
+			So(si.FailureCause(), ShouldEqual, "Lamp failure")
+			So(si.RepairTime().IsZero(), ShouldBeTrue)
+			So(si.ActiveAspect().MeansProceed(), ShouldBeFalse)
+			sim.Step()
+			So(si.Failed(), ShouldBeTrue)
+			si.Repair()
+			So(si.Failed(), ShouldBeFalse)
+			So(si.FailureMode(), ShouldBeEmpty)
+			So(si.ActiveAspect().MeansProceed(), ShouldBeTrue)
+		})
+		Convey("Failed signals should be repaired after their repair time", func() {
+			si.Fail(simulation.SignalStuckAtDanger, "Cable fault", 2*time.Second)
+			So(si.RepairTime(), ShouldResemble, sim.Options.CurrentTime.Time.Add(2*time.Second))
+			for i := 0; i < 10 && si.Failed(); i++ {
+				sim.Step()
+			}
+			So(si.Failed(), ShouldBeFalse)
+			So(si.ActiveAspect().MeansProceed(), ShouldBeTrue)
+		})
+		Convey("Malfunctions and disruptions should not clear each other", func() {
+			d := &simulation.Disruption{Type: simulation.DisruptionSignalFailed, TrackItemID: "5"}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(si.FailureMode(), ShouldEqual, simulation.SignalStuckAtDanger)
+			si.Fail(simulation.SignalDark, "Lamp failure", 0)
+			So(sim.RemoveDisruption(d.ID()), ShouldBeNil)
+			So(si.Failed(), ShouldBeTrue)
+			si.Repair()
+			So(si.Failed(), ShouldBeFalse)
+		})
+		Convey("Signals should fail at random with a failure rate", func() {
+			sim.Options.SignalFailureRate = 3600 * 10
+			sim.Options.SignalMTTRMinutes = 30
+			So(sim.SignalMTTR(), ShouldEqual, 30*time.Minute)
+			sim.Step()
+			So(si.Failed(), ShouldBeTrue)
+			So(si.FailureCause(), ShouldEqual, "Random failure")
+			So(si.RepairTime().IsZero(), ShouldBeFalse)
+		})
+	})
+}
@@ -22,7 +22,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"sort"
+	"sync"
 	"time"
 
 	log "gopkg.in/inconshreveable/log15.v2"
@@ -65,10 +67,80 @@ type Simulation struct {
 
 	// internal indexes
 	routesByBeginSignal map[string][]*Route
+	// routeConflicts is the route conflict graph, indexed by route ID
+	routeConflicts map[string][]RouteConflict
 
 	clockTicker *time.Ticker
 	stopChan    chan bool
-	started     bool
+	// runDone is closed when the main loop started by Start returns
+	runDone chan struct{}
+	started bool
+	// fastForwarding is set to 1 while FastForward runs the simulation
+	fastForwarding int32
+	// quiet simulations do not write their messages to the Logger
+	quiet bool
+
+	disruptions            map[string]*Disruption
+	lastDisruptionID       int
+	speedRestrictions      map[string]*SpeedRestriction
+	lastSpeedRestrictionID int
+	possessions            map[string]*Possession
+	lastPossessionID       int
+	// disruptionsMutex protects disruptions, speed restrictions and possessions
+	disruptionsMutex sync.RWMutex
+
+	sections      map[string]*Section
+	sectionsMutex sync.RWMutex
+
+	transfers map[string]*Transfer
+	depots    map[string]*Depot
+
+	trainingScenarios map[string]*TrainingScenario
+
+	controlAreas map[string]*ControlArea
+	// areaOfItem holds the ID of the control area of each track item
+	areaOfItem map[string]string
+
+	// approachControlled holds the approach controlled signals, sorted by ID
+	approachControlled []*SignalItem
+	// levelCrossings holds the level crossings, sorted by ID
+	levelCrossings []*LevelCrossingItem
+
+	suggestionEngine *SuggestionEngine
+
+	perturbationStats map[PerturbationKind]PerturbationStat
+	// perturbationsMutex protects the perturbations configuration and
+	// statistics
+	perturbationsMutex sync.Mutex
+
+	rng       *rand.Rand
+	rngSource *countingSource
+	rngSeed   int64
+	// randMutex protects the random generator
+	randMutex sync.Mutex
+
+	rewindPoints []*RewindPoint
+	// rewindMutex protects the rewind points and their events
+	rewindMutex sync.Mutex
+
+	breakpoints      map[string]*Breakpoint
+	lastBreakpointID int
+	// breakpointsMutex protects the breakpoints
+	breakpointsMutex sync.Mutex
+
+	occupancy *occupancyIndex
+
+	spatial *spatialIndex
+	// spatialMutex protects the creation of the spatial index
+	spatialMutex sync.Mutex
+}
+
+// A SimulationReleaser is a manager that holds state for each simulation.
+//
+// ReleaseSimulation is called when the given simulation is closed so that
+// the manager can discard the state it holds for it.
+type SimulationReleaser interface {
+	ReleaseSimulation(*Simulation)
 }
 
 // UnmarshalJSON for the Simulation type
@@ -84,6 +156,12 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 		Services      map[string]*Service   `json:"services"`
 		Trains        []*Train              `json:"trains"`
 		MessageLogger *MessageLogger        `json:"messageLogger"`
+		Sections      map[string]*Section   `json:"sections"`
+		Transfers     map[string]*Transfer  `json:"transfers"`
+		Depots        map[string]*Depot     `json:"depots"`
+
+		TrainingScenarios map[string]*TrainingScenario `json:"trainingScenarios"`
+		ControlAreas      map[string]*ControlArea      `json:"controlAreas"`
 	}
 
 	sim.EventChan = make(chan *Event)
@@ -126,6 +204,9 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 		case `"InvisibleLinkItem"`:
 			var ti InvisibleLinkItem
 			err = unmarshalItem(&ti)
+		case `"LevelCrossingItem"`:
+			var ti LevelCrossingItem
+			err = unmarshalItem(&ti)
 		case `"EndItem"`:
 			var ti EndItem
 			err = unmarshalItem(&ti)
@@ -159,6 +240,15 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 
 	sim.Options = rawSim.Options
 	sim.Options.simulation = sim
+	if err := sim.rebaseTime(&sim.Options.CurrentTime); err != nil {
+		return fmt.Errorf("error in currentTime: %s", err)
+	}
+	if err := sim.Options.GeoReference.Validate(); err != nil {
+		return fmt.Errorf("error in geoReference: %s", err)
+	}
+	if sim.Options.Seed == 0 {
+		sim.Options.Seed = newSeed()
+	}
 	sim.Routes = make(map[string]*Route)
 	for num, route := range rawSim.Routes {
 		route.setSimulation(sim)
@@ -173,26 +263,43 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 	sim.TrainTypes = rawSim.TrainTypes
 	for ttCode, tt := range sim.TrainTypes {
 		tt.setSimulation(sim)
-		tt.initialize(ttCode)
+		if err := tt.initialize(ttCode); err != nil {
+			return fmt.Errorf("error initializing train type %s: %s", ttCode, err)
+		}
 	}
 
 	sim.Services = rawSim.Services
 	for sCode, s := range sim.Services {
 		s.setSimulation(sim)
+		for _, line := range s.Lines {
+			if err := sim.rebaseServiceLine(line); err != nil {
+				return fmt.Errorf("error in service %s: %s", sCode, err)
+			}
+		}
 		s.initialize(sCode)
+		if err := s.Calendar.Validate(); err != nil {
+			return fmt.Errorf("error in calendar of service %s: %s", sCode, err)
+		}
 	}
 
 	sim.Trains = rawSim.Trains
 	for _, t := range sim.Trains {
 		t.setSimulation(sim)
+		if err := sim.rebaseTime(&t.AppearTime); err != nil {
+			return fmt.Errorf("error in appearTime of train %s: %s", t.ServiceCode, err)
+		}
+	}
+	// Trains split from another one may have no service
+	hasLines := func(t *Train) bool {
+		return t.Service() != nil && len(t.Service().Lines) > 0
 	}
 	sort.Slice(sim.Trains, func(i, j int) bool {
 		switch {
-		case len(sim.Trains[i].Service().Lines) == 0 && len(sim.Trains[j].Service().Lines) == 0:
+		case !hasLines(sim.Trains[i]) && !hasLines(sim.Trains[j]):
 			return sim.Trains[i].ServiceCode < sim.Trains[j].ServiceCode
-		case len(sim.Trains[i].Service().Lines) == 0:
+		case !hasLines(sim.Trains[i]):
 			return false
-		case len(sim.Trains[j].Service().Lines) == 0:
+		case !hasLines(sim.Trains[j]):
 			return true
 		default:
 			return sim.Trains[i].Service().Lines[0].ScheduledDepartureTime.Sub(
@@ -208,58 +315,58 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 			return err
 		}
 	}
+	sim.occupancy = newOccupancyIndex(sim)
 	sim.MessageLogger = rawSim.MessageLogger
 	sim.MessageLogger.setSimulation(sim)
+
+	sim.sections = make(map[string]*Section)
+	for sID, s := range rawSim.Sections {
+		if err := s.initialize(sim, sID); err != nil {
+			return err
+		}
+		sim.sections[sID] = s
+	}
+
+	sim.transfers = make(map[string]*Transfer)
+	for tID, tr := range rawSim.Transfers {
+		if err := tr.initialize(sim, tID); err != nil {
+			return err
+		}
+		sim.transfers[tID] = tr
+	}
+
+	sim.depots = make(map[string]*Depot)
+	for dID, d := range rawSim.Depots {
+		if err := d.initialize(sim, dID); err != nil {
+			return err
+		}
+		sim.depots[dID] = d
+	}
+
+	sim.trainingScenarios = make(map[string]*TrainingScenario)
+	for tsID, ts := range rawSim.TrainingScenarios {
+		if err := ts.initialize(sim, tsID); err != nil {
+			return err
+		}
+		sim.trainingScenarios[tsID] = ts
+	}
+
+	sim.controlAreas = make(map[string]*ControlArea)
+	sim.areaOfItem = make(map[string]string)
+	for caID, ca := range rawSim.ControlAreas {
+		if err := ca.initialize(sim, caID); err != nil {
+			return err
+		}
+		sim.controlAreas[caID] = ca
+	}
 	return nil
 }
 
 // MarshalJSON for the Simulation type
 func (sim Simulation) MarshalJSON() ([]byte, error) {
 	var res bytes.Buffer
-	res.WriteString(`{
-	"__type__": "Simulation",
-`)
-	res.WriteString(`	"messageLogger": `)
-	logr, _ := json.Marshal(sim.MessageLogger)
-	res.Write(logr)
-	res.WriteString(`,
-	"options": `)
-	opts, _ := json.Marshal(sim.Options)
-	res.Write(opts)
-	res.WriteString(`,
-	"routes": `)
-	rtes, _ := json.Marshal(sim.Routes)
-	res.Write(rtes)
-	res.WriteString(`,
-	"trainTypes": `)
-	tts, _ := json.Marshal(sim.TrainTypes)
-	res.Write(tts)
-	res.WriteString(`,
-    "services": `)
-	svs, _ := json.Marshal(sim.Services)
-	res.Write(svs)
-
-	tkis := make(map[string]TrackItem)
-	for k, v := range sim.TrackItems {
-		tkis[k] = v
-	}
-	for _, v := range sim.Places {
-		tkis[v.ID()] = v
-	}
-	res.WriteString(`,
-	"trackItems": `)
-	tkd, _ := json.Marshal(tkis)
-	res.Write(tkd)
-	res.WriteString(`,
-	"trains": `)
-	trns, _ := json.Marshal(sim.Trains)
-	res.Write(trns)
-	res.WriteString(`,
-	"signalLibrary": `)
-	sll, _ := json.Marshal(sim.SignalLib)
-	res.Write(sll)
-	res.WriteString(`}`)
-	return res.Bytes(), nil
+	err := sim.WriteJSON(&res)
+	return res.Bytes(), err
 }
 
 // Initialize initializes the simulation.
@@ -267,13 +374,25 @@ func (sim Simulation) MarshalJSON() ([]byte, error) {
 func (sim *Simulation) Initialize() error {
 	sim.MessageLogger.addMessage("Simulation initializing", softwareMsg)
 
-	for num, r := range sim.Routes {
+	// Routes and signals are initialized in a fixed order so that seeded runs
+	// send the same events.
+	routeNums := make([]string, 0, len(sim.Routes))
+	for num := range sim.Routes {
+		routeNums = append(routeNums, num)
+	}
+	sort.Strings(routeNums)
+	for _, num := range routeNums {
+		r := sim.Routes[num]
 		if err := r.initialize(num); err != nil {
 			return fmt.Errorf("error initializing route %s: %s", r.routeID, err)
 		}
 	}
+	sim.computeRouteConflicts()
+	for _, num := range routeNums {
+		sim.Routes[num].setInitialState()
+	}
 
-	for _, ti := range sim.TrackItems {
+	for _, ti := range sim.sortedTrackItems() {
 		si, ok := ti.(*SignalItem)
 		if !ok {
 			continue
@@ -282,11 +401,11 @@ func (sim *Simulation) Initialize() error {
 	}
 
 	// Initialize suggestion engine and precompute once if enabled
-	if suggestionEngine == nil {
-		suggestionEngine = NewSuggestionEngine(sim)
+	if sim.suggestionEngine == nil {
+		sim.suggestionEngine = NewSuggestionEngine(sim)
 	}
 	if sim.Options.SuggestionsEnabled {
-		suggestionEngine.Recompute()
+		sim.suggestionEngine.Recompute()
 	}
 
 	return nil
@@ -301,14 +420,20 @@ func (sim *Simulation) Start() {
 		Logger.Debug("Simulation already started")
 		return
 	}
+	if sim.IsFastForwarding() {
+		Logger.Warn("Cannot start simulation while it is fast-forwarding")
+		return
+	}
 	sim.started = true
-	go sim.run()
+	sim.runDone = make(chan struct{})
+	go sim.run(sim.runDone)
 	sim.sendEvent(&Event{Name: StateChangedEvent, Object: BoolObject{Value: true}})
 	Logger.Info("Simulation started")
 }
 
-// run enters the main loop of the simulation
-func (sim *Simulation) run() {
+// run enters the main loop of the simulation. It closes done when it returns.
+func (sim *Simulation) run(done chan struct{}) {
+	defer close(done)
 	clockTicker := time.NewTicker(timeStep)
 	for {
 		select {
@@ -316,22 +441,90 @@ func (sim *Simulation) run() {
 			clockTicker.Stop()
 			sim.sendEvent(&Event{Name: StateChangedEvent, Object: BoolObject{Value: false}})
 			Logger.Info("Simulation paused")
+			// Acknowledge the pause
+			sim.stopChan <- true
 			return
 		case <-clockTicker.C:
-			sim.increaseTime(timeStep)
-			sim.sendEvent(&Event{Name: ClockEvent, Object: sim.Options.CurrentTime})
-			sim.updateTrains()
-			// Periodic suggestions recomputation
-			if suggestionEngine != nil {
-				_ = suggestionEngine.RecomputeIfDue()
+			sim.Step()
+			if sim.checkBreakpoints() {
+				clockTicker.Stop()
+				sim.started = false
+				sim.sendEvent(&Event{Name: StateChangedEvent, Object: BoolObject{Value: false}})
+				Logger.Info("Simulation paused at breakpoint")
+				return
 			}
 		}
 	}
 }
 
+// Step processes a single clock tick of the simulation, i.e. advances the
+// simulation time by timeStep multiplied by the time factor, and updates all
+// objects accordingly.
+//
+// Step is called by the clock when the simulation is started. It can also be
+// called directly to drive a simulation that is not started, for instance to
+// run it headless as fast as possible. It must not be called while the
+// simulation is started.
+func (sim *Simulation) Step() {
+	defer sim.startOperation(OperationStep)()
+	sim.increaseTime(timeStep)
+	if !sim.IsFastForwarding() {
+		sim.sendEvent(&Event{Name: ClockEvent, Object: sim.Options.CurrentTime})
+	}
+	sim.updateDisruptions()
+	sim.updateSignalFailures(time.Duration(sim.Options.TimeFactor) * timeStep)
+	sim.updatePointsFailures(time.Duration(sim.Options.TimeFactor) * timeStep)
+	sim.updatePerturbations(time.Duration(sim.Options.TimeFactor) * timeStep)
+	sim.updateTrains()
+	sim.updateLevelCrossings()
+	sim.updateApproachControlledSignals()
+	sim.updateOverlaps()
+	// Periodic suggestions recomputation
+	if sim.suggestionEngine != nil {
+		_ = sim.suggestionEngine.RecomputeIfDue()
+	}
+	sim.recordRewindPoint()
+}
+
+// Tick returns the simulation time by which each Step advances the
+// simulation, that is the clock interval multiplied by the time factor.
+func (sim *Simulation) Tick() time.Duration {
+	return time.Duration(sim.Options.TimeFactor) * timeStep
+}
+
+// Close releases the state that managers hold for this simulation.
+//
+// The simulation must be paused and must not be used after Close is called.
+func (sim *Simulation) Close() {
+	managers := []interface{}{lineItemManager, pointsItemManager, signalItemManager}
+	for _, rm := range routesManagers {
+		managers = append(managers, rm)
+	}
+	for _, tm := range trainsManagers {
+		managers = append(managers, tm)
+	}
+	for _, m := range managers {
+		if r, ok := m.(SimulationReleaser); ok {
+			r.ReleaseSimulation(sim)
+		}
+	}
+}
+
 // Pause holds the simulation by stopping the clock ticker. Call Start again to restart the simulation.
+//
+// Pause returns once the clock is stopped and the stateChanged event has been
+// sent, so that the simulation does not send any event afterwards by itself.
 func (sim *Simulation) Pause() {
-	sim.stopChan <- true
+	if !sim.started {
+		// Already paused, e.g. at a breakpoint
+		return
+	}
+	select {
+	case sim.stopChan <- true:
+		<-sim.stopChan
+	case <-sim.runDone:
+		// The main loop stopped at a breakpoint meanwhile
+	}
 	sim.started = false
 }
 
@@ -343,14 +536,51 @@ func (sim *Simulation) IsStarted() bool {
 // sendEvent sends the given event on the event channel to notify clients.
 // Sending is done asynchronously so as not to block.
 func (sim *Simulation) sendEvent(evt *Event) {
+	sim.recordEvent(evt)
+	sim.encodeEvent(evt)
+	if snapshotter != nil && !sim.quiet {
+		evt.Snapshot = snapshotter(sim, evt)
+	}
 	sim.EventChan <- evt
 }
 
+// encodeEvent sets the Data of evt to the JSON encoding of its object, on the
+// goroutine that changed the object. Events of quiet simulations are not
+// encoded since they are not sent to clients.
+func (sim *Simulation) encodeEvent(evt *Event) {
+	if sim.quiet || evt.Data != nil {
+		return
+	}
+	var data []byte
+	var err error
+	if h, ok := evt.Object.(Time); ok {
+		// Times sent alone, such as with clock events, follow the date mode
+		// of the simulation as the times of its objects.
+		data, err = json.Marshal(formatObjectTime(sim, h.Time))
+	} else {
+		data, err = json.Marshal(evt.Object)
+	}
+	if err != nil {
+		Logger.Error("Unable to encode event object", "event", evt.Name, "error", err)
+		return
+	}
+	evt.Data = data
+}
+
 // increaseTime adds the step to the simulation time.
 func (sim *Simulation) increaseTime(step time.Duration) {
 	sim.Options.CurrentTime.Lock()
 	defer sim.Options.CurrentTime.Unlock()
-	sim.Options.CurrentTime = sim.Options.CurrentTime.Add(time.Duration(sim.Options.TimeFactor) * step)
+	sim.Options.CurrentTime.Time = sim.Options.CurrentTime.Time.Add(time.Duration(sim.Options.TimeFactor) * step)
+}
+
+// CurrentTime returns the simulation time. It holds the lock of the clock so
+// that it can be called from another goroutine than the one running the
+// simulation.
+func (sim *Simulation) CurrentTime() time.Time {
+	sim.Options.CurrentTime.RLock()
+	defer sim.Options.CurrentTime.RUnlock()
+	return sim.Options.CurrentTime.Time
 }
 
 // checks that all TrackItems are linked together.
@@ -369,7 +599,7 @@ func (sim *Simulation) checkTrackItemsLinks() error {
 				return ItemInconsistentLinkError{item1: pi, item2: pi.ReverseItem(), pt: pi.Reverse()}
 			}
 			fallthrough
-		case TypeLine, TypeInvisibleLink, TypeSignal:
+		case TypeLine, TypeInvisibleLink, TypeLevelCrossing, TypeSignal:
 			if ti.NextItem() == nil {
 				return ItemNotLinkedAtError{item: ti, pt: ti.End()}
 			}
@@ -405,7 +635,7 @@ func (sim *Simulation) updateScore(penalty int) {
 	sim.Options.CurrentScore += penalty
 	sim.sendEvent(&Event{
 		Name:   OptionsChangedEvent,
-		Object: sim.Options,
+		Object: &sim.Options,
 	})
 }
 
@@ -0,0 +1,288 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+)
+
+// singleLineLookahead is the distance in meters ahead of trains within which
+// they are considered approaching a single line section.
+const singleLineLookahead float64 = 5000
+
+// A SingleLineDirection is the direction in which a single line section is
+// worked.
+type SingleLineDirection string
+
+const (
+	// SingleLineFree means that the single line is not locked in any direction
+	SingleLineFree SingleLineDirection = ""
+	// SingleLineUp is the direction of the first track item of the section,
+	// from its origin to its end
+	SingleLineUp SingleLineDirection = "UP"
+	// SingleLineDown is the opposite direction
+	SingleLineDown SingleLineDirection = "DOWN"
+)
+
+// itemID returns the ID of ti, or an empty string if ti is nil
+func itemID(ti TrackItem) string {
+	if ti == nil {
+		return ""
+	}
+	return ti.ID()
+}
+
+// initializeSingleLine computes the orientation of each item of this section
+// relative to its first item, and its ends, and locks it in its initial
+// direction. It returns an error if the section is not made of connected
+// items.
+func (s *Section) initializeSingleLine() error {
+	s.upItems = make(map[string]bool)
+	first := s.simulation.TrackItems[s.TrackItemIDs[0]]
+	s.upItems[first.ID()] = true
+	queue := []TrackItem{first}
+	for len(queue) > 0 {
+		ti := queue[0]
+		queue = queue[1:]
+		up := s.upItems[ti.ID()]
+		// Items after ti are entered from ti when running along ti
+		ahead := []TrackItem{ti.NextItem()}
+		if pi, ok := ti.(*PointsItem); ok {
+			ahead = append(ahead, pi.ReverseItem())
+		}
+		for _, next := range ahead {
+			if _, done := s.upItems[itemID(next)]; done || !s.Contains(next) {
+				continue
+			}
+			s.upItems[next.ID()] = up == (itemID(next.PreviousItem()) == ti.ID())
+			queue = append(queue, next)
+		}
+		// The item before ti is left towards ti when running along it
+		prev := ti.PreviousItem()
+		if _, done := s.upItems[itemID(prev)]; done || !s.Contains(prev) {
+			continue
+		}
+		along := itemID(prev.NextItem()) == ti.ID()
+		if pi, ok := prev.(*PointsItem); ok && itemID(pi.ReverseItem()) == ti.ID() {
+			along = true
+		}
+		s.upItems[prev.ID()] = up == along
+		queue = append(queue, prev)
+	}
+	if len(s.upItems) != len(s.items) {
+		return fmt.Errorf("single line section %s is not made of connected track items", s.sectionID)
+	}
+	s.exits = make(map[SingleLineDirection]string)
+	for _, id := range s.TrackItemIDs {
+		ti := s.simulation.TrackItems[id]
+		ahead := []TrackItem{ti.NextItem()}
+		if pi, ok := ti.(*PointsItem); ok {
+			ahead = append(ahead, pi.ReverseItem())
+		}
+		along, against := SingleLineUp, SingleLineDown
+		if !s.upItems[id] {
+			along, against = against, along
+		}
+		for _, next := range ahead {
+			if _, ok := s.exits[along]; !ok && !s.Contains(next) {
+				s.exits[along] = id
+			}
+		}
+		if _, ok := s.exits[against]; !ok && !s.Contains(ti.PreviousItem()) {
+			s.exits[against] = id
+		}
+	}
+	dir := s.InitialDirection
+	if dir == SingleLineFree {
+		dir = s.usedDirection()
+	}
+	return s.lock(dir)
+}
+
+// positionDirection returns the direction of a train or route at pos, which
+// must be on an item of this section.
+func (s *Section) positionDirection(pos Position) SingleLineDirection {
+	along := itemID(pos.TrackItem().PreviousItem()) == pos.PreviousItemID
+	if along == s.upItems[pos.TrackItemID] {
+		return SingleLineUp
+	}
+	return SingleLineDown
+}
+
+// routeDirection returns the direction in which route r runs on this single
+// line section. The begin and end signals of the route are not taken into
+// account so that routes leaving or arriving at the section do not claim it.
+// ok is false if the route does not run on this section.
+func (s *Section) routeDirection(r *Route) (dir SingleLineDirection, ok bool) {
+	for i, pos := range r.Positions {
+		if i == 0 || i == len(r.Positions)-1 || !s.Contains(pos.TrackItem()) {
+			continue
+		}
+		return s.positionDirection(pos), true
+	}
+	return SingleLineFree, false
+}
+
+// trainDirection returns the direction in which train t runs on this single
+// line section. ok is false if the train is not on the section.
+func (s *Section) trainDirection(t *Train) (dir SingleLineDirection, ok bool) {
+	if !t.IsActive() {
+		return SingleLineFree, false
+	}
+	for _, pos := range []Position{t.TrainHead, t.TrainTail()} {
+		if s.Contains(pos.TrackItem()) {
+			return s.positionDirection(pos), true
+		}
+	}
+	return SingleLineFree, false
+}
+
+// usedDirection returns the direction of the routes set over this single line
+// section and of the trains running on it, or SingleLineFree if there are none.
+func (s *Section) usedDirection() SingleLineDirection {
+	for _, r := range s.simulation.Routes {
+		if r.State() == Deactivated {
+			continue
+		}
+		if dir, ok := s.routeDirection(r); ok {
+			return dir
+		}
+	}
+	for _, t := range s.trainsPresent() {
+		if dir, ok := s.trainDirection(t); ok {
+			return dir
+		}
+	}
+	return SingleLineFree
+}
+
+// lock locks this single line section in the given direction
+func (s *Section) lock(dir SingleLineDirection) error {
+	switch dir {
+	case SingleLineFree, SingleLineUp, SingleLineDown:
+	default:
+		return fmt.Errorf("unknown single line direction %s", dir)
+	}
+	s.direction = dir
+	s.acceptingEnd = s.exits[dir]
+	return nil
+}
+
+// Direction returns the direction in which this single line section is
+// locked. A free single line is locked in the direction of the first route
+// set over it, and then keeps its direction, even when no train runs on it,
+// until a route is set over it in the other direction or it is changed with
+// SetDirection.
+func (s *Section) Direction() SingleLineDirection {
+	if !s.SingleLine {
+		return SingleLineFree
+	}
+	return s.direction
+}
+
+// AcceptingEnd returns the ID of the track item at the end of this single line
+// section that accepts the trains running in its direction, i.e. the item
+// through which they leave it, or an empty string if the line is free.
+func (s *Section) AcceptingEnd() string {
+	if !s.SingleLine {
+		return ""
+	}
+	return s.acceptingEnd
+}
+
+// SetDirection locks this single line section in the given direction, or
+// frees it with SingleLineFree. The direction can only be changed when no
+// route is set over the line and no train runs on it.
+func (s *Section) SetDirection(dir SingleLineDirection) error {
+	if !s.SingleLine {
+		return fmt.Errorf("section %s is not a single line", s.sectionID)
+	}
+	if dir == s.direction {
+		return nil
+	}
+	if s.usedDirection() != SingleLineFree {
+		return fmt.Errorf("single line %s is in use", s.Name)
+	}
+	return s.lock(dir)
+}
+
+// accepts returns an error if a route in the given direction cannot be
+// accepted on this single line section, because it is locked in the other
+// direction by a route set over it, or because a train is running on it in
+// the other direction. An empty single line accepts the first route in the
+// other direction, which reverses it.
+func (s *Section) accepts(dir SingleLineDirection) error {
+	for _, t := range s.trainsPresent() {
+		if tDir, ok := s.trainDirection(t); ok && tDir != dir {
+			return fmt.Errorf("single line %s is occupied by train %s running %s", s.Name, t.ServiceCode, tDir)
+		}
+	}
+	if locked := s.Direction(); locked != SingleLineFree && locked != dir && s.usedDirection() != SingleLineFree {
+		return fmt.Errorf("single line %s is locked %s", s.Name, locked)
+	}
+	return nil
+}
+
+// SingleLines returns the single line sections of the simulation, sorted by ID.
+func (sim *Simulation) SingleLines() []*Section {
+	var res []*Section
+	for _, s := range sim.Sections() {
+		if s.SingleLine {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// checkSingleLines returns an error if this route runs on a single line
+// section that is locked by a route or occupied in the other direction.
+func (r *Route) checkSingleLines() error {
+	for _, s := range r.simulation.SingleLines() {
+		dir, ok := s.routeDirection(r)
+		if !ok {
+			continue
+		}
+		if err := s.accepts(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reversesSingleLines returns true if this route runs on a single line
+// section locked in the other direction.
+func (r *Route) reversesSingleLines() bool {
+	for _, s := range r.simulation.SingleLines() {
+		if dir, ok := s.routeDirection(r); ok && s.direction != SingleLineFree && s.direction != dir {
+			return true
+		}
+	}
+	return false
+}
+
+// claimSingleLines locks the single line sections that this route runs on in
+// its direction. They are either free, or empty if locked in the other
+// direction, since the route has been checked with checkSingleLines.
+func (r *Route) claimSingleLines() {
+	for _, s := range r.simulation.SingleLines() {
+		if dir, ok := s.routeDirection(r); ok && s.direction != dir {
+			_ = s.lock(dir)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSingleLines(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with signals in both directions
+	// between signals 101 and 11, and a single line section made of the given
+	// items, locked in the given initial direction. Route 20 runs up from 101
+	// to 103 and route 21 runs down from 107 to 106.
+	loadSim := func(direction string, sectionItems ...string) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			items := raw["trackItems"].(map[string]interface{})
+			signal := func(id, previous, next string, reverse bool, x float64) map[string]interface{} {
+				return map[string]interface{}{
+					"__type__": "SignalItem", "tiId": id, "name": id, "signalType": "UK_3_ASPECTS",
+					"reverse": reverse, "previousTiId": previous, "nextTiId": next, "x": x, "y": 0.0,
+					"xn": x + 5, "yn": 5.0, "customProperties": map[string]interface{}{},
+				}
+			}
+			items["103"] = signal("103", "102", "106", false, 470)
+			items["106"] = signal("106", "104", "103", true, 490)
+			items["107"] = signal("107", "11", "104", true, 535)
+			items["104"].(map[string]interface{})["previousTiId"] = "106"
+			items["104"].(map[string]interface{})["nextTiId"] = "107"
+			items["11"].(map[string]interface{})["previousTiId"] = "107"
+			routes := raw["routes"].(map[string]interface{})
+			route := func(id, begin, end string) map[string]interface{} {
+				return map[string]interface{}{
+					"__type__": "Route", "id": id, "beginSignal": begin, "endSignal": end,
+					"directions": map[string]interface{}{}, "initialState": 0,
+				}
+			}
+			routes["20"] = route("20", "101", "103")
+			routes["21"] = route("21", "107", "106")
+			raw["sections"] = map[string]interface{}{
+				"SL": map[string]interface{}{"name": "Branch", "trackItems": sectionItems, "singleLine": true, "initialDirection": direction},
+			}
+		})
+	}
+	Convey("Testing single line working", t, func() {
+		Convey("Single lines must be made of connected items", func() {
+			_, err := loadSim("", "102", "104")
+			So(err, ShouldNotBeNil)
+		})
+		sim, err := loadSim("", "102", "103", "106", "104", "107")
+		So(err, ShouldBeNil)
+		So(sim.SingleLines(), ShouldHaveLength, 1)
+		sl := sim.SingleLines()[0]
+		for _, tr := range sim.Trains {
+			So(tr.Cancel(), ShouldBeNil)
+		}
+		r11, r20, r21 := sim.Routes["11"], sim.Routes["20"], sim.Routes["21"]
+		Convey("Single lines should be locked in the direction of the routes set", func() {
+			So(r11.IsActive(), ShouldBeTrue)
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineUp)
+			So(sl.AcceptingEnd(), ShouldEqual, "107")
+			So(r11.Deactivate(), ShouldBeNil)
+			So(r20.Activate(false), ShouldBeNil)
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineUp)
+			err := r21.Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "single line Branch is locked UP")
+			So(r20.Deactivate(), ShouldBeNil)
+			So(sl.SetDirection(simulation.SingleLineFree), ShouldBeNil)
+			So(r21.Activate(false), ShouldBeNil)
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineDown)
+			So(r20.Activate(false), ShouldNotBeNil)
+		})
+		Convey("Single lines should keep their direction while they are empty", func() {
+			So(r11.Deactivate(), ShouldBeNil)
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineUp)
+			So(sl.AcceptingEnd(), ShouldEqual, "107")
+			sim.Step()
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineUp)
+			Convey("Until the first route in the other direction reverses them", func() {
+				So(r21.Activate(false), ShouldBeNil)
+				So(sl.Direction(), ShouldEqual, simulation.SingleLineDown)
+				So(sl.AcceptingEnd(), ShouldEqual, "102")
+				err := r20.Activate(false)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "single line Branch is locked DOWN")
+			})
+		})
+		Convey("The direction of single lines should only change on request when they are clear", func() {
+			So(sl.SetDirection(simulation.SingleLineDown), ShouldNotBeNil)
+			So(r11.Deactivate(), ShouldBeNil)
+			So(sl.SetDirection("SIDEWAYS"), ShouldNotBeNil)
+			So(sl.SetDirection(simulation.SingleLineDown), ShouldBeNil)
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineDown)
+			So(sl.AcceptingEnd(), ShouldEqual, "102")
+			So(r21.Activate(false), ShouldBeNil)
+			So(r20.Activate(false), ShouldNotBeNil)
+			err := sl.SetDirection(simulation.SingleLineUp)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "single line Branch is in use")
+			So(r21.Deactivate(), ShouldBeNil)
+			So(sl.SetDirection(simulation.SingleLineFree), ShouldBeNil)
+			So(sl.AcceptingEnd(), ShouldBeEmpty)
+			So(r20.Activate(false), ShouldBeNil)
+			So(sl.Direction(), ShouldEqual, simulation.SingleLineUp)
+		})
+		Convey("Single lines should be loaded in their initial direction", func() {
+			sim, err := loadSim("DOWN", "102", "103", "106", "104", "107")
+			So(err, ShouldBeNil)
+			So(sim.SingleLines()[0].Direction(), ShouldEqual, simulation.SingleLineDown)
+			So(sim.SingleLines()[0].AcceptingEnd(), ShouldEqual, "102")
+			So(sim.Routes["11"].IsActive(), ShouldBeFalse)
+			_, err = loadSim("SIDEWAYS", "102", "103", "106", "104", "107")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,312 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+
+package simulation
+
+import (
+	"math"
+	"sort"
+)
+
+// A cell is the position of a cell of a spatialIndex grid
+type cell struct {
+	x, y int
+}
+
+// A segment is a part of the drawing of a track item. Signals and other items
+// drawn as a point have a segment of zero length.
+type segment struct {
+	item     TrackItem
+	from, to Point
+}
+
+// distance returns the distance between p and this segment
+func (s segment) distance(p Point) float64 {
+	dx, dy := s.to.X-s.from.X, s.to.Y-s.from.Y
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return math.Hypot(p.X-s.from.X, p.Y-s.from.Y)
+	}
+	t := math.Max(0, math.Min(1, ((p.X-s.from.X)*dx+(p.Y-s.from.Y)*dy)/l2))
+	return math.Hypot(p.X-s.from.X-t*dx, p.Y-s.from.Y-t*dy)
+}
+
+// intersects returns true if this segment crosses the rectangle from min to
+// max, using the Liang-Barsky clipping algorithm.
+func (s segment) intersects(min, max Point) bool {
+	t0, t1 := 0.0, 1.0
+	dx, dy := s.to.X-s.from.X, s.to.Y-s.from.Y
+	for _, c := range [4][2]float64{
+		{-dx, s.from.X - min.X},
+		{dx, max.X - s.from.X},
+		{-dy, s.from.Y - min.Y},
+		{dy, max.Y - s.from.Y},
+	} {
+		p, q := c[0], c[1]
+		if p == 0 {
+			if q < 0 {
+				return false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			t0 = math.Max(t0, r)
+		} else {
+			t1 = math.Min(t1, r)
+		}
+		if t0 > t1 {
+			return false
+		}
+	}
+	return true
+}
+
+// itemSegments returns the segments drawing ti
+func itemSegments(ti TrackItem) []segment {
+	if pi, ok := ti.(*PointsItem); ok {
+		return []segment{
+			{pi, pi.Origin(), pi.Center()},
+			{pi, pi.Center(), pi.End()},
+			{pi, pi.Center(), pi.Reverse()},
+		}
+	}
+	return []segment{{ti, ti.Origin(), ti.End()}}
+}
+
+// A spatialIndex is a uniform grid over the layout coordinates of the track
+// items of a simulation, for position queries.
+type spatialIndex struct {
+	cellSize float64
+	min, max cell
+	cells    map[cell][]segment
+}
+
+// newSpatialIndex returns the spatial index of the given track items.
+//
+// The cell size is chosen so that the cells hold a few items each on
+// average, whatever the scale of the layout.
+func newSpatialIndex(items map[string]TrackItem) *spatialIndex {
+	var segments []segment
+	minP := Point{math.Inf(1), math.Inf(1)}
+	maxP := Point{math.Inf(-1), math.Inf(-1)}
+	for _, ti := range items {
+		for _, s := range itemSegments(ti) {
+			segments = append(segments, s)
+			for _, p := range []Point{s.from, s.to} {
+				minP = Point{math.Min(minP.X, p.X), math.Min(minP.Y, p.Y)}
+				maxP = Point{math.Max(maxP.X, p.X), math.Max(maxP.Y, p.Y)}
+			}
+		}
+	}
+	si := &spatialIndex{cellSize: 1, cells: make(map[cell][]segment)}
+	if len(segments) == 0 {
+		return si
+	}
+	if extent := math.Max(maxP.X-minP.X, maxP.Y-minP.Y); extent > 0 {
+		si.cellSize = extent / math.Ceil(math.Sqrt(float64(len(segments))))
+	}
+	si.min, si.max = si.cellOf(minP), si.cellOf(maxP)
+	for _, s := range segments {
+		from, to := si.cellOf(s.from), si.cellOf(s.to)
+		for x := minInt(from.x, to.x); x <= maxInt(from.x, to.x); x++ {
+			for y := minInt(from.y, to.y); y <= maxInt(from.y, to.y); y++ {
+				si.cells[cell{x, y}] = append(si.cells[cell{x, y}], s)
+			}
+		}
+	}
+	return si
+}
+
+// cellOf returns the cell containing p
+func (si *spatialIndex) cellOf(p Point) cell {
+	return cell{int(math.Floor(p.X / si.cellSize)), int(math.Floor(p.Y / si.cellSize))}
+}
+
+// visit calls f for each segment in the cells from min to max that are in
+// the grid. Segments spanning several cells may be visited several times.
+func (si *spatialIndex) visit(min, max cell, f func(segment)) {
+	min = cell{maxInt(min.x, si.min.x), maxInt(min.y, si.min.y)}
+	max = cell{minInt(max.x, si.max.x), minInt(max.y, si.max.y)}
+	for x := min.x; x <= max.x; x++ {
+		for y := min.y; y <= max.y; y++ {
+			for _, s := range si.cells[cell{x, y}] {
+				f(s)
+			}
+		}
+	}
+}
+
+// A SpatialMatch is a track item found by a position query, with its
+// distance to the queried point in layout units.
+type SpatialMatch struct {
+	Item     TrackItem
+	Distance float64
+}
+
+// sortMatches sorts the given matches by distance, then by item ID.
+func sortMatches(matches []SpatialMatch) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return idLess(matches[i].Item.ID(), matches[j].Item.ID())
+	})
+}
+
+// idLess compares track item IDs, numerically if they are numbers.
+func idLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// within returns the items at most radius away from p that match filter.
+func (si *spatialIndex) within(p Point, radius float64, filter func(TrackItem) bool) []SpatialMatch {
+	best := make(map[TrackItem]float64)
+	si.visit(si.cellOf(Point{p.X - radius, p.Y - radius}), si.cellOf(Point{p.X + radius, p.Y + radius}), func(s segment) {
+		if filter != nil && !filter(s.item) {
+			return
+		}
+		d := s.distance(p)
+		if prev, ok := best[s.item]; d <= radius && (!ok || d < prev) {
+			best[s.item] = d
+		}
+	})
+	res := make([]SpatialMatch, 0, len(best))
+	for ti, d := range best {
+		res = append(res, SpatialMatch{Item: ti, Distance: d})
+	}
+	sortMatches(res)
+	return res
+}
+
+// nearest returns the nearest item to p that matches filter, searching the
+// cells around p ring by ring.
+func (si *spatialIndex) nearest(p Point, filter func(TrackItem) bool) (SpatialMatch, bool) {
+	var (
+		res   SpatialMatch
+		found bool
+	)
+	check := func(s segment) {
+		if filter != nil && !filter(s.item) {
+			return
+		}
+		d := s.distance(p)
+		if !found || d < res.Distance || (d == res.Distance && idLess(s.item.ID(), res.Item.ID())) {
+			res, found = SpatialMatch{Item: s.item, Distance: d}, true
+		}
+	}
+	c := si.cellOf(p)
+	// Number of rings needed to cover the whole grid from c
+	maxRing := maxInt(maxInt(c.x-si.min.x, si.max.x-c.x), maxInt(c.y-si.min.y, si.max.y-c.y))
+	for r := 0; r <= maxRing; r++ {
+		if r == 0 {
+			si.visit(c, c, check)
+		} else {
+			si.visit(cell{c.x - r, c.y - r}, cell{c.x + r, c.y - r}, check)
+			si.visit(cell{c.x - r, c.y + r}, cell{c.x + r, c.y + r}, check)
+			si.visit(cell{c.x - r, c.y - r + 1}, cell{c.x - r, c.y + r - 1}, check)
+			si.visit(cell{c.x + r, c.y - r + 1}, cell{c.x + r, c.y + r - 1}, check)
+		}
+		// Items in further rings are at least r cells away
+		if found && res.Distance <= float64(r)*si.cellSize {
+			break
+		}
+	}
+	return res, found
+}
+
+// inRect returns the items crossing the rectangle from min to max
+func (si *spatialIndex) inRect(min, max Point) []TrackItem {
+	seen := make(map[TrackItem]bool)
+	var res []TrackItem
+	si.visit(si.cellOf(min), si.cellOf(max), func(s segment) {
+		if !seen[s.item] && s.intersects(min, max) {
+			seen[s.item] = true
+			res = append(res, s.item)
+		}
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return idLess(res[i].ID(), res[j].ID())
+	})
+	return res
+}
+
+// minInt returns the smallest of a and b
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// maxInt returns the largest of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// spatialIndex returns the spatial index of the track items of the
+// simulation, building it on first use. The layout of a simulation does not
+// change once it is loaded.
+func (sim *Simulation) spatialIndex() *spatialIndex {
+	sim.spatialMutex.Lock()
+	defer sim.spatialMutex.Unlock()
+	if sim.spatial == nil {
+		sim.spatial = newSpatialIndex(sim.TrackItems)
+	}
+	return sim.spatial
+}
+
+// ItemsWithin returns the track items drawn at most radius away from the
+// layout point p, nearest first. If filter is not nil, only the items for
+// which it returns true are returned.
+func (sim *Simulation) ItemsWithin(p Point, radius float64, filter func(TrackItem) bool) []SpatialMatch {
+	return sim.spatialIndex().within(p, radius, filter)
+}
+
+// NearestItem returns the track item drawn nearest to the layout point p for
+// which filter returns true, or any item if filter is nil. It returns false
+// if there is no such item.
+func (sim *Simulation) NearestItem(p Point, filter func(TrackItem) bool) (SpatialMatch, bool) {
+	return sim.spatialIndex().nearest(p, filter)
+}
+
+// NearestSignal returns the signal nearest to the layout point p and its
+// distance, or false if the simulation has no signal.
+func (sim *Simulation) NearestSignal(p Point) (*SignalItem, float64, bool) {
+	m, ok := sim.NearestItem(p, func(ti TrackItem) bool {
+		_, isSignal := ti.(*SignalItem)
+		return isSignal
+	})
+	if !ok {
+		return nil, 0, false
+	}
+	return m.Item.(*SignalItem), m.Distance, true
+}
+
+// ItemsInRect returns the track items drawn across the rectangle of the
+// layout from min to max, sorted by ID.
+func (sim *Simulation) ItemsInRect(min, max Point) []TrackItem {
+	return sim.spatialIndex().inRect(min, max)
+}
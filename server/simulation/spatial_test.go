@@ -0,0 +1,103 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// bruteForceDistance returns the distance between p and the drawing of ti
+func bruteForceDistance(ti simulation.TrackItem, p simulation.Point) float64 {
+	segDist := func(a, b simulation.Point) float64 {
+		dx, dy := b.X-a.X, b.Y-a.Y
+		l2 := dx*dx + dy*dy
+		if l2 == 0 {
+			return math.Hypot(p.X-a.X, p.Y-a.Y)
+		}
+		t := math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/l2))
+		return math.Hypot(p.X-a.X-t*dx, p.Y-a.Y-t*dy)
+	}
+	if pi, ok := ti.(*simulation.PointsItem); ok {
+		return math.Min(segDist(pi.Origin(), pi.Center()), math.Min(segDist(pi.Center(), pi.End()), segDist(pi.Center(), pi.Reverse())))
+	}
+	return segDist(ti.Origin(), ti.End())
+}
+
+func TestSpatialIndex(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing the spatial index", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		points := []simulation.Point{{X: 0, Y: 0}, {X: 22.5, Y: 3}, {X: 250, Y: 10}, {X: -100, Y: 80}, {X: 1000, Y: -40}}
+
+		Convey("Items within a radius should match a brute force search", func() {
+			for _, p := range points {
+				for _, radius := range []float64{0, 5, 30, 200, 5000} {
+					expected := make(map[string]float64)
+					for id, ti := range sim.TrackItems {
+						if d := bruteForceDistance(ti, p); d <= radius {
+							expected[id] = d
+						}
+					}
+					matches := sim.ItemsWithin(p, radius, nil)
+					So(matches, ShouldHaveLength, len(expected))
+					for i, m := range matches {
+						So(m.Distance, ShouldAlmostEqual, expected[m.Item.ID()])
+						if i > 0 {
+							So(m.Distance, ShouldBeGreaterThanOrEqualTo, matches[i-1].Distance)
+						}
+					}
+				}
+			}
+			signals := sim.ItemsWithin(simulation.Point{X: 250, Y: 0}, 5000, func(ti simulation.TrackItem) bool {
+				return ti.Type() == simulation.TypeSignal
+			})
+			So(signals, ShouldNotBeEmpty)
+			for _, m := range signals {
+				So(m.Item.Type(), ShouldEqual, simulation.TypeSignal)
+			}
+		})
+		Convey("The nearest signal should match a brute force search", func() {
+			for _, p := range points {
+				best := math.Inf(1)
+				for _, ti := range sim.TrackItems {
+					if ti.Type() == simulation.TypeSignal {
+						best = math.Min(best, bruteForceDistance(ti, p))
+					}
+				}
+				si, d, ok := sim.NearestSignal(p)
+				So(ok, ShouldBeTrue)
+				So(d, ShouldAlmostEqual, best)
+				So(bruteForceDistance(si, p), ShouldAlmostEqual, best)
+				m, ok := sim.NearestItem(p, nil)
+				So(ok, ShouldBeTrue)
+				So(m.Distance, ShouldBeLessThanOrEqualTo, d)
+			}
+			_, ok := sim.NearestItem(points[0], func(simulation.TrackItem) bool { return false })
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Items in a rectangle should be found", func() {
+			items := sim.ItemsInRect(simulation.Point{X: -1e6, Y: -1e6}, simulation.Point{X: 1e6, Y: 1e6})
+			So(items, ShouldHaveLength, len(sim.TrackItems))
+			So(sim.ItemsInRect(simulation.Point{X: 1e5, Y: 1e5}, simulation.Point{X: 1e6, Y: 1e6}), ShouldBeEmpty)
+			ti := sim.TrackItems["2"]
+			mid := simulation.Point{X: (ti.Origin().X + ti.End().X) / 2, Y: (ti.Origin().Y + ti.End().Y) / 2}
+			items = sim.ItemsInRect(simulation.Point{X: mid.X - 1, Y: mid.Y - 1}, simulation.Point{X: mid.X + 1, Y: mid.Y + 1})
+			So(items, ShouldContain, ti)
+			for _, it := range items {
+				So(bruteForceDistance(it, mid), ShouldBeLessThanOrEqualTo, math.Sqrt2)
+			}
+		})
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// A TrainSpawn describes a train to add to a running simulation.
+//
+// The train enters the area at the EntryPoint, which is the ID of an end item,
+// or appears at TrainHead if EntryPoint is empty. It appears at AppearTime, or
+// as soon as possible if AppearTime is zero.
+type TrainSpawn struct {
+	TrainTypeCode string        `json:"trainTypeCode"`
+	ServiceCode   string        `json:"serviceCode"`
+	EntryPoint    string        `json:"entryPoint"`
+	TrainHead     Position      `json:"trainHead"`
+	AppearTime    Time          `json:"appearTime"`
+	InitialSpeed  float64       `json:"initialSpeed"`
+	PriorityClass TrainPriority `json:"priority"`
+}
+
+// entryPosition returns the position of the head of a train entering the
+// area at the end item with the given ID.
+func (sim *Simulation) entryPosition(entryPoint string) (Position, error) {
+	ti, ok := sim.TrackItems[entryPoint]
+	if !ok || ti.Type() != TypeEnd {
+		return Position{}, fmt.Errorf("unknown entry point: %s", entryPoint)
+	}
+	next := ti.PreviousItem()
+	if next == nil {
+		next = ti.NextItem()
+	}
+	if next == nil {
+		return Position{}, fmt.Errorf("entry point %s is not connected", entryPoint)
+	}
+	return Position{
+		simulation:     sim,
+		TrackItemID:    next.ID(),
+		PreviousItemID: ti.ID(),
+	}, nil
+}
+
+// SpawnTrain adds a new train described by ts to the simulation and returns
+// it.
+//
+// The train is inactive until its appear time, when it enters the area like
+// the trains of the simulation file, without initial delay.
+func (sim *Simulation) SpawnTrain(ts *TrainSpawn) (*Train, error) {
+	if _, ok := sim.TrainTypes[ts.TrainTypeCode]; !ok {
+		return nil, fmt.Errorf("unknown train type: %s", ts.TrainTypeCode)
+	}
+	if _, ok := sim.Services[ts.ServiceCode]; ts.ServiceCode != "" && !ok {
+		return nil, fmt.Errorf("unknown service: %s", ts.ServiceCode)
+	}
+	if _, err := ParseTrainPriority(string(ts.PriorityClass)); err != nil {
+		return nil, err
+	}
+	if ts.InitialSpeed < 0 {
+		return nil, errors.New("initial speed must not be negative")
+	}
+	head := ts.TrainHead
+	head.simulation = sim
+	if ts.EntryPoint != "" {
+		var err error
+		if head, err = sim.entryPosition(ts.EntryPoint); err != nil {
+			return nil, err
+		}
+	}
+	if head.IsNull() || !head.IsValid() {
+		return nil, errors.New("invalid train head position")
+	}
+	now := sim.Options.CurrentTime.Time
+	appear := ts.AppearTime.Time
+	if appear.IsZero() || appear.Before(now) {
+		appear = now
+	}
+	if appear.Equal(now) && head.TrackItem().TrainPresent() {
+		return nil, fmt.Errorf("track item %s is occupied", head.TrackItemID)
+	}
+
+	t := &Train{
+		InitialDelay:  DelayGenerator{data: []delayTuplet{{prob: 100}}},
+		InitialSpeed:  ts.InitialSpeed,
+		ServiceCode:   ts.ServiceCode,
+		TrainTypeCode: ts.TrainTypeCode,
+		TrainHead:     head,
+		PriorityClass: ts.PriorityClass,
+	}
+	t.AppearTime.Time = appear
+	t.setSimulation(sim)
+	t.initialize(strconv.Itoa(len(sim.Trains)))
+	t.effInitialDelay = 0
+	sim.Trains = append(sim.Trains, t)
+
+	sim.MessageLogger.addMessage(fmt.Sprintf("Train %s (%s) added, entering at %s",
+		t.ID(), t.ServiceCode, FormatTime(appear)), simulationMsg)
+	sim.sendEvent(&Event{Name: TrainAddedEvent, Object: t})
+	if sim.suggestionEngine != nil && sim.Options.SuggestionsEnabled {
+		sim.suggestionEngine.Recompute()
+	}
+	return t, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSpawnTrain(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing adding trains at runtime", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		now := sim.Options.CurrentTime.Time
+		Convey("A train should enter at an end item at its appear time", func() {
+			ts := &simulation.TrainSpawn{
+				TrainTypeCode: "UT",
+				ServiceCode:   "S001",
+				EntryPoint:    "1",
+				InitialSpeed:  5,
+			}
+			ts.AppearTime.Time = now.Add(10 * time.Second)
+			train, err := sim.SpawnTrain(ts)
+			So(err, ShouldBeNil)
+			So(train.ID(), ShouldEqual, "2")
+			So(sim.Trains, ShouldHaveLength, 3)
+			So(train.TrainHead.TrackItemID, ShouldEqual, "2")
+			So(train.TrainHead.PreviousItemID, ShouldEqual, "1")
+			So(train.Status, ShouldEqual, simulation.Inactive)
+			So(sim.FastForward(now.Add(5*time.Second)), ShouldBeNil)
+			So(train.Status, ShouldEqual, simulation.Inactive)
+			So(sim.FastForward(now.Add(15*time.Second)), ShouldBeNil)
+			So(train.IsActive(), ShouldBeTrue)
+			So(train.Service().ID(), ShouldEqual, "S001")
+		})
+		Convey("A train should not enter on an occupied track", func() {
+			So(sim.FastForward(now.Add(2*time.Second)), ShouldBeNil)
+			So(sim.Trains[0].IsActive(), ShouldBeTrue)
+			_, err := sim.SpawnTrain(&simulation.TrainSpawn{TrainTypeCode: "UT", EntryPoint: "1"})
+			So(err, ShouldNotBeNil)
+			So(sim.Trains, ShouldHaveLength, 2)
+		})
+		Convey("Invalid trains should be refused", func() {
+			_, err := sim.SpawnTrain(&simulation.TrainSpawn{TrainTypeCode: "XX", EntryPoint: "1"})
+			So(err, ShouldNotBeNil)
+			_, err = sim.SpawnTrain(&simulation.TrainSpawn{TrainTypeCode: "UT", ServiceCode: "XX", EntryPoint: "1"})
+			So(err, ShouldNotBeNil)
+			_, err = sim.SpawnTrain(&simulation.TrainSpawn{TrainTypeCode: "UT", EntryPoint: "2"})
+			So(err, ShouldNotBeNil)
+			_, err = sim.SpawnTrain(&simulation.TrainSpawn{TrainTypeCode: "UT"})
+			So(err, ShouldNotBeNil)
+			_, err = sim.SpawnTrain(&simulation.TrainSpawn{TrainTypeCode: "UT", EntryPoint: "1", InitialSpeed: -1})
+			So(err, ShouldNotBeNil)
+			So(sim.Trains, ShouldHaveLength, 2)
+		})
+	})
+}
@@ -0,0 +1,240 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// A SpeedRestriction is a temporary speed restriction (TSR) imposed on the
+// track items between TrackItemID and ToTrackItemID between StartTime and
+// EndTime. Trains running on these items do not exceed SpeedLimit.
+//
+// A zero StartTime means that the restriction starts immediately and a zero
+// EndTime that it lasts until it is removed. An empty ToTrackItemID restricts
+// TrackItemID only.
+type SpeedRestriction struct {
+	TrackItemID   string  `json:"trackItemId"`
+	ToTrackItemID string  `json:"toTrackItemId"`
+	SpeedLimit    float64 `json:"speedLimit"`
+	StartTime     Time    `json:"startTime"`
+	EndTime       Time    `json:"endTime"`
+	Reason        string  `json:"reason"`
+
+	restrictionID string
+	items         []string
+	active        bool
+	simulation    *Simulation
+}
+
+// ID returns the unique identifier of this speed restriction
+func (sr *SpeedRestriction) ID() string {
+	return sr.restrictionID
+}
+
+// Items returns the IDs of the track items covered by this speed restriction
+func (sr *SpeedRestriction) Items() []string {
+	return sr.items
+}
+
+// IsActive returns true if this speed restriction currently applies to trains
+func (sr *SpeedRestriction) IsActive() bool {
+	return sr.active
+}
+
+// Status returns PLANNED, ACTIVE or ENDED
+func (sr *SpeedRestriction) Status() string {
+	return scheduleStatus(sr.active, sr.EndTime.Time, sr.simulation.Options.CurrentTime.Time)
+}
+
+// MarshalJSON method for SpeedRestriction
+func (sr *SpeedRestriction) MarshalJSON() ([]byte, error) {
+	type auxSpeedRestriction struct {
+		ID            string   `json:"id"`
+		TrackItemID   string   `json:"trackItemId"`
+		ToTrackItemID string   `json:"toTrackItemId,omitempty"`
+		SpeedLimit    float64  `json:"speedLimit"`
+		StartTime     string   `json:"startTime"`
+		EndTime       string   `json:"endTime"`
+		Reason        string   `json:"reason,omitempty"`
+		Items         []string `json:"items"`
+		Status        string   `json:"status"`
+	}
+	return json.Marshal(auxSpeedRestriction{
+		ID:            sr.restrictionID,
+		TrackItemID:   sr.TrackItemID,
+		ToTrackItemID: sr.ToTrackItemID,
+		SpeedLimit:    sr.SpeedLimit,
+		StartTime:     formatScheduleTime(sr.simulation, sr.StartTime.Time),
+		EndTime:       formatScheduleTime(sr.simulation, sr.EndTime.Time),
+		Reason:        sr.Reason,
+		Items:         sr.items,
+		Status:        sr.Status(),
+	})
+}
+
+// resolveItems checks this speed restriction and computes the track items it
+// covers.
+func (sr *SpeedRestriction) resolveItems() error {
+	ti, ok := sr.simulation.TrackItems[sr.TrackItemID]
+	if !ok {
+		return fmt.Errorf("unknown track item: %s", sr.TrackItemID)
+	}
+	if sr.SpeedLimit <= 0 {
+		return fmt.Errorf("speed restriction requires a positive speed limit")
+	}
+	if !sr.StartTime.IsZero() && !sr.EndTime.IsZero() && !sr.StartTime.Time.Before(sr.EndTime.Time) {
+		return fmt.Errorf("end time must be after start time")
+	}
+	if sr.ToTrackItemID == "" {
+		sr.items = []string{ti.ID()}
+		return nil
+	}
+	to, ok := sr.simulation.TrackItems[sr.ToTrackItemID]
+	if !ok {
+		return fmt.Errorf("unknown track item: %s", sr.ToTrackItemID)
+	}
+	items, err := itemsBetween(ti, to)
+	if err != nil {
+		return err
+	}
+	sr.items = items
+	return nil
+}
+
+// AddSpeedRestriction checks the given speed restriction and adds it to the
+// simulation. The restriction is applied immediately if it is already
+// scheduled.
+func (sim *Simulation) AddSpeedRestriction(sr *SpeedRestriction) error {
+	sr.simulation = sim
+	if err := sr.resolveItems(); err != nil {
+		return err
+	}
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	if sim.speedRestrictions == nil {
+		sim.speedRestrictions = make(map[string]*SpeedRestriction)
+	}
+	sim.lastSpeedRestrictionID++
+	sr.restrictionID = strconv.Itoa(sim.lastSpeedRestrictionID)
+	sim.speedRestrictions[sr.restrictionID] = sr
+	sim.sendEvent(&Event{Name: SpeedRestrictionChangedEvent, Object: sr})
+	sim.updateSpeedRestrictionsLocked()
+	return nil
+}
+
+// RemoveSpeedRestriction removes the speed restriction with the given ID from
+// the simulation and lifts it from its track items.
+func (sim *Simulation) RemoveSpeedRestriction(id string) error {
+	sim.disruptionsMutex.Lock()
+	defer sim.disruptionsMutex.Unlock()
+	sr, ok := sim.speedRestrictions[id]
+	if !ok {
+		return fmt.Errorf("unknown speed restriction: %s", id)
+	}
+	delete(sim.speedRestrictions, id)
+	if sr.active {
+		sr.active = false
+		for _, iid := range sr.items {
+			sim.applySpeedLimit(iid)
+		}
+		sim.MessageLogger.addMessage(fmt.Sprintf("Speed restriction %s lifted on %s", sr.restrictionID, sr.TrackItemID), simulationMsg)
+		sim.disruptionsChanged()
+	}
+	sim.sendEvent(&Event{Name: SpeedRestrictionChangedEvent, Object: sr})
+	return nil
+}
+
+// SpeedRestrictions returns all the speed restrictions of the simulation
+// ordered by ID.
+func (sim *Simulation) SpeedRestrictions() []*SpeedRestriction {
+	sim.disruptionsMutex.RLock()
+	defer sim.disruptionsMutex.RUnlock()
+	res := make([]*SpeedRestriction, 0, len(sim.speedRestrictions))
+	for _, sr := range sim.speedRestrictions {
+		res = append(res, sr)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, _ := strconv.Atoi(res[i].restrictionID)
+		b, _ := strconv.Atoi(res[j].restrictionID)
+		return a < b
+	})
+	return res
+}
+
+// GetSpeedRestriction returns the speed restriction with the given ID
+func (sim *Simulation) GetSpeedRestriction(id string) (*SpeedRestriction, bool) {
+	sim.disruptionsMutex.RLock()
+	defer sim.disruptionsMutex.RUnlock()
+	sr, ok := sim.speedRestrictions[id]
+	return sr, ok
+}
+
+// updateSpeedRestrictionsLocked starts and ends speed restrictions according
+// to their schedule. disruptionsMutex must be held.
+func (sim *Simulation) updateSpeedRestrictionsLocked() {
+	changed := false
+	for _, sr := range sim.speedRestrictions {
+		active := isScheduledAt(sr.StartTime.Time, sr.EndTime.Time, sim.Options.CurrentTime.Time)
+		if active == sr.active {
+			continue
+		}
+		sr.active = active
+		for _, iid := range sr.items {
+			sim.applySpeedLimit(iid)
+		}
+		if active {
+			sim.MessageLogger.addMessage(fmt.Sprintf("Speed restriction %s of %.0f km/h started on %s", sr.restrictionID, sr.SpeedLimit*3.6, sr.TrackItemID), simulationMsg)
+		} else {
+			sim.MessageLogger.addMessage(fmt.Sprintf("Speed restriction %s ended on %s", sr.restrictionID, sr.TrackItemID), simulationMsg)
+		}
+		sim.sendEvent(&Event{Name: SpeedRestrictionChangedEvent, Object: sr})
+		changed = true
+	}
+	if changed {
+		sim.disruptionsChanged()
+	}
+}
+
+// applySpeedLimit sets the speed restriction of the track item with the given
+// ID to the lowest limit of the active speed restrictions and speed
+// restriction disruptions covering it. disruptionsMutex must be held.
+func (sim *Simulation) applySpeedLimit(id string) {
+	var limit float64
+	for _, d := range sim.activeDisruptionsOn(id, DisruptionSpeedRestriction) {
+		if limit == 0 || d.SpeedLimit < limit {
+			limit = d.SpeedLimit
+		}
+	}
+	for _, sr := range sim.speedRestrictions {
+		if !sr.active || (limit != 0 && sr.SpeedLimit >= limit) {
+			continue
+		}
+		for _, iid := range sr.items {
+			if iid == id {
+				limit = sr.SpeedLimit
+				break
+			}
+		}
+	}
+	sim.TrackItems[id].SetSpeedRestriction(limit)
+}
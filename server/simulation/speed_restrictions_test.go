@@ -0,0 +1,84 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestSpeedRestrictions(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing temporary speed restrictions", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim, endChan)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		Convey("Speed restrictions should limit the speed of their items", func() {
+			sr := &simulation.SpeedRestriction{TrackItemID: "2", ToTrackItemID: "6", SpeedLimit: 5}
+			So(sim.AddSpeedRestriction(sr), ShouldBeNil)
+			So(sr.ID(), ShouldNotBeEmpty)
+			So(sr.Status(), ShouldEqual, "ACTIVE")
+			So(sr.Items(), ShouldResemble, []string{"2", "3", "4", "5", "6"})
+			So(sim.TrackItems["3"].SpeedRestriction(), ShouldEqual, 5)
+			So(sim.TrackItems["3"].MaxSpeed(), ShouldEqual, 5)
+			So(sim.TrackItems["8"].MaxSpeed(), ShouldEqual, 10)
+			So(sim.SpeedRestrictions(), ShouldHaveLength, 1)
+			So(sim.RemoveSpeedRestriction(sr.ID()), ShouldBeNil)
+			So(sim.TrackItems["3"].SpeedRestriction(), ShouldEqual, 0)
+			So(sim.SpeedRestrictions(), ShouldBeEmpty)
+			So(sim.RemoveSpeedRestriction(sr.ID()), ShouldNotBeNil)
+		})
+		Convey("Invalid speed restrictions should be refused", func() {
+			So(sim.AddSpeedRestriction(&simulation.SpeedRestriction{TrackItemID: "2"}), ShouldNotBeNil)
+			So(sim.AddSpeedRestriction(&simulation.SpeedRestriction{TrackItemID: "UNKNOWN", SpeedLimit: 5}), ShouldNotBeNil)
+			sr := &simulation.SpeedRestriction{TrackItemID: "2", SpeedLimit: 5}
+			sr.StartTime.Time = sim.Options.CurrentTime.Time.Add(time.Minute)
+			sr.EndTime.Time = sim.Options.CurrentTime.Time
+			So(sim.AddSpeedRestriction(sr), ShouldNotBeNil)
+		})
+		Convey("The lowest limit should apply with disruptions", func() {
+			sr := &simulation.SpeedRestriction{TrackItemID: "6", SpeedLimit: 4}
+			So(sim.AddSpeedRestriction(sr), ShouldBeNil)
+			d := &simulation.Disruption{Type: simulation.DisruptionSpeedRestriction, TrackItemID: "6", SpeedLimit: 3}
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sim.TrackItems["6"].MaxSpeed(), ShouldEqual, 3)
+			So(sim.RemoveDisruption(d.ID()), ShouldBeNil)
+			So(sim.TrackItems["6"].MaxSpeed(), ShouldEqual, 4)
+			So(sim.RemoveSpeedRestriction(sr.ID()), ShouldBeNil)
+			So(sim.TrackItems["6"].MaxSpeed(), ShouldEqual, 10)
+		})
+		Convey("Speed restrictions should follow their schedule", func() {
+			sr := &simulation.SpeedRestriction{TrackItemID: "6", SpeedLimit: 4}
+			sr.StartTime.Time = sim.Options.CurrentTime.Time.Add(time.Second)
+			sr.EndTime.Time = sim.Options.CurrentTime.Time.Add(3 * time.Second)
+			So(sim.AddSpeedRestriction(sr), ShouldBeNil)
+			So(sr.Status(), ShouldEqual, "PLANNED")
+			So(sim.TrackItems["6"].SpeedRestriction(), ShouldEqual, 0)
+			sim.Step()
+			So(sr.Status(), ShouldEqual, "ACTIVE")
+			So(sim.TrackItems["6"].SpeedRestriction(), ShouldEqual, 4)
+			sim.Step()
+			So(sr.Status(), ShouldEqual, "ENDED")
+			So(sim.TrackItems["6"].SpeedRestriction(), ShouldEqual, 0)
+		})
+		Convey("Trains should obey speed restrictions", func() {
+			So(sim.AddSpeedRestriction(&simulation.SpeedRestriction{TrackItemID: "2", ToTrackItemID: "6", SpeedLimit: 3}), ShouldBeNil)
+			train := sim.Trains[0]
+			So(stepUntil(&sim, 600, func() bool {
+				return train.IsActive() && train.TrainHead.TrackItemID == "4"
+			}), ShouldBeTrue)
+			So(train.Speed, ShouldBeLessThanOrEqualTo, 3.01)
+		})
+	})
+}
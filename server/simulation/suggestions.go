@@ -27,8 +27,6 @@ import (
     "time"
 )
 
-var suggestionEngine *SuggestionEngine
-
 // SuggestionKind defines the category of a suggestion
 type SuggestionKind string
 
@@ -39,8 +37,15 @@ const (
     SuggestionTrainReverse           SuggestionKind = "TRAIN_REVERSE"
     SuggestionTrainSetService        SuggestionKind = "TRAIN_SET_SERVICE"
     SuggestionSignalOverride         SuggestionKind = "SIGNAL_OVERRIDE"
+    SuggestionRouteDiversion         SuggestionKind = "ROUTE_DIVERSION"
+    SuggestionTrainCoast             SuggestionKind = "TRAIN_COAST"
+    SuggestionPlatformReassign       SuggestionKind = "PLATFORM_REASSIGN"
+    SuggestionThroughRoute           SuggestionKind = "THROUGH_ROUTE"
 )
 
+// ecoMinSlack is the minimum time a train must be early at full speed to be advised to coast
+const ecoMinSlack = 30 * time.Second
+
 // SuggestionAction describes an actionable command the client may accept
 // The action maps to existing server hub object/action pairs.
 type SuggestionAction struct {
@@ -120,6 +125,7 @@ func (e *SuggestionEngine) RecomputeIfDue() bool {
     }
     s.Items = filtered
     e.sim.Suggestions = s
+    Logger.Debug("Suggestions recomputed", "submodule", "suggestions", "items", len(s.Items))
     e.sim.sendEvent(&Event{Name: SuggestionsUpdatedEvent, Object: *s})
     return true
 }
@@ -142,10 +148,12 @@ func (e *SuggestionEngine) Recompute() {
     s.Items = filtered
     e.sim.Suggestions = s
     e.lastComputedAt = e.sim.Options.CurrentTime
+    Logger.Debug("Suggestions recomputed", "submodule", "suggestions", "items", len(s.Items))
     e.sim.sendEvent(&Event{Name: SuggestionsUpdatedEvent, Object: *s})
 }
 
 func (e *SuggestionEngine) computeSuggestions() *Suggestions {
+    defer e.sim.startOperation(OperationSuggestions)()
     var res Suggestions
     res.simulation = e.sim
     res.GeneratedAt = e.sim.Options.CurrentTime
@@ -175,7 +183,7 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if e.sim.Options.CurrentTime.Sub(line.ScheduledDepartureTime) < 0 {
             continue
         }
-        if t.StoppedTime < t.minStopTime {
+        if t.StoppedTime < t.MinimumStopTime() {
             continue
         }
         // Find next signal and candidate routes
@@ -183,6 +191,8 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if nextSignal == nil {
             continue
         }
+        failedPoints := e.failedPointsFrom(nextSignal)
+        delayMin := float64(e.sim.Options.CurrentTime.Sub(line.ScheduledDepartureTime) / time.Minute)
         // Scan only routes starting at the next signal
         for _, r := range e.sim.routesByBeginSignal[nextSignal.ID()] {
             // Check activable
@@ -196,6 +206,10 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             if !activable {
                 continue
             }
+            // Disruptions: do not suggest routes that cannot be set or whose signal cannot clear
+            if r.checkDisruptions() != nil || r.checkOverlaps() != nil || r.checkSingleLines() != nil || r.BeginSignal().Failed() {
+                continue
+            }
             // Quick occupancy check on route path ahead (skip the begin signal and current head item)
             blocked := false
             for i, pos := range r.Positions {
@@ -207,7 +221,7 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
                     // ignore current occupancy by this train
                     continue
                 }
-                if ti.TrainPresent() {
+                if isOccupied(ti) {
                     blocked = true
                     break
                 }
@@ -223,16 +237,20 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             if pred, _ := e.predictsHeadOnConflictOnRoute(t, r); pred {
                 continue
             }
-            // Enforce planned track code for current departure place
-            if line.TrackCode != "" && line.PlaceCode != "" {
+            // Never send the train to a platform too short for it
+            if r.checkPlatformLengths(t) != nil {
+                continue
+            }
+            // Enforce planned track code for current departure place, unless
+            // failed points force a diversion
+            if line.TrackCode != "" && line.PlaceCode != "" && len(failedPoints) == 0 {
                 if !e.routeRespectsTrackCodeWithinPlace(r, line.PlaceCode, line.TrackCode) {
                     continue
                 }
             }
             // Score: base on delay minutes and track alignment bonus
-            delayMin := float64(e.sim.Options.CurrentTime.Sub(line.ScheduledDepartureTime) / time.Minute)
             score := 10.0*delayMin + 1.0
-            reason := fmt.Sprintf("Scheduled departure was %s, minimum stop satisfied. No conflicts detected.", line.ScheduledDepartureTime.Time.Format("15:04:05"))
+            reason := fmt.Sprintf("Scheduled departure was %s, minimum stop satisfied. No conflicts detected.", FormatTime(line.ScheduledDepartureTime.Time))
             // Bonus if first segment matches planned track code
             if thi.TrackCode() == line.TrackCode {
                 score += 2.0
@@ -241,11 +259,42 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             if util < 50.0 {
                 score += (50.0 - util) / 10.0
             }
+            if len(failedPoints) > 0 {
+                score += 3.0
+                reason += diversionReason(failedPoints)
+            }
+            flowScore, flowReason := e.singleLineFlow(t, r)
+            score += flowScore
+            reason += flowReason
+            score += priorityBonus(t)
+            reason += priorityReason(t)
             sID := fmt.Sprintf("%s:%s:%s", SuggestionRouteActivate, t.ID(), r.ID())
             title := fmt.Sprintf("Set route %s to depart train %s", r.ID(), t.ServiceCode)
             act := SuggestionAction{Object: "route", Action: "activate", Params: map[string]interface{}{"id": r.ID(), "persistent": false}}
             candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionRouteActivate, Title: title, Reason: reason, Score: score, Actions: []SuggestionAction{act}})
         }
+        // Departures crossing several blocks: propose the through route to the next stop
+        if path := e.throughRoute(t, nextSignal); path != nil {
+            ids := path.RouteIDs()
+            endSignal := path.Routes[len(path.Routes)-1].EndSignalId
+            via := make([]string, 0, len(path.Routes)-1)
+            for _, r := range path.Routes[:len(path.Routes)-1] {
+                via = append(via, r.EndSignalId)
+            }
+            // Slightly preferred to setting the first block only
+            score := 10.0*delayMin + 2.0
+            reason := fmt.Sprintf("Scheduled departure was %s, minimum stop satisfied. Routes %s lead to the next stop at %s without stopping at intermediate signals.",
+                FormatTime(line.ScheduledDepartureTime.Time), strings.Join(ids, ", "), e.nextMustStopLine(t).PlaceCode)
+            if util < 50.0 {
+                score += (50.0 - util) / 10.0
+            }
+            score += priorityBonus(t)
+            reason += priorityReason(t)
+            sID := fmt.Sprintf("%s:%s:%s", SuggestionThroughRoute, t.ID(), strings.Join(ids, "+"))
+            title := fmt.Sprintf("Set through route from signal %s to signal %s to depart train %s", nextSignal.ID(), endSignal, t.ServiceCode)
+            act := SuggestionAction{Object: "route", Action: "setThrough", Params: map[string]interface{}{"begin": nextSignal.ID(), "end": endSignal, "via": via, "persistent": false}}
+            candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionThroughRoute, Title: title, Reason: reason, Score: score, Actions: []SuggestionAction{act}})
+        }
     }
 
     // 1b) Predictive route activation: for approaching trains that will need routes soon
@@ -275,6 +324,7 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if nextSignal.ActiveAspect().MeansProceed() {
             continue
         }
+        failedPoints := e.failedPointsFrom(nextSignal)
         // Find suitable route from this signal
         for _, r := range e.sim.routesByBeginSignal[nextSignal.ID()] {
             // Check if route can be activated
@@ -288,13 +338,17 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             if !activable {
                 continue
             }
+            // Disruptions: do not suggest routes that cannot be set or whose signal cannot clear
+            if r.checkDisruptions() != nil || r.checkOverlaps() != nil || r.checkSingleLines() != nil || r.BeginSignal().Failed() {
+                continue
+            }
             // Check path is clear
             pathClear := true
             for i, pos := range r.Positions {
                 if i == 0 {
                     continue
                 }
-                if pos.TrackItem().TrainPresent() {
+                if isOccupied(pos.TrackItem()) {
                     pathClear = false
                     break
                 }
@@ -310,8 +364,13 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             if pred, _ := e.predictsHeadOnConflictOnRoute(t, r); pred {
                 continue
             }
-            // Enforce planned track code for the upcoming must-stop place if this route touches it
-            if nsl := e.nextMustStopLine(t); nsl != nil && nsl.PlaceCode != "" && nsl.TrackCode != "" {
+            // Never send the train to a platform too short for it
+            if r.checkPlatformLengths(t) != nil {
+                continue
+            }
+            // Enforce planned track code for the upcoming must-stop place if this route touches it,
+            // unless failed points force a diversion
+            if nsl := e.nextMustStopLine(t); nsl != nil && nsl.PlaceCode != "" && nsl.TrackCode != "" && len(failedPoints) == 0 {
                 if e.routeTouchesPlace(r, nsl.PlaceCode) && !e.routeRespectsTrackCodeWithinPlace(r, nsl.PlaceCode, nsl.TrackCode) {
                     continue
                 }
@@ -320,6 +379,14 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             score := 15.0 + (60.0-timeToSignal.Seconds())/10.0 // Higher score for trains closer to signal
             reason := fmt.Sprintf("Train %s approaching signal %s in ~%.0fs. Proactive route setting prevents stop.", 
                 t.ServiceCode, nextSignal.ID(), timeToSignal.Seconds())
+            if len(failedPoints) > 0 {
+                reason += diversionReason(failedPoints)
+            }
+            flowScore, flowReason := e.singleLineFlow(t, r)
+            score += flowScore
+            reason += flowReason
+            score += priorityBonus(t)
+            reason += priorityReason(t)
             sID := fmt.Sprintf("%s:%s:%s:predictive", SuggestionRouteActivate, t.ID(), r.ID())
             title := fmt.Sprintf("Proactively set route %s for approaching train %s", r.ID(), t.ServiceCode)
             act := SuggestionAction{Object: "route", Action: "activate", Params: map[string]interface{}{"id": r.ID(), "persistent": false}}
@@ -342,13 +409,20 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if sig.ActiveAspect().MeansProceed() {
             continue
         }
-        // Check ahead up to that next signal for trains
+        // Check ahead up to that next signal for trains and blocked items.
+        // Items with failed train detection do not prevent proceeding with
+        // caution, which is the degraded working procedure over them.
         clear := true
+        var failedDetection []string
         for pos := t.TrainHead; !pos.Equals(nsp); pos = pos.Next(DirectionCurrent) {
             if pos.TrackItem().Equals(t.TrainHead.TrackItem()) {
                 continue
             }
-            if pos.TrackItem().TrainPresent() {
+            if pos.TrackItem().DetectionFailure() != "" && !pos.TrackItem().Blocked() {
+                failedDetection = append(failedDetection, pos.TrackItemID)
+                continue
+            }
+            if isOccupied(pos.TrackItem()) {
                 clear = false
                 break
             }
@@ -367,6 +441,12 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         sID := fmt.Sprintf("%s:%s", SuggestionTrainProceedWithCaution, t.ID())
         title := fmt.Sprintf("Proceed with caution for train %s to next signal", t.ServiceCode)
         reason := fmt.Sprintf("Signal %s at STOP but block to next signal appears clear.", sig.ID())
+        if sig.Failed() {
+            reason = fmt.Sprintf("Signal %s has failed at STOP; block to next signal appears clear.", sig.ID())
+        }
+        if len(failedDetection) > 0 {
+            reason += fmt.Sprintf(" Train detection has failed on %s: the driver must proceed on sight.", strings.Join(failedDetection, ", "))
+        }
         act := SuggestionAction{Object: "train", Action: "proceed", Params: map[string]interface{}{"id": mustAtoi(t.ID())}}
         // Higher score for late trains
         bonus := 0.0
@@ -380,10 +460,16 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
             }
         }
         score := 5.0 + bonus
+        // A failed signal will not clear, so proceeding with caution is the only way past it
+        if sig.Failed() {
+            score += 5.0
+        }
         // KPI-proxy: if utilization is high, prefer actions that get trains moving cautiously
         if util > 60.0 {
             score += (util - 60.0) / 12.0
         }
+        score += priorityBonus(t)
+        reason += priorityReason(t)
         candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionTrainProceedWithCaution, Title: title, Reason: reason, Score: score, Actions: []SuggestionAction{act}})
     }
 
@@ -403,7 +489,7 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if e.sim.Options.CurrentTime.Sub(line.ScheduledDepartureTime) < 0 {
             continue
         }
-        if t.StoppedTime < t.minStopTime {
+        if t.StoppedTime < t.MinimumStopTime() {
             continue
         }
         readyTrains = append(readyTrains, t)
@@ -420,23 +506,25 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
                 if i == 0 { continue }
                 ti := pos.TrackItem()
                 if ti.Equals(thi) { continue }
-                if ti.TrainPresent() { pathBlockedByTrain = true; break }
+                if isOccupied(ti) { pathBlockedByTrain = true; break }
             }
             if pathBlockedByTrain { continue }
-            // Ask route managers for activation and parse conflicting route if any
-            var conflictID string
+            // Only routes refused by the interlocking are unblocked by deactivation
+            refused := r.checkFlankProtection() != nil
             for _, rm := range routesManagers {
-                if err := rm.CanActivate(r); err != nil {
-                    if cid := parseConflictingRouteID(err.Error()); cid != "" {
-                        conflictID = cid
-                        break
-                    }
+                if rm.CanActivate(r) != nil {
+                    refused = true
+                    break
                 }
             }
-            if conflictID == "" { continue }
-            // Check conflicting route is persistent and unused
-            rp, ok := e.sim.Routes[conflictID]
-            if !ok { continue }
+            if !refused { continue }
+            // The route must be blocked by a single conflicting route, persistent and unused
+            var set []*Route
+            for _, c := range r.Conflicts() {
+                if c.Route.State() != Deactivated { set = append(set, c.Route) }
+            }
+            if len(set) != 1 { continue }
+            rp := set[0]
             if rp.State() != Persistent { continue }
             if routeHasAnyTrain(rp) { continue }
             // Record
@@ -477,13 +565,13 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if sig.ActiveAspect().MeansProceed() {
             continue
         }
-        // Check ahead up to that next signal for trains
+        // Check ahead up to that next signal for trains and blocked items
         clear := true
         for pos := t.TrainHead; !pos.Equals(nsp); pos = pos.Next(DirectionCurrent) {
             if pos.TrackItem().Equals(t.TrainHead.TrackItem()) {
                 continue
             }
-            if pos.TrackItem().TrainPresent() {
+            if isOccupied(pos.TrackItem()) {
                 clear = false
                 break
             }
@@ -491,6 +579,10 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         if !clear {
             continue
         }
+        // A failed signal cannot be overridden
+        if sig.Failed() {
+            continue
+        }
         // Choose a conservative proceed aspect (prefer the lowest-speed proceed aspect)
         targetAspect := e.findProceedAspectPreferCaution(sig)
         if targetAspect == nil {
@@ -512,6 +604,125 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
         candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionSignalOverride, Title: title, Reason: reason, Score: score, Actions: []SuggestionAction{act}})
     }
 
+    // 5) Diversionary routing: the planned track of the next stop cannot be reached, propose a path to another track of the place
+    for _, t := range e.sim.Trains {
+        if !t.IsActive() {
+            continue
+        }
+        nextSignal := t.findNextSignal()
+        if nextSignal == nil || nextSignal.ActiveAspect().MeansProceed() {
+            continue
+        }
+        nsl := e.nextMustStopLine(t)
+        if nsl == nil || nsl.PlaceCode == "" || nsl.TrackCode == "" {
+            continue
+        }
+        tooShort := nsl.Place() != nil && t.fitsPlatform(nsl.Place(), nsl.TrackCode) != nil
+        if _, err := e.sim.FindRoutePath(nextSignal.ID(), nsl.PlaceCode+"/"+nsl.TrackCode); err == nil && !tooShort {
+            continue
+        }
+        path := e.divertToPlatform(t, nextSignal, nsl)
+        if path == nil {
+            continue
+        }
+        // The diversion must be free of trains and its first route activable now
+        free := true
+        for _, r := range path.Routes {
+            if routeHasAnyTrain(r) { free = false; break }
+        }
+        if !free {
+            continue
+        }
+        activable := true
+        for _, rm := range routesManagers {
+            if err := rm.CanActivate(path.Routes[0]); err != nil {
+                activable = false
+                break
+            }
+        }
+        if !activable {
+            continue
+        }
+        ids := path.RouteIDs()
+        acts := make([]SuggestionAction, len(ids))
+        for i, id := range ids {
+            acts[i] = SuggestionAction{Object: "route", Action: "activate", Params: map[string]interface{}{"id": id, "persistent": false}}
+        }
+        sID := fmt.Sprintf("%s:%s:%s", SuggestionRouteDiversion, t.ID(), strings.Join(ids, "+"))
+        title := fmt.Sprintf("Divert train %s to another track at %s", t.ServiceCode, nsl.PlaceCode)
+        reason := fmt.Sprintf("Planned track %s at %s cannot be reached. Routes %s lead to another track.", nsl.TrackCode, nsl.PlaceCode, strings.Join(ids, ", "))
+        if tooShort {
+            reason = fmt.Sprintf("Planned platform %s at %s is too short for the train. Routes %s lead to another track.", nsl.TrackCode, nsl.PlaceCode, strings.Join(ids, ", "))
+        }
+        score := 12.0
+        if util > 60.0 {
+            score += (util - 60.0) / 10.0
+        }
+        score += priorityBonus(t)
+        reason += priorityReason(t)
+        candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionRouteDiversion, Title: title, Reason: reason, Score: score, Actions: acts})
+    }
+
+    // 6) Eco-driving: trains that would arrive early at their next stop can coast now
+    for _, t := range e.sim.Trains {
+        if t.IsCoasting() {
+            continue
+        }
+        ea, ok := t.EcoAdvisory()
+        if !ok || !ea.Coast || ea.Slack < ecoMinSlack {
+            continue
+        }
+        act := SuggestionAction{Object: "train", Action: "coast", Params: map[string]interface{}{"id": mustAtoi(t.ID())}}
+        sID := fmt.Sprintf("%s:%s", SuggestionTrainCoast, t.ID())
+        title := fmt.Sprintf("Train %s can coast now", t.ServiceCode)
+        reason := fmt.Sprintf("Train %s would reach %s %s early at full speed. Coasting down to %.0f km/h saves energy and still arrives on time.",
+            t.ServiceCode, ea.PlaceCode, ea.Slack.Round(time.Second), ea.AdvisorySpeed*3.6)
+        // Energy savings come after operational suggestions
+        score := 2.0 + math.Min(ea.Slack.Minutes(), 3.0)
+        candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionTrainCoast, Title: title, Reason: reason, Score: score, Actions: []SuggestionAction{act}})
+    }
+
+    // 7) Platform reassignment: two services are booked on the same platform at the same time
+    placeCodes := make([]string, 0, len(e.sim.Places))
+    for code := range e.sim.Places {
+        placeCodes = append(placeCodes, code)
+    }
+    sort.Strings(placeCodes)
+    for _, placeCode := range placeCodes {
+        allocs := e.sim.platformAllocations(placeCode)
+        proposed := make(map[*PlatformAllocation]bool)
+        for _, pc := range platformConflicts(allocs) {
+            // Move the service arriving last, unless its train already stands on the platform
+            pa, other := pc.Second, pc.First
+            if pa.ActualTrack != "" {
+                pa, other = pc.First, pc.Second
+            }
+            if pa.ActualTrack != "" || proposed[pa] {
+                continue
+            }
+            track := freePlatform(pa, allocs)
+            if track == "" {
+                continue
+            }
+            proposed[pa] = true
+            code := pa.Service.ID()
+            act := SuggestionAction{Object: "service", Action: "assignPlatform", Params: map[string]interface{}{"id": code, "index": pa.LineIndex, "trackCode": track}}
+            sID := fmt.Sprintf("%s:%s:%d:%s", SuggestionPlatformReassign, code, pa.LineIndex, track)
+            title := fmt.Sprintf("Move service %s to platform %s at %s", code, track, placeCode)
+            reason := fmt.Sprintf("Platform %s at %s is booked by %s and %s around %s. Platform %s is free.",
+                pc.TrackCode, placeCode, other.Service.ID(), code, pa.Arrival.Format("15:04"), track)
+            score := 10.0
+            if pa.Train != nil {
+                score += priorityBonus(pa.Train)
+                reason += priorityReason(pa.Train)
+            }
+            candidates = append(candidates, Suggestion{ID: sID, Kind: SuggestionPlatformReassign, Title: title, Reason: reason, Score: score, Actions: []SuggestionAction{act}})
+        }
+    }
+
+    // Trains of higher priority classes go first through junctions
+    e.arbitrateJunctions(candidates)
+
     // Order by score desc and cap list
     sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
     maxItems := e.sim.Options.SuggestMaxItems
@@ -523,6 +734,72 @@ func (e *SuggestionEngine) computeSuggestions() *Suggestions {
     return &res
 }
 
+// priorityBonus returns the score bonus of the suggestions for train t, so
+// that the suggestions for trains of higher priority classes come first.
+func priorityBonus(t *Train) float64 {
+    return 4.0 * float64(t.Priority().Rank()-DefaultPriority.Rank())
+}
+
+// priorityReason returns the explanation to add to the reason of the
+// suggestions for train t when its priority class is not the default one.
+func priorityReason(t *Train) string {
+    if t.Priority() == DefaultPriority {
+        return ""
+    }
+    return fmt.Sprintf(" Train has %s priority.", t.Priority())
+}
+
+// arbitrateJunctions arbitrates between the route activations suggested for
+// different trains over common track items: the activation for the train of
+// the lower priority class is scored below the other one and explains that
+// the train gives way.
+func (e *SuggestionEngine) arbitrateJunctions(candidates []Suggestion) {
+    type activation struct {
+        index int
+        train *Train
+        items map[string]bool
+    }
+    activations := make([]activation, 0)
+    for i, c := range candidates {
+        if c.Kind != SuggestionRouteActivate {
+            continue
+        }
+        parts := strings.Split(c.ID, ":")
+        if len(parts) < 3 {
+            continue
+        }
+        tid := mustAtoi(parts[1])
+        r, ok := e.sim.Routes[parts[2]]
+        if !ok || tid < 0 || tid >= len(e.sim.Trains) {
+            continue
+        }
+        items := make(map[string]bool)
+        for _, pos := range r.Positions {
+            items[pos.TrackItemID] = true
+        }
+        activations = append(activations, activation{index: i, train: e.sim.Trains[tid], items: items})
+    }
+    for _, a := range activations {
+        for _, b := range activations {
+            if a.train == b.train || a.train.Priority().Rank() <= b.train.Priority().Rank() {
+                continue
+            }
+            shared := false
+            for id := range b.items {
+                if a.items[id] {
+                    shared = true
+                    break
+                }
+            }
+            if !shared || candidates[b.index].Score < candidates[a.index].Score {
+                continue
+            }
+            candidates[b.index].Score = candidates[a.index].Score - 1.0
+            candidates[b.index].Reason += fmt.Sprintf(" Gives way to %s train %s.", a.train.Priority(), a.train.ServiceCode)
+        }
+    }
+}
+
 // Helper to parse numeric train IDs (trains use string IDs of numeric index)
 func mustAtoi(s string) int {
     var x int
@@ -532,17 +809,7 @@ func mustAtoi(s string) int {
 
 // currentUtilizationPercent computes a proxy for network utilization as percentage of occupied key track items
 func (e *SuggestionEngine) currentUtilizationPercent() float64 {
-    occupied := 0
-    total := 0
-    for _, ti := range e.sim.TrackItems {
-        switch ti.Type() {
-        case TypeLine, TypeInvisibleLink, TypeSignal, TypePoints:
-            total++
-            if ti.TrainPresent() { occupied++ }
-        }
-    }
-    if total == 0 { return 0 }
-    return float64(occupied) * 100.0 / float64(total)
+    return e.sim.Utilization()
 }
 
 // findProceedAspectPreferCaution returns a proceed aspect for the given signal, preferring the lowest-speed proceed aspect.
@@ -565,23 +832,79 @@ func (e *SuggestionEngine) findProceedAspectPreferCaution(sig *SignalItem) *Sign
     return best
 }
 
-// parseConflictingRouteID tries to extract a route ID from a StandardManager CanActivate error string
-// expected format contains: "conflicting route <ID> is active"
-func parseConflictingRouteID(msg string) string {
-    // Very small helper; avoid regex for performance
-    parts := strings.Split(msg, " ")
-    for i := 0; i+2 < len(parts); i++ {
-        if strings.EqualFold(parts[i], "conflicting") && strings.EqualFold(parts[i+1], "route") {
-            return strings.Trim(parts[i+2], ": ")
+// isOccupied returns true if the given track item is occupied by a train,
+// may be because its train detection has failed, or is out of service, e.g.
+// under an engineering possession. In all cases no train can be routed
+// through it.
+func isOccupied(ti TrackItem) bool {
+    return ti.Occupied() || ti.Blocked()
+}
+
+// failedPointsFrom returns the IDs of the failed points that prevent setting
+// some of the routes starting at the given signal. Trains waiting at such a
+// signal may be diverted over the routes that remain available.
+func (e *SuggestionEngine) failedPointsFrom(sig *SignalItem) []string {
+    var res []string
+    seen := make(map[string]bool)
+    for _, r := range e.sim.routesByBeginSignal[sig.ID()] {
+        for _, pi := range r.failedPoints() {
+            if !seen[pi.ID()] {
+                seen[pi.ID()] = true
+                res = append(res, pi.ID())
+            }
         }
     }
-    return ""
+    sort.Strings(res)
+    return res
+}
+
+// diversionReason returns the reason added to the suggestions that divert a
+// train around the given failed points.
+func diversionReason(failedPoints []string) string {
+    return fmt.Sprintf(" Diverts around failed points %s.", strings.Join(failedPoints, ", "))
 }
 
-// routeHasAnyTrain returns true if any position along the route is currently occupied by a train
+// singleLineFlow returns the score adjustment and the explanation for the
+// activation of route r for train t over single line sections. Routes that
+// follow the current flow of a single line are favoured, and a train does not
+// claim a free or empty single line before an opposing train that is closer to
+// it. Routes that reverse an empty single line say so in their reason.
+func (e *SuggestionEngine) singleLineFlow(t *Train, r *Route) (float64, string) {
+    var score float64
+    var reason string
+    for _, s := range e.sim.SingleLines() {
+        dir, ok := s.routeDirection(r)
+        if !ok {
+            continue
+        }
+        if s.Direction() == dir {
+            score += 2.0
+            reason += fmt.Sprintf(" Follows the %s flow on single line %s.", dir, s.Name)
+            continue
+        }
+        if s.Direction() != SingleLineFree {
+            reason += fmt.Sprintf(" Reverses single line %s to %s.", s.Name, dir)
+        }
+        own, ok := s.approach(t, singleLineLookahead)
+        for _, sa := range s.IncomingTrains(singleLineLookahead) {
+            if sa.Train == t || s.positionDirection(sa.entry) == dir {
+                continue
+            }
+            if !ok || sa.Distance < own.Distance {
+                score -= 5.0
+                reason += fmt.Sprintf(" Opposing train %s is closer to single line %s.", sa.Train.ServiceCode, s.Name)
+                break
+            }
+        }
+    }
+    return score, reason
+}
+
+// routeHasAnyTrain returns true if any position along the route is currently
+// occupied by a train, or may be because its train detection has failed
 func routeHasAnyTrain(r *Route) bool {
     for _, pos := range r.Positions {
-        if pos.TrackItem().TrainPresent() {
+        if pos.TrackItem().Occupied() {
             return true
         }
     }
@@ -605,21 +928,88 @@ func (e *SuggestionEngine) distanceToSignal(t *Train, sig *SignalItem) float64 {
     return math.MaxFloat64 // Signal not found ahead
 }
 
-// estimateTimeToReach estimates time for train to reach a distance at current speed
+// speedZone is a stretch of line, measured from a train head, over which the
+// train cannot run faster than speed
+type speedZone struct {
+    from  float64
+    to    float64
+    speed float64
+}
+
+// approachControlZones returns the stretches within distance ahead of the train
+// over which it must slow down to release an approach controlled signal that is
+// still at danger
+func approachControlZones(t *Train, distance float64) []speedZone {
+    var zones []speedZone
+    travelled := 0.0
+    for pos := t.TrainHead; !pos.IsOut() && travelled <= distance; pos = pos.Next(DirectionCurrent) {
+        if sig, ok := pos.TrackItem().(*SignalItem); ok && sig.IsOnPosition(pos) && !sig.ActiveAspect().MeansProceed() {
+            if d, v, ok := sig.approachRelease(); ok {
+                zones = append(zones, speedZone{from: math.Max(0, travelled-d), to: travelled, speed: v})
+            }
+        }
+        if pos.TrackItem().RealLength() > 0 {
+            travelled += pos.TrackItem().RealLength() - pos.PositionOnTI
+        }
+    }
+    return zones
+}
+
+// estimateTimeToReach estimates time for train to reach a distance from its current speed,
+// following the acceleration curve of its type up to the speed limits of the track items ahead
+// and slowing down in front of approach controlled signals at danger
 func (e *SuggestionEngine) estimateTimeToReach(t *Train, distance float64) time.Duration {
     if t.Speed <= 0 {
         return time.Hour // Stopped train
     }
+    tt := t.TrainType()
     // Consider deceleration if approaching signal
+    braking := t.ApplicableAction().Speed < t.Speed
     avgSpeed := t.Speed
-    if t.ApplicableAction().Speed < t.Speed {
+    if braking {
         // Train is braking, use average of current and target speed
         avgSpeed = (t.Speed + t.ApplicableAction().Speed) / 2
     }
     if avgSpeed <= 0 {
         avgSpeed = 0.5 // Minimum speed to avoid division by zero
     }
-    seconds := distance / avgSpeed
+    seconds := 0.0
+    remaining := distance
+    pos := t.TrainHead
+    speed := avgSpeed
+    zones := approachControlZones(t, distance)
+    for remaining > 0 && !pos.IsOut() {
+        length := math.Min(pos.TrackItem().RealLength()-pos.PositionOnTI, remaining)
+        if length > 0 {
+            limit := math.Min(tt.MaxSpeed, t.ApplicableAction().Speed)
+            if lineSpeed := pos.TrackItem().MaxSpeed(); lineSpeed > 0 && lineSpeed < limit {
+                limit = lineSpeed
+            }
+            if braking {
+                limit = avgSpeed
+            }
+            if restriction := pos.TrackItem().SpeedRestriction(); restriction > 0 && restriction < limit {
+                limit = restriction
+            }
+            if balancing := tt.balancingSpeed(pos.resistance()); balancing < limit {
+                // Climbing or curved section
+                limit = balancing
+            }
+            covered := distance - remaining
+            for _, z := range zones {
+                if z.from < covered+length && z.to > covered && z.speed < limit {
+                    // Slowing down to release an approach controlled signal
+                    limit = z.speed
+                }
+            }
+            seconds += tt.runningTime(length, &speed, limit, pos.resistance())
+            remaining -= length
+        }
+        pos = pos.Next(DirectionCurrent)
+    }
+    if remaining > 0 {
+        seconds += remaining / speed
+    }
     return time.Duration(seconds * float64(time.Second))
 }
 
@@ -641,12 +1031,10 @@ func (e *SuggestionEngine) distanceToTrackItemStart(t *Train, ti TrackItem) floa
 }
 
 // predictsCrossingConflictOnRoute checks if activating the route for train t could lead to
-// a collision at a crossing (conflict items) with another approaching train.
+// a collision at a crossing (conflict items) with another approaching train, including
+// on the overlap of the route.
 func (e *SuggestionEngine) predictsCrossingConflictOnRoute(t *Train, r *Route) (bool, string) {
-    for i, pos := range r.Positions {
-        if i == 0 {
-            continue
-        }
+    for _, pos := range r.protectedPositions() {
         if pred, reason := e.predictsCrossingConflictForItem(t, pos.TrackItem()); pred {
             return true, reason
         }
@@ -676,7 +1064,7 @@ func (e *SuggestionEngine) predictsCrossingConflictForItem(t *Train, ti TrackIte
         return false, ""
     }
     // Immediate occupancy on the conflict item blocks
-    if conflict.TrainPresent() {
+    if isOccupied(conflict) {
         return true, fmt.Sprintf("conflict item %s is occupied", conflict.ID())
     }
     // Predictive: find nearest approaching train to the conflict item
@@ -755,32 +1143,88 @@ func (e *SuggestionEngine) routeRespectsTrackCodeWithinPlace(r *Route, placeCode
     return true
 }
 
-// nextMustStopLine finds the next service line with MustStop=true from the train's perspective.
-func (e *SuggestionEngine) nextMustStopLine(t *Train) *ServiceLine {
-    if t.Service() == nil || t.NextPlaceIndex == NoMorePlace {
+// divertToPlatform returns the shortest path from the given signal to a track
+// of the place of sl other than its planned track, whose platform is long
+// enough for train t, or nil if there is none.
+func (e *SuggestionEngine) divertToPlatform(t *Train, sig *SignalItem, sl *ServiceLine) *RoutePath {
+    pl := sl.Place()
+    if pl == nil {
         return nil
     }
-    // If currently stopped at a stop place, look ahead from the next index
-    start := t.NextPlaceIndex
-    if t.Status == Stopped {
-        start = t.NextPlaceIndex + 1
+    codes := pl.TrackCodes()
+    if len(codes) == 0 {
+        // Tracks are not numbered, any track of the place will do
+        path, err := e.sim.FindRoutePath(sig.ID(), pl.PlaceCode)
+        if err != nil {
+            return nil
+        }
+        return path
     }
-    for i := start; i < len(t.Service().Lines); i++ {
-        sl := t.Service().Lines[i]
-        if sl.MustStop {
-            return sl
+    var best *RoutePath
+    for _, tc := range codes {
+        if tc == sl.TrackCode || t.fitsPlatform(pl, tc) != nil {
+            continue
+        }
+        path, err := e.sim.FindRoutePath(sig.ID(), pl.PlaceCode+"/"+tc)
+        if err != nil {
+            continue
+        }
+        if best == nil || path.Length < best.Length {
+            best = path
         }
     }
-    return nil
+    return best
+}
+
+// throughRoute returns the path of several routes leading train t from signal
+// sig to its next stop when no single route reaches it, or nil if there is no
+// such path or if one of its routes cannot be set now.
+func (e *SuggestionEngine) throughRoute(t *Train, sig *SignalItem) *RoutePath {
+    nsl := e.nextMustStopLine(t)
+    if nsl == nil || nsl.PlaceCode == "" || sig.nextActiveRoute != nil {
+        return nil
+    }
+    target := nsl.PlaceCode
+    if nsl.TrackCode != "" {
+        target += "/" + nsl.TrackCode
+    }
+    path, err := e.sim.FindRoutePath(sig.ID(), target)
+    if err != nil || len(path.Routes) < 2 {
+        return nil
+    }
+    for _, r := range path.Routes {
+        for _, rm := range routesManagers {
+            if rm.CanActivate(r) != nil {
+                return nil
+            }
+        }
+        if r.checkOverlaps() != nil || r.checkSingleLines() != nil || r.checkPlatformLengths(t) != nil {
+            return nil
+        }
+        for _, pos := range r.Positions[1:] {
+            if !pos.TrackItem().Equals(t.TrainHead.TrackItem()) && isOccupied(pos.TrackItem()) {
+                return nil
+            }
+        }
+        if pred, _ := e.predictsCrossingConflictOnRoute(t, r); pred {
+            return nil
+        }
+        if pred, _ := e.predictsHeadOnConflictOnRoute(t, r); pred {
+            return nil
+        }
+    }
+    return path
+}
+
+// nextMustStopLine finds the next service line with MustStop=true from the train's perspective.
+func (e *SuggestionEngine) nextMustStopLine(t *Train) *ServiceLine {
+    return t.nextStopLine()
 }
 
 // predictsHeadOnConflictOnRoute checks if activating the route for train t could lead to
-// a head-on collision with another train approaching any item on the route.
+// a head-on collision with another train approaching any item on the route or its overlap.
 func (e *SuggestionEngine) predictsHeadOnConflictOnRoute(t *Train, r *Route) (bool, string) {
-    for i, pos := range r.Positions {
-        if i == 0 {
-            continue
-        }
+    for _, pos := range r.protectedPositions() {
         if pred, reason := e.predictsHeadOnConflictForItem(t, pos.TrackItem()); pred {
             return true, reason
         }
@@ -889,6 +1333,49 @@ func (e *SuggestionEngine) Accept(id string) error {
             return fmt.Errorf("unknown train: %d", tid)
         }
         return e.sim.Trains[tid].ProceedWithCaution()
+    case SuggestionTrainCoast:
+        if len(parts) < 2 {
+            return fmt.Errorf("invalid coast id")
+        }
+        tid := mustAtoi(parts[1])
+        if tid < 0 || tid >= len(e.sim.Trains) {
+            return fmt.Errorf("unknown train: %d", tid)
+        }
+        return e.sim.Trains[tid].Coast()
+    case SuggestionPlatformReassign:
+        if len(parts) < 4 {
+            return fmt.Errorf("invalid platform reassignment id")
+        }
+        // parts[1] serviceCode, parts[2] line index, parts[3] trackCode
+        return e.sim.AssignPlatform(parts[1], mustAtoi(parts[2]), parts[3])
+    case SuggestionRouteDiversion:
+        if len(parts) < 3 {
+            return fmt.Errorf("invalid route diversion id")
+        }
+        // parts[1] trainId (unused), parts[2] routeIds joined with +
+        var routes []*Route
+        for _, rid := range strings.Split(parts[2], "+") {
+            rte, ok := e.sim.Routes[rid]
+            if !ok {
+                return fmt.Errorf("unknown route: %s", rid)
+            }
+            routes = append(routes, rte)
+        }
+        return activateRoutes(routes, false)
+    case SuggestionThroughRoute:
+        if len(parts) < 3 {
+            return fmt.Errorf("invalid through route id")
+        }
+        // parts[1] trainId (unused), parts[2] routeIds joined with +
+        var routes []*Route
+        for _, rid := range strings.Split(parts[2], "+") {
+            rte, ok := e.sim.Routes[rid]
+            if !ok {
+                return fmt.Errorf("unknown route: %s", rid)
+            }
+            routes = append(routes, rte)
+        }
+        return activateRoutes(routes, false)
     case SuggestionSignalOverride:
         if len(parts) < 3 {
             return fmt.Errorf("invalid signal override id")
@@ -927,46 +1414,44 @@ func (e *SuggestionEngine) Reject(id string, minutes int) {
     e.RejectUntil(id, until)
 }
 
-// Exported helpers for server layer
-func GetSuggestionEngine() *SuggestionEngine {
-    return suggestionEngine
+// SuggestionEngine returns the suggestion engine of this simulation, or nil
+// if the simulation is not initialized.
+func (sim *Simulation) SuggestionEngine() *SuggestionEngine {
+    return sim.suggestionEngine
 }
 
-func AcceptSuggestion(id string) error {
-    if suggestionEngine == nil {
+// AcceptSuggestion applies the actions of the suggestion with the given id.
+func (sim *Simulation) AcceptSuggestion(id string) error {
+    if sim.suggestionEngine == nil {
         return fmt.Errorf("suggestion engine not initialized")
     }
-    return suggestionEngine.Accept(id)
+    return sim.suggestionEngine.Accept(id)
 }
 
-func RejectSuggestion(id string, minutes int) error {
-    if suggestionEngine == nil {
+// RejectSuggestion hides the suggestion with the given id for the given minutes.
+func (sim *Simulation) RejectSuggestion(id string, minutes int) error {
+    if sim.suggestionEngine == nil {
         return fmt.Errorf("suggestion engine not initialized")
     }
-    suggestionEngine.Reject(id, minutes)
+    sim.suggestionEngine.Reject(id, minutes)
     return nil
 }
 
-func RecomputeSuggestions() {
-    if suggestionEngine == nil {
+// RecomputeSuggestions computes the suggestions of this simulation now.
+func (sim *Simulation) RecomputeSuggestions() {
+    if sim.suggestionEngine == nil {
         return
     }
-    suggestionEngine.Recompute()
-}
-
-// ResetSuggestionEngine rebinds the suggestions engine to the provided simulation.
-// It discards previous engine state (including rejections) and starts fresh.
-func ResetSuggestionEngine(sim *Simulation) {
-    suggestionEngine = NewSuggestionEngine(sim)
+    sim.suggestionEngine.Recompute()
 }
 
 // MarshalJSON for Suggestions so it serializes cleanly in events
 func (s Suggestions) MarshalJSON() ([]byte, error) {
     type aux struct {
         Items       []Suggestion `json:"items"`
-        GeneratedAt Time         `json:"generatedAt"`
+        GeneratedAt string       `json:"generatedAt"`
     }
-    a := aux{Items: s.Items, GeneratedAt: s.GeneratedAt}
+    a := aux{Items: s.Items, GeneratedAt: formatObjectTime(s.simulation, s.GeneratedAt.Time)}
     return json.Marshal(a)
 }
 
@@ -22,6 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -84,8 +86,33 @@ func (dg DelayGenerator) MarshalJSON() ([]byte, error) {
 	return json.Marshal(data)
 }
 
+// A randSource yields random numbers
+type randSource interface {
+	Intn(n int) int
+	Float64() float64
+}
+
+// globalRand is the randSource of the math/rand package
+type globalRand struct{}
+
+// Intn calls rand.Intn
+func (globalRand) Intn(n int) int {
+	return rand.Intn(n)
+}
+
+// Float64 calls rand.Float64
+func (globalRand) Float64() float64 {
+	return rand.Float64()
+}
+
 // Yield a delay from this DelayGenerator
 func (dg DelayGenerator) Yield() time.Duration {
+	return dg.yieldFrom(globalRand{})
+}
+
+// yieldFrom yields a delay from this DelayGenerator using the random numbers
+// of r.
+func (dg DelayGenerator) yieldFrom(r randSource) time.Duration {
 	probas := []int{0}
 	cumsum := 0
 	for _, p := range dg.data {
@@ -94,7 +121,7 @@ func (dg DelayGenerator) Yield() time.Duration {
 	}
 
 	// First determine our segment
-	r0 := rand.Intn(100)
+	r0 := r.Intn(100)
 	seg := 0
 	for i := 0; i < len(probas)-1; i++ {
 		if probas[i] <= r0 && r0 <= probas[i+1] {
@@ -108,7 +135,7 @@ func (dg DelayGenerator) Yield() time.Duration {
 	}
 
 	// Then pick up a number inside our segment
-	r1 := rand.Float64()
+	r1 := r.Float64()
 	return time.Duration(r1*float64(dg.data[seg].high-dg.data[seg].low)+float64(dg.data[seg].low)) * time.Second
 }
 
@@ -141,30 +168,82 @@ func (h Time) ID() string {
 }
 
 // UnmarshalJSON for the Time type
+//
+// RFC3339 date times, as written by simulations in real date mode, are kept
+// as they are until the simulation turns them into simulation times with
+// rebaseTime.
 func (h *Time) UnmarshalJSON(data []byte) error {
 	var hourStr string
 	if err := json.Unmarshal(data, &hourStr); err != nil {
 		return fmt.Errorf("times should be encoded as 00:00:00 strings in JSON, got %s instead", data)
 	}
+	if dt, err := time.Parse(time.RFC3339, hourStr); err == nil {
+		h.Time = dt
+		return nil
+	}
 	*h = ParseTime(hourStr)
 	return nil
 }
 
 // MarshalJSON for the Time type
+//
+// Times are written in the 15:04:05 format of FormatTime. The objects of a
+// simulation write their times with formatObjectTime instead, so that they
+// follow the date mode of the simulation.
 func (h Time) MarshalJSON() ([]byte, error) {
-	return json.Marshal(h.Time.Format("15:04:05"))
+	return json.Marshal(FormatTime(h.Time))
 }
 
-// ParseTime returns a Time object from its string representation in format 15:04:05
+// isDateTime returns true if h has been read from a date time and not yet
+// turned into a simulation time. Simulation times are in year 0 and zero
+// times in year 1.
+func (h *Time) isDateTime() bool {
+	return h.Year() > 1
+}
+
+// firstDay is the start of the first day of the simulation
+var firstDay = time.Date(0, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+// ParseTime returns a Time object from its string representation in format 15:04:05.
+//
+// Hours of 24 and more are times of the following days of the simulation, so
+// that "25:30:00" is 01:30:00 on the second day.
 func ParseTime(data string) Time {
+	var days int
+	if parts := strings.SplitN(data, ":", 2); len(parts) == 2 && len(parts[0]) >= 2 {
+		if h, err := strconv.Atoi(parts[0]); err == nil && h >= 24 {
+			days = h / 24
+			data = fmt.Sprintf("%02d:%s", h%24, parts[1])
+		}
+	}
 	t, err := time.Parse("15:04:05", data)
 	if err != nil {
 		return Time{}
 	}
 	// We add 24 hours to make a difference between 00:00:00 and an empty Time
 	return Time{
-		Time: t.Add(24 * time.Hour),
+		Time: t.Add(time.Duration(days+1) * 24 * time.Hour),
+	}
+}
+
+// FormatTime returns t in the format 15:04:05, with hours counted from the
+// start of the first day of the simulation, so that the result can be parsed
+// back with ParseTime.
+func FormatTime(t time.Time) string {
+	if t.Before(firstDay) {
+		return t.Format("15:04:05")
+	}
+	d := t.Sub(firstDay)
+	return fmt.Sprintf("%02d:%s", int(d/time.Hour), t.Format("04:05"))
+}
+
+// dayNumber returns the day of the simulation of t, starting at 0 for the
+// first day.
+func dayNumber(t time.Time) int {
+	if t.Before(firstDay) {
+		return 0
 	}
+	return int(t.Sub(firstDay) / (24 * time.Hour))
 }
 
 // Add returns the time h + duration .
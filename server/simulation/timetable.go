@@ -0,0 +1,230 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"time"
+)
+
+// A ServiceLineChange describes the changes to make to a line of a service.
+// Nil fields are left unchanged and zero times clear the scheduled time.
+type ServiceLineChange struct {
+	ScheduledArrivalTime   *Time   `json:"scheduledArrivalTime"`
+	ScheduledDepartureTime *Time   `json:"scheduledDepartureTime"`
+	TrackCode              *string `json:"trackCode"`
+	MustStop               *bool   `json:"mustStop"`
+}
+
+// serviceLine returns the service with the given code and checks that index
+// is one of its lines.
+func (sim *Simulation) serviceLine(code string, index int) (*Service, error) {
+	s, ok := sim.Services[code]
+	if !ok {
+		return nil, fmt.Errorf("unknown service: %s", code)
+	}
+	if index < 0 || index >= len(s.Lines) {
+		return nil, fmt.Errorf("service %s has no line %d", code, index)
+	}
+	return s, nil
+}
+
+// checkServiceLineTimes returns an error if the line departs before it arrives
+func checkServiceLineTimes(sl *ServiceLine) error {
+	if sl.ScheduledArrivalTime.IsZero() || sl.ScheduledDepartureTime.IsZero() {
+		return nil
+	}
+	if sl.ScheduledDepartureTime.Time.Before(sl.ScheduledArrivalTime.Time) {
+		return fmt.Errorf("departure time %s is before arrival time %s",
+			FormatTime(sl.ScheduledDepartureTime.Time), FormatTime(sl.ScheduledArrivalTime.Time))
+	}
+	return nil
+}
+
+// UpdateServiceLine changes the scheduled times, the track code or the stop
+// of the line at index of the service with the given code, while the
+// simulation runs. Trains running this service follow the new timetable.
+func (sim *Simulation) UpdateServiceLine(code string, index int, c ServiceLineChange) error {
+	s, err := sim.serviceLine(code, index)
+	if err != nil {
+		return err
+	}
+	for _, h := range []*Time{c.ScheduledArrivalTime, c.ScheduledDepartureTime} {
+		if err := sim.rebaseTime(h); err != nil {
+			return err
+		}
+	}
+	old := s.Lines[index]
+	sl := ServiceLine{
+		MustStop:               old.MustStop,
+		PlaceCode:              old.PlaceCode,
+		ScheduledArrivalTime:   Time{Time: old.ScheduledArrivalTime.Time},
+		ScheduledDepartureTime: Time{Time: old.ScheduledDepartureTime.Time},
+		TrackCode:              old.TrackCode,
+		OriginalTrackCode:      old.OriginalTrackCode,
+		ExpectedDepartureTime:  old.ExpectedDepartureTime,
+		service:                s,
+	}
+	if c.ScheduledArrivalTime != nil {
+		sl.ScheduledArrivalTime = Time{Time: c.ScheduledArrivalTime.Time}
+	}
+	if c.ScheduledDepartureTime != nil {
+		sl.ScheduledDepartureTime = Time{Time: c.ScheduledDepartureTime.Time}
+	}
+	if c.TrackCode != nil {
+		// The timetable itself changes
+		sl.TrackCode = *c.TrackCode
+		sl.OriginalTrackCode = ""
+	}
+	if c.MustStop != nil {
+		sl.MustStop = *c.MustStop
+	}
+	if err := checkServiceLineTimes(&sl); err != nil {
+		return err
+	}
+	s.Lines[index] = &sl
+	sim.timetableChanged(s, fmt.Sprintf("Timetable of service %s changed at %s", code, sl.PlaceCode))
+	return nil
+}
+
+// SetExpectedDeparture sets the departure time of the line at index of the
+// service with the given code, as predicted by an external traffic
+// management system. The train running the service does not depart from
+// this line before this time, but its delay is still measured against the
+// timetable. A zero time clears the prediction.
+func (sim *Simulation) SetExpectedDeparture(code string, index int, t time.Time) error {
+	s, err := sim.serviceLine(code, index)
+	if err != nil {
+		return err
+	}
+	line := s.Lines[index]
+	if t.IsZero() {
+		if line.ExpectedDepartureTime == nil {
+			return nil
+		}
+		line.ExpectedDepartureTime = nil
+		sim.timetableChanged(s, fmt.Sprintf("Expected departure of service %s at %s cleared", code, line.PlaceCode))
+		return nil
+	}
+	if !line.ScheduledArrivalTime.IsZero() && t.Before(line.ScheduledArrivalTime.Time) {
+		return fmt.Errorf("expected departure time %s is before arrival time %s",
+			FormatTime(t), FormatTime(line.ScheduledArrivalTime.Time))
+	}
+	line.ExpectedDepartureTime = &Time{Time: t}
+	sim.timetableChanged(s, fmt.Sprintf("Service %s expected to depart %s at %s", code, line.PlaceCode, FormatTime(t)))
+	return nil
+}
+
+// InsertServiceLine inserts sl as the line at index of the service with the
+// given code, shifting the following lines. An index equal to the number of
+// lines appends sl to the service.
+//
+// A train running the service that has not reached index yet will call at
+// the new place. A train already stopped at the line at index keeps its
+// current stop and will call at the new place afterwards.
+func (sim *Simulation) InsertServiceLine(code string, index int, sl *ServiceLine) error {
+	s, ok := sim.Services[code]
+	if !ok {
+		return fmt.Errorf("unknown service: %s", code)
+	}
+	if index < 0 || index > len(s.Lines) {
+		return fmt.Errorf("cannot insert line %d in service %s which has %d lines", index, code, len(s.Lines))
+	}
+	if _, ok := sim.Places[sl.PlaceCode]; !ok {
+		return fmt.Errorf("unknown place: %s", sl.PlaceCode)
+	}
+	if err := sim.rebaseServiceLine(sl); err != nil {
+		return err
+	}
+	if err := checkServiceLineTimes(sl); err != nil {
+		return err
+	}
+	sl.service = s
+	s.Lines = append(s.Lines, nil)
+	copy(s.Lines[index+1:], s.Lines[index:])
+	s.Lines[index] = sl
+	for _, t := range sim.trainsOfService(code) {
+		if t.NextPlaceIndex > index || (t.NextPlaceIndex == index && t.Status == Stopped) {
+			t.NextPlaceIndex++
+		}
+	}
+	sim.timetableChanged(s, fmt.Sprintf("Service %s now calls at %s", code, sl.PlaceCode))
+	return nil
+}
+
+// RemoveServiceLine removes the line at index of the service with the given
+// code. It fails if a train running the service is stopped at this line, or
+// if this line is the last one left to call at for a train.
+func (sim *Simulation) RemoveServiceLine(code string, index int) error {
+	s, err := sim.serviceLine(code, index)
+	if err != nil {
+		return err
+	}
+	if len(s.Lines) == 1 {
+		return fmt.Errorf("cannot remove the only line of service %s", code)
+	}
+	trains := sim.trainsOfService(code)
+	for _, t := range trains {
+		if t.NextPlaceIndex != index {
+			continue
+		}
+		if t.Status == Stopped {
+			return fmt.Errorf("train %s is stopped at %s", t.ID(), s.Lines[index].PlaceCode)
+		}
+		if index == len(s.Lines)-1 {
+			return fmt.Errorf("%s is the last place train %s has to call at", s.Lines[index].PlaceCode, t.ID())
+		}
+	}
+	placeCode := s.Lines[index].PlaceCode
+	s.Lines = append(s.Lines[:index], s.Lines[index+1:]...)
+	for _, t := range trains {
+		if t.NextPlaceIndex > index {
+			t.NextPlaceIndex--
+		}
+	}
+	sim.timetableChanged(s, fmt.Sprintf("Service %s does not call at %s anymore", code, placeCode))
+	return nil
+}
+
+// trainsOfService returns the trains that run the service with the given
+// code and have not finished it.
+func (sim *Simulation) trainsOfService(code string) []*Train {
+	var res []*Train
+	for _, t := range sim.Trains {
+		if t.ServiceCode != code || t.NextPlaceIndex == NoMorePlace || t.Status == Joined || t.Status == Cancelled {
+			continue
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// timetableChanged notifies clients that the timetable of s has changed,
+// with the trains running it, and makes the suggestion engine take the new
+// timetable into account.
+func (sim *Simulation) timetableChanged(s *Service, msg string) {
+	sim.MessageLogger.addMessage(msg, simulationMsg)
+	sim.sendEvent(&Event{Name: ServiceChangedEvent, Object: s})
+	for _, t := range sim.trainsOfService(s.ID()) {
+		sim.sendEvent(&Event{Name: TrainChangedEvent, Object: t})
+	}
+	if sim.suggestionEngine != nil && sim.Options.SuggestionsEnabled {
+		sim.suggestionEngine.Recompute()
+	}
+}
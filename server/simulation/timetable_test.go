@@ -0,0 +1,131 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestTimetableEditing(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing runtime timetable editing", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		srv := sim.Services["S003"]
+		train := sim.Trains[1]
+		So(train.ServiceCode, ShouldEqual, "S003")
+		train.Status = simulation.Running
+		train.NextPlaceIndex = 1
+		Convey("Updating a line should change its times, track and stop", func() {
+			arr := simulation.ParseTime("06:05:00")
+			dep := simulation.ParseTime("06:06:00")
+			track := "2"
+			mustStop := false
+			So(sim.UpdateServiceLine("S003", 1, simulation.ServiceLineChange{
+				ScheduledArrivalTime:   &arr,
+				ScheduledDepartureTime: &dep,
+				TrackCode:              &track,
+				MustStop:               &mustStop,
+			}), ShouldBeNil)
+			So(srv.Lines[1].ScheduledArrivalTime.Time, ShouldResemble, arr.Time)
+			So(srv.Lines[1].ScheduledDepartureTime.Time, ShouldResemble, dep.Time)
+			So(srv.Lines[1].TrackCode, ShouldEqual, "2")
+			So(srv.Lines[1].MustStop, ShouldBeFalse)
+			So(srv.Lines[1].Place().PlaceCode, ShouldEqual, "STN")
+			Convey("Unless departure is before arrival", func() {
+				early := simulation.ParseTime("06:04:00")
+				So(sim.UpdateServiceLine("S003", 1, simulation.ServiceLineChange{ScheduledDepartureTime: &early}), ShouldNotBeNil)
+				So(srv.Lines[1].ScheduledDepartureTime.Time, ShouldResemble, dep.Time)
+			})
+		})
+		Convey("Unknown services and lines should be refused", func() {
+			So(sim.UpdateServiceLine("XXX", 0, simulation.ServiceLineChange{}), ShouldNotBeNil)
+			So(sim.UpdateServiceLine("S003", 3, simulation.ServiceLineChange{}), ShouldNotBeNil)
+			So(sim.InsertServiceLine("S003", 4, &simulation.ServiceLine{PlaceCode: "STN"}), ShouldNotBeNil)
+			So(sim.InsertServiceLine("S003", 0, &simulation.ServiceLine{PlaceCode: "XXX"}), ShouldNotBeNil)
+			So(sim.RemoveServiceLine("S003", -1), ShouldNotBeNil)
+		})
+		Convey("Inserting lines should keep trains on their next stop", func() {
+			So(sim.InsertServiceLine("S003", 0, &simulation.ServiceLine{PlaceCode: "RGT"}), ShouldBeNil)
+			So(srv.Lines, ShouldHaveLength, 4)
+			So(srv.Lines[0].Place().PlaceCode, ShouldEqual, "RGT")
+			So(train.NextPlaceIndex, ShouldEqual, 2)
+			So(srv.Lines[train.NextPlaceIndex].PlaceCode, ShouldEqual, "STN")
+			Convey("Unless the new line is the next stop", func() {
+				So(sim.InsertServiceLine("S003", 2, &simulation.ServiceLine{PlaceCode: "LFT", MustStop: true}), ShouldBeNil)
+				So(train.NextPlaceIndex, ShouldEqual, 2)
+				So(srv.Lines[train.NextPlaceIndex].PlaceCode, ShouldEqual, "LFT")
+			})
+			Convey("Or the train is stopped at the next stop", func() {
+				train.Status = simulation.Stopped
+				So(sim.InsertServiceLine("S003", 2, &simulation.ServiceLine{PlaceCode: "LFT", MustStop: true}), ShouldBeNil)
+				So(train.NextPlaceIndex, ShouldEqual, 3)
+				So(srv.Lines[train.NextPlaceIndex].PlaceCode, ShouldEqual, "STN")
+			})
+		})
+		Convey("Removing lines should keep trains on their next stop", func() {
+			So(sim.RemoveServiceLine("S003", 0), ShouldBeNil)
+			So(srv.Lines, ShouldHaveLength, 2)
+			So(train.NextPlaceIndex, ShouldEqual, 0)
+			So(srv.Lines[train.NextPlaceIndex].PlaceCode, ShouldEqual, "STN")
+			Convey("Unless the train is stopped at the removed line", func() {
+				train.Status = simulation.Stopped
+				So(sim.RemoveServiceLine("S003", 0), ShouldNotBeNil)
+			})
+			Convey("Or it is the last place the train has to call at", func() {
+				train.NextPlaceIndex = 1
+				So(sim.RemoveServiceLine("S003", 1), ShouldNotBeNil)
+				So(sim.RemoveServiceLine("S003", 0), ShouldBeNil)
+				So(train.NextPlaceIndex, ShouldEqual, 0)
+				So(sim.RemoveServiceLine("S003", 0), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestExpectedDepartures(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing expected departure times", t, func() {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		line := sim.Services["S001"].Lines[1]
+		train := sim.Trains[0]
+		Convey("Expected departures cannot be before arrival", func() {
+			So(sim.SetExpectedDeparture("S001", 1, simulation.ParseTime("06:01:00").Time), ShouldNotBeNil)
+			So(sim.SetExpectedDeparture("S001", 2, simulation.ParseTime("06:05:00").Time), ShouldNotBeNil)
+			So(line.ExpectedDepartureTime, ShouldBeNil)
+		})
+		Convey("Expected departures are saved and can be cleared", func() {
+			So(sim.SetExpectedDeparture("S001", 1, simulation.ParseTime("06:04:00").Time), ShouldBeNil)
+			out, err := json.Marshal(line)
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, `"expectedDepartureTime":"06:04:00"`)
+			So(sim.SetExpectedDeparture("S001", 1, simulation.Time{}.Time), ShouldBeNil)
+			So(line.ExpectedDepartureTime, ShouldBeNil)
+			out, _ = json.Marshal(line)
+			So(string(out), ShouldNotContainSubstring, "expectedDepartureTime")
+		})
+		Convey("Trains do not depart before their expected departure time", func() {
+			So(sim.SetExpectedDeparture("S001", 1, simulation.ParseTime("06:04:00").Time), ShouldBeNil)
+			So(stepUntil(&sim, 2000, func() bool { return train.Status == simulation.Stopped && train.NextPlaceIndex == 1 }), ShouldBeTrue)
+			So(stepUntil(&sim, 2000, func() bool { return train.ServiceCode != "S001" || train.Status != simulation.Stopped }), ShouldBeTrue)
+			So(sim.Options.CurrentTime.Time.Format("15:04:05"), ShouldBeGreaterThanOrEqualTo, "06:04:00")
+			// The timetable itself is unchanged
+			So(simulation.FormatTime(line.ScheduledDepartureTime.Time), ShouldEqual, "06:02:00")
+		})
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+// Names of the operations reported to the registered Tracer
+const (
+	// OperationStep is a clock tick of the simulation
+	OperationStep = "simulation.step"
+	// OperationSuggestions is a computation of the suggestions
+	OperationSuggestions = "suggestions.compute"
+)
+
+// A Tracer measures the operations of the simulations, for instance to export
+// their durations to a monitoring system.
+type Tracer interface {
+	// StartOperation is called when the operation with the given name starts
+	// on sim. The returned function is called when the operation ends.
+	StartOperation(sim *Simulation, name string) func()
+}
+
+var tracer Tracer
+
+// RegisterTracer registers the given tracer for all simulations.
+//
+// If a tracer was already registered, it is replaced by t. It must be called
+// before the simulations are started.
+func RegisterTracer(t Tracer) {
+	tracer = t
+}
+
+// startOperation reports to the registered tracer that the operation with the
+// given name starts, and returns the function to call when it ends.
+func (sim *Simulation) startOperation(name string) func() {
+	if tracer == nil {
+		return func() {}
+	}
+	return tracer.StartOperation(sim, name)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// testTracer records the started and ended operations
+type testTracer struct {
+	started []string
+	ended   []string
+}
+
+func (tt *testTracer) StartOperation(sim *simulation.Simulation, name string) func() {
+	tt.started = append(tt.started, name)
+	return func() {
+		tt.ended = append(tt.ended, name)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	Convey("Testing the tracing of the simulation operations", t, func() {
+		endChan := make(chan struct{})
+		defer close(endChan)
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		var sim simulation.Simulation
+		So(json.Unmarshal(data, &sim), ShouldBeNil)
+		drainEvents(&sim, endChan)
+		So(sim.Initialize(), ShouldBeNil)
+		tt := new(testTracer)
+		simulation.RegisterTracer(tt)
+		defer simulation.RegisterTracer(nil)
+		Convey("Steps should be traced", func() {
+			sim.Step()
+			So(tt.started, ShouldContain, simulation.OperationStep)
+			So(tt.ended, ShouldContain, simulation.OperationStep)
+			So(tt.started, ShouldHaveLength, len(tt.ended))
+		})
+		Convey("Suggestion computations should be traced", func() {
+			sim.RecomputeSuggestions()
+			So(tt.started, ShouldResemble, []string{simulation.OperationSuggestions})
+			So(tt.ended, ShouldResemble, []string{simulation.OperationSuggestions})
+		})
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+)
+
+// A TrackCircuitFailureMode describes what the failed train detection of a
+// track item shows to the signaller.
+type TrackCircuitFailureMode string
+
+const (
+	// TrackCircuitShowsOccupied is a track circuit that shows the item
+	// occupied whether a train is on it or not.
+	TrackCircuitShowsOccupied TrackCircuitFailureMode = "SHOW_OCCUPIED"
+	// TrackCircuitShowsClear is a track circuit that does not detect the
+	// trains on the item any more.
+	TrackCircuitShowsClear TrackCircuitFailureMode = "SHOW_CLEAR"
+)
+
+// ParseTrackCircuitFailureMode returns the TrackCircuitFailureMode with the
+// given name. An empty name gives TrackCircuitShowsOccupied.
+func ParseTrackCircuitFailureMode(name string) (TrackCircuitFailureMode, error) {
+	switch TrackCircuitFailureMode(name) {
+	case "", TrackCircuitShowsOccupied:
+		return TrackCircuitShowsOccupied, nil
+	case TrackCircuitShowsClear:
+		return TrackCircuitShowsClear, nil
+	}
+	return "", fmt.Errorf("unknown track circuit failure mode: %s", name)
+}
+
+// DetectionFailure returns how the train detection of this item has failed,
+// or an empty string if it works.
+func (t *trackStruct) DetectionFailure() TrackCircuitFailureMode {
+	return t.detectionFailure
+}
+
+// ShowsOccupied returns true if the train detection of this item shows it
+// occupied to the signaller. It differs from TrainPresent when the detection
+// has failed.
+func (t *trackStruct) ShowsOccupied() bool {
+	switch t.detectionFailure {
+	case TrackCircuitShowsOccupied:
+		return true
+	case TrackCircuitShowsClear:
+		return false
+	}
+	return t.TrainPresent()
+}
+
+// Occupied returns true if the signalling must consider this item occupied,
+// that is if a train is detected on it or if its detection has failed,
+// whatever it shows.
+func (t *trackStruct) Occupied() bool {
+	return t.detectionFailure != "" || t.TrainPresent()
+}
+
+// setDetectionFailure sets the failure mode of the train detection of this
+// item, or repairs it if mode is empty, and updates the signals.
+func (t *trackStruct) setDetectionFailure(mode TrackCircuitFailureMode) {
+	if t.detectionFailure == mode {
+		return
+	}
+	t.detectionFailure = mode
+	for _, trigger := range t.triggers {
+		trigger(t.full())
+	}
+	t.simulation.updateAllSignals()
+	t.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: t.full(),
+	})
+}
+
+// applyDetectionFailure sets the train detection of the given item according
+// to the active track circuit failures on it. An item shows occupied if any of
+// them does.
+func (sim *Simulation) applyDetectionFailure(id string) {
+	var mode TrackCircuitFailureMode
+	for _, d := range sim.activeDisruptionsOn(id, DisruptionTrackCircuitFailed) {
+		if mode == "" || d.FailureMode == TrackCircuitShowsOccupied {
+			mode = d.FailureMode
+		}
+	}
+	sim.TrackItems[id].setDetectionFailure(mode)
+}
+
+// updateAllSignals recomputes the aspect of all the signals of the simulation
+func (sim *Simulation) updateAllSignals() {
+	for _, ti := range sim.sortedTrackItems() {
+		if si, ok := ti.(*SignalItem); ok {
+			si.updateSignalState()
+		}
+	}
+}
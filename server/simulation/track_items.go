@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // bigFloat is a large number used for the length of an EndItem. It must be bigger
@@ -40,6 +41,7 @@ const (
 	TypeTrack         TrackItemType = "TrackItem"
 	TypeLine          TrackItemType = "LineItem"
 	TypeInvisibleLink TrackItemType = "InvisibleLinkItem"
+	TypeLevelCrossing TrackItemType = "LevelCrossingItem"
 	TypeEnd           TrackItemType = "EndItem"
 	TypeSignal        TrackItemType = "SignalItem"
 	TypePoints        TrackItemType = "PointsItem"
@@ -151,6 +153,14 @@ type TrackItem interface {
 	// TrackCode returns the code (usually a number) of the track line
 	TrackCode() string
 
+	// Gradient returns the gradient of this item in per mille, positive if
+	// the track climbs from the previous item towards the next item.
+	Gradient() float64
+
+	// CurveRadius returns the radius in meters of the curve of this item, or
+	// 0 if the track is straight.
+	CurveRadius() float64
+
 	// FollowingItem returns the following TrackItem linked to this one,
 	// knowing we come from precedingItem(). Returned is either NextItem or
 	// PreviousItem, depending which way we come from.
@@ -188,6 +198,38 @@ type TrackItem interface {
 	// TrainPresent returns true if at least one train is present on this TrackItem
 	TrainPresent() bool
 
+	// DetectionFailure returns how the train detection of this item has
+	// failed, or an empty string if it works.
+	DetectionFailure() TrackCircuitFailureMode
+
+	// ShowsOccupied returns true if the train detection of this item shows
+	// it occupied to the signaller.
+	ShowsOccupied() bool
+
+	// Occupied returns true if the signalling must consider this item
+	// occupied, that is if a train is detected on it or if its detection
+	// has failed.
+	Occupied() bool
+
+	// setDetectionFailure sets the failure mode of the train detection of
+	// this item, or repairs it if mode is empty.
+	setDetectionFailure(TrackCircuitFailureMode)
+
+	// Blocked returns true if this TrackItem is blocked, i.e. closed to traffic.
+	Blocked() bool
+
+	// SetBlocked blocks or unblocks this TrackItem. Routes cannot be set
+	// through a blocked item and trains stop before entering it.
+	SetBlocked(bool)
+
+	// SpeedRestriction returns the temporary speed limit imposed on this
+	// TrackItem in meters per second, or 0 if there is none.
+	SpeedRestriction() float64
+
+	// SetSpeedRestriction imposes a temporary speed limit in meters per
+	// second on this TrackItem. A limit of 0 removes the restriction.
+	SetSpeedRestriction(float64)
+
 	// IsOnPosition returns true if this track item is the track item of the given position.
 	// When applicable, also checks if the item is in the same direction as the position.
 	IsOnPosition(Position) bool
@@ -213,6 +255,10 @@ type TrackItem interface {
 
 	// underlying returns the underlying trackStruct object
 	underlying() *trackStruct
+
+	// restoreCheckpointState sets the internal state of this TrackItem from
+	// a checkpoint
+	restoreCheckpointState(trackItemState, map[string]*Train)
 }
 
 // trackStruct is an abstract struct the pointer of which implements TrackItem
@@ -229,16 +275,21 @@ type trackStruct struct {
 	CustomProperties map[string]CustomProperty `json:"customProperties"`
 	PlaceCode        string                    `json:"placeCode"`
 	TsTrackCode      string                    `json:"trackCode"`
-
-	tsId           string
-	simulation     *Simulation
-	activeRoute    *Route
-	arPreviousItem TrackItem
-	selected       bool
-	trainEndsFW    map[*Train]float64
-	trainEndsBK    map[*Train]float64
-	trainEndMutex  sync.RWMutex
-	triggers       []func(TrackItem)
+	TsGradient       float64                   `json:"gradient"`
+	TsCurveRadius    float64                   `json:"curveRadius"`
+
+	tsId             string
+	simulation       *Simulation
+	activeRoute      *Route
+	arPreviousItem   TrackItem
+	selected         bool
+	blocked          bool
+	speedLimit       float64
+	trainEndsFW      map[*Train]float64
+	trainEndsBK      map[*Train]float64
+	trainEndMutex    sync.RWMutex
+	triggers         []func(TrackItem)
+	detectionFailure TrackCircuitFailureMode
 }
 
 // routeID returns the unique routeID of this TrackItem, which is the index of this
@@ -272,15 +323,24 @@ func (t *trackStruct) PreviousItem() TrackItem {
 }
 
 // MaxSpeed is the maximum allowed speed on this TrackItem in meters per second.
+//
+// MaxSpeed is 0 if the item is blocked and is capped by the speed restriction if any.
 func (t *trackStruct) MaxSpeed() float64 {
+	var maxSpeed float64
 	switch {
+	case t.blocked:
+		return 0
 	case t.TsMaxSpeed != 0:
-		return t.TsMaxSpeed
+		maxSpeed = t.TsMaxSpeed
 	case t.PlaceCode != "" && t.Place().TsMaxSpeed != 0:
-		return t.Place().TsMaxSpeed
+		maxSpeed = t.Place().TsMaxSpeed
 	default:
-		return t.simulation.Options.DefaultMaxSpeed
+		maxSpeed = t.simulation.Options.DefaultMaxSpeed
+	}
+	if t.speedLimit > 0 && t.speedLimit < maxSpeed {
+		return t.speedLimit
 	}
+	return maxSpeed
 }
 
 // RealLength is the length in meters that this TrackItem has in real life track length
@@ -288,6 +348,18 @@ func (t *trackStruct) RealLength() float64 {
 	return t.TsRealLength
 }
 
+// Gradient returns the gradient of this item in per mille, positive if the
+// track climbs from the previous item towards the next item.
+func (t *trackStruct) Gradient() float64 {
+	return t.TsGradient
+}
+
+// CurveRadius returns the radius in meters of the curve of this item, or 0 if
+// the track is straight.
+func (t *trackStruct) CurveRadius() float64 {
+	return t.TsCurveRadius
+}
+
 // Origin are the two coordinates (x, y) of the origin point of this TrackItem.
 func (t *trackStruct) Origin() Point {
 	return Point{t.X, t.Y}
@@ -400,15 +472,22 @@ func (t *trackStruct) trainTailActions(train *Train) {
 }
 
 // releaseRouteBehind automatically releases the route after train passed if applicable
+//
+// Routes are released element by element: the item behind is released as
+// soon as the train tail has cleared it, so that conflicting routes can be set
+// before the train has left the route. This also applies to persistent routes,
+// which are set again once the train has cleared them (see
+// SignalItem.releaseRouteBehind).
 func (t *trackStruct) releaseRouteBehind() {
 	if t.activeRoute == nil {
 		return
 	}
-	if t.activeRoute.State() != Activated && t.activeRoute.State() != Destroying {
+	state := t.activeRoute.State()
+	if state != Activated && state != Destroying && state != Persistent {
 		return
 	}
 	beginSignalNextRoute := t.activeRoute.BeginSignal().nextActiveRoute
-	if beginSignalNextRoute != nil && beginSignalNextRoute.routeID == t.activeRoute.routeID {
+	if state != Persistent && beginSignalNextRoute != nil && beginSignalNextRoute.routeID == t.activeRoute.routeID {
 		// same route has been set again
 		return
 	}
@@ -426,6 +505,43 @@ func (t *trackStruct) TrainPresent() bool {
 	return len(t.trainEndsFW)+len(t.trainEndsBK) > 0
 }
 
+// Blocked returns true if this TrackItem is blocked, i.e. closed to traffic.
+func (t *trackStruct) Blocked() bool {
+	return t.blocked
+}
+
+// SetBlocked blocks or unblocks this TrackItem. Routes cannot be set
+// through a blocked item and trains stop before entering it.
+func (t *trackStruct) SetBlocked(blocked bool) {
+	if t.blocked == blocked {
+		return
+	}
+	t.blocked = blocked
+	t.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: t.full(),
+	})
+}
+
+// SpeedRestriction returns the temporary speed limit imposed on this
+// TrackItem in meters per second, or 0 if there is none.
+func (t *trackStruct) SpeedRestriction() float64 {
+	return t.speedLimit
+}
+
+// SetSpeedRestriction imposes a temporary speed limit in meters per
+// second on this TrackItem. A limit of 0 removes the restriction.
+func (t *trackStruct) SetSpeedRestriction(limit float64) {
+	if t.speedLimit == limit {
+		return
+	}
+	t.speedLimit = limit
+	t.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: t.full(),
+	})
+}
+
 // resetActiveRoute resets route information on this item.
 func (t *trackStruct) resetActiveRoute() {
 	t.activeRoute = nil
@@ -542,6 +658,10 @@ func (t *trackStruct) asJSONStruct() jsonTrackStruct {
 		TrainEndsFW:      tEndsFW,
 		TrainEndsBK:      tEndsBK,
 		TsTrackCode:      t.TsTrackCode,
+		TsGradient:       t.TsGradient,
+		TsCurveRadius:    t.TsCurveRadius,
+		Blocked:          t.blocked,
+		SpeedRestriction: t.speedLimit,
 	}
 	return ai
 }
@@ -566,12 +686,26 @@ type jsonTrackStruct struct {
 	TrainEndsFW      map[string]float64        `json:"trainEndsFW"`
 	TrainEndsBK      map[string]float64        `json:"trainEndsBK"`
 	TsTrackCode      string                    `json:"trackCode"`
+	TsGradient       float64                   `json:"gradient"`
+	TsCurveRadius    float64                   `json:"curveRadius"`
+	Blocked          bool                      `json:"blocked"`
+	SpeedRestriction float64                   `json:"speedRestriction"`
 }
 
 // A Place is a special TrackItem representing a physical location such as a
 // station or a passing point. Note that Place items are not linked to other items.
 type Place struct {
 	trackStruct
+	// PlatformLengths are the usable lengths in meters of the platforms of
+	// this place, by track code. Platforms which are not listed have no
+	// length constraint.
+	PlatformLengths map[string]float64 `json:"platformLengths"`
+	// PassengerDemand is the passenger traffic at this place by period of
+	// the day.
+	PassengerDemand []PassengerDemand `json:"passengerDemand"`
+
+	waitingPassengers float64
+	lastBoarding      time.Time
 }
 
 // Type returns the name of the type of this item
@@ -579,6 +713,21 @@ func (pl *Place) Type() TrackItemType {
 	return TypePlace
 }
 
+// MarshalJSON method for Place
+func (pl *Place) MarshalJSON() ([]byte, error) {
+	type auxPlace struct {
+		jsonTrackStruct
+		PlatformLengths map[string]float64 `json:"platformLengths,omitempty"`
+		PassengerDemand []PassengerDemand  `json:"passengerDemand,omitempty"`
+	}
+	aPl := auxPlace{
+		jsonTrackStruct: pl.asJSONStruct(),
+		PlatformLengths: pl.PlatformLengths,
+		PassengerDemand: pl.PassengerDemand,
+	}
+	return json.Marshal(aPl)
+}
+
 var _ TrackItem = new(Place)
 
 // A LineItem is a resizable TrackItem that represent a simple railway line and
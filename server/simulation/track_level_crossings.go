@@ -0,0 +1,436 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Default timings of level crossings, used when the item does not define them.
+const (
+	DefaultStrikeInDistance     float64 = 1000
+	defaultLevelCrossingClosing         = 20 * time.Second
+	defaultLevelCrossingOpening         = 10 * time.Second
+)
+
+// A LevelCrossingState is the position of the barriers of a level crossing
+type LevelCrossingState string
+
+const (
+	// LevelCrossingOpen means the road is open and the railway is not protected
+	LevelCrossingOpen LevelCrossingState = "OPEN"
+	// LevelCrossingClosing means the lights are flashing and the barriers are
+	// being lowered
+	LevelCrossingClosing LevelCrossingState = "CLOSING"
+	// LevelCrossingClosed means the barriers are down and trains may pass
+	LevelCrossingClosed LevelCrossingState = "CLOSED"
+	// LevelCrossingOpening means the barriers are being raised
+	LevelCrossingOpening LevelCrossingState = "OPENING"
+)
+
+// A LevelCrossingFailureMode describes how the barriers of a failed level
+// crossing misbehave.
+type LevelCrossingFailureMode string
+
+const (
+	// BarriersStuckOpen are barriers that cannot be lowered. Signals
+	// protecting the crossing stay at danger.
+	BarriersStuckOpen LevelCrossingFailureMode = "STUCK_OPEN"
+	// BarriersStuckClosed are barriers that cannot be raised. The road stays
+	// closed.
+	BarriersStuckClosed LevelCrossingFailureMode = "STUCK_CLOSED"
+)
+
+// ParseLevelCrossingFailureMode returns the LevelCrossingFailureMode with the
+// given name. An empty name gives BarriersStuckOpen.
+func ParseLevelCrossingFailureMode(name string) (LevelCrossingFailureMode, error) {
+	switch LevelCrossingFailureMode(name) {
+	case "", BarriersStuckOpen:
+		return BarriersStuckOpen, nil
+	case BarriersStuckClosed:
+		return BarriersStuckClosed, nil
+	}
+	return "", fmt.Errorf("unknown level crossing failure mode: %s", name)
+}
+
+// LevelCrossingMetrics are the statistics of a level crossing since the
+// beginning of the simulation.
+type LevelCrossingMetrics struct {
+	// Closures is the number of times the road has been closed
+	Closures int `json:"closures"`
+	// ClosedSeconds is the total time the road has been closed, from the
+	// barriers being down to the barriers starting to rise
+	ClosedSeconds float64 `json:"closedSeconds"`
+	// ShortestClosureSeconds and LongestClosureSeconds are the extreme
+	// durations of the closures
+	ShortestClosureSeconds float64 `json:"shortestClosureSeconds"`
+	LongestClosureSeconds  float64 `json:"longestClosureSeconds"`
+	// TrainsPassed is the number of trains that have passed the crossing
+	TrainsPassed int `json:"trainsPassed"`
+	// MinWarningSeconds is the shortest time between the barriers being
+	// down and a train reaching the crossing
+	MinWarningSeconds float64 `json:"minWarningSeconds"`
+	// UnprotectedPassages is the number of trains that have reached the
+	// crossing while it was not closed
+	UnprotectedPassages int `json:"unprotectedPassages"`
+}
+
+// A LevelCrossingItem is a piece of line crossed by a road at the same level.
+//
+// In automatic mode, the crossing closes when a train comes within its
+// strike-in distance and opens again when no train is approaching or on the
+// crossing any more. Closing and opening take ClosingSeconds and
+// OpeningSeconds. In manual mode, the crossing is only closed and opened on
+// request. Signals protecting the crossing use the LEVEL_CROSSING_CLOSED
+// condition so that trains do not reach it before it is closed.
+type LevelCrossingItem struct {
+	LineItem
+	// StrikeInDistance is the distance in metres before the crossing at which
+	// an approaching train starts the closing sequence
+	StrikeInDistance float64 `json:"strikeInDistance"`
+	ClosingSeconds   int     `json:"closingSeconds"`
+	OpeningSeconds   int     `json:"openingSeconds"`
+
+	state        LevelCrossingState
+	since        time.Time
+	manual       bool
+	manualClosed bool
+	failureMode  LevelCrossingFailureMode
+	failureCause string
+	repairAt     time.Time
+	metrics      LevelCrossingMetrics
+}
+
+// Type returns the name of the type of this item
+func (lc *LevelCrossingItem) Type() TrackItemType {
+	return TypeLevelCrossing
+}
+
+// initialize this level crossing and register it in the simulation
+func (lc *LevelCrossingItem) initialize() error {
+	if err := lc.LineItem.initialize(); err != nil {
+		return err
+	}
+	if lc.StrikeInDistance < 0 || lc.ClosingSeconds < 0 || lc.OpeningSeconds < 0 {
+		return fmt.Errorf("level crossing %s: negative strike-in distance or timings", lc.ID())
+	}
+	if lc.state == "" {
+		lc.state = LevelCrossingOpen
+	}
+	sim := lc.simulation
+	i := sort.Search(len(sim.levelCrossings), func(i int) bool {
+		return sim.levelCrossings[i].ID() >= lc.ID()
+	})
+	if i < len(sim.levelCrossings) && sim.levelCrossings[i].ID() == lc.ID() {
+		sim.levelCrossings[i] = lc
+		return nil
+	}
+	sim.levelCrossings = append(sim.levelCrossings, nil)
+	copy(sim.levelCrossings[i+1:], sim.levelCrossings[i:])
+	sim.levelCrossings[i] = lc
+	return nil
+}
+
+// State returns the current position of the barriers of this crossing
+func (lc *LevelCrossingItem) State() LevelCrossingState {
+	return lc.state
+}
+
+// IsClosed returns true if the road is closed and trains may pass
+func (lc *LevelCrossingItem) IsClosed() bool {
+	return lc.state == LevelCrossingClosed
+}
+
+// IsManual returns true if this crossing is operated manually
+func (lc *LevelCrossingItem) IsManual() bool {
+	return lc.manual
+}
+
+// Metrics returns the statistics of this crossing
+func (lc *LevelCrossingItem) Metrics() LevelCrossingMetrics {
+	return lc.metrics
+}
+
+// strikeInDistance returns the strike-in distance of this crossing
+func (lc *LevelCrossingItem) strikeInDistance() float64 {
+	if lc.StrikeInDistance > 0 {
+		return lc.StrikeInDistance
+	}
+	return DefaultStrikeInDistance
+}
+
+// closingTime returns the time needed to close this crossing
+func (lc *LevelCrossingItem) closingTime() time.Duration {
+	if lc.ClosingSeconds > 0 {
+		return time.Duration(lc.ClosingSeconds) * time.Second
+	}
+	return defaultLevelCrossingClosing
+}
+
+// openingTime returns the time needed to open this crossing
+func (lc *LevelCrossingItem) openingTime() time.Duration {
+	if lc.OpeningSeconds > 0 {
+		return time.Duration(lc.OpeningSeconds) * time.Second
+	}
+	return defaultLevelCrossingOpening
+}
+
+// TrainApproaching returns true if a train is on this crossing or is heading
+// to it within its strike-in distance.
+func (lc *LevelCrossingItem) TrainApproaching() bool {
+	if lc.TrainPresent() {
+		return true
+	}
+	for _, t := range lc.simulation.Trains {
+		if !t.IsActive() {
+			continue
+		}
+		travelled := -t.TrainHead.PositionOnTI
+		for pos := t.TrainHead; !pos.IsOut() && travelled <= lc.strikeInDistance(); pos = pos.Next(DirectionCurrent) {
+			if pos.TrackItemID == lc.ID() {
+				return true
+			}
+			travelled += pos.TrackItem().RealLength()
+		}
+	}
+	return false
+}
+
+// SetManual switches this crossing to manual operation, in which it keeps
+// its current state until Close or Open is called, or back to automatic
+// operation.
+func (lc *LevelCrossingItem) SetManual(manual bool) {
+	lc.manual = manual
+	lc.manualClosed = lc.state == LevelCrossingClosing || lc.state == LevelCrossingClosed
+	lc.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: lc})
+}
+
+// Close starts the closing sequence of this crossing and switches it to
+// manual operation.
+func (lc *LevelCrossingItem) Close() error {
+	if lc.failureMode == BarriersStuckOpen {
+		return fmt.Errorf("barriers of level crossing %s are stuck open", lc.ID())
+	}
+	lc.manual = true
+	lc.manualClosed = true
+	lc.update()
+	return nil
+}
+
+// Open starts the opening sequence of this crossing and switches it to
+// manual operation. It is refused while a train is approaching.
+func (lc *LevelCrossingItem) Open() error {
+	if lc.failureMode == BarriersStuckClosed {
+		return fmt.Errorf("barriers of level crossing %s are stuck closed", lc.ID())
+	}
+	if lc.TrainApproaching() {
+		return fmt.Errorf("a train is approaching level crossing %s", lc.ID())
+	}
+	lc.manual = true
+	lc.manualClosed = false
+	lc.update()
+	return nil
+}
+
+// setState changes the state of the crossing and updates its metrics and the
+// signals protecting it.
+func (lc *LevelCrossingItem) setState(state LevelCrossingState) {
+	now := lc.simulation.Options.CurrentTime.Time
+	if lc.state == LevelCrossingClosed {
+		closure := now.Sub(lc.since).Seconds()
+		m := &lc.metrics
+		m.ClosedSeconds += closure
+		if m.Closures == 1 || closure < m.ShortestClosureSeconds {
+			m.ShortestClosureSeconds = closure
+		}
+		if closure > m.LongestClosureSeconds {
+			m.LongestClosureSeconds = closure
+		}
+	}
+	if state == LevelCrossingClosed {
+		lc.metrics.Closures++
+	}
+	lc.state = state
+	lc.since = now
+	for _, trigger := range lc.triggers {
+		trigger(lc)
+	}
+	lc.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: lc})
+	lc.simulation.sendEvent(&Event{Name: LevelCrossingChangedEvent, Object: lc})
+}
+
+// update moves the barriers of this crossing according to its demand
+func (lc *LevelCrossingItem) update() {
+	now := lc.simulation.Options.CurrentTime.Time
+	if !lc.repairAt.IsZero() && !now.Before(lc.repairAt) {
+		lc.Repair()
+	}
+	switch lc.failureMode {
+	case BarriersStuckOpen:
+		if lc.state != LevelCrossingOpen {
+			lc.setState(LevelCrossingOpen)
+		}
+		return
+	case BarriersStuckClosed:
+		if lc.state != LevelCrossingClosed {
+			lc.setState(LevelCrossingClosed)
+		}
+		return
+	}
+	closed := lc.manualClosed
+	if !lc.manual {
+		closed = lc.TrainApproaching()
+	}
+	elapsed := now.Sub(lc.since)
+	switch lc.state {
+	case LevelCrossingOpen:
+		if closed {
+			lc.setState(LevelCrossingClosing)
+		}
+	case LevelCrossingClosing:
+		if elapsed >= lc.closingTime() {
+			lc.setState(LevelCrossingClosed)
+		}
+	case LevelCrossingClosed:
+		if !closed {
+			lc.setState(LevelCrossingOpening)
+		}
+	case LevelCrossingOpening:
+		switch {
+		case closed:
+			lc.setState(LevelCrossingClosing)
+		case elapsed >= lc.openingTime():
+			lc.setState(LevelCrossingOpen)
+		}
+	}
+}
+
+// trainHeadActions records the passage of the train on the crossing
+func (lc *LevelCrossingItem) trainHeadActions(train *Train) {
+	m := &lc.metrics
+	m.TrainsPassed++
+	if lc.state != LevelCrossingClosed {
+		m.UnprotectedPassages++
+		lc.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s passed level crossing %s while it was not closed (%s)",
+			train.ServiceCode, lc.Name(), lc.state), playerWarningMsg)
+	} else {
+		warning := lc.simulation.Options.CurrentTime.Time.Sub(lc.since).Seconds()
+		if m.TrainsPassed-m.UnprotectedPassages == 1 || warning < m.MinWarningSeconds {
+			m.MinWarningSeconds = warning
+		}
+	}
+	lc.LineItem.trainHeadActions(train)
+}
+
+// Failed returns true if the barriers of this crossing are malfunctioning
+func (lc *LevelCrossingItem) Failed() bool {
+	return lc.failureMode != ""
+}
+
+// FailureMode returns how the barriers of this crossing have failed, or an
+// empty string if they are operational.
+func (lc *LevelCrossingItem) FailureMode() LevelCrossingFailureMode {
+	return lc.failureMode
+}
+
+// FailureCause returns why this crossing is malfunctioning, if it is.
+func (lc *LevelCrossingItem) FailureCause() string {
+	return lc.failureCause
+}
+
+// RepairTime returns the simulation time at which this crossing will be
+// repaired. It is zero if the crossing is not malfunctioning or if it will
+// only be repaired on request.
+func (lc *LevelCrossingItem) RepairTime() time.Time {
+	return lc.repairAt
+}
+
+// Fail makes the barriers of this crossing malfunction with the given mode.
+// The crossing is repaired automatically after repairIn, or only when Repair
+// is called if repairIn is 0.
+func (lc *LevelCrossingItem) Fail(mode LevelCrossingFailureMode, cause string, repairIn time.Duration) {
+	lc.failureMode = mode
+	lc.failureCause = cause
+	lc.repairAt = time.Time{}
+	if repairIn > 0 {
+		lc.repairAt = lc.simulation.Options.CurrentTime.Time.Add(repairIn)
+	}
+	lc.simulation.MessageLogger.addMessage(fmt.Sprintf("Level crossing %s has failed (%s)", lc.Name(), mode), simulationMsg)
+	lc.update()
+	lc.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: lc})
+	lc.simulation.sendEvent(&Event{Name: LevelCrossingFailedEvent, Object: lc})
+}
+
+// Repair puts the barriers of this crossing back in service.
+func (lc *LevelCrossingItem) Repair() {
+	if lc.failureMode == "" {
+		return
+	}
+	lc.failureMode = ""
+	lc.failureCause = ""
+	lc.repairAt = time.Time{}
+	lc.simulation.MessageLogger.addMessage(fmt.Sprintf("Level crossing %s has been repaired", lc.Name()), simulationMsg)
+	lc.simulation.sendEvent(&Event{Name: TrackItemChangedEvent, Object: lc})
+	lc.simulation.sendEvent(&Event{Name: LevelCrossingRepairedEvent, Object: lc})
+}
+
+// MarshalJSON method for LevelCrossingItem
+func (lc *LevelCrossingItem) MarshalJSON() ([]byte, error) {
+	type auxLC struct {
+		jsonTrackStruct
+		Xf               float64              `json:"xf"`
+		Yf               float64              `json:"yf"`
+		StrikeInDistance float64              `json:"strikeInDistance"`
+		ClosingSeconds   int                  `json:"closingSeconds"`
+		OpeningSeconds   int                  `json:"openingSeconds"`
+		State            LevelCrossingState   `json:"state"`
+		Manual           bool                 `json:"manual"`
+		FailureMode      string               `json:"failureMode"`
+		Metrics          LevelCrossingMetrics `json:"metrics"`
+	}
+	return json.Marshal(auxLC{
+		jsonTrackStruct:  lc.asJSONStruct(),
+		Xf:               lc.Xf,
+		Yf:               lc.Yf,
+		StrikeInDistance: lc.StrikeInDistance,
+		ClosingSeconds:   lc.ClosingSeconds,
+		OpeningSeconds:   lc.OpeningSeconds,
+		State:            lc.state,
+		Manual:           lc.manual,
+		FailureMode:      string(lc.failureMode),
+		Metrics:          lc.metrics,
+	})
+}
+
+// LevelCrossings returns the level crossings of the simulation, sorted by ID.
+func (sim *Simulation) LevelCrossings() []*LevelCrossingItem {
+	return sim.levelCrossings
+}
+
+// updateLevelCrossings moves the barriers of all level crossings
+func (sim *Simulation) updateLevelCrossings() {
+	for _, lc := range sim.levelCrossings {
+		lc.update()
+	}
+}
+
+var _ TrackItem = new(LevelCrossingItem)
@@ -18,7 +18,10 @@
 
 package simulation
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // A PointsItemManager simulates the physical points, in particular delay in points
 // position and breakdowns
@@ -82,6 +85,12 @@ type PointsItem struct {
 	Yr          float64 `json:"yr"`
 	ReverseTiId string  `json:"reverseTiId"`
 	PairedTiId  string  `json:"pairedTiId"`
+
+	locked       bool
+	failureMode  PointsFailureMode
+	failureCause string
+	repairAt     time.Time
+	flankRoutes  []*Route
 }
 
 // Type returns the name of the type of this item
@@ -146,6 +155,24 @@ func (pi *PointsItem) Reversed() bool {
 	return dir == DirectionReversed
 }
 
+// Locked returns true if these points are locked in their current direction.
+// Routes requiring the other direction cannot be activated.
+func (pi *PointsItem) Locked() bool {
+	return pi.locked
+}
+
+// SetLocked locks or unlocks these points in their current direction.
+func (pi *PointsItem) SetLocked(locked bool) {
+	if pi.locked == locked {
+		return
+	}
+	pi.locked = locked
+	pi.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: pi,
+	})
+}
+
 // IsConnected returns true if this TrackItem is connected to the given
 // TrackItem, false otherwise
 func (pi *PointsItem) IsConnected(oti TrackItem) bool {
@@ -187,7 +214,7 @@ func (pi *PointsItem) FollowingItem(precedingItem TrackItem, dir PointDirection)
 // setActiveRoute sets the given route as active on this PointsItem.
 // previous gives the direction.
 func (pi *PointsItem) setActiveRoute(r *Route, previous TrackItem) {
-	if r != nil {
+	if r != nil && !pi.locked && !pi.Failed() {
 		pointsItemManager.SetDirection(pi, r.Directions[pi.ID()])
 	}
 	// Send event for pairedItem
@@ -215,6 +242,14 @@ func (pi *PointsItem) MarshalJSON() ([]byte, error) {
 		ReverseTiId string  `json:"reverseTiId"`
 		PairedTiId  string  `json:"pairedTiId"`
 		Reversed    bool    `json:"reversed"`
+		Locked      bool    `json:"locked"`
+		FlankLocked bool    `json:"flankLocked"`
+		FailureMode string  `json:"failureMode"`
+		RepairTime  string  `json:"repairTime"`
+	}
+	var repairTime string
+	if !pi.repairAt.IsZero() {
+		repairTime = pi.repairAt.Format(time.RFC3339)
 	}
 	aPI := auxPI{
 		jsonTrackStruct: pi.asJSONStruct(),
@@ -227,6 +262,10 @@ func (pi *PointsItem) MarshalJSON() ([]byte, error) {
 		ReverseTiId:     pi.ReverseTiId,
 		PairedTiId:      pi.PairedTiId,
 		Reversed:        pi.Reversed(),
+		Locked:          pi.locked,
+		FlankLocked:     pi.FlankLocked(),
+		FailureMode:     string(pi.failureMode),
+		RepairTime:      repairTime,
 	}
 	return json.Marshal(aPI)
 }
@@ -236,6 +236,17 @@ func (st *SignalType) getDefaultAspect() *SignalAspect {
 	return st.States[len(st.States)-1].Aspect
 }
 
+// getDangerAspect returns the most restrictive aspect of this SignalType, i.e.
+// the last aspect that does not mean proceed.
+func (st *SignalType) getDangerAspect() *SignalAspect {
+	for i := len(st.States) - 1; i >= 0; i-- {
+		if !st.States[i].Aspect.MeansProceed() {
+			return st.States[i].Aspect
+		}
+	}
+	return st.getDefaultAspect()
+}
+
 // GetAspect returns the aspect that signal should show according to this SignalType logic.
 func (st *SignalType) GetAspect(signal *SignalItem) *SignalAspect {
 	for _, state := range st.States {
@@ -262,6 +273,10 @@ type SignalItem struct {
 	activeAspect        *SignalAspect
 	manualOverride      bool
 	manualAspect        *SignalAspect
+	failed              bool
+	failureMode         SignalFailureMode
+	failureCause        string
+	repairAt            time.Time
 	lastChanged         time.Time
 }
 
@@ -413,6 +428,19 @@ func (si *SignalItem) releaseRouteBehind() {
 	if si.activeRoute != nil && si.activeRoute.State() != Persistent {
 		si.resetActiveRoute()
 	}
+	// End signal of a persistent route: release its last element and set it
+	// again for the next trains, unless a conflicting route has been set on
+	// the elements released behind the train.
+	if si.previousActiveRoute != nil && si.previousActiveRoute.State() == Persistent {
+		r := si.previousActiveRoute
+		if pi := si.PreviousItem(); pi.ActiveRoute() != nil && pi.ActiveRoute().Equals(r) {
+			pi.resetActiveRoute()
+		}
+		if err := r.Activate(true); err != nil {
+			si.simulation.MessageLogger.addMessage(fmt.Sprintf("Persistent route %s cancelled: %s", r.ID(), err), simulationMsg)
+			_ = r.Deactivate()
+		}
+	}
 	// End signal
 	if si.previousActiveRoute != nil && si.previousActiveRoute.State() != Persistent {
 		beginSignalNextRoute := si.previousActiveRoute.BeginSignal().nextActiveRoute
@@ -435,9 +463,12 @@ func (si *SignalItem) updateSignalState(previous ...bool) {
 		return
 	}
 	oldAspect := si.activeAspect
-	if si.manualOverride && si.manualAspect != nil {
+	switch {
+	case si.Failed():
+		si.activeAspect = si.SignalType().getDangerAspect()
+	case si.manualOverride && si.manualAspect != nil:
 		si.activeAspect = si.manualAspect
-	} else {
+	default:
 		switch signalItemManager {
 		case nil:
 			si.activeAspect = si.SignalType().GetAspect(si)
@@ -495,6 +526,9 @@ func (si *SignalItem) MarshalJSON() ([]byte, error) {
 		PreviousActiveRoute string  `json:"previousActiveRoute"`
 		NextActiveRoute     string  `json:"nextActiveRoute"`
 		ActiveAspect        string  `json:"activeAspect"`
+		Failed              bool    `json:"failed"`
+		FailureMode         string  `json:"failureMode"`
+		RepairTime          string  `json:"repairTime"`
 		LastChanged         string  `json:"lastChanged"`
 	}
 	var parID, narID string
@@ -504,6 +538,10 @@ func (si *SignalItem) MarshalJSON() ([]byte, error) {
 	if si.nextActiveRoute != nil {
 		narID = si.nextActiveRoute.ID()
 	}
+	var repairTime string
+	if !si.repairAt.IsZero() {
+		repairTime = si.repairAt.Format(time.RFC3339)
+	}
 	var trainID string
 	if si.train != nil {
 		trainID = si.train.ID()
@@ -518,6 +556,9 @@ func (si *SignalItem) MarshalJSON() ([]byte, error) {
 		PreviousActiveRoute: parID,
 		NextActiveRoute:     narID,
 		ActiveAspect:        si.activeAspect.Name,
+		Failed:              si.Failed(),
+		FailureMode:         string(si.FailureMode()),
+		RepairTime:          repairTime,
 		LastChanged:         si.lastChanged.Format(time.RFC3339),
 	}
 	d, err := json.Marshal(aSI)
@@ -538,6 +579,22 @@ func (si *SignalItem) SetManualAspect(a *SignalAspect) {
     si.updateSignalState()
 }
 
+// Failed returns true if this signal has failed, either through a
+// SIGNAL_FAILED disruption or a malfunction. A failed signal shows its most
+// restrictive aspect whatever the routes and manual override.
+func (si *SignalItem) Failed() bool {
+	return si.failed || si.failureMode != ""
+}
+
+// SetFailed sets whether this signal is failed by a SIGNAL_FAILED disruption.
+func (si *SignalItem) SetFailed(failed bool) {
+	if si.failed == failed {
+		return
+	}
+	si.failed = failed
+	si.updateSignalState()
+}
+
 func (si *SignalItem) LastChangedRFC3339() string {
     if si.lastChanged.IsZero() {
         return ""
@@ -18,7 +18,10 @@
 
 package simulation
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // TrainType defines a rolling stock type.
 type TrainType struct {
@@ -30,6 +33,15 @@ type TrainType struct {
 	StdAccel     float64  `json:"stdAccel"`
 	StdBraking   float64  `json:"stdBraking"`
 	ElementsStr  []string `json:"elements"`
+	// Capacity is the number of passengers this train type can carry. 0
+	// means unlimited.
+	Capacity float64 `json:"capacity"`
+	// AccelCurve is the acceleration depending on the speed. StdAccel is
+	// used if it is empty.
+	AccelCurve PerformanceCurve `json:"accelCurve"`
+	// BrakingCurve is the standard braking rate depending on the speed.
+	// StdBraking is used if it is empty.
+	BrakingCurve PerformanceCurve `json:"brakingCurve"`
 
 	simulation *Simulation
 }
@@ -45,8 +57,15 @@ func (tt *TrainType) setSimulation(sim *Simulation) {
 }
 
 // initialize this train type
-func (tt *TrainType) initialize(code string) {
+func (tt *TrainType) initialize(code string) error {
 	tt.code = code
+	if err := tt.AccelCurve.check(); err != nil {
+		return fmt.Errorf("invalid acceleration curve: %s", err)
+	}
+	if err := tt.BrakingCurve.check(); err != nil {
+		return fmt.Errorf("invalid braking curve: %s", err)
+	}
+	return nil
 }
 
 // Elements() returns the train types this TrainType is composed of.
@@ -61,14 +80,17 @@ func (tt *TrainType) Elements() []*TrainType {
 // MarshalJSON for the TrainType type
 func (tt *TrainType) MarshalJSON() ([]byte, error) {
 	type auxTT struct {
-		ID           string   `json:"id"`
-		Description  string   `json:"description"`
-		EmergBraking float64  `json:"emergBraking"`
-		Length       float64  `json:"length"`
-		MaxSpeed     float64  `json:"maxSpeed"`
-		StdAccel     float64  `json:"stdAccel"`
-		StdBraking   float64  `json:"stdBraking"`
-		ElementsStr  []string `json:"elements"`
+		ID           string           `json:"id"`
+		Description  string           `json:"description"`
+		EmergBraking float64          `json:"emergBraking"`
+		Length       float64          `json:"length"`
+		MaxSpeed     float64          `json:"maxSpeed"`
+		StdAccel     float64          `json:"stdAccel"`
+		StdBraking   float64          `json:"stdBraking"`
+		ElementsStr  []string         `json:"elements"`
+		Capacity     float64          `json:"capacity"`
+		AccelCurve   PerformanceCurve `json:"accelCurve,omitempty"`
+		BrakingCurve PerformanceCurve `json:"brakingCurve,omitempty"`
 	}
 	att := auxTT{
 		ID:           tt.ID(),
@@ -79,6 +101,9 @@ func (tt *TrainType) MarshalJSON() ([]byte, error) {
 		StdAccel:     tt.StdAccel,
 		StdBraking:   tt.StdBraking,
 		ElementsStr:  tt.ElementsStr,
+		Capacity:     tt.Capacity,
+		AccelCurve:   tt.AccelCurve,
+		BrakingCurve: tt.BrakingCurve,
 	}
 	return json.Marshal(att)
 }
@@ -0,0 +1,271 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectiveMetric is the KPI on which an objective of a training scenario is
+// evaluated
+type ObjectiveMetric string
+
+const (
+	// ObjectivePunctuality is the percentage of arrivals and departures on
+	// time since the scenario started
+	ObjectivePunctuality ObjectiveMetric = "punctuality"
+	// ObjectiveAverageDelay is the average delay in minutes of the trains
+	// over the last hour
+	ObjectiveAverageDelay ObjectiveMetric = "averageDelay"
+	// ObjectiveOpenConflicts is the number of route conflicts not resolved
+	ObjectiveOpenConflicts ObjectiveMetric = "openConflicts"
+	// ObjectiveCancellations is the number of trains cancelled since the
+	// scenario started
+	ObjectiveCancellations ObjectiveMetric = "cancellations"
+	// ObjectiveMissedConnections is the number of connections missed since
+	// the scenario started
+	ObjectiveMissedConnections ObjectiveMetric = "missedConnections"
+	// ObjectiveSPADs is the number of signals passed at danger since the
+	// scenario started
+	ObjectiveSPADs ObjectiveMetric = "spads"
+	// ObjectiveOverspeeds is the number of trains that ran too fast since
+	// the scenario started
+	ObjectiveOverspeeds ObjectiveMetric = "overspeeds"
+	// ObjectiveScore is the score in percent of the dispatcher session
+	// started with the scenario
+	ObjectiveScore ObjectiveMetric = "score"
+)
+
+// objectiveMetrics are the valid objective metrics
+var objectiveMetrics = map[ObjectiveMetric]bool{
+	ObjectivePunctuality:       true,
+	ObjectiveAverageDelay:      true,
+	ObjectiveOpenConflicts:     true,
+	ObjectiveCancellations:     true,
+	ObjectiveMissedConnections: true,
+	ObjectiveSPADs:             true,
+	ObjectiveOverspeeds:        true,
+	ObjectiveScore:             true,
+}
+
+// ObjectiveMode tells how an objective is met
+type ObjectiveMode string
+
+const (
+	// ObjectiveReach objectives are met as soon as their condition holds
+	// before their deadline
+	ObjectiveReach ObjectiveMode = "REACH"
+	// ObjectiveMaintain objectives are failed as soon as their condition
+	// does not hold before their deadline
+	ObjectiveMaintain ObjectiveMode = "MAINTAIN"
+)
+
+// A TrainingObjective is a condition on a KPI that the dispatcher must
+// fulfil during a training scenario, e.g. punctuality >= 90 by 10:00:00.
+//
+// An empty Deadline means that the objective is evaluated until the
+// scenario is stopped.
+type TrainingObjective struct {
+	Description string          `json:"description"`
+	Metric      ObjectiveMetric `json:"metric"`
+	Operator    string          `json:"operator"`
+	Value       float64         `json:"value"`
+	Deadline    string          `json:"deadline"`
+	Mode        ObjectiveMode   `json:"mode"`
+
+	deadline time.Time
+}
+
+// DeadlineTime returns the deadline of this objective, or a zero time if it
+// has none.
+func (o *TrainingObjective) DeadlineTime() time.Time {
+	return o.deadline
+}
+
+// Holds returns true if the given value of the metric fulfils the condition
+// of this objective
+func (o *TrainingObjective) Holds(value float64) bool {
+	switch o.Operator {
+	case ">=":
+		return value >= o.Value
+	case ">":
+		return value > o.Value
+	case "<=":
+		return value <= o.Value
+	case "<":
+		return value < o.Value
+	}
+	return false
+}
+
+// String returns the condition of this objective, e.g. "punctuality >= 90
+// by 10:00:00"
+func (o *TrainingObjective) String() string {
+	res := fmt.Sprintf("%s %s %v", o.Metric, o.Operator, o.Value)
+	if o.Deadline == "" {
+		return res
+	}
+	if o.Mode == ObjectiveMaintain {
+		return res + " until " + o.Deadline
+	}
+	return res + " by " + o.Deadline
+}
+
+// initialize checks this objective
+func (o *TrainingObjective) initialize() error {
+	if !objectiveMetrics[o.Metric] {
+		return fmt.Errorf("unknown metric %s", o.Metric)
+	}
+	switch o.Operator {
+	case ">=", ">", "<=", "<":
+	default:
+		return fmt.Errorf("unknown operator %q", o.Operator)
+	}
+	if math.IsNaN(o.Value) || math.IsInf(o.Value, 0) {
+		return fmt.Errorf("invalid value %v", o.Value)
+	}
+	o.Mode = ObjectiveMode(strings.ToUpper(string(o.Mode)))
+	switch o.Mode {
+	case "":
+		o.Mode = ObjectiveReach
+	case ObjectiveReach, ObjectiveMaintain:
+	default:
+		return fmt.Errorf("unknown mode %s", o.Mode)
+	}
+	if o.Deadline != "" {
+		o.deadline = ParseTime(o.Deadline).Time
+		if o.deadline.IsZero() {
+			return fmt.Errorf("invalid deadline %q, expected HH:MM:SS", o.Deadline)
+		}
+	}
+	return nil
+}
+
+// A TrainingDisruption is a disruption injected when a training scenario
+// starts. It starts StartAfterMinutes after the start of the scenario and
+// lasts DurationMinutes, or until it is cleared if DurationMinutes is 0.
+type TrainingDisruption struct {
+	Type              DisruptionType          `json:"type"`
+	TrackItemID       string                  `json:"trackItemId"`
+	ToTrackItemID     string                  `json:"toTrackItemId,omitempty"`
+	SpeedLimit        float64                 `json:"speedLimit,omitempty"`
+	Reason            string                  `json:"reason,omitempty"`
+	FailureMode       TrackCircuitFailureMode `json:"failureMode,omitempty"`
+	StartAfterMinutes int                     `json:"startAfterMinutes"`
+	DurationMinutes   int                     `json:"durationMinutes"`
+}
+
+// Disruption returns a new disruption from this template for a scenario
+// started at the given time
+func (td *TrainingDisruption) Disruption(start time.Time) *Disruption {
+	d := &Disruption{
+		Type:          td.Type,
+		TrackItemID:   td.TrackItemID,
+		ToTrackItemID: td.ToTrackItemID,
+		SpeedLimit:    td.SpeedLimit,
+		Reason:        td.Reason,
+		FailureMode:   td.FailureMode,
+	}
+	if td.StartAfterMinutes > 0 {
+		d.StartTime.Time = start.Add(time.Duration(td.StartAfterMinutes) * time.Minute)
+	}
+	if td.DurationMinutes > 0 {
+		from := start.Add(time.Duration(td.StartAfterMinutes) * time.Minute)
+		d.EndTime.Time = from.Add(time.Duration(td.DurationMinutes) * time.Minute)
+	}
+	return d
+}
+
+// A TrainingScenario is an exercise bundled with a simulation: disruptions
+// injected when it starts and objectives the dispatcher must meet.
+type TrainingScenario struct {
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	Disruptions []*TrainingDisruption `json:"disruptions"`
+	Objectives  []*TrainingObjective  `json:"objectives"`
+
+	scenarioID string
+}
+
+// ID returns the unique identifier of this training scenario
+func (ts *TrainingScenario) ID() string {
+	return ts.scenarioID
+}
+
+// MarshalJSON for the TrainingScenario type
+func (ts TrainingScenario) MarshalJSON() ([]byte, error) {
+	type auxScenario TrainingScenario
+	return json.Marshal(struct {
+		auxScenario
+		ID string `json:"id"`
+	}{
+		auxScenario: auxScenario(ts),
+		ID:          ts.scenarioID,
+	})
+}
+
+// initialize checks this training scenario against the track items of sim
+func (ts *TrainingScenario) initialize(sim *Simulation, id string) error {
+	ts.scenarioID = id
+	if len(ts.Objectives) == 0 {
+		return fmt.Errorf("training scenario %s has no objectives", id)
+	}
+	for i, o := range ts.Objectives {
+		if err := o.initialize(); err != nil {
+			return fmt.Errorf("error in objective %d of training scenario %s: %s", i, id, err)
+		}
+	}
+	for i, td := range ts.Disruptions {
+		td.Type = DisruptionType(strings.ToUpper(string(td.Type)))
+		td.FailureMode = TrackCircuitFailureMode(strings.ToUpper(string(td.FailureMode)))
+		if td.StartAfterMinutes < 0 || td.DurationMinutes < 0 {
+			return fmt.Errorf("negative time in disruption %d of training scenario %s", i, id)
+		}
+		d := td.Disruption(time.Time{})
+		d.simulation = sim
+		if err := d.resolveItems(); err != nil {
+			return fmt.Errorf("error in disruption %d of training scenario %s: %s", i, id, err)
+		}
+	}
+	return nil
+}
+
+// TrainingScenarios returns the training scenarios of the simulation sorted
+// by ID
+func (sim *Simulation) TrainingScenarios() []*TrainingScenario {
+	res := make([]*TrainingScenario, 0, len(sim.trainingScenarios))
+	for _, ts := range sim.trainingScenarios {
+		res = append(res, ts)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].scenarioID < res[j].scenarioID
+	})
+	return res
+}
+
+// TrainingScenario returns the training scenario with the given ID, or nil if
+// it does not exist
+func (sim *Simulation) TrainingScenario(id string) *TrainingScenario {
+	return sim.trainingScenarios[id]
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestTrainingScenarios(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given training scenarios
+	loadSim := func(scenarios map[string]interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["trainingScenarios"] = scenarios
+		})
+	}
+	scenario := func(disruption, objective map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"title":       "Signal failure",
+			"disruptions": []interface{}{disruption},
+			"objectives":  []interface{}{objective},
+		}
+	}
+	failure := map[string]interface{}{"type": "signal_failed", "trackItemId": "5", "startAfterMinutes": 5, "durationMinutes": 30}
+	punctuality := map[string]interface{}{"metric": "punctuality", "operator": ">=", "value": 90, "deadline": "07:00:00"}
+	Convey("Testing training scenarios", t, func() {
+		Convey("Training scenarios should be loaded and saved", func() {
+			sim, err := loadSim(map[string]interface{}{"SF": scenario(failure, punctuality)})
+			So(err, ShouldBeNil)
+			So(sim.TrainingScenarios(), ShouldHaveLength, 1)
+			ts := sim.TrainingScenario("SF")
+			So(ts, ShouldNotBeNil)
+			So(ts.ID(), ShouldEqual, "SF")
+			So(sim.TrainingScenario("XX"), ShouldBeNil)
+			So(ts.Disruptions[0].Type, ShouldEqual, simulation.DisruptionSignalFailed)
+			o := ts.Objectives[0]
+			So(o.Mode, ShouldEqual, simulation.ObjectiveReach)
+			So(o.DeadlineTime(), ShouldResemble, simulation.ParseTime("07:00:00").Time)
+			So(o.String(), ShouldEqual, "punctuality >= 90 by 07:00:00")
+			So(o.Holds(90), ShouldBeTrue)
+			So(o.Holds(89.9), ShouldBeFalse)
+
+			data, err := json.Marshal(sim)
+			So(err, ShouldBeNil)
+			var saved struct {
+				TrainingScenarios map[string]map[string]interface{} `json:"trainingScenarios"`
+			}
+			So(json.Unmarshal(data, &saved), ShouldBeNil)
+			So(saved.TrainingScenarios, ShouldContainKey, "SF")
+			So(saved.TrainingScenarios["SF"]["id"], ShouldEqual, "SF")
+			clone, err := sim.Clone()
+			So(err, ShouldBeNil)
+			drainEvents(clone, endChan)
+			So(clone.TrainingScenario("SF"), ShouldNotBeNil)
+			So(clone.TrainingScenario("SF").Objectives[0].DeadlineTime(), ShouldResemble, o.DeadlineTime())
+		})
+		Convey("Disruptions should be scheduled from the start of the scenario", func() {
+			sim, err := loadSim(map[string]interface{}{"SF": scenario(failure, punctuality)})
+			So(err, ShouldBeNil)
+			start := sim.Options.CurrentTime.Time
+			d := sim.TrainingScenario("SF").Disruptions[0].Disruption(start)
+			So(sim.AddDisruption(d), ShouldBeNil)
+			So(sim.FormatTime(d.StartTime.Time), ShouldEqual, "06:05:00")
+			So(sim.FormatTime(d.EndTime.Time), ShouldEqual, "06:35:00")
+			So(d.Status(), ShouldEqual, "PLANNED")
+		})
+		Convey("Invalid training scenarios should be refused", func() {
+			maintain := map[string]interface{}{"metric": "spads", "operator": "<", "value": 1, "mode": "maintain"}
+			_, err := loadSim(map[string]interface{}{"SF": scenario(failure, maintain)})
+			So(err, ShouldBeNil)
+			for _, bad := range []map[string]interface{}{
+				{"metric": "happiness", "operator": ">=", "value": 90},
+				{"metric": "punctuality", "operator": "=", "value": 90},
+				{"metric": "punctuality", "operator": ">=", "value": 90, "deadline": "10h"},
+				{"metric": "punctuality", "operator": ">=", "value": 90, "mode": "sometimes"},
+			} {
+				_, err = loadSim(map[string]interface{}{"SF": scenario(failure, bad)})
+				So(err, ShouldNotBeNil)
+			}
+			notSignal := map[string]interface{}{"type": "SIGNAL_FAILED", "trackItemId": "4"}
+			_, err = loadSim(map[string]interface{}{"SF": scenario(notSignal, punctuality)})
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(map[string]interface{}{"SF": map[string]interface{}{"title": "No objectives"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
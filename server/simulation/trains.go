@@ -55,6 +55,19 @@ const (
 
 	// EndOfService means the train has finished its service and no new service assigned
 	EndOfService TrainStatus = 50
+
+	// Joined means the train has been coupled to another train and is not in the area anymore
+	Joined TrainStatus = 60
+
+	// Cancelled means the train has been cancelled by the dispatcher and removed from the area
+	Cancelled TrainStatus = 70
+
+	// Stabled means the train is parked in a depot, out of traffic
+	Stabled TrainStatus = 80
+
+	// NotRunning means the service of the train does not run on the date of
+	// the simulation, so that the train never enters the area
+	NotRunning TrainStatus = 90
 )
 
 // VeryHighSpeed is the speed limit set when there are no speed limits.
@@ -78,6 +91,9 @@ type Train struct {
 	StoppedTime    time.Duration  `json:"stoppedTime"`
 	TrainTypeCode  string         `json:"trainTypeCode"`
 	TrainHead      Position       `json:"trainHead"`
+	PriorityClass  TrainPriority  `json:"priority"`
+	// Passengers is the number of passengers on board
+	Passengers float64 `json:"passengers"`
 
 	trainManager    TrainsManager
 	simulation      *Simulation
@@ -88,6 +104,20 @@ type Train struct {
 	actionTime      Time
 	lastSignal      *SignalItem
 	ignoredSignal   *SignalItem
+	heldUntil       Time
+	performance     float64
+	degradedUntil   Time
+	entryPerturbed  bool
+	tractionEnergy  float64
+	coastSpeed      float64
+	boarded         float64
+	alighted        float64
+	passengerDwell  time.Duration
+	shunting        bool
+	shuntRoute      string
+	depot           string
+	withdrawn       bool
+	overspeeding    bool
 }
 
 // ID returns the unique internal identifier of this Train
@@ -104,11 +134,11 @@ func (t *Train) setSimulation(sim *Simulation) {
 // initialize attaches the Simulation to this Train and initializes it.
 func (t *Train) initialize(id string) {
 	t.trainID = id
-	t.effInitialDelay = t.InitialDelay.Yield()
+	t.effInitialDelay = t.InitialDelay.yieldFrom(t.simulation.random())
 	if t.InitialDelay.IsNull() {
-		t.effInitialDelay = t.simulation.Options.DefaultDelayAtEntry.Yield()
+		t.effInitialDelay = t.simulation.Options.DefaultDelayAtEntry.yieldFrom(t.simulation.random())
 	}
-	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.Yield()
+	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.yieldFrom(t.simulation.random())
 	if t.trainManager == nil {
 		t.trainManager = defaultTrainManager
 	}
@@ -152,11 +182,29 @@ func (t Train) MarshalJSON() ([]byte, error) {
 	type auxTrain Train
 	type trainJSON struct {
 		auxTrain
-		ID string `json:"id"`
+		ID             string  `json:"id"`
+		TractionEnergy float64 `json:"tractionEnergy"`
+		Coasting       bool    `json:"coasting"`
+		Shunting       bool    `json:"shunting"`
+		Depot          string  `json:"depot,omitempty"`
+		Withdrawn      bool    `json:"withdrawn,omitempty"`
+		Geo            *LatLon `json:"geo,omitempty"`
+		AppearTime     string  `json:"appearTime"`
 	}
 	at := trainJSON{
-		auxTrain: auxTrain(t),
-		ID:       t.ID(),
+		auxTrain:       auxTrain(t),
+		ID:             t.ID(),
+		AppearTime:     formatObjectTime(t.simulation, t.AppearTime.Time),
+		TractionEnergy: t.TractionEnergy(),
+		Coasting:       t.IsCoasting(),
+		Shunting:       t.IsShunting(),
+		Depot:          t.depot,
+		Withdrawn:      t.withdrawn,
+	}
+	if t.simulation != nil && t.TrainHead.simulation != nil {
+		if ll, ok := t.simulation.GeoPosition(t.TrainHead); ok {
+			at.Geo = &ll
+		}
 	}
 	return json.Marshal(at)
 }
@@ -165,7 +213,11 @@ func (t Train) MarshalJSON() ([]byte, error) {
 func (t *Train) IsActive() bool {
 	return t.Status != Inactive &&
 		t.Status != Out &&
-		t.Status != EndOfService
+		t.Status != EndOfService &&
+		t.Status != Joined &&
+		t.Status != Cancelled &&
+		t.Status != Stabled &&
+		t.Status != NotRunning
 }
 
 // activate this Train if this train is Inactive and if h is after its AppearTime.
@@ -175,13 +227,30 @@ func (t *Train) activate(h Time) {
 	if t.Status != Inactive {
 		return
 	}
-	if h.IsZero() {
+	if h.IsZero() || t.IsHeld() {
 		return
 	}
 	realAppearTime := t.AppearTime.Add(t.effInitialDelay)
 	if h.Sub(realAppearTime) < 0 {
 		return
 	}
+	if !t.runsOnCalendar() {
+		t.Status = NotRunning
+		t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s does not run on %s",
+			t.ServiceCode, t.runDate().Format("Mon 2006-01-02")), simulationMsg)
+		t.simulation.sendEvent(&Event{
+			Name:   TrainChangedEvent,
+			Object: t,
+		})
+		return
+	}
+	if !t.entryPerturbed {
+		t.entryPerturbed = true
+		t.simulation.perturbEntry(t)
+		if h.Sub(t.AppearTime.Add(t.effInitialDelay)) < 0 {
+			return
+		}
+	}
 	t.Speed = t.InitialSpeed
 	// Update signals
 	if signalAhead := t.findNextSignal(); signalAhead != nil {
@@ -213,11 +282,37 @@ func (t *Train) advance(timeElapsed time.Duration) {
 		return
 	}
 	t.updateSignalActions()
+	previousSpeed := t.Speed
 	t.Speed = t.trainManager.Speed(t, timeElapsed)
+	if t.IsHeld() {
+		// A held train brakes to a stop and stays where it is
+		secs := float64(timeElapsed) / float64(time.Second)
+		t.Speed = math.Max(0, math.Min(t.Speed, previousSpeed-t.Braking()*secs))
+	} else if perf := t.Performance(); perf < 1 {
+		// A degraded train accelerates slower and cannot reach its full speed
+		secs := float64(timeElapsed) / float64(time.Second)
+		t.Speed = math.Min(t.Speed, previousSpeed+t.Acceleration()*perf*secs)
+		t.Speed = math.Min(t.Speed, math.Max(t.TrainType().MaxSpeed*perf, previousSpeed-t.Braking()*secs))
+		t.Speed = math.Max(0, t.Speed)
+	}
+	if !t.IsHeld() {
+		// A train cannot sustain a speed above its balancing speed on a
+		// climbing or curved section, but only slows down because of it.
+		secs := float64(timeElapsed) / float64(time.Second)
+		resistance := t.TrainHead.resistance()
+		t.Speed = math.Max(0, math.Min(t.Speed, math.Max(t.BalancingSpeed(), previousSpeed-resistance*secs)))
+	}
+	t.coast(previousSpeed, float64(timeElapsed)/float64(time.Second))
+	t.updateTractionEnergy(previousSpeed, float64(timeElapsed)/float64(time.Second))
 	advanceLength := t.Speed * float64(timeElapsed) / float64(time.Second)
+	spad := t.signalPassedAtDanger(advanceLength)
 	t.TrainHead = t.TrainHead.Add(advanceLength)
 	t.updateStatus(timeElapsed)
 	t.executeActions(advanceLength)
+	if spad != nil {
+		t.recordSPAD(spad)
+	}
+	t.checkOverspeed()
 	t.simulation.sendEvent(&Event{
 		Name:   TrainChangedEvent,
 		Object: t,
@@ -234,12 +329,21 @@ func (t *Train) advance(timeElapsed time.Duration) {
 func (t *Train) executeActions(advanceLength float64) {
 	// Train head
 	oth := t.TrainHead.Add(-advanceLength)
-	toNotify := make(map[TrackItem]bool)
+	// toNotify keeps the order in which items are met, so that events are
+	// always sent in the same order.
+	var toNotify []TrackItem
+	notified := make(map[TrackItem]bool)
+	notify := func(ti TrackItem) {
+		if !notified[ti] {
+			notified[ti] = true
+			toNotify = append(toNotify, ti)
+		}
+	}
 	for _, ti := range oth.trackItemsToPosition(t.TrainHead) {
 		t.checkPlace(ti)
 		t.updateItemWithTrainHead(ti)
 		ti.trainHeadActions(t)
-		toNotify[ti] = true
+		notify(ti)
 	}
 	// Train tail
 	tt := t.TrainTail()
@@ -247,14 +351,14 @@ func (t *Train) executeActions(advanceLength float64) {
 	for _, ti := range ott.trackItemsToPosition(tt) {
 		t.updateItemWithTrainTail(ti)
 		ti.trainTailActions(t)
-		toNotify[ti] = true
+		notify(ti)
 	}
 	if tt.IsOut() {
 		t.Status = Out
 		t.Speed = 0
 		t.logAndScoreTrainExited()
 	}
-	for ti := range toNotify {
+	for _, ti := range toNotify {
 		t.simulation.sendEvent(&Event{
 			Name:   TrackItemChangedEvent,
 			Object: ti,
@@ -267,6 +371,7 @@ func (t *Train) executeActions(advanceLength float64) {
 func (t *Train) updateItemWithTrainHead(ti TrackItem) {
 	ti.underlying().trainEndMutex.Lock()
 	defer ti.underlying().trainEndMutex.Unlock()
+	defer ti.underlying().syncOccupancy(t)
 	ti.underlying().trainEndsFW[t] = ti.RealLength()
 	ti.underlying().trainEndsBK[t] = 0
 	if t.simulation.Options.TrackCircuitBased {
@@ -286,6 +391,7 @@ func (t *Train) updateItemWithTrainHead(ti TrackItem) {
 func (t *Train) updateItemWithTrainTail(ti TrackItem) {
 	ti.underlying().trainEndMutex.Lock()
 	defer ti.underlying().trainEndMutex.Unlock()
+	defer ti.underlying().syncOccupancy(t)
 	if !ti.Equals(t.TrainHead.TrackItem()) {
 		delete(ti.underlying().trainEndsBK, t)
 		delete(ti.underlying().trainEndsFW, t)
@@ -404,13 +510,13 @@ func (t *Train) setActionIndex(index int) {
 // checkPlace if the given ti belongs to a place which is a waypoiny on t's service (non stop).
 // Updates t's current service line accordingly.
 func (t *Train) checkPlace(ti TrackItem) {
-	if ti.Type() != TypeLine && ti.Type() != TypeInvisibleLink {
+	if ti.Type() != TypeLine && ti.Type() != TypeInvisibleLink && ti.Type() != TypeLevelCrossing {
 		return
 	}
 	if ti.Place() == nil {
 		return
 	}
-	if t.Service() == nil || t.NextPlaceIndex == NoMorePlace {
+	if t.Service() == nil || t.NextPlaceIndex == NoMorePlace || t.shunting {
 		return
 	}
 	sLine := t.Service().Lines[t.NextPlaceIndex]
@@ -426,18 +532,31 @@ func (t *Train) checkPlace(ti TrackItem) {
 
 // jumpToNextServiceLine sets the next service line as the new active line.
 func (t *Train) jumpToNextServiceLine() {
-	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.Yield()
+	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.yieldFrom(t.simulation.random())
 	if t.NextPlaceIndex == len(t.Service().Lines)-1 {
+		if t.loops() {
+			return
+		}
 		// The service is ended
 		t.NextPlaceIndex = NoMorePlace
+		previous := t.Service()
 		for _, action := range t.Service().PostActions {
 			switch action.ActionCode {
 			case actionReverse:
 				_ = t.Reverse()
 			case actionSetService:
 				_ = t.AssignService(action.ActionParam)
+			case actionSplit:
+				if err := t.splitFromAction(action.ActionParam); err != nil {
+					t.simulation.MessageLogger.addMessage(fmt.Sprintf("Unable to split train %s: %s", t.ServiceCode, err), simulationMsg)
+				}
+			case actionJoin:
+				if err := t.joinFromAction(action.ActionParam); err != nil {
+					t.simulation.MessageLogger.addMessage(fmt.Sprintf("Unable to join train %s: %s", t.ServiceCode, err), simulationMsg)
+				}
 			}
 		}
+		t.turnAround(previous)
 		return
 	}
 	t.NextPlaceIndex += 1
@@ -477,6 +596,7 @@ func (t *Train) AssignService(srv string) error {
 	}
 	t.ServiceCode = srv
 	t.NextPlaceIndex = 0
+	t.withdrawn = false
 	t.findNextSignal().setTrain(t)
 	if t.StoppedTime != 0 {
 		t.Status = Stopped
@@ -511,9 +631,77 @@ func (t *Train) ProceedWithCaution() error {
 	return nil
 }
 
+// Hold holds this train for the given duration of simulation time.
+//
+// A running train brakes to a stop and stays where it is, a train stopped at a
+// station does not depart and a train that has not yet entered the area will
+// not enter before the hold is released. A duration of 0 or less releases the
+// train immediately.
+func (t *Train) Hold(d time.Duration) {
+	if d <= 0 {
+		t.heldUntil.Time = time.Time{}
+	} else {
+		t.heldUntil.Time = t.simulation.Options.CurrentTime.Time.Add(d)
+	}
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+}
+
+// IsHeld returns true if this train is currently held.
+func (t *Train) IsHeld() bool {
+	return !t.heldUntil.IsZero() && t.simulation.Options.CurrentTime.Before(t.heldUntil)
+}
+
+// HeldUntil returns the simulation time until which this train is held.
+// It returns a zero Time if the train is not held.
+func (t *Train) HeldUntil() Time {
+	if !t.IsHeld() {
+		return Time{}
+	}
+	return t.heldUntil
+}
+
+// Degrade reduces the performance of this train to the given factor of its
+// nominal acceleration and maximum speed for the given duration of simulation
+// time. A factor of 1 or more, or a duration of 0 or less restores the nominal
+// performance immediately.
+func (t *Train) Degrade(factor float64, d time.Duration) {
+	if factor >= 1 || d <= 0 {
+		t.performance = 0
+		t.degradedUntil.Time = time.Time{}
+	} else {
+		t.performance = math.Max(0, factor)
+		t.degradedUntil.Time = t.simulation.Options.CurrentTime.Time.Add(d)
+	}
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+}
+
+// Performance returns the current performance factor of this train, between 0
+// and 1. It is 1 unless the train has been degraded with Degrade.
+func (t *Train) Performance() float64 {
+	if t.degradedUntil.IsZero() || !t.simulation.Options.CurrentTime.Time.Before(t.degradedUntil.Time) {
+		return 1
+	}
+	return t.performance
+}
+
+// DegradedUntil returns the simulation time until which this train's
+// performance is reduced. It returns a zero time if the train is not degraded.
+func (t *Train) DegradedUntil() time.Time {
+	if t.Performance() >= 1 {
+		return time.Time{}
+	}
+	return t.degradedUntil.Time
+}
+
 // IsShunting returns true if this train is currently shunting.
 func (t *Train) IsShunting() bool {
-	return false
+	return t.shunting
 }
 
 // ApplicableAction returns the current signal action that this train is following
@@ -532,6 +720,10 @@ func (t *Train) updateStatus(timeElapsed time.Duration) {
 	if !t.IsActive() {
 		return
 	}
+	if t.shunting {
+		t.updateShuntingStatus()
+		return
+	}
 	if t.Speed > minRunningSpeed {
 		// Speed is not null, the train is running
 		t.Status = Running
@@ -555,11 +747,15 @@ func (t *Train) updateStatus(timeElapsed time.Duration) {
 		// Train just stopped
 		t.Status = Stopped
 		t.StoppedTime = 0
+		t.coastSpeed = 0
+		t.exchangePassengers()
+		t.simulation.recordTransferArrival(t, line.PlaceCode)
 		t.simulation.sendEvent(&Event{
 			Name:   TrainStoppedAtStationEvent,
 			Object: t,
 		})
 		t.logAndScoreTrainStoppedAtStation()
+		t.simulation.perturbDwell(t)
 		return
 	}
 	if t.Status != Stopped {
@@ -567,20 +763,25 @@ func (t *Train) updateStatus(timeElapsed time.Duration) {
 		return
 	}
 	// Train is already stopped at the place
-	if line.ScheduledDepartureTime.Sub(t.simulation.Options.CurrentTime) > 0 ||
-		t.StoppedTime < t.minStopTime ||
-		line.ScheduledDepartureTime.IsZero() {
+	if (!line.ScheduledDepartureTime.IsZero() && line.ScheduledDepartureTime.Sub(t.simulation.Options.CurrentTime) > 0) ||
+		(line.ExpectedDepartureTime != nil && line.ExpectedDepartureTime.Time.After(t.simulation.Options.CurrentTime.Time)) ||
+		t.StoppedTime < t.MinimumStopTime() ||
+		t.IsHeld() ||
+		(line.ScheduledDepartureTime.IsZero() && !t.linksAtTerminus()) {
 		// Conditions to depart are not met
 		t.Status = Stopped
 		t.StoppedTime += timeElapsed
 		return
 	}
 	// Train departs
+	if t.NextPlaceIndex < len(t.Service().Lines)-1 {
+		t.simulation.recordTransferDeparture(t, line.PlaceCode)
+	}
 	oldServiceCode := t.ServiceCode
 	t.jumpToNextServiceLine()
 	if oldServiceCode != t.ServiceCode {
 		// The service has changed
-		if t.TrainHead.TrackItem().Place().PlaceCode != t.Service().Lines[t.NextPlaceIndex].PlaceCode {
+		if pl := t.TrainHead.TrackItem().Place(); pl == nil || pl.PlaceCode != t.Service().Lines[t.NextPlaceIndex].PlaceCode {
 			// The first scheduled place of this new service is not here, so we depart
 			t.Status = Running
 			t.simulation.sendEvent(&Event{
@@ -0,0 +1,218 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TransferStatus is the state of a connection between two services
+type TransferStatus string
+
+const (
+	// TransferPending means that the departing service has not left the place
+	// yet
+	TransferPending TransferStatus = "PENDING"
+	// TransferMade means that the departing service left the place at least
+	// the minimum connection time after the arrival of the arriving service
+	TransferMade TransferStatus = "MADE"
+	// TransferMissed means that the departing service left the place before
+	// passengers of the arriving service could board it, or that one of the
+	// services was cancelled
+	TransferMissed TransferStatus = "MISSED"
+)
+
+// A Transfer is a connection planned at a place between a service arriving
+// there and a service departing from there. Passengers need at least
+// MinConnectionTime seconds after the arrival of FromService to board
+// ToService.
+type Transfer struct {
+	PlaceCode         string `json:"placeCode"`
+	FromService       string `json:"fromService"`
+	ToService         string `json:"toService"`
+	MinConnectionTime int    `json:"minConnectionTime"`
+
+	transferID    string
+	simulation    *Simulation
+	status        TransferStatus
+	arrivalTime   time.Time
+	departureTime time.Time
+}
+
+// ID returns the unique identifier of this transfer
+func (tr *Transfer) ID() string {
+	return tr.transferID
+}
+
+// MarshalJSON for the Transfer type
+func (tr Transfer) MarshalJSON() ([]byte, error) {
+	type auxTransfer Transfer
+	clock := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return formatObjectTime(tr.simulation, t)
+	}
+	type transferJSON struct {
+		auxTransfer
+		ID            string         `json:"id"`
+		Status        TransferStatus `json:"status"`
+		ArrivalTime   string         `json:"arrivalTime"`
+		DepartureTime string         `json:"departureTime"`
+	}
+	return json.Marshal(transferJSON{
+		auxTransfer:   auxTransfer(tr),
+		ID:            tr.transferID,
+		Status:        tr.status,
+		ArrivalTime:   clock(tr.arrivalTime),
+		DepartureTime: clock(tr.departureTime),
+	})
+}
+
+// Status returns the current state of this transfer
+func (tr *Transfer) Status() TransferStatus {
+	return tr.status
+}
+
+// ArrivalTime returns the time at which FromService stopped at the place, or
+// a zero time if it has not arrived yet.
+func (tr *Transfer) ArrivalTime() time.Time {
+	return tr.arrivalTime
+}
+
+// DepartureTime returns the time at which ToService left the place, or a zero
+// time if it has not departed yet.
+func (tr *Transfer) DepartureTime() time.Time {
+	return tr.departureTime
+}
+
+// ConnectionTime returns the time passengers had to change trains, i.e. the
+// time between the arrival of FromService and the departure of ToService. It
+// is 0 until both times are known.
+func (tr *Transfer) ConnectionTime() time.Duration {
+	if tr.arrivalTime.IsZero() || tr.departureTime.IsZero() {
+		return 0
+	}
+	return tr.departureTime.Sub(tr.arrivalTime)
+}
+
+// initialize checks this transfer against the places and services of sim
+func (tr *Transfer) initialize(sim *Simulation, id string) error {
+	tr.simulation = sim
+	tr.transferID = id
+	tr.status = TransferPending
+	if _, ok := sim.Places[tr.PlaceCode]; !ok {
+		return fmt.Errorf("unknown place %s in transfer %s", tr.PlaceCode, id)
+	}
+	if tr.MinConnectionTime < 0 {
+		return fmt.Errorf("negative minimum connection time in transfer %s", id)
+	}
+	for _, code := range []string{tr.FromService, tr.ToService} {
+		s, ok := sim.Services[code]
+		if !ok {
+			return fmt.Errorf("unknown service %s in transfer %s", code, id)
+		}
+		if s.lineIndexAt(tr.PlaceCode) < 0 {
+			return fmt.Errorf("service %s does not call at %s in transfer %s", code, tr.PlaceCode, id)
+		}
+	}
+	return nil
+}
+
+// lineIndexAt returns the index of the line of this service at the place with
+// the given code, or -1 if the service does not call there.
+func (s *Service) lineIndexAt(placeCode string) int {
+	for i, line := range s.Lines {
+		if line.PlaceCode == placeCode {
+			return i
+		}
+	}
+	return -1
+}
+
+// setStatus changes the status of this transfer and notifies clients
+func (tr *Transfer) setStatus(status TransferStatus) {
+	tr.status = status
+	if status == TransferMissed {
+		tr.simulation.MessageLogger.addMessage(fmt.Sprintf("Connection from %s to %s at %s missed",
+			tr.FromService, tr.ToService, tr.PlaceCode), simulationMsg)
+	}
+	tr.simulation.sendEvent(&Event{Name: TransferChangedEvent, Object: tr})
+}
+
+// Transfers returns the transfers of the simulation sorted by ID
+func (sim *Simulation) Transfers() []*Transfer {
+	res := make([]*Transfer, 0, len(sim.transfers))
+	for _, tr := range sim.transfers {
+		res = append(res, tr)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].transferID < res[j].transferID
+	})
+	return res
+}
+
+// Transfer returns the transfer with the given ID, or nil if it does not exist
+func (sim *Simulation) Transfer(id string) *Transfer {
+	return sim.transfers[id]
+}
+
+// recordTransferArrival records that train t stopped at the place with the
+// given code for the transfers from its service.
+func (sim *Simulation) recordTransferArrival(t *Train, placeCode string) {
+	for _, tr := range sim.Transfers() {
+		if tr.status != TransferPending || tr.FromService != t.ServiceCode || tr.PlaceCode != placeCode {
+			continue
+		}
+		tr.arrivalTime = sim.Options.CurrentTime.Time
+		sim.sendEvent(&Event{Name: TransferChangedEvent, Object: tr})
+	}
+}
+
+// recordTransferDeparture records that train t leaves the place with the
+// given code and decides whether the transfers to its service are made.
+func (sim *Simulation) recordTransferDeparture(t *Train, placeCode string) {
+	for _, tr := range sim.Transfers() {
+		if tr.status != TransferPending || tr.ToService != t.ServiceCode || tr.PlaceCode != placeCode {
+			continue
+		}
+		tr.departureTime = sim.Options.CurrentTime.Time
+		if tr.arrivalTime.IsZero() || tr.ConnectionTime() < time.Duration(tr.MinConnectionTime)*time.Second {
+			tr.setStatus(TransferMissed)
+			continue
+		}
+		tr.setStatus(TransferMade)
+	}
+}
+
+// missTransfers marks as missed the pending transfers that cannot be made
+// anymore because the service with the given code is cancelled.
+func (sim *Simulation) missTransfers(serviceCode string) {
+	for _, tr := range sim.Transfers() {
+		if tr.status != TransferPending {
+			continue
+		}
+		if tr.ToService == serviceCode || (tr.FromService == serviceCode && tr.arrivalTime.IsZero()) {
+			tr.setStatus(TransferMissed)
+		}
+	}
+}
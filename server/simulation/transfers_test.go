@@ -0,0 +1,105 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestTransfers(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation with the given transfers
+	loadSim := func(transfers map[string]interface{}) (*simulation.Simulation, error) {
+		return loadDemoWith(endChan, func(raw map[string]interface{}) {
+			raw["transfers"] = transfers
+		})
+	}
+	transfer := func(from, to string, minTime int) map[string]interface{} {
+		return map[string]interface{}{"placeCode": "STN", "fromService": from, "toService": to, "minConnectionTime": minTime}
+	}
+	Convey("Testing connections between services", t, func() {
+		Convey("Transfers should be loaded and saved", func() {
+			sim, err := loadSim(map[string]interface{}{"T1": transfer("S001", "S002", 120)})
+			So(err, ShouldBeNil)
+			So(sim.Transfers(), ShouldHaveLength, 1)
+			tr := sim.Transfer("T1")
+			So(tr, ShouldNotBeNil)
+			So(tr.ID(), ShouldEqual, "T1")
+			So(tr.FromService, ShouldEqual, "S001")
+			So(tr.MinConnectionTime, ShouldEqual, 120)
+			So(tr.Status(), ShouldEqual, simulation.TransferPending)
+			data, err := json.Marshal(sim)
+			So(err, ShouldBeNil)
+			var saved struct {
+				Transfers map[string]map[string]interface{} `json:"transfers"`
+			}
+			So(json.Unmarshal(data, &saved), ShouldBeNil)
+			So(saved.Transfers["T1"]["toService"], ShouldEqual, "S002")
+			So(saved.Transfers["T1"]["status"], ShouldEqual, "PENDING")
+			So(saved.Transfers["T1"]["arrivalTime"], ShouldEqual, "")
+		})
+		Convey("Invalid transfers should not be loaded", func() {
+			_, err := loadSim(map[string]interface{}{"T1": transfer("S001", "XXX", 120)})
+			So(err, ShouldNotBeNil)
+			bad := transfer("S001", "S002", 120)
+			bad["placeCode"] = "RGT"
+			_, err = loadSim(map[string]interface{}{"T1": bad})
+			So(err, ShouldNotBeNil)
+			_, err = loadSim(map[string]interface{}{"T1": transfer("S001", "S002", -1)})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Connections should be made or missed when the departing service leaves", func() {
+			sim, err := loadSim(map[string]interface{}{
+				"T1": transfer("S001", "S002", 120),
+				"T2": transfer("S001", "S002", 600),
+			})
+			So(err, ShouldBeNil)
+			train := sim.Trains[0]
+			So(stepUntil(sim, 3000, func() bool { return train.Status == simulation.Stopped }), ShouldBeTrue)
+			arrival := sim.Options.CurrentTime.Time
+			t1, t2 := sim.Transfer("T1"), sim.Transfer("T2")
+			So(t1.ArrivalTime(), ShouldResemble, arrival)
+			So(t1.Status(), ShouldEqual, simulation.TransferPending)
+			So(t1.ConnectionTime(), ShouldEqual, 0)
+			So(stepUntil(sim, 10000, func() bool { return t1.Status() != simulation.TransferPending }), ShouldBeTrue)
+			So(t1.Status(), ShouldEqual, simulation.TransferMade)
+			So(t1.DepartureTime().Before(arrival), ShouldBeFalse)
+			So(t1.DepartureTime().Equal(t1.ArrivalTime().Add(t1.ConnectionTime())), ShouldBeTrue)
+			So(t1.ConnectionTime(), ShouldBeBetween, 2*time.Minute, 10*time.Minute)
+			So(t2.Status(), ShouldEqual, simulation.TransferMissed)
+			Convey("And keep their status in clones and checkpoints", func() {
+				clone, err := sim.Clone()
+				So(err, ShouldBeNil)
+				drainEvents(clone, endChan)
+				defer clone.Close()
+				So(clone.Transfer("T1").Status(), ShouldEqual, simulation.TransferMade)
+				So(clone.Transfer("T2").ConnectionTime(), ShouldEqual, t2.ConnectionTime())
+				data, err := sim.Checkpoint()
+				So(err, ShouldBeNil)
+				restored, err := simulation.RestoreCheckpoint(data)
+				So(err, ShouldBeNil)
+				So(restored.Transfer("T1").Status(), ShouldEqual, simulation.TransferMade)
+				So(restored.Transfer("T2").Status(), ShouldEqual, simulation.TransferMissed)
+				So(restored.Transfer("T1").ArrivalTime().Equal(arrival), ShouldBeTrue)
+			})
+		})
+		Convey("Connections should be missed when a service is cancelled", func() {
+			sim, err := loadSim(map[string]interface{}{
+				"T1": transfer("S003", "S002", 60),
+				"T2": transfer("S001", "S003", 60),
+			})
+			So(err, ShouldBeNil)
+			_, err = sim.CancelService("S003")
+			So(err, ShouldBeNil)
+			So(sim.Transfer("T1").Status(), ShouldEqual, simulation.TransferMissed)
+			So(sim.Transfer("T2").Status(), ShouldEqual, simulation.TransferMissed)
+		})
+	})
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// linkedService returns the service that the train running s is assigned
+// automatically when s ends, or nil if services are not linked automatically
+// or if there is none.
+//
+// This is the NextServiceCode of s if any. Otherwise, it is the first service
+// of the timetable not run by any train, which starts where s ends, after the
+// current time and with the same planned train type.
+func (s *Service) linkedService() *Service {
+	sim := s.simulation
+	if !sim.Options.AutoLinkServices || len(s.Lines) == 0 {
+		return nil
+	}
+	if s.NextServiceCode != "" {
+		next, ok := sim.Services[s.NextServiceCode]
+		if !ok || len(next.Lines) == 0 {
+			return nil
+		}
+		return next
+	}
+	last := s.Lines[len(s.Lines)-1]
+	var candidates []*Service
+	for _, next := range sim.Services {
+		if next == s || len(next.Lines) == 0 || next.Lines[0].PlaceCode != last.PlaceCode {
+			continue
+		}
+		if next.PlannedTrainTypeCode != s.PlannedTrainTypeCode {
+			continue
+		}
+		if next.Lines[0].ScheduledDepartureTime.Time.Before(sim.Options.CurrentTime.Time) {
+			continue
+		}
+		if t, _ := sim.serviceTrain(next); t != nil || sim.isNextServiceOfAnother(next) {
+			continue
+		}
+		candidates = append(candidates, next)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		di := candidates[i].Lines[0].ScheduledDepartureTime.Time
+		dj := candidates[j].Lines[0].ScheduledDepartureTime.Time
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return candidates[i].ID() < candidates[j].ID()
+	})
+	return candidates[0]
+}
+
+// isNextServiceOfAnother returns true if s is the planned next service of
+// another service of the timetable.
+func (sim *Simulation) isNextServiceOfAnother(s *Service) bool {
+	for _, other := range sim.Services {
+		if other != s && other.NextServiceCode == s.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// endsWithSetService returns true if the post actions of s assign another
+// service to its train.
+func (s *Service) endsWithSetService() bool {
+	for _, pa := range s.PostActions {
+		if pa.ActionCode == actionSetService {
+			return true
+		}
+	}
+	return false
+}
+
+// endsWithReverse returns true if the post actions of s reverse its train.
+func (s *Service) endsWithReverse() bool {
+	for _, pa := range s.PostActions {
+		if pa.ActionCode == actionReverse {
+			return true
+		}
+	}
+	return false
+}
+
+// turnAround is called when this train ends service s at its last place,
+// after the post actions of s have been executed.
+//
+// If s does not assign another service to the train and services are linked
+// automatically, the train gets its linked service and is reversed if this
+// service goes back the way the train came. If s assigns another service
+// without reversing the train, the train is reversed in the same way when
+// AutoReverseAtTerminus is set. If the train is assigned a new service, it
+// stays at least the minimum turnaround time at the place, plus the driver
+// change time if it has reversed.
+func (t *Train) turnAround(s *Service) {
+	reversed := s.endsWithReverse()
+	if !s.endsWithSetService() {
+		next := s.linkedService()
+		if next == nil {
+			return
+		}
+		if t.mustReverseFor(s, next) {
+			reversed = t.Reverse() == nil
+		}
+		if err := t.AssignService(next.ID()); err != nil {
+			return
+		}
+		t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s linked to service %s", s.ID(), next.ID()), simulationMsg)
+	} else if !reversed && t.simulation.Options.AutoReverseAtTerminus && t.ServiceCode != s.ID() && t.mustReverseFor(s, t.Service()) {
+		if reversed = t.Reverse() == nil; reversed {
+			t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s reversed for return working %s", s.ID(), t.ServiceCode), simulationMsg)
+		}
+	}
+	if t.ServiceCode == s.ID() {
+		return
+	}
+	turnaround := time.Duration(t.simulation.Options.MinTurnaroundSeconds) * time.Second
+	if reversed {
+		turnaround += time.Duration(t.simulation.Options.DriverChangeSeconds) * time.Second
+	}
+	if t.minStopTime < turnaround {
+		t.minStopTime = turnaround
+	}
+}
+
+// mustReverseFor returns true if this train must reverse before running s
+// after prev, that is if s goes back to a place where prev came from, or if
+// there is no signal ahead of the train.
+func (t *Train) mustReverseFor(prev, s *Service) bool {
+	if len(s.Lines) < 2 {
+		return false
+	}
+	if t.NextSignalPosition().IsNull() {
+		return true
+	}
+	for _, line := range prev.Lines[:len(prev.Lines)-1] {
+		if line.PlaceCode == s.Lines[1].PlaceCode {
+			return true
+		}
+	}
+	return false
+}
+
+// linksAtTerminus returns true if this train, stopped at the last place of
+// its service, will be assigned a linked service when it departs. Such a
+// train departs without a scheduled departure time.
+func (t *Train) linksAtTerminus() bool {
+	if t.Service() == nil || t.NextPlaceIndex != len(t.Service().Lines)-1 {
+		return false
+	}
+	return !t.Service().endsWithSetService() && t.Service().linkedService() != nil
+}
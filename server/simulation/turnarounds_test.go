@@ -0,0 +1,120 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestTurnarounds(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	// loadSim loads the demo simulation without the post actions of S001
+	// and with the given options and S001 changes.
+	loadSim := func(options map[string]interface{}, s001 map[string]interface{}) *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, func(raw map[string]interface{}) {
+			for k, v := range options {
+				raw["options"].(map[string]interface{})[k] = v
+			}
+			srv := raw["services"].(map[string]interface{})["S001"].(map[string]interface{})
+			srv["postActions"] = []interface{}{}
+			for k, v := range s001 {
+				srv[k] = v
+			}
+		})
+		So(err, ShouldBeNil)
+		return sim
+	}
+	Convey("Testing automatic service linking at turnarounds", t, func() {
+		Convey("Without linking, the train ends its service", func() {
+			sim := loadSim(nil, nil)
+			train := sim.Trains[0]
+			So(stepUntil(sim, 1000, func() bool { return train.Status == simulation.EndOfService }), ShouldBeTrue)
+			So(train.ServiceCode, ShouldEqual, "S001")
+		})
+		Convey("With linking, the train gets the next service starting at the terminus", func() {
+			sim := loadSim(map[string]interface{}{"autoLinkServices": true}, nil)
+			train := sim.Trains[0]
+			head := train.TrainHead
+			So(stepUntil(sim, 1000, func() bool {
+				if train.ServiceCode == "S001" {
+					head = train.TrainHead
+				}
+				return train.ServiceCode == "S002"
+			}), ShouldBeTrue)
+			So(train.Status, ShouldEqual, simulation.Stopped)
+			So(train.NextPlaceIndex, ShouldEqual, 0)
+			// S002 goes back to LFT, so the train has reversed
+			So(train.TrainHead.PreviousItemID, ShouldNotEqual, head.PreviousItemID)
+			So(stepUntil(sim, 1000, func() bool { return train.Status == simulation.Running }), ShouldBeTrue)
+			// S002 does not leave before its scheduled departure time
+			So(sim.Options.CurrentTime.Time.Format("15:04:05"), ShouldBeGreaterThanOrEqualTo, "06:07:00")
+		})
+		Convey("Trains are linked at a terminus without departure time", func() {
+			sim := loadSim(map[string]interface{}{"autoLinkServices": true}, nil)
+			sim.Services["S001"].Lines[1].ScheduledDepartureTime.Time = time.Time{}
+			train := sim.Trains[0]
+			So(stepUntil(sim, 1000, func() bool { return train.ServiceCode == "S002" }), ShouldBeTrue)
+		})
+		Convey("The planned next service is used first", func() {
+			sim := loadSim(map[string]interface{}{"autoLinkServices": true}, map[string]interface{}{"nextService": "S003"})
+			So(sim.Services["S001"].NextServiceCode, ShouldEqual, "S003")
+			So(sim.Trains[1].Cancel(), ShouldBeNil)
+			train := sim.Trains[0]
+			So(stepUntil(sim, 1000, func() bool { return train.ServiceCode != "S001" }), ShouldBeTrue)
+			So(train.ServiceCode, ShouldEqual, "S003")
+		})
+		Convey("Trains stay at least the minimum turnaround time", func() {
+			sim := loadSim(map[string]interface{}{"autoLinkServices": true, "minTurnaroundSeconds": 900}, nil)
+			train := sim.Trains[0]
+			var arrival time.Time
+			So(stepUntil(sim, 1000, func() bool {
+				if arrival.IsZero() && train.Status == simulation.Stopped {
+					arrival = sim.Options.CurrentTime.Time
+				}
+				return train.ServiceCode == "S002"
+			}), ShouldBeTrue)
+			So(stepUntil(sim, 1000, func() bool { return train.Status == simulation.Running }), ShouldBeTrue)
+			So(sim.Options.CurrentTime.Time.Sub(arrival), ShouldBeGreaterThanOrEqualTo, 15*time.Minute)
+		})
+		setService := map[string]interface{}{"postActions": []interface{}{
+			map[string]interface{}{"actionCode": "SET_SERVICE", "actionParam": "S002"},
+		}}
+		Convey("Without automatic reversal, return workings keep the train direction", func() {
+			sim := loadSim(nil, setService)
+			train := sim.Trains[0]
+			head := train.TrainHead
+			So(stepUntil(sim, 1000, func() bool {
+				if train.ServiceCode == "S001" {
+					head = train.TrainHead
+				}
+				return train.ServiceCode == "S002"
+			}), ShouldBeTrue)
+			So(train.TrainHead.PreviousItemID, ShouldEqual, head.PreviousItemID)
+		})
+		Convey("Trains are reversed for return workings with the driver change time", func() {
+			sim := loadSim(map[string]interface{}{"autoReverseAtTerminus": true, "driverChangeSeconds": 900}, setService)
+			train := sim.Trains[0]
+			head := train.TrainHead
+			var arrival time.Time
+			So(stepUntil(sim, 1000, func() bool {
+				if train.ServiceCode == "S001" {
+					head = train.TrainHead
+				}
+				if arrival.IsZero() && train.Status == simulation.Stopped {
+					arrival = sim.Options.CurrentTime.Time
+				}
+				return train.ServiceCode == "S002"
+			}), ShouldBeTrue)
+			So(train.TrainHead.PreviousItemID, ShouldNotEqual, head.PreviousItemID)
+			So(train.MinimumStopTime(), ShouldEqual, 15*time.Minute)
+			So(stepUntil(sim, 1000, func() bool { return train.Status == simulation.Running }), ShouldBeTrue)
+			So(sim.Options.CurrentTime.Time.Sub(arrival), ShouldBeGreaterThanOrEqualTo, 15*time.Minute)
+		})
+	})
+}
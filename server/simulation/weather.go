@@ -0,0 +1,121 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Weather is the weather condition of the simulation. It degrades the
+// adhesion between wheels and rails, and hence the braking and acceleration
+// of trains, and lengthens dwell times at stations.
+type Weather string
+
+const (
+	// WeatherClear is the nominal condition
+	WeatherClear Weather = "CLEAR"
+	// WeatherRain is wet rails
+	WeatherRain Weather = "RAIN"
+	// WeatherSnow is snow and ice on the rails and platforms
+	WeatherSnow Weather = "SNOW"
+	// WeatherLeafFall is crushed leaves on the rails, the worst case for
+	// adhesion
+	WeatherLeafFall Weather = "LEAF_FALL"
+)
+
+// weatherEffect holds the factors applied to trains for a weather condition
+type weatherEffect struct {
+	// adhesion multiplies the acceleration and braking rates of trains
+	adhesion float64
+	// dwell multiplies the minimum stop time of trains at stations
+	dwell float64
+}
+
+var weatherEffects = map[Weather]weatherEffect{
+	WeatherClear:    {adhesion: 1, dwell: 1},
+	WeatherRain:     {adhesion: 0.8, dwell: 1.1},
+	WeatherSnow:     {adhesion: 0.6, dwell: 1.3},
+	WeatherLeafFall: {adhesion: 0.5, dwell: 1.05},
+}
+
+// Weathers returns all the weather conditions
+func Weathers() []Weather {
+	return []Weather{WeatherClear, WeatherRain, WeatherSnow, WeatherLeafFall}
+}
+
+// Validate returns an error if w is not a known weather condition. An empty
+// weather is the same as WeatherClear.
+func (w Weather) Validate() error {
+	if w == "" {
+		return nil
+	}
+	if _, ok := weatherEffects[w]; !ok {
+		return fmt.Errorf("unknown weather: %s", w)
+	}
+	return nil
+}
+
+// IsDegraded returns true if w reduces the performance of trains
+func (w Weather) IsDegraded() bool {
+	return w != "" && w != WeatherClear
+}
+
+// effect returns the factors to apply to trains for w
+func (w Weather) effect() weatherEffect {
+	if e, ok := weatherEffects[w]; ok {
+		return e
+	}
+	return weatherEffects[WeatherClear]
+}
+
+// Adhesion returns the factor applied to the acceleration and braking rates
+// of trains under w, between 0 and 1.
+func (w Weather) Adhesion() float64 {
+	return w.effect().adhesion
+}
+
+// Acceleration returns the acceleration of this train at its current speed,
+// reduced by the current weather and by the slope and curve under its head.
+func (t *Train) Acceleration() float64 {
+	base := t.TrainType().AccelerationAt(t.Speed) * t.simulation.Options.Weather.Adhesion()
+	return math.Max(base*minTractionShare, base-t.TrainHead.resistance())
+}
+
+// Braking returns the standard braking rate of this train at its current
+// speed, reduced by the current weather and corrected by the slope and curve
+// under its head.
+func (t *Train) Braking() float64 {
+	base := t.TrainType().BrakingAt(t.Speed) * t.simulation.Options.Weather.Adhesion()
+	return math.Max(base*minTractionShare, base+t.TrainHead.resistance())
+}
+
+// EmergencyBraking returns the emergency braking rate of this train, reduced
+// by the current weather and corrected by the slope and curve under its head.
+func (t *Train) EmergencyBraking() float64 {
+	base := t.TrainType().EmergBraking * t.simulation.Options.Weather.Adhesion()
+	return math.Max(base*minTractionShare, base+t.TrainHead.resistance())
+}
+
+// MinimumStopTime returns the minimum time this train stays at its current
+// station, lengthened by the current weather.
+func (t *Train) MinimumStopTime() time.Duration {
+	return time.Duration(float64(t.minStopTime)*t.simulation.Options.Weather.effect().dwell) + t.passengerDwell
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation_test
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestWeather(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	loadSim := func(weather simulation.Weather) *simulation.Simulation {
+		sim, err := loadDemoWith(endChan, nil)
+		So(err, ShouldBeNil)
+		So(sim.Options.Set("weather", string(weather)), ShouldBeNil)
+		return sim
+	}
+	Convey("Testing weather effects", t, func() {
+		Convey("Weather conditions should be validated", func() {
+			So(simulation.WeatherSnow.Validate(), ShouldBeNil)
+			So(simulation.Weather("").Validate(), ShouldBeNil)
+			So(simulation.Weather("HAIL").Validate(), ShouldNotBeNil)
+			So(simulation.Weather("").IsDegraded(), ShouldBeFalse)
+			So(simulation.WeatherRain.IsDegraded(), ShouldBeTrue)
+			So(simulation.WeatherClear.Adhesion(), ShouldEqual, 1)
+			So(simulation.WeatherLeafFall.Adhesion(), ShouldBeLessThan, simulation.WeatherRain.Adhesion())
+			sim := loadSim(simulation.WeatherClear)
+			So(sim.Options.Set("weather", "HAIL"), ShouldNotBeNil)
+		})
+		Convey("Degraded adhesion should reduce braking and lengthen dwell times", func() {
+			sim := loadSim(simulation.WeatherClear)
+			train := sim.Trains[0]
+			braking, accel, dwell := train.Braking(), train.Acceleration(), train.MinimumStopTime()
+			So(braking, ShouldEqual, train.TrainType().StdBraking)
+			So(sim.Options.Set("weather", "SNOW"), ShouldBeNil)
+			So(train.Braking(), ShouldAlmostEqual, braking*simulation.WeatherSnow.Adhesion())
+			So(train.EmergencyBraking(), ShouldBeLessThan, train.TrainType().EmergBraking)
+			So(train.Acceleration(), ShouldBeLessThan, accel)
+			So(train.MinimumStopTime(), ShouldBeGreaterThanOrEqualTo, dwell)
+		})
+		Convey("Trains should run slower under degraded adhesion", func() {
+			run := func(weather simulation.Weather) float64 {
+				sim := loadSim(weather)
+				train := sim.Trains[0]
+				So(stepUntil(sim, 600, train.IsActive), ShouldBeTrue)
+				start := train.TrainHead
+				for i := 0; i < 20; i++ {
+					sim.Step()
+				}
+				d, err := train.TrainHead.Sub(start)
+				So(err, ShouldBeNil)
+				return d
+			}
+			So(run(simulation.WeatherLeafFall), ShouldBeLessThan, run(simulation.WeatherClear))
+		})
+	})
+}